@@ -0,0 +1,199 @@
+// Package adc reads analog to digital converters, for the analog senders
+// on board: tank levels, oil pressure, rudder angle and the like.
+package adc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// TI ADS1115 16 bit ADC with four single ended or two differential inputs
+// and a programmable gain amplifier. Each channel is read as a single shot
+// conversion with its own input, range and data rate, so senders with
+// different needs can share the chip. The inputs must stay within the
+// supply voltage whatever the range.
+
+type ADS1115 struct {
+	bus     *i2c.Bus
+	address int
+
+	mut      sync.Mutex
+	channels []ADS1115Channel
+	voltages []float64
+	cached   time.Time
+}
+
+// An ADS1115Channel is a conversion to do on each refresh.
+type ADS1115Channel struct {
+	Name      string
+	Input     ADS1115Input
+	FullScale float64 // V; one of 6.144, 4.096, 2.048, 1.024, 0.512, 0.256
+	Rate      int     // samples per second; one of 8, 16, 32, 64, 128, 250, 475, 860
+}
+
+// An ADS1115Input is an input multiplexer setting.
+type ADS1115Input uint16
+
+// ADS1115DefaultAddress is the address with the address pin to ground. The
+// pin selects addresses 0x48 to 0x4b.
+const ADS1115DefaultAddress = 0x48
+
+const (
+	ads1115ConversionReg = 0x00
+	ads1115ConfigReg     = 0x01
+
+	ads1115Start      = 1 << 15 // write: start a conversion; read: idle
+	ads1115SingleShot = 1 << 8
+	ads1115NoComp     = 3 // comparator disabled
+
+	ads1115MaxPolls = 10
+)
+
+var ads1115Inputs = map[string]ADS1115Input{
+	"0-1": 0, "0-3": 1, "1-3": 2, "2-3": 3,
+	"0": 4, "1": 5, "2": 6, "3": 7,
+}
+
+var ads1115Ranges = map[float64]uint16{
+	6.144: 0, 4.096: 1, 2.048: 2, 1.024: 3, 0.512: 4, 0.256: 5,
+}
+
+var ads1115Rates = map[int]uint16{
+	8: 0, 16: 1, 32: 2, 64: 3, 128: 4, 250: 5, 475: 6, 860: 7,
+}
+
+// ParseADS1115Input parses an input given as a single ended input number
+// ("0" to "3") or a differential pair ("0-1", "0-3", "1-3" or "2-3").
+func ParseADS1115Input(s string) (ADS1115Input, error) {
+	in, ok := ads1115Inputs[s]
+	if !ok {
+		return 0, fmt.Errorf("invalid input %q (valid: 0, 1, 2, 3, 0-1, 0-3, 1-3, 2-3)", s)
+	}
+	return in, nil
+}
+
+// config returns the configuration register value starting a conversion
+// on the channel.
+func (c ADS1115Channel) config() (uint16, error) {
+	pga, ok := ads1115Ranges[c.FullScale]
+	if !ok {
+		return 0, fmt.Errorf("channel %s: invalid range %v V", c.Name, c.FullScale)
+	}
+	dr, ok := ads1115Rates[c.Rate]
+	if !ok {
+		return 0, fmt.Errorf("channel %s: invalid data rate %v", c.Name, c.Rate)
+	}
+	return ads1115Start | uint16(c.Input)<<12 | pga<<9 | ads1115SingleShot | dr<<5 | ads1115NoComp, nil
+}
+
+// Validate returns an error if the range or data rate of the channel is
+// not supported.
+func (c ADS1115Channel) Validate() error {
+	_, err := c.config()
+	return err
+}
+
+func NewADS1115(bus *i2c.Bus, addr int, channels []ADS1115Channel) (*ADS1115, error) {
+	for _, c := range channels {
+		if err := c.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	s := &ADS1115{
+		bus:      bus,
+		address:  addr,
+		channels: channels,
+		voltages: make([]float64, len(channels)),
+	}
+	// Check that it is there.
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		r.Block(ads1115ConfigReg, 2)
+		return r.Error()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ADS1115) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	for i, c := range s.channels {
+		err := s.bus.Do(s.address, func(dev i2c.Device) error {
+			var err error
+			s.voltages[i], err = convert(i2c.NewReader(dev), c)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("channel %s: %w", c.Name, err)
+		}
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// convert does a single shot conversion on the channel and returns the
+// voltage.
+func convert(r *i2c.Reader, c ADS1115Channel) (float64, error) {
+	cfg, err := c.config()
+	if err != nil {
+		return 0, err
+	}
+	if err := r.WriteBlock(ads1115ConfigReg, []byte{byte(cfg >> 8), byte(cfg)}); err != nil {
+		return 0, fmt.Errorf("start conversion: %w", err)
+	}
+	wait := time.Second/time.Duration(c.Rate) + 100*time.Microsecond
+	for i := 0; ; i++ {
+		time.Sleep(wait)
+		status := r.Block(ads1115ConfigReg, 2)
+		if err := r.Error(); err != nil {
+			return 0, fmt.Errorf("read status: %w", err)
+		}
+		if status[0]&(ads1115Start>>8) != 0 {
+			break
+		}
+		if i == ads1115MaxPolls {
+			return 0, errors.New("conversion timed out")
+		}
+		wait = time.Millisecond
+	}
+	data := r.Block(ads1115ConversionReg, 2)
+	if err := r.Error(); err != nil {
+		return 0, fmt.Errorf("read conversion: %w", err)
+	}
+	raw := int16(uint16(data[0])<<8 | uint16(data[1]))
+	return float64(raw) * c.FullScale / 32768, nil
+}
+
+// Voltages returns the voltages of the channels, in the order given.
+func (s *ADS1115) Voltages() []float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]float64(nil), s.voltages...)
+}
+
+func (s *ADS1115) Info() sensor.Info {
+	return sensor.Info{Chip: "ADS1115", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *ADS1115) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	res := make([]sensor.Reading, len(s.channels))
+	for i, c := range s.channels {
+		res[i] = sensor.Reading{Name: c.Name, Unit: "volts", Quantity: sensor.Voltage, Precision: 3, Value: s.voltages[i]}
+	}
+	return res
+}
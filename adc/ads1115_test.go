@@ -0,0 +1,62 @@
+package adc
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+// ads1115Device converts at once, to a fixed value per input.
+type ads1115Device struct {
+	i2c.Device
+	config uint16
+	values map[ADS1115Input]int16
+}
+
+func (d *ads1115Device) WriteBlockData(reg uint8, data []byte) error {
+	d.config = uint16(data[0])<<8 | uint16(data[1])
+	return nil
+}
+
+func (d *ads1115Device) ReadBlockData(reg uint8, buf []byte) error {
+	v := d.config
+	if reg == ads1115ConversionReg {
+		v = uint16(d.values[ADS1115Input(d.config>>12&7)])
+	}
+	buf[0], buf[1] = byte(v>>8), byte(v)
+	return nil
+}
+
+func TestADS1115Convert(t *testing.T) {
+	dev := &ads1115Device{values: map[ADS1115Input]int16{4: 16384, 3: -8192}}
+	in0, _ := ParseADS1115Input("0")
+	in23, _ := ParseADS1115Input("2-3")
+
+	v, err := convert(i2c.NewReader(dev), ADS1115Channel{Name: "fuel", Input: in0, FullScale: 4.096, Rate: 860})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(v-2.048) > 1e-9 {
+		t.Errorf("unexpected voltage %v", v)
+	}
+	// Single shot, 4.096 V, 860 SPS, comparator off
+	if dev.config != 0xc3e3 {
+		t.Errorf("unexpected configuration %04x", dev.config)
+	}
+
+	v, err = convert(i2c.NewReader(dev), ADS1115Channel{Name: "shunt", Input: in23, FullScale: 0.256, Rate: 860})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(v+0.064) > 1e-9 {
+		t.Errorf("unexpected differential voltage %v", v)
+	}
+
+	if _, err := ParseADS1115Input("1-2"); err == nil {
+		t.Error("expected error for unsupported pair")
+	}
+	if _, err := (ADS1115Channel{FullScale: 5, Rate: 128}).config(); err == nil {
+		t.Error("expected error for invalid range")
+	}
+}
@@ -0,0 +1,113 @@
+package adc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/sensor"
+	"github.com/calmh/boatpi/spi"
+)
+
+// Microchip MCP3008 10 bit ADC with eight single ended or four
+// differential inputs on SPI. The range is zero to the reference voltage,
+// which on most boards is the 3.3 V supply.
+
+type MCP3008 struct {
+	dev       spi.Device
+	path      string
+	reference float64 // V
+
+	mut      sync.Mutex
+	channels []MCP3008Channel
+	voltages []float64
+	cached   time.Time
+}
+
+// An MCP3008Channel is a conversion to do on each refresh.
+type MCP3008Channel struct {
+	Name  string
+	Input MCP3008Input
+}
+
+// An MCP3008Input is the single ended or differential bit and the channel
+// selection, as sent to the chip.
+type MCP3008Input uint8
+
+// MCP3008Speed is a clock speed the MCP3008 handles at 3.3 V.
+const MCP3008Speed = 1000000
+
+// ParseMCP3008Input parses an input given as a single ended input number
+// ("0" to "7") or a differential pair of adjacent inputs, positive first
+// ("0-1", "1-0", "2-3", ... "7-6").
+func ParseMCP3008Input(s string) (MCP3008Input, error) {
+	var pos, neg int
+	if n, _ := fmt.Sscanf(s, "%d-%d", &pos, &neg); n == 2 && len(s) == 3 {
+		if pos/2 == neg/2 && pos != neg && pos >= 0 && pos < 8 {
+			// CH0+/CH1- is 0, CH1+/CH0- is 1 and so on.
+			return MCP3008Input(pos), nil
+		}
+	} else if n == 1 && len(s) == 1 && pos >= 0 && pos < 8 {
+		return MCP3008Input(0x8 | pos), nil
+	}
+	return 0, fmt.Errorf("invalid input %q (valid: 0 to 7, or adjacent pairs such as 0-1 and 1-0)", s)
+}
+
+// NewMCP3008 returns the MCP3008 on the device, with the reference
+// voltage. The path is the device path, for the description.
+func NewMCP3008(dev spi.Device, path string, reference float64, channels []MCP3008Channel) (*MCP3008, error) {
+	if reference <= 0 {
+		return nil, fmt.Errorf("invalid reference voltage %v", reference)
+	}
+	return &MCP3008{
+		dev:       dev,
+		path:      path,
+		reference: reference,
+		channels:  channels,
+		voltages:  make([]float64, len(channels)),
+	}, nil
+}
+
+func (s *MCP3008) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	for i, c := range s.channels {
+		// Start bit, then the input selection in the high nibble; the
+		// ten bit result comes back in the low bits of the last two
+		// bytes.
+		rx, err := spi.Transfer(s.dev, []byte{0x01, byte(c.Input) << 4, 0})
+		if err != nil {
+			return fmt.Errorf("channel %s: %w", c.Name, err)
+		}
+		raw := int(rx[1]&0x03)<<8 | int(rx[2])
+		s.voltages[i] = float64(raw) * s.reference / 1024
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// Voltages returns the voltages of the channels, in the order given.
+func (s *MCP3008) Voltages() []float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]float64(nil), s.voltages...)
+}
+
+func (s *MCP3008) Info() sensor.Info {
+	return sensor.Info{Chip: "MCP3008", Bus: "spi", Address: s.path}
+}
+
+func (s *MCP3008) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	res := make([]sensor.Reading, len(s.channels))
+	for i, c := range s.channels {
+		res[i] = sensor.Reading{Name: c.Name, Unit: "volts", Quantity: sensor.Voltage, Precision: 2, Value: s.voltages[i]}
+	}
+	return res
+}
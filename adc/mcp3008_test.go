@@ -0,0 +1,45 @@
+package adc
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/spi"
+)
+
+func TestMCP3008Refresh(t *testing.T) {
+	in3, err := ParseMCP3008Input("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in45, err := ParseMCP3008Input("5-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"8", "1-2", "0-0", "11"} {
+		if _, err := ParseMCP3008Input(s); err == nil {
+			t.Errorf("expected error for input %q", s)
+		}
+	}
+
+	// Answers with the input selection times 100 as the result.
+	dev := &spi.Mock{Reply: func(tx []byte) []byte {
+		v := int(tx[1]>>4&0x7) * 100
+		return []byte{0, byte(v >> 8), byte(v)}
+	}}
+	s, err := NewMCP3008(dev, "/dev/spidev0.0", 3.3, []MCP3008Channel{{"tank", in3}, {"rudder", in45}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	tr := dev.Transfers()
+	if len(tr) != 2 || tr[0][0] != 1 || tr[0][1] != 0xb0 || tr[1][1] != 0x50 {
+		t.Errorf("unexpected transfers %x", tr)
+	}
+	v := s.Voltages()
+	if math.Abs(v[0]-300*3.3/1024) > 1e-9 || math.Abs(v[1]-500*3.3/1024) > 1e-9 {
+		t.Errorf("unexpected voltages %v", v)
+	}
+}
@@ -0,0 +1,152 @@
+// Package ams reads the ams (now ams OSRAM) magnetic position sensors.
+package ams
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// ams AS5600 magnetic rotary encoder, 12 bits per turn, reading the angle
+// of a diametrically magnetized magnet on the end of a shaft, such as the
+// rudder stock or the quadrant. The raw angle is read and the zero
+// position and direction applied here, rather than programmed into the
+// sensor, whose settings can only be burnt a few times.
+//
+// The angle is from -180 to 180 degrees around the zero position,
+// positive as the raw angle increases, which is counterclockwise seen from
+// the magnet, unless reversed.
+
+type AS5600 struct {
+	bus     *i2c.Bus
+	address int
+	zero    float64 // raw degrees
+	reverse bool
+
+	mut       sync.Mutex
+	cached    time.Time
+	raw       float64 // degrees
+	angle     float64 // degrees
+	magnitude int
+	status    byte
+}
+
+// AS5600DefaultAddress is the only address of the AS5600; the AS5600L has
+// 0x40.
+const AS5600DefaultAddress = 0x36
+
+const (
+	as5600StatusReg    = 0x0b // followed by RAW ANGLE, high byte first
+	as5600MagnitudeReg = 0x1b
+
+	as5600MagnetDetected = 0b_0010_0000 // STATUS MD
+	as5600MagnetWeak     = 0b_0001_0000 // STATUS ML
+	as5600MagnetStrong   = 0b_0000_1000 // STATUS MH
+
+	as5600Counts = 4096 // per turn
+)
+
+// ErrNoMagnet is returned by Refresh when the sensor does not see the
+// magnet.
+var ErrNoMagnet = errors.New("no magnet detected")
+
+// NewAS5600 returns the AS5600 at the address, with the raw angle in
+// degrees at the zero position and the direction.
+func NewAS5600(bus *i2c.Bus, addr int, zero float64, reverse bool) (*AS5600, error) {
+	s := &AS5600{bus: bus, address: addr, zero: zero, reverse: reverse}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		r.Byte(as5600StatusReg)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read status: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *AS5600) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(as5600StatusReg, 3)
+		mag := r.Block(as5600MagnitudeReg, 2)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read angle: %w", err)
+		}
+		s.status = data[0]
+		if s.status&as5600MagnetDetected == 0 {
+			return ErrNoMagnet
+		}
+		raw := int(data[1]&0x0f)<<8 | int(data[2])
+		s.raw = float64(raw) * 360 / as5600Counts
+		s.angle = rudderAngle(s.raw, s.zero, s.reverse)
+		s.magnitude = int(mag[0]&0x0f)<<8 | int(mag[1])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// rudderAngle returns the raw angle relative to the zero position, from
+// -180 to 180 degrees.
+func rudderAngle(raw, zero float64, reverse bool) float64 {
+	a := math.Mod(raw-zero+540, 360) - 180
+	if reverse {
+		a = -a
+	}
+	return a
+}
+
+// Angle returns the angle from the zero position, in degrees.
+func (s *AS5600) Angle() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.angle
+}
+
+// RawAngle returns the angle as read, from 0 to 360 degrees, for finding
+// the zero position.
+func (s *AS5600) RawAngle() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.raw
+}
+
+// Magnitude returns the magnitude of the magnetic field, in counts, and
+// whether it is too weak or too strong for accurate readings. The magnet
+// is best placed so that it is neither.
+func (s *AS5600) Magnitude() (magnitude int, weak, strong bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.magnitude, s.status&as5600MagnetWeak != 0, s.status&as5600MagnetStrong != 0
+}
+
+func (s *AS5600) Info() sensor.Info {
+	return sensor.Info{Chip: "AS5600", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *AS5600) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "rudder_angle", Unit: "degrees", Quantity: sensor.Angle, Precision: 1, Value: s.angle},
+	}
+}
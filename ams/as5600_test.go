@@ -0,0 +1,58 @@
+package ams
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+func TestRudderAngle(t *testing.T) {
+	cases := []struct {
+		raw, zero float64
+		reverse   bool
+		angle     float64
+	}{
+		{90, 90, false, 0},
+		{120, 90, false, 30},
+		{60, 90, false, -30},
+		{60, 90, true, 30},
+		// Across the raw zero.
+		{350, 10, false, -20},
+		{15, 350, false, 25},
+		{15, 350, true, -25},
+	}
+	for _, tc := range cases {
+		if a := rudderAngle(tc.raw, tc.zero, tc.reverse); math.Abs(a-tc.angle) > 1e-9 {
+			t.Errorf("rudderAngle(%v, %v, %v) = %v, expected %v", tc.raw, tc.zero, tc.reverse, a, tc.angle)
+		}
+	}
+}
+
+func TestAS5600(t *testing.T) {
+	dev := i2ctest.Registers{}
+	s, err := NewAS5600(i2c.NewBus(dev), AS5600DefaultAddress, 180, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Refresh(0); err != ErrNoMagnet {
+		t.Errorf("expected no magnet, got %v", err)
+	}
+
+	dev[as5600StatusReg] = as5600MagnetDetected | as5600MagnetWeak
+	dev.Set16(as5600StatusReg+1, 2048-256) // 22.5° below the zero
+	dev.Set16(as5600MagnitudeReg, 300)
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if a := s.Angle(); a != 22.5 {
+		t.Errorf("angle %v, expected 22.5 reversed", a)
+	}
+	if raw := s.RawAngle(); raw != 157.5 {
+		t.Errorf("raw angle %v", raw)
+	}
+	if m, weak, strong := s.Magnitude(); m != 300 || !weak || strong {
+		t.Errorf("magnitude %d, weak %v, strong %v", m, weak, strong)
+	}
+}
@@ -0,0 +1,146 @@
+// Package ble receives Bluetooth Low Energy advertisements, and decodes
+// those of the sensors that broadcast their readings in them, so that they
+// can be read without connecting.
+package ble
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/calmh/boatpi/sensor"
+)
+
+// An Advertisement is one advertising report, with the data structures
+// of interest to decoders picked out.
+type Advertisement struct {
+	Address          string            // e.g. "C4:7C:8D:6A:12:34"
+	RSSI             int               // dBm
+	Name             string            // local name, if advertised
+	ManufacturerData map[uint16][]byte // by company identifier
+	ServiceData      map[uint16][]byte // by 16 bit service UUID
+}
+
+// AD types (Bluetooth Core Specification Supplement, part A).
+const (
+	adShortName        = 0x08
+	adCompleteName     = 0x09
+	adServiceData16    = 0x16
+	adManufacturerData = 0xff
+)
+
+// leAdvertisingReport is the LE meta event subevent code of advertising
+// reports.
+const leAdvertisingReport = 0x02
+
+// parseReports parses the parameters of an LE advertising report event,
+// after the subevent code. Malformed reports end the parsing.
+func parseReports(params []byte) []Advertisement {
+	if len(params) < 1 {
+		return nil
+	}
+	n := int(params[0])
+	params = params[1:]
+	var res []Advertisement
+	for i := 0; i < n; i++ {
+		// Event type, address type, address, data length, data, RSSI.
+		if len(params) < 9 {
+			break
+		}
+		l := int(params[8])
+		if len(params) < 9+l+1 {
+			break
+		}
+		adv := Advertisement{
+			Address: formatAddress(params[2:8]),
+			RSSI:    int(int8(params[9+l])),
+		}
+		adv.parseData(params[9 : 9+l])
+		res = append(res, adv)
+		params = params[9+l+1:]
+	}
+	return res
+}
+
+// parseData picks the structures of interest from advertising data, a
+// sequence of length, type and data.
+func (a *Advertisement) parseData(data []byte) {
+	for len(data) > 0 {
+		l := int(data[0])
+		if l == 0 || len(data) < 1+l {
+			return
+		}
+		typ, val := data[1], data[2:1+l]
+		data = data[1+l:]
+
+		switch typ {
+		case adShortName, adCompleteName:
+			a.Name = string(val)
+		case adServiceData16:
+			if len(val) >= 2 {
+				if a.ServiceData == nil {
+					a.ServiceData = make(map[uint16][]byte)
+				}
+				a.ServiceData[binary.LittleEndian.Uint16(val)] = val[2:]
+			}
+		case adManufacturerData:
+			if len(val) >= 2 {
+				if a.ManufacturerData == nil {
+					a.ManufacturerData = make(map[uint16][]byte)
+				}
+				a.ManufacturerData[binary.LittleEndian.Uint16(val)] = val[2:]
+			}
+		}
+	}
+}
+
+// formatAddress formats a device address, which is sent least significant
+// byte first.
+func formatAddress(b []byte) string {
+	parts := make([]string, len(b))
+	for i := range b {
+		parts[len(b)-1-i] = fmt.Sprintf("%02X", b[i])
+	}
+	return strings.Join(parts, ":")
+}
+
+// ValidAddress returns true if the address is six colon separated hex
+// bytes, such as "C4:7C:8D:6A:12:34", in either case.
+func ValidAddress(addr string) bool {
+	parts := strings.Split(addr, ":")
+	if len(parts) != 6 {
+		return false
+	}
+	for _, p := range parts {
+		if len(p) != 2 || strings.Trim(p, "0123456789abcdefABCDEF") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// A Tag is what a decoder makes of an advertisement: the kind of device,
+// such as "ruuvi", and its readings. Devices may send only some of their
+// readings in each advertisement. Err is why there are none, if the
+// advertisement could not be read, such as for a missing key.
+type Tag struct {
+	Kind     string
+	Readings []sensor.Reading
+	Err      error
+}
+
+// decoders are tried in turn on each advertisement; the first one that
+// recognizes it wins.
+var decoders []func(adv Advertisement, key []byte) (Tag, bool)
+
+// Decode returns the readings in the advertisement, if it is from a kind
+// of sensor known to a decoder. The key is for devices that encrypt their
+// advertisements, and may be nil.
+func Decode(adv Advertisement, key []byte) (Tag, bool) {
+	for _, dec := range decoders {
+		if tag, ok := dec(adv, key); ok {
+			return tag, true
+		}
+	}
+	return Tag{}, false
+}
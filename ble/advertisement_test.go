@@ -0,0 +1,65 @@
+package ble
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseReports(t *testing.T) {
+	data := []byte{
+		0x02, 0x01, 0x06, // flags
+		0x05, 0xff, 0x99, 0x04, 0x05, 0x12, // manufacturer 0x0499
+		0x05, 0x16, 0x1a, 0x18, 0xab, 0xcd, // service data 0x181a
+		0x04, 0x09, 'T', 'a', 'g', // name
+		0x03, 0x16, 0x1b, // short service data, ignored
+	}
+	params := []byte{2}
+	for _, addr := range [][]byte{
+		{0x34, 0x12, 0x6a, 0x8d, 0x7c, 0xc4},
+		{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+	} {
+		params = append(params, 0x00, 0x01)
+		params = append(params, addr...)
+		params = append(params, byte(len(data)))
+		params = append(params, data...)
+		params = append(params, 0xba) // -70 dBm
+	}
+
+	advs := parseReports(params)
+	if len(advs) != 2 {
+		t.Fatalf("expected two reports, got %d", len(advs))
+	}
+	a := advs[0]
+	if a.Address != "C4:7C:8D:6A:12:34" || advs[1].Address != "06:05:04:03:02:01" {
+		t.Errorf("unexpected addresses %q, %q", a.Address, advs[1].Address)
+	}
+	if a.RSSI != -70 || a.Name != "Tag" {
+		t.Errorf("unexpected RSSI %d or name %q", a.RSSI, a.Name)
+	}
+	if !bytes.Equal(a.ManufacturerData[0x0499], []byte{0x05, 0x12}) {
+		t.Errorf("unexpected manufacturer data % x", a.ManufacturerData[0x0499])
+	}
+	if !bytes.Equal(a.ServiceData[0x181a], []byte{0xab, 0xcd}) {
+		t.Errorf("unexpected service data % x", a.ServiceData[0x181a])
+	}
+
+	// A truncated report is dropped.
+	if advs := parseReports(params[:20]); len(advs) != 0 {
+		t.Errorf("expected truncated report dropped, got %+v", advs)
+	}
+}
+
+func TestValidAddress(t *testing.T) {
+	for addr, valid := range map[string]bool{
+		"C4:7C:8D:6A:12:34":  true,
+		"c4:7c:8d:6a:12:34":  true,
+		"C4:7C:8D:6A:12":     false,
+		"C4-7C-8D-6A-12-34":  false,
+		"C4:7C:8D:6A:12:3G":  false,
+		"C4:7C:8D:6A:12:345": false,
+	} {
+		if ValidAddress(addr) != valid {
+			t.Errorf("%q: expected valid %v", addr, valid)
+		}
+	}
+}
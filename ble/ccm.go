@@ -0,0 +1,102 @@
+package ble
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// AES-CCM (RFC 3610), which the standard library lacks, for opening
+// encrypted advertisements.
+
+var errAuthentication = errors.New("message authentication failed")
+
+// ccmOpen decrypts and authenticates the ciphertext with the tag, whose
+// length is that of the MAC: 4, 6, ..., 16 bytes. The nonce is 7 to 13
+// bytes; the rest of the 16 byte counter block holds the length.
+func ccmOpen(block cipher.Block, nonce, ciphertext, tag, aad []byte) ([]byte, error) {
+	l := 15 - len(nonce)
+	if l < 2 || l > 8 || len(tag) < 4 || len(tag) > 16 || len(tag)%2 != 0 {
+		return nil, errors.New("invalid CCM parameters")
+	}
+	plain := make([]byte, len(ciphertext))
+	ccmCTR(block, nonce, plain, ciphertext)
+	if subtle.ConstantTimeCompare(ccmTag(block, nonce, plain, aad, len(tag)), tag) != 1 {
+		return nil, errAuthentication
+	}
+	return plain, nil
+}
+
+// ccmCounter returns counter block i: flags, nonce and counter. Block 0
+// encrypts the tag, the following ones the message.
+func ccmCounter(nonce []byte, i int) []byte {
+	b := make([]byte, 16)
+	b[0] = byte(14 - len(nonce))
+	copy(b[1:], nonce)
+	for j := 15; i > 0; j-- {
+		b[j] = byte(i)
+		i >>= 8
+	}
+	return b
+}
+
+// ccmCTR encrypts or decrypts src into dst.
+func ccmCTR(block cipher.Block, nonce, dst, src []byte) {
+	s := make([]byte, 16)
+	for i := 0; i*16 < len(src); i++ {
+		block.Encrypt(s, ccmCounter(nonce, i+1))
+		end := i*16 + 16
+		if end > len(src) {
+			end = len(src)
+		}
+		xorBytes(dst[i*16:end], src[i*16:end], s)
+	}
+}
+
+// ccmTag returns the encrypted CBC-MAC of the message, m bytes long. It
+// is computed over the first block (flags, nonce, message length), the
+// length prefixed additional data and the message, each zero padded to
+// whole blocks.
+func ccmTag(block cipher.Block, nonce, plain, aad []byte, m int) []byte {
+	b0 := make([]byte, 16)
+	b0[0] = byte((m-2)/2<<3 | (14 - len(nonce)))
+	if len(aad) > 0 {
+		b0[0] |= 1 << 6
+	}
+	copy(b0[1:], nonce)
+	for j, n := 15, len(plain); n > 0; j-- {
+		b0[j] = byte(n)
+		n >>= 8
+	}
+	mac := make([]byte, 16)
+	s := make([]byte, 16)
+	feed := func(data []byte) {
+		for len(data) > 0 {
+			n := copy(s, data)
+			for i := n; i < 16; i++ {
+				s[i] = 0
+			}
+			xorBytes(mac, mac, s)
+			block.Encrypt(mac, mac)
+			data = data[n:]
+		}
+	}
+	feed(b0)
+	if len(aad) > 0 {
+		// Lengths from 0xff00 need a longer encoding; advertisements
+		// are far shorter.
+		feed(append([]byte{byte(len(aad) >> 8), byte(len(aad))}, aad...))
+	}
+	feed(plain)
+
+	block.Encrypt(s, ccmCounter(nonce, 0))
+	xorBytes(mac, mac, s)
+	return mac[:m]
+}
+
+// xorBytes sets dst to the XOR of a and b, as far as a goes.
+func xorBytes(dst, a, b []byte) {
+	for i := range a {
+		dst[i] = a[i] ^ b[i]
+	}
+}
@@ -0,0 +1,48 @@
+package ble
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestCCMOpen(t *testing.T) {
+	cases := []struct {
+		key, nonce, aad, plain, cipher, tag string
+	}{
+		// NIST SP 800-38C, example 1.
+		{"404142434445464748494a4b4c4d4e4f", "10111213141516", "0001020304050607", "20212223", "7162015b", "4dac255d"},
+		// RFC 3610, packet vector 1.
+		{"c0c1c2c3c4c5c6c7c8c9cacbcccdcecf", "00000003020100a0a1a2a3a4a5", "0001020304050607",
+			"08090a0b0c0d0e0f101112131415161718191a1b1c1d1e",
+			"588c979a61c663d2f066d0c2c0f989806d5f6b61dac384", "17e8d12cfdf926e0"},
+	}
+	for _, tc := range cases {
+		h := func(s string) []byte {
+			b, err := hex.DecodeString(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}
+		block, err := aes.NewCipher(h(tc.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := ccmOpen(block, h(tc.nonce), h(tc.cipher), h(tc.tag), h(tc.aad))
+		if err != nil {
+			t.Errorf("%s: %v", tc.cipher, err)
+			continue
+		}
+		if !bytes.Equal(plain, h(tc.plain)) {
+			t.Errorf("%s: decrypted to %x", tc.cipher, plain)
+		}
+
+		tag := h(tc.tag)
+		tag[0] ^= 1
+		if _, err := ccmOpen(block, h(tc.nonce), h(tc.cipher), tag, h(tc.aad)); err != errAuthentication {
+			t.Errorf("%s: expected authentication failure, got %v", tc.cipher, err)
+		}
+	}
+}
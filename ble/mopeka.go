@@ -0,0 +1,48 @@
+package ble
+
+import (
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Mopeka Pro Check tank sensors, stuck to the bottom of a gas bottle,
+// measure the height of the liquid propane by the time of flight of an
+// ultrasonic pulse, whose speed depends on the temperature. The reading
+// quality is 0 to 3; at 0 there was no echo, and the level is left out.
+
+const (
+	mopekaCompanyID = 0x0059 // Nordic Semiconductor
+
+	mopekaProCheck = 0x03
+	mopekaProPlus  = 0x08
+)
+
+// mopekaPropane are the coefficients of the propane time of flight to
+// level conversion, a second degree polynomial in the raw temperature.
+var mopekaPropane = [3]float64{0.573045, -0.002822, -0.00000535}
+
+func init() {
+	decoders = append(decoders, decodeMopeka)
+}
+
+func decodeMopeka(adv Advertisement, _ []byte) (Tag, bool) {
+	// Hardware ID, battery, temperature, level and quality, the end of
+	// the device address, and two bytes of acceleration.
+	d, ok := adv.ManufacturerData[mopekaCompanyID]
+	if !ok || len(d) != 10 || (d[0] != mopekaProCheck && d[0] != mopekaProPlus) {
+		return Tag{}, false
+	}
+	temp := float64(d[2] & 0x7f) // in degrees above -40 °C
+	raw := (uint16(d[4])<<8 | uint16(d[3])) & 0x3fff
+	quality := d[4] >> 6
+
+	rs := []sensor.Reading{
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 0, Value: temp - 40},
+		{Name: "battery", Unit: "volts", Quantity: sensor.Voltage, Precision: 2, Value: float64(d[1]&0x7f) / 32},
+		{Name: "quality", Quantity: sensor.Raw, Precision: 0, Value: float64(quality)},
+	}
+	if quality > 0 {
+		mm := float64(raw) * (mopekaPropane[0] + mopekaPropane[1]*temp + mopekaPropane[2]*temp*temp)
+		rs = append(rs, sensor.Reading{Name: "level", Unit: "metres", Quantity: sensor.Length, Precision: 3, Value: mm / 1000})
+	}
+	return Tag{Kind: "mopeka", Readings: rs}, true
+}
@@ -0,0 +1,43 @@
+package ble
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodeMopeka(t *testing.T) {
+	// 2.875 V, 20 °C, 400 µs at quality 3.
+	data := []byte{mopekaProCheck, 0x5c, 0x3c, 0x90, 0xc1, 0x6a, 0x12, 0x34, 0x00, 0x00}
+	tag, ok := Decode(Advertisement{ManufacturerData: map[uint16][]byte{mopekaCompanyID: data}}, nil)
+	if !ok || tag.Kind != "mopeka" {
+		t.Fatalf("not decoded: %+v", tag)
+	}
+	want := map[string]float64{
+		"temperature": 20,
+		"battery":     2.875,
+		"quality":     3,
+		"level":       0.153786,
+	}
+	got := readingValues(tag)
+	if len(got) != len(want) {
+		t.Errorf("unexpected readings %v", got)
+	}
+	for name, w := range want {
+		if math.Abs(got[name]-w) > 1e-6 {
+			t.Errorf("%s: %v, expected %v", name, got[name], w)
+		}
+	}
+
+	// No echo, no level.
+	data[4] &^= 0xc0
+	tag, _ = Decode(Advertisement{ManufacturerData: map[uint16][]byte{mopekaCompanyID: data}}, nil)
+	if _, ok := readingValues(tag)["level"]; ok {
+		t.Error("expected no level at quality 0")
+	}
+
+	// Another Nordic based device.
+	data[0] = 0x42
+	if _, ok := Decode(Advertisement{ManufacturerData: map[uint16][]byte{mopekaCompanyID: data}}, nil); ok {
+		t.Error("expected unknown hardware ID to be ignored")
+	}
+}
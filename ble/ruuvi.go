@@ -0,0 +1,54 @@
+package ble
+
+import (
+	"encoding/binary"
+
+	"github.com/calmh/boatpi/sensor"
+)
+
+// RuuviTag environmental sensors, in the RAWv2 format (data format 5) of
+// the current firmware: temperature, humidity, pressure, acceleration,
+// battery voltage and a counter of movements detected by the
+// accelerometer, which wraps at 255. Fields the tag does not have are
+// sent as invalid values and left out.
+
+const (
+	ruuviCompanyID = 0x0499
+	ruuviFormat5   = 5
+)
+
+func init() {
+	decoders = append(decoders, decodeRuuvi)
+}
+
+func decodeRuuvi(adv Advertisement, _ []byte) (Tag, bool) {
+	d, ok := adv.ManufacturerData[ruuviCompanyID]
+	if !ok || len(d) < 18 || d[0] != ruuviFormat5 {
+		return Tag{}, false
+	}
+	be := binary.BigEndian
+	var rs []sensor.Reading
+	if v := int16(be.Uint16(d[1:])); v != -0x8000 {
+		rs = append(rs, sensor.Reading{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 2, Value: float64(v) * 0.005})
+	}
+	if v := be.Uint16(d[3:]); v != 0xffff {
+		rs = append(rs, sensor.Reading{Name: "humidity", Unit: "percent", Quantity: sensor.Humidity, Precision: 1, Value: float64(v) * 0.0025})
+	}
+	if v := be.Uint16(d[5:]); v != 0xffff {
+		rs = append(rs, sensor.Reading{Name: "pressure", Unit: "mb", Quantity: sensor.Pressure, Precision: 2, Value: (float64(v) + 50000) / 100})
+	}
+	for i, axis := range []string{"x", "y", "z"} {
+		if v := int16(be.Uint16(d[7+2*i:])); v != -0x8000 {
+			rs = append(rs, sensor.Reading{Name: "acceleration_" + axis, Unit: "g", Quantity: sensor.Acceleration, Precision: 3, Value: float64(v) / 1000})
+		}
+	}
+	// Eleven bits of battery voltage above 1.6 V, in mV, and five of
+	// transmit power.
+	if v := be.Uint16(d[13:]) >> 5; v != 0x7ff {
+		rs = append(rs, sensor.Reading{Name: "battery", Unit: "volts", Quantity: sensor.Voltage, Precision: 3, Value: (float64(v) + 1600) / 1000})
+	}
+	if v := d[15]; v != 0xff {
+		rs = append(rs, sensor.Reading{Name: "movement_counter", Quantity: sensor.Raw, Value: float64(v)})
+	}
+	return Tag{Kind: "ruuvi", Readings: rs}, true
+}
@@ -0,0 +1,45 @@
+package ble
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+func TestDecodeRuuvi(t *testing.T) {
+	// The valid and invalid test vectors from the format 5 specification.
+	valid, _ := hex.DecodeString("0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F")
+	tag, ok := Decode(Advertisement{ManufacturerData: map[uint16][]byte{ruuviCompanyID: valid}}, nil)
+	if !ok || tag.Kind != "ruuvi" {
+		t.Fatalf("not decoded: %+v", tag)
+	}
+	want := map[string]float64{
+		"temperature":      24.3,
+		"humidity":         53.49,
+		"pressure":         1000.44,
+		"acceleration_x":   0.004,
+		"acceleration_y":   -0.004,
+		"acceleration_z":   1.036,
+		"battery":          2.977,
+		"movement_counter": 66,
+	}
+	if len(tag.Readings) != len(want) {
+		t.Errorf("expected %d readings, got %+v", len(want), tag.Readings)
+	}
+	for _, r := range tag.Readings {
+		if math.Abs(r.Value-want[r.Name]) > 1e-9 {
+			t.Errorf("%s: %v, expected %v", r.Name, r.Value, want[r.Name])
+		}
+	}
+
+	invalid, _ := hex.DecodeString("058000FFFFFFFF800080008000FFFFFFFFFFFFFFFFFFFFFF")
+	tag, ok = Decode(Advertisement{ManufacturerData: map[uint16][]byte{ruuviCompanyID: invalid}}, nil)
+	if !ok || len(tag.Readings) != 0 {
+		t.Errorf("expected no readings from invalid values, got %+v", tag.Readings)
+	}
+
+	// Format 3, no longer sent by current firmware.
+	if _, ok := Decode(Advertisement{ManufacturerData: map[uint16][]byte{ruuviCompanyID: {3, 0x29, 0x1a, 0x1e, 0xce, 0x1e, 0xfc, 0x18, 0xf9, 0x42, 0x02, 0xca, 0x0b, 0x53}}}, nil); ok {
+		t.Error("expected format 3 to be ignored")
+	}
+}
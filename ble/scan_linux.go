@@ -0,0 +1,138 @@
+package ble
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Scanning is done on a raw HCI socket, which needs CAP_NET_RAW and
+// CAP_NET_ADMIN but no Bluetooth daemon. BlueZ may run alongside; its own
+// scans then share the reports.
+
+const (
+	afBluetooth   = 31
+	btprotoHCI    = 1
+	solHCI        = 0
+	hciFilter     = 2
+	hciChannelRaw = 0
+	ioctlHCIDevUp = 0x400448c9 // HCIDEVUP
+
+	hciCommandPkt = 0x01
+	hciEventPkt   = 0x04
+
+	evtCmdComplete = 0x0e
+	evtCmdStatus   = 0x0f
+	evtLEMeta      = 0x3e
+
+	ogfLE                  = 0x08
+	ocfLESetScanParameters = 0x000b
+	ocfLESetScanEnable     = 0x000c
+)
+
+// sockaddrHCI is struct sockaddr_hci.
+type sockaddrHCI struct {
+	family  uint16
+	dev     uint16
+	channel uint16
+}
+
+// hciFilterT is struct hci_filter.
+type hciFilterT struct {
+	typeMask  uint32
+	eventMask [2]uint32
+	opcode    uint16
+	_         uint16
+}
+
+// Scan passively scans for advertisements on the adapter (0 for hci0)
+// until the context is done. Reports are dropped if the receiver does not
+// keep up.
+func Scan(ctx context.Context, adapter int) (<-chan Advertisement, error) {
+	fd, err := syscall.Socket(afBluetooth, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, btprotoHCI)
+	if err != nil {
+		return nil, fmt.Errorf("HCI socket: %w", err)
+	}
+	if err := setupHCI(fd, adapter); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("hci%d: %w", adapter, err)
+	}
+	// Non blocking, so that the read is handled by the runtime poller and
+	// interrupted by closing the file.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("HCI socket: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("hci%d", adapter))
+
+	advs := make(chan Advertisement, 64)
+	go func() {
+		<-ctx.Done()
+		f.Write(leCommand(ocfLESetScanEnable, 0, 0))
+		f.Close()
+	}()
+	go func() {
+		defer close(advs)
+		buf := make([]byte, 260)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			// Event packet, event code, length, parameters.
+			pkt := buf[:n]
+			if len(pkt) < 4 || pkt[0] != hciEventPkt || pkt[1] != evtLEMeta || pkt[3] != leAdvertisingReport {
+				continue
+			}
+			for _, adv := range parseReports(pkt[4:]) {
+				select {
+				case advs <- adv:
+				default:
+				}
+			}
+		}
+	}()
+	return advs, nil
+}
+
+// setupHCI brings the adapter up, binds the socket to it, and starts a
+// passive scan that reports every advertisement, duplicates included, as
+// the readings change from one to the next.
+func setupHCI(fd, adapter int) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlHCIDevUp, uintptr(adapter)); errno != 0 && errno != syscall.EALREADY {
+		return fmt.Errorf("bring up: %w", errno)
+	}
+	sa := sockaddrHCI{family: afBluetooth, dev: uint16(adapter), channel: hciChannelRaw}
+	if _, _, errno := syscall.Syscall(syscall.SYS_BIND, uintptr(fd), uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa)); errno != 0 {
+		return fmt.Errorf("bind: %w", errno)
+	}
+	filter := hciFilterT{typeMask: 1 << hciEventPkt}
+	for _, ev := range []uint{evtCmdComplete, evtCmdStatus, evtLEMeta} {
+		filter.eventMask[ev/32] |= 1 << (ev % 32)
+	}
+	bs := (*[unsafe.Sizeof(filter)]byte)(unsafe.Pointer(&filter))
+	if err := syscall.SetsockoptString(fd, solHCI, hciFilter, string(bs[:])); err != nil {
+		return fmt.Errorf("set filter: %w", err)
+	}
+
+	// Scanning must be off to change the parameters. The interval and
+	// window are in units of 0.625 ms; equal, the scan is continuous.
+	for _, cmd := range [][]byte{
+		leCommand(ocfLESetScanEnable, 0, 0),
+		leCommand(ocfLESetScanParameters, 0x00, 0x60, 0x00, 0x60, 0x00, 0x00, 0x00),
+		leCommand(ocfLESetScanEnable, 1, 0),
+	} {
+		if _, err := syscall.Write(fd, cmd); err != nil {
+			return fmt.Errorf("start scan: %w", err)
+		}
+	}
+	return nil
+}
+
+// leCommand returns an HCI command packet for the LE controller command.
+func leCommand(ocf uint16, params ...byte) []byte {
+	op := ogfLE<<10 | ocf
+	return append([]byte{hciCommandPkt, byte(op), byte(op >> 8), byte(len(params))}, params...)
+}
@@ -0,0 +1,19 @@
+package ble
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+)
+
+func TestHCIStructs(t *testing.T) {
+	if s := unsafe.Sizeof(sockaddrHCI{}); s != 6 {
+		t.Errorf("sockaddr_hci is %d bytes, expected 6", s)
+	}
+	if s := unsafe.Sizeof(hciFilterT{}); s != 16 {
+		t.Errorf("hci_filter is %d bytes, expected 16", s)
+	}
+	if cmd := leCommand(ocfLESetScanEnable, 1, 0); !bytes.Equal(cmd, []byte{0x01, 0x0c, 0x20, 0x02, 0x01, 0x00}) {
+		t.Errorf("unexpected command % x", cmd)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package ble
+
+import (
+	"context"
+	"errors"
+)
+
+func Scan(ctx context.Context, adapter int) (<-chan Advertisement, error) {
+	return nil, errors.New("Bluetooth scanning is only supported on Linux")
+}
@@ -0,0 +1,197 @@
+package ble
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Xiaomi Mijia LYWSD03MMC thermometers and their kin. The stock firmware
+// sends MiBeacon service data, encrypted from version 4 with AES-CCM and a
+// per device bind key, obtained when pairing the device with the Mi Home
+// app or from the Xiaomi cloud. Each advertisement carries one object:
+// the temperature, the humidity, both, or the battery level.
+//
+// The custom ATC firmware instead sends all readings in the clear as
+// Environmental Sensing service data, in the original ATC layout or the
+// longer, more precise one of the pvvx fork.
+
+const (
+	serviceEnvironmentalSensing = 0x181a
+	serviceMiBeacon             = 0xfe95
+
+	miEncrypted  = 1 << 3 // frame control
+	miMAC        = 1 << 4
+	miCapability = 1 << 5
+	miObject     = 1 << 6
+
+	miTemperature         = 0x1004
+	miHumidity            = 0x1006
+	miBattery             = 0x100a
+	miTemperatureHumidity = 0x100d
+)
+
+var errNoKey = errors.New("encrypted; needs the bind key")
+
+func init() {
+	decoders = append(decoders, decodeXiaomi)
+}
+
+// ValidKey returns true if the key is a 16 byte bind key in hex, as used
+// by MiBeacon version 4 and later.
+func ValidKey(key string) bool {
+	b, err := hex.DecodeString(key)
+	return err == nil && len(b) == 16
+}
+
+func decodeXiaomi(adv Advertisement, key []byte) (Tag, bool) {
+	if d, ok := adv.ServiceData[serviceEnvironmentalSensing]; ok {
+		return decodeATC(d)
+	}
+	if d, ok := adv.ServiceData[serviceMiBeacon]; ok {
+		return decodeMiBeacon(adv, d, key)
+	}
+	return Tag{}, false
+}
+
+func decodeATC(d []byte) (Tag, bool) {
+	// Both layouts start with the device address.
+	var temp, hum, mv, pct float64
+	switch len(d) {
+	case 13:
+		be := binary.BigEndian
+		temp = float64(int16(be.Uint16(d[6:]))) / 10
+		hum = float64(d[8])
+		pct = float64(d[9])
+		mv = float64(be.Uint16(d[10:]))
+	case 15:
+		le := binary.LittleEndian
+		temp = float64(int16(le.Uint16(d[6:]))) / 100
+		hum = float64(le.Uint16(d[8:])) / 100
+		mv = float64(le.Uint16(d[10:]))
+		pct = float64(d[12])
+	default:
+		return Tag{}, false
+	}
+	return Tag{Kind: "xiaomi", Readings: []sensor.Reading{
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: temp},
+		{Name: "humidity", Unit: "percent", Quantity: sensor.Humidity, Precision: 0, Value: hum},
+		{Name: "battery", Unit: "volts", Quantity: sensor.Voltage, Precision: 3, Value: mv / 1000},
+		{Name: "battery_level", Unit: "percent", Quantity: sensor.Raw, Precision: 0, Value: pct},
+	}}, true
+}
+
+func decodeMiBeacon(adv Advertisement, d, key []byte) (Tag, bool) {
+	// Frame control, product ID, frame counter, then the optional
+	// device address, capabilities and object.
+	if len(d) < 5 {
+		return Tag{}, false
+	}
+	frctrl := binary.LittleEndian.Uint16(d)
+	version := frctrl >> 12
+	tag := Tag{Kind: "xiaomi"}
+	if frctrl&miObject == 0 {
+		return tag, true
+	}
+
+	i := 5
+	var mac []byte // as sent, least significant byte first
+	if frctrl&miMAC != 0 {
+		if len(d) < i+6 {
+			return Tag{}, false
+		}
+		mac = d[i : i+6]
+		i += 6
+	} else {
+		mac = addressBytes(adv.Address)
+	}
+	if frctrl&miCapability != 0 {
+		if len(d) < i+1 {
+			return Tag{}, false
+		}
+		if version >= 5 && d[i]&0x20 != 0 {
+			i += 2 // I/O capability
+		}
+		i++
+	}
+	if len(d) < i {
+		return Tag{}, false
+	}
+	payload := d[i:]
+
+	if frctrl&miEncrypted != 0 {
+		if version < 4 {
+			tag.Err = fmt.Errorf("encrypted MiBeacon version %d not supported", version)
+			return tag, true
+		}
+		if key == nil {
+			tag.Err = errNoKey
+			return tag, true
+		}
+		// Object, three bytes of extended frame counter, and the four
+		// byte tag. The nonce is the address, product ID and both
+		// frame counters.
+		n := len(payload)
+		if n < 3+3+4 {
+			return Tag{}, false
+		}
+		nonce := make([]byte, 0, 12)
+		nonce = append(nonce, mac...)
+		nonce = append(nonce, d[2:5]...)
+		nonce = append(nonce, payload[n-7:n-4]...)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			tag.Err = err
+			return tag, true
+		}
+		payload, err = ccmOpen(block, nonce, payload[:n-7], payload[n-4:], []byte{0x11})
+		if err != nil {
+			tag.Err = fmt.Errorf("decrypt (wrong bind key?): %w", err)
+			return tag, true
+		}
+	}
+
+	// Objects are type, length and value.
+	le := binary.LittleEndian
+	for len(payload) >= 3 {
+		typ, l := le.Uint16(payload), int(payload[2])
+		if len(payload) < 3+l {
+			break
+		}
+		v := payload[3 : 3+l]
+		payload = payload[3+l:]
+
+		temp := func(b []byte) sensor.Reading {
+			return sensor.Reading{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: float64(int16(le.Uint16(b))) / 10}
+		}
+		hum := func(b []byte) sensor.Reading {
+			return sensor.Reading{Name: "humidity", Unit: "percent", Quantity: sensor.Humidity, Precision: 0, Value: float64(le.Uint16(b)) / 10}
+		}
+		switch {
+		case typ == miTemperature && l >= 2:
+			tag.Readings = append(tag.Readings, temp(v))
+		case typ == miHumidity && l >= 2:
+			tag.Readings = append(tag.Readings, hum(v))
+		case typ == miTemperatureHumidity && l >= 4:
+			tag.Readings = append(tag.Readings, temp(v), hum(v[2:]))
+		case typ == miBattery && l >= 1:
+			tag.Readings = append(tag.Readings, sensor.Reading{Name: "battery_level", Unit: "percent", Quantity: sensor.Raw, Precision: 0, Value: float64(v[0])})
+		}
+	}
+	return tag, true
+}
+
+// addressBytes returns the address as sent, least significant byte
+// first.
+func addressBytes(addr string) []byte {
+	b, _ := hex.DecodeString(strings.ReplaceAll(addr, ":", ""))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
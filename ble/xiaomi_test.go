@@ -0,0 +1,90 @@
+package ble
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"math"
+	"testing"
+)
+
+func readingValues(tag Tag) map[string]float64 {
+	res := make(map[string]float64)
+	for _, r := range tag.Readings {
+		res[r.Name] = r.Value
+	}
+	return res
+}
+
+func TestDecodeATC(t *testing.T) {
+	cases := []struct {
+		data string
+		want map[string]float64
+	}{
+		// ATC: address, 22.5 °C, 48 %, 87 %, 2.953 V, counter.
+		{"a4c1386a1234" + "00e1" + "30" + "57" + "0b89" + "2a",
+			map[string]float64{"temperature": 22.5, "humidity": 48, "battery_level": 87, "battery": 2.953}},
+		// pvvx: address reversed, -3.21 °C, 55.55 %, 2.953 V, 87 %,
+		// counter, flags.
+		{"34126a38c1a4" + "bffe" + "b315" + "890b" + "57" + "2a" + "04",
+			map[string]float64{"temperature": -3.21, "humidity": 55.55, "battery_level": 87, "battery": 2.953}},
+	}
+	for _, tc := range cases {
+		data, _ := hex.DecodeString(tc.data)
+		tag, ok := Decode(Advertisement{ServiceData: map[uint16][]byte{serviceEnvironmentalSensing: data}}, nil)
+		if !ok || tag.Kind != "xiaomi" {
+			t.Errorf("%s: not decoded", tc.data)
+			continue
+		}
+		got := readingValues(tag)
+		for name, want := range tc.want {
+			if v, ok := got[name]; !ok || math.Abs(v-want) > 1e-9 {
+				t.Errorf("%s: %s %v, expected %v", tc.data, name, v, want)
+			}
+		}
+	}
+}
+
+func TestDecodeMiBeacon(t *testing.T) {
+	key, _ := hex.DecodeString("e9ea895fac7cca6d30532432a516f3a8")
+	mac := []byte{0x34, 0x12, 0x6a, 0x38, 0xc1, 0xa4} // A4:C1:38:6A:12:34
+	header := append([]byte{0x58, 0x58, 0x5b, 0x05, 0x42}, mac...)
+	ext := []byte{0x01, 0x00, 0x00}
+
+	// The temperature and humidity object, sealed as the thermometer
+	// does.
+	object := []byte{0x0d, 0x10, 0x04, 0xe1, 0x00, 0xe0, 0x01}
+	block, _ := aes.NewCipher(key)
+	nonce := append(append(append([]byte{}, mac...), header[2:5]...), ext...)
+	sealed := make([]byte, len(object))
+	ccmCTR(block, nonce, sealed, object)
+	sealed = append(sealed, ext...)
+	sealed = append(sealed, ccmTag(block, nonce, object, []byte{0x11}, 4)...)
+	adv := Advertisement{
+		Address:     "A4:C1:38:6A:12:34",
+		ServiceData: map[uint16][]byte{serviceMiBeacon: append(header, sealed...)},
+	}
+
+	tag, ok := Decode(adv, key)
+	if !ok || tag.Err != nil {
+		t.Fatalf("not decoded: %+v", tag)
+	}
+	if got := readingValues(tag); got["temperature"] != 22.5 || got["humidity"] != 48 {
+		t.Errorf("unexpected readings %v", got)
+	}
+
+	if tag, _ := Decode(adv, nil); tag.Err != errNoKey || len(tag.Readings) != 0 {
+		t.Errorf("expected missing key error, got %+v", tag)
+	}
+	wrong := append([]byte{}, key...)
+	wrong[0] ^= 1
+	if tag, _ := Decode(adv, wrong); tag.Err == nil || len(tag.Readings) != 0 {
+		t.Errorf("expected wrong key error, got %+v", tag)
+	}
+
+	// A battery level in the clear, without the address.
+	clear := []byte{0x40, 0x30, 0x5b, 0x05, 0x43, 0x0a, 0x10, 0x01, 0x5d}
+	tag, ok = Decode(Advertisement{Address: "A4:C1:38:6A:12:34", ServiceData: map[uint16][]byte{serviceMiBeacon: clear}}, nil)
+	if got := readingValues(tag); !ok || got["battery_level"] != 93 {
+		t.Errorf("unexpected readings %v", got)
+	}
+}
@@ -0,0 +1,231 @@
+// Package bmp reads the Bosch BMP3xx barometers.
+package bmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Bosch BMP388 and BMP390 pressure and temperature sensors. They run in
+// normal mode, measuring continuously at 12.5 Hz, which is slow enough for
+// the highest oversampling. The IIR filter smooths out short pressure
+// changes such as from wind gusts and doors slamming; the oversampling
+// reduces the noise of each measurement.
+
+type BMP388 struct {
+	bus     *i2c.Bus
+	address int
+	chip    string
+	cal     bmp388Calibration
+
+	mut         sync.Mutex
+	cached      time.Time
+	pressure    float64 // mbar
+	temperature float64 // °C
+}
+
+// BMP388DefaultAddress is the address with SDO high, as on most breakout
+// boards; with SDO low it is 0x76.
+const BMP388DefaultAddress = 0x77
+
+const (
+	bmp388ChipIDReg  = 0x00
+	bmp388ErrReg     = 0x02
+	bmp388DataReg    = 0x04 // pressure then temperature, 24 bits each, LSB first
+	bmp388PwrCtrlReg = 0x1b
+	bmp388OSRReg     = 0x1c
+	bmp388ODRReg     = 0x1d
+	bmp388ConfigReg  = 0x1f
+	bmp388NVMReg     = 0x31
+	bmp388NVMLen     = 21
+	bmp388CmdReg     = 0x7e
+
+	bmp388ChipID = 0x50
+	bmp390ChipID = 0x60
+
+	bmp388SoftReset = 0xb6
+	bmp388Normal    = 0x33 // pressure and temperature enabled, normal mode
+	bmp388ODR12Hz5  = 0x04 // 200 Hz / 2^4
+	bmp388ConfErr   = 0x04
+
+	bmp388ResetTime = 10 * time.Millisecond
+)
+
+// Oversampling settings, the number of samples per measurement, and IIR
+// filter coefficients.
+var (
+	Oversamplings   = []int{1, 2, 4, 8, 16, 32}
+	IIRCoefficients = []int{0, 1, 3, 7, 15, 31, 63, 127}
+)
+
+// bmp388Calibration is the NVM calibration, scaled as in the datasheet.
+type bmp388Calibration struct {
+	t1, t2, t3                                   float64
+	p1, p2, p3, p4, p5, p6, p7, p8, p9, p10, p11 float64
+}
+
+// NewBMP388 resets and configures the BMP388 or BMP390 at the address,
+// with the oversampling of the pressure and the IIR filter coefficient.
+func NewBMP388(bus *i2c.Bus, addr, oversampling, iir int) (*BMP388, error) {
+	osr := index(Oversamplings, oversampling)
+	if osr < 0 {
+		return nil, fmt.Errorf("invalid oversampling %d (valid: %v)", oversampling, Oversamplings)
+	}
+	filter := index(IIRCoefficients, iir)
+	if filter < 0 {
+		return nil, fmt.Errorf("invalid IIR filter coefficient %d (valid: %v)", iir, IIRCoefficients)
+	}
+
+	s := &BMP388{bus: bus, address: addr}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		id, err := dev.ReadByteData(bmp388ChipIDReg)
+		if err != nil {
+			return fmt.Errorf("read chip ID: %w", err)
+		}
+		switch id {
+		case bmp388ChipID:
+			s.chip = "BMP388"
+		case bmp390ChipID:
+			s.chip = "BMP390"
+		default:
+			return fmt.Errorf("unknown chip ID 0x%02x", id)
+		}
+
+		if err := dev.WriteByteData(bmp388CmdReg, bmp388SoftReset); err != nil {
+			return fmt.Errorf("reset: %w", err)
+		}
+		time.Sleep(bmp388ResetTime)
+
+		r := i2c.NewReader(dev)
+		nvm := r.Block(bmp388NVMReg, bmp388NVMLen)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read calibration: %w", err)
+		}
+		s.cal = parseBMP388Calibration(nvm)
+
+		// Temperature is not oversampled; it changes slowly and is only
+		// used for the compensation.
+		for _, w := range []struct{ reg, val uint8 }{
+			{bmp388OSRReg, uint8(osr)},
+			{bmp388ODRReg, bmp388ODR12Hz5},
+			{bmp388ConfigReg, uint8(filter) << 1},
+			{bmp388PwrCtrlReg, bmp388Normal},
+		} {
+			if err := dev.WriteByteData(w.reg, w.val); err != nil {
+				return fmt.Errorf("write configuration: %w", err)
+			}
+		}
+		if e, err := dev.ReadByteData(bmp388ErrReg); err != nil {
+			return fmt.Errorf("read error register: %w", err)
+		} else if e&bmp388ConfErr != 0 {
+			return fmt.Errorf("configuration rejected")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func index(list []int, v int) int {
+	for i, l := range list {
+		if l == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func parseBMP388Calibration(nvm []byte) bmp388Calibration {
+	u16 := func(i int) float64 { return float64(binary.LittleEndian.Uint16(nvm[i:])) }
+	s16 := func(i int) float64 { return float64(int16(binary.LittleEndian.Uint16(nvm[i:]))) }
+	s8 := func(i int) float64 { return float64(int8(nvm[i])) }
+	return bmp388Calibration{
+		t1:  u16(0) * 256,
+		t2:  u16(2) / math.Pow(2, 30),
+		t3:  s8(4) / math.Pow(2, 48),
+		p1:  (s16(5) - 16384) / math.Pow(2, 20),
+		p2:  (s16(7) - 16384) / math.Pow(2, 29),
+		p3:  s8(9) / math.Pow(2, 32),
+		p4:  s8(10) / math.Pow(2, 37),
+		p5:  u16(11) * 8,
+		p6:  u16(13) / math.Pow(2, 6),
+		p7:  s8(15) / math.Pow(2, 8),
+		p8:  s8(16) / math.Pow(2, 15),
+		p9:  s16(17) / math.Pow(2, 48),
+		p10: s8(19) / math.Pow(2, 48),
+		p11: s8(20) / math.Pow(2, 65),
+	}
+}
+
+func (s *BMP388) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(bmp388DataReg, 6)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
+		up := float64(uint32(data[0]) | uint32(data[1])<<8 | uint32(data[2])<<16)
+		ut := float64(uint32(data[3]) | uint32(data[4])<<8 | uint32(data[5])<<16)
+		s.pressure, s.temperature = s.cal.compensate(up, ut)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// compensate returns the pressure in mbar and the temperature in °C from
+// the raw values, with the floating point compensation of the datasheet.
+func (c bmp388Calibration) compensate(up, ut float64) (pressure, temperature float64) {
+	pd1 := ut - c.t1
+	t := pd1*c.t2 + pd1*pd1*c.t3
+
+	out1 := c.p5 + c.p6*t + c.p7*t*t + c.p8*t*t*t
+	out2 := up * (c.p1 + c.p2*t + c.p3*t*t + c.p4*t*t*t)
+	out3 := up*up*(c.p9+c.p10*t) + up*up*up*c.p11
+	return (out1 + out2 + out3) / 100, t
+}
+
+// Pressure returns the pressure, in mbar.
+func (s *BMP388) Pressure() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.pressure
+}
+
+// Temperature returns the temperature, in °C.
+func (s *BMP388) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature
+}
+
+func (s *BMP388) Info() sensor.Info {
+	return sensor.Info{Chip: s.chip, Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *BMP388) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "pressure", Unit: "mb", Quantity: sensor.Pressure, Precision: 2, Value: s.pressure},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: s.temperature},
+	}
+}
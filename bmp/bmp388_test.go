@@ -0,0 +1,53 @@
+package bmp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+// put24 sets a 24 bit value in three registers, low byte first.
+func put24(d i2ctest.Registers, reg uint8, v uint32) {
+	d[reg], d[reg+1], d[reg+2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func TestBMP388(t *testing.T) {
+	dev := i2ctest.Registers{bmp388ChipIDReg: bmp390ChipID}
+
+	// A calibration where the raw temperature equal to T1 × 256 is
+	// exactly 0 °C, and the pressure is then P5 × 8 + raw pressure × P1.
+	dev.Set16LE(bmp388NVMReg+0, 32000)      // T1
+	dev.Set16LE(bmp388NVMReg+5, 16384+1024) // P1, 1/1024
+	dev.Set16LE(bmp388NVMReg+11, 12500)     // P5, 100000 Pa
+	put24(dev, bmp388DataReg, 1325*1024)
+	put24(dev, bmp388DataReg+3, 32000*256)
+
+	s, err := NewBMP388(i2c.NewBus(dev), BMP388DefaultAddress, 8, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Info().Chip != "BMP390" {
+		t.Errorf("unexpected chip %q", s.Info().Chip)
+	}
+	if dev[bmp388OSRReg] != 3 || dev[bmp388ConfigReg] != 2<<1 || dev[bmp388PwrCtrlReg] != bmp388Normal {
+		t.Errorf("unexpected configuration OSR 0x%02x config 0x%02x power 0x%02x",
+			dev[bmp388OSRReg], dev[bmp388ConfigReg], dev[bmp388PwrCtrlReg])
+	}
+
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.Temperature() != 0 || math.Abs(s.Pressure()-1013.25) > 1e-9 {
+		t.Errorf("unexpected pressure %v and temperature %v", s.Pressure(), s.Temperature())
+	}
+
+	if _, err := NewBMP388(i2c.NewBus(dev), BMP388DefaultAddress, 3, 3); err == nil {
+		t.Error("expected error for invalid oversampling")
+	}
+	dev[bmp388ChipIDReg] = 0x58 // BMP280
+	if _, err := NewBMP388(i2c.NewBus(dev), BMP388DefaultAddress, 8, 3); err == nil {
+		t.Error("expected error for unknown chip")
+	}
+}
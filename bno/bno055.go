@@ -0,0 +1,405 @@
+// Package bno reads the Bosch BNO055 absolute orientation sensor.
+package bno
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Bosch BNO055 9-axis absolute orientation sensor: an accelerometer, a
+// gyroscope and a magnetometer, fused on the chip into Euler angles and a
+// quaternion. It runs in NDOF mode, with the magnetometer calibrating
+// itself as the sensor moves around; the calibration status tells how far
+// that has come, from 0 for none to 3 for fully calibrated, for the system
+// as a whole and each sensor.
+//
+// The calibration is lost at power off. The offsets can be read once
+// calibrated and written back at the next start, which gives a good
+// heading much sooner. Reading them needs the sensor in configuration
+// mode, which pauses the fusion for a moment.
+//
+// The BNO055 stretches the I2C clock longer than the Raspberry Pi
+// handles; it needs the bus slowed down, e.g. to 50 kHz with
+// dtparam=i2c_arm_baudrate=50000.
+
+type BNO055 struct {
+	bus     *i2c.Bus
+	address int
+	id      string
+	mo      float64
+
+	mut    sync.Mutex
+	cached time.Time
+	accel  sensor.Point // mg
+	magn   sensor.Point // µT/16
+	gyro   sensor.Point // °/s/16
+	euler  [3]int16     // heading, roll, pitch, °/16
+	quat   [4]int16     // w, x, y, z, 1/2^14
+	temp   int8         // °C
+	calib  byte
+}
+
+const (
+	BNO055DefaultAddress = 0x28
+	BNO055AltAddress     = 0x29
+)
+
+const (
+	bno055ChipIDReg    = 0x00
+	bno055SWRevIDReg   = 0x04 // LSB, MSB
+	bno055PageIDReg    = 0x07
+	bno055AccelDataReg = 0x08 // through SYS_ERR, 51 bytes
+	bno055UnitSelReg   = 0x3b
+	bno055OprModeReg   = 0x3d
+	bno055PwrModeReg   = 0x3e
+	bno055OffsetsReg   = 0x55 // 22 bytes
+
+	bno055ChipID      = 0xa0
+	bno055UnitsMG     = 0b_0000_0001 // UNIT_SEL ACC_Unit mg; °/s, degrees, °C
+	bno055PowerNormal = 0x00
+	bno055ModeConfig  = 0x00
+	bno055ModeNDOF    = 0x0c
+	bno055SystemError = 0x01 // SYS_STATUS
+
+	// Times to boot, and to switch to and from configuration mode.
+	bno055BootTime   = 650 * time.Millisecond
+	bno055ConfigTime = 25 * time.Millisecond
+	bno055FusionTime = 20 * time.Millisecond
+
+	bno055EulerLSB = 16 // counts per degree
+	bno055GyroLSB  = 16 // counts per °/s
+	bno055QuatLSB  = 1 << 14
+)
+
+// Offsets in the data block read on refresh.
+const (
+	bno055AccelOffs  = 0
+	bno055MagnOffs   = 6
+	bno055GyroOffs   = 12
+	bno055EulerOffs  = 18
+	bno055QuatOffs   = 24
+	bno055TempOffs   = 44
+	bno055CalibOffs  = 45
+	bno055StatusOffs = 49
+	bno055ErrorOffs  = 50
+	bno055DataLen    = 51
+)
+
+// BNO055Offsets are the calibration offsets and radii, in the order of the
+// offset registers.
+type BNO055Offsets struct {
+	Accel       sensor.Point
+	Magn        sensor.Point
+	Gyro        sensor.Point
+	AccelRadius int16
+	MagnRadius  int16
+}
+
+func (o BNO055Offsets) bytes() []byte {
+	data := make([]byte, 22)
+	for i, v := range []int16{o.Accel.X, o.Accel.Y, o.Accel.Z, o.Magn.X, o.Magn.Y, o.Magn.Z, o.Gyro.X, o.Gyro.Y, o.Gyro.Z, o.AccelRadius, o.MagnRadius} {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(v))
+	}
+	return data
+}
+
+func parseOffsets(data []byte) BNO055Offsets {
+	return BNO055Offsets{
+		Accel:       point(data[0:]),
+		Magn:        point(data[6:]),
+		Gyro:        point(data[12:]),
+		AccelRadius: int16(binary.LittleEndian.Uint16(data[18:])),
+		MagnRadius:  int16(binary.LittleEndian.Uint16(data[20:])),
+	}
+}
+
+// BNO055CalibrationStatus is the calibration status of the system and
+// each sensor, from 0 for uncalibrated to 3 for fully calibrated.
+type BNO055CalibrationStatus struct {
+	System int
+	Gyro   int
+	Accel  int
+	Magn   int
+}
+
+// Calibrated returns whether the system and all sensors are fully
+// calibrated, and the offsets worth keeping.
+func (c BNO055CalibrationStatus) Calibrated() bool {
+	return c.System == 3 && c.Gyro == 3 && c.Accel == 3 && c.Magn == 3
+}
+
+func parseCalibrationStatus(b byte) BNO055CalibrationStatus {
+	return BNO055CalibrationStatus{
+		System: int(b>>6) & 3,
+		Gyro:   int(b>>4) & 3,
+		Accel:  int(b>>2) & 3,
+		Magn:   int(b) & 3,
+	}
+}
+
+// NewBNO055 configures the BNO055 at the address, writes the calibration
+// offsets if not nil and starts the fusion. The magnetic offset is added to
+// the heading and compass angles, in degrees.
+func NewBNO055(bus *i2c.Bus, addr int, magnOffs float64, offsets *BNO055Offsets) (*BNO055, error) {
+	s := &BNO055{bus: bus, address: addr, mo: magnOffs}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		if r.Byte(bno055ChipIDReg) != bno055ChipID {
+			// It may still be booting.
+			time.Sleep(bno055BootTime)
+			r.Reset()
+			if id := r.Byte(bno055ChipIDReg); r.Error() == nil && id != bno055ChipID {
+				return fmt.Errorf("unknown chip ID 0x%02x", id)
+			}
+		}
+		rev := r.Block(bno055SWRevIDReg, 2)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read chip ID: %w", err)
+		}
+		s.id = fmt.Sprintf("SW %x.%02x", rev[1], rev[0])
+
+		if err := s.setMode(r, bno055ModeConfig); err != nil {
+			return err
+		}
+		for _, w := range []struct{ reg, val byte }{
+			{bno055PageIDReg, 0},
+			{bno055PwrModeReg, bno055PowerNormal},
+			{bno055UnitSelReg, bno055UnitsMG},
+		} {
+			if err := r.WriteBlock(w.reg, []byte{w.val}); err != nil {
+				return fmt.Errorf("write register 0x%02x: %w", w.reg, err)
+			}
+		}
+		if offsets != nil {
+			if err := r.WriteBlock(bno055OffsetsReg, offsets.bytes()); err != nil {
+				return fmt.Errorf("write calibration offsets: %w", err)
+			}
+		}
+		return s.setMode(r, bno055ModeNDOF)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BNO055) setMode(r *i2c.Reader, mode byte) error {
+	if err := r.WriteBlock(bno055OprModeReg, []byte{mode}); err != nil {
+		return fmt.Errorf("set operation mode: %w", err)
+	}
+	if mode == bno055ModeConfig {
+		time.Sleep(bno055ConfigTime)
+	} else {
+		time.Sleep(bno055FusionTime)
+	}
+	return nil
+}
+
+func (s *BNO055) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(bno055AccelDataReg, bno055DataLen)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
+		if data[bno055StatusOffs] == bno055SystemError {
+			return fmt.Errorf("system error %d", data[bno055ErrorOffs])
+		}
+		s.accel = point(data[bno055AccelOffs:])
+		s.magn = point(data[bno055MagnOffs:])
+		s.gyro = point(data[bno055GyroOffs:])
+		for i := range s.euler {
+			s.euler[i] = int16(binary.LittleEndian.Uint16(data[bno055EulerOffs+2*i:]))
+		}
+		for i := range s.quat {
+			s.quat[i] = int16(binary.LittleEndian.Uint16(data[bno055QuatOffs+2*i:]))
+		}
+		s.temp = int8(data[bno055TempOffs])
+		s.calib = data[bno055CalibOffs]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+func point(data []byte) sensor.Point {
+	return sensor.Point{
+		X: int16(binary.LittleEndian.Uint16(data[0:])),
+		Y: int16(binary.LittleEndian.Uint16(data[2:])),
+		Z: int16(binary.LittleEndian.Uint16(data[4:])),
+	}
+}
+
+// Offsets reads the calibration offsets, pausing the fusion meanwhile.
+// They are only worth keeping when the sensor is fully calibrated.
+func (s *BNO055) Offsets() (BNO055Offsets, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var offs BNO055Offsets
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		if err := s.setMode(r, bno055ModeConfig); err != nil {
+			return err
+		}
+		data, err := r.ReadBlock(bno055OffsetsReg, 22)
+		if err != nil {
+			// Back to fusion regardless.
+			_ = s.setMode(r, bno055ModeNDOF)
+			return fmt.Errorf("read calibration offsets: %w", err)
+		}
+		offs = parseOffsets(data)
+		return s.setMode(r, bno055ModeNDOF)
+	})
+	return offs, err
+}
+
+// CalibrationStatus returns the calibration status as of the last refresh.
+func (s *BNO055) CalibrationStatus() BNO055CalibrationStatus {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return parseCalibrationStatus(s.calib)
+}
+
+// Orientation returns the fused heading, from 0 to 360 and plus the
+// magnetic offset, and the roll and pitch, in degrees.
+func (s *BNO055) Orientation() (heading, roll, pitch float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.orientation()
+}
+
+func (s *BNO055) orientation() (heading, roll, pitch float64) {
+	heading = math.Mod(float64(s.euler[0])/bno055EulerLSB+s.mo+360, 360)
+	return heading, float64(s.euler[1]) / bno055EulerLSB, float64(s.euler[2]) / bno055EulerLSB
+}
+
+// Quaternion returns the fused orientation as a unit quaternion.
+func (s *BNO055) Quaternion() (w, x, y, z float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return float64(s.quat[0]) / bno055QuatLSB, float64(s.quat[1]) / bno055QuatLSB, float64(s.quat[2]) / bno055QuatLSB, float64(s.quat[3]) / bno055QuatLSB
+}
+
+// Acceleration returns the acceleration in mg, gravity included.
+func (s *BNO055) Acceleration() (x, y, z int16) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.accel.X, s.accel.Y, s.accel.Z
+}
+
+// AccelerationSamples returns the current accelerometer sample.
+func (s *BNO055) AccelerationSamples() []sensor.Point {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Point{s.accel}
+}
+
+// AccelerationRate returns the number of accelerometer samples per second
+// available from AccelerationSamples.
+func (s *BNO055) AccelerationRate(refresh time.Duration) float64 {
+	return 1 / refresh.Seconds()
+}
+
+func (s *BNO055) AccelerationAngles() (xy, xz, yz float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	xy = angle(float64(s.accel.Y), float64(s.accel.X))
+	xz = angle(float64(s.accel.Z), float64(s.accel.X))
+	yz = angle(float64(s.accel.Z), float64(s.accel.Y))
+	return xy, xz, yz
+}
+
+// Rotation returns the rate of turn around each axis, in °/s.
+func (s *BNO055) Rotation() (x, y, z float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return float64(s.gyro.X) / bno055GyroLSB, float64(s.gyro.Y) / bno055GyroLSB, float64(s.gyro.Z) / bno055GyroLSB
+}
+
+// MagneticField returns the magnetic field in counts of 1/16 µT, as
+// calibrated by the sensor.
+func (s *BNO055) MagneticField() (x, y, z int16) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.magn.X, s.magn.Y, s.magn.Z
+}
+
+// Compass returns the angle of the magnetic field in each plane, plus the
+// magnetic offset, without the tilt compensation of the heading.
+func (s *BNO055) Compass() (xy, xz, yz float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	x, y, z := float64(s.magn.X), float64(s.magn.Y), float64(s.magn.Z)
+	return compass(y, x, s.mo), compass(z, x, s.mo), compass(z, y, s.mo)
+}
+
+// Temperature returns the temperature of the accelerometer, in degrees
+// Celsius.
+func (s *BNO055) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return float64(s.temp)
+}
+
+func (s *BNO055) Info() sensor.Info {
+	return sensor.Info{Chip: "BNO055", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address), ID: s.id}
+}
+
+// Readings returns the fused orientation, the acceleration, the rate of
+// turn and the temperature.
+func (s *BNO055) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	heading, roll, pitch := s.orientation()
+	return []sensor.Reading{
+		{Name: "heading", Unit: "degrees", Quantity: sensor.Angle, Precision: 0, Value: heading},
+		{Name: "roll", Unit: "degrees", Quantity: sensor.Angle, Precision: 1, Value: roll},
+		{Name: "pitch", Unit: "degrees", Quantity: sensor.Angle, Precision: 1, Value: pitch},
+		{Name: "acceleration_x", Quantity: sensor.Acceleration, Value: float64(s.accel.X)},
+		{Name: "acceleration_y", Quantity: sensor.Acceleration, Value: float64(s.accel.Y)},
+		{Name: "acceleration_z", Quantity: sensor.Acceleration, Value: float64(s.accel.Z)},
+		{Name: "rotation_x", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gyro.X) / bno055GyroLSB},
+		{Name: "rotation_y", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gyro.Y) / bno055GyroLSB},
+		{Name: "rotation_z", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gyro.Z) / bno055GyroLSB},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 0, Value: float64(s.temp)},
+	}
+}
+
+func compass(y, x, o float64) float64 {
+	v := math.Atan2(y, x)/math.Pi*180 + o
+	for v > 360 {
+		v -= 360
+	}
+	for v < 0 {
+		v += 360
+	}
+	return v
+}
+
+func angle(y, x float64) float64 {
+	v := math.Atan2(y, x) / math.Pi * 180
+	for v > 180 {
+		v -= 360
+	}
+	for v < -180 {
+		v += 360
+	}
+	return v
+}
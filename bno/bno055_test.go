@@ -0,0 +1,108 @@
+package bno
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// bnoDevice is a register map in which the offset registers can only be
+// written in configuration mode.
+type bnoDevice struct {
+	i2ctest.Registers
+	modes  []uint8 // operation modes set, in order
+	locked bool    // offsets written outside configuration mode
+}
+
+func (d *bnoDevice) WriteBlockData(reg uint8, data []byte) error {
+	if reg == bno055OprModeReg {
+		d.modes = append(d.modes, data[0])
+	}
+	if reg == bno055OffsetsReg && d.Registers[bno055OprModeReg] != bno055ModeConfig {
+		d.locked = true
+	}
+	return d.Registers.WriteBlockData(reg, data)
+}
+
+func (d *bnoDevice) set16(reg uint8, v int16) {
+	d.Set16LE(reg, uint16(v))
+}
+
+func TestBNO055(t *testing.T) {
+	dev := &bnoDevice{Registers: i2ctest.Registers{bno055ChipIDReg: bno055ChipID, bno055SWRevIDReg: 0x11, bno055SWRevIDReg + 1: 0x03}}
+	offs := &BNO055Offsets{Accel: sensor.Point{X: -12, Y: 3, Z: 20}, Magn: sensor.Point{X: 140, Y: -360, Z: 75}, AccelRadius: 1000, MagnRadius: 700}
+	s, err := NewBNO055(i2c.NewBus(dev), BNO055DefaultAddress, 2, offs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.locked {
+		t.Error("offsets written outside configuration mode")
+	}
+	if dev.Registers[bno055OprModeReg] != bno055ModeNDOF {
+		t.Errorf("operation mode 0x%02x, expected NDOF", dev.Registers[bno055OprModeReg])
+	}
+	if dev.Registers[bno055UnitSelReg] != bno055UnitsMG {
+		t.Errorf("units 0x%02x", dev.Registers[bno055UnitSelReg])
+	}
+	if id := s.Info().ID; id != "SW 3.11" {
+		t.Errorf("ID %q", id)
+	}
+
+	// Heading 359°, heeled 20° to starboard, pitched 2.5° down, turning
+	// at 3 °/s, at 24 °C, with everything but the magnetometer
+	// calibrated.
+	dev.set16(bno055AccelDataReg+bno055EulerOffs, 359*16)
+	dev.set16(bno055AccelDataReg+bno055EulerOffs+2, 20*16)
+	dev.set16(bno055AccelDataReg+bno055EulerOffs+4, -40)
+	dev.set16(bno055AccelDataReg+bno055GyroOffs+4, 48)
+	dev.set16(bno055AccelDataReg+bno055QuatOffs, 1<<14)
+	dev.set16(bno055AccelDataReg+bno055AccelOffs+2, 342)
+	dev.set16(bno055AccelDataReg+bno055AccelOffs+4, 940)
+	dev.Registers[bno055AccelDataReg+bno055TempOffs] = 24
+	dev.Registers[bno055AccelDataReg+bno055CalibOffs] = 0b_11_11_11_01
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+
+	heading, roll, pitch := s.Orientation()
+	if heading != 1 || roll != 20 || pitch != -2.5 {
+		t.Errorf("orientation %v, %v, %v, expected 1 (with the magnetic offset), 20, -2.5", heading, roll, pitch)
+	}
+	if w, _, _, _ := s.Quaternion(); w != 1 {
+		t.Errorf("quaternion w %v, expected 1", w)
+	}
+	if _, _, z := s.Rotation(); z != 3 {
+		t.Errorf("rotation %v, expected 3", z)
+	}
+	if _, _, yz := s.AccelerationAngles(); math.Abs(yz-70) > 0.1 {
+		t.Errorf("yz angle %v, expected 70", yz)
+	}
+	if temp := s.Temperature(); temp != 24 {
+		t.Errorf("temperature %v", temp)
+	}
+	cal := s.CalibrationStatus()
+	if cal != (BNO055CalibrationStatus{System: 3, Gyro: 3, Accel: 3, Magn: 1}) || cal.Calibrated() {
+		t.Errorf("calibration status %+v", cal)
+	}
+
+	// Reading the offsets goes through configuration mode and back.
+	dev.modes = nil
+	got, err := s.Offsets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != *offs {
+		t.Errorf("offsets %+v, expected %+v", got, *offs)
+	}
+	if len(dev.modes) != 2 || dev.modes[0] != bno055ModeConfig || dev.modes[1] != bno055ModeNDOF {
+		t.Errorf("modes %v, expected config and back to NDOF", dev.modes)
+	}
+
+	dev.Registers[bno055AccelDataReg+bno055StatusOffs] = bno055SystemError
+	if err := s.Refresh(0); err == nil {
+		t.Error("expected error for system error status")
+	}
+}
@@ -1,3 +1,4 @@
 #!/bin/bash
 
 GOOS=linux GOARCH=arm go build -o boatpi-promexp-linux-arm -ldflags '-w -s' ./cmd/promexp
+GOOS=linux GOARCH=arm go build -o boatpi-storeimport-linux-arm -ldflags '-w -s' ./cmd/storeimport
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Analog senders on an ADC (ADS1115, MCP3008) are configured as named
+// channels. Each is exported as sensors_<adc>_voltage{channel="<name>"}
+// and, with a scale of voltage and value pairs, also as
+// sensors_<adc>_<name>, linearly interpolated between the pairs. The name
+// should thus end in the unit of the scaled value:
+//
+//   sensors:
+//     ads1115:
+//       channels:
+//         fuel_tank_percent:
+//           input: "0"        # 0 to 3, or 0-1, 0-3, 1-3, 2-3 for differential
+//           range: 4.096      # full scale volts, default 4.096
+//           rate: 128         # samples per second, default 128
+//           scale: [[0.5, 0], [4.5, 100]]
+//         oil_pressure_bar:
+//           input: "1"
+//           scale: [[0.5, 0], [4.5, 10]]
+//     mcp3008:
+//       channels:
+//         rudder_angle_degrees:
+//           input: "0"        # 0 to 7, or adjacent pairs 0-1, 1-0, ... for differential
+//           scale: [[0.2, -35], [3.1, 35]]
+
+type adcChannelConfig struct {
+	Input string       `yaml:"input"`
+	Range float64      `yaml:"range"` // V full scale, ADS1115
+	Rate  int          `yaml:"rate"`  // samples per second, ADS1115
+	Scale [][2]float64 `yaml:"scale"` // voltage and value pairs
+}
+
+func (c adcChannelConfig) scale() (interpolation, bool) {
+	if len(c.Scale) == 0 {
+		return interpolation{}, false
+	}
+	var n interpolation
+	for _, p := range c.Scale {
+		n.x = append(n.x, p[0])
+		n.y = append(n.y, p[1])
+	}
+	return n, true
+}
+
+// adcChannelNames returns the channel names in order.
+func adcChannelNames(confs map[string]adcChannelConfig) []string {
+	var names []string
+	for name := range confs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// adcValidators check the channels for the ADC with the given name.
+var adcValidators = map[string]func(map[string]adcChannelConfig) error{
+	"ads1115": validateADS1115Channels,
+	"mcp3008": validateMCP3008Channels,
+}
+
+func validateADCChannels(sensor string, confs map[string]adcChannelConfig) error {
+	if len(confs) == 0 {
+		return nil
+	}
+	validate, ok := adcValidators[sensor]
+	if !ok {
+		var names []string
+		for name := range adcValidators {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("channels are only supported for %s", strings.Join(names, ", "))
+	}
+	if err := validate(confs); err != nil {
+		return err
+	}
+	for name, c := range confs {
+		if !virtualNameExp.MatchString(name) {
+			return fmt.Errorf("channel %q: invalid name", name)
+		}
+		if len(c.Scale) == 1 {
+			return fmt.Errorf("channel %s: scale needs at least two points", name)
+		}
+		for i := 1; i < len(c.Scale); i++ {
+			if c.Scale[i][0] <= c.Scale[i-1][0] {
+				return fmt.Errorf("channel %s: scale voltages must be increasing", name)
+			}
+		}
+	}
+	return nil
+}
+
+type adcDevice interface {
+	Refresh(age time.Duration) error
+	Voltages() []float64
+}
+
+// registerADC registers the metrics of the ADC sensor with the name and
+// returns the update function.
+func registerADC(sensor, chip string, dev adcDevice, confs map[string]adcChannelConfig) func() {
+	names := adcChannelNames(confs)
+	voltage := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: sensor,
+		Name:      "voltage",
+	}, []string{"channel"})
+	scaled := make([]*gauge, len(names))
+	scales := make([]interpolation, len(names))
+	for i, name := range names {
+		if n, ok := confs[name].scale(); ok {
+			scales[i] = n
+			scaled[i] = newGauge(prometheus.GaugeOpts{
+				Namespace: "sensors",
+				Subsystem: sensor,
+				Name:      name,
+				Help:      fmt.Sprintf("Channel %s, scaled from the voltage.", name),
+			})
+		}
+	}
+
+	return func() {
+		if err := dev.Refresh(time.Second); err != nil {
+			log.Printf("%s: %v", chip, err)
+			health.failed(sensor, err)
+			return
+		}
+
+		health.ok(sensor)
+		for i, v := range dev.Voltages() {
+			voltage.WithLabelValues(names[i]).Set(v)
+			if scaled[i] != nil {
+				scaled[i].Set(scales[i].val(v))
+			}
+		}
+	}
+}
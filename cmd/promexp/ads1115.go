@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/calmh/boatpi/adc"
+	"github.com/calmh/boatpi/i2c"
+)
+
+const (
+	adcDefaultRange = 4.096
+	adcDefaultRate  = 128
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "ads1115",
+		section: true,
+		enabled: func(o options) bool { return o.WithADS1115 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, c.Channels}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			channels, err := ads1115Channels(conf.Channels)
+			if err != nil {
+				return nil, err
+			}
+			dev, err := adc.NewADS1115(bus, conf.address(adc.ADS1115DefaultAddress), channels)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("ads1115", dev)
+			return registerADC("ads1115", "ADS1115", dev, conf.Channels), nil
+		},
+	})
+}
+
+// ads1115Channels returns the driver channels, in the order of
+// adcChannelNames.
+func ads1115Channels(confs map[string]adcChannelConfig) ([]adc.ADS1115Channel, error) {
+	var channels []adc.ADS1115Channel
+	for _, name := range adcChannelNames(confs) {
+		c := confs[name]
+		in, err := adc.ParseADS1115Input(c.Input)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", name, err)
+		}
+		ch := adc.ADS1115Channel{Name: name, Input: in, FullScale: c.Range, Rate: c.Rate}
+		if ch.FullScale == 0 {
+			ch.FullScale = adcDefaultRange
+		}
+		if ch.Rate == 0 {
+			ch.Rate = adcDefaultRate
+		}
+		channels = append(channels, ch)
+	}
+	return channels, nil
+}
+
+func validateADS1115Channels(confs map[string]adcChannelConfig) error {
+	channels, err := ads1115Channels(confs)
+	if err != nil {
+		return err
+	}
+	for _, c := range channels {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestADS1115Channels(t *testing.T) {
+	const conf = `
+sensors:
+  ads1115:
+    channels:
+      oil_pressure_bar:
+        input: "1"
+        scale: [[0.5, 0], [4.5, 10]]
+      fuel_tank_percent:
+        input: "0"
+        range: 6.144
+        rate: 8
+        scale: [[0.5, 0], [4.5, 100]]
+`
+	secs, _, err := loadSections(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	confs := secs.Sensors["ads1115"].Channels
+	names := adcChannelNames(confs)
+	channels, err := ads1115Channels(confs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "fuel_tank_percent" {
+		t.Fatalf("unexpected channel order %v", names)
+	}
+	if channels[0].FullScale != 6.144 || channels[0].Rate != 8 {
+		t.Errorf("unexpected fuel channel %+v", channels[0])
+	}
+	if channels[1].FullScale != adcDefaultRange || channels[1].Rate != adcDefaultRate {
+		t.Errorf("expected defaults for oil channel, got %+v", channels[1])
+	}
+	n, _ := confs["oil_pressure_bar"].scale()
+	if v := n.val(2.5); v != 5 {
+		t.Errorf("scaled oil pressure %v, expected 5", v)
+	}
+}
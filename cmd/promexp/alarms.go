@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/gpio"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Fire and gas detectors raise latched alarms: once triggered, the alarm
+// stays raised until explicitly reset, even if the detector clears. A gas
+// leak that comes and goes still needs to be investigated.
+//
+// A detector is either a switching one on a GPIO input, or an analog one,
+// such as an MQ-2 LPG sensor on an ADC channel, given as an expression
+// and the level above which it is triggered:
+//
+//   detectors:
+//     - name: bilge
+//       kind: gas
+//       source: sensors_ads1115_voltage{channel="lpg"}
+//       above: 1.2
+//
+// The alarm has the name of the detector, which thus cannot be one used by
+// the other alarms: anchor, or starting with mob-, alr- or webhook-.
+
+func init() {
+	registerSensor(sensorDef{
+		// Analog detectors see the ADC values from this round.
+		name:    "detectors",
+		order:   1,
+		enabled: func(o options) bool { return len(sections().Detectors) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.GPIOChip, sections().Detectors}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			var inputs []*detectorInput
+			closeLines := func() {
+				for _, in := range inputs {
+					if in.line != nil {
+						in.line.Close()
+					}
+				}
+			}
+			for _, det := range sections().Detectors {
+				if det.Source != "" {
+					expr, _, _ := parseExpr(det.Source)
+					inputs = append(inputs, &detectorInput{detectorConfig: det, expr: expr})
+					continue
+				}
+				line, err := requestInput(cli().GPIOChip, det.Pin, det.ActiveLow)
+				if err != nil {
+					closeLines()
+					return nil, fmt.Errorf("detector %s: %w", det.Name, err)
+				}
+				inputs = append(inputs, &detectorInput{detectorConfig: det, line: line})
+			}
+			onDone(ctx, closeLines)
+			return registerDetectors(inputs, prometheus.DefaultGatherer), nil
+		},
+	})
+}
+
+// reservedAlarm returns whether the alarm name is one used other than by
+// the detectors.
+func reservedAlarm(name string) bool {
+	for _, prefix := range []string{"mob-", "alr-", "webhook-"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return name == anchorAlarm
+}
+
+type latchedAlarms struct {
+	mut    sync.Mutex
+	raised map[string]time.Time
+}
+
+var alarms = &latchedAlarms{raised: make(map[string]time.Time)}
+
+// raise raises the named alarm and returns true if it was not already
+// raised.
+func (a *latchedAlarms) raise(name string) bool {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if _, ok := a.raised[name]; ok {
+		return false
+	}
+	a.raised[name] = time.Now()
+	return true
+}
+
+func (a *latchedAlarms) isRaised(name string) bool {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	_, ok := a.raised[name]
+	return ok
+}
+
+func (a *latchedAlarms) reset(name string) bool {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if _, ok := a.raised[name]; !ok {
+		return false
+	}
+	delete(a.raised, name)
+	return true
+}
+
+func (a *latchedAlarms) list() map[string]time.Time {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	res := make(map[string]time.Time, len(a.raised))
+	for name, when := range a.raised {
+		res[name] = when
+	}
+	return res
+}
+
+// handleAlarms lists raised alarms on GET and resets the alarm given by
+// the "name" parameter on POST.
+func handleAlarms(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(alarms.list())
+
+	case http.MethodPost:
+		name := req.FormValue("name")
+		if !alarms.reset(name) {
+			http.Error(w, "no such raised alarm", http.StatusNotFound)
+			return
+		}
+		log.Printf("Alarm %s reset", name)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// gpioInput is a requested GPIO input line.
+type gpioInput interface {
+	Value() (bool, error)
+	Close() error
+}
+
+// requestInput requests a GPIO input line. The kernel hands out a line to
+// one holder at a time.
+var requestInput = func(chip string, offset int, activeLow bool) (gpioInput, error) {
+	return gpio.RequestInput(chip, offset, activeLow)
+}
+
+type detectorInput struct {
+	detectorConfig
+	line gpioInput // or
+	expr exprNode
+}
+
+// active returns whether the detector is triggered.
+func (in *detectorInput) active(lookup lookupFunc) (bool, error) {
+	if in.line != nil {
+		return in.line.Value()
+	}
+	v, err := in.expr(lookup)
+	if err != nil {
+		return false, err
+	}
+	return v > in.Above, nil
+}
+
+func registerDetectors(inputs []*detectorInput, gatherer prometheus.Gatherer) func() {
+	triggered := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "detector",
+		Name:      "triggered",
+	}, []string{"name", "kind"})
+
+	alarm := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "detector",
+		Name:      "alarm",
+		Help:      "Latched alarm state; stays set until reset.",
+	}, []string{"name", "kind"})
+
+	return func() {
+		var lookup lookupFunc
+		for _, in := range inputs {
+			if in.expr != nil && lookup == nil {
+				mfs, err := gatherer.Gather()
+				if err != nil {
+					log.Println("Detectors: gather metrics:", err)
+				}
+				lookup = gatheredLookup(mfs)
+			}
+			active, err := in.active(lookup)
+			if err != nil {
+				// A detector we cannot read is as good as a triggered
+				// one.
+				log.Printf("Detector %s: %v", in.Name, err)
+				active = true
+			}
+
+			if active {
+				triggered.WithLabelValues(in.Name, in.Kind).Set(1)
+				raiseAlarm(notice{
+					Alarm:   in.Name,
+					Kind:    in.Kind,
+					Message: fmt.Sprintf("%s detector %s triggered", in.Kind, in.Name),
+				})
+			} else {
+				triggered.WithLabelValues(in.Name, in.Kind).Set(0)
+			}
+
+			if alarms.isRaised(in.Name) {
+				alarm.WithLabelValues(in.Name, in.Kind).Set(1)
+			} else {
+				alarm.WithLabelValues(in.Name, in.Kind).Set(0)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAnalogDetector(t *testing.T) {
+	lpg := newGaugeVec(prometheus.GaugeOpts{Namespace: "sensors", Subsystem: "test_adc", Name: "voltage"}, []string{"channel"})
+	det := detectorConfig{Name: "test-bilge", Kind: "gas", Source: `sensors_test_adc_voltage{channel="lpg"}`, Above: 1.2}
+	if err := validateDetectors([]detectorConfig{det}); err != nil {
+		t.Fatal(err)
+	}
+	expr, _, _ := parseExpr(det.Source)
+	update := registerDetectors([]*detectorInput{{detectorConfig: det, expr: expr}}, prometheus.DefaultGatherer)
+	defer alarms.reset(det.Name)
+
+	lpg.WithLabelValues("lpg").Set(0.4)
+	update()
+	if alarms.isRaised(det.Name) {
+		t.Fatal("expected no alarm below the level")
+	}
+	lpg.WithLabelValues("lpg").Set(1.5)
+	update()
+	if !alarms.isRaised(det.Name) {
+		t.Fatal("expected an alarm above the level")
+	}
+	// Latched.
+	lpg.WithLabelValues("lpg").Set(0.4)
+	update()
+	if !alarms.isRaised(det.Name) {
+		t.Error("expected the alarm to stay raised")
+	}
+}
+
+// heldLines fakes GPIO lines that can be requested by one holder at a time.
+type heldLines struct {
+	mut  sync.Mutex
+	held map[int]bool
+}
+
+func (h *heldLines) request(chip string, offset int, activeLow bool) (gpioInput, error) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.held[offset] {
+		return nil, fmt.Errorf("line %d: device or resource busy", offset)
+	}
+	h.held[offset] = true
+	return &heldLine{lines: h, offset: offset}, nil
+}
+
+type heldLine struct {
+	lines  *heldLines
+	offset int
+}
+
+func (l *heldLine) Value() (bool, error) { return false, nil }
+
+func (l *heldLine) Close() error {
+	time.Sleep(10 * time.Millisecond)
+	l.lines.mut.Lock()
+	defer l.lines.mut.Unlock()
+	delete(l.lines.held, l.offset)
+	return nil
+}
+
+func TestReloadDetectors(t *testing.T) {
+	lines := &heldLines{held: make(map[int]bool)}
+	prevRequest := requestInput
+	requestInput = lines.request
+	defer func() { requestInput = prevRequest }()
+
+	def, _ := sensorDefByName("detectors")
+	prevDefs := sensorDefs
+	sensorDefs = []sensorDef{def}
+	defer func() { sensorDefs = prevDefs }()
+
+	prevConfig := current.Load()
+	defer current.Store(prevConfig)
+	fire := detectorConfig{Name: "test-engine-room", Kind: "flame", Pin: 5}
+	setConfig(options{}, fileSections{Detectors: []detectorConfig{fire}})
+	defer alarms.reset(fire.Name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var running runningSensors
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Adding an analog detector reinitializes the detectors, which must
+	// get the line of the fire detector again.
+	lpg := detectorConfig{Name: "test-bilge", Kind: "gas", Source: "sensors_test_adc_voltage", Above: 1.2}
+	setConfig(options{}, fileSections{Detectors: []detectorConfig{fire, lpg}})
+	defer alarms.reset(lpg.Name)
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 {
+		t.Fatal("expected the detectors running after the reload")
+	}
+	running[0].update()
+	if alarms.isRaised(fire.Name) {
+		t.Error("expected the fire detector readable and not triggered")
+	}
+}
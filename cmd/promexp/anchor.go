@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Anchor watch: the anchor position is marked from the GPS position when
+// the anchor is dropped, and a latched alarm is raised when the boat is
+// further from it than the alarm radius. Controlled and reported at
+// /api/v1/anchor:
+//
+//   POST /api/v1/anchor?action=drop&radius=40   anchor here, 40 m radius
+//   POST /api/v1/anchor?action=radius&radius=50 change the radius
+//   POST /api/v1/anchor?action=raise            stop watching
+//
+// The /map page shows the boat, the recent track, the anchor and the alarm
+// radius, drawn without map tiles so that it works offline. Positions
+// estimated by dead reckoning (see deadreckoning.go) are flagged as such.
+
+const (
+	anchorAlarm         = "anchor"
+	anchorTrackInterval = 10 * time.Second
+	anchorTrackAge      = time.Hour
+)
+
+func init() {
+	registerSensor(sensorDef{
+		// Uses the GPS metrics.
+		name:    "anchor",
+		order:   1,
+		enabled: func(o options) bool { return o.WithAnchor },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.AnchorRadius}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			anchor.setDefaultRadius(cli().AnchorRadius)
+			return registerAnchor(), nil
+		},
+	})
+}
+
+type anchorState struct {
+	mut    sync.Mutex
+	anchor *racePoint
+	radius float64 // metres
+	defRad float64 // metres, when dropped without a radius
+	pos    *racePoint
+	est    bool // pos is estimated
+	track  []trackPoint
+	alarm  bool
+}
+
+type trackPoint struct {
+	racePoint
+	When      time.Time `json:"when"`
+	Estimated bool      `json:"estimated,omitempty"`
+}
+
+var anchor = &anchorState{}
+
+type anchorReport struct {
+	Anchor   *racePoint   `json:"anchor,omitempty"`
+	Radius   *float64     `json:"radius,omitempty"`   // metres
+	Distance *float64     `json:"distance,omitempty"` // metres from the anchor
+	Bearing  *float64     `json:"bearing,omitempty"`  // degrees from the anchor
+	Position *racePoint   `json:"position,omitempty"`
+	Track    []trackPoint `json:"track"`
+	Alarm    bool         `json:"alarm"`
+
+	// Estimated is whether the position is estimated by dead reckoning.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+func (a *anchorState) report() anchorReport {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	rep := anchorReport{Position: a.pos, Track: append([]trackPoint(nil), a.track...), Alarm: a.alarm, Estimated: a.est}
+	if a.anchor == nil {
+		return rep
+	}
+	radius := a.radius
+	rep.Anchor, rep.Radius = a.anchor, &radius
+	if a.pos != nil {
+		x, y := flatXY(*a.anchor, *a.pos)
+		dist := math.Hypot(x, y)
+		brg := math.Mod(math.Atan2(x, y)/math.Pi*180+360, 360)
+		rep.Distance, rep.Bearing = &dist, &brg
+	}
+	return rep
+}
+
+// flatXY returns the position of p in metres east and north of the
+// origin. The distances are small enough to treat the earth as flat.
+func flatXY(origin, p racePoint) (x, y float64) {
+	x = (p.Lon - origin.Lon) / 180 * math.Pi * math.Cos(origin.Lat/180*math.Pi) * raceEarthRadius
+	y = (p.Lat - origin.Lat) / 180 * math.Pi * raceEarthRadius
+	return x, y
+}
+
+// act performs one of the API actions.
+func (a *anchorState) act(action string, radius float64) error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	switch action {
+	case "drop":
+		if a.pos == nil {
+			return fmt.Errorf("no GPS position")
+		}
+		pos := *a.pos
+		a.anchor = &pos
+		a.radius = radius
+		if radius <= 0 {
+			a.radius = a.defRad
+		}
+		log.Printf("Anchor dropped at %.5f, %.5f; alarm radius %.0f m", pos.Lat, pos.Lon, a.radius)
+	case "radius":
+		if radius <= 0 {
+			return fmt.Errorf("radius: must be a positive number")
+		}
+		a.radius = radius
+	case "raise":
+		a.anchor = nil
+		a.alarm = false
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	return nil
+}
+
+func (a *anchorState) setDefaultRadius(radius float64) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.defRad = radius
+}
+
+// setGPS records the position, which may be estimated, adds it to the
+// track and returns whether the boat has newly moved outside the alarm
+// radius.
+func (a *anchorState) setGPS(pos racePoint, estimated bool, now time.Time) bool {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.pos, a.est = &pos, estimated
+
+	if n := len(a.track); n == 0 || now.Sub(a.track[n-1].When) >= anchorTrackInterval {
+		a.track = append(a.track, trackPoint{pos, now, estimated})
+	}
+	i := 0
+	for i < len(a.track) && now.Sub(a.track[i].When) > anchorTrackAge {
+		i++
+	}
+	a.track = a.track[i:]
+
+	if a.anchor == nil {
+		return false
+	}
+	x, y := flatXY(*a.anchor, pos)
+	outside := math.Hypot(x, y) > a.radius
+	newly := outside && !a.alarm
+	a.alarm = outside
+	return newly
+}
+
+func registerAnchor() func() {
+	distance := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "anchor",
+		Name:      "distance_metres",
+		Help:      "Distance from the anchor.",
+	})
+	radius := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "anchor",
+		Name:      "radius_metres",
+		Help:      "Anchor alarm radius.",
+	})
+
+	lat := seriesRef{name: "sensors_gps_position_degrees", labels: map[string]string{"axis": "latitude"}}
+	lon := seriesRef{name: "sensors_gps_position_degrees", labels: map[string]string{"axis": "longitude"}}
+	est := seriesRef{name: "sensors_gps_position_estimated"}
+
+	return func() {
+		if mfs, err := prometheus.DefaultGatherer.Gather(); err == nil {
+			lookup := gatheredLookup(mfs)
+			la, err1 := lookup(lat)
+			lo, err2 := lookup(lon)
+			e, _ := lookup(est)
+			if err1 == nil && err2 == nil && anchor.setGPS(racePoint{la, lo}, e == 1, time.Now()) {
+				r := anchor.report()
+				msg := fmt.Sprintf("anchor dragging, %.0f m from the anchor", *r.Distance)
+				if r.Estimated {
+					msg += " (estimated position, no GPS fix)"
+				}
+				raiseAlarm(notice{
+					Alarm:       anchorAlarm,
+					Kind:        "anchor",
+					Message:     msg,
+					Value:       *r.Distance,
+					Lat:         r.Position.Lat,
+					Lon:         r.Position.Lon,
+					HasPosition: true,
+				})
+			}
+		}
+
+		// Only set while anchored, so that they expire otherwise.
+		r := anchor.report()
+		if r.Radius != nil {
+			radius.Set(*r.Radius)
+		}
+		if r.Distance != nil {
+			distance.Set(*r.Distance)
+		}
+	}
+}
+
+func handleAnchor(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost && !anchorAct(w, req) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(anchor.report())
+}
+
+// anchorAct performs the action of a POST request, and responds with an
+// error and returns false if it fails.
+func anchorAct(w http.ResponseWriter, req *http.Request) bool {
+	var radius float64
+	if s := req.FormValue("radius"); s != "" {
+		var err error
+		if radius, err = strconv.ParseFloat(s, 64); err != nil || radius <= 0 {
+			http.Error(w, "radius: must be a positive number", http.StatusBadRequest)
+			return false
+		}
+	}
+	if err := anchor.act(req.FormValue("action"), radius); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// mapView is the map drawn in a square SVG, mapSize pixels wide, centred
+// on the anchor if there is one and otherwise on the boat.
+type mapView struct {
+	Report   anchorReport
+	Distance float64 // metres from the anchor
+	Radius   float64 // metres
+	Scale    float64 // metres across
+	Track    string  // SVG polyline points
+	Boat     *mapXY
+	Anchor   *mapXY
+	RadiusPx float64
+}
+
+type mapXY struct{ X, Y float64 }
+
+const mapSize = 400
+
+func newMapView(r anchorReport) mapView {
+	v := mapView{Report: r}
+	if r.Distance != nil {
+		v.Distance = *r.Distance
+	}
+	if r.Radius != nil {
+		v.Radius = *r.Radius
+	}
+	var centre racePoint
+	switch {
+	case r.Anchor != nil:
+		centre = *r.Anchor
+	case r.Position != nil:
+		centre = *r.Position
+	default:
+		return v
+	}
+
+	// Fit the alarm circle and the track, with a margin.
+	extent := 50.0
+	if r.Radius != nil {
+		extent = math.Max(extent, *r.Radius*1.2)
+	}
+	for _, p := range r.Track {
+		x, y := flatXY(centre, p.racePoint)
+		extent = math.Max(extent, math.Max(math.Abs(x), math.Abs(y))*1.1)
+	}
+	v.Scale = 2 * extent
+	px := func(p racePoint) mapXY {
+		x, y := flatXY(centre, p)
+		return mapXY{mapSize/2 + x/extent*mapSize/2, mapSize/2 - y/extent*mapSize/2}
+	}
+
+	pts := make([]string, len(r.Track))
+	for i, p := range r.Track {
+		xy := px(p.racePoint)
+		pts[i] = fmt.Sprintf("%.1f,%.1f", xy.X, xy.Y)
+	}
+	v.Track = strings.Join(pts, " ")
+	if r.Position != nil {
+		xy := px(*r.Position)
+		v.Boat = &xy
+	}
+	if r.Anchor != nil {
+		xy := px(*r.Anchor)
+		v.Anchor = &xy
+		v.RadiusPx = v.Radius / extent * mapSize / 2
+	}
+	return v
+}
+
+var mapTpl = template.Must(template.New("map").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>Map</title>
+<style>
+body { font-family: sans-serif; }
+svg { background: #def; border: 1px solid #888; }
+.alarm { color: #c00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Map</h1>
+{{with .Boat}}{{else}}<p>No GPS position.</p>{{end}}
+{{if .Scale}}<svg width="400" height="400" viewBox="0 0 400 400">
+{{with .Anchor}}<circle cx="{{printf "%.1f" .X}}" cy="{{printf "%.1f" .Y}}" r="{{printf "%.1f" $.RadiusPx}}" fill="#fff8" stroke="{{if $.Report.Alarm}}#c00{{else}}#080{{end}}" stroke-width="2"/>
+<text x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" text-anchor="middle" dominant-baseline="central" font-size="16">⚓</text>{{end}}
+<polyline points="{{.Track}}" fill="none" stroke="#06c" stroke-width="1.5"/>
+{{with .Boat}}<circle cx="{{printf "%.1f" .X}}" cy="{{printf "%.1f" .Y}}" r="5" fill="#c60"/>{{end}}
+<text x="8" y="392" font-size="12">{{printf "%.0f" .Scale}} m across, north up</text>
+</svg>{{end}}
+{{with .Report}}
+{{with .Position}}<p>Position {{printf "%.5f" .Lat}}, {{printf "%.5f" .Lon}}{{if $.Report.Estimated}} (estimated, no GPS fix){{end}}</p>{{end}}
+{{if .Anchor}}<p{{if .Alarm}} class="alarm"{{end}}>{{printf "%.0f" $.Distance}} m from the anchor (alarm radius {{printf "%.0f" $.Radius}} m){{if .Alarm}}, outside the radius{{end}}</p>
+<form method="post"><input type="hidden" name="action" value="radius"><input name="radius" size="4" value="{{printf "%.0f" $.Radius}}"> m <button>Set radius</button></form>
+<form method="post"><input type="hidden" name="action" value="raise"><button>Raise anchor</button></form>
+{{else}}<form method="post"><input type="hidden" name="action" value="drop"><input name="radius" size="4" placeholder="radius"> m <button>Drop anchor here</button></form>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// handleMap shows the map, and performs the anchor actions of its forms.
+func handleMap(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		if anchorAct(w, req) {
+			http.Redirect(w, req, req.URL.Path, http.StatusSeeOther)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	mapTpl.Execute(w, newMapView(anchor.report()))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnchorWatch(t *testing.T) {
+	a := &anchorState{defRad: 30}
+	if err := a.act("drop", 0); err == nil {
+		t.Error("expected error dropping without a position")
+	}
+
+	now := time.Now()
+	origin := racePoint{Lat: 57.7, Lon: 11.85}
+	if a.setGPS(origin, false, now) {
+		t.Error("expected no alarm when not anchored")
+	}
+	if err := a.act("drop", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Swinging 20 m north, then dragging 40 m east.
+	if a.setGPS(racePoint{Lat: 57.7 + 20/111195.0, Lon: 11.85}, false, now.Add(time.Minute)) {
+		t.Error("expected no alarm inside the radius")
+	}
+	east := racePoint{Lat: 57.7, Lon: 11.85 + 40/(111195*math.Cos(57.7/180*math.Pi))}
+	if !a.setGPS(east, false, now.Add(2*time.Minute)) {
+		t.Error("expected alarm outside the radius")
+	}
+	if a.setGPS(east, false, now.Add(3*time.Minute)) {
+		t.Error("expected the alarm only once")
+	}
+	rep := a.report()
+	if math.Abs(*rep.Distance-40) > 0.5 || math.Abs(*rep.Bearing-90) > 0.5 || !rep.Alarm {
+		t.Errorf("unexpected report %+v", rep)
+	}
+
+	if err := a.act("radius", 50); err != nil {
+		t.Fatal(err)
+	}
+	if a.setGPS(east, false, now.Add(4*time.Minute)) || a.report().Alarm {
+		t.Error("expected no alarm inside the larger radius")
+	}
+
+	// The track drops points older than the track age: those from the
+	// first two minutes.
+	a.setGPS(east, false, now.Add(anchorTrackAge+90*time.Second))
+	if rep := a.report(); len(rep.Track) != 4 {
+		t.Errorf("expected 4 track points, got %d", len(rep.Track))
+	}
+
+	a.act("raise", 0)
+	if rep := a.report(); rep.Anchor != nil || rep.Distance != nil {
+		t.Errorf("expected no anchor, got %+v", rep)
+	}
+}
+
+func TestMapView(t *testing.T) {
+	a := &anchorState{defRad: 30}
+	a.setGPS(racePoint{Lat: 57.7, Lon: 11.85}, false, time.Now())
+	a.act("drop", 0)
+
+	v := newMapView(a.report())
+	if v.Anchor == nil || v.Anchor.X != mapSize/2 || v.Anchor.Y != mapSize/2 {
+		t.Errorf("expected the anchor centred, got %+v", v.Anchor)
+	}
+	// At least 100 m across.
+	if v.Scale != 100 || v.RadiusPx != 120 {
+		t.Errorf("unexpected scale %v and radius %v", v.Scale, v.RadiusPx)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := mapTpl.Execute(rec, v); err != nil {
+		t.Fatal(err)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "0 m from the anchor (alarm radius 30 m)") {
+		t.Errorf("unexpected page %s", body)
+	}
+}
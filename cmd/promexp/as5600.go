@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/ams"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The AS5600 reads the rudder angle from a magnet on the rudder stock. The
+// zero position is the raw angle with the rudder amidships, as shown by
+// sensors_as5600_raw_angle_degrees; reverse it if the angle is negative to
+// starboard:
+//
+//     sensors:
+//       as5600:
+//         zero: 212.5
+//         reverse: true
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "as5600",
+		section: true,
+		fields:  []string{"rudder_angle"},
+		enabled: func(o options) bool { return o.WithAS5600 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, c.Zero, c.Reverse}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			as5600, err := ams.NewAS5600(bus, conf.address(ams.AS5600DefaultAddress), conf.Zero, conf.Reverse)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("as5600", as5600)
+			return registerAS5600(as5600), nil
+		},
+	})
+}
+
+func registerAS5600(as5600 *ams.AS5600) func() {
+	angle := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "as5600",
+		Name:      "rudder_angle_degrees",
+		Help:      "Rudder angle from amidships, positive to starboard.",
+	})
+
+	raw := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "as5600",
+		Name:      "raw_angle_degrees",
+		Help:      "Angle of the magnet as read, for setting the zero position.",
+	})
+
+	magnitude := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "as5600",
+		Name:      "magnet_magnitude",
+		Help:      "Magnitude of the magnet's field at the sensor, in counts; the magnet is too far or too near when logged as weak or strong.",
+	})
+
+	var wasWeak, wasStrong bool
+	return func() {
+		err := as5600.Refresh(100 * time.Millisecond)
+		if err != nil {
+			log.Println("AS5600:", err)
+			health.failed("as5600", err)
+			return
+		}
+
+		health.ok("as5600")
+		angle.Set(sensorConf("as5600").correct("rudder_angle", as5600.Angle()))
+		raw.Set(as5600.RawAngle())
+		m, weak, strong := as5600.Magnitude()
+		magnitude.Set(float64(m))
+		if weak && !wasWeak {
+			log.Println("AS5600: magnet too weak, move it closer")
+		}
+		if strong && !wasStrong {
+			log.Println("AS5600: magnet too strong, move it further away")
+		}
+		wasWeak, wasStrong = weak, strong
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/calmh/boatpi/nmea"
+)
+
+// Autopilots and plotters want heading several times a second, which is
+// far more often than is useful to export to Prometheus. The heading and
+// attitude sentences are therefore sent at their own rate, from the latest
+// IMU readings; the heading only from an IMU with a compass.
+
+func sendAttitude(ctx context.Context, a *AvgIMU, rate float64) {
+	t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		x, y, z := a.Acceleration()
+		heading, ok := a.Heading()
+		lines := attitudeSentences(heading, x, y, z)
+		if !ok {
+			lines = lines[1:]
+		}
+		for _, line := range lines {
+			nmeaForward(line)
+		}
+	}
+}
+
+// attitudeSentences returns the HDM and XDR sentences for the heading and
+// acceleration. Pitch and roll assume the board is mounted flat with the x
+// axis forward.
+func attitudeSentences(heading float64, x, y, z int16) []string {
+	pitch := math.Atan2(-float64(x), math.Hypot(float64(y), float64(z))) / math.Pi * 180
+	roll := math.Atan2(float64(y), float64(z)) / math.Pi * 180
+	return []string{
+		nmea.FormatSentence("HCHDM", fmt.Sprintf("%.1f", heading), "M"),
+		nmea.FormatSentence("IIXDR", "A", fmt.Sprintf("%.1f", pitch), "D", "PITCH", "A", fmt.Sprintf("%.1f", roll), "D", "ROLL"),
+	}
+}
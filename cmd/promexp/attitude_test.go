@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestAttitudeSentences(t *testing.T) {
+	lines := attitudeSentences(123.45, -1000, 0, 1000)
+	exp := []string{
+		"$HCHDM,123.5,M*2C",
+		"$IIXDR,A,45.0,D,PITCH,A,0.0,D,ROLL*24",
+	}
+	for i := range exp {
+		if lines[i] != exp[i] {
+			t.Errorf("got %q, expected %q", lines[i], exp[i])
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/calmh/boatpi/store"
+)
+
+// The attitude history returns heading and heel pairs from the local
+// store, one per step, for a heel against heading polar plot:
+//
+//   /api/v1/attitude?start=...&end=...&step=1m
+//
+// The heading is --attitude-heading and the heel --display-heel, which
+// depends on how the board is mounted. They are expressions as for the
+// virtual sensors, evaluated per step against the stored series; the
+// series in them must be named with all their labels, as they are
+// stored. Angles are averaged over each step as such. Times and the step
+// are as for the query API, the step defaulting to a minute.
+
+const attitudeDefaultStep = time.Minute
+
+type attitudeResult struct {
+	Heading string       `json:"heading"`
+	Heel    string       `json:"heel"`
+	Values  [][3]float64 `json:"values"` // Unix seconds, heading and heel
+}
+
+func handleAttitudeHistory(w http.ResponseWriter, req *http.Request) {
+	end, err := parseQueryTime(req.FormValue("end"), time.Now())
+	if err != nil {
+		http.Error(w, "end: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, err := parseQueryTime(req.FormValue("start"), end.Add(-queryDefaultRange))
+	if err != nil {
+		http.Error(w, "start: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if end.Before(start) {
+		http.Error(w, "end before start", http.StatusBadRequest)
+		return
+	}
+	step := attitudeDefaultStep
+	if s := req.FormValue("step"); s != "" {
+		if step, err = parseQueryStep(s); err != nil {
+			http.Error(w, "step: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if end.Sub(start)/step > queryMaxPoints {
+		http.Error(w, "step too small for the range", http.StatusBadRequest)
+		return
+	}
+
+	o := cli()
+	res := attitudeResult{Heading: o.AttitudeHeading, Heel: o.DisplayHeel}
+	heading, headingRefs, err := parseExpr(res.Heading)
+	if err != nil {
+		http.Error(w, "heading: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	heel, heelRefs, err := parseExpr(res.Heel)
+	if err != nil {
+		http.Error(w, "heel: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	localStoreMut.RLock()
+	defer localStoreMut.RUnlock()
+	if localStore == nil {
+		http.Error(w, "no local store", http.StatusServiceUnavailable)
+		return
+	}
+	steps, err := stepValues(localStore, append(headingRefs, heelRefs...), start, end, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res.Values = [][3]float64{}
+	for i := 0; i <= int(end.Sub(start)/step); i++ {
+		vals := steps[i]
+		lookup := func(ref seriesRef) (float64, error) {
+			v, ok := vals[ref.String()]
+			if !ok {
+				return 0, fmt.Errorf("no value for %v", ref)
+			}
+			return v, nil
+		}
+		hdg, err := heading(lookup)
+		if err != nil {
+			continue
+		}
+		hl, err := heel(lookup)
+		if err != nil {
+			continue
+		}
+		t := start.Add(time.Duration(i) * step)
+		res.Values = append(res.Values, [3]float64{float64(t.UnixNano()) / 1e9, math.Mod(hdg+360, 360), hl})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// stepValues returns the values of the series in each step of the range,
+// keyed by step index and series name. Steps where a series has no
+// samples lack it.
+func stepValues(st store.Store, refs []seriesRef, start, end time.Time, step time.Duration) (map[int]map[string]float64, error) {
+	res := make(map[int]map[string]float64)
+	for _, ref := range refs {
+		name := ref.String()
+		samples, err := st.Query(name, start, end)
+		if err != nil {
+			return nil, err
+		}
+		agg := store.Aggregations["avg"]
+		if isAngle(ref.name) {
+			agg = store.Aggregations["angle"]
+		}
+		samples, _ = store.Downsample(samples, start, step, agg)
+		for _, s := range samples {
+			i := int(s.Time.Sub(start) / step)
+			if res[i] == nil {
+				res[i] = make(map[string]float64)
+			}
+			res[i][name] = s.Value
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/store"
+)
+
+func TestHandleAttitudeHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attitude")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := store.OpenSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	// Two minutes heading across north, heeled ten degrees, then a
+	// minute with no heel recorded.
+	t0 := time.Unix(1591012800, 0)
+	for i, hdg := range []float64{350, 10, 80, 100, 180} {
+		ts := t0.Add(time.Duration(i) * 30 * time.Second)
+		st.Append(`hdg_degrees{plane="horiz"}`, ts, hdg)
+		if i < 4 {
+			st.Append("yz", ts, 100)
+		}
+	}
+	localStore = st
+	defer func() { localStore = nil }()
+	withOptions(t, func(o *options) {
+		o.AttitudeHeading = `hdg_degrees{plane="horiz"}`
+		o.DisplayHeel = "yz - 90"
+	})
+
+	rec := httptest.NewRecorder()
+	handleAttitudeHistory(rec, httptest.NewRequest("GET", "/api/v1/attitude?start=1591012800&end=1591012980", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	var res attitudeResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Values) != 2 {
+		t.Fatalf("unexpected values %v", res.Values)
+	}
+	if v := res.Values[0]; v[0] != 1591012800 || (v[1] > 1e-6 && v[1] < 360-1e-6) || v[2] != 10 {
+		t.Errorf("unexpected first step %v", v)
+	}
+	if v := res.Values[1]; v[0] != 1591012860 || v[1] < 90-1e-6 || v[1] > 90+1e-6 || v[2] != 10 {
+		t.Errorf("unexpected second step %v", v)
+	}
+
+	rec = httptest.NewRecorder()
+	handleAttitudeHistory(rec, httptest.NewRequest("GET", "/api/v1/attitude?step=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status %d for zero step", rec.Code)
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The HTTP endpoints can require authentication, by any of several
+// backends; a request is let through if one of them accepts it:
+//
+//   - static bearer tokens, given as name:token (--auth-tokens), for
+//     Prometheus and other programs;
+//   - a user file in htpasswd format with bcrypt passwords
+//     (htpasswd -B), for HTTP basic authentication from a browser;
+//   - OpenID Connect ID tokens from an issuer, as bearer tokens, for
+//     access from shore via the hub, which does the interactive login.
+//
+// Without any backend configured everything is open, as before. The health
+// endpoints are always open, for the service manager and load balancers.
+// The backends are set up again on reload, rereading the user file.
+
+type authBackend interface {
+	// authenticate returns the user name if the request carries
+	// credentials the backend accepts.
+	authenticate(req *http.Request) (string, bool)
+}
+
+var auth struct {
+	mut      sync.Mutex
+	backends []authBackend
+	basic    bool // offer basic authentication in challenges
+}
+
+// Paths that never require authentication.
+var openPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// setAuth sets up the authentication backends from the options.
+func setAuth(o options) error {
+	var backends []authBackend
+	if len(o.AuthTokens) > 0 {
+		b, err := newTokenAuth(o.AuthTokens)
+		if err != nil {
+			return err
+		}
+		backends = append(backends, b)
+	}
+	if o.AuthUsers != "" {
+		b, err := loadUserAuth(o.AuthUsers)
+		if err != nil {
+			return err
+		}
+		backends = append(backends, b)
+	}
+	if o.AuthOIDCIssuer != "" {
+		if o.AuthOIDCAudience == "" {
+			return errors.New("--auth-oidc-audience is required with --auth-oidc-issuer")
+		}
+		backends = append(backends, newOIDCAuth(o.AuthOIDCIssuer, o.AuthOIDCAudience))
+	}
+
+	auth.mut.Lock()
+	defer auth.mut.Unlock()
+	auth.backends = backends
+	auth.basic = o.AuthUsers != ""
+	return nil
+}
+
+// requireAuth wraps the handler with the configured authentication.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth.mut.Lock()
+		backends, basic := auth.backends, auth.basic
+		auth.mut.Unlock()
+
+		// Webhooks check their own secrets.
+		if len(backends) == 0 || openPaths[req.URL.Path] || strings.HasPrefix(req.URL.Path, "/webhook/") {
+			next.ServeHTTP(w, req)
+			return
+		}
+		for _, b := range backends {
+			if _, ok := b.authenticate(req); ok {
+				next.ServeHTTP(w, req)
+				return
+			}
+		}
+		if basic {
+			w.Header().Add("WWW-Authenticate", `Basic realm="boatpi"`)
+		}
+		w.Header().Add("WWW-Authenticate", `Bearer realm="boatpi"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// authenticatedUser returns the user the request is authenticated as. It
+// is false if there is no authentication backend, for endpoints that must
+// not be open.
+func authenticatedUser(req *http.Request) (string, bool) {
+	auth.mut.Lock()
+	backends := auth.backends
+	auth.mut.Unlock()
+
+	for _, b := range backends {
+		if user, ok := b.authenticate(req); ok {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	h := req.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
+
+// tokenAuth accepts static bearer tokens.
+type tokenAuth struct {
+	tokens map[string]string // name by token
+}
+
+func newTokenAuth(specs []string) (*tokenAuth, error) {
+	a := &tokenAuth{tokens: make(map[string]string)}
+	for _, spec := range specs {
+		i := strings.IndexByte(spec, ':')
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("auth token %q: expected name:token", spec)
+		}
+		a.tokens[spec[i+1:]] = spec[:i]
+	}
+	return a, nil
+}
+
+func (a *tokenAuth) authenticate(req *http.Request) (string, bool) {
+	tok, ok := bearerToken(req)
+	if !ok {
+		return "", false
+	}
+	for t, name := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(tok)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// userAuth accepts basic authentication against bcrypt hashes. Checking a
+// bcrypt hash takes a good part of a second on a Pi, too long for every
+// request of a polling browser, so accepted credentials are remembered
+// (as a hash) until the file is reloaded.
+type userAuth struct {
+	hashes map[string][]byte // bcrypt hash by user
+
+	mut      sync.Mutex
+	accepted map[[sha256.Size]byte]bool
+}
+
+func loadUserAuth(file string) (*userAuth, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("auth users: %w", err)
+	}
+	defer fd.Close()
+	return parseUserAuth(bufio.NewScanner(fd), file)
+}
+
+func parseUserAuth(sc *bufio.Scanner, file string) (*userAuth, error) {
+	a := &userAuth{hashes: make(map[string][]byte), accepted: make(map[[sha256.Size]byte]bool)}
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		i := strings.IndexByte(s, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", file, line)
+		}
+		hash := []byte(s[i+1:])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("%s:%d: user %s: not a bcrypt hash", file, line, s[:i])
+		}
+		a.hashes[s[:i]] = hash
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("auth users: %w", err)
+	}
+	return a, nil
+}
+
+func (a *userAuth) authenticate(req *http.Request) (string, bool) {
+	user, pass, ok := req.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	hash, ok := a.hashes[user]
+	if !ok {
+		return "", false
+	}
+	key := sha256.Sum256([]byte(user + ":" + pass))
+	a.mut.Lock()
+	accepted := a.accepted[key]
+	a.mut.Unlock()
+	if accepted {
+		return user, true
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(pass)) != nil {
+		return "", false
+	}
+	a.mut.Lock()
+	a.accepted[key] = true
+	a.mut.Unlock()
+	return user, true
+}
+
+// oidcAuth accepts ID tokens signed by the issuer for the audience. The
+// issuer's keys are fetched by discovery on first use, as the boat is often
+// offline when the exporter starts, and fetched again when a token is
+// signed with an unknown key, at most once per oidcRefreshInterval. The
+// fetch happens without holding the lock, so that tokens signed with known
+// keys are not held up by a slow issuer; requests needing the new keys wait
+// for the one fetch in progress.
+type oidcAuth struct {
+	issuer, audience string
+	client           *http.Client
+
+	mut      sync.Mutex
+	keys     map[string]crypto.PublicKey // by key ID
+	fetched  time.Time
+	fetching chan struct{} // closed when the fetch in progress is done
+}
+
+const oidcRefreshInterval = time.Minute
+
+func newOIDCAuth(issuer, audience string) *oidcAuth {
+	return &oidcAuth{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		audience: audience,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type oidcClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Email     string          `json:"email"`
+	Audience  json.RawMessage `json:"aud"` // a string or a list of them
+	Expires   float64         `json:"exp"`
+	NotBefore float64         `json:"nbf"`
+}
+
+func (a *oidcAuth) authenticate(req *http.Request) (string, bool) {
+	tok, ok := bearerToken(req)
+	if !ok || strings.Count(tok, ".") != 2 {
+		return "", false
+	}
+	claims, err := a.verify(tok, time.Now())
+	if err != nil {
+		return "", false
+	}
+	if claims.Email != "" {
+		return claims.Email, true
+	}
+	return claims.Subject, true
+}
+
+// verify checks the signature and claims of the token.
+func (a *oidcAuth) verify(tok string, now time.Time) (oidcClaims, error) {
+	parts := strings.Split(tok, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return oidcClaims{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return oidcClaims{}, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return oidcClaims{}, err
+	}
+
+	var claims oidcClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return oidcClaims{}, err
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != a.issuer {
+		return oidcClaims{}, fmt.Errorf("wrong issuer %q", claims.Issuer)
+	}
+	var auds []string
+	if json.Unmarshal(claims.Audience, &auds) != nil {
+		var aud string
+		json.Unmarshal(claims.Audience, &aud)
+		auds = []string{aud}
+	}
+	found := false
+	for _, aud := range auds {
+		found = found || aud == a.audience
+	}
+	if !found {
+		return oidcClaims{}, errors.New("wrong audience")
+	}
+	unix := float64(now.Unix())
+	if claims.Expires == 0 || unix >= claims.Expires {
+		return oidcClaims{}, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && unix < claims.NotBefore {
+		return oidcClaims{}, errors.New("token not yet valid")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			break
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig)
+	case *ecdsa.PublicKey:
+		if alg != "ES256" {
+			break
+		}
+		if len(sig) != 64 {
+			return errors.New("bad signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, sum[:], r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q for the key", alg)
+}
+
+// key returns the issuer's key with the ID, fetching the keys if it is
+// not known.
+func (a *oidcAuth) key(kid string) (crypto.PublicKey, error) {
+	a.mut.Lock()
+	if key, ok := a.keys[kid]; ok {
+		a.mut.Unlock()
+		return key, nil
+	}
+	if done := a.fetching; done != nil {
+		a.mut.Unlock()
+		<-done
+		return a.knownKey(kid)
+	}
+	if time.Since(a.fetched) < oidcRefreshInterval {
+		a.mut.Unlock()
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	a.fetched = time.Now()
+	done := make(chan struct{})
+	a.fetching = done
+	a.mut.Unlock()
+
+	keys, err := a.fetchKeys()
+	a.mut.Lock()
+	if err == nil {
+		a.keys = keys
+	}
+	a.fetching = nil
+	a.mut.Unlock()
+	close(done)
+
+	if err != nil {
+		log.Println("OIDC:", err)
+		return nil, err
+	}
+	return a.knownKey(kid)
+}
+
+// knownKey returns the key with the ID among those already fetched.
+func (a *oidcAuth) knownKey(kid string) (crypto.PublicKey, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (a *oidcAuth) fetchKeys() (map[string]crypto.PublicKey, error) {
+	var disco struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := a.getJSON(a.issuer+"/.well-known/openid-configuration", &disco); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	var set jwkSet
+	if err := a.getJSON(disco.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("keys: %w", err)
+	}
+	return set.publicKeys(), nil
+}
+
+func (a *oidcAuth) getJSON(url string, v interface{}) error {
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jwkSet struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	} `json:"keys"`
+}
+
+// publicKeys returns the RSA and P-256 keys in the set; others are
+// skipped, as are EC points not on the curve.
+func (s jwkSet) publicKeys() map[string]crypto.PublicKey {
+	keys := make(map[string]crypto.PublicKey)
+	num := func(s string) (*big.Int, bool) {
+		bs, err := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(bs), err == nil && len(bs) > 0
+	}
+	for _, k := range s.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, ok1 := num(k.N)
+			e, ok2 := num(k.E)
+			if ok1 && ok2 && e.IsInt64() {
+				keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+			}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, ok1 := num(k.X)
+			y, ok2 := num(k.Y)
+			if ok1 && ok2 && elliptic.P256().IsOnCurve(x, y) {
+				keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+			}
+		}
+	}
+	return keys
+}
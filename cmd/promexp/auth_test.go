@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestRequireAuth(t *testing.T) {
+	defer setAuth(options{})
+	if err := setAuth(options{AuthTokens: []string{"prometheus:s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+	h := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	cases := []struct {
+		path, authorization string
+		code                int
+	}{
+		{"/metrics", "", http.StatusUnauthorized},
+		{"/metrics", "Bearer wrong", http.StatusUnauthorized},
+		{"/metrics", "Bearer s3cret", http.StatusOK},
+		{"/metrics", "bearer s3cret", http.StatusOK},
+		{"/healthz", "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("%s with %q: got %d, expected %d", tc.path, tc.authorization, rec.Code, tc.code)
+		}
+	}
+
+	if err := setAuth(options{AuthTokens: []string{"nocolon"}}); err == nil {
+		t.Error("expected error for token without name")
+	}
+}
+
+func TestUserAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := "# crew\nskipper:" + string(hash) + "\n"
+	a, err := parseUserAuth(bufio.NewScanner(strings.NewReader(file)), "users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		// The second time from the accepted cache.
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("skipper", "hunter2")
+		if user, ok := a.authenticate(req); !ok || user != "skipper" {
+			t.Errorf("expected skipper accepted, got %q %v", user, ok)
+		}
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("skipper", "hunter3")
+	if _, ok := a.authenticate(req); ok {
+		t.Error("expected wrong password rejected")
+	}
+
+	if _, err := parseUserAuth(bufio.NewScanner(strings.NewReader("mate:plaintext\n")), "users"); err == nil {
+		t.Error("expected error for a password that is not a bcrypt hash")
+	}
+}
+
+func TestOIDCAuth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": srv.URL + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kid": "k1", "kty": "EC", "crv": "P-256",
+				"x": b64(key.X.Bytes()), "y": b64(key.Y.Bytes()),
+			}}})
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	sign := func(claims map[string]interface{}) string {
+		hdr, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "k1"})
+		body, _ := json.Marshal(claims)
+		signed := b64(hdr) + "." + b64(body)
+		sum := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):], rb)
+		copy(sig[64-len(sb):], sb)
+		return signed + "." + b64(sig)
+	}
+
+	a := newOIDCAuth(srv.URL+"/", "boatpi")
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	cases := []struct {
+		claims map[string]interface{}
+		ok     bool
+	}{
+		{map[string]interface{}{"iss": srv.URL, "aud": "boatpi", "exp": exp, "email": "skipper@example.com"}, true},
+		{map[string]interface{}{"iss": srv.URL, "aud": []string{"hub", "boatpi"}, "exp": exp, "sub": "1"}, true},
+		{map[string]interface{}{"iss": srv.URL, "aud": "hub", "exp": exp}, false},
+		{map[string]interface{}{"iss": "https://example.com", "aud": "boatpi", "exp": exp}, false},
+		{map[string]interface{}{"iss": srv.URL, "aud": "boatpi", "exp": exp - 7200}, false},
+	}
+	for i, tc := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+sign(tc.claims))
+		if _, ok := a.authenticate(req); ok != tc.ok {
+			t.Errorf("case %d: got %v, expected %v", i, ok, tc.ok)
+		}
+	}
+
+	// A tampered token is rejected.
+	tok := sign(cases[0].claims)
+	parts := strings.Split(tok, ".")
+	parts[1] = b64([]byte(`{"iss":"` + srv.URL + `","aud":"boatpi","exp":9999999999,"sub":"mallory"}`))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+strings.Join(parts, "."))
+	if _, ok := a.authenticate(req); ok {
+		t.Error("expected tampered token rejected")
+	}
+}
+
+func TestOIDCKeyFetch(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	var srv *httptest.Server
+	var discoveries int32
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			atomic.AddInt32(&discoveries, 1)
+			requested <- struct{}{}
+			<-release
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": srv.URL + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+				{"kid": "k2", "kty": "EC", "crv": "P-256", "x": b64(key.X.Bytes()), "y": b64(key.Y.Bytes())},
+				// Not on the curve.
+				{"kid": "k3", "kty": "EC", "crv": "P-256", "x": b64(key.X.Bytes()), "y": b64(key.X.Bytes())},
+			}})
+		}
+	}))
+	defer srv.Close()
+
+	a := newOIDCAuth(srv.URL, "boatpi")
+	a.keys = map[string]crypto.PublicKey{"k1": &key.PublicKey}
+
+	// Two requests for a new key share one fetch...
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := a.key("k2")
+			errs <- err
+		}()
+	}
+	<-requested
+
+	// ... which does not hold up known keys.
+	if _, err := a.key("k1"); err != nil {
+		t.Error(err)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if n := atomic.LoadInt32(&discoveries); n != 1 {
+		t.Errorf("%d discovery requests, expected 1", n)
+	}
+	if _, err := a.key("k3"); err == nil {
+		t.Error("expected the point off the curve to be rejected")
+	}
+}
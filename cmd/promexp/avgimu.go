@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/sensor"
+)
+
+// An IMU is an accelerometer, such as the LSM9DS1, MPU6050 or ICM-20948,
+// giving raw counts at the nominal sensitivity. Those with a magnetometer
+// are also an imuCompass, those with a gyroscope an imuGyro, and those that
+// fuse them into an orientation on the chip, like the BNO055, an
+// imuOrientation.
+type IMU interface {
+	Refresh(age time.Duration) error
+	Acceleration() (x, y, z int16)
+	// AccelerationSamples returns the samples read on the last refresh,
+	// oldest first, AccelerationRate per second.
+	AccelerationSamples() []sensor.Point
+	AccelerationRate(refresh time.Duration) float64
+	AccelerationAngles() (xy, xz, yz float64)
+	Temperature() float64
+}
+
+type imuCompass interface {
+	// Compass returns the calibrated compass angle in each plane.
+	Compass() (xy, xz, yz float64)
+	MagneticField() (x, y, z int16)
+}
+
+type imuGyro interface {
+	// Rotation returns the rate of turn around each axis, in °/s.
+	Rotation() (x, y, z float64)
+}
+
+type imuOrientation interface {
+	// Orientation returns the tilt compensated heading, the roll and the
+	// pitch, in degrees.
+	Orientation() (heading, roll, pitch float64)
+}
+
+// AvgIMU keeps the acceleration samples of the last window of time,
+// each with its time, so that the statistics cover the same time
+// whatever the poll interval and however many samples went missing.
+type AvgIMU struct {
+	IMU
+	name    string // of the sensor, for the health
+	window  time.Duration
+	intv    time.Duration
+	slow    time.Duration   // when still, if adaptive
+	motion  *motionDetector // nil unless adaptive
+	done    chan struct{}
+	mut     sync.Mutex
+	samples []accelSample
+	cur     time.Duration // current poll interval
+}
+
+type accelSample struct {
+	t      time.Time
+	angles [3]float64
+}
+
+func NewAvgIMU(ctx context.Context, name string, window, intv time.Duration, imu IMU) *AvgIMU {
+	a := newAvgIMU(name, window, intv, imu)
+	go a.serve(ctx)
+	return a
+}
+
+// NewAdaptiveAvgIMU returns an AvgIMU that polls at the fast interval
+// when underway and at the slow one when still, going by the motion
+// threshold in degrees.
+func NewAdaptiveAvgIMU(ctx context.Context, name string, window, fast, slow time.Duration, threshold float64, imu IMU) *AvgIMU {
+	a := newAvgIMU(name, window, fast, imu)
+	a.slow = slow
+	a.cur = slow
+	a.motion = &motionDetector{threshold: threshold}
+	go a.serve(ctx)
+	return a
+}
+
+func newAvgIMU(name string, window, intv time.Duration, imu IMU) *AvgIMU {
+	size := int(window.Seconds() * imu.AccelerationRate(intv))
+	return &AvgIMU{
+		IMU:     imu,
+		name:    name,
+		window:  window,
+		intv:    intv,
+		cur:     intv,
+		done:    make(chan struct{}),
+		samples: make([]accelSample, 0, size),
+	}
+}
+
+// PollInterval returns the current poll interval and whether the boat is
+// considered underway; always true unless adaptive.
+func (a *AvgIMU) PollInterval() (time.Duration, bool) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return a.cur, a.cur == a.intv
+}
+
+// Done is closed when the context is done and the sensor is no longer
+// being read.
+func (a *AvgIMU) Done() <-chan struct{} {
+	return a.done
+}
+
+func (a *AvgIMU) serve(ctx context.Context) {
+	defer close(a.done)
+	intv, _ := a.PollInterval()
+	t := time.NewTicker(intv)
+	defer func() { t.Stop() }()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := a.IMU.Refresh(intv / 2); err != nil {
+			log.Printf("refresh %s: %v", a.name, err)
+			health.failed(a.name, err)
+			continue
+		}
+		health.ok(a.name)
+		if next := a.update(); next != intv {
+			intv = next
+			t.Stop()
+			t = time.NewTicker(intv)
+		}
+	}
+}
+
+// update adds the new samples and returns the poll interval to use.
+func (a *AvgIMU) update() time.Duration {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.add(time.Now(), a.IMU.AccelerationSamples(), a.IMU.AccelerationRate(a.cur))
+
+	if a.motion != nil && len(a.samples) > 0 {
+		if a.motion.observe(time.Now(), a.samples[len(a.samples)-1].angles) {
+			a.cur = a.intv
+		} else {
+			a.cur = a.slow
+		}
+	}
+	return a.cur
+}
+
+// add adds the samples, read at the time and taken at the rate (per
+// second), and drops those that have fallen out of the window.
+func (a *AvgIMU) add(now time.Time, points []sensor.Point, rate float64) {
+	step := time.Duration(float64(time.Second) / rate)
+	for i, p := range points {
+		x, y, z := p.X, p.Y, p.Z
+		a.samples = append(a.samples, accelSample{
+			t:      now.Add(-time.Duration(len(points)-1-i) * step),
+			angles: [3]float64{angle(float64(y), float64(x)), angle(float64(z), float64(x)), angle(float64(z), float64(y))},
+		})
+	}
+
+	cutoff := now.Add(-a.window)
+	i := 0
+	for i < len(a.samples) && !a.samples[i].t.After(cutoff) {
+		i++
+	}
+	if i > 0 {
+		n := copy(a.samples, a.samples[i:])
+		a.samples = a.samples[:n]
+	}
+}
+
+// MedianAccelerationAngles returns the median of each acceleration angle
+// over the window.
+func (a *AvgIMU) MedianAccelerationAngles() (xy, xz, yz float64) {
+	qs := a.AccelerationAngleQuantiles(0.5)
+	return qs[0][0], qs[0][1], qs[0][2]
+}
+
+// AccelerationAngleQuantiles returns the given quantiles, between 0 and 1,
+// of each acceleration angle (xy, xz, yz) over the window.
+func (a *AvgIMU) AccelerationAngleQuantiles(qs ...float64) [][3]float64 {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	res := make([][3]float64, len(qs))
+	if len(a.samples) == 0 {
+		return res
+	}
+	vals := make([]float64, len(a.samples))
+	for axis := 0; axis < 3; axis++ {
+		for i, s := range a.samples {
+			vals[i] = s.angles[axis]
+		}
+		sort.Float64s(vals)
+		for i, q := range qs {
+			res[i][axis] = vals[int(q*float64(len(vals)-1)+0.5)]
+		}
+	}
+	return res
+}
+
+// Deviation returns the range of each acceleration angle over the window.
+func (a *AvgIMU) Deviation() (xy, xz, yz float64) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	if len(a.samples) == 0 {
+		return 0, 0, 0
+	}
+	min := a.samples[0].angles
+	max := a.samples[0].angles
+	for _, s := range a.samples[1:] {
+		for i, v := range s.angles {
+			if v < min[i] {
+				min[i] = v
+			}
+			if v > max[i] {
+				max[i] = v
+			}
+		}
+	}
+	return max[0] - min[0], max[1] - min[1], max[2] - min[2]
+}
+
+// Heading returns the compass angle in the plane that is currently
+// horizontal, going by which axis gravity is along, or the fused heading
+// of an IMU that has one. It returns false for an IMU without a compass.
+func (a *AvgIMU) Heading() (float64, bool) {
+	if o, ok := a.IMU.(imuOrientation); ok {
+		heading, _, _ := o.Orientation()
+		return heading, true
+	}
+	c, ok := a.IMU.(imuCompass)
+	if !ok {
+		return 0, false
+	}
+	x, y, z := a.IMU.Acceleration()
+	xy, xz, yz := c.Compass()
+	x = abs(x)
+	y = abs(y)
+	z = abs(z)
+	switch {
+	case x > y && x > z:
+		// x is down
+		return yz, true
+	case y > x && y > z:
+		// y is down
+		return xz, true
+	case z > x && z > y:
+		// z is down
+		return xy, true
+	}
+	return 0, true
+}
+
+func abs(v int16) int16 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func angle(y, x float64) float64 {
+	v := math.Atan2(y, x) / math.Pi * 180
+	for v > 180 {
+		v -= 360
+	}
+	for v < -180 {
+		v += 360
+	}
+	return v
+}
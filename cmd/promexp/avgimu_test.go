@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/sensor"
+)
+
+func TestAvgIMUWindow(t *testing.T) {
+	a := &AvgIMU{window: time.Minute}
+	level := []sensor.Point{{X: 0, Y: 0, Z: 1000}}
+	heeled := []sensor.Point{{X: 0, Y: 500, Z: 866}}
+
+	// A heel half a minute ago is within the window, whatever the poll
+	// interval has been since.
+	t0 := time.Now()
+	a.add(t0, heeled, 1)
+	for i := 1; i <= 30; i += 10 {
+		a.add(t0.Add(time.Duration(i)*time.Second), level, 1)
+	}
+	if _, _, yz := a.Deviation(); yz < 29 || yz > 31 {
+		t.Errorf("deviation %v, expected 30", yz)
+	}
+
+	// A minute later it has fallen out, although there have been few
+	// samples since.
+	a.add(t0.Add(61*time.Second), level, 1)
+	if _, _, yz := a.Deviation(); yz != 0 {
+		t.Errorf("deviation %v after the heel left the window", yz)
+	}
+	if len(a.samples) != 3 {
+		t.Errorf("%d samples in the window, expected 3", len(a.samples))
+	}
+
+	// FIFO samples are spread back in time at the rate.
+	a.add(t0.Add(62*time.Second), []sensor.Point{level[0], level[0], level[0]}, 10)
+	if s := a.samples[len(a.samples)-3]; !s.t.Equal(t0.Add(62*time.Second - 200*time.Millisecond)) {
+		t.Errorf("first FIFO sample at %v", s.t.Sub(t0))
+	}
+}
+
+func TestAvgIMUQuantiles(t *testing.T) {
+	a := &AvgIMU{window: time.Minute}
+	t0 := time.Now()
+	// Heeling 0, 1, ..., 20 degrees, out of order.
+	for i, deg := range []int{20, 3, 7, 0, 12, 5, 18, 1, 9, 15, 4, 2, 11, 6, 19, 8, 14, 10, 17, 13, 16} {
+		rad := float64(deg) / 180 * math.Pi
+		p := sensor.Point{Y: int16(1000 * math.Sin(rad)), Z: int16(1000 * math.Cos(rad))}
+		a.add(t0.Add(time.Duration(i)*time.Second), []sensor.Point{p}, 1)
+	}
+
+	qs := a.AccelerationAngleQuantiles(0, 0.5, 1)
+	for i, exp := range []float64{70, 80, 90} {
+		if math.Abs(qs[i][2]-exp) > 0.2 {
+			t.Errorf("quantile %d: yz angle %v, expected %v", i, qs[i][2], exp)
+		}
+	}
+	if _, _, yz := a.MedianAccelerationAngles(); yz != qs[1][2] {
+		t.Errorf("median %v, expected %v", yz, qs[1][2])
+	}
+}
+
+func TestAvgIMUHeading(t *testing.T) {
+	// The compass of a board mounted on its side is in the yz plane, but
+	// a fused heading is used as it is.
+	a := &AvgIMU{IMU: compassIMU{}}
+	if h, ok := a.Heading(); !ok || h != 30 {
+		t.Errorf("heading %v, %v, expected the yz plane 30", h, ok)
+	}
+	a = &AvgIMU{IMU: fusedIMU{}}
+	if h, ok := a.Heading(); !ok || h != 245 {
+		t.Errorf("heading %v, %v, expected the fused 245", h, ok)
+	}
+}
+
+type compassIMU struct{ IMU }
+
+func (compassIMU) Acceleration() (x, y, z int16)  { return 1000, 0, 0 }
+func (compassIMU) Compass() (xy, xz, yz float64)  { return 10, 20, 30 }
+func (compassIMU) MagneticField() (x, y, z int16) { return 0, 0, 0 }
+
+type fusedIMU struct{ compassIMU }
+
+func (fusedIMU) Orientation() (heading, roll, pitch float64) { return 245, -90, 0 }
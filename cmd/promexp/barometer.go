@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Barometers, the LPS25H, the MS5611 and the BMP388, export the same
+// metrics under their own names and each keep a pressure history for the
+// tendency. The forecast is made from one of them, in that order of
+// preference.
+
+// pressureHistoryFile returns the history file of the named barometer: the
+// configured one for the LPS25H, and with the sensor name inserted before
+// the extension for the others.
+func pressureHistoryFile(file, name string) string {
+	if name == "lps25h" {
+		return file
+	}
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + name + ext
+}
+
+// forecastWind returns the parsed forecast wind direction expression, or
+// nil if there is none.
+func forecastWind() (exprNode, error) {
+	if cli().ForecastWind == "" {
+		return nil, nil
+	}
+	wind, _, err := parseExpr(cli().ForecastWind)
+	if err != nil {
+		return nil, fmt.Errorf("forecast wind: %w", err)
+	}
+	return wind, nil
+}
+
+// registerBarometer exports the pressure and temperature of the named
+// sensor, with the squall warning, the pressure tendency and, if
+// updateForecast is not nil, the forecast.
+func registerBarometer(name string, baro *SquallDetector, updateForecast func(p, delta float64)) func() {
+	press := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "pressure_mb",
+	})
+
+	temp := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "temperature_celsius",
+	})
+
+	jump := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "pressure_jump_mb",
+	})
+
+	deviation := newHistogram(prometheus.HistogramOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "pressure_deviation_mb_histogram",
+		Help:      "Deviation of the pressure samples from their mean over the last minute.",
+		Buckets:   []float64{-2, -1, -0.5, -0.2, -0.1, -0.05, 0, 0.05, 0.1, 0.2, 0.5, 1, 2},
+	})
+
+	warning := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "squall_warning",
+	})
+
+	tendency := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "pressure_tendency_mb",
+		Help:      "Pressure change over the last three hours.",
+	})
+
+	tendencyState := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "pressure_tendency_state",
+		Help:      "1 for the current tendency: rising, steady or falling.",
+	}, []string{"state"})
+
+	history := loadPressureTendency(pressureHistoryFile(cli().PressureHistoryFile, name), time.Now())
+
+	return func() {
+		baro.SetThreshold(cli().SquallThreshold)
+		jump.Set(baro.PressureJump())
+		for _, dev := range baro.TakeDeviations() {
+			deviation.Observe(dev)
+		}
+		if baro.Warning() {
+			warning.Set(1)
+		} else {
+			warning.Set(0)
+		}
+
+		if err := baro.Refresh(time.Second); err != nil {
+			log.Printf("%s: %v", strings.ToUpper(name), err)
+			health.failed(name, err)
+			return
+		}
+
+		health.ok(name)
+		conf := sensorConf(name)
+		p := conf.correct("pressure", baro.Pressure())
+		press.Set(p)
+		temp.Set(conf.correct("temperature", baro.Temperature()))
+
+		now := time.Now()
+		history.observe(now, p)
+		if delta, ok := history.tendency(now); ok {
+			tendency.Set(delta)
+			if updateForecast != nil {
+				updateForecast(p, delta)
+			}
+			cur := pressureTendencyState(delta)
+			for _, state := range []string{"rising", "steady", "falling"} {
+				val := 0.0
+				if state == cur {
+					val = 1
+				}
+				tendencyState.WithLabelValues(state).Set(val)
+			}
+		}
+	}
+}
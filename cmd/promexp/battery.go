@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// State of charge from the resting voltage of a 12 V battery, by
+// chemistry. The voltage is only a fair guide with no load or charge
+// current for a while; under load it reads low and while charging high.
+
+// batteryState is for flooded lead acid, the default.
+var batteryState = interpolation{
+	x: []float64{11.8, 12.0, 12.2, 12.4, 12.7},
+	y: []float64{0, 25.0, 50.0, 75.0, 100},
+}
+
+var batteryChemistries = map[string]interpolation{
+	"flooded": batteryState,
+	"agm": {
+		x: []float64{11.8, 12.0, 12.3, 12.6, 12.85},
+		y: []float64{0, 25, 50, 75, 100},
+	},
+	"gel": {
+		x: []float64{11.8, 12.0, 12.35, 12.65, 12.85},
+		y: []float64{0, 25, 50, 75, 100},
+	},
+	// The LiFePO4 curve is flat through the middle, so small voltage
+	// errors make for large errors in the estimate there.
+	"lifepo4": {
+		x: []float64{10.0, 12.0, 12.8, 13.0, 13.1, 13.2, 13.3, 13.4, 13.6},
+		y: []float64{0, 10, 20, 30, 40, 70, 90, 99, 100},
+	},
+}
+
+type batteryConfig struct {
+	Chemistry string       `yaml:"chemistry"`
+	Curve     [][2]float64 `yaml:"curve"` // voltage and percent pairs
+
+	// For coulomb counting, see coulomb.go
+	Current          string  `yaml:"current"`  // expression, amperes, positive when charging
+	Capacity         float64 `yaml:"capacity"` // Ah at the 20 hour rate
+	Peukert          float64 `yaml:"peukert"`
+	ChargeEfficiency float64 `yaml:"charge-efficiency"`
+	FullVoltage      float64 `yaml:"full-voltage"`
+}
+
+// curve returns the voltage to state of charge curve for the battery.
+func (c batteryConfig) curve() interpolation {
+	if len(c.Curve) > 0 {
+		var n interpolation
+		for _, p := range c.Curve {
+			n.x = append(n.x, p[0])
+			n.y = append(n.y, p[1])
+		}
+		return n
+	}
+	if n, ok := batteryChemistries[c.Chemistry]; ok {
+		return n
+	}
+	return batteryState
+}
+
+// batteryCurve returns the curve for the battery on the Omini channel.
+func batteryCurve(channel string) interpolation {
+	return sections().Batteries[channel].curve()
+}
+
+func validateBatteries(bats map[string]batteryConfig) error {
+	var chems []string
+	for name := range batteryChemistries {
+		chems = append(chems, name)
+	}
+	sort.Strings(chems)
+
+	for ch, bat := range bats {
+		if ch != "a" && ch != "b" && ch != "c" {
+			return fmt.Errorf("battery %s: unknown Omini channel (valid: a, b, c)", ch)
+		}
+		if bat.Chemistry != "" && len(bat.Curve) > 0 {
+			return fmt.Errorf("battery %s: both chemistry and curve given", ch)
+		}
+		if _, ok := batteryChemistries[bat.Chemistry]; bat.Chemistry != "" && !ok {
+			return fmt.Errorf("battery %s: unknown chemistry %q (valid: %s)", ch, bat.Chemistry, strings.Join(chems, ", "))
+		}
+		if len(bat.Curve) == 1 {
+			return fmt.Errorf("battery %s: curve needs at least two points", ch)
+		}
+		for i := 1; i < len(bat.Curve); i++ {
+			if bat.Curve[i][0] <= bat.Curve[i-1][0] {
+				return fmt.Errorf("battery %s: curve voltages must be increasing", ch)
+			}
+		}
+		if bat.Current != "" {
+			if _, _, err := parseExpr(bat.Current); err != nil {
+				return fmt.Errorf("battery %s: current: %w", ch, err)
+			}
+			if bat.Capacity <= 0 {
+				return fmt.Errorf("battery %s: coulomb counting needs the capacity", ch)
+			}
+		}
+		if bat.Peukert != 0 && (bat.Peukert < 1 || bat.Peukert > 2) {
+			return fmt.Errorf("battery %s: Peukert exponent %v out of range (1 to 2)", ch, bat.Peukert)
+		}
+		if bat.ChargeEfficiency < 0 || bat.ChargeEfficiency > 1 {
+			return fmt.Errorf("battery %s: charge efficiency %v out of range (0 to 1)", ch, bat.ChargeEfficiency)
+		}
+	}
+	return nil
+}
+
+type interpolation struct {
+	x, y []float64
+}
+
+func (n interpolation) val(x float64) float64 {
+	if x <= n.x[0] {
+		return n.y[0]
+	}
+	for i := 1; i < len(n.x); i++ {
+		if x <= n.x[i] {
+			return n.y[i-1] + (x-n.x[i-1])*(n.y[i]-n.y[i-1])/(n.x[i]-n.x[i-1])
+		}
+	}
+	return n.y[len(n.y)-1]
+}
@@ -1,6 +1,9 @@
 package main
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestBatteryState(t *testing.T) {
 	t.Log(batteryState.val(11))
@@ -10,3 +13,38 @@ func TestBatteryState(t *testing.T) {
 	t.Log(batteryState.val(12.9))
 	t.Log(batteryState.val(13))
 }
+
+func TestBatteryCurves(t *testing.T) {
+	const conf = `
+batteries:
+  a:
+    chemistry: lifepo4
+  b:
+    curve: [[11.9, 0], [12.5, 100]]
+`
+	secs, _, err := loadSections(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bats := secs.Batteries
+	if v := bats["a"].curve().val(13.25); v != 80 {
+		t.Errorf("lifepo4 at 13.25 V: %v %%, expected 80", v)
+	}
+	if v := bats["b"].curve().val(12.2); v < 50-1e-9 || v > 50+1e-9 {
+		t.Errorf("custom curve at 12.2 V: %v %%, expected 50", v)
+	}
+	if v := bats["c"].curve().val(12.2); v != 50 {
+		t.Errorf("default curve at 12.2 V: %v %%, expected 50", v)
+	}
+
+	for _, conf := range []string{
+		"batteries:\n  d:\n    chemistry: agm\n",
+		"batteries:\n  a:\n    chemistry: nicd\n",
+		"batteries:\n  a:\n    curve: [[12, 0]]\n",
+		"batteries:\n  a:\n    curve: [[12.5, 100], [12, 0]]\n",
+	} {
+		if _, _, err := loadSections(strings.NewReader(conf)); err == nil {
+			t.Errorf("expected error for %q", conf)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/calmh/boatpi/ble"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Bluetooth LE sensors broadcast their readings in advertisements, picked
+// up by scanning with --with-ble. They are named by address in their own
+// section:
+//
+//   ble:
+//     saloon:
+//       address: C4:7C:8D:6A:12:34
+//     forepeak:
+//       address: A4:C1:38:6A:12:34
+//       key: e9ea895fac7cca6d30532432a516f3a8
+//
+// The key is the bind key of a Xiaomi thermometer on the stock firmware,
+// which encrypts its advertisements.
+//
+// The readings of the devices understood by a decoder in the ble package,
+// which knows RuuviTags, Xiaomi thermometers and Mopeka gas bottle
+// sensors, are exported as
+// sensors_ble_<reading>_<unit>{device="<address>", name="<name>"}, such
+// as sensors_ble_temperature_celsius, with the signal strength as
+// sensors_ble_rssi_dbm, also for named devices no decoder understands.
+// Devices not named have an empty name, unless --ble-known-only ignores
+// them, as is best in a marina full of other boats' tags. The Mopeka
+// sensors' sensors_ble_level_metres gives the propane fill percentage as
+// the level of a tank with the bottle's height, see tank.go. Devices with
+// both temperature and humidity, such as RuuviTags in the lockers, are
+// also moisture sources, by name or, if not named, by address.
+
+type bleConfig struct {
+	Address string `yaml:"address"`
+	Key     string `yaml:"key"` // hex
+}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "ble",
+		enabled: func(o options) bool { return o.WithBLE },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.BLEAdapter, o.BLEKnownOnly, sections().BLE}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			advs, err := ble.Scan(ctx, cli().BLEAdapter)
+			if err != nil {
+				return nil, err
+			}
+			s := newBLEScanner(sections().BLE, cli().BLEKnownOnly)
+			go s.run(advs)
+			return registerBLE(s), nil
+		},
+	})
+}
+
+func validateBLE(devs map[string]bleConfig) error {
+	names := make([]string, 0, len(devs))
+	for name := range devs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	used := make(map[string]string)
+	for _, name := range names {
+		addr := strings.ToUpper(devs[name].Address)
+		if !ble.ValidAddress(addr) {
+			return fmt.Errorf("ble %s: invalid address %q (e.g. C4:7C:8D:6A:12:34)", name, devs[name].Address)
+		}
+		if other, ok := used[addr]; ok {
+			return fmt.Errorf("ble %s: address %s already used by %s", name, addr, other)
+		}
+		used[addr] = name
+		if key := devs[name].Key; key != "" && !ble.ValidKey(key) {
+			return fmt.Errorf("ble %s: invalid key (32 hex digits)", name)
+		}
+	}
+	return nil
+}
+
+// bleDevice is the latest from a device, with the latest of each reading,
+// as some devices send only some in each advertisement.
+type bleDevice struct {
+	name string
+	adv  ble.Advertisement
+	tag  ble.Tag
+	seq  uint64 // advertisements received
+}
+
+type bleScanner struct {
+	names     map[string]string // by address
+	keys      map[string][]byte // by address
+	knownOnly bool
+
+	mut     sync.Mutex
+	devices map[string]*bleDevice // by address
+}
+
+func newBLEScanner(devs map[string]bleConfig, knownOnly bool) *bleScanner {
+	s := &bleScanner{
+		names:     make(map[string]string),
+		keys:      make(map[string][]byte),
+		knownOnly: knownOnly,
+		devices:   make(map[string]*bleDevice),
+	}
+	for name, dev := range devs {
+		addr := strings.ToUpper(dev.Address)
+		s.names[addr] = name
+		if dev.Key != "" {
+			s.keys[addr], _ = hex.DecodeString(dev.Key)
+		}
+	}
+	return s
+}
+
+func (s *bleScanner) run(advs <-chan ble.Advertisement) {
+	for adv := range advs {
+		s.handle(adv)
+	}
+}
+
+// handle keeps the advertisement if it is from a named device or one a
+// decoder understands.
+func (s *bleScanner) handle(adv ble.Advertisement) {
+	name, named := s.names[adv.Address]
+	if !named && s.knownOnly {
+		return
+	}
+	tag, ok := ble.Decode(adv, s.keys[adv.Address])
+	if !ok && !named {
+		return
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	dev, seen := s.devices[adv.Address]
+	if !seen {
+		dev = &bleDevice{name: name}
+		s.devices[adv.Address] = dev
+		if ok && !named {
+			log.Printf("BLE: found %s sensor %s, not named", tag.Kind, adv.Address)
+		}
+	}
+	if ok && tag.Err != nil && (dev.tag.Err == nil || dev.tag.Err.Error() != tag.Err.Error()) {
+		log.Printf("BLE: %s sensor %s: %v", tag.Kind, adv.Address, tag.Err)
+	}
+	dev.adv = adv
+	if ok {
+		dev.tag.Kind, dev.tag.Err = tag.Kind, tag.Err
+		dev.tag.Readings = mergeReadings(dev.tag.Readings, tag.Readings)
+	}
+	dev.seq++
+}
+
+// mergeReadings returns the readings with those of the same name replaced
+// by the newer ones.
+func mergeReadings(old, newer []sensor.Reading) []sensor.Reading {
+	res := append([]sensor.Reading(nil), newer...)
+next:
+	for _, r := range old {
+		for _, n := range newer {
+			if n.Name == r.Name {
+				continue next
+			}
+		}
+		res = append(res, r)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Name < res[b].Name })
+	return res
+}
+
+// updated returns copies of the devices heard since the sequence numbers
+// in last, and updates those.
+func (s *bleScanner) updated(last map[string]uint64) []bleDevice {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var res []bleDevice
+	for addr, dev := range s.devices {
+		if dev.seq != last[addr] {
+			res = append(res, *dev)
+			last[addr] = dev.seq
+		}
+	}
+	return res
+}
+
+func registerBLE(s *bleScanner) func() {
+	labels := []string{"device", "name"}
+	rssi := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ble",
+		Name:      "rssi_dbm",
+		Help:      "Signal strength of the latest advertisement.",
+	}, labels)
+
+	// Values are only set when an advertisement has been received, so
+	// that they expire when the device goes out of range.
+	last := make(map[string]uint64)
+	return func() {
+		for _, dev := range s.updated(last) {
+			rssi.WithLabelValues(dev.adv.Address, dev.name).Set(float64(dev.adv.RSSI))
+			for _, r := range dev.tag.Readings {
+				name := r.Name
+				if r.Unit != "" {
+					name += "_" + r.Unit
+				}
+				newGaugeVec(prometheus.GaugeOpts{
+					Namespace: "sensors",
+					Subsystem: "ble",
+					Name:      name,
+				}, labels).WithLabelValues(dev.adv.Address, dev.name).Set(r.Value)
+			}
+			if temp, hum, ok := bleTemperatureHumidity(dev.tag.Readings); ok {
+				source := dev.name
+				if source == "" {
+					source = dev.adv.Address
+				}
+				moisture.observe(source, temp, hum)
+			}
+		}
+	}
+}
+
+// bleTemperatureHumidity returns the temperature and humidity among the
+// readings, if there are both.
+func bleTemperatureHumidity(rs []sensor.Reading) (temp, hum float64, ok bool) {
+	var haveTemp, haveHum bool
+	for _, r := range rs {
+		switch r.Quantity {
+		case sensor.Temperature:
+			temp, haveTemp = r.Value, true
+		case sensor.Humidity:
+			hum, haveHum = r.Value, true
+		}
+	}
+	return temp, hum, haveTemp && haveHum
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/calmh/boatpi/ble"
+	"github.com/calmh/boatpi/sensor"
+)
+
+func TestBLEScanner(t *testing.T) {
+	ruuvi, _ := hex.DecodeString("0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F")
+	s := newBLEScanner(map[string]bleConfig{"saloon": {Address: "c4:7c:8d:6a:12:34"}}, true)
+	s.handle(ble.Advertisement{Address: "C4:7C:8D:6A:12:34", RSSI: -60})
+	s.handle(ble.Advertisement{Address: "06:05:04:03:02:01", RSSI: -80, ManufacturerData: map[uint16][]byte{0x0499: ruuvi}})
+
+	last := make(map[string]uint64)
+	devs := s.updated(last)
+	if len(devs) != 1 || devs[0].name != "saloon" || devs[0].adv.RSSI != -60 {
+		t.Fatalf("expected the named device only, got %+v", devs)
+	}
+	if devs := s.updated(last); len(devs) != 0 {
+		t.Errorf("expected nothing new, got %+v", devs)
+	}
+	s.handle(ble.Advertisement{Address: "C4:7C:8D:6A:12:34", RSSI: -65})
+	if devs := s.updated(last); len(devs) != 1 || devs[0].adv.RSSI != -65 {
+		t.Errorf("expected the new advertisement, got %+v", devs)
+	}
+
+	// Unnamed devices are kept if decoded, without --ble-known-only.
+	s.knownOnly = false
+	s.handle(ble.Advertisement{Address: "06:05:04:03:02:01", RSSI: -80})
+	s.handle(ble.Advertisement{Address: "CB:B8:33:4C:88:4F", RSSI: -80, ManufacturerData: map[uint16][]byte{0x0499: ruuvi}})
+	if devs := s.updated(last); len(devs) != 1 || devs[0].tag.Kind != "ruuvi" || devs[0].name != "" {
+		t.Errorf("expected the RuuviTag, got %+v", devs)
+	}
+}
+
+func TestMergeReadings(t *testing.T) {
+	old := []sensor.Reading{{Name: "temperature", Value: 20}, {Name: "humidity", Value: 50}}
+	res := mergeReadings(old, []sensor.Reading{{Name: "battery_level", Value: 90}, {Name: "temperature", Value: 21}})
+	if len(res) != 3 || res[0].Name != "battery_level" || res[1].Value != 50 || res[2].Value != 21 {
+		t.Errorf("unexpected merge %+v", res)
+	}
+}
+
+func TestBLEMoisture(t *testing.T) {
+	ruuvi, _ := hex.DecodeString("0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F")
+	s := newBLEScanner(map[string]bleConfig{"test-locker": {Address: "CB:B8:33:4C:88:4F"}}, true)
+	update := registerBLE(s)
+	s.handle(ble.Advertisement{Address: "CB:B8:33:4C:88:4F", RSSI: -70, ManufacturerData: map[uint16][]byte{0x0499: ruuvi}})
+	update()
+
+	for _, r := range moisture.list() {
+		if r.Source == "test-locker" {
+			if r.Temperature != 24.3 || r.Humidity != 53.49 {
+				t.Errorf("unexpected moisture reading %+v", r)
+			}
+			return
+		}
+	}
+	t.Error("expected the RuuviTag as a moisture source")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+
+	"github.com/calmh/boatpi/bmp"
+	"github.com/calmh/boatpi/i2c"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "bmp388",
+		section: true,
+		fields:  []string{"pressure", "temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithBMP388 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, o.BMP388Oversampling, o.BMP388IIR,
+				o.SquallWindow, o.PressureHistoryFile, o.ForecastWind, o.WithLPS25H, o.WithMS5611}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			wind, err := forecastWind()
+			if err != nil {
+				return nil, err
+			}
+			dev, err := bmp.NewBMP388(bus, conf.address(bmp.BMP388DefaultAddress), cli().BMP388Oversampling, cli().BMP388IIR)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("bmp388", dev)
+			squall := NewSquallDetector(ctx, "bmp388", cli().SquallWindow, squallSampleInterval, cli().SquallThreshold, dev)
+			var forecaster func(p, delta float64)
+			if !cli().WithLPS25H && !cli().WithMS5611 {
+				forecaster = newForecaster("bmp388", wind)
+			}
+			return registerBarometer("bmp388", squall, forecaster), nil
+		},
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/bno"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bno055SaveInterval is how often, at most, the calibration offsets are
+// read and saved while the BNO055 is fully calibrated. Reading them pauses
+// the fusion.
+const bno055SaveInterval = time.Hour
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "bno055",
+		section: true,
+		fields:  []string{"temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithBNO055 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return append([]interface{}{c.Address, o.MagneticOffset, o.BNO055CalFile}, imuSettings(o)...)
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			file := cli().BNO055CalFile
+			var offs *bno.BNO055Offsets
+			var saved bno.BNO055Offsets
+			if err := readCalibration(file, &saved); err == nil {
+				offs = &saved
+			}
+			bno055, err := bno.NewBNO055(bus, conf.address(bno.BNO055DefaultAddress), cli().MagneticOffset, offs)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("bno055", bno055)
+			abno055 := startIMU(ctx, "bno055", bno055)
+			keepBNO055Offsets(ctx, file, saved, bno055)
+
+			imuUpdate := registerIMU("bno055", abno055)
+			calUpdate := registerBNO055(bno055)
+			return func() {
+				imuUpdate()
+				calUpdate()
+			}, nil
+		},
+	})
+}
+
+// keepBNO055Offsets saves the calibration offsets to the file when the
+// sensor is fully calibrated and they have changed.
+func keepBNO055Offsets(ctx context.Context, file string, saved bno.BNO055Offsets, bno055 *bno.BNO055) {
+	track(ctx, func() {
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+		var last time.Time
+		for {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+			if !bno055.CalibrationStatus().Calibrated() || time.Since(last) < bno055SaveInterval {
+				continue
+			}
+			cur, err := bno055.Offsets()
+			if err != nil {
+				log.Println("Read BNO055 calibration:", err)
+				continue
+			}
+			last = time.Now()
+			if cur == saved {
+				continue
+			}
+			if err := saveCalibration(file, cur); err != nil {
+				log.Println("Save calibration:", err)
+				continue
+			}
+			saved = cur
+		}
+	})
+}
+
+// registerBNO055 exports the calibration status and the quaternion.
+func registerBNO055(bno055 *bno.BNO055) func() {
+	calStatus := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "bno055",
+		Name:      "calibration_status",
+		Help:      "Calibration status of the fusion system and each sensor, from 0 (uncalibrated) to 3 (fully calibrated).",
+	}, []string{"sensor"})
+
+	quat := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "bno055",
+		Name:      "quaternion",
+		Help:      "Fused orientation as a unit quaternion.",
+	}, []string{"component"})
+
+	return func() {
+		c := bno055.CalibrationStatus()
+		calStatus.WithLabelValues("system").Set(float64(c.System))
+		calStatus.WithLabelValues("gyroscope").Set(float64(c.Gyro))
+		calStatus.WithLabelValues("accelerometer").Set(float64(c.Accel))
+		calStatus.WithLabelValues("magnetometer").Set(float64(c.Magn))
+
+		w, x, y, z := bno055.Quaternion()
+		quat.WithLabelValues("w").Set(w)
+		quat.WithLabelValues("x").Set(x)
+		quat.WithLabelValues("y").Set(y)
+		quat.WithLabelValues("z").Set(z)
+	}
+}
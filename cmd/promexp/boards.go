@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/calmh/boatpi/hat"
+	"github.com/calmh/boatpi/i2c"
+)
+
+// Boards are identified by their HAT EEPROM and their sensors enabled as
+// if given on the command line. A boatpi ID EEPROM, in the same format
+// but on the sensor bus, lists its sensors in a custom data atom.
+
+// The sensors on known HATs, by product name.
+var boardSensors = map[string][]string{
+	"Sense HAT": {"hts221", "lps25h", "lsm9ds1"},
+}
+
+const boatpiVendor = "boatpi"
+
+// detected holds the sensors on identified boards.
+var detected = map[string]bool{}
+
+func detectBoards(bus *i2c.Bus) map[string]bool {
+	var boards []hat.Info
+	if cli().DetectBoards {
+		info, err := hat.FromDeviceTree(hat.DeviceTreeDir)
+		if err == nil {
+			boards = append(boards, info)
+		} else if !os.IsNotExist(err) {
+			log.Println("Read HAT information:", err)
+		}
+	}
+	if cli().IDEEPROM != 0 {
+		info, err := hat.ReadEEPROM(bus, int(cli().IDEEPROM))
+		if err == nil {
+			boards = append(boards, info)
+		} else {
+			log.Println("Read ID EEPROM:", err)
+		}
+	}
+
+	res := make(map[string]bool)
+	for _, board := range boards {
+		names := boardSensors[board.Product]
+		if board.Vendor == boatpiVendor && len(board.Custom) > 0 {
+			names = append(names, strings.Split(strings.TrimSpace(string(board.Custom[0])), ",")...)
+		}
+		log.Printf("Detected %s %s, with sensors %s", board.Vendor, board.Product, strings.Join(names, ", "))
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if def, ok := sensorDefByName(name); !ok || !def.section {
+				log.Printf("Board %s: unknown sensor %q", board.Product, name)
+				continue
+			}
+			res[name] = true
+		}
+	}
+	return res
+}
+
+// An i2cAddress is an int flag that also accepts hexadecimal.
+type i2cAddress int
+
+func (a *i2cAddress) Decode(ctx *kong.DecodeContext) error {
+	var s string
+	if err := ctx.Scan.PopValueInto("address", &s); err != nil {
+		return err
+	}
+	n, err := strconv.ParseInt(s, 0, 0)
+	if err != nil {
+		return err
+	}
+	*a = i2cAddress(n)
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"runtime"
+)
+
+// The exporter is meant to run for months. The number of goroutines should
+// be stable after startup: a few per sensor plus the HTTP server. A count
+// growing past the budget means something is leaking, typically across
+// reloads, and is worth knowing about before it takes the Pi down.
+
+var budgetWarned bool
+
+func checkBudget() {
+	n := runtime.NumGoroutine()
+	if n > cli().GoroutineBudget {
+		if !budgetWarned {
+			log.Printf("Warning: %d goroutines running, budget is %d", n, cli().GoroutineBudget)
+			budgetWarned = true
+		}
+	} else {
+		budgetWarned = false
+	}
+
+	checkFileDescriptors()
+}
@@ -0,0 +1,27 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"syscall"
+)
+
+// checkFileDescriptors warns when more than half of the allowed file
+// descriptors are open.
+func checkFileDescriptors() {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return
+	}
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return
+	}
+	max := uint64(lim.Cur) // int64 on the BSDs
+	if uint64(len(fds)) > max/2 {
+		log.Printf("Warning: %d of %d file descriptors in use", len(fds), max)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/calmh/boatpi/sensehat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The IMUs with a magnetometer, the LSM9DS1 and the ICM-20948, calibrate
+// themselves as they go. The calibration is kept in a file of its own per
+// sensor, as it is only valid for the sensor and range it was made with.
+// The BNO055 calibrates itself on the chip, and only its offsets are kept.
+
+// calibratedIMU is an IMU that keeps its own magnetometer and accelerometer
+// calibration.
+type calibratedIMU interface {
+	Calibration() sensehat.Calibration
+	CalibrationResets() []sensehat.CalibrationReset
+	CalibrationQuality() sensehat.CalibrationQuality
+}
+
+// keepCalibration saves the calibration to the file when it changes, and a
+// last time when the sensor is stopped.
+func keepCalibration(ctx context.Context, file string, cal sensehat.Calibration, avg *AvgIMU, imu calibratedIMU) {
+	track(ctx, func() {
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+		for done := false; !done; {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				<-avg.Done()
+				done = true
+			}
+			cur := imu.Calibration()
+			if cur != cal {
+				if err := saveCalibration(file, cur); err != nil {
+					log.Println("Save calibration:", err)
+					continue
+				}
+				cal = cur
+			}
+		}
+	})
+}
+
+// registerCalibration exports the magnetometer calibration quality and
+// resets.
+func registerCalibration(name, file string, imu calibratedIMU) func() {
+	calQuality := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "compass_calibration",
+		Help:      "Magnetometer calibration quality: coverage of the compass sectors, residual from the calibration ellipsoid, and a score from 0 (untrustworthy) to 1.",
+	}, []string{"measure"})
+
+	calResets := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "compass_calibration_resets_total",
+		Help:      "Magnetometer calibrations thrown away as obviously bad: frozen readings, or a field too strong to be the earth's.",
+	}, []string{"reason"})
+
+	return func() {
+		for _, r := range imu.CalibrationResets() {
+			log.Printf("%s: resetting bad magnetometer calibration (%s): min %+v, max %+v", strings.ToUpper(name), r.Reason, r.Calibration.Min, r.Calibration.Max)
+			calResets.WithLabelValues(r.Reason).Inc()
+			// Keep the bad one around for a post mortem.
+			if err := saveCalibration(file+".rejected", r.Calibration); err != nil {
+				log.Println("Save rejected calibration:", err)
+			}
+		}
+
+		q := imu.CalibrationQuality()
+		calQuality.WithLabelValues("coverage").Set(q.Coverage)
+		calQuality.WithLabelValues("residual").Set(q.Residual)
+		calQuality.WithLabelValues("score").Set(q.Score)
+	}
+}
+
+// saveCalibration writes the calibration, of any of the IMUs, to the file
+// as JSON.
+func saveCalibration(file string, cal interface{}) error {
+	fd, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(cal); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// readCalibration reads the calibration saved in the file into cal.
+func readCalibration(file string, cal interface{}) error {
+	fd, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return json.NewDecoder(fd).Decode(cal)
+}
+
+func loadCalibration(file string) sensehat.Calibration {
+	var cal sensehat.Calibration
+	if err := readCalibration(file, &cal); err != nil {
+		return sensehat.Calibration{}
+	}
+	return cal
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The charger stage of a battery bank is inferred from its voltage: a
+// rising voltage while charging (bulk), a voltage held at the absorption
+// level (absorption), and a lower held voltage after absorption (float).
+// Each stage is exported along with the time it was last entered, so that
+// alerts such as "absorption not reached in three days" are simple:
+//
+//   time() - sensors_omini_charger_stage_entered_timestamp_seconds{stage="absorption"} > 3 * 86400
+
+type chargeStage string
+
+const (
+	stageIdle       chargeStage = "idle"
+	stageBulk       chargeStage = "bulk"
+	stageAbsorption chargeStage = "absorption"
+	stageFloat      chargeStage = "float"
+)
+
+var chargeStages = []chargeStage{stageIdle, stageBulk, stageAbsorption, stageFloat}
+
+const (
+	chargeSlopeWindow = 10 * time.Minute
+	bulkMinSlope      = 0.2 // V/h
+	stageHysteresis   = 0.1 // V
+)
+
+type chargeThresholds struct {
+	absorption float64
+	float      float64
+}
+
+// nextChargeStage returns the stage given the previous stage, the current
+// voltage and its rate of change in volts per hour.
+func nextChargeStage(prev chargeStage, v, slope float64, thr chargeThresholds) chargeStage {
+	absorption := thr.absorption
+	if prev == stageAbsorption {
+		absorption -= stageHysteresis
+	}
+	float := thr.float
+	if prev == stageFloat || prev == stageAbsorption {
+		float -= stageHysteresis
+	}
+
+	switch {
+	case v >= absorption:
+		return stageAbsorption
+	case v >= float && (prev == stageAbsorption || prev == stageFloat):
+		return stageFloat
+	case slope >= bulkMinSlope:
+		return stageBulk
+	case prev == stageBulk && v >= float:
+		// Approaching absorption, rising slowly.
+		return stageBulk
+	default:
+		return stageIdle
+	}
+}
+
+type voltageSample struct {
+	when time.Time
+	val  float64
+}
+
+type chargerTracker struct {
+	channel string
+	samples []voltageSample
+	stage   chargeStage
+	entered map[chargeStage]time.Time
+}
+
+type chargerStages struct {
+	trackers map[string]*chargerTracker
+	stage    *gaugeVec
+	entered  *gaugeVec
+}
+
+func newChargerStages() *chargerStages {
+	return &chargerStages{
+		trackers: make(map[string]*chargerTracker),
+		stage: newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: "omini",
+			Name:      "charger_stage",
+			Help:      "Inferred charger stage; 1 for the current stage.",
+		}, []string{"channel", "stage"}),
+		entered: newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: "omini",
+			Name:      "charger_stage_entered_timestamp_seconds",
+			Help:      "Time the charger stage was last entered.",
+		}, []string{"channel", "stage"}),
+	}
+}
+
+func (c *chargerStages) observe(channel string, v float64, now time.Time) {
+	t, ok := c.trackers[channel]
+	if !ok {
+		t = &chargerTracker{channel: channel, stage: stageIdle, entered: make(map[chargeStage]time.Time)}
+		c.trackers[channel] = t
+	}
+
+	cutoff := now.Add(-chargeSlopeWindow)
+	i := 0
+	for i < len(t.samples) && t.samples[i].when.Before(cutoff) {
+		i++
+	}
+	t.samples = append(t.samples[i:], voltageSample{now, v})
+
+	thr := chargeThresholds{absorption: cli().ChargeAbsorptionVoltage, float: cli().ChargeFloatVoltage}
+	next := nextChargeStage(t.stage, v, voltageSlope(t.samples), thr)
+	if next != t.stage {
+		log.Printf("Omini %s: charger stage %s -> %s at %.2f V", channel, t.stage, next, v)
+		t.stage = next
+		t.entered[next] = now
+	}
+
+	for _, s := range chargeStages {
+		val := 0.0
+		if s == t.stage {
+			val = 1
+		}
+		c.stage.WithLabelValues(channel, string(s)).Set(val)
+		if when, ok := t.entered[s]; ok {
+			c.entered.WithLabelValues(channel, string(s)).Set(float64(when.Unix()))
+		}
+	}
+}
+
+// voltageSlope returns the least squares slope of the samples in volts per
+// hour, or zero if they span less than half the slope window.
+func voltageSlope(samples []voltageSample) float64 {
+	if len(samples) < 2 || samples[len(samples)-1].when.Sub(samples[0].when) < chargeSlopeWindow/2 {
+		return 0
+	}
+	t0 := samples[0].when
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.when.Sub(t0).Hours()
+		sx += x
+		sy += s.val
+		sxx += x * x
+		sxy += x * s.val
+	}
+	n := float64(len(samples))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / den
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNextChargeStage(t *testing.T) {
+	thr := chargeThresholds{absorption: 14.0, float: 13.2}
+	cases := []struct {
+		prev  chargeStage
+		v     float64
+		slope float64
+		next  chargeStage
+	}{
+		{stageIdle, 12.6, 0, stageIdle},
+		{stageIdle, 12.6, -0.1, stageIdle},
+		{stageIdle, 12.9, 1.5, stageBulk},
+		{stageBulk, 13.8, 0.1, stageBulk},
+		{stageBulk, 14.2, 0.5, stageAbsorption},
+		{stageAbsorption, 13.95, 0, stageAbsorption},
+		{stageAbsorption, 13.4, -2, stageFloat},
+		{stageFloat, 13.15, 0, stageFloat},
+		{stageFloat, 12.9, -0.5, stageIdle},
+		{stageIdle, 13.4, 0, stageIdle}, // surface charge, not float
+	}
+	for _, tc := range cases {
+		if next := nextChargeStage(tc.prev, tc.v, tc.slope, thr); next != tc.next {
+			t.Errorf("%s at %v V, %v V/h: got %s, expected %s", tc.prev, tc.v, tc.slope, next, tc.next)
+		}
+	}
+}
+
+func TestVoltageSlope(t *testing.T) {
+	t0 := time.Now()
+	var samples []voltageSample
+	for i := 0; i <= 600; i += 10 {
+		samples = append(samples, voltageSample{t0.Add(time.Duration(i) * time.Second), 12.5 + float64(i)/3600})
+	}
+	if s := voltageSlope(samples); math.Abs(s-1) > 1e-9 {
+		t.Errorf("expected 1 V/h, got %v", s)
+	}
+	if s := voltageSlope(samples[:10]); s != 0 {
+		t.Errorf("expected 0 for a short window, got %v", s)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Besides /metrics with everything, the metrics are split by class on
+// /metrics/<class>, so that Prometheus can scrape slowly changing data less
+// often than, say, the motion sensors. Metrics are classed by prefix;
+// those matching no class, such as the virtual sensors and the process
+// metrics, are on /metrics/other.
+
+var metricClasses = []struct {
+	name     string
+	prefixes []string
+}{
+	{"environment", []string{"sensors_lps25h_", "sensors_hts221_", "sensors_ds18b20_", "sensors_ms5611_", "sensors_bmp388_", "sensors_ms5837_", "sensors_scd30_", "sensors_sgp30_", "sensors_sgp40_", "sensors_bh1750_", "sensors_veml7700_", "sensors_tmp117_", "sensors_moisture_", "sensors_sea_", "sensors_ble_"}},
+	{"power", []string{"sensors_omini_", "sensors_ina219_", "sensors_ina3221_", "sensors_battery_"}},
+	{"navigation", []string{"sensors_gps_", "sensors_lsm9ds1_", "sensors_mpu6050_", "sensors_icm20948_", "sensors_bno055_", "sensors_as5600_", "sensors_race_", "sensors_anchor_"}},
+	{"machinery", []string{"sensors_engine_", "sensors_flow_", "sensors_tank_"}},
+}
+
+// metricClass returns the class of the named metric.
+func metricClass(name string) string {
+	for _, c := range metricClasses {
+		for _, p := range c.prefixes {
+			if strings.HasPrefix(name, p) {
+				return c.name
+			}
+		}
+	}
+	return "other"
+}
+
+// classGatherer gathers the metrics of one class.
+type classGatherer struct {
+	prometheus.Gatherer
+	class string
+}
+
+func (g classGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	res := mfs[:0]
+	for _, mf := range mfs {
+		if metricClass(mf.GetName()) == g.class {
+			res = append(res, mf)
+		}
+	}
+	return res, err
+}
+
+func handleMetricClasses() {
+	classes := []string{"other"}
+	for _, c := range metricClasses {
+		classes = append(classes, c.name)
+	}
+	for _, class := range classes {
+		g := classGatherer{Gatherer: prometheus.DefaultGatherer, class: class}
+		http.Handle("/metrics/"+class, promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	}
+}
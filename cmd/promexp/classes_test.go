@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestMetricClass(t *testing.T) {
+	cases := map[string]string{
+		"sensors_lps25h_pressure_mb":        "environment",
+		"sensors_sea_temperature_celsius":   "environment",
+		"sensors_omini_voltage":             "power",
+		"sensors_omini_read_errors_total":   "power",
+		"sensors_gps_up":                    "navigation",
+		"sensors_lsm9ds1_compass_degrees":   "navigation",
+		"sensors_race_countdown_seconds":    "navigation",
+		"sensors_anchor_distance_metres":    "navigation",
+		"sensors_engine_rpm":                "machinery",
+		"sensors_flow_rate_litres_per_hour": "machinery",
+		"sensors_tank_fill_percent":         "machinery",
+		"sensors_ble_temperature_celsius":   "environment",
+		"sensors_virtual_house_power_watts": "other",
+		"go_goroutines":                     "other",
+	}
+	for name, exp := range cases {
+		if c := metricClass(name); c != exp {
+			t.Errorf("%s: got class %s, expected %s", name, c, exp)
+		}
+	}
+}
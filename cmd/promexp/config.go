@@ -0,0 +1,448 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v2"
+)
+
+// The configuration file is YAML. Top level keys are the same as the long
+// command line flags (e.g. "update-interval: 5s"), and values given on the
+// command line take precedence over those in the file. Options that have no
+// corresponding flag live in per-sensor sections:
+//
+//   sensors:
+//     lps25h:
+//       address: 0x5d
+//       interval: 10s
+//       offsets:
+//         pressure: 1.2
+//     hts221:
+//       gains:
+//         temperature: 0.95
+//       offsets:
+//         temperature: -4.5
+//     omini:
+//       gains:
+//         a: 1.012
+//       offsets:
+//         a: 0.15
+//     lsm9ds1:
+//       accelerometer-rate: 119
+//       accelerometer-range: 8
+//       magnetometer-rate: 20
+//       magnetometer-range: 8
+//       fifo: true
+//     mpu6050:
+//       accelerometer-rate: 100
+//       gyroscope-range: 500
+//       fifo: true
+//     icm20948:
+//       accelerometer-rate: 100
+//       magnetometer-rate: 50
+//     ina219:
+//       address: 0x41
+//       shunt: 0.00075 # ohms; 75 mV at 100 A
+//     ina3221:
+//       shunts:
+//         "3": 0.01 # ohms, per channel; the others keep the default 0.1
+//     ads1115:
+//       channels: ... # see adc.go
+//     ms5837:
+//       location: fresh water tank # see locations.go
+//       offsets:
+//         depth: 0.05 # metres; the sensor sits above the tank bottom
+//     as5600:
+//       zero: 212.5 # degrees, raw, with the rudder amidships; see as5600.go
+//
+// Fire and gas detectors on GPIO inputs, or on ADC channels (see
+// alarms.go), are listed in their own section:
+//
+//   detectors:
+//     - name: galley
+//       kind: gas
+//       pin: 17
+//       active-low: true
+//
+// So are the engine speed sensors, see engine.go, the flow sensors, see
+// flow.go, the tank levels, see tank.go, other digital inputs, see
+// inputs.go, the outputs, see outputs.go, the dimmers, see dimmers.go, and
+// the Bluetooth LE sensors, see ble.go.
+//
+// Virtual sensors are computed from other metrics (see expr.go) and
+// exported as sensors_virtual_<name>:
+//
+//   virtual:
+//     house_power_watts: sensors_omini_voltage{channel="a"} * 4.2
+//
+// Expressions, here and elsewhere, refer to metrics by their names in
+// degrees Celsius, millibar and metres and see the values as measured,
+// whatever the selected output units, smoothing and precision.
+//
+// Exported gauges can be smoothed with an exponentially weighted moving
+// average, given the time constant per metric name. Metrics ending in
+// _degrees are averaged as angles.
+//
+//   smoothing:
+//     sensors_omini_voltage: 1m
+//
+// The state of charge of the batteries on the Omini channels is estimated
+// from the voltage, by chemistry (flooded, agm, gel or lifepo4; flooded if
+// not given) or from a custom curve of voltage and percent pairs:
+//
+//   batteries:
+//     a:
+//       chemistry: lifepo4
+//     b:
+//       curve: [[11.9, 0], [12.2, 50], [12.7, 100]]
+//
+// A battery with a current measurement, an expression in amperes that is
+// positive when charging, also gets a state of charge by coulomb counting
+// (see coulomb.go):
+//
+//   batteries:
+//     a:
+//       current: sensors_virtual_house_current_amperes
+//       capacity: 200          # Ah, at the 20 hour rate
+//       peukert: 1.25          # 1.05 for LiFePO4
+//       charge-efficiency: 0.9 # 0.99 for LiFePO4
+//       full-voltage: 13.2     # at which the battery is full when the current tails off
+//
+// Alarm notifications can be customized, see notify.go, and other systems
+// can post values and alarms to webhooks, see webhook.go.
+
+// fileSections are the parts of the configuration file that are not flags.
+type fileSections struct {
+	Sensors   map[string]sensorConfig  `yaml:"sensors"`
+	Detectors []detectorConfig         `yaml:"detectors"`
+	Virtual   map[string]string        `yaml:"virtual"`
+	Smoothing map[string]time.Duration `yaml:"smoothing"`
+	Batteries map[string]batteryConfig `yaml:"batteries"`
+	Engines   map[string]engineConfig  `yaml:"engines"`
+	Flows     map[string]flowConfig    `yaml:"flows"`
+	Tanks     map[string]tankConfig    `yaml:"tanks"`
+	Inputs    map[string]inputConfig   `yaml:"inputs"`
+	Outputs   map[string]outputConfig  `yaml:"outputs"`
+	Dimmers   map[string]dimmerConfig  `yaml:"dimmers"`
+	BLE       map[string]bleConfig     `yaml:"ble"`
+
+	Notifications map[string]string        `yaml:"notifications"`
+	Webhooks      map[string]webhookConfig `yaml:"webhooks"`
+}
+
+type sensorConfig struct {
+	Address     int                `yaml:"address"`
+	MagnAddress int                `yaml:"magnetometer-address"`
+	Interval    time.Duration      `yaml:"interval"`
+	Offsets     map[string]float64 `yaml:"offsets"`
+	Gains       map[string]float64 `yaml:"gains"`
+
+	// The compartment of the sensor, and of its channels or probes by
+	// label value; see locations.go
+	Location  string            `yaml:"location"`
+	Locations map[string]string `yaml:"locations"`
+
+	// INA219 and INA3221 current shunt resistance (Ω), the latter also
+	// per channel
+	Shunt  float64            `yaml:"shunt"`
+	Shunts map[string]float64 `yaml:"shunts"`
+
+	// ADC channels, see adc.go
+	Channels map[string]adcChannelConfig `yaml:"channels"`
+
+	// IMU data rates (Hz) and full scale ranges (g, gauss, °/s)
+	AccelRate  float64 `yaml:"accelerometer-rate"`
+	AccelRange int     `yaml:"accelerometer-range"`
+	MagnRate   float64 `yaml:"magnetometer-rate"`
+	MagnRange  int     `yaml:"magnetometer-range"`
+	GyroRange  int     `yaml:"gyroscope-range"`
+	FIFO       bool    `yaml:"fifo"`
+
+	// AS5600 raw angle (degrees) at the zero position, and direction
+	Zero    float64 `yaml:"zero"`
+	Reverse bool    `yaml:"reverse"`
+}
+
+type detectorConfig struct {
+	Name      string  `yaml:"name"`
+	Kind      string  `yaml:"kind"`
+	Pin       int     `yaml:"pin"`
+	ActiveLow bool    `yaml:"active-low"`
+	Source    string  `yaml:"source"` // expression, instead of a pin
+	Above     float64 `yaml:"above"`  // triggering level of the source
+}
+
+var detectorKinds = []string{"gas", "flame", "heat"}
+
+func sensorConf(name string) sensorConfig {
+	return sections().Sensors[name]
+}
+
+func (c sensorConfig) address(def int) int {
+	if c.Address == 0 {
+		return def
+	}
+	return c.Address
+}
+
+func (c sensorConfig) magnAddress(def int) int {
+	if c.MagnAddress == 0 {
+		return def
+	}
+	return c.MagnAddress
+}
+
+// gain returns the gain for the field, 1 if not configured.
+func (c sensorConfig) gain(field string) float64 {
+	if g, ok := c.Gains[field]; ok {
+		return g
+	}
+	return 1
+}
+
+// correct returns the value of the field multiplied by the gain and with
+// the offset added.
+func (c sensorConfig) correct(field string, v float64) float64 {
+	return v*c.gain(field) + c.Offsets[field]
+}
+
+func (c sensorConfig) shunt(def float64) float64 {
+	if c.Shunt == 0 {
+		return def
+	}
+	return c.Shunt
+}
+
+// channelShunt returns the shunt resistance of the channel, or else the
+// common one.
+func (c sensorConfig) channelShunt(ch string, def float64) float64 {
+	if r, ok := c.Shunts[ch]; ok {
+		return r
+	}
+	return c.shunt(def)
+}
+
+func (c sensorConfig) interval(def time.Duration) time.Duration {
+	if c.Interval == 0 {
+		return def
+	}
+	return c.Interval
+}
+
+// yamlConfig returns a kong.ConfigurationLoader for the YAML configuration
+// file, which stores the sections of the file in secs.
+func yamlConfig(secs *fileSections) kong.ConfigurationLoader {
+	return func(r io.Reader) (kong.Resolver, error) {
+		sections, values, err := loadSections(r)
+		if err != nil {
+			return nil, err
+		}
+		*secs = sections
+		return &yamlResolver{values: values}, nil
+	}
+}
+
+// loadSections reads the configuration file and returns its validated
+// sections, and the remaining values, which are options.
+func loadSections(r io.Reader) (fileSections, map[string]interface{}, error) {
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fileSections{}, nil, err
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(bs, &values); err != nil {
+		return fileSections{}, nil, err
+	}
+	var sections fileSections
+	if err := yaml.Unmarshal(bs, &sections); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateSensors(sections.Sensors); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateDetectors(sections.Detectors); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateEngines(sections.Engines); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateFlows(sections.Flows, sections.Engines); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateInputs(sections.Inputs); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateOutputs(sections.Outputs, sections.Inputs); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateDimmers(sections.Dimmers); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateBLE(sections.BLE); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateVirtual(sections.Virtual); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateBatteries(sections.Batteries); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateTanks(sections.Tanks); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateNotifications(sections.Notifications); err != nil {
+		return fileSections{}, nil, err
+	}
+	if err := validateWebhooks(sections.Webhooks); err != nil {
+		return fileSections{}, nil, err
+	}
+	delete(values, "sensors")
+	delete(values, "detectors")
+	delete(values, "virtual")
+	delete(values, "smoothing")
+	delete(values, "batteries")
+	delete(values, "engines")
+	delete(values, "flows")
+	delete(values, "tanks")
+	delete(values, "inputs")
+	delete(values, "outputs")
+	delete(values, "dimmers")
+	delete(values, "ble")
+	delete(values, "notifications")
+	delete(values, "webhooks")
+	return sections, values, nil
+}
+
+func validateSensors(secs map[string]sensorConfig) error {
+	for name, sec := range secs {
+		def, ok := sensorDefByName(name)
+		if !ok || !def.section {
+			return fmt.Errorf("unknown sensor %q", name)
+		}
+		fields := def.fields
+	nextOffset:
+		for field := range sec.Offsets {
+			for _, f := range fields {
+				if f == field {
+					continue nextOffset
+				}
+			}
+			return fmt.Errorf("sensor %s: unknown offset field %q (valid: %s)", name, field, strings.Join(fields, ", "))
+		}
+		if sec.Shunt < 0 {
+			return fmt.Errorf("sensor %s: shunt resistance must be positive", name)
+		}
+		if err := validateADCChannels(name, sec.Channels); err != nil {
+			return fmt.Errorf("sensor %s: %w", name, err)
+		}
+		for ch, r := range sec.Shunts {
+			if r <= 0 {
+				return fmt.Errorf("sensor %s: channel %s: shunt resistance must be positive", name, ch)
+			}
+		}
+		for v, loc := range sec.Locations {
+			if loc == "" {
+				return fmt.Errorf("sensor %s: %s: empty location", name, v)
+			}
+		}
+		if len(sec.Gains) > 0 && !def.gains {
+			return fmt.Errorf("sensor %s: gains are not supported", name)
+		}
+	nextGain:
+		for field := range sec.Gains {
+			for _, f := range fields {
+				if f == field {
+					continue nextGain
+				}
+			}
+			return fmt.Errorf("sensor %s: unknown gain field %q (valid: %s)", name, field, strings.Join(fields, ", "))
+		}
+	}
+	return nil
+}
+
+func validateDetectors(dets []detectorConfig) error {
+	seen := make(map[string]bool)
+nextDetector:
+	for _, det := range dets {
+		if det.Name == "" {
+			return fmt.Errorf("detector on pin %d: missing name", det.Pin)
+		}
+		if seen[det.Name] {
+			return fmt.Errorf("detector %s: duplicate name", det.Name)
+		}
+		seen[det.Name] = true
+		if reservedAlarm(det.Name) {
+			return fmt.Errorf("detector %s: name is used by another alarm", det.Name)
+		}
+		if det.Source != "" {
+			if det.Pin != 0 || det.ActiveLow {
+				return fmt.Errorf("detector %s: either a pin or a source, not both", det.Name)
+			}
+			if _, _, err := parseExpr(det.Source); err != nil {
+				return fmt.Errorf("detector %s: %w", det.Name, err)
+			}
+			if det.Above == 0 {
+				return fmt.Errorf("detector %s: a source needs the level above which it triggers", det.Name)
+			}
+		}
+		for _, kind := range detectorKinds {
+			if det.Kind == kind {
+				continue nextDetector
+			}
+		}
+		return fmt.Errorf("detector %s: unknown kind %q (valid: %s)", det.Name, det.Kind, strings.Join(detectorKinds, ", "))
+	}
+	return nil
+}
+
+type yamlResolver struct {
+	values map[string]interface{}
+}
+
+func (r *yamlResolver) Validate(app *kong.Application) error {
+	known := make(map[string]bool)
+	for _, flag := range app.Flags {
+		known[flag.Name] = true
+	}
+	var unknown []string
+	for key := range r.values {
+		if !known[flagName(key)] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown configuration keys: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+func (r *yamlResolver) Resolve(ctx *kong.Context, parent *kong.Path, flag *kong.Flag) (interface{}, error) {
+	for key, val := range r.values {
+		if flagName(key) == flag.Name {
+			// Pass everything as strings and let the flag mappers parse
+			// them; they are not prepared for arbitrary YAML types.
+			if list, ok := val.([]interface{}); ok {
+				strs := make([]string, len(list))
+				for i, v := range list {
+					strs[i] = fmt.Sprint(v)
+				}
+				return strings.Join(strs, ","), nil
+			}
+			return fmt.Sprint(val), nil
+		}
+	}
+	return nil, nil
+}
+
+func flagName(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestYAMLConfigSensors(t *testing.T) {
+	const conf = `
+update-interval: 5s
+sensors:
+  lps25h:
+    address: 0x5d
+    interval: 30s
+    offsets:
+      pressure: -1.5
+`
+	secs, _, err := loadSections(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := secs.Sensors["lps25h"]
+	if c.address(0x5c) != 0x5d {
+		t.Errorf("unexpected address 0x%02x", c.address(0x5c))
+	}
+	if c.interval(time.Second) != 30*time.Second {
+		t.Errorf("unexpected interval %v", c.interval(time.Second))
+	}
+	if c.Offsets["pressure"] != -1.5 {
+		t.Errorf("unexpected offsets %v", c.Offsets)
+	}
+	if secs.Sensors["hts221"].address(0x5f) != 0x5f {
+		t.Error("expected default address for unconfigured sensor")
+	}
+}
+
+func TestSensorCorrection(t *testing.T) {
+	const conf = `
+sensors:
+  hts221:
+    gains:
+      temperature: 0.9
+    offsets:
+      temperature: -2
+      humidity: 3
+`
+	secs, _, err := loadSections(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := secs.Sensors["hts221"]
+	if v := c.correct("temperature", 30); v != 25 {
+		t.Errorf("corrected temperature %v, expected 25", v)
+	}
+	if v := c.correct("humidity", 50); v != 53 {
+		t.Errorf("corrected humidity %v, expected 53", v)
+	}
+}
+
+func TestChannelShunt(t *testing.T) {
+	const conf = `
+sensors:
+  ina3221:
+    shunt: 0.01
+    shunts:
+      "3": 0.001
+`
+	secs, _, err := loadSections(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := secs.Sensors["ina3221"]
+	if r := c.channelShunt("1", 0.1); r != 0.01 {
+		t.Errorf("channel 1 shunt %v, expected 0.01", r)
+	}
+	if r := c.channelShunt("3", 0.1); r != 0.001 {
+		t.Errorf("channel 3 shunt %v, expected 0.001", r)
+	}
+}
+
+func TestYAMLConfigInvalid(t *testing.T) {
+	for _, conf := range []string{
+		"sensors:\n  bme280: {}\n",
+		"sensors:\n  hts221:\n    offsets:\n      pressure: 1\n",
+		"detectors:\n  - name: galley\n    kind: smoke\n",
+		"detectors:\n  - kind: gas\n",
+		"detectors:\n  - name: anchor\n    kind: gas\n    pin: 17\n",
+		"detectors:\n  - name: webhook-door\n    kind: gas\n    pin: 17\n",
+		"detectors:\n  - name: bilge\n    kind: gas\n    source: sensors_ads1115_voltage{channel=\"lpg\"}\n",
+		"detectors:\n  - name: bilge\n    kind: gas\n    source: sensors_ads1115_voltage{\n    above: 1.2\n",
+		"detectors:\n  - name: bilge\n    kind: gas\n    pin: 17\n    source: sensors_ads1115_voltage\n    above: 1.2\n",
+		"sensors:\n  omini:\n    gains:\n      d: 1\n",
+		"sensors:\n  hts221:\n    gains:\n      pressure: 1\n",
+		"sensors:\n  ds18b20:\n    gains:\n      temperature: 1\n",
+		"sensors:\n  ina3221:\n    shunts:\n      \"2\": 0\n",
+		"sensors:\n  ads1115:\n    channels:\n      fuel:\n        input: \"1-2\"\n",
+		"sensors:\n  ads1115:\n    channels:\n      fuel:\n        input: \"0\"\n        range: 5\n",
+		"sensors:\n  ads1115:\n    channels:\n      fuel:\n        input: \"0\"\n        scale: [[4.5, 0], [0.5, 100]]\n",
+		"sensors:\n  hts221:\n    channels:\n      fuel:\n        input: \"0\"\n",
+		"sensors:\n  mcp3008:\n    channels:\n      fuel:\n        input: \"8\"\n",
+		"sensors:\n  mcp3008:\n    channels:\n      fuel:\n        input: \"0\"\n        rate: 8\n",
+		"engines:\n  main:\n    pin: 22\n",
+		"engines:\n  main:\n    pin: 22\n    pulses-per-revolution: 6\n    edge: up\n",
+		"engines:\n  port:\n    pin: 22\n    pulses-per-revolution: 6\n  starboard:\n    pin: 22\n    pulses-per-revolution: 6\n",
+		"flows:\n  water:\n    pin: 25\n",
+		"tanks:\n  water:\n    height: 0.4\n    capacity: 100\n",
+		"inputs:\n  float:\n    pin: 27\n    pull-up: true\n",
+		"outputs:\n  pump:\n    expander: pcf8574\n    pin: 9\n",
+		"dimmers:\n  lights:\n    channel: 16\n",
+		"dimmers:\n  lights:\n    channel: 0\n  gauge:\n    address: 0x40\n    channel: 0\n",
+		"dimmers:\n  gauge:\n    channel: 1\n    source: sensors_tank_fill_percent\n",
+		"dimmers:\n  gauge:\n    channel: 1\n    scale: [[0, 10], [100, 90]]\n",
+		"dimmers:\n  gauge:\n    channel: 1\n    source: x\n    scale: [[0, 10], [100, 190]]\n",
+		"ble:\n  saloon:\n    address: C4:7C:8D:6A:12\n",
+		"ble:\n  saloon:\n    address: C4:7C:8D:6A:12:34\n  cabin:\n    address: c4:7c:8d:6a:12:34\n",
+		"ble:\n  forepeak:\n    address: A4:C1:38:6A:12:34\n    key: e9ea895fac7cca6d30532432a516f3\n",
+		"inputs:\n  float:\n    pin: 27\noutputs:\n  pump:\n    pin: 27\n",
+		"inputs:\n  float:\n    expander: mcp23017\n    pin: 3\noutputs:\n  pump:\n    expander: mcp23017\n    address: 0x20\n    pin: 3\n",
+		"inputs:\n  float:\n    expander: mcp23008\n    pin: 3\n",
+		"inputs:\n  float:\n    expander: pcf8574\n    pin: 8\n",
+		"inputs:\n  float:\n    expander: mcp23017\n    pin: 3\n  door:\n    expander: mcp23017\n    address: 0x20\n    pin: 3\n",
+		"tanks:\n  water:\n    level: x\n    port: /dev/ttyUSB0\n    height: 0.4\n    capacity: 100\n",
+		"tanks:\n  water:\n    level: x\n    capacity: 100\n",
+		"tanks:\n  water:\n    distance: x\n    strapping: [[0, 0], [0.4, 100]]\n",
+		"tanks:\n  water:\n    level: x\n    strapping: [[0, 0], [0.4, 100], [0.3, 120]]\n",
+		"flows:\n  water:\n    pin: 25\n    pulses-per-litre: 450\n    edge: down\n",
+		"engines:\n  main:\n    pin: 22\n    pulses-per-revolution: 6\nflows:\n  fuel:\n    pin: 22\n    pulses-per-litre: 2200\n",
+	} {
+		if _, _, err := loadSections(strings.NewReader(conf)); err == nil {
+			t.Errorf("expected error for %q", conf)
+		}
+	}
+}
+
+func TestI2CAddressFlag(t *testing.T) {
+	for _, arg := range []string{"--id-eeprom=0x50", "--id-eeprom=80"} {
+		opts, _, err := parseOptions([]string{arg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.IDEEPROM != 0x50 {
+			t.Errorf("%s: unexpected address 0x%02x", arg, int(opts.IDEEPROM))
+		}
+	}
+}
+
+func TestWaypointFlag(t *testing.T) {
+	opts, _, err := parseOptions([]string{"--simulate-route=57.5/11.25,-33.9/151.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []waypoint{{57.5, 11.25}, {-33.9, 151.2}}; !reflect.DeepEqual(opts.SimulateRoute, exp) {
+		t.Errorf("unexpected route %v", opts.SimulateRoute)
+	}
+	if _, _, err := parseOptions([]string{"--simulate-route=57.5"}); err == nil {
+		t.Error("expected error for waypoint without longitude")
+	}
+}
+
+// withOptions sets the options, as changed by fn, for the duration of the
+// test.
+func withOptions(t *testing.T, fn func(o *options)) {
+	prev := current.Load()
+	o := *cli()
+	fn(&o)
+	setConfig(o, *sections())
+	t.Cleanup(func() { current.Store(prev) })
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Coulomb counting: the voltage says little about the state of charge
+// while there is a load or charge current, so batteries with a current
+// measurement have the charge integrated over time instead. Discharge is
+// weighted by Peukert's law, relative to the 20 hour rate the capacity is
+// given for, and charge by the charge efficiency. The count is reset to
+// full when the voltage is at or above the full voltage and the charge
+// current has tailed off, and kept in the state file across restarts.
+
+const (
+	coulombDefaultPeukert    = 1.25
+	coulombDefaultEfficiency = 0.9
+	coulombTailCurrent       = 0.02 // of the capacity, per hour
+	coulombMaxGap            = time.Minute
+	coulombSaveInterval      = 10 * time.Minute
+)
+
+func init() {
+	registerSensor(sensorDef{
+		// The current may well be a virtual sensor.
+		name:  "battery",
+		order: 2,
+		enabled: func(o options) bool {
+			for _, bat := range sections().Batteries {
+				if bat.Current != "" {
+					return true
+				}
+			}
+			return false
+		},
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{sections().Batteries, o.BatteryStateFile}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initCoulomb(ctx, sections().Batteries, cli().BatteryStateFile)
+		},
+	})
+}
+
+// coulombCounter is the charge count of one battery.
+type coulombCounter struct {
+	Remaining  float64   `json:"remaining"`  // Ah
+	Charged    float64   `json:"charged"`    // Ah in, cumulative
+	Discharged float64   `json:"discharged"` // Ah out, cumulative
+	Updated    time.Time `json:"updated"`
+
+	capacity, peukert, efficiency, full float64
+	current                             exprNode
+	drain                               float64 // Peukert effective discharge current, A
+}
+
+func newCoulombCounter(bat batteryConfig) *coulombCounter {
+	c := &coulombCounter{
+		Remaining:  bat.Capacity,
+		capacity:   bat.Capacity,
+		peukert:    bat.Peukert,
+		efficiency: bat.ChargeEfficiency,
+		full:       bat.FullVoltage,
+	}
+	if c.peukert == 0 {
+		c.peukert = coulombDefaultPeukert
+	}
+	if c.efficiency == 0 {
+		c.efficiency = coulombDefaultEfficiency
+	}
+	return c
+}
+
+// observe integrates the current (A, positive when charging) since the
+// last observation and checks for a full battery at the voltage, if
+// known (not NaN). Gaps longer than coulombMaxGap are not integrated, as
+// nothing is known about the current during them.
+func (c *coulombCounter) observe(now time.Time, current, voltage float64) {
+	dt := now.Sub(c.Updated)
+	c.Updated = now
+	c.drain = 0
+	if current < 0 {
+		// Peukert: the effective current relative to the 20 hour
+		// rate.
+		rated := c.capacity / 20
+		c.drain = rated * math.Pow(-current/rated, c.peukert)
+	}
+	if dt > 0 && dt <= coulombMaxGap {
+		h := dt.Hours()
+		if current >= 0 {
+			c.Charged += current * h
+			c.Remaining += current * h * c.efficiency
+		} else {
+			c.Discharged += -current * h
+			c.Remaining -= c.drain * h
+		}
+	}
+	if c.full > 0 && voltage >= c.full && current >= 0 && current <= coulombTailCurrent*c.capacity {
+		c.Remaining = c.capacity
+	}
+	c.Remaining = math.Max(0, math.Min(c.capacity, c.Remaining))
+}
+
+func (c *coulombCounter) soc() float64 {
+	return c.Remaining / c.capacity * 100
+}
+
+// timeRemaining returns the time until empty at the current discharge, if
+// discharging.
+func (c *coulombCounter) timeRemaining() (time.Duration, bool) {
+	if c.drain <= 0 {
+		return 0, false
+	}
+	return time.Duration(c.Remaining / c.drain * float64(time.Hour)), true
+}
+
+func loadCoulombState(file string) map[string]coulombCounter {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+	var saved map[string]coulombCounter
+	if err := json.NewDecoder(fd).Decode(&saved); err != nil {
+		log.Println("Load battery state:", err)
+		return nil
+	}
+	return saved
+}
+
+func saveCoulombState(file string, counters map[string]*coulombCounter) error {
+	tmp := file + ".tmp"
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fd).Encode(counters); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func initCoulomb(ctx context.Context, bats map[string]batteryConfig, file string) (func(), error) {
+	saved := loadCoulombState(file)
+	counters := make(map[string]*coulombCounter)
+	var channels []string
+	for ch, bat := range bats {
+		if bat.Current == "" {
+			continue
+		}
+		expr, _, err := parseExpr(bat.Current)
+		if err != nil {
+			return nil, fmt.Errorf("battery %s: current: %w", ch, err)
+		}
+		c := newCoulombCounter(bat)
+		c.current = expr
+		if s, ok := saved[ch]; ok {
+			c.Remaining = math.Min(s.Remaining, c.capacity)
+			c.Charged, c.Discharged = s.Charged, s.Discharged
+		}
+		counters[ch] = c
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+
+	onDone(ctx, func() {
+		if err := saveCoulombState(file, counters); err != nil {
+			log.Println("Save battery state:", err)
+		}
+	})
+	return registerCoulomb(counters, channels, file), nil
+}
+
+func registerCoulomb(counters map[string]*coulombCounter, channels []string, file string) func() {
+	soc := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "battery",
+		Name:      "state_of_charge_percent",
+		Help:      "State of charge by coulomb counting.",
+	}, []string{"channel"})
+	remaining := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "battery",
+		Name:      "remaining_amp_hours",
+	}, []string{"channel"})
+	timeLeft := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "battery",
+		Name:      "time_remaining_seconds",
+		Help:      "Time until empty at the current discharge; only while discharging.",
+	}, []string{"channel"})
+	charged := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "battery",
+		Name:      "charged_amp_hours",
+		Help:      "Charge into the battery, cumulative across restarts.",
+	}, []string{"channel"})
+	discharged := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "battery",
+		Name:      "discharged_amp_hours",
+		Help:      "Charge out of the battery, cumulative across restarts.",
+	}, []string{"channel"})
+
+	errs := make(map[string]error)
+	saved := time.Now()
+	return func() {
+		mfs, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			log.Println("Battery: gather metrics:", err)
+			return
+		}
+		lookup := gatheredLookup(mfs)
+		now := time.Now()
+		for _, ch := range channels {
+			c := counters[ch]
+			current, err := c.current(lookup)
+			if err != nil {
+				if prev := errs[ch]; prev == nil || prev.Error() != err.Error() {
+					log.Printf("Battery %s: current: %v", ch, err)
+				}
+				errs[ch] = err
+				continue
+			}
+			errs[ch] = nil
+			voltage, err := lookup(seriesRef{name: "sensors_omini_voltage", labels: map[string]string{"channel": ch}})
+			if err != nil {
+				voltage = math.NaN()
+			}
+			c.observe(now, current, voltage)
+
+			soc.WithLabelValues(ch).Set(c.soc())
+			remaining.WithLabelValues(ch).Set(c.Remaining)
+			charged.WithLabelValues(ch).Set(c.Charged)
+			discharged.WithLabelValues(ch).Set(c.Discharged)
+			if d, ok := c.timeRemaining(); ok {
+				timeLeft.WithLabelValues(ch).Set(d.Seconds())
+			}
+		}
+
+		if now.Sub(saved) >= coulombSaveInterval {
+			if err := saveCoulombState(file, counters); err != nil {
+				log.Println("Save battery state:", err)
+			}
+			saved = now
+		}
+	}
+}
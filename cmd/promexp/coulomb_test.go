@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCoulombCounter(t *testing.T) {
+	c := newCoulombCounter(batteryConfig{Capacity: 100, Peukert: 1.2, ChargeEfficiency: 0.9, FullVoltage: 13.2})
+	t0 := time.Now()
+	c.observe(t0, 0, math.NaN())
+
+	// An hour at the 20 hour rate takes 5 Ah, Peukert or not.
+	for i := 1; i <= 60; i++ {
+		c.observe(t0.Add(time.Duration(i)*time.Minute), -5, math.NaN())
+	}
+	if math.Abs(c.Remaining-95) > 1e-6 || math.Abs(c.Discharged-5) > 1e-6 {
+		t.Errorf("remaining %v, discharged %v after an hour at 5 A", c.Remaining, c.Discharged)
+	}
+	if d, ok := c.timeRemaining(); !ok || math.Abs(d.Hours()-19) > 1e-3 {
+		t.Errorf("time remaining %v at 5 A", d)
+	}
+
+	// Twice the current drains more than twice as fast.
+	before := c.Remaining
+	c.observe(t0.Add(61*time.Minute), -10, math.NaN())
+	if used, exp := (before-c.Remaining)*60, 10*math.Pow(2, 0.2); math.Abs(used-exp) > 1e-6 {
+		t.Errorf("used %v Ah/h at 10 A, expected %v", used, exp)
+	}
+
+	// Charge counts at the efficiency, and gaps are skipped.
+	before = c.Remaining
+	c.observe(t0.Add(62*time.Minute), 20, 13.0)
+	if gained := (c.Remaining - before) * 60; math.Abs(gained-18) > 1e-6 {
+		t.Errorf("gained %v Ah/h at 20 A, expected 18", gained)
+	}
+	before = c.Remaining
+	c.observe(t0.Add(3*time.Hour), 20, 13.0)
+	if c.Remaining != before {
+		t.Error("integrated across a gap")
+	}
+	if _, ok := c.timeRemaining(); ok {
+		t.Error("time remaining while charging")
+	}
+
+	// At the full voltage with the current tailed off, it's full.
+	c.observe(t0.Add(3*time.Hour+time.Minute), 1.5, 13.3)
+	if c.soc() != 100 {
+		t.Errorf("state of charge %v when full", c.soc())
+	}
+}
+
+func TestCoulombStateAcrossReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coulomb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	def, _ := sensorDefByName("battery")
+	prevDefs := sensorDefs
+	sensorDefs = []sensorDef{def}
+	defer func() { sensorDefs = prevDefs }()
+	prevConfig := current.Load()
+	defer current.Store(prevConfig)
+
+	shunt := newGauge(prometheus.GaugeOpts{Namespace: "sensors", Subsystem: "test_shunt", Name: "current_amperes"})
+	shunt.Set(-50)
+	remaining, _, _ := parseExpr(`sensors_battery_remaining_amp_hours{channel="test-house"}`)
+	read := func() float64 {
+		mfs, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := remaining(gatheredLookup(mfs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	opts := options{BatteryStateFile: filepath.Join(dir, "battery.json")}
+	bat := batteryConfig{Capacity: 100, Current: "sensors_test_shunt_current_amperes"}
+	setConfig(opts, fileSections{Batteries: map[string]batteryConfig{"test-house": bat}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var running runningSensors
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { running[0].stop() }()
+	running[0].update()
+	time.Sleep(10 * time.Millisecond)
+	running[0].update()
+	before := read()
+	if before >= 100 {
+		t.Fatalf("nothing drained: %v Ah remaining", before)
+	}
+
+	// A changed battery section reinitializes the counter, which must
+	// carry on from where the previous one stopped rather than from the
+	// last periodic save.
+	bat.FullVoltage = 13.2
+	setConfig(opts, fileSections{Batteries: map[string]batteryConfig{"test-house": bat}})
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	running[0].update()
+	if after := read(); after != before {
+		t.Errorf("%v Ah remaining after the reload, expected %v", after, before)
+	}
+}
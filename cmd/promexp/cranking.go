@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/omini"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Engine starts show as a sharp sag in the start battery voltage while the
+// starter motor cranks. The lowest voltage during cranking is an early
+// sign of a weakening battery, well before it fails to start the engine.
+// Cranking lasts a second or two, so the start bank is sampled much faster
+// than the regular update interval, and without the outlier filter.
+
+const (
+	crankSampleInterval = 50 * time.Millisecond
+	crankBaselineWindow = 5 * time.Second
+	crankSag            = 1.0 // V below baseline to count as cranking
+	crankRecovered      = 0.3 // V below baseline to count as recovered
+	crankMaxDuration    = 15 * time.Second
+)
+
+type crankEvent struct {
+	start    time.Time
+	duration time.Duration
+	baseline float64
+	min      float64
+}
+
+type crankDetector struct {
+	samples []voltageSample // the baseline window
+	active  *crankEvent
+}
+
+// sample adds a sample and returns the cranking event that just ended, if
+// any.
+func (d *crankDetector) sample(now time.Time, v float64) *crankEvent {
+	if e := d.active; e != nil {
+		if v < e.min {
+			e.min = v
+		}
+		if v >= e.baseline-crankRecovered || now.Sub(e.start) > crankMaxDuration {
+			e.duration = now.Sub(e.start)
+			d.active = nil
+			// The alternator will soon lift the voltage; start over
+			// with the baseline.
+			d.samples = d.samples[:0]
+			return e
+		}
+		return nil
+	}
+
+	if base, ok := d.baseline(); ok && v < base-crankSag {
+		d.active = &crankEvent{start: now, baseline: base, min: v}
+		return nil
+	}
+
+	cutoff := now.Add(-crankBaselineWindow)
+	i := 0
+	for i < len(d.samples) && d.samples[i].when.Before(cutoff) {
+		i++
+	}
+	d.samples = append(d.samples[i:], voltageSample{now, v})
+	return nil
+}
+
+// baseline returns the average voltage over the baseline window, if there
+// is enough of it.
+func (d *crankDetector) baseline() (float64, bool) {
+	if len(d.samples) < 2 || d.samples[len(d.samples)-1].when.Sub(d.samples[0].when) < crankBaselineWindow/2 {
+		return 0, false
+	}
+	sum := 0.0
+	for _, s := range d.samples {
+		sum += s.val
+	}
+	return sum / float64(len(d.samples)), true
+}
+
+type crankMonitor struct {
+	channel string
+	mut     sync.Mutex
+	last    *crankEvent
+	events  int
+}
+
+func newCrankMonitor(ctx context.Context, dev *omini.Omini, channel string) (*crankMonitor, error) {
+	idx := map[string]int{"a": 0, "b": 1, "c": 2}
+	i, ok := idx[channel]
+	if !ok {
+		return nil, fmt.Errorf("unknown cranking channel %q", channel)
+	}
+	m := &crankMonitor{channel: channel}
+	go m.serve(ctx, dev, i)
+	return m, nil
+}
+
+func (m *crankMonitor) serve(ctx context.Context, dev *omini.Omini, idx int) {
+	var d crankDetector
+	t := time.NewTicker(crankSampleInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		a, b, c, err := dev.RawVoltages()
+		if err != nil {
+			continue
+		}
+		v := [3]float64{a, b, c}[idx]
+		if e := d.sample(time.Now(), v); e != nil {
+			log.Printf("Engine start on %s: minimum %.2f V (from %.2f V) over %.1f s", m.channel, e.min, e.baseline, e.duration.Seconds())
+			m.mut.Lock()
+			m.last = e
+			m.events++
+			m.mut.Unlock()
+		}
+	}
+}
+
+func (m *crankMonitor) lastEvent() (*crankEvent, int) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return m.last, m.events
+}
+
+func registerCranking(m *crankMonitor) func() {
+	min := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "cranking_min_voltage",
+		Help:      "Lowest voltage during the last engine start.",
+	}, []string{"channel"})
+	duration := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "cranking_duration_seconds",
+	}, []string{"channel"})
+	when := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "cranking_timestamp_seconds",
+	}, []string{"channel"})
+	events := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "cranking_events_total",
+	}, []string{"channel"})
+
+	prev := 0
+	return func() {
+		e, n := m.lastEvent()
+		events.WithLabelValues(m.channel).Add(float64(n - prev))
+		prev = n
+		if e == nil {
+			return
+		}
+		min.WithLabelValues(m.channel).Set(e.min)
+		duration.WithLabelValues(m.channel).Set(e.duration.Seconds())
+		when.WithLabelValues(m.channel).Set(float64(e.start.Unix()))
+	}
+}
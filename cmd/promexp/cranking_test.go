@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrankDetector(t *testing.T) {
+	var d crankDetector
+	now := time.Now()
+	feed := func(v float64, dur time.Duration) *crankEvent {
+		var res *crankEvent
+		for end := now.Add(dur); now.Before(end); now = now.Add(crankSampleInterval) {
+			if e := d.sample(now, v); e != nil {
+				res = e
+			}
+		}
+		return res
+	}
+
+	if e := feed(12.6, 10*time.Second); e != nil {
+		t.Fatal("unexpected event at rest")
+	}
+	if e := feed(12.3, time.Second); e != nil {
+		t.Fatal("unexpected event for a small load")
+	}
+	if e := feed(10.1, 1500*time.Millisecond); e != nil {
+		t.Fatal("unexpected event while cranking")
+	}
+	feed(9.8, 200*time.Millisecond)
+	e := feed(13.8, time.Second)
+	if e == nil {
+		t.Fatal("expected an event when the voltage recovers")
+	}
+	if e.min != 9.8 {
+		t.Errorf("expected minimum 9.8 V, got %v", e.min)
+	}
+	if e.duration < 1700*time.Millisecond || e.duration > 1800*time.Millisecond {
+		t.Errorf("unexpected duration %v", e.duration)
+	}
+}
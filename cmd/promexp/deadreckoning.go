@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Dead reckoning: with --dead-reckoning, the GPS position is carried on
+// from the last fix by the heading and speed when the fix is lost, for at
+// most that long, so that the anchor watch and the track do not freeze
+// during a brief outage. The heading and speed are expressions, by default
+// the last course and speed over ground; a compass and a log do better
+// when the boat turns or the current sets it sideways:
+//
+//   --dead-reckoning=5m
+//   --dead-reckoning-heading='sensors_lsm9ds1_compass_degrees{plane="horiz"} + 6'
+//   --dead-reckoning-speed=sensors_virtual_log_knots
+//
+// sensors_gps_position_estimated is 1 while the position is estimated.
+
+// deadReckoningDelay is how long the GPS may go without a new position
+// before the fix is considered lost.
+const deadReckoningDelay = 5 * time.Second
+
+type deadReckoner struct {
+	heading, speed exprNode
+	limit          time.Duration
+
+	pos racePoint
+	at  time.Time // of pos
+	fix time.Time // of the last GPS fix
+}
+
+func newDeadReckoner(limit time.Duration, heading, speed string) (*deadReckoner, error) {
+	h, _, err := parseExpr(heading)
+	if err != nil {
+		return nil, fmt.Errorf("dead reckoning heading %q: %w", heading, err)
+	}
+	s, _, err := parseExpr(speed)
+	if err != nil {
+		return nil, fmt.Errorf("dead reckoning speed %q: %w", speed, err)
+	}
+	return &deadReckoner{heading: h, speed: s, limit: limit}, nil
+}
+
+// setFix records a GPS fix.
+func (d *deadReckoner) setFix(pos racePoint, when time.Time) {
+	d.pos, d.at, d.fix = pos, when, when
+}
+
+// estimate advances the position to now from the current heading and
+// speed, and returns it. It returns false before the fix is lost, after
+// the limit, and when the heading or speed is not available.
+func (d *deadReckoner) estimate(now time.Time, lookup lookupFunc) (racePoint, bool) {
+	if d.fix.IsZero() || now.Sub(d.fix) < deadReckoningDelay || now.Sub(d.fix) > d.limit {
+		return racePoint{}, false
+	}
+	hdg, err := d.heading(lookup)
+	if err != nil {
+		return racePoint{}, false
+	}
+	knots, err := d.speed(lookup)
+	if err != nil {
+		return racePoint{}, false
+	}
+	d.pos = deadReckon(d.pos, hdg, knots, now.Sub(d.at))
+	d.at = now
+	return d.pos, true
+}
+
+// deadReckon returns the position after sailing from p for the duration
+// at the heading (degrees true) and speed (knots).
+func deadReckon(p racePoint, heading, knots float64, dur time.Duration) racePoint {
+	dist := knots * 1852 / 3600 * dur.Seconds()
+	rad := heading / 180 * math.Pi
+	north, east := dist*math.Cos(rad), dist*math.Sin(rad)
+	p.Lat += north / raceEarthRadius / math.Pi * 180
+	p.Lon += east / (raceEarthRadius * math.Cos(p.Lat/180*math.Pi)) / math.Pi * 180
+	return p
+}
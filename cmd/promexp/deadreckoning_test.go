@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDeadReckon(t *testing.T) {
+	// An hour at six knots due east, then due north.
+	origin := racePoint{Lat: 57.7, Lon: 11.85}
+	p := deadReckon(origin, 90, 6, time.Hour)
+	if x, y := flatXY(origin, p); math.Abs(x-6*1852) > 1 || math.Abs(y) > 1e-6 {
+		t.Errorf("east: got %.1f, %.1f m", x, y)
+	}
+	p = deadReckon(origin, 0, 6, time.Hour)
+	if x, y := flatXY(origin, p); math.Abs(x) > 1e-6 || math.Abs(y-6*1852) > 1 {
+		t.Errorf("north: got %.1f, %.1f m", x, y)
+	}
+}
+
+func TestDeadReckoner(t *testing.T) {
+	dr, err := newDeadReckoner(time.Minute, "hdg", "sog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals := map[string]float64{"hdg": 180, "sog": 3600 / 1852.0} // 1 m/s
+	lookup := func(r seriesRef) (float64, error) {
+		if v, ok := vals[r.name]; ok {
+			return v, nil
+		}
+		return 0, errors.New("missing")
+	}
+
+	now := time.Now()
+	origin := racePoint{Lat: 57.7, Lon: 11.85}
+	if _, ok := dr.estimate(now, lookup); ok {
+		t.Error("expected no estimate before a fix")
+	}
+	dr.setFix(origin, now)
+	if _, ok := dr.estimate(now.Add(time.Second), lookup); ok {
+		t.Error("expected no estimate while the fix is fresh")
+	}
+	p, ok := dr.estimate(now.Add(10*time.Second), lookup)
+	if !ok {
+		t.Fatal("expected an estimate")
+	}
+	if _, y := flatXY(origin, p); math.Abs(y+10) > 0.01 {
+		t.Errorf("expected 10 m south, got %.2f", y)
+	}
+
+	// Turning west continues from the estimate.
+	vals["hdg"] = 270
+	p, _ = dr.estimate(now.Add(20*time.Second), lookup)
+	if x, y := flatXY(origin, p); math.Abs(x+10) > 0.01 || math.Abs(y+10) > 0.01 {
+		t.Errorf("expected 10 m south and west, got %.2f, %.2f", x, y)
+	}
+
+	delete(vals, "sog")
+	if _, ok := dr.estimate(now.Add(30*time.Second), lookup); ok {
+		t.Error("expected no estimate without the speed")
+	}
+	vals["sog"] = 1
+	if _, ok := dr.estimate(now.Add(2*time.Minute), lookup); ok {
+		t.Error("expected no estimate after the limit")
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/pwm"
+	"github.com/calmh/boatpi/sensor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Dimmers are PWM channels on a PCA9685, for LED lights through a MOSFET
+// or for analog gauges, named in their own section. A light is set by a
+// POST to /api/v1/dimmers with the name and the duty cycle in percent,
+// which like switching outputs requires authentication (see outputs.go);
+// a GET lists them. A gauge follows a source expression instead, through
+// a scale of value and duty cycle pairs:
+//
+//   dimmers:
+//     saloon-lights:
+//       channel: 0
+//     fuel-gauge:
+//       address: 0x41 # the default is 0x40
+//       channel: 15
+//       source: sensors_tank_fill_percent{tank="diesel"}
+//       scale: [[0, 12], [100, 88]]
+//
+// All channels on a chip share one frequency, --pwm-frequency. The duty
+// cycles are exported as sensors_dimmer_duty_percent{dimmer="<name>"},
+// once set; the chip keeps them across restarts.
+
+type dimmerConfig struct {
+	Address int          `yaml:"address"`
+	Channel int          `yaml:"channel"`
+	Source  string       `yaml:"source"` // expression
+	Scale   [][2]float64 `yaml:"scale"`  // value and percent pairs
+}
+
+func init() {
+	registerSensor(sensorDef{
+		// The source may well be a virtual sensor.
+		name:    "dimmers",
+		order:   2,
+		enabled: func(o options) bool { return len(sections().Dimmers) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.PWMFrequency, sections().Dimmers}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initDimmers(ctx, bus, sections().Dimmers, cli().PWMFrequency)
+		},
+	})
+}
+
+func sortedDimmers(dims map[string]dimmerConfig) []string {
+	names := make([]string, 0, len(dims))
+	for name := range dims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateDimmers(dims map[string]dimmerConfig) error {
+	type channel struct{ address, channel int }
+	used := make(map[channel]string)
+	for _, name := range sortedDimmers(dims) {
+		dim := dims[name]
+		if dim.Address != 0 && (dim.Address < 0x40 || dim.Address > 0x7f) {
+			return fmt.Errorf("dimmer %s: invalid address 0x%02x (valid: 0x40 to 0x7f)", name, dim.Address)
+		}
+		if dim.Channel < 0 || dim.Channel > 15 {
+			return fmt.Errorf("dimmer %s: no channel %d (valid: 0 to 15)", name, dim.Channel)
+		}
+		ch := channel{dim.Address, dim.Channel}
+		if ch.address == 0 {
+			ch.address = pwm.PCA9685DefaultAddress
+		}
+		if other, ok := used[ch]; ok {
+			return fmt.Errorf("dimmer %s: channel %d already used by %s", name, dim.Channel, other)
+		}
+		used[ch] = name
+
+		if dim.Source == "" {
+			if len(dim.Scale) > 0 {
+				return fmt.Errorf("dimmer %s: scale without a source", name)
+			}
+			continue
+		}
+		if _, _, err := parseExpr(dim.Source); err != nil {
+			return fmt.Errorf("dimmer %s: source: %w", name, err)
+		}
+		if len(dim.Scale) < 2 {
+			return fmt.Errorf("dimmer %s: a source needs a scale of at least two points", name)
+		}
+		for i, p := range dim.Scale {
+			if p[1] < 0 || p[1] > 100 {
+				return fmt.Errorf("dimmer %s: scale duty cycle %v out of range (0 to 100)", name, p[1])
+			}
+			if i > 0 && p[0] <= dim.Scale[i-1][0] {
+				return fmt.Errorf("dimmer %s: scale values must be increasing", name)
+			}
+		}
+	}
+	return nil
+}
+
+type dimmer struct {
+	name    string
+	pca     *pwm.PCA9685
+	channel int
+	source  exprNode // nil for manual dimmers
+	scale   interpolation
+}
+
+// dimmerSet holds the dimmers currently set up, for the HTTP handler.
+type dimmerSet struct {
+	mut     sync.Mutex
+	dimmers map[string]*dimmer
+}
+
+var dimmable = &dimmerSet{dimmers: make(map[string]*dimmer)}
+
+var (
+	errNoSuchDimmer = errors.New("no such dimmer")
+	errDimmerSource = errors.New("dimmer follows its source")
+)
+
+// replace sets the dimmers, replacing any previous ones.
+func (s *dimmerSet) replace(dims []*dimmer) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.dimmers = make(map[string]*dimmer, len(dims))
+	for _, dim := range dims {
+		s.dimmers[dim.name] = dim
+	}
+}
+
+// release forgets the dimmers unless they have been replaced already. The
+// channels are left as they are.
+func (s *dimmerSet) release(dims []*dimmer) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, dim := range dims {
+		if s.dimmers[dim.name] == dim {
+			delete(s.dimmers, dim.name)
+		}
+	}
+}
+
+// set sets the duty cycle, in percent, of a manual dimmer.
+func (s *dimmerSet) set(name string, percent float64) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	dim, ok := s.dimmers[name]
+	if !ok {
+		return errNoSuchDimmer
+	}
+	if dim.source != nil {
+		return errDimmerSource
+	}
+	return dim.pca.SetDuty(dim.channel, percent/100)
+}
+
+// list returns the duty cycles in percent of the dimmers that have one.
+func (s *dimmerSet) list() map[string]float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	res := make(map[string]float64, len(s.dimmers))
+	for name, dim := range s.dimmers {
+		if d, ok := dim.pca.Duty(dim.channel); ok {
+			res[name] = d * 100
+		}
+	}
+	return res
+}
+
+func (s *dimmerSet) sources() []*dimmer {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var res []*dimmer
+	for _, dim := range s.dimmers {
+		if dim.source != nil {
+			res = append(res, dim)
+		}
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].name < res[b].name })
+	return res
+}
+
+func initDimmers(ctx context.Context, bus *i2c.Bus, dims map[string]dimmerConfig, freq float64) (func(), error) {
+	chips := make(map[int]*pwm.PCA9685)
+	var devs []sensor.Sensor
+	var list []*dimmer
+	for _, name := range sortedDimmers(dims) {
+		conf := dims[name]
+		addr := conf.Address
+		if addr == 0 {
+			addr = pwm.PCA9685DefaultAddress
+		}
+		pca, ok := chips[addr]
+		if !ok {
+			var err error
+			pca, err = pwm.NewPCA9685(bus, addr, freq)
+			if err != nil {
+				return nil, fmt.Errorf("PCA9685 at 0x%02x: %w", addr, err)
+			}
+			chips[addr] = pca
+			devs = append(devs, pca)
+		}
+		dim := &dimmer{name: name, pca: pca, channel: conf.Channel}
+		if conf.Source != "" {
+			dim.source, _, _ = parseExpr(conf.Source)
+			for _, p := range conf.Scale {
+				dim.scale.x = append(dim.scale.x, p[0])
+				dim.scale.y = append(dim.scale.y, p[1])
+			}
+		}
+		list = append(list, dim)
+	}
+
+	dimmable.replace(list)
+	meta.setDevices("dimmers", devs...)
+	onDone(ctx, func() { dimmable.release(list) })
+	return registerDimmers(), nil
+}
+
+func registerDimmers() func() {
+	duty := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "dimmer",
+		Name:      "duty_percent",
+	}, []string{"dimmer"})
+
+	errs := make(map[string]error)
+	return func() {
+		if srcs := dimmable.sources(); len(srcs) > 0 {
+			mfs, err := prometheus.DefaultGatherer.Gather()
+			if err != nil {
+				log.Println("Dimmers: gather metrics:", err)
+				return
+			}
+			lookup := gatheredLookup(mfs)
+			for _, dim := range srcs {
+				v, err := dim.source(lookup)
+				if err == nil {
+					err = dim.pca.SetDuty(dim.channel, dim.scale.val(v)/100)
+				}
+				if err != nil {
+					if prev := errs[dim.name]; prev == nil || prev.Error() != err.Error() {
+						log.Printf("Dimmer %s: %v", dim.name, err)
+					}
+				}
+				errs[dim.name] = err
+			}
+		}
+
+		for name, v := range dimmable.list() {
+			duty.WithLabelValues(name).Set(v)
+		}
+	}
+}
+
+// handleDimmers lists the dimmers on GET and sets the duty cycle of the
+// dimmer given by the "name" parameter to the "duty" parameter, in
+// percent, on POST.
+func handleDimmers(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		user, ok := authenticatedUser(req)
+		if !ok {
+			http.Error(w, "setting dimmers requires authentication to be set up", http.StatusForbidden)
+			return
+		}
+		duty, err := strconv.ParseFloat(req.FormValue("duty"), 64)
+		if err != nil || duty < 0 || duty > 100 {
+			http.Error(w, "duty: must be a number from 0 to 100", http.StatusBadRequest)
+			return
+		}
+		name := req.FormValue("name")
+		switch err := dimmable.set(name, duty); err {
+		case nil:
+		case errNoSuchDimmer:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errDimmerSource:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			log.Printf("Dimmer %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Dimmer %s set to %v%% by %s", name, duty, user)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dimmable.list())
+}
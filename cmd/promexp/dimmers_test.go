@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+	"github.com/calmh/boatpi/pwm"
+)
+
+func TestHandleDimmers(t *testing.T) {
+	dev := i2ctest.Registers{0xfe: 30} // awake at 200 Hz
+	pca, err := pwm.NewPCA9685(i2c.NewBus(dev), pwm.PCA9685DefaultAddress, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, _, _ := parseExpr("sensors_tank_fill_percent")
+	dims := []*dimmer{
+		{name: "lights", pca: pca, channel: 0},
+		{name: "gauge", pca: pca, channel: 1, source: source},
+	}
+	dimmable.replace(dims)
+	defer dimmable.release(dims)
+
+	defer setAuth(options{})
+	if err := setAuth(options{AuthTokens: []string{"skipper:s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/dimmers", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		handleDimmers(rec, req)
+		return rec
+	}
+
+	if rec := post(url.Values{"name": {"lights"}, "duty": {"25"}}); rec.Code != http.StatusOK {
+		t.Errorf("not set: %d %s", rec.Code, rec.Body)
+	} else if body := rec.Body.String(); !strings.Contains(body, `"lights":25`) {
+		t.Errorf("unexpected list %s", body)
+	}
+	if off := uint16(dev[0x08]) | uint16(dev[0x09])<<8; off != 1024 {
+		t.Errorf("channel 0 off at %d, expected 1024", off)
+	}
+	if rec := post(url.Values{"name": {"lights"}, "duty": {"150"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("duty out of range: %d", rec.Code)
+	}
+	if rec := post(url.Values{"name": {"gauge"}, "duty": {"50"}}); rec.Code != http.StatusConflict {
+		t.Errorf("set a dimmer with a source: %d", rec.Code)
+	}
+	if rec := post(url.Values{"name": {"fan"}, "duty": {"50"}}); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown dimmer: %d", rec.Code)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensehat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The Sense HAT LED matrix shows one page at a time, cycled by a timer or
+// with the joystick. The values shown are taken from the exported metrics
+// using the same expressions as virtual sensors, so that the heel page can
+// be adjusted to how the board is mounted. While an alarm is raised, the
+// matrix flashes red regardless of page.
+
+func init() {
+	registerSensor(sensorDef{
+		// The display shows values, including virtual ones.
+		name:    "display",
+		order:   2,
+		enabled: func(o options) bool { return o.WithDisplay },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.DisplayPages, o.DisplayBattery, o.DisplayHeel}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initDisplay(ctx)
+		},
+	})
+}
+
+var displayPages = map[string]func(lookupFunc) ([64]sensehat.Color, error){
+	"battery": func(lookup lookupFunc) ([64]sensehat.Color, error) {
+		v, err := displayExprs["battery"](lookup)
+		return batteryFrame(batteryState.val(v)), err
+	},
+	"heel": func(lookup lookupFunc) ([64]sensehat.Color, error) {
+		v, err := displayExprs["heel"](lookup)
+		return heelFrame(v), err
+	},
+	"alarms": func(lookupFunc) ([64]sensehat.Color, error) {
+		return alarmFrame(false, false), nil
+	},
+	"race": func(lookupFunc) ([64]sensehat.Color, error) {
+		now := time.Now()
+		r := race.report(now)
+		if r.Countdown == nil {
+			return [64]sensehat.Color{}, nil
+		}
+		return raceFrame(*r.Countdown, now.Second()%2 == 0), nil
+	},
+}
+
+var displayExprs = map[string]exprNode{}
+
+const displayMaxHeel = 30 // degrees at the edge of the matrix
+
+var (
+	colorOff    = sensehat.Color{}
+	colorRed    = sensehat.Color{R: 255}
+	colorYellow = sensehat.Color{R: 255, G: 160}
+	colorGreen  = sensehat.Color{G: 255}
+	colorDim    = sensehat.Color{R: 32, G: 32, B: 32}
+)
+
+func initDisplay(ctx context.Context) (func(), error) {
+	o := cli()
+	for _, page := range o.DisplayPages {
+		if _, ok := displayPages[page]; !ok {
+			return nil, fmt.Errorf("unknown display page %q", page)
+		}
+	}
+	if len(o.DisplayPages) == 0 {
+		return nil, fmt.Errorf("no display pages")
+	}
+	for name, src := range map[string]string{"battery": o.DisplayBattery, "heel": o.DisplayHeel} {
+		expr, _, err := parseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("display %s: %w", name, err)
+		}
+		displayExprs[name] = expr
+	}
+
+	matrix, err := sensehat.OpenLEDMatrix()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(chan sensehat.Key, 4)
+	joystick, err := sensehat.OpenJoystick()
+	if err != nil {
+		log.Println("Display: no joystick:", err)
+	} else {
+		go func() {
+			for {
+				key, err := joystick.Read()
+				if err != nil {
+					return
+				}
+				select {
+				case keys <- key:
+				default:
+				}
+			}
+		}()
+	}
+
+	onDone(ctx, func() {
+		if joystick != nil {
+			joystick.Close()
+		}
+		matrix.Set([64]sensehat.Color{})
+		matrix.Close()
+	})
+
+	pages := o.DisplayPages
+	page := 0
+	switched := time.Now()
+	flash := false
+	var lastErr error
+
+	return func() {
+		select {
+		case key := <-keys:
+			switch key {
+			case sensehat.KeyLeft, sensehat.KeyUp:
+				page = (page + len(pages) - 1) % len(pages)
+			default:
+				page = (page + 1) % len(pages)
+			}
+			switched = time.Now()
+		default:
+			if cycle := cli().DisplayCycle; cycle > 0 && time.Since(switched) >= cycle {
+				page = (page + 1) % len(pages)
+				switched = time.Now()
+			}
+		}
+		flash = !flash
+
+		var frame [64]sensehat.Color
+		if len(alarms.list()) > 0 {
+			frame = alarmFrame(true, flash)
+		} else {
+			mfs, err := prometheus.DefaultGatherer.Gather()
+			if err == nil {
+				frame, err = displayPages[pages[page]](gatheredLookup(mfs))
+			}
+			if err != nil && (lastErr == nil || err.Error() != lastErr.Error()) {
+				log.Printf("Display %s: %v", pages[page], err)
+			}
+			lastErr = err
+		}
+		if err := matrix.Set(frame); err != nil {
+			log.Println("Display:", err)
+		}
+	}, nil
+}
+
+// batteryFrame shows the state of charge as a bar filling the matrix from
+// the bottom.
+func batteryFrame(pct float64) [64]sensehat.Color {
+	var frame [64]sensehat.Color
+	rows := int(math.Round(pct / 100 * 8))
+	color := colorGreen
+	switch {
+	case pct < 25:
+		color = colorRed
+	case pct < 50:
+		color = colorYellow
+	}
+	for row := 8 - rows; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			frame[row*8+col] = color
+		}
+	}
+	return frame
+}
+
+// heelFrame shows the heel angle as a dot moving sideways from the centre
+// marks, which stay in place.
+func heelFrame(deg float64) [64]sensehat.Color {
+	var frame [64]sensehat.Color
+	frame[0*8+3], frame[0*8+4] = colorDim, colorDim
+	frame[7*8+3], frame[7*8+4] = colorDim, colorDim
+
+	x := 3.5 + deg/displayMaxHeel*3.5
+	col := int(math.Round(math.Max(0, math.Min(6, x-0.5))))
+	color := colorGreen
+	switch {
+	case math.Abs(deg) >= 20:
+		color = colorRed
+	case math.Abs(deg) >= 10:
+		color = colorYellow
+	}
+	for _, row := range []int{3, 4} {
+		frame[row*8+col] = color
+		frame[row*8+col+1] = color
+	}
+	return frame
+}
+
+// alarmFrame shows a raised alarm as a red flash, or a dim green dot when
+// all is well.
+func alarmFrame(raised, on bool) [64]sensehat.Color {
+	var frame [64]sensehat.Color
+	if !raised {
+		frame[3*8+3] = sensehat.Color{G: 64}
+		return frame
+	}
+	if on {
+		for i := range frame {
+			frame[i] = colorRed
+		}
+	}
+	return frame
+}
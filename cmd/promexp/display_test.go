@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calmh/boatpi/sensehat"
+)
+
+func TestDisplayDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "display")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "conf.yaml")
+	if err := ioutil.WriteFile(conf, []byte("display-pages: [heel, alarms]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, _, err := parseOptions([]string{"--config", conf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.DisplayPages) != 2 || opts.DisplayPages[0] != "heel" {
+		t.Errorf("unexpected pages %q", opts.DisplayPages)
+	}
+	for _, src := range []string{opts.DisplayBattery, opts.DisplayHeel} {
+		if _, _, err := parseExpr(src); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+}
+
+func TestHeelFrame(t *testing.T) {
+	lit := func(frame [64]sensehat.Color) (cols []int) {
+		for col := 0; col < 8; col++ {
+			if frame[3*8+col] != colorOff {
+				cols = append(cols, col)
+			}
+		}
+		return cols
+	}
+	for _, tc := range []struct {
+		deg   float64
+		first int
+	}{
+		{0, 3},
+		{-90, 0},
+		{90, 6},
+		{15, 5},
+	} {
+		cols := lit(heelFrame(tc.deg))
+		if len(cols) != 2 || cols[0] != tc.first {
+			t.Errorf("%v degrees: lit columns %v, expected %d and %d", tc.deg, cols, tc.first, tc.first+1)
+		}
+	}
+}
+
+func TestBatteryFrame(t *testing.T) {
+	frame := batteryFrame(50)
+	if frame[3*8] != colorOff || frame[4*8] != colorGreen {
+		t.Error("expected the bottom half lit at 50 %")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/onewire"
+	"github.com/calmh/boatpi/sensor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A DS18B20 conversion takes most of a second, so the probes are read in the
+// background and the update exports the readings taken since the last one.
+// The bus is rescanned now and then for probes added or replaced while
+// running; the series of probes that are gone expire.
+
+const ds18b20RescanInterval = time.Minute
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "ds18b20",
+		section: true,
+		enabled: func(o options) bool { return o.WithDS18B20 || strings.HasPrefix(o.SeaTemperature, "28-") },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.interval(o.UpdateInterval)}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			probes := &ds18b20Probes{readings: make(map[string]float64)}
+			if err := probes.rescan(); err != nil {
+				return nil, err
+			}
+			if len(probes.probes) == 0 {
+				return nil, errors.New("no probes found")
+			}
+			go probes.serve(ctx, conf.interval(cli().UpdateInterval))
+			return registerDS18B20(probes), nil
+		},
+	})
+}
+
+type ds18b20Probes struct {
+	mut      sync.Mutex
+	probes   []*onewire.DS18B20
+	readings map[string]float64 // by ID, since the last take
+	err      error              // of the latest failed read, if any
+}
+
+func (p *ds18b20Probes) serve(ctx context.Context, intv time.Duration) {
+	read := time.NewTicker(intv)
+	defer read.Stop()
+	scan := time.NewTicker(ds18b20RescanInterval)
+	defer scan.Stop()
+	for {
+		p.read(intv / 2)
+		select {
+		case <-read.C:
+		case <-scan.C:
+			if err := p.rescan(); err != nil {
+				log.Println("DS18B20:", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rescan updates the probes to those currently on the bus.
+func (p *ds18b20Probes) rescan() error {
+	ids, err := onewire.DS18B20s()
+	if err != nil {
+		return err
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	known := make(map[string]*onewire.DS18B20, len(p.probes))
+	for _, probe := range p.probes {
+		known[probe.ID()] = probe
+	}
+	probes := make([]*onewire.DS18B20, len(ids))
+	devs := make([]sensor.Sensor, len(ids))
+	for i, id := range ids {
+		probe, ok := known[id]
+		if !ok {
+			if p.probes != nil {
+				log.Println("DS18B20: found probe", id)
+			}
+			probe = onewire.NewDS18B20(id)
+		}
+		delete(known, id)
+		probes[i] = probe
+		devs[i] = probe
+	}
+	for id := range known {
+		log.Println("DS18B20: probe", id, "is gone")
+	}
+	p.probes = probes
+	meta.setDevices("ds18b20", devs...)
+	return nil
+}
+
+// read reads the probes, which takes a while.
+func (p *ds18b20Probes) read(age time.Duration) {
+	p.mut.Lock()
+	probes := p.probes
+	p.mut.Unlock()
+
+	var failed error
+	for _, probe := range probes {
+		if err := probe.Refresh(age); err != nil {
+			log.Println("DS18B20:", err)
+			failed = err
+			continue
+		}
+		p.mut.Lock()
+		p.readings[probe.ID()] = probe.Temperature()
+		p.mut.Unlock()
+	}
+	p.mut.Lock()
+	p.err = failed
+	p.mut.Unlock()
+}
+
+// take returns the readings since the last call, and the error of the
+// latest failed read.
+func (p *ds18b20Probes) take() (map[string]float64, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	readings := p.readings
+	p.readings = make(map[string]float64, len(readings))
+	return readings, p.err
+}
+
+func registerDS18B20(probes *ds18b20Probes) func() {
+	temp := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ds18b20",
+		Name:      "temperature_celsius",
+	}, []string{"id"})
+
+	return func() {
+		readings, err := probes.take()
+		for id, val := range readings {
+			temp.WithLabelValues(id).Set(val)
+			if id == cli().SeaTemperature {
+				seaTemperature().Set(val)
+			}
+		}
+		switch {
+		case err != nil:
+			health.failed("ds18b20", err)
+		case len(readings) > 0:
+			health.ok("ds18b20")
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/calmh/boatpi/onewire"
+)
+
+func TestDS18B20Rescan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prev := onewire.DevicesDir
+	onewire.DevicesDir = dir
+	defer func() { onewire.DevicesDir = prev }()
+
+	plug := func(id, data string) {
+		if err := os.MkdirAll(filepath.Join(dir, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, id, "w1_slave"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plug("28-000000000001", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n")
+
+	probes := &ds18b20Probes{readings: make(map[string]float64)}
+	if err := probes.rescan(); err != nil {
+		t.Fatal(err)
+	}
+	probes.read(0)
+	readings, err := probes.take()
+	if err != nil || len(readings) != 1 || readings["28-000000000001"] != 23.125 {
+		t.Fatalf("unexpected readings %v, %v", readings, err)
+	}
+	if readings, _ := probes.take(); len(readings) != 0 {
+		t.Errorf("readings %v taken twice", readings)
+	}
+
+	// A probe plugged in while running is read after a rescan, and one
+	// unplugged no longer is.
+	os.RemoveAll(filepath.Join(dir, "28-000000000001"))
+	plug("28-000000000002", "f6 ff 4b 46 7f ff 0a 10 d9 : crc=d9 YES\nf6 ff 4b 46 7f ff 0a 10 d9 t=-625\n")
+	if err := probes.rescan(); err != nil {
+		t.Fatal(err)
+	}
+	probes.read(0)
+	readings, err = probes.take()
+	if err != nil || len(readings) != 1 || readings["28-000000000002"] != -0.625 {
+		t.Errorf("unexpected readings %v, %v after replacing the probe", readings, err)
+	}
+}
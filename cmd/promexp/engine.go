@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/calmh/boatpi/gpio"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Engine speed is counted from pulses on a GPIO input, through an
+// optocoupler or a voltage divider: from the alternator W terminal, with
+// the number of alternator poles divided by two times the pulley ratio
+// pulses per revolution, or from a magnetic or hall pickup on the flywheel
+// or a shaft. The engines are listed in their own section:
+//
+//   engines:
+//     main:
+//       pin: 22
+//       pulses-per-revolution: 15.6
+//       edge: falling # rising (the default), falling or both
+
+type engineConfig struct {
+	Pin                 int     `yaml:"pin"`
+	PulsesPerRevolution float64 `yaml:"pulses-per-revolution"`
+	Edge                string  `yaml:"edge"`
+}
+
+var pulseEdges = map[string]gpio.Edge{"": gpio.RisingEdge, "rising": gpio.RisingEdge, "falling": gpio.FallingEdge, "both": gpio.BothEdges}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "engines",
+		enabled: func(o options) bool { return len(sections().Engines) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.GPIOChip, sections().Engines}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			var inputs []*engineInput
+			engines := sections().Engines
+			for _, name := range sortedEngines(engines) {
+				eng := engines[name]
+				counter, err := gpio.RequestCounter(cli().GPIOChip, eng.Pin, pulseEdges[eng.Edge])
+				if err != nil {
+					for _, in := range inputs {
+						in.counter.Close()
+					}
+					return nil, fmt.Errorf("engine %s: %w", name, err)
+				}
+				inputs = append(inputs, &engineInput{engineConfig: eng, name: name, counter: counter})
+			}
+			onDone(ctx, func() {
+				for _, in := range inputs {
+					in.counter.Close()
+				}
+			})
+			return registerEngines(inputs), nil
+		},
+	})
+}
+
+func sortedEngines(engs map[string]engineConfig) []string {
+	names := make([]string, 0, len(engs))
+	for name := range engs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateEngines(engs map[string]engineConfig) error {
+	pins := make(map[int]string)
+	for _, name := range sortedEngines(engs) {
+		eng := engs[name]
+		if eng.PulsesPerRevolution <= 0 {
+			return fmt.Errorf("engine %s: pulses per revolution must be positive", name)
+		}
+		if _, ok := pulseEdges[eng.Edge]; !ok {
+			return fmt.Errorf("engine %s: unknown edge %q (valid: rising, falling, both)", name, eng.Edge)
+		}
+		if other, ok := pins[eng.Pin]; ok {
+			return fmt.Errorf("engine %s: pin %d already used by %s", name, eng.Pin, other)
+		}
+		pins[eng.Pin] = name
+	}
+	return nil
+}
+
+type engineInput struct {
+	engineConfig
+	name    string
+	counter *gpio.Counter
+	rate    pulseRate
+}
+
+func registerEngines(inputs []*engineInput) func() {
+	rpm := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "engine",
+		Name:      "rpm",
+		Help:      "Engine speed in revolutions per minute.",
+	}, []string{"engine"})
+
+	revs := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "engine",
+		Name:      "revolutions_total",
+		Help:      "Engine revolutions counted since start; the rate over time is the average speed.",
+	}, []string{"engine"})
+
+	return func() {
+		now := time.Now()
+		for _, in := range inputs {
+			count, last := in.counter.Pulses()
+			n, rate := in.rate.update(now, count, last)
+			rpm.WithLabelValues(in.name).Set(rate * 60 / in.PulsesPerRevolution)
+			revs.WithLabelValues(in.name).Add(float64(n) / in.PulsesPerRevolution)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/expander"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// GPIO expanders are opened on first use and shared by whoever has pins on
+// them, as the PCF8574 only works that way.
+
+type gpioExpander interface {
+	sensor.Sensor
+	Pins() int
+	ConfigureInput(pin int, pullUp bool) error
+	ConfigureOutput(pin int, high bool) error
+	SetLevel(pin int, high bool) error
+	Level(pin int) bool
+}
+
+type expanderKind struct {
+	pins    int
+	address int // default
+	open    func(bus *i2c.Bus, addr int) (gpioExpander, error)
+}
+
+var expanderKinds = map[string]expanderKind{
+	"mcp23017": {16, expander.MCP23017DefaultAddress, func(bus *i2c.Bus, addr int) (gpioExpander, error) {
+		return expander.NewMCP23017(bus, addr)
+	}},
+	"pcf8574": {8, expander.PCF8574DefaultAddress, func(bus *i2c.Bus, addr int) (gpioExpander, error) {
+		return expander.NewPCF8574(bus, addr)
+	}},
+}
+
+func expanderKindNames() string {
+	var names []string
+	for name := range expanderKinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// validateExpanderPin checks the pin on the expander of the kind, at the
+// address or the default one.
+func validateExpanderPin(kind string, addr, pin int) error {
+	k, ok := expanderKinds[kind]
+	if !ok {
+		return fmt.Errorf("unknown expander %q (valid: %s)", kind, expanderKindNames())
+	}
+	if addr != 0 && (addr < 0x03 || addr > 0x77) {
+		return fmt.Errorf("invalid address 0x%02x", addr)
+	}
+	if pin < 0 || pin >= k.pins {
+		return fmt.Errorf("no pin %d on the %s (valid: 0 to %d)", pin, kind, k.pins-1)
+	}
+	return nil
+}
+
+// pinKey identifies a pin on the Pi's GPIO, if kind is empty, or on an
+// expander, for checking that no pin is used twice.
+func pinKey(kind string, addr, pin int) inputConfig {
+	if kind != "" && addr == 0 {
+		addr = expanderKinds[kind].address
+	}
+	return inputConfig{Expander: kind, Address: addr, Pin: pin}
+}
+
+type expanderKey struct {
+	kind    string
+	address int
+}
+
+var openExpanders = struct {
+	mut  sync.Mutex
+	devs map[expanderKey]gpioExpander
+}{devs: make(map[expanderKey]gpioExpander)}
+
+// sharedExpander returns the expander of the kind at the address, or the
+// default one, opening it if not already open.
+func sharedExpander(bus *i2c.Bus, kind string, addr int) (gpioExpander, error) {
+	k, ok := expanderKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown expander %q", kind)
+	}
+	if addr == 0 {
+		addr = k.address
+	}
+
+	openExpanders.mut.Lock()
+	defer openExpanders.mut.Unlock()
+	key := expanderKey{kind, addr}
+	if dev, ok := openExpanders.devs[key]; ok {
+		return dev, nil
+	}
+	dev, err := k.open(bus, addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s at 0x%02x: %w", kind, addr, err)
+	}
+	openExpanders.devs[key] = dev
+	return dev, nil
+}
+
+// expanderPin is a pin on an expander.
+type expanderPin struct {
+	exp       gpioExpander
+	pin       int
+	activeLow bool
+}
+
+// value returns whether the input pin is active, refreshing the expander
+// if it was not read in this round of updates.
+func (p expanderPin) value() (bool, error) {
+	if err := p.exp.Refresh(100 * time.Millisecond); err != nil {
+		return false, err
+	}
+	return p.exp.Level(p.pin) != p.activeLow, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/calmh/boatpi/store"
+)
+
+// A small arithmetic expression language for virtual sensors. Operands are
+// numbers and metric series, optionally with a label selector:
+//
+//   sensors_omini_voltage{channel="a"} * sensors_ina219_current_amperes
+//
+// The operators + - * / and parentheses are supported, as are the
+// functions abs, sqrt, min and max.
+
+type seriesRef struct {
+	name   string
+	labels map[string]string
+}
+
+// String returns the series as named in expressions and the local store.
+func (r seriesRef) String() string {
+	return store.SeriesName(r.name, r.labels)
+}
+
+// A lookupFunc returns the current value of a series.
+type lookupFunc func(seriesRef) (float64, error)
+
+type exprNode func(lookupFunc) (float64, error)
+
+var exprFuncs = map[string]func([]float64) (float64, error){
+	"abs":  unaryFunc(math.Abs),
+	"sqrt": unaryFunc(math.Sqrt),
+	"min":  func(args []float64) (float64, error) { return foldFunc(args, math.Min) },
+	"max":  func(args []float64) (float64, error) { return foldFunc(args, math.Max) },
+}
+
+func unaryFunc(fn func(float64) float64) func([]float64) (float64, error) {
+	return func(args []float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("expected one argument, got %d", len(args))
+		}
+		return fn(args[0]), nil
+	}
+}
+
+func foldFunc(args []float64, fn func(a, b float64) float64) (float64, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("expected at least one argument")
+	}
+	res := args[0]
+	for _, v := range args[1:] {
+		res = fn(res, v)
+	}
+	return res, nil
+}
+
+type exprParser struct {
+	src  string
+	pos  int
+	refs []seriesRef
+}
+
+// parseExpr parses the expression and returns it along with the series it
+// refers to.
+func parseExpr(src string) (exprNode, []seriesRef, error) {
+	p := &exprParser{src: src}
+	n, err := p.expr()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, nil, p.errorf("unexpected %q", p.src[p.pos:])
+	}
+	return n, p.refs, nil
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes the next non-space character if it is one of chars.
+func (p *exprParser) accept(chars string) (byte, bool) {
+	p.skipSpace()
+	if p.pos < len(p.src) && strings.IndexByte(chars, p.src[p.pos]) >= 0 {
+		p.pos++
+		return p.src[p.pos-1], true
+	}
+	return 0, false
+}
+
+func (p *exprParser) expr() (exprNode, error) {
+	lhs, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+-")
+		if !ok {
+			return lhs, nil
+		}
+		rhs, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryNode(op, lhs, rhs)
+	}
+}
+
+func (p *exprParser) term() (exprNode, error) {
+	lhs, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*/")
+		if !ok {
+			return lhs, nil
+		}
+		rhs, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryNode(op, lhs, rhs)
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if _, ok := p.accept("-"); ok {
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(l lookupFunc) (float64, error) {
+			v, err := n(l)
+			return -v, err
+		}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	if _, ok := p.accept("("); ok {
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, p.errorf("expected )")
+		}
+		return n, nil
+	}
+
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) && (isIdentChar(p.src[p.pos]) || p.src[p.pos] == '.') {
+		p.pos++
+	}
+	word := p.src[start:p.pos]
+	if word == "" {
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unexpected end of expression")
+		}
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+
+	if v, err := strconv.ParseFloat(word, 64); err == nil {
+		return func(lookupFunc) (float64, error) { return v, nil }, nil
+	}
+	if !isIdentStart(word[0]) || strings.Contains(word, ".") {
+		return nil, p.errorf("invalid name %q", word)
+	}
+
+	if _, ok := p.accept("("); ok {
+		return p.call(word)
+	}
+
+	ref := seriesRef{name: word}
+	if _, ok := p.accept("{"); ok {
+		labels, err := p.labels()
+		if err != nil {
+			return nil, err
+		}
+		ref.labels = labels
+	}
+	p.refs = append(p.refs, ref)
+	return func(l lookupFunc) (float64, error) { return l(ref) }, nil
+}
+
+func (p *exprParser) call(name string) (exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, p.errorf("unknown function %q", name)
+	}
+	var args []exprNode
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(","); ok {
+				continue
+			}
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			return nil, p.errorf("expected , or )")
+		}
+	}
+	return func(l lookupFunc) (float64, error) {
+		vals := make([]float64, len(args))
+		for i, arg := range args {
+			v, err := arg(l)
+			if err != nil {
+				return 0, err
+			}
+			vals[i] = v
+		}
+		v, err := fn(vals)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", name, err)
+		}
+		return v, nil
+	}, nil
+}
+
+// labels parses a label selector, after the opening brace.
+func (p *exprParser) labels() (map[string]string, error) {
+	labels := make(map[string]string)
+	for {
+		if _, ok := p.accept("}"); ok {
+			return labels, nil
+		}
+		p.skipSpace()
+		start := p.pos
+		for p.pos < len(p.src) && isIdentChar(p.src[p.pos]) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		if name == "" {
+			return nil, p.errorf("expected label name")
+		}
+		if _, ok := p.accept("="); !ok {
+			return nil, p.errorf("expected =")
+		}
+		if _, ok := p.accept(`"`); !ok {
+			return nil, p.errorf("expected quoted label value")
+		}
+		end := strings.IndexByte(p.src[p.pos:], '"')
+		if end < 0 {
+			return nil, p.errorf("unterminated label value")
+		}
+		labels[name] = p.src[p.pos : p.pos+end]
+		p.pos += end + 1
+		if _, ok := p.accept(","); !ok {
+			if _, ok := p.accept("}"); ok {
+				return labels, nil
+			}
+			return nil, p.errorf("expected , or }")
+		}
+	}
+}
+
+func binaryNode(op byte, lhs, rhs exprNode) exprNode {
+	return func(l lookupFunc) (float64, error) {
+		a, err := lhs(l)
+		if err != nil {
+			return 0, err
+		}
+		b, err := rhs(l)
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '+':
+			return a + b, nil
+		case '-':
+			return a - b, nil
+		case '*':
+			return a * b, nil
+		default:
+			return a / b, nil
+		}
+	}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
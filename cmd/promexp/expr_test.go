@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	values := map[string]float64{
+		"volts":                              12.5,
+		"amps":                               -2,
+		`sensors_omini_voltage{channel="a"}`: 12.8,
+	}
+	lookup := func(ref seriesRef) (float64, error) {
+		v, ok := values[ref.String()]
+		if !ok {
+			return 0, fmt.Errorf("no series %v", ref)
+		}
+		return v, nil
+	}
+
+	cases := []struct {
+		expr string
+		res  float64
+	}{
+		{"volts * amps", -25},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"-amps / 4", 0.5},
+		{"abs(amps) + max(1, 3, 2)", 5},
+		{`sensors_omini_voltage{channel="a"} - volts`, 0.3},
+		{"2.5e1", 25},
+	}
+	for _, tc := range cases {
+		n, _, err := parseExpr(tc.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		res, err := n(lookup)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if d := res - tc.res; d > 1e-9 || d < -1e-9 {
+			t.Errorf("%q = %v, expected %v", tc.expr, res, tc.res)
+		}
+	}
+
+	for _, expr := range []string{"", "1 +", "(1", "foo(1)", `x{a=b}`, "1 2", "1.2.3"} {
+		if _, _, err := parseExpr(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
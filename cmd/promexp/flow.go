@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/calmh/boatpi/gpio"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Flow sensors with a pulse output, a turbine or an oval gear, count the
+// fuel to the engine or the water from the tanks on GPIO inputs, as the
+// engine speed does. The calibration is in pulses per litre, from the data
+// sheet or better from filling a measured jerry can. A diesel engine
+// returns much of its fuel to the tank, so with a sensor on each line its
+// consumption is the supply less the return, as a virtual sensor.
+//
+//   flows:
+//     fuel-supply:
+//       pin: 23
+//       pulses-per-litre: 2200
+//     fuel-return:
+//       pin: 24
+//       pulses-per-litre: 2200
+//     fresh-water:
+//       pin: 25
+//       pulses-per-litre: 450
+//       edge: both # rising (the default), falling or both
+//
+// The volumes are cumulative, kept in the state file across restarts.
+
+const flowSaveInterval = 10 * time.Minute
+
+type flowConfig struct {
+	Pin            int     `yaml:"pin"`
+	PulsesPerLitre float64 `yaml:"pulses-per-litre"`
+	Edge           string  `yaml:"edge"`
+}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "flows",
+		enabled: func(o options) bool { return len(sections().Flows) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.GPIOChip, sections().Flows, o.FlowStateFile}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initFlows(ctx, sections().Flows, cli().FlowStateFile)
+		},
+	})
+}
+
+func sortedFlows(fls map[string]flowConfig) []string {
+	names := make([]string, 0, len(fls))
+	for name := range fls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateFlows checks the flow sensors, and that their pins are not
+// also used for engines.
+func validateFlows(fls map[string]flowConfig, engs map[string]engineConfig) error {
+	pins := make(map[int]string)
+	for name, eng := range engs {
+		pins[eng.Pin] = "engine " + name
+	}
+	for _, name := range sortedFlows(fls) {
+		fl := fls[name]
+		if fl.PulsesPerLitre <= 0 {
+			return fmt.Errorf("flow %s: pulses per litre must be positive", name)
+		}
+		if _, ok := pulseEdges[fl.Edge]; !ok {
+			return fmt.Errorf("flow %s: unknown edge %q (valid: rising, falling, both)", name, fl.Edge)
+		}
+		if other, ok := pins[fl.Pin]; ok {
+			return fmt.Errorf("flow %s: pin %d already used by %s", name, fl.Pin, other)
+		}
+		pins[fl.Pin] = name
+	}
+	return nil
+}
+
+// flowMeter is the count of one flow sensor.
+type flowMeter struct {
+	Litres float64 `json:"litres"` // cumulative
+
+	name           string
+	pulsesPerLitre float64
+	counter        *gpio.Counter
+	rate           pulseRate
+}
+
+// observe adds the new pulses to the volume and returns the flow rate in
+// litres per hour.
+func (m *flowMeter) observe(now time.Time, count uint64, last time.Duration) float64 {
+	n, rate := m.rate.update(now, count, last)
+	m.Litres += float64(n) / m.pulsesPerLitre
+	return rate * 3600 / m.pulsesPerLitre
+}
+
+func initFlows(ctx context.Context, fls map[string]flowConfig, file string) (func(), error) {
+	saved := loadFlowState(file)
+	var meters []*flowMeter
+	for _, name := range sortedFlows(fls) {
+		fl := fls[name]
+		counter, err := gpio.RequestCounter(cli().GPIOChip, fl.Pin, pulseEdges[fl.Edge])
+		if err != nil {
+			for _, m := range meters {
+				m.counter.Close()
+			}
+			return nil, fmt.Errorf("flow %s: %w", name, err)
+		}
+		meters = append(meters, &flowMeter{
+			Litres:         saved[name].Litres,
+			name:           name,
+			pulsesPerLitre: fl.PulsesPerLitre,
+			counter:        counter,
+		})
+	}
+
+	onDone(ctx, func() {
+		for _, m := range meters {
+			m.counter.Close()
+		}
+		if err := saveFlowState(file, meters); err != nil {
+			log.Println("Save flow state:", err)
+		}
+	})
+	return registerFlows(meters, file), nil
+}
+
+func registerFlows(meters []*flowMeter, file string) func() {
+	rate := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "flow",
+		Name:      "rate_litres_per_hour",
+		Help:      "Flow rate, zero when the pulses have stopped.",
+	}, []string{"name"})
+
+	volume := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "flow",
+		Name:      "volume_litres",
+		Help:      "Volume through the sensor, cumulative across restarts.",
+	}, []string{"name"})
+
+	saved := time.Now()
+	return func() {
+		now := time.Now()
+		for _, m := range meters {
+			count, last := m.counter.Pulses()
+			rate.WithLabelValues(m.name).Set(m.observe(now, count, last))
+			volume.WithLabelValues(m.name).Set(m.Litres)
+		}
+
+		if now.Sub(saved) >= flowSaveInterval {
+			if err := saveFlowState(file, meters); err != nil {
+				log.Println("Save flow state:", err)
+			}
+			saved = now
+		}
+	}
+}
+
+func loadFlowState(file string) map[string]flowMeter {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer fd.Close()
+	var saved map[string]flowMeter
+	if err := json.NewDecoder(fd).Decode(&saved); err != nil {
+		log.Println("Load flow state:", err)
+		return nil
+	}
+	return saved
+}
+
+func saveFlowState(file string, meters []*flowMeter) error {
+	state := make(map[string]*flowMeter, len(meters))
+	for _, m := range meters {
+		state[m.name] = m
+	}
+	tmp := file + ".tmp"
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fd).Encode(state); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
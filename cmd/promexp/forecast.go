@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A local forecast in the manner of the Negretti & Zambra "Zambretti"
+// forecaster, from the sea level pressure, its three hour tendency and,
+// when available, the wind direction. It's a rule of thumb for the next
+// twelve hours or so, best in temperate waters.
+
+// The forecast letter for each of the 32 Zambretti numbers: 1-9 when
+// falling, 10-19 when steady and 20-32 when rising.
+const zambrettiLetters = "ABDHORUXZ" + "ABEKNPSWXZ" + "ABCFGIJLMQTYZ"
+
+var zambrettiTexts = map[byte]string{
+	'A': "Settled fine",
+	'B': "Fine weather",
+	'C': "Becoming fine",
+	'D': "Fine, becoming less settled",
+	'E': "Fine, possible showers",
+	'F': "Fairly fine, improving",
+	'G': "Fairly fine, possible showers early",
+	'H': "Fairly fine, showery later",
+	'I': "Showery early, improving",
+	'J': "Changeable, mending",
+	'K': "Fairly fine, showers likely",
+	'L': "Rather unsettled, clearing later",
+	'M': "Unsettled, probably improving",
+	'N': "Showery, bright intervals",
+	'O': "Showery, becoming less settled",
+	'P': "Changeable, some rain",
+	'Q': "Unsettled, short fine intervals",
+	'R': "Unsettled, rain later",
+	'S': "Unsettled, some rain",
+	'T': "Mostly very unsettled",
+	'U': "Occasional rain, worsening",
+	'V': "Rain at times, very unsettled",
+	'W': "Rain at frequent intervals",
+	'X': "Rain, very unsettled",
+	'Y': "Stormy, may improve",
+	'Z': "Stormy, much rain",
+}
+
+// Pressure adjustments in hPa for the wind direction, by sixteen point
+// compass direction starting at north, for the northern hemisphere.
+var zambrettiWind = [16]float64{6, 5, 5, 2, -0.5, -2, -5, -8.5, -12, -10, -6, -4.5, -3, -0.5, 1.5, 3}
+
+// zambretti returns the forecast letter for the sea level pressure and
+// its three hour change, in hPa. The wind direction is in degrees, NaN if
+// unknown.
+func zambretti(p, delta, wind float64, south bool) byte {
+	if !math.IsNaN(wind) {
+		if south {
+			wind += 180
+		}
+		p += zambrettiWind[int(math.Mod(math.Mod(wind+11.25, 360)+360, 360)/22.5)]
+	}
+	var z, min, max float64
+	switch pressureTendencyState(delta) {
+	case "falling":
+		z, min, max = 130-p/8.1, 1, 9
+	case "steady":
+		z, min, max = 147-5*p/37.6, 10, 19
+	default:
+		z, min, max = 179-2*p/12.9, 20, 32
+	}
+	z = math.Max(min, math.Min(max, math.Round(z)))
+	return zambrettiLetters[int(z)-1]
+}
+
+type forecastReport struct {
+	Letter   string    `json:"letter"`
+	Text     string    `json:"text"`
+	Pressure float64   `json:"pressure"`
+	Tendency float64   `json:"tendency"`
+	Wind     *float64  `json:"wind,omitempty"`
+	Updated  time.Time `json:"updated"`
+}
+
+type currentForecast struct {
+	mut    sync.Mutex
+	report *forecastReport
+}
+
+var forecast = &currentForecast{}
+
+func (f *currentForecast) set(r *forecastReport) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.report = r
+}
+
+func (f *currentForecast) get() *forecastReport {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.report
+}
+
+func handleForecast(w http.ResponseWriter, req *http.Request) {
+	r := forecast.get()
+	if r == nil {
+		http.Error(w, "no forecast yet; it needs three hours of pressure history", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r)
+}
+
+// newForecaster returns a function that updates the forecast from the
+// pressure and tendency of the barometer exporting under subsystem, with the wind direction from the expression if
+// it is not nil.
+func newForecaster(subsystem string, windExpr exprNode) func(p, delta float64) {
+	code := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: subsystem,
+		Name:      "forecast_zambretti",
+		Help:      "Zambretti forecast letter as a number, from 1 (A, settled fine) to 26 (Z, stormy).",
+	})
+
+	var lastErr error
+	return func(p, delta float64) {
+		r := &forecastReport{Pressure: p, Tendency: delta, Updated: time.Now()}
+		wind := math.NaN()
+		if windExpr != nil {
+			mfs, err := prometheus.DefaultGatherer.Gather()
+			var v float64
+			if err == nil {
+				v, err = windExpr(gatheredLookup(mfs))
+			}
+			if err != nil {
+				if lastErr == nil || lastErr.Error() != err.Error() {
+					log.Println("Forecast wind direction:", err)
+				}
+			} else {
+				wind = v
+				r.Wind = &v
+			}
+			lastErr = err
+		}
+		letter := zambretti(p, delta, wind, cli().ForecastSouthern)
+		r.Letter = string(letter)
+		r.Text = zambrettiTexts[letter]
+		code.Set(float64(letter - 'A' + 1))
+		forecast.set(r)
+	}
+}
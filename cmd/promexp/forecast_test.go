@@ -0,0 +1,37 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestZambretti(t *testing.T) {
+	cases := []struct {
+		p, delta, wind float64
+		south          bool
+		exp            byte
+	}{
+		{1035, 0, math.NaN(), false, 'A'},
+		{1020, 0, math.NaN(), false, 'B'},
+		{1020, -2, math.NaN(), false, 'H'},
+		{1020, 2, math.NaN(), false, 'B'},
+		{990, -3, math.NaN(), false, 'X'},
+		{990, 3, math.NaN(), false, 'J'},
+		{950, -5, math.NaN(), false, 'Z'},
+		// A southerly wind lowers the effective pressure in the northern
+		// hemisphere, a northerly raises it; the reverse in the southern.
+		{1020, -2, 180, false, 'R'},
+		{1020, -2, 355, false, 'D'},
+		{1020, -2, 0, true, 'R'},
+	}
+	for _, tc := range cases {
+		if res := zambretti(tc.p, tc.delta, tc.wind, tc.south); res != tc.exp {
+			t.Errorf("zambretti(%v, %v, %v, %v) = %c, expected %c", tc.p, tc.delta, tc.wind, tc.south, res, tc.exp)
+		}
+	}
+	for i := 0; i < len(zambrettiLetters); i++ {
+		if zambrettiTexts[zambrettiLetters[i]] == "" {
+			t.Errorf("no text for %c", zambrettiLetters[i])
+		}
+	}
+}
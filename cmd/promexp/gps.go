@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/gps"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "gps",
+		section: true,
+		enabled: func(o options) bool { return o.WithGPS || o.Simulate },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.GPSD, o.GPSDevice, o.GPSBaudRate,
+				o.DeadReckoning, o.DeadReckoningHead, o.DeadReckoningSpeed, o.Simulate, o.SimulateRoute, o.SimulateSpeed, o.SimulateHeading, o.SimulateDepth}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			o := cli()
+			var dr *deadReckoner
+			if o.DeadReckoning > 0 {
+				var err error
+				dr, err = newDeadReckoner(o.DeadReckoning, o.DeadReckoningHead, o.DeadReckoningSpeed)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			var g *gps.GPS
+			var err error
+			kind := "serial"
+			if o.Simulate {
+				g, err = gps.NewSimulator(ctx, simulation(*o))
+				kind = "simulator"
+			} else if o.GPSD != "" {
+				g, err = gps.NewGPSD(ctx, o.GPSD)
+				kind = "gpsd"
+			} else {
+				g, err = gps.NewSerial(ctx, o.GPSDevice, o.GPSBaudRate)
+			}
+			if err != nil {
+				return nil, err
+			}
+			g.SetForwarder(nmeaForward)
+			return registerGPS(g, kind, dr), nil
+		},
+	})
+}
+
+// registerGPS returns the update function for the GPS, which estimates the
+// position with dr, if not nil, when the fix is lost.
+func registerGPS(g *gps.GPS, kind string, dr *deadReckoner) func() {
+	pos := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "position_degrees",
+	}, []string{"axis"})
+
+	sog := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "speed_over_ground_knots",
+	})
+
+	cog := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "course_over_ground_degrees",
+	})
+
+	quality := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "fix_quality",
+	})
+
+	sats := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "satellites",
+	})
+
+	estimated := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "position_estimated",
+		Help:      "1 while the position is estimated by dead reckoning, 0 on a GPS fix.",
+	})
+
+	fixAge := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "fix_age_seconds",
+	})
+
+	sentences := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "sentences_total",
+		Help:      "Valid NMEA sentences received, per talker.",
+	}, []string{"talker"})
+	malformed := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "malformed_sentences_total",
+		Help:      "NMEA sentences dropped as malformed, per talker and reason.",
+	}, []string{"talker", "reason"})
+
+	remoteAlarm := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "gps",
+		Name:      "remote_alarm",
+		Help:      "Alarms reported by other instruments on the NMEA input (ALR and MOB), 1 while active.",
+	}, []string{"talker", "id", "kind"})
+
+	links := newLinkMetrics()
+
+	// Values are only set when new data has been received, so that they
+	// expire if the receiver or talker goes silent.
+	var lastReceived, lastUpdated, lastWater time.Time
+	var reconnects uint64
+	prev := make(map[string]gps.TalkerStats)
+	active := make(map[string]gps.Alarm)
+	return func() {
+		r := g.Reconnects()
+		links.set(kind, g.Name(), g.Connected(), r-reconnects)
+		reconnects = r
+
+		active = raiseRemoteAlarms(g.Alarms(), active, func(a gps.Alarm, on bool) {
+			kind := "alr"
+			if a.MOB {
+				kind = "mob"
+			}
+			if on {
+				remoteAlarm.WithLabelValues(a.Talker, a.ID, kind).Set(1)
+			} else {
+				remoteAlarm.WithLabelValues(a.Talker, a.ID, kind).Set(0)
+			}
+		})
+
+		for talker, st := range g.Stats() {
+			p := prev[talker]
+			sentences.WithLabelValues(talker).Add(float64(st.Sentences - p.Sentences))
+			malformed.WithLabelValues(talker, "checksum").Add(float64(st.Checksum - p.Checksum))
+			malformed.WithLabelValues(talker, "length").Add(float64(st.Length - p.Length))
+			malformed.WithLabelValues(talker, "fields").Add(float64(st.Fields - p.Fields))
+			prev[talker] = st
+		}
+
+		if received := g.Received(); received != lastReceived {
+			quality.Set(float64(g.FixQuality()))
+			sats.Set(float64(g.Satellites()))
+			lastReceived = received
+			health.ok("gps")
+		}
+
+		if cli().SeaTemperature == "nmea" {
+			if temp, when := g.WaterTemperature(); when != lastWater {
+				seaTemperature().Set(temp)
+				lastWater = when
+			}
+		}
+
+		updated := g.Updated()
+		if updated.IsZero() {
+			return
+		}
+		fixAge.Set(time.Since(updated).Seconds())
+		if updated == lastUpdated {
+			if dr == nil || time.Since(updated) < deadReckoningDelay {
+				return
+			}
+			mfs, err := prometheus.DefaultGatherer.Gather()
+			if err != nil {
+				return
+			}
+			if p, ok := dr.estimate(time.Now(), gatheredLookup(mfs)); ok {
+				pos.WithLabelValues("latitude").Set(p.Lat)
+				pos.WithLabelValues("longitude").Set(p.Lon)
+				estimated.Set(1)
+			}
+			return
+		}
+		lastUpdated = updated
+
+		lat, lon := g.Position()
+		if dr != nil {
+			dr.setFix(racePoint{lat, lon}, updated)
+		}
+		pos.WithLabelValues("latitude").Set(lat)
+		pos.WithLabelValues("longitude").Set(lon)
+		estimated.Set(0)
+		sog.Set(g.SpeedOverGround())
+		cog.Set(g.CourseOverGround())
+	}
+}
+
+// raiseRemoteAlarms raises latched alarms for the newly active alarms
+// reported by other instruments, and calls set for each alarm that is or
+// was active since the previous call. It returns the currently active
+// alarms, to be passed as prev to the next call. Like the detector alarms,
+// they stay raised until reset even if the instrument clears them. MOB
+// device tests are logged only.
+func raiseRemoteAlarms(list []gps.Alarm, prev map[string]gps.Alarm, set func(a gps.Alarm, active bool)) map[string]gps.Alarm {
+	cur := make(map[string]gps.Alarm, len(list))
+	for _, a := range list {
+		key := a.Key()
+		cur[key] = a
+		set(a, true)
+		if p, ok := prev[key]; ok && p.Raised.Equal(a.Raised) {
+			continue
+		}
+		switch {
+		case a.Test:
+			log.Printf("MOB device %s test from %s", a.ID, a.Talker)
+		case a.MOB && a.HasPosition:
+			raiseAlarm(notice{
+				Alarm:       key,
+				Kind:        "mob",
+				Message:     fmt.Sprintf("man overboard, device %s at %.5f, %.5f", a.ID, a.Lat, a.Lon),
+				Lat:         a.Lat,
+				Lon:         a.Lon,
+				HasPosition: true,
+			})
+		case a.MOB:
+			raiseAlarm(notice{Alarm: key, Kind: "mob", Message: fmt.Sprintf("man overboard, device %s", a.ID)})
+		default:
+			raiseAlarm(notice{Alarm: key, Kind: "alr", Message: fmt.Sprintf("%s alarm %s: %s", a.Talker, a.ID, a.Text)})
+		}
+	}
+	for key, a := range prev {
+		if _, ok := cur[key]; !ok {
+			set(a, false)
+		}
+	}
+	return cur
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/gps"
+)
+
+func TestRaiseRemoteAlarms(t *testing.T) {
+	defer alarms.reset("mob-ai-12345")
+	defer alarms.reset("alr-ii-012")
+
+	now := time.Now()
+	mob := gps.Alarm{Talker: "AI", ID: "12345", MOB: true, Active: true, Raised: now}
+	test := gps.Alarm{Talker: "AI", ID: "54321", MOB: true, Active: true, Test: true, Raised: now}
+	alr := gps.Alarm{Talker: "II", ID: "012", Active: true, Text: "Shallow water", Raised: now}
+
+	set := make(map[string]bool)
+	record := func(a gps.Alarm, on bool) { set[a.Key()] = on }
+
+	prev := raiseRemoteAlarms([]gps.Alarm{alr, mob, test}, nil, record)
+	if !alarms.isRaised("mob-ai-12345") || !alarms.isRaised("alr-ii-012") {
+		t.Error("expected MOB and ALR alarms raised")
+	}
+	if alarms.isRaised("mob-ai-54321") {
+		t.Error("expected MOB test not to raise an alarm")
+	}
+
+	// The instrument clearing the alarm clears the metric but the alarm
+	// stays latched.
+	raiseRemoteAlarms([]gps.Alarm{mob}, prev, record)
+	if set["alr-ii-012"] || !set["mob-ai-12345"] {
+		t.Errorf("unexpected metric states %v", set)
+	}
+	if !alarms.isRaised("alr-ii-012") {
+		t.Error("expected ALR alarm to stay latched")
+	}
+}
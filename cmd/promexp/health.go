@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Health and readiness for systemd, watchdogs and remote monitors. The
+// exporter is healthy when the update loop is running, and ready when
+// every running sensor has been read successfully recently enough.
+//
+// The state is also exported per sensor: sensors_<name>_up is 1 after a
+// successful read and 0 after a failed one, and there are
+// sensors_<name>_read_errors_total and
+// sensors_<name>_last_success_timestamp_seconds. They bypass the gauge
+// wrappers, as they must not expire when the sensor stops working. The
+// value gauges of a failing sensor keep their last value, and expire if
+// it stays down.
+
+// A sensor is stale when it hasn't been read successfully for this many
+// of its update intervals, or minStale, whichever is longer.
+const (
+	staleIntervals = 3
+	minStale       = 10 * time.Second
+)
+
+type sensorHealth struct {
+	Started           time.Time `json:"started"`
+	LastSuccess       time.Time `json:"lastSuccess"`
+	ConsecutiveErrors int       `json:"consecutiveErrors"`
+	LastError         string    `json:"lastError,omitempty"`
+	Stale             bool      `json:"stale"`
+
+	up          prometheus.Gauge
+	errors      prometheus.Counter
+	lastSuccess prometheus.Gauge
+}
+
+type healthMap struct {
+	mut     sync.Mutex
+	loop    time.Time
+	sensors map[string]*sensorHealth
+}
+
+var health = &healthMap{sensors: make(map[string]*sensorHealth)}
+
+// start begins tracking the sensor, anew if it was reinitialized.
+func (h *healthMap) start(name string) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.sensors[name] = &sensorHealth{
+		Started: time.Now(),
+		up: register(prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "up",
+			Help:      "1 if the last read of the sensor succeeded, 0 if it failed.",
+		})).(prometheus.Gauge),
+		errors: register(prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "read_errors_total",
+			Help:      "Failed reads of the sensor.",
+		})).(prometheus.Counter),
+		lastSuccess: register(prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Time of the last successful read of the sensor.",
+		})).(prometheus.Gauge),
+	}
+}
+
+func (h *healthMap) stop(name string) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if s, ok := h.sensors[name]; ok {
+		prometheus.Unregister(s.up)
+		prometheus.Unregister(s.errors)
+		prometheus.Unregister(s.lastSuccess)
+		delete(h.sensors, name)
+	}
+}
+
+// ok records a successful read of the sensor.
+func (h *healthMap) ok(name string) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if s, ok := h.sensors[name]; ok {
+		s.LastSuccess = time.Now()
+		s.ConsecutiveErrors = 0
+		s.LastError = ""
+		s.lastSuccess.Set(float64(s.LastSuccess.UnixNano()) / 1e9)
+		s.up.Set(1)
+	}
+}
+
+// failed records a failed read of the sensor.
+func (h *healthMap) failed(name string, err error) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if s, ok := h.sensors[name]; ok {
+		s.ConsecutiveErrors++
+		s.LastError = err.Error()
+		s.errors.Inc()
+		s.up.Set(0)
+	}
+}
+
+// lastSuccess returns the time of the last successful read of the sensors
+// that have had one.
+func (h *healthMap) lastSuccess() map[string]time.Time {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	res := make(map[string]time.Time)
+	for name, s := range h.sensors {
+		if !s.LastSuccess.IsZero() {
+			res[name] = s.LastSuccess
+		}
+	}
+	return res
+}
+
+// tick records that the update loop has run.
+func (h *healthMap) tick() {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.loop = time.Now()
+}
+
+type healthReport struct {
+	Status   string                  `json:"status"`
+	Loop     time.Time               `json:"loop"`
+	Sensors  map[string]sensorHealth `json:"sensors"`
+	NotReady []string                `json:"notReady,omitempty"`
+}
+
+// report returns the state at the given time, whether the update loop is
+// alive, and the stale sensors. A sensor that has never been read is
+// given the stale time from its start to come up.
+func (h *healthMap) report(now time.Time) (healthReport, bool) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	intv := cli().UpdateInterval
+	r := healthReport{Loop: h.loop, Sensors: make(map[string]sensorHealth)}
+	for name, s := range h.sensors {
+		since := s.LastSuccess
+		if since.IsZero() {
+			since = s.Started
+		}
+		cur := *s
+		cur.Stale = now.Sub(since) > staleAfter(sensorConf(name).interval(intv))
+		if cur.Stale {
+			r.NotReady = append(r.NotReady, name)
+		}
+		r.Sensors[name] = cur
+	}
+	sort.Strings(r.NotReady)
+	alive := !h.loop.IsZero() && now.Sub(h.loop) <= staleAfter(intv)
+	return r, alive
+}
+
+func staleAfter(intv time.Duration) time.Duration {
+	if d := staleIntervals * intv; d > minStale {
+		return d
+	}
+	return minStale
+}
+
+// handleHealthz answers 200 while the update loop is running, whatever
+// the state of the sensors.
+func handleHealthz(w http.ResponseWriter, req *http.Request) {
+	r, alive := health.report(time.Now())
+	r.Status = "ok"
+	code := http.StatusOK
+	if !alive {
+		r.Status = "update loop stalled"
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, r)
+}
+
+// handleReadyz answers 200 when the update loop is running and no sensor
+// is stale.
+func handleReadyz(w http.ResponseWriter, req *http.Request) {
+	r, alive := health.report(time.Now())
+	r.Status = "ready"
+	code := http.StatusOK
+	if !alive {
+		r.Status = "update loop stalled"
+		code = http.StatusServiceUnavailable
+	} else if len(r.NotReady) > 0 {
+		r.Status = "stale sensors"
+		code = http.StatusServiceUnavailable
+	}
+	writeHealth(w, code, r)
+}
+
+func writeHealth(w http.ResponseWriter, code int, r healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHealthReport(t *testing.T) {
+	prev := current.Load()
+	defer current.Store(prev)
+	setConfig(options{UpdateInterval: time.Second}, fileSections{Sensors: map[string]sensorConfig{"ds18b20": {Interval: time.Minute}}})
+
+	h := &healthMap{sensors: make(map[string]*sensorHealth)}
+	if _, alive := h.report(time.Now()); alive {
+		t.Error("alive before the first tick")
+	}
+
+	h.tick()
+	h.start("hts221")
+	h.start("ds18b20")
+	h.ok("hts221")
+	h.failed("hts221", errors.New("i/o error"))
+	h.failed("hts221", errors.New("i/o error"))
+
+	now := time.Now()
+	r, alive := h.report(now)
+	if !alive || len(r.NotReady) != 0 {
+		t.Fatalf("unexpected report %+v, alive %v", r, alive)
+	}
+	if s := r.Sensors["hts221"]; s.ConsecutiveErrors != 2 || s.LastError != "i/o error" {
+		t.Errorf("unexpected hts221 state %+v", s)
+	}
+	s := h.sensors["hts221"]
+	if v := testutil.ToFloat64(s.up); v != 0 {
+		t.Errorf("up %v after a failed read", v)
+	}
+	if v := testutil.ToFloat64(s.errors); v != 2 {
+		t.Errorf("read errors %v, expected 2", v)
+	}
+	if v := testutil.ToFloat64(s.lastSuccess); v != float64(s.LastSuccess.UnixNano())/1e9 {
+		t.Errorf("last success %v, expected %v", v, s.LastSuccess)
+	}
+
+	// The HTS221 goes stale after ten seconds, the DS18B20 after three
+	// minutes; the update loop stalls after ten seconds.
+	r, alive = h.report(now.Add(time.Minute))
+	if alive || !reflect.DeepEqual(r.NotReady, []string{"hts221"}) {
+		t.Errorf("unexpected report %+v, alive %v", r, alive)
+	}
+	r, _ = h.report(now.Add(4 * time.Minute))
+	if !reflect.DeepEqual(r.NotReady, []string{"ds18b20", "hts221"}) {
+		t.Errorf("unexpected not ready %v", r.NotReady)
+	}
+}
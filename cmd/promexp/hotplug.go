@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/hotplug"
+)
+
+// hotplugSettle is the time allowed after a device appears for udev to
+// create the device node and its /dev/serial/by-id links, and for further
+// devices of the same adapter to appear.
+const hotplugSettle = 2 * time.Second
+
+// watchHotplug returns a channel that receives when serial devices or
+// network interfaces (such as a CAN adapter) have appeared, so that the
+// sensors and inputs that failed to start for lack of them can be retried.
+// The channel never receives if the events cannot be watched.
+func watchHotplug(ctx context.Context) <-chan struct{} {
+	plugged := make(chan struct{}, 1)
+	events, err := hotplug.Watch(ctx)
+	if err != nil {
+		log.Println("Hotplug:", err)
+		return plugged
+	}
+	go func() {
+		var settle <-chan time.Time
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					if ctx.Err() == nil {
+						log.Println("Hotplug: no longer watching for devices")
+					}
+					return
+				}
+				switch {
+				case ev.Lost:
+					log.Println("Hotplug: events lost; retrying all inputs")
+				case hotplugRelevant(ev):
+					log.Printf("Hotplug: %s appeared", hotplugName(ev))
+				default:
+					continue
+				}
+				settle = time.After(hotplugSettle)
+			case <-settle:
+				settle = nil
+				select {
+				case plugged <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return plugged
+}
+
+func hotplugRelevant(ev hotplug.Event) bool {
+	return ev.Action == "add" && (ev.Subsystem == "tty" && ev.DevName != "" || ev.Subsystem == "net")
+}
+
+func hotplugName(ev hotplug.Event) string {
+	if ev.Interface != "" {
+		return ev.Interface
+	}
+	return "/dev/" + ev.DevName
+}
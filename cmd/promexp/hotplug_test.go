@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/calmh/boatpi/hotplug"
+)
+
+func TestHotplugRelevant(t *testing.T) {
+	cases := []struct {
+		ev       hotplug.Event
+		relevant bool
+	}{
+		{hotplug.Event{Action: "add", Subsystem: "tty", DevName: "ttyUSB0"}, true},
+		{hotplug.Event{Action: "add", Subsystem: "net", Interface: "can0"}, true},
+		{hotplug.Event{Action: "remove", Subsystem: "tty", DevName: "ttyUSB0"}, false},
+		{hotplug.Event{Action: "add", Subsystem: "usb"}, false},
+		{hotplug.Event{Action: "add", Subsystem: "tty"}, false},
+	}
+	for _, tc := range cases {
+		if got := hotplugRelevant(tc.ev); got != tc.relevant {
+			t.Errorf("%+v: got %v, expected %v", tc.ev, got, tc.relevant)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensehat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "hts221",
+		section: true,
+		fields:  []string{"humidity", "temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithHTS221 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return nil
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			hts221, err := sensehat.NewHTS221(bus)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("hts221", hts221)
+			return registerHTS221(hts221), nil
+		},
+	})
+}
+
+func registerHTS221(hts221 *sensehat.HTS221) func() {
+	hum := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "hts221",
+		Name:      "humidity_percent",
+	})
+	temp := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "hts221",
+		Name:      "temperature_celsius",
+	})
+	dew := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "hts221",
+		Name:      "dewpoint_celsius",
+	})
+
+	return func() {
+		if err := hts221.Refresh(time.Second); err != nil {
+			log.Println("HTS221:", err)
+			health.failed("hts221", err)
+			return
+		}
+
+		health.ok("hts221")
+		conf := sensorConf("hts221")
+		h := conf.correct("humidity", hts221.Humidity())
+		t := conf.correct("temperature", hts221.Temperature())
+		hum.Set(h)
+		temp.Set(t)
+		dew.Set(dewPoint(t, h))
+		moisture.observe("hts221", t, h)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/invensense"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "icm20948",
+		section: true,
+		fields:  []string{"temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithICM20948 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return append([]interface{}{c.Address, c.AccelRate, c.AccelRange, c.GyroRange, c.MagnRate, o.MagneticOffset, o.ICM20948CalFile},
+				imuSettings(o)...)
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			file := cli().ICM20948CalFile
+			cal := loadCalibration(file)
+			settings := invensense.ICM20948Settings{
+				AccelRate:  conf.AccelRate,
+				AccelRange: conf.AccelRange,
+				GyroRange:  conf.GyroRange,
+				MagnRate:   conf.MagnRate,
+			}
+			icm20948, err := invensense.NewICM20948(bus, conf.address(invensense.ICM20948DefaultAddress), cli().MagneticOffset, cal, settings)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("icm20948", icm20948)
+			aicm20948 := startIMU(ctx, "icm20948", icm20948)
+			keepCalibration(ctx, file, cal, aicm20948, icm20948)
+
+			imuUpdate := registerIMU("icm20948", aicm20948)
+			calUpdate := registerCalibration("icm20948", file, icm20948)
+			return func() {
+				imuUpdate()
+				calUpdate()
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The IMUs, the LSM9DS1 and those like it, share the averaging, the
+// attitude sentences and most of the metrics, named after the sensor:
+// sensors_lsm9ds1_accel_angle_degrees, sensors_mpu6050_accel_angle_degrees
+// and so on. The compass metrics are only there for IMUs with a
+// magnetometer, the rate of turn for those with a gyroscope, and the
+// heading, roll and pitch for those that fuse them on the chip.
+
+// imuSettings are the options that restart the IMUs when changed.
+func imuSettings(o options) []interface{} {
+	return []interface{}{o.HeadingRate, o.IMUAdaptive, o.IMUUnderwayInterval, o.IMUStillInterval, o.IMUMotionThreshold}
+}
+
+// startIMU starts averaging the IMU, and sending the attitude sentences if
+// enabled.
+func startIMU(ctx context.Context, name string, imu IMU) *AvgIMU {
+	o := cli()
+	intv, slow := 500*time.Millisecond, o.IMUStillInterval
+	if o.IMUAdaptive {
+		intv = o.IMUUnderwayInterval
+	}
+	if o.HeadingRate > 0 {
+		// Poll at least as fast as the heading is sent.
+		d := time.Duration(float64(time.Second) / o.HeadingRate)
+		if d < intv {
+			intv = d
+		}
+		if d < slow {
+			slow = d
+		}
+	}
+	var a *AvgIMU
+	if o.IMUAdaptive {
+		a = NewAdaptiveAvgIMU(ctx, name, time.Minute, intv, slow, o.IMUMotionThreshold, imu)
+	} else {
+		a = NewAvgIMU(ctx, name, time.Minute, intv, imu)
+	}
+	if o.HeadingRate > 0 {
+		go sendAttitude(ctx, a, o.HeadingRate)
+	}
+	return a
+}
+
+// Quantiles of the acceleration angles over the window, for alerts that
+// should not trip on a single wave.
+var attitudeQuantiles = []float64{0.05, 0.5, 0.95}
+
+func registerIMU(name string, imu *AvgIMU) func() {
+	accel := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "accel_field",
+	}, []string{"direction"})
+
+	accelA := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "accel_angle_degrees",
+	}, []string{"plane"})
+
+	buckets := []float64{0}
+	for i := 1; i < 10; i++ {
+		buckets = append([]float64{float64(-i)}, buckets...)
+		buckets = append(buckets, float64(i))
+	}
+	for i := 10; i < 20; i += 2 {
+		buckets = append([]float64{float64(-i)}, buckets...)
+		buckets = append(buckets, float64(i))
+	}
+	for i := 20; i < 50; i += 5 {
+		buckets = append([]float64{float64(-i)}, buckets...)
+		buckets = append(buckets, float64(i))
+	}
+
+	accelAI := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "accel_angle_instant_degrees",
+		Help:      "Acceleration angles as last read, for showing live motion.",
+	}, []string{"plane"})
+
+	accelAQ := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "accel_angle_quantile_degrees",
+		Help:      "Quantiles of the acceleration angles over the last minute; sensors_" + name + "_accel_angle_degrees is the median.",
+	}, []string{"plane", "quantile"})
+
+	accelAH := newHistogramVec(prometheus.HistogramOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "accel_angle_degrees_histogram",
+		Buckets:   buckets,
+	}, []string{"plane"})
+
+	devA := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "accel_deviation_degrees",
+	}, []string{"plane"})
+
+	temp := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "temperature_celsius",
+	})
+
+	pollIntv := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "poll_interval_seconds",
+	})
+
+	motionMode := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "motion_mode",
+		Help:      "1 for the current mode, underway or still, which sets the poll interval with --imu-adaptive.",
+	}, []string{"mode"})
+
+	var compA, compF, rot *gaugeVec
+	var heading, roll, pitch *gauge
+	compass, hasCompass := imu.IMU.(imuCompass)
+	if hasCompass {
+		compA = newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "compass_degrees",
+		}, []string{"plane"})
+
+		compF = newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "magnetic_field",
+		}, []string{"direction"})
+	}
+	gyro, hasGyro := imu.IMU.(imuGyro)
+	if hasGyro {
+		rot = newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "rotation_degrees_per_second",
+			Help:      "Rate of turn around each axis.",
+		}, []string{"direction"})
+	}
+	orientation, hasOrientation := imu.IMU.(imuOrientation)
+	if hasOrientation {
+		heading = newGauge(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "heading_degrees",
+			Help:      "Tilt compensated magnetic heading, fused on the sensor.",
+		})
+		roll = newGauge(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "roll_degrees",
+		})
+		pitch = newGauge(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: name,
+			Name:      "pitch_degrees",
+		})
+	}
+
+	return func() {
+		x, y, z := imu.Acceleration()
+		accel.WithLabelValues("x").Set(float64(x))
+		accel.WithLabelValues("y").Set(float64(y))
+		accel.WithLabelValues("z").Set(float64(z))
+		xy, xz, yz := imu.MedianAccelerationAngles()
+		accelA.WithLabelValues("xy").Set(xy)
+		accelA.WithLabelValues("xz").Set(xz)
+		accelA.WithLabelValues("yz").Set(yz)
+		for i, q := range imu.AccelerationAngleQuantiles(attitudeQuantiles...) {
+			ql := strconv.FormatFloat(attitudeQuantiles[i], 'f', -1, 64)
+			accelAQ.WithLabelValues("xy", ql).Set(q[0])
+			accelAQ.WithLabelValues("xz", ql).Set(q[1])
+			accelAQ.WithLabelValues("yz", ql).Set(q[2])
+		}
+		xy, xz, yz = imu.AccelerationAngles()
+		accelAI.WithLabelValues("xy").Set(xy)
+		accelAI.WithLabelValues("xz").Set(xz)
+		accelAI.WithLabelValues("yz").Set(yz)
+		accelAH.WithLabelValues("xy").Observe(xy)
+		accelAH.WithLabelValues("xz").Observe(xz)
+		accelAH.WithLabelValues("yz").Observe(yz)
+		xy, xz, yz = imu.Deviation()
+		devA.WithLabelValues("xy").Set(xy)
+		devA.WithLabelValues("xz").Set(xz)
+		devA.WithLabelValues("yz").Set(yz)
+
+		if hasCompass {
+			xy, xz, yz = compass.Compass()
+			compA.WithLabelValues("xy").Set(xy)
+			compA.WithLabelValues("xz").Set(xz)
+			compA.WithLabelValues("yz").Set(yz)
+			heading, _ := imu.Heading()
+			compA.WithLabelValues("horiz").Set(heading)
+
+			x, y, z = compass.MagneticField()
+			compF.WithLabelValues("x").Set(float64(x))
+			compF.WithLabelValues("y").Set(float64(y))
+			compF.WithLabelValues("z").Set(float64(z))
+		}
+		if hasGyro {
+			rx, ry, rz := gyro.Rotation()
+			rot.WithLabelValues("x").Set(rx)
+			rot.WithLabelValues("y").Set(ry)
+			rot.WithLabelValues("z").Set(rz)
+		}
+		if hasOrientation {
+			h, r, p := orientation.Orientation()
+			heading.Set(h)
+			roll.Set(r)
+			pitch.Set(p)
+		}
+
+		temp.Set(sensorConf(name).correct("temperature", imu.Temperature()))
+
+		intv, underway := imu.PollInterval()
+		pollIntv.Set(intv.Seconds())
+		if underway {
+			motionMode.WithLabelValues("underway").Set(1)
+			motionMode.WithLabelValues("still").Set(0)
+		} else {
+			motionMode.WithLabelValues("underway").Set(0)
+			motionMode.WithLabelValues("still").Set(1)
+		}
+	}
+}
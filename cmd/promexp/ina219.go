@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/ina"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "ina219",
+		section: true,
+		fields:  []string{"voltage", "current"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithINA219 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, c.Shunt}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			dev, err := ina.NewINA219(bus, conf.address(ina.INA219DefaultAddress), conf.shunt(ina.DefaultShunt))
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("ina219", dev)
+			return registerINA219(dev), nil
+		},
+	})
+}
+
+func registerINA219(dev *ina.INA219) func() {
+	voltage := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ina219",
+		Name:      "voltage",
+	})
+	current := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ina219",
+		Name:      "current_amperes",
+		Help:      "Current through the shunt, positive from IN+ to IN-.",
+	})
+	power := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ina219",
+		Name:      "power_watts",
+	})
+
+	return func() {
+		if err := dev.Refresh(time.Second); err != nil {
+			log.Println("INA219:", err)
+			health.failed("ina219", err)
+			return
+		}
+
+		health.ok("ina219")
+		conf := sensorConf("ina219")
+		v := conf.correct("voltage", dev.Voltage())
+		c := conf.correct("current", dev.Current())
+		voltage.Set(v)
+		current.Set(c)
+		power.Set(v * c)
+	}
+}
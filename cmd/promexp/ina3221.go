@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/ina"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var ina3221Channels = []string{"1", "2", "3"}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "ina3221",
+		section: true,
+		fields:  []string{"voltage_1", "voltage_2", "voltage_3", "current_1", "current_2", "current_3"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithINA3221 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, c.Shunt, c.Shunts}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			var shunts [3]float64
+			for i, ch := range ina3221Channels {
+				shunts[i] = conf.channelShunt(ch, ina.DefaultShunt)
+			}
+			dev, err := ina.NewINA3221(bus, conf.address(ina.INA3221DefaultAddress), shunts)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("ina3221", dev)
+			return registerINA3221(dev), nil
+		},
+	})
+}
+
+func registerINA3221(dev *ina.INA3221) func() {
+	voltage := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ina3221",
+		Name:      "voltage",
+	}, []string{"channel"})
+	current := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ina3221",
+		Name:      "current_amperes",
+		Help:      "Current through the shunt, positive from IN+ to IN-.",
+	}, []string{"channel"})
+	power := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ina3221",
+		Name:      "power_watts",
+	}, []string{"channel"})
+
+	return func() {
+		if err := dev.Refresh(time.Second); err != nil {
+			log.Println("INA3221:", err)
+			health.failed("ina3221", err)
+			return
+		}
+
+		health.ok("ina3221")
+		conf := sensorConf("ina3221")
+		vs, cs := dev.Voltages(), dev.Currents()
+		for i, ch := range ina3221Channels {
+			v := conf.correct("voltage_"+ch, vs[i])
+			c := conf.correct("current_"+ch, cs[i])
+			voltage.WithLabelValues(ch).Set(v)
+			current.WithLabelValues(ch).Set(c)
+			power.WithLabelValues(ch).Set(v * c)
+		}
+	}
+}
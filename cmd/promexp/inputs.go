@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/calmh/boatpi/gpio"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Digital inputs, such as float switches, door switches and shore power
+// sense relays, are named in their own section and exported as
+// sensors_input_active{input="<name>"}, 1 when active. They are on the
+// Pi's GPIO, or on an MCP23017 or PCF8574 expander at its default address
+// or the given one:
+//
+//   inputs:
+//     bilge-float:
+//       pin: 27
+//       active-low: true
+//     shore-power:
+//       expander: mcp23017
+//       address: 0x21
+//       pin: 8 # 0 to 7 on port A, 8 to 15 on port B
+//       pull-up: true # MCP23017 only; the PCF8574 always has one
+//       active-low: true
+
+type inputConfig struct {
+	Expander  string `yaml:"expander"`
+	Address   int    `yaml:"address"`
+	Pin       int    `yaml:"pin"`
+	ActiveLow bool   `yaml:"active-low"`
+	PullUp    bool   `yaml:"pull-up"`
+}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "inputs",
+		enabled: func(o options) bool { return len(sections().Inputs) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.GPIOChip, sections().Inputs}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initInputs(ctx, bus, sections().Inputs)
+		},
+	})
+}
+
+func sortedInputs(ins map[string]inputConfig) []string {
+	names := make([]string, 0, len(ins))
+	for name := range ins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateInputs(ins map[string]inputConfig) error {
+	pins := make(map[inputConfig]string)
+	for _, name := range sortedInputs(ins) {
+		in := ins[name]
+		if in.Expander == "" {
+			if in.Address != 0 {
+				return fmt.Errorf("input %s: address without an expander", name)
+			}
+			if in.PullUp {
+				return fmt.Errorf("input %s: pull-up is only supported on expanders", name)
+			}
+		} else if err := validateExpanderPin(in.Expander, in.Address, in.Pin); err != nil {
+			return fmt.Errorf("input %s: %w", name, err)
+		}
+		key := pinKey(in.Expander, in.Address, in.Pin)
+		if other, ok := pins[key]; ok {
+			return fmt.Errorf("input %s: pin %d already used by %s", name, in.Pin, other)
+		}
+		pins[key] = name
+	}
+	return nil
+}
+
+type digitalInput struct {
+	name  string
+	value func() (bool, error)
+	close func() error
+}
+
+func initInputs(ctx context.Context, bus *i2c.Bus, ins map[string]inputConfig) (func(), error) {
+	var dins []*digitalInput
+	var devs []sensor.Sensor
+	closeAll := func() {
+		for _, in := range dins {
+			if in.close != nil {
+				in.close()
+			}
+		}
+	}
+	for _, name := range sortedInputs(ins) {
+		conf := ins[name]
+		if conf.Expander == "" {
+			line, err := gpio.RequestInput(cli().GPIOChip, conf.Pin, conf.ActiveLow)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("input %s: %w", name, err)
+			}
+			dins = append(dins, &digitalInput{name: name, value: line.Value, close: line.Close})
+			continue
+		}
+
+		exp, err := sharedExpander(bus, conf.Expander, conf.Address)
+		if err == nil {
+			err = exp.ConfigureInput(conf.Pin, conf.PullUp)
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("input %s: %w", name, err)
+		}
+		devs = appendDevice(devs, exp)
+		pin := expanderPin{exp: exp, pin: conf.Pin, activeLow: conf.ActiveLow}
+		dins = append(dins, &digitalInput{name: name, value: pin.value})
+	}
+
+	meta.setDevices("inputs", devs...)
+	onDone(ctx, closeAll)
+	return registerInputs(dins), nil
+}
+
+// appendDevice appends the device to the list unless it is already there.
+func appendDevice(devs []sensor.Sensor, dev sensor.Sensor) []sensor.Sensor {
+	for _, d := range devs {
+		if d == dev {
+			return devs
+		}
+	}
+	return append(devs, dev)
+}
+
+func registerInputs(dins []*digitalInput) func() {
+	active := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "input",
+		Name:      "active",
+		Help:      "Digital input state, 1 when active.",
+	}, []string{"input"})
+
+	errs := make(map[string]error)
+	return func() {
+		for _, in := range dins {
+			v, err := in.value()
+			if err != nil {
+				if prev := errs[in.name]; prev == nil || prev.Error() != err.Error() {
+					log.Printf("Input %s: %v", in.name, err)
+				}
+				errs[in.name] = err
+				continue
+			}
+			errs[in.name] = nil
+			if v {
+				active.WithLabelValues(in.name).Set(1)
+			} else {
+				active.WithLabelValues(in.name).Set(0)
+			}
+		}
+	}
+}
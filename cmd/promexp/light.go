@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/light"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type lightSensor interface {
+	Refresh(age time.Duration) error
+	Illuminance() float64
+}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "bh1750",
+		section: true,
+		fields:  []string{"illuminance"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithBH1750 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			bh1750, err := light.NewBH1750(bus, conf.address(light.BH1750DefaultAddress))
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("bh1750", bh1750)
+			return registerLight("bh1750", bh1750), nil
+		},
+	})
+	registerSensor(sensorDef{
+		name:    "veml7700",
+		section: true,
+		fields:  []string{"illuminance"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithVEML7700 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			veml7700, err := light.NewVEML7700(bus, conf.address(light.VEML7700DefaultAddress))
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("veml7700", veml7700)
+			return registerLight("veml7700", veml7700), nil
+		},
+	})
+}
+
+func registerLight(name string, dev lightSensor) func() {
+	lux := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: name,
+		Name:      "illuminance_lux",
+		Help:      "Ambient light.",
+	})
+
+	return func() {
+		err := dev.Refresh(time.Second)
+		if err == light.ErrNoMeasurement {
+			return
+		}
+		if err != nil {
+			log.Printf("%s: %v", strings.ToUpper(name), err)
+			health.failed(name, err)
+			return
+		}
+
+		health.ok(name)
+		lux.Set(sensorConf(name).correct("illuminance", dev.Illuminance()))
+	}
+}
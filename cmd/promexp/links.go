@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The connections to other devices and hosts, such as the GPS serial
+// device or gpsd and the NMEA outputs, are exported with their state and
+// reconnects, so that missing data can be told to be a broken link rather
+// than a broken sensor:
+//
+//   sensors_link_up{kind="gpsd",destination="[::1]:2947"} 1
+//   sensors_link_reconnects_total{kind="gpsd",destination="[::1]:2947"} 3
+
+type linkMetrics struct {
+	up         *gaugeVec
+	reconnects *prometheus.CounterVec
+}
+
+func newLinkMetrics() linkMetrics {
+	return linkMetrics{
+		up: newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: "link",
+			Name:      "up",
+			Help:      "Whether the connection to the destination is up.",
+		}, []string{"kind", "destination"}),
+		reconnects: newCounterVec(prometheus.CounterOpts{
+			Namespace: "sensors",
+			Subsystem: "link",
+			Name:      "reconnects_total",
+			Help:      "Times the connection to the destination was established again after being lost.",
+		}, []string{"kind", "destination"}),
+	}
+}
+
+// set records the state of the link, and the number of reconnects since
+// the previous call.
+func (m linkMetrics) set(kind, dest string, up bool, reconnects uint64) {
+	if up {
+		m.up.WithLabelValues(kind, dest).Set(1)
+	} else {
+		m.up.WithLabelValues(kind, dest).Set(0)
+	}
+	m.reconnects.WithLabelValues(kind, dest).Add(float64(reconnects))
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/calmh/boatpi/store"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Sensors are placed in compartments of the boat, such as the saloon, the
+// engine room or the bilge, by giving their location in the configuration
+// file. Multi channel sensors can place channels, probes and the like
+// apart, by the value of the label that tells them apart:
+//
+//   sensors:
+//     hts221:
+//       location: saloon
+//     ds18b20:
+//       location: engine room
+//       locations:
+//         28-0316a2794bff: fridge
+//
+// The current values are then listed by compartment at /api/v1/locations,
+// across sensor types, and shown on the /locations page. Series without a
+// location are listed under "other". Series that are sensor readings carry
+// their unit, quantity and precision, as in /api/v1/meta.
+
+const otherLocation = "other"
+
+type locationGroup struct {
+	Name   string           `json:"name"`
+	Series []locationSeries `json:"series"`
+}
+
+type locationSeries struct {
+	Series    string  `json:"series"`
+	Sensor    string  `json:"sensor,omitempty"`
+	Value     float64 `json:"value"`
+	Unit      string  `json:"unit,omitempty"`
+	Quantity  string  `json:"quantity,omitempty"`
+	Precision *int    `json:"precision,omitempty"`
+}
+
+var unitSymbols = map[string]string{
+	"celsius":    "°C",
+	"fahrenheit": "°F",
+	"percent":    "%",
+	"inhg":       "inHg",
+	"volts":      "V",
+	"amperes":    "A",
+	"watts":      "W",
+	"metres":     "m",
+	"feet":       "ft",
+	"lux":        "lx",
+	"degrees":    "°",
+}
+
+// Text returns the value with its unit, with the precision if known.
+func (s locationSeries) Text() string {
+	if s.Precision == nil {
+		return fmt.Sprintf("%.6g", s.Value)
+	}
+	unit := s.Unit
+	if sym, ok := unitSymbols[unit]; ok {
+		unit = sym
+	}
+	return strings.TrimSpace(fmt.Sprintf("%.*f %s", *s.Precision, s.Value, unit))
+}
+
+// locate returns the location of the series, and the sensor it belongs
+// to.
+func (m *metaMap) locate(name string, labels []*dto.LabelPair) (location, sensor string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for sensor := range m.sensors {
+		if !strings.HasPrefix(name, "sensors_"+sensor+"_") {
+			continue
+		}
+		conf := sensorConf(sensor)
+		for _, lp := range labels {
+			if loc, ok := conf.Locations[lp.GetValue()]; ok {
+				return loc, sensor
+			}
+		}
+		if conf.Location != "" {
+			return conf.Location, sensor
+		}
+		return otherLocation, sensor
+	}
+	return otherLocation, ""
+}
+
+// groupByLocation groups the sensor metrics by location, ordered by name
+// with "other" last, with the field descriptions of the readings.
+func groupByLocation(mfs []*dto.MetricFamily, locate func(string, []*dto.LabelPair) (string, string), field func(string) (metaField, bool)) []locationGroup {
+	byLoc := make(map[string][]locationSeries)
+	for _, mf := range mfs {
+		name := mf.GetName()
+		if !strings.HasPrefix(name, "sensors_") {
+			continue
+		}
+		f, known := field(name)
+		for _, m := range mf.GetMetric() {
+			var v float64
+			switch {
+			case m.Gauge != nil:
+				v = m.Gauge.GetValue()
+			case m.Counter != nil:
+				v = m.Counter.GetValue()
+			case m.Untyped != nil:
+				v = m.Untyped.GetValue()
+			default:
+				continue
+			}
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			loc, sensor := locate(name, m.GetLabel())
+			ls := locationSeries{Series: store.SeriesName(name, labels), Sensor: sensor, Value: v}
+			if known {
+				prec := f.Precision
+				ls.Unit, ls.Quantity, ls.Precision = f.Unit, f.Quantity, &prec
+			}
+			byLoc[loc] = append(byLoc[loc], ls)
+		}
+	}
+
+	res := make([]locationGroup, 0, len(byLoc))
+	for loc, series := range byLoc {
+		sort.Slice(series, func(a, b int) bool { return series[a].Series < series[b].Series })
+		res = append(res, locationGroup{Name: loc, Series: series})
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if (res[a].Name == otherLocation) != (res[b].Name == otherLocation) {
+			return res[b].Name == otherLocation
+		}
+		return res[a].Name < res[b].Name
+	})
+	return res
+}
+
+func gatherLocations() ([]locationGroup, error) {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	return groupByLocation(mfs, meta.locate, meta.field), nil
+}
+
+func handleLocations(w http.ResponseWriter, req *http.Request) {
+	groups, err := gatherLocations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"locations": groups})
+}
+
+var locationsTpl = template.Must(template.New("locations").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>Compartments</title>
+<style>
+body { font-family: sans-serif; }
+td, th { padding: 0.3em 1em; text-align: left; }
+td.value { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Compartments</h1>
+{{range .}}<h2>{{.Name}}</h2>
+<table>
+{{range .Series}}<tr><td>{{.Series}}</td><td class="value">{{.Text}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+func handleLocationsPage(w http.ResponseWriter, req *http.Request) {
+	groups, err := gatherLocations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	locationsTpl.Execute(w, groups)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/sensor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGroupByLocation(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	hum := prometheus.NewGauge(prometheus.GaugeOpts{Name: "sensors_hts221_humidity_percent"})
+	temp := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "sensors_ds18b20_temperature_celsius"}, []string{"id"})
+	volt := prometheus.NewGauge(prometheus.GaugeOpts{Name: "sensors_ina219_voltage"})
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Name: "go_goroutines"})
+	reg.MustRegister(hum, temp, volt, other)
+	hum.Set(65)
+	temp.WithLabelValues("28-0316a2794bff").Set(4)
+	temp.WithLabelValues("28-0416b1222aff").Set(60)
+	volt.Set(12.8)
+
+	prev := current.Load()
+	defer current.Store(prev)
+	setConfig(options{}, fileSections{Sensors: map[string]sensorConfig{
+		"hts221":  {Location: "saloon"},
+		"ds18b20": {Location: "engine room", Locations: map[string]string{"28-0316a2794bff": "saloon"}},
+	}})
+
+	m := &metaMap{sensors: make(map[string]*sensorMeta)}
+	m.setDevices("hts221", humiditySensor{})
+	m.start("ds18b20")
+	m.start("ina219")
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero := 0
+	exp := []locationGroup{
+		{Name: "engine room", Series: []locationSeries{
+			{Series: `sensors_ds18b20_temperature_celsius{id="28-0416b1222aff"}`, Sensor: "ds18b20", Value: 60},
+		}},
+		{Name: "saloon", Series: []locationSeries{
+			{Series: `sensors_ds18b20_temperature_celsius{id="28-0316a2794bff"}`, Sensor: "ds18b20", Value: 4},
+			{Series: "sensors_hts221_humidity_percent", Sensor: "hts221", Value: 65, Unit: "percent", Quantity: "humidity", Precision: &zero},
+		}},
+		{Name: "other", Series: []locationSeries{
+			{Series: "sensors_ina219_voltage", Sensor: "ina219", Value: 12.8},
+		}},
+	}
+	res := groupByLocation(mfs, m.locate, m.field)
+	if !reflect.DeepEqual(res, exp) {
+		t.Errorf("got %+v, expected %+v", res, exp)
+	}
+	if txt := res[1].Series[1].Text(); txt != "65 %" {
+		t.Errorf("unexpected text %q", txt)
+	}
+	if txt := res[2].Series[0].Text(); txt != "12.8" {
+		t.Errorf("unexpected text %q", txt)
+	}
+}
+
+type humiditySensor struct{}
+
+func (humiditySensor) Refresh(time.Duration) error { return nil }
+
+func (humiditySensor) Readings() []sensor.Reading {
+	return []sensor.Reading{{Name: "humidity", Unit: "percent", Quantity: sensor.Humidity, Precision: 0, Value: 65}}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensehat"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "lps25h",
+		section: true,
+		fields:  []string{"pressure", "temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithLPS25H },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, o.SquallWindow, o.PressureHistoryFile, o.ForecastWind}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			wind, err := forecastWind()
+			if err != nil {
+				return nil, err
+			}
+			lps25h, err := sensehat.NewLPS25H(bus, conf.address(sensehat.LPS25HAddress))
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("lps25h", lps25h)
+			squall := NewSquallDetector(ctx, "lps25h", cli().SquallWindow, squallSampleInterval, cli().SquallThreshold, lps25h)
+			return registerBarometer("lps25h", squall, newForecaster("lps25h", wind)), nil
+		},
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensehat"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "lsm9ds1",
+		section: true,
+		fields:  []string{"temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithLSM9DS1 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return append([]interface{}{c.Address, c.MagnAddress, c.AccelRate, c.AccelRange, c.MagnRate, c.MagnRange, c.FIFO, o.MagneticOffset, o.CalibrationFile},
+				imuSettings(o)...)
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			file := cli().CalibrationFile
+			cal := loadCalibration(file)
+			settings := sensehat.LSM9DS1Settings{
+				AccelRate:  conf.AccelRate,
+				AccelRange: conf.AccelRange,
+				MagnRate:   conf.MagnRate,
+				MagnRange:  conf.MagnRange,
+				FIFO:       conf.FIFO,
+			}
+			lsm9ds1, err := sensehat.NewLSM9DS1(bus, conf.address(sensehat.LSM9DS1AccelAddress), conf.magnAddress(sensehat.LSM9DS1MagnAddress), cli().MagneticOffset, cal, settings)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("lsm9ds1", lsm9ds1)
+			alsm9ds1 := startIMU(ctx, "lsm9ds1", lsm9ds1)
+			keepCalibration(ctx, file, cal, alsm9ds1, lsm9ds1)
+
+			imuUpdate := registerIMU("lsm9ds1", alsm9ds1)
+			calUpdate := registerCalibration("lsm9ds1", file, lsm9ds1)
+			return func() {
+				imuUpdate()
+				calUpdate()
+			}, nil
+		},
+	})
+}
@@ -1,363 +1,378 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"log"
 	"math"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
-	"github.com/calmh/boatpi/omini"
-	"github.com/calmh/boatpi/sensehat"
+	"github.com/calmh/boatpi/i2c"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"gobot.io/x/gobot/sysfs"
 )
 
-var cli struct {
-	Device          string  `default:"/dev/i2c-1"`
-	PrometheusAddr  string  `default:":9091"`
-	MagneticOffset  float64 `placeholder:"DEGREES"`
-	CalibrationFile string  `default:"calibration.lsm9ds1"`
-	WithLPS25H      bool    `name:"with-lps25h"`
-	WithHTS221      bool    `name:"with-hts221"`
-	WithLSM9DS1     bool    `name:"with-lsm9ds1"`
-	WithOmini       bool
-	UpdateInterval  time.Duration `default:"1s"`
+const shutdownTimeout = 10 * time.Second
+
+type options struct {
+	Config             kong.ConfigFlag `placeholder:"FILE" help:"YAML configuration file. Reloaded on SIGHUP."`
+	Device             string          `default:"/dev/i2c-1"`
+	PrometheusAddr     []string        `default:":9091" placeholder:"HOST:PORT,..." help:"Addresses the HTTP server listens on. A port alone listens on all addresses, IPv4 and IPv6; IPv6 addresses go in brackets, e.g. [::1]:9091."`
+	AuthTokens         []string        `placeholder:"NAME:TOKEN,..." help:"Bearer tokens accepted on the HTTP endpoints; best kept in the configuration file."`
+	AuthUsers          string          `placeholder:"FILE" help:"User file in htpasswd format with bcrypt passwords, for basic authentication on the HTTP endpoints."`
+	AuthOIDCIssuer     string          `name:"auth-oidc-issuer" placeholder:"URL" help:"OpenID Connect issuer whose ID tokens are accepted as bearer tokens on the HTTP endpoints."`
+	AuthOIDCAudience   string          `name:"auth-oidc-audience" placeholder:"CLIENT-ID" help:"Audience (client ID) required in OpenID Connect ID tokens."`
+	MagneticOffset     float64         `placeholder:"DEGREES"`
+	CalibrationFile    string          `default:"calibration.lsm9ds1" help:"File the LSM9DS1 magnetometer and accelerometer calibration is kept in."`
+	WithLPS25H         bool            `name:"with-lps25h"`
+	WithHTS221         bool            `name:"with-hts221"`
+	WithLSM9DS1        bool            `name:"with-lsm9ds1"`
+	WithMPU6050        bool            `name:"with-mpu6050" help:"Export the attitude and rate of turn from an MPU6050, like the LSM9DS1 but without a compass."`
+	WithICM20948       bool            `name:"with-icm20948" help:"Export the attitude, heading and rate of turn from an ICM-20948, like the LSM9DS1."`
+	ICM20948CalFile    string          `name:"icm20948-calibration-file" default:"calibration.icm20948" help:"File the ICM-20948 magnetometer and accelerometer calibration is kept in."`
+	WithBNO055         bool            `name:"with-bno055" help:"Export the fused heading, roll and pitch from a BNO055, with its calibration status."`
+	BNO055CalFile      string          `name:"bno055-calibration-file" default:"calibration.bno055" help:"File the BNO055 calibration offsets are kept in, once fully calibrated."`
+	WithAS5600         bool            `name:"with-as5600" help:"Export the rudder angle from an AS5600 magnetic rotary encoder; the zero position and direction are set in the configuration file."`
+	WithOmini          bool
+	OminiHighBit       string        `default:"retry" enum:"retry,mask,keep" help:"Handling of Omini readings with the spurious high bit set: read again, clear the bit, or keep the value as read."`
+	OminiDiagnostics   bool          `help:"Log Omini readings with the spurious high bit set."`
+	WithINA219         bool          `name:"with-ina219" help:"Export the voltage and current from an INA219; the address and shunt resistance are set in the configuration file."`
+	WithINA3221        bool          `name:"with-ina3221" help:"Export the voltages and currents of the three channels of an INA3221; the address and shunt resistances are set in the configuration file."`
+	WithADS1115        bool          `name:"with-ads1115" help:"Export the analog channels of an ADS1115, as configured in the configuration file."`
+	WithMCP3008        bool          `name:"with-mcp3008" help:"Export the analog channels of an MCP3008 on SPI, as configured in the configuration file."`
+	MCP3008Device      string        `name:"mcp3008-device" default:"/dev/spidev0.0" help:"SPI device of the MCP3008."`
+	MCP3008Reference   float64       `name:"mcp3008-reference" default:"3.3" placeholder:"V" help:"Reference voltage of the MCP3008, the top of its range."`
+	WithMS5611         bool          `name:"with-ms5611" help:"Export the pressure from an MS5611 barometer, with the squall warning, tendency and (without an LPS25H) forecast."`
+	WithBMP388         bool          `name:"with-bmp388" help:"Export the pressure from a BMP388 or BMP390 barometer, with the squall warning, tendency and (without an LPS25H or MS5611) forecast."`
+	BMP388Oversampling int           `name:"bmp388-oversampling" default:"8" help:"Pressure samples per BMP388 measurement; more is less noisy."`
+	BMP388IIR          int           `name:"bmp388-iir" default:"3" help:"BMP388 IIR filter coefficient, smoothing out short pressure changes such as gusts; 0 disables."`
+	WithMS5837         bool          `name:"with-ms5837" help:"Export the water pressure, temperature and depth from an MS5837-30BA."`
+	MS5837Water        string        `name:"ms5837-water" default:"salt" placeholder:"DENSITY" help:"Water density for the MS5837 depth: fresh, salt or a density in kg/m³."`
+	MS5837Surface      float64       `name:"ms5837-surface" default:"1013.25" placeholder:"MB" help:"Air pressure at the surface, subtracted for the MS5837 depth."`
+	WithSCD30          bool          `name:"with-scd30" help:"Export the CO2 concentration, temperature and humidity from an SCD30."`
+	SCD30Interval      time.Duration `name:"scd30-interval" default:"5s" help:"SCD30 measurement interval, from 2s to 30m."`
+	SCD30SelfCal       bool          `name:"scd30-self-calibration" help:"Let the SCD30 calibrate itself, which assumes that it sees fresh air for an hour a day."`
+	WithSGP30          bool          `name:"with-sgp30" help:"Export the total VOC and the CO2 equivalent from an SGP30."`
+	WithSGP40          bool          `name:"with-sgp40" help:"Export the VOC index from an SGP40."`
+	SGPStateFile       string        `name:"sgp-state-file" default:"sgp.state" help:"File for saving the learned SGP30 baseline and SGP40 VOC index state across restarts."`
+	SGPTemperature     string        `name:"sgp-temperature" placeholder:"EXPR" help:"Air temperature in °C for the SGP30 and SGP40 humidity compensation, e.g. sensors_scd30_temperature_celsius."`
+	SGPHumidity        string        `name:"sgp-humidity" placeholder:"EXPR" help:"Relative humidity in percent for the SGP30 and SGP40 humidity compensation."`
+	WithBH1750         bool          `name:"with-bh1750" help:"Export the ambient light from a BH1750."`
+	WithVEML7700       bool          `name:"with-veml7700" help:"Export the ambient light from a VEML7700."`
+	WithTMP117         bool          `name:"with-tmp117" help:"Export the temperature from a TMP117, accurate enough to calibrate the temperature offsets of the other sensors against."`
+	TMP117Averaging    int           `name:"tmp117-averaging" default:"8" help:"Conversions averaged per TMP117 measurement: 1, 8, 32 or 64; more is less noisy."`
+	DetectBoards       bool          `help:"Enable the sensors on boards identified by their HAT EEPROM."`
+	IDEEPROM           i2cAddress    `name:"id-eeprom" placeholder:"ADDR" help:"Address of a boatpi ID EEPROM on the I2C bus, listing the sensors on the board."`
+	SquallThreshold    float64       `default:"1" placeholder:"MB" help:"Pressure rise that triggers a squall warning (requires a barometer)."`
+	SquallWindow       time.Duration `default:"10m" help:"Time window for squall detection."`
+	ForecastWind       string        `placeholder:"EXPR" help:"Wind direction in degrees (where it blows from) for the local forecast, e.g. sensors_virtual_wind_direction_degrees."`
+	ForecastSouthern   bool          `help:"Make the local forecast for the southern hemisphere."`
+	WithGPS            bool          `name:"with-gps"`
+	GPSDevice          string        `name:"gps-device" default:"/dev/serial0" help:"Serial device of the GPS; prefer a stable /dev/serial/by-id name for USB receivers."`
+	GPSBaudRate        int           `name:"gps-baud-rate" default:"9600"`
+	GPSD               string        `name:"gpsd" placeholder:"HOST:PORT" help:"Read GPS data from gpsd instead of a serial device."`
+	DeadReckoning      time.Duration `placeholder:"DURATION" help:"Estimate the position from the heading and speed for this long after the GPS fix is lost; 0 disables."`
+	DeadReckoningHead  string        `name:"dead-reckoning-heading" default:"sensors_gps_course_over_ground_degrees" placeholder:"EXPR" help:"Heading in degrees true for dead reckoning; a compass is better than the last course over ground."`
+	DeadReckoningSpeed string        `default:"sensors_gps_speed_over_ground_knots" placeholder:"EXPR" help:"Speed in knots for dead reckoning; speed through the water is better, if there is a log."`
+	NMEAListen         []string      `name:"nmea-listen" placeholder:"HOST:PORT,..." help:"Forward NMEA sentences from the GPS input to TCP clients connecting here (port 10110 is customary)."`
+	NMEAUDP            []string      `name:"nmea-udp" placeholder:"HOST:PORT,..." help:"Forward NMEA sentences from the GPS input to these UDP addresses, e.g. [ff02::1%wlan0]:10110 for all hosts on the link."`
+	NMEASentences      []string      `name:"nmea-sentences" placeholder:"TYPE,..." help:"Sentence types (e.g. RMC) or addresses (e.g. GPRMC) to forward; all if empty."`
+	NMEARateLimit      time.Duration `name:"nmea-rate-limit" help:"Forward each sentence address at most this often."`
+	NMEAInput          []string      `name:"nmea-input" placeholder:"SOURCE,..." help:"Read depth, wind, log, heading and water temperature from other instruments: serial devices, tcp://HOST:PORT to connect to a multiplexer, or udp://[HOST]:PORT to listen for broadcasts."`
+	NMEAInputBaudRate  int           `name:"nmea-input-baud-rate" default:"4800" help:"Baud rate of the serial NMEA inputs; 38400 for high speed (AIS) ports."`
+	HeadingRate        float64       `placeholder:"HZ" help:"Send heading (HDM) and attitude (XDR) sentences from the IMU to the NMEA outputs this many times a second, independently of the update interval; 0 disables."`
+	WithDS18B20        bool          `name:"with-ds18b20" help:"Export all DS18B20 1-Wire temperature probes."`
+	WithBLE            bool          `name:"with-ble" help:"Export the readings that Bluetooth LE sensors in range broadcast, such as RuuviTags, Xiaomi thermometers and Mopeka gas level sensors."`
+	BLEAdapter         int           `name:"ble-adapter" placeholder:"N" help:"Bluetooth adapter to scan with, N of hciN."`
+	BLEKnownOnly       bool          `name:"ble-known-only" help:"Ignore Bluetooth LE devices not named in the ble section of the configuration file."`
+	GPIOChip           string        `name:"gpio-chip" default:"/dev/gpiochip0"`
+	PWMFrequency       float64       `name:"pwm-frequency" default:"200" placeholder:"HZ" help:"PWM frequency of the PCA9685 dimmers, 24 to 1526 Hz."`
+	SeaTemperature     string        `placeholder:"SOURCE" help:"Source of the sea temperature metric: a DS18B20 probe ID (e.g. 28-0316a2794bff), \"ms5837\" or \"nmea\" for MTW sentences on the GPS or NMEA inputs."`
+	UpdateInterval     time.Duration `default:"1s" help:"Base update interval; per-sensor intervals are rounded to a multiple of this."`
+	I2CRetries         int           `name:"i2c-retries" default:"2" help:"Times a failed I2C operation is retried."`
+	I2CBackoff         time.Duration `name:"i2c-backoff" default:"5ms" help:"Delay before the first I2C retry; each following retry waits one more such delay."`
+	Hotplug            bool          `help:"Start sensors and inputs that failed to start, such as a GPS on a USB serial adapter, when a serial device or network interface appears. Missing devices at startup are then not fatal."`
+	BoatName           string        `placeholder:"NAME" help:"Name of the boat, for the alarm notifications."`
+	NotifyURL          string        `name:"notify-url" placeholder:"URL" help:"Post alarm notifications as plain text to this URL, such as an ntfy topic."`
+	MetricExpiry       time.Duration `default:"10m" help:"Remove series not updated for this long, such as those of a vanished probe or a silent GPS. Must exceed the longest sensor interval; 0 disables."`
+	GoroutineBudget    int           `default:"100" help:"Warn when more goroutines than this are running."`
+	StorePath          string        `placeholder:"PATH" help:"Record the sensor metrics in a local store here: a directory for the segment backend, a file for sqlite."`
+	StoreBackend       string        `default:"segment" help:"Local store backend: segment (append only files, gentle on SD cards) or sqlite (when built with the sqlite tag)."`
+	StoreInterval      time.Duration `default:"1m" help:"Interval between samples in the local store."`
+
+	WithDisplay    bool          `help:"Show status pages on the Sense HAT LED matrix."`
+	DisplayPages   []string      `default:"battery,heel,alarms" help:"Pages to show: battery, heel, alarms, race."`
+	DisplayCycle   time.Duration `default:"10s" help:"Time before switching to the next page; 0 to switch only with the joystick."`
+	DisplayBattery string        `default:"sensors_omini_voltage{channel=\"a\"}" placeholder:"EXPR" help:"Battery voltage shown on the battery page."`
+	DisplayHeel    string        `default:"sensors_lsm9ds1_accel_angle_degrees{plane=\"yz\"} - 90" placeholder:"EXPR" help:"Heel angle shown on the heel page and in the attitude history; depends on how the board is mounted."`
+
+	AttitudeHeading string `default:"sensors_lsm9ds1_compass_degrees{plane=\"horiz\"}" placeholder:"EXPR" help:"Heading in the attitude history, /api/v1/attitude."`
+
+	PolarWindSpeed string `placeholder:"EXPR" help:"True wind speed in knots, for accumulating performance polars, e.g. sensors_virtual_true_wind_speed_knots."`
+	PolarWindAngle string `placeholder:"EXPR" help:"True wind angle off the bow in degrees, for the performance polars."`
+	PolarBoatSpeed string `default:"sensors_gps_speed_over_ground_knots" placeholder:"EXPR" help:"Boat speed in knots for the performance polars; speed through the water is better, if there is a log."`
+	PolarFile      string `default:"polar.json" help:"File the performance polars are kept in."`
+
+	WithRace    bool `help:"Enable the race countdown and start line tools at /api/v1/race."`
+	RaceHornPin int  `default:"-1" placeholder:"PIN" help:"GPIO output with a buzzer or horn relay, to sound the race countdown on."`
+
+	WithAnchor   bool    `help:"Enable the anchor watch at /api/v1/anchor and the map page at /map."`
+	AnchorRadius float64 `default:"30" placeholder:"M" help:"Anchor alarm radius when dropping the anchor without giving one."`
+
+	PressureHistoryFile     string  `default:"pressure.history" help:"File for saving the pressure history for the three hour tendency across restarts."`
+	ChargeAbsorptionVoltage float64 `default:"14.0" placeholder:"V" help:"Lowest voltage considered absorption charging."`
+	ChargeFloatVoltage      float64 `default:"13.2" placeholder:"V" help:"Lowest voltage considered float charging, after absorption."`
+	BatteryStateFile        string  `default:"battery.state" help:"File for saving the coulomb counting state of charge across restarts."`
+	FlowStateFile           string  `default:"flow.state" help:"File for saving the flow sensor volumes across restarts."`
+	CrankingChannel         string  `placeholder:"CHANNEL" help:"Omini channel (a, b or c) of the start battery, to record the voltage during engine starts."`
+
+	IMUAdaptive         bool          `name:"imu-adaptive" help:"Poll the IMU quickly when the boat is moving and slowly when it is still, to save power in the marina."`
+	IMUUnderwayInterval time.Duration `name:"imu-underway-interval" default:"100ms" help:"IMU poll interval when moving, with --imu-adaptive."`
+	IMUStillInterval    time.Duration `name:"imu-still-interval" default:"2s" help:"IMU poll interval when still, with --imu-adaptive."`
+	IMUMotionThreshold  float64       `name:"imu-motion-threshold" default:"3" placeholder:"DEGREES" help:"Change of the acceleration angles within ten seconds that counts as moving, with --imu-adaptive. Still is less than half of this for five minutes."`
+
+	Simulate        bool       `help:"Read GPS data from a built-in simulated boat instead of a receiver, for testing on a desk."`
+	SimulateRoute   []waypoint `default:"57.70/11.85" placeholder:"LAT/LON,..." help:"Waypoints the simulated boat sails between in a loop. The first is the starting position."`
+	SimulateSpeed   float64    `default:"5" placeholder:"KNOTS" help:"Speed of the simulated boat."`
+	SimulateHeading float64    `placeholder:"DEGREES" help:"Heading of the simulated boat when the route is a single waypoint."`
+	SimulateDepth   float64    `default:"10" placeholder:"METRES" help:"Depth reported by the simulated boat."`
+
+	MetricsPrecision precision `default:"-1" help:"Decimals kept in exported metrics (-1 for full precision)."`
+	DisplayPrecision precision `default:"1" help:"Decimals shown in log output."`
+	TemperatureUnit  string    `default:"celsius" enum:"celsius,fahrenheit" help:"Unit of exported temperatures: celsius or fahrenheit. The metric names follow the unit."`
+	PressureUnit     string    `default:"mb" enum:"mb,inhg" help:"Unit of exported pressures: mb or inhg. The metric names follow the unit."`
+	LengthUnit       string    `default:"metres" enum:"metres,feet" help:"Unit of exported depths and distances: metres or feet. The metric names follow the unit."`
 }
 
-func main() {
-	kong.Parse(&cli)
-	log.SetOutput(os.Stdout)
-	log.SetFlags(0)
-
-	dev, err := sysfs.NewI2cDevice(cli.Device)
-	if err != nil {
-		log.Fatalln("open I2C device:", err)
-	}
-
-	var update funcs
-
-	if cli.WithLPS25H {
-		lps25h, err := sensehat.NewLPS25H(dev)
-		if err != nil {
-			log.Fatalln("init LPS25H:", err)
-		}
-		update = append(update, registerLPS25H(lps25h))
-	}
-
-	if cli.WithHTS221 {
-		hts221, err := sensehat.NewHTS221(dev)
-		if err != nil {
-			log.Fatalln("init HTS221:", err)
-		}
-		update = append(update, registerHTS221(hts221))
-	}
+// The current options and configuration file sections. A reload replaces
+// them as a whole and they are never modified in place, so that they can
+// be read from any goroutine, be it the update loop, an HTTP handler or a
+// sensor's own. A caller that needs several values consistent with each
+// other takes the snapshot once.
+var current atomic.Value // *config
 
-	if cli.WithLSM9DS1 {
-		cal := loadCalibration(cli.CalibrationFile)
-		lsm9ds1, err := sensehat.NewLSM9DS1(dev, cli.MagneticOffset, cal)
-		if err != nil {
-			log.Fatalln("init LSM9DS1:", err)
-		}
-		alsm9ds1 := NewAvgLSM9DS1(time.Minute, 500*time.Millisecond, lsm9ds1)
-		update = append(update, registerLSM9DS1(alsm9ds1))
-
-		go func() {
-			for range time.NewTicker(time.Minute).C {
-				cur := lsm9ds1.Calibration()
-				if cur != cal {
-					saveCalibration(cli.CalibrationFile, cur)
-					cal = cur
-				}
-			}
-		}()
-	}
-
-	if cli.WithOmini {
-		omini := omini.New(dev)
-		update = append(update, registerOmini(omini))
-	}
-
-	if len(update) == 0 {
-		log.Fatal("No sensors enabled? Enable some sensors.")
-	}
-
-	go func() {
-		update.call()
-		for range time.NewTicker(cli.UpdateInterval).C {
-			update.call()
-		}
-	}()
-
-	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(cli.PrometheusAddr, nil)
+type config struct {
+	opts     options
+	sections fileSections
 }
 
-type funcs []func()
+func init() {
+	current.Store(&config{})
+}
 
-func (fs funcs) call() {
-	for _, fn := range fs {
-		fn()
-	}
+// cli returns the current options, which must not be modified.
+func cli() *options {
+	return &current.Load().(*config).opts
 }
 
-func registerHTS221(hts221 *sensehat.HTS221) func() {
-	hum := promauto.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "hts221",
-		Name:      "humidity_percent",
-	})
-	temp := promauto.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "hts221",
-		Name:      "temperature_celsius",
-	})
-
-	return func() {
-		if err := hts221.Refresh(time.Second); err != nil {
-			log.Println("HTS221:", err)
-			hum.Set(0)
-			temp.Set(0)
-			return
-		}
+// sections returns the current configuration file sections, which must not
+// be modified.
+func sections() *fileSections {
+	return &current.Load().(*config).sections
+}
 
-		hum.Set(round(hts221.Humidity(), 2))
-		temp.Set(round(hts221.Temperature(), 2))
-	}
+func setConfig(opts options, secs fileSections) {
+	current.Store(&config{opts: opts, sections: secs})
 }
 
-func registerLPS25H(lps25h *sensehat.LPS25H) func() {
-	press := promauto.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "lps25h",
-		Name:      "pressure_mb",
-	})
+func main() {
+	var opts options
+	var secs fileSections
+	parser, err := kong.New(&opts, kong.Configuration(yamlConfig(&secs)))
+	if err != nil {
+		panic(err)
+	}
+	_, err = parser.Parse(os.Args[1:])
+	parser.FatalIfErrorf(err)
+	setConfig(opts, secs)
 
-	temp := promauto.NewGauge(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "lps25h",
-		Name:      "temperature_celsius",
-	})
-
-	return func() {
-		if err := lps25h.Refresh(time.Second); err != nil {
-			log.Println("LPS25H:", err)
-			press.Set(0)
-			temp.Set(0)
-			return
-		}
+	log.SetOutput(os.Stdout)
+	log.SetFlags(0)
 
-		press.Set(round(lps25h.Pressure(), 2))
-		temp.Set(round(lps25h.Temperature(), 2))
+	if err := setAuth(opts); err != nil {
+		log.Fatalln("Authentication:", err)
 	}
-}
 
-func registerLSM9DS1(lsm9ds1 *AvgLSM9DS1) func() {
-	accel := promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "lsm9ds1",
-		Name:      "accel_field",
-	}, []string{"direction"})
+	dev, err := i2c.Open(cli().Device)
+	if err != nil {
+		log.Fatalln("open I2C device:", err)
+	}
 
-	accelA := promauto.NewGaugeVec(prometheus.GaugeOpts{
+	bus := i2c.NewBus(dev)
+	i2c.SetDefaultRetries(cli().I2CRetries, cli().I2CBackoff)
+	register(prometheus.NewCounterFunc(prometheus.CounterOpts{
 		Namespace: "sensors",
-		Subsystem: "lsm9ds1",
-		Name:      "accel_angle_degrees",
-	}, []string{"plane"})
-
-	buckets := []float64{0}
-	for i := 1; i < 10; i++ {
-		buckets = append([]float64{float64(-i)}, buckets...)
-		buckets = append(buckets, float64(i))
+		Subsystem: "i2c",
+		Name:      "retries_total",
+		Help:      "I2C operations retried after a transient error.",
+	}, func() float64 { return float64(i2c.Retried()) }))
+	register(serialCollector{})
+
+	detected = detectBoards(bus)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var running runningSensors
+	if err := running.apply(ctx, bus); err != nil && !cli().Hotplug {
+		os.Exit(1)
 	}
-	for i := 10; i < 20; i += 2 {
-		buckets = append([]float64{float64(-i)}, buckets...)
-		buckets = append(buckets, float64(i))
+	if len(running) == 0 && !cli().Hotplug {
+		log.Fatal("No sensors enabled? Enable some sensors.")
 	}
-	for i := 20; i < 50; i += 5 {
-		buckets = append([]float64{float64(-i)}, buckets...)
-		buckets = append(buckets, float64(i))
+	var plugged <-chan struct{}
+	if cli().Hotplug {
+		plugged = watchHotplug(ctx)
 	}
+	checkBudget()
+	systemd.checkInterval(cli().UpdateInterval)
+	systemd.notify("READY=1")
 
-	accelAH := promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Namespace: "sensors",
-		Subsystem: "lsm9ds1",
-		Name:      "accel_angle_degrees_histogram",
-		Buckets:   buckets,
-	}, []string{"plane"})
-
-	devA := promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "lsm9ds1",
-		Name:      "accel_deviation_degrees",
-	}, []string{"plane"})
-
-	compA := promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "lsm9ds1",
-		Name:      "compass_degrees",
-	}, []string{"plane"})
-
-	compF := promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "lsm9ds1",
-		Name:      "magnetic_field",
-	}, []string{"direction"})
-
-	return func() {
-		x, y, z := lsm9ds1.Acceleration()
-		accel.WithLabelValues("x").Set(float64(x))
-		accel.WithLabelValues("y").Set(float64(y))
-		accel.WithLabelValues("z").Set(float64(z))
-		xy, xz, yz := lsm9ds1.MedianAccelerationAngles()
-		accelA.WithLabelValues("xy").Set(round(xy, 2))
-		accelA.WithLabelValues("xz").Set(round(xz, 2))
-		accelA.WithLabelValues("yz").Set(round(yz, 2))
-		xy, xz, yz = lsm9ds1.AccelerationAngles()
-		accelAH.WithLabelValues("xy").Observe(xy)
-		accelAH.WithLabelValues("xz").Observe(xz)
-		accelAH.WithLabelValues("yz").Observe(yz)
-		xy, xz, yz = lsm9ds1.Deviation()
-		devA.WithLabelValues("xy").Set(round(xy, 2))
-		devA.WithLabelValues("xz").Set(round(xz, 2))
-		devA.WithLabelValues("yz").Set(round(yz, 2))
-		xy, xz, yz = lsm9ds1.Compass()
-		compA.WithLabelValues("xy").Set(round(xy, 2))
-		compA.WithLabelValues("xz").Set(round(xz, 2))
-		compA.WithLabelValues("yz").Set(round(yz, 2))
-
-		x = abs(x)
-		y = abs(y)
-		z = abs(z)
-		h := 0.0
-		switch {
-		case x > y && x > z:
-			// x is down
-			h = yz
-		case y > x && y > z:
-			// y is down
-			h = xz
-		case z > x && z > y:
-			// z is down
-			h = xy
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		running.call(0)
+		systemd.ping(time.Now())
+		intv := cli().UpdateInterval
+		t := time.NewTicker(intv)
+		exp := time.NewTicker(time.Minute)
+		for tick := 1; ; tick++ {
+			select {
+			case <-t.C:
+				running.call(tick)
+				systemd.ping(time.Now())
+			case <-exp.C:
+				if cli().MetricExpiry > 0 {
+					expireMetrics(cli().MetricExpiry)
+					moisture.expire(cli().MetricExpiry)
+				}
+				checkBudget()
+			case <-plugged:
+				if cli().Hotplug {
+					running.apply(ctx, bus)
+				}
+			case <-hup:
+				systemd.notify("RELOADING=1")
+				reload(ctx, bus, &running)
+				if cli().UpdateInterval != intv {
+					intv = cli().UpdateInterval
+					t.Stop()
+					t = time.NewTicker(intv)
+					systemd.checkInterval(intv)
+				}
+				systemd.notify("READY=1")
+			case <-ctx.Done():
+				t.Stop()
+				exp.Stop()
+				return
+			}
 		}
-		compA.WithLabelValues("horiz").Set(round(h, 2))
-
-		x, y, z = lsm9ds1.MagneticField()
-		compF.WithLabelValues("x").Set(float64(x))
-		compF.WithLabelValues("y").Set(float64(y))
-		compF.WithLabelValues("z").Set(float64(z))
-	}
-}
-
-func abs(v int16) int16 {
-	if v < 0 {
-		return -v
-	}
-	return v
-}
-
-func registerOmini(omini *omini.Omini) func() {
-	vv := promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "sensors",
-		Subsystem: "omini",
-		Name:      "voltage",
-	}, []string{"channel"})
-
-	logLine := ""
+	}()
 
-	return func() {
-		a, b, c, err := omini.Voltages()
+	http.Handle("/metrics", promhttp.Handler())
+	handleMetricClasses()
+	http.HandleFunc("/alarms", handleAlarms)
+	http.HandleFunc("/moisture", handleMoisture)
+	http.HandleFunc("/forecast", handleForecast)
+	http.HandleFunc("/api/v1/query", handleQuery)
+	http.HandleFunc("/api/v1/meta", handleMeta)
+	http.HandleFunc("/api/v1/locations", handleLocations)
+	http.HandleFunc("/locations", handleLocationsPage)
+	http.HandleFunc("/api/v1/attitude", handleAttitudeHistory)
+	http.HandleFunc("/api/v1/polar", handlePolar)
+	http.HandleFunc("/api/v1/race", handleRace)
+	http.HandleFunc("/api/v1/anchor", handleAnchor)
+	http.HandleFunc("/api/v1/outputs", handleOutputs)
+	http.HandleFunc("/api/v1/dimmers", handleDimmers)
+	http.HandleFunc("/map", handleMap)
+	http.HandleFunc("/webhook/", handleWebhook)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	srv := &http.Server{Handler: requireAuth(http.DefaultServeMux)}
+	for _, addr := range cli().PrometheusAddr {
+		l, err := listenTCP(addr)
 		if err != nil {
-			log.Println("Omini:", err)
-			vv.WithLabelValues("a").Set(0)
-			vv.WithLabelValues("b").Set(0)
-			vv.WithLabelValues("c").Set(0)
-			return
-		}
-
-		var vals []string
-		if a > 1 {
-			vals = append(vals, fmt.Sprintf("%.01f V (%.0f %%)", a, batteryState.val(a)))
+			log.Fatalln("HTTP server:", err)
 		}
-		if b > 1 {
-			vals = append(vals, fmt.Sprintf("%.01f V (%.0f %%)", b, batteryState.val(b)))
-		}
-		if c > 1 {
-			vals = append(vals, fmt.Sprintf("%.01f V (%.0f %%)", c, batteryState.val(c)))
-		}
-		if len(vals) > 0 {
-			newLogLine := fmt.Sprintf("Omini: %s", strings.Join(vals, ", "))
-			if newLogLine != logLine {
-				logLine = newLogLine
-				log.Println(logLine)
+		go func() {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				log.Fatalln("HTTP server:", err)
 			}
-		}
-
-		vv.WithLabelValues("a").Set(a)
-		vv.WithLabelValues("b").Set(b)
-		vv.WithLabelValues("c").Set(c)
+		}()
 	}
-}
 
-func round(x float64, prec int) float64 {
-	pow := math.Pow10(prec)
-	return math.Round(x*pow) / pow
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	<-term
+	shutdown(srv, cancel, loopDone)
 }
 
-func saveCalibration(file string, cal sensehat.Calibration) error {
-	fd, err := os.Create(file)
-	if err != nil {
-		return err
+// shutdown stops the HTTP server and the update loop, then stops the
+// sensors and waits for their cleanups, such as the final calibration
+// write, for at most shutdownTimeout.
+func shutdown(srv *http.Server, cancel context.CancelFunc, loopDone <-chan struct{}) {
+	log.Println("Shutting down")
+	systemd.notify("STOPPING=1")
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("HTTP server shutdown:", err)
 	}
-	enc := json.NewEncoder(fd)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(&cal); err != nil {
-		fd.Close()
-		return err
+
+	// Cancelling the context stops the update loop and all the sensors.
+	// The loop must be out of the way before waiting for the cleanups, as
+	// a reload could start new sensors.
+	cancel()
+	<-loopDone
+
+	done := make(chan struct{})
+	go func() {
+		cleanups.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("Timed out waiting for sensors to stop")
 	}
-	return fd.Close()
 }
 
-func loadCalibration(file string) sensehat.Calibration {
-	fd, err := os.Open(file)
-	if err != nil {
-		return sensehat.Calibration{}
-	}
-	defer fd.Close()
+var seaTemp *gauge
 
-	var cal sensehat.Calibration
-	dec := json.NewDecoder(fd)
-	if err := dec.Decode(&cal); err != nil {
-		return sensehat.Calibration{}
+// seaTemperature returns the sea temperature gauge, which is set by
+// whichever sensor is the configured source.
+func seaTemperature() *gauge {
+	if seaTemp == nil {
+		seaTemp = newGauge(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Name:      "sea_temperature_celsius",
+		})
 	}
-
-	return cal
+	return seaTemp
 }
 
-var batteryState = interpolation{
-	x: []float64{11.8, 12.0, 12.2, 12.4, 12.7},
-	y: []float64{0, 25.0, 50.0, 75.0, 100},
-}
+// A precision is the number of decimals kept in values for a given output.
+// Negative precision means the value is passed on unrounded.
+type precision int
 
-type interpolation struct {
-	x, y []float64
+func (p precision) round(x float64) float64 {
+	if p < 0 {
+		return x
+	}
+	pow := math.Pow10(int(p))
+	return math.Round(x*pow) / pow
 }
 
-func (n interpolation) val(x float64) float64 {
-	if x <= n.x[0] {
-		return n.y[0]
-	}
-	for i := 1; i < len(n.x); i++ {
-		if x <= n.x[i] {
-			return n.y[i-1] + (x-n.x[i-1])*(n.y[i]-n.y[i-1])/(n.x[i]-n.x[i-1])
-		}
+func (p precision) format(x float64) string {
+	if p < 0 {
+		return strconv.FormatFloat(x, 'f', -1, 64)
 	}
-	return n.y[len(n.y)-1]
+	return strconv.FormatFloat(x, 'f', int(p), 64)
 }
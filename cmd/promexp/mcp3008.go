@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/calmh/boatpi/adc"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/spi"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "mcp3008",
+		section: true,
+		enabled: func(o options) bool { return o.WithMCP3008 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.MCP3008Device, o.MCP3008Reference, c.Channels}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			channels, err := mcp3008Channels(conf.Channels)
+			if err != nil {
+				return nil, err
+			}
+			dev, err := spi.Open(cli().MCP3008Device, spi.Mode0, adc.MCP3008Speed)
+			if err != nil {
+				return nil, err
+			}
+			mcp, err := adc.NewMCP3008(dev, cli().MCP3008Device, cli().MCP3008Reference, channels)
+			if err != nil {
+				dev.Close()
+				return nil, err
+			}
+			onDone(ctx, func() {
+				dev.Close()
+			})
+			meta.setDevices("mcp3008", mcp)
+			return registerADC("mcp3008", "MCP3008", mcp, conf.Channels), nil
+		},
+	})
+}
+
+// mcp3008Channels returns the driver channels, in the order of
+// adcChannelNames.
+func mcp3008Channels(confs map[string]adcChannelConfig) ([]adc.MCP3008Channel, error) {
+	var channels []adc.MCP3008Channel
+	for _, name := range adcChannelNames(confs) {
+		c := confs[name]
+		in, err := adc.ParseMCP3008Input(c.Input)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", name, err)
+		}
+		if c.Range != 0 || c.Rate != 0 {
+			return nil, fmt.Errorf("channel %s: the range and rate are fixed on the MCP3008", name)
+		}
+		channels = append(channels, adc.MCP3008Channel{Name: name, Input: in})
+	}
+	return channels, nil
+}
+
+func validateMCP3008Channels(confs map[string]adcChannelConfig) error {
+	_, err := mcp3008Channels(confs)
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/sensor"
+)
+
+// /api/v1/meta describes the active sensors: the hardware, the fields with
+// their units as exported, the quantities and the precision worth showing,
+// the configured calibration and when they were last read, so that generic
+// clients can render the data sensibly.
+//
+// The sensors set their devices when initialized; the configuration and
+// the selected units are those currently in effect.
+
+type sensorMeta struct {
+	devices []sensor.Sensor
+}
+
+type metaMap struct {
+	mut     sync.Mutex
+	sensors map[string]*sensorMeta
+}
+
+var meta = &metaMap{sensors: make(map[string]*sensorMeta)}
+
+// setDevices sets the devices of the sensor, replacing any earlier ones.
+func (m *metaMap) setDevices(name string, devs ...sensor.Sensor) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.get(name).devices = devs
+}
+
+// start adds the sensor, without devices until it sets them.
+func (m *metaMap) start(name string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.get(name)
+}
+
+func (m *metaMap) remove(name string) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	delete(m.sensors, name)
+}
+
+func (m *metaMap) get(name string) *sensorMeta {
+	s, ok := m.sensors[name]
+	if !ok {
+		s = &sensorMeta{}
+		m.sensors[name] = s
+	}
+	return s
+}
+
+type metaSensor struct {
+	Name        string             `json:"name"`
+	Devices     []metaDevice       `json:"devices"`
+	Offsets     map[string]float64 `json:"offsets,omitempty"`
+	Gains       map[string]float64 `json:"gains,omitempty"`
+	Location    string             `json:"location,omitempty"`
+	Locations   map[string]string  `json:"locations,omitempty"`
+	Interval    string             `json:"interval"`
+	LastRefresh *time.Time         `json:"lastRefresh,omitempty"`
+}
+
+type metaDevice struct {
+	Chip    string      `json:"chip,omitempty"`
+	Bus     string      `json:"bus,omitempty"`
+	Address string      `json:"address,omitempty"`
+	ID      string      `json:"id,omitempty"`
+	Fields  []metaField `json:"fields"`
+}
+
+type metaField struct {
+	Name      string `json:"name"`
+	Unit      string `json:"unit"`
+	Quantity  string `json:"quantity,omitempty"`
+	Precision int    `json:"precision"`
+}
+
+// metaFieldOf returns the description of the reading, in the selected
+// units.
+func (m *metaMap) metaFieldOf(r sensor.Reading) metaField {
+	unit, prec := exportedUnit(*cli(), r.Unit, r.Precision)
+	return metaField{Name: r.Name, Unit: unit, Quantity: r.Quantity, Precision: prec}
+}
+
+// field returns the description of the field exported as the named
+// metric, if it is one of the readings of a sensor: the metric is named
+// for the sensor, the reading and its unit, or the reading alone.
+func (m *metaMap) field(metric string) (metaField, bool) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	for name, s := range m.sensors {
+		prefix := "sensors_" + name + "_"
+		if !strings.HasPrefix(metric, prefix) {
+			continue
+		}
+		for _, dev := range s.devices {
+			for _, r := range dev.Readings() {
+				f := m.metaFieldOf(r)
+				if metric == prefix+r.Name || f.Unit != "" && metric == prefix+r.Name+"_"+f.Unit {
+					return f, true
+				}
+			}
+		}
+	}
+	return metaField{}, false
+}
+
+func (m *metaMap) report() []metaSensor {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	intv := cli().UpdateInterval
+	res := make([]metaSensor, 0, len(m.sensors))
+	for name, s := range m.sensors {
+		conf := sensorConf(name)
+		ms := metaSensor{
+			Name:      name,
+			Devices:   make([]metaDevice, 0, len(s.devices)),
+			Offsets:   conf.Offsets,
+			Gains:     conf.Gains,
+			Location:  conf.Location,
+			Locations: conf.Locations,
+			Interval:  conf.interval(intv).String(),
+		}
+		for _, dev := range s.devices {
+			var md metaDevice
+			if d, ok := dev.(sensor.Describer); ok {
+				info := d.Info()
+				md = metaDevice{Chip: info.Chip, Bus: info.Bus, Address: info.Address, ID: info.ID}
+			}
+			for _, r := range dev.Readings() {
+				md.Fields = append(md.Fields, m.metaFieldOf(r))
+			}
+			ms.Devices = append(ms.Devices, md)
+		}
+		res = append(res, ms)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Name < res[b].Name })
+	return res
+}
+
+func handleMeta(w http.ResponseWriter, req *http.Request) {
+	sensors := meta.report()
+	last := health.lastSuccess()
+	for i := range sensors {
+		if t, ok := last[sensors[i].Name]; ok {
+			sensors[i].LastRefresh = &t
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"sensors": sensors})
+}
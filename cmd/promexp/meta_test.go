@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/sensor"
+)
+
+type fakeSensor struct{}
+
+func (fakeSensor) Refresh(time.Duration) error { return nil }
+
+func (fakeSensor) Readings() []sensor.Reading {
+	return []sensor.Reading{{Name: "pressure", Unit: "mb", Quantity: sensor.Pressure, Precision: 1, Value: 1013}}
+}
+
+func (fakeSensor) Info() sensor.Info {
+	return sensor.Info{Chip: "LPS25H", Bus: "i2c", Address: "0x5c", ID: "WHO_AM_I 0xbd"}
+}
+
+func TestMetaReport(t *testing.T) {
+	prev := current.Load()
+	defer current.Store(prev)
+	setConfig(options{UpdateInterval: time.Second}, fileSections{Sensors: map[string]sensorConfig{
+		"lps25h": {Offsets: map[string]float64{"pressure": 1.5}, Interval: 2 * time.Second},
+	}})
+
+	m := &metaMap{sensors: make(map[string]*sensorMeta)}
+	m.setDevices("lps25h", fakeSensor{})
+	m.start("gps")
+
+	exp := []metaSensor{
+		{Name: "gps", Devices: []metaDevice{}, Interval: "1s"},
+		{
+			Name: "lps25h",
+			Devices: []metaDevice{{
+				Chip: "LPS25H", Bus: "i2c", Address: "0x5c", ID: "WHO_AM_I 0xbd",
+				Fields: []metaField{{"pressure", "mb", "pressure", 1}},
+			}},
+			Offsets:  map[string]float64{"pressure": 1.5},
+			Interval: "2s",
+		},
+	}
+	if res := m.report(); !reflect.DeepEqual(res, exp) {
+		t.Errorf("got %+v, expected %+v", res, exp)
+	}
+
+	withOptions(t, func(o *options) { o.PressureUnit = "inhg" })
+	if f, ok := m.field("sensors_lps25h_pressure_inhg"); !ok || f.Unit != "inhg" || f.Precision != 3 {
+		t.Errorf("unexpected field %+v %v", f, ok)
+	}
+	if _, ok := m.field("sensors_lps25h_pressure_mb"); ok {
+		t.Error("expected no field in the unselected unit")
+	}
+
+	m.remove("lps25h")
+	if res := m.report(); len(res) != 1 {
+		t.Errorf("unexpected %+v after removal", res)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The register functions are called again when a sensor is reinitialized
+// after a configuration reload, so metrics are registered in a way that
+// returns the existing collector if there already is one.
+//
+// Gauges are wrapped so that every value passes through the same output
+// stage: conversion to the selected units (see units.go), optional
+// smoothing, as configured per metric, and rounding to the metrics
+// precision. That stage is for export only: the values as set, in the
+// base units and before smoothing and rounding, are what expressions and
+// other internal consumers see (see rawValue).
+//
+// The wrappers are kept by name, so that a reinitialized sensor reuses the
+// same ones and the set of them stays bounded over months of uptime.
+// Series that are no longer updated, such as those of a probe that has
+// been removed or a GPS that has gone silent, are expired rather than
+// exported with a frozen value. Unlabelled gauges are unregistered and
+// come back on the next update.
+
+var (
+	wrappersMut sync.Mutex
+	gauges      = make(map[string]*gauge)
+	gaugeVecs   = make(map[string]*gaugeVec)
+	// by the name in the base unit, for rawValue
+	rawGauges    = make(map[string]*gauge)
+	rawGaugeVecs = make(map[string]*gaugeVec)
+)
+
+type gauge struct {
+	g    prometheus.Gauge
+	name string
+	lvs  []string
+	conv func(float64) float64 // to the output unit, if converted
+
+	mut      sync.Mutex
+	raw      float64 // as set
+	updated  time.Time
+	expired  bool
+	avg      float64
+	sin, cos float64 // averaged unit vector, for angles
+	last     time.Time
+}
+
+func (g *gauge) Set(v float64) {
+	g.mut.Lock()
+	g.raw = v
+	g.updated = time.Now()
+	if g.expired {
+		register(g.g)
+		g.expired = false
+	}
+	g.mut.Unlock()
+	if g.conv != nil {
+		v = g.conv(v)
+	}
+	if tau := sections().Smoothing[g.name]; tau > 0 {
+		if isAngle(g.name) {
+			v = g.smoothAngle(v, tau, time.Now())
+		} else {
+			v = g.smooth(v, tau, time.Now())
+		}
+	}
+	g.g.Set(cli().MetricsPrecision.round(v))
+}
+
+// isAngle returns whether the named metric is an angle in degrees, to be
+// averaged as such.
+func isAngle(name string) bool {
+	return strings.HasSuffix(name, "_degrees")
+}
+
+// smooth applies an exponentially weighted moving average with the given
+// time constant. The weight depends on the time since the last sample, so
+// the result does not depend on the update interval.
+func (g *gauge) smooth(v float64, tau time.Duration, now time.Time) float64 {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.avg += g.alpha(tau, now) * (v - g.avg)
+	return g.avg
+}
+
+// smoothAngle is like smooth, for angles in degrees. The average is kept as
+// a unit vector so that it behaves across the 360/0 wrap. The result is
+// positive if the input is.
+func (g *gauge) smoothAngle(v float64, tau time.Duration, now time.Time) float64 {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	alpha := g.alpha(tau, now)
+	rad := v / 180 * math.Pi
+	g.sin += alpha * (math.Sin(rad) - g.sin)
+	g.cos += alpha * (math.Cos(rad) - g.cos)
+	res := math.Atan2(g.sin, g.cos) / math.Pi * 180
+	if v >= 0 && res < 0 {
+		res += 360
+	}
+	return res
+}
+
+func (g *gauge) alpha(tau time.Duration, now time.Time) float64 {
+	last := g.last
+	g.last = now
+	if last.IsZero() {
+		return 1
+	}
+	return 1 - math.Exp(-float64(now.Sub(last))/float64(tau))
+}
+
+type gaugeVec struct {
+	v      *prometheus.GaugeVec
+	name   string
+	labels []string
+	conv   func(float64) float64
+
+	mut    sync.Mutex
+	gauges map[string]*gauge
+}
+
+func (v *gaugeVec) WithLabelValues(lvs ...string) *gauge {
+	key := strings.Join(lvs, "\x00")
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	g, ok := v.gauges[key]
+	if !ok {
+		g = &gauge{g: v.v.WithLabelValues(lvs...), name: v.name, lvs: lvs, conv: v.conv}
+		v.gauges[key] = g
+	}
+	return g
+}
+
+// expire removes the series that have not been updated since the cutoff.
+func (v *gaugeVec) expire(cutoff time.Time) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	for key, g := range v.gauges {
+		g.mut.Lock()
+		stale := g.updated.Before(cutoff)
+		g.mut.Unlock()
+		if stale {
+			v.v.DeleteLabelValues(g.lvs...)
+			delete(v.gauges, key)
+		}
+	}
+}
+
+func newGauge(opts prometheus.GaugeOpts) *gauge {
+	raw := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	opts, conv := convertUnit(opts)
+	name := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	wrappersMut.Lock()
+	defer wrappersMut.Unlock()
+	g, ok := gauges[name]
+	if !ok {
+		g = &gauge{
+			g:    register(prometheus.NewGauge(opts)).(prometheus.Gauge),
+			name: name,
+			conv: conv,
+		}
+		gauges[name] = g
+	}
+	rawGauges[raw] = g
+	return g
+}
+
+func newGaugeVec(opts prometheus.GaugeOpts, labels []string) *gaugeVec {
+	raw := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	opts, conv := convertUnit(opts)
+	name := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	wrappersMut.Lock()
+	defer wrappersMut.Unlock()
+	v, ok := gaugeVecs[name]
+	if !ok {
+		v = &gaugeVec{
+			v:      register(prometheus.NewGaugeVec(opts, labels)).(*prometheus.GaugeVec),
+			name:   name,
+			labels: labels,
+			conv:   conv,
+			gauges: make(map[string]*gauge),
+		}
+		gaugeVecs[name] = v
+	}
+	rawGaugeVecs[raw] = v
+	return v
+}
+
+// rawValue returns the value of the gauge series as last set, by its name
+// in the base unit. The result is false if there is no such gauge, in
+// which case the series may still be some other metric. Series that have
+// expired or not yet been set are not found.
+func rawValue(ref seriesRef) (float64, bool, error) {
+	wrappersMut.Lock()
+	g, isGauge := rawGauges[ref.name]
+	v, isVec := rawGaugeVecs[ref.name]
+	wrappersMut.Unlock()
+
+	switch {
+	case isGauge:
+		if len(ref.labels) > 0 {
+			return 0, true, fmt.Errorf("no series matches %v", ref)
+		}
+		g.mut.Lock()
+		defer g.mut.Unlock()
+		if g.expired || g.updated.IsZero() {
+			return 0, true, fmt.Errorf("no series matches %v", ref)
+		}
+		return g.raw, true, nil
+
+	case isVec:
+		idx := make(map[int]string, len(ref.labels))
+		for i, l := range v.labels {
+			if want, ok := ref.labels[l]; ok {
+				idx[i] = want
+			}
+		}
+		if len(idx) != len(ref.labels) {
+			return 0, true, fmt.Errorf("no series matches %v", ref)
+		}
+		v.mut.Lock()
+		defer v.mut.Unlock()
+		var match *gauge
+	series:
+		for _, g := range v.gauges {
+			for i, want := range idx {
+				if g.lvs[i] != want {
+					continue series
+				}
+			}
+			g.mut.Lock()
+			set := !g.updated.IsZero()
+			g.mut.Unlock()
+			if !set {
+				continue
+			}
+			if match != nil {
+				return 0, true, fmt.Errorf("%v matches several series", ref)
+			}
+			match = g
+		}
+		if match == nil {
+			return 0, true, fmt.Errorf("no series matches %v", ref)
+		}
+		match.mut.Lock()
+		defer match.mut.Unlock()
+		return match.raw, true, nil
+	}
+	return 0, false, nil
+}
+
+// expireMetrics removes labelled series that have not been updated for
+// maxAge.
+func expireMetrics(maxAge time.Duration) {
+	cutoff := time.Now().Add(-maxAge)
+	wrappersMut.Lock()
+	defer wrappersMut.Unlock()
+	for _, g := range gauges {
+		g.expire(cutoff)
+	}
+	for _, v := range gaugeVecs {
+		v.expire(cutoff)
+	}
+}
+
+// expire unregisters the gauge if it has not been updated since the
+// cutoff.
+func (g *gauge) expire(cutoff time.Time) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	if !g.expired && g.updated.Before(cutoff) {
+		prometheus.Unregister(g.g)
+		g.expired = true
+	}
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	return register(prometheus.NewCounterVec(opts, labels)).(*prometheus.CounterVec)
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	return register(prometheus.NewHistogramVec(opts, labels)).(*prometheus.HistogramVec)
+}
+
+func newHistogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	return register(prometheus.NewHistogram(opts)).(prometheus.Histogram)
+}
+
+func register(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGaugeSmoothing(t *testing.T) {
+	var g gauge
+	t0 := time.Now()
+
+	if v := g.smooth(10, time.Minute, t0); v != 10 {
+		t.Errorf("first sample should pass through, got %v", v)
+	}
+
+	// After one time constant, 63 % of a step is reached regardless of
+	// how many samples it took to get there.
+	var fast, slow gauge
+	fast.smooth(0, time.Minute, t0)
+	slow.smooth(0, time.Minute, t0)
+	var vf, vs float64
+	for i := 1; i <= 60; i++ {
+		vf = fast.smooth(1, time.Minute, t0.Add(time.Duration(i)*time.Second))
+	}
+	for i := 1; i <= 6; i++ {
+		vs = slow.smooth(1, time.Minute, t0.Add(time.Duration(i)*10*time.Second))
+	}
+	exp := 1 - math.Exp(-1)
+	if math.Abs(vf-exp) > 1e-9 || math.Abs(vs-exp) > 1e-9 {
+		t.Errorf("expected %v for both, got %v and %v", exp, vf, vs)
+	}
+}
+
+func TestGaugeSmoothingAngles(t *testing.T) {
+	var g gauge
+	t0 := time.Now()
+	g.smoothAngle(350, time.Minute, t0)
+	v := g.smoothAngle(10, time.Minute, t0.Add(time.Minute))
+	if v > 10 && v < 350 {
+		t.Errorf("expected average near north, got %v", v)
+	}
+}
+
+func TestGaugeVecExpire(t *testing.T) {
+	v := newGaugeVec(prometheus.GaugeOpts{Name: "test_expire"}, []string{"id"})
+	if newGaugeVec(prometheus.GaugeOpts{Name: "test_expire"}, []string{"id"}) != v {
+		t.Error("expected the same wrapper for the same name")
+	}
+	v.WithLabelValues("old").Set(1)
+	time.Sleep(time.Millisecond)
+	cutoff := time.Now()
+	v.WithLabelValues("new").Set(2)
+
+	v.expire(cutoff)
+	if len(v.gauges) != 1 || v.gauges["new"] == nil {
+		t.Errorf("expected only the new series to remain, got %v", v.gauges)
+	}
+}
+
+func TestGaugeExpire(t *testing.T) {
+	g := newGauge(prometheus.GaugeOpts{Name: "test_gauge_expire"})
+	g.Set(1)
+	g.expire(time.Now().Add(time.Minute))
+	if !g.expired {
+		t.Fatal("expected gauge to be expired")
+	}
+	if err := prometheus.Register(g.g); err != nil {
+		t.Fatal("expected expired gauge to be unregistered:", err)
+	}
+	prometheus.Unregister(g.g)
+
+	g.Set(2)
+	if g.expired {
+		t.Error("expected gauge to come back on update")
+	}
+	if err := prometheus.Register(g.g); err == nil {
+		t.Error("expected gauge to be registered again")
+	}
+}
+
+func TestGatheredLookupRaw(t *testing.T) {
+	withOptions(t, func(o *options) {
+		o.TemperatureUnit = "fahrenheit"
+		o.MetricsPrecision = 0
+	})
+
+	v := newGaugeVec(prometheus.GaugeOpts{Name: "test_raw_celsius"}, []string{"id"})
+	v.WithLabelValues("a").Set(21.46)
+	v.WithLabelValues("b")
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := gatheredLookup(mfs)
+
+	// Expressions see the value as set, in Celsius and unrounded.
+	if got, err := lookup(seriesRef{name: "test_raw_celsius", labels: map[string]string{"id": "a"}}); err != nil || got != 21.46 {
+		t.Errorf("raw value %v, %v; expected 21.46", got, err)
+	}
+	// The series never set is not there, so the selector is not
+	// ambiguous.
+	if got, err := lookup(seriesRef{name: "test_raw_celsius"}); err != nil || got != 21.46 {
+		t.Errorf("raw value %v, %v; expected 21.46", got, err)
+	}
+	if _, err := lookup(seriesRef{name: "test_raw_celsius", labels: map[string]string{"other": "a"}}); err == nil {
+		t.Error("expected no match for an unknown label")
+	}
+	// The export is converted and rounded.
+	if got, err := lookup(seriesRef{name: "test_raw_fahrenheit", labels: map[string]string{"id": "a"}}); err != nil || got != 71 {
+		t.Errorf("exported value %v, %v; expected 71", got, err)
+	}
+}
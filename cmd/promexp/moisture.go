@@ -0,0 +1,160 @@
+package main
+
+import (
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Moisture tracking across all humidity sources: the margin between air
+// temperature and dew point (how far a surface at air temperature is from
+// condensation) and a mold risk score.
+
+type moistureReading struct {
+	Source      string
+	Temperature float64
+	Humidity    float64
+	Margin      float64
+	MoldRisk    float64
+	Updated     time.Time
+}
+
+type moistureMap struct {
+	mut      sync.Mutex
+	readings map[string]moistureReading
+	margin   *gaugeVec
+	risk     *gaugeVec
+	maxRisk  *gauge
+}
+
+var moisture = &moistureMap{readings: make(map[string]moistureReading)}
+
+func (m *moistureMap) observe(source string, temp, hum float64) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if m.margin == nil {
+		m.margin = newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: "moisture",
+			Name:      "dewpoint_margin_celsius",
+		}, []string{"source"})
+		m.risk = newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: "moisture",
+			Name:      "mold_risk",
+			Help:      "Mold growth risk, 0 (none) to 1 (high).",
+		}, []string{"source"})
+		m.maxRisk = newGauge(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: "moisture",
+			Name:      "mold_risk_max",
+			Help:      "Highest mold growth risk across all sources.",
+		})
+	}
+
+	r := moistureReading{
+		Source:      source,
+		Temperature: temp,
+		Humidity:    hum,
+		Margin:      temp - dewPoint(temp, hum),
+		MoldRisk:    moldRisk(temp, hum),
+		Updated:     time.Now(),
+	}
+	m.readings[source] = r
+
+	m.margin.WithLabelValues(source).Set(r.Margin)
+	m.risk.WithLabelValues(source).Set(r.MoldRisk)
+	max := 0.0
+	for _, r := range m.readings {
+		max = math.Max(max, r.MoldRisk)
+	}
+	m.maxRisk.Set(max)
+}
+
+// expire forgets readings from sources that have not been updated for
+// maxAge, so that a removed sensor does not hold up the maximum risk.
+func (m *moistureMap) expire(maxAge time.Duration) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	cutoff := time.Now().Add(-maxAge)
+	for source, r := range m.readings {
+		if r.Updated.Before(cutoff) {
+			delete(m.readings, source)
+		}
+	}
+}
+
+func (m *moistureMap) list() []moistureReading {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	res := make([]moistureReading, 0, len(m.readings))
+	for _, r := range m.readings {
+		res = append(res, r)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Source < res[b].Source })
+	return res
+}
+
+// dewPoint returns the dew point in degrees Celsius, using the Magnus
+// formula with the Sonntag (1990) constants.
+func dewPoint(temp, hum float64) float64 {
+	const b, c = 17.62, 243.12
+	if hum <= 0 {
+		return math.Inf(-1)
+	}
+	g := math.Log(hum/100) + b*temp/(c+temp)
+	return c * g / (b - g)
+}
+
+// moldRisk returns a mold growth risk score between zero and one. The
+// critical humidity below which there is no growth follows the VTT model
+// (Hukka & Viitanen); the risk then increases linearly up to saturation.
+func moldRisk(temp, hum float64) float64 {
+	if temp <= 0 || temp >= 50 {
+		return 0
+	}
+	crit := 80.0
+	if temp < 20 {
+		crit = -0.00267*temp*temp*temp + 0.160*temp*temp - 3.13*temp + 100
+	}
+	if hum <= crit {
+		return 0
+	}
+	return math.Min(1, (hum-crit)/(100-crit))
+}
+
+var moistureTpl = template.Must(template.New("moisture").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="60">
+<title>Moisture map</title>
+<style>
+body { font-family: sans-serif; }
+td, th { padding: 0.3em 1em; text-align: right; }
+td:first-child, th:first-child { text-align: left; }
+.ok { background: #cfc; } .warn { background: #ffc; } .risk { background: #fcc; }
+</style>
+</head>
+<body>
+<h1>Moisture map</h1>
+<table>
+<tr><th>Source</th><th>Temperature</th><th>Humidity</th><th>Dew point margin</th><th>Mold risk</th><th>Updated</th></tr>
+{{range .}}<tr class="{{if gt .MoldRisk 0.5}}risk{{else if gt .MoldRisk 0.0}}warn{{else}}ok{{end}}">
+<td>{{.Source}}</td><td>{{printf "%.1f" .Temperature}} °C</td><td>{{printf "%.0f" .Humidity}} %</td><td>{{printf "%.1f" .Margin}} °C</td><td>{{printf "%.2f" .MoldRisk}}</td><td>{{.Updated.Format "15:04:05"}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func handleMoisture(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	moistureTpl.Execute(w, moisture.list())
+}
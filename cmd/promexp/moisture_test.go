@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDewPoint(t *testing.T) {
+	cases := []struct {
+		temp, hum, dp float64
+	}{
+		{20, 50, 9.3},
+		{25, 80, 21.3},
+		{10, 100, 10},
+		{-5, 70, -9.6},
+	}
+	for _, tc := range cases {
+		if dp := dewPoint(tc.temp, tc.hum); math.Abs(dp-tc.dp) > 0.1 {
+			t.Errorf("dewPoint(%v, %v) = %.2f, expected %v", tc.temp, tc.hum, dp, tc.dp)
+		}
+	}
+}
+
+func TestMoldRisk(t *testing.T) {
+	if r := moldRisk(20, 60); r != 0 {
+		t.Errorf("expected no risk at 20 °C, 60 %%, got %v", r)
+	}
+	if r := moldRisk(20, 90); r != 0.5 {
+		t.Errorf("expected 0.5 risk at 20 °C, 90 %%, got %v", r)
+	}
+	if r := moldRisk(5, 85); r != 0 {
+		t.Errorf("expected no risk at 5 °C, 85 %%, got %v", r)
+	}
+}
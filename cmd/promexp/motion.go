@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// The motion detector tells whether the boat is underway, going by how
+// much the acceleration angles move, so that the LSM9DS1 can be polled
+// quickly at sea and slowly in the marina. There is hysteresis: the boat
+// is underway as soon as the angles move by more than the threshold within
+// the window, and still again only after they've moved less than half of
+// that for the hold time.
+
+const (
+	motionWindow = 10 * time.Second
+	motionHold   = 5 * time.Minute
+)
+
+type motionSample struct {
+	t      time.Time
+	angles [3]float64
+}
+
+type motionDetector struct {
+	threshold float64 // degrees
+
+	samples    []motionSample
+	underway   bool
+	lastMotion time.Time
+}
+
+// observe adds the acceleration angles at the time and returns whether the
+// boat is underway.
+func (m *motionDetector) observe(now time.Time, angles [3]float64) bool {
+	m.samples = append(m.samples, motionSample{now, angles})
+	i := 0
+	for i < len(m.samples) && now.Sub(m.samples[i].t) > motionWindow {
+		i++
+	}
+	m.samples = m.samples[i:]
+
+	motion := m.motion()
+	switch {
+	case motion > m.threshold:
+		m.underway = true
+		m.lastMotion = now
+	case motion > m.threshold/2:
+		if m.underway {
+			m.lastMotion = now
+		}
+	case m.underway && now.Sub(m.lastMotion) >= motionHold:
+		m.underway = false
+	}
+	return m.underway
+}
+
+// motion returns the largest range of any of the angles in the window.
+// The angles are taken relative to the oldest, so that a range across the
+// ±180 wrap isn't mistaken for a full turn.
+func (m *motionDetector) motion() float64 {
+	var res float64
+	for axis := 0; axis < 3; axis++ {
+		ref := m.samples[0].angles[axis]
+		min, max := 0.0, 0.0
+		for _, s := range m.samples[1:] {
+			d := math.Remainder(s.angles[axis]-ref, 360)
+			min = math.Min(min, d)
+			max = math.Max(max, d)
+		}
+		res = math.Max(res, max-min)
+	}
+	return res
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMotionDetector(t *testing.T) {
+	m := &motionDetector{threshold: 3}
+	t0 := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := t0
+	step := func(d time.Duration, heel float64) bool {
+		now = now.Add(d)
+		return m.observe(now, [3]float64{179.5, heel, -90})
+	}
+
+	// Still in the marina, with the xy angle jittering across ±180.
+	for i := 0; i < 20; i++ {
+		if step(time.Second, float64(i%2)) {
+			t.Fatal("underway when still")
+		}
+	}
+	m.samples[len(m.samples)-1].angles[0] = -179.5
+	if step(time.Second, 0) {
+		t.Fatal("underway from the ±180 wrap")
+	}
+
+	// Heeling over.
+	if !step(time.Second, 10) {
+		t.Fatal("not underway when heeling")
+	}
+	// Steady heel: the change drops out of the window but it takes the
+	// hold time to be still again.
+	for now.Sub(t0) < 4*time.Minute {
+		if !step(time.Second, 10) {
+			t.Fatal("still before the hold time")
+		}
+	}
+	for now.Sub(t0) < 10*time.Minute {
+		step(time.Second, 10)
+	}
+	if step(time.Second, 10) {
+		t.Error("still underway after the hold time")
+	}
+}
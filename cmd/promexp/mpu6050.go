@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/invensense"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "mpu6050",
+		section: true,
+		fields:  []string{"temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithMPU6050 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return append([]interface{}{c.Address, c.AccelRate, c.AccelRange, c.GyroRange, c.FIFO}, imuSettings(o)...)
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			settings := invensense.MPU6050Settings{
+				AccelRate:  conf.AccelRate,
+				AccelRange: conf.AccelRange,
+				GyroRange:  conf.GyroRange,
+				FIFO:       conf.FIFO,
+			}
+			mpu6050, err := invensense.NewMPU6050(bus, conf.address(invensense.MPU6050DefaultAddress), settings)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("mpu6050", mpu6050)
+			return registerIMU("mpu6050", startIMU(ctx, "mpu6050", mpu6050)), nil
+		},
+	})
+}
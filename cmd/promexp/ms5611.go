@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/ms5"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "ms5611",
+		section: true,
+		fields:  []string{"pressure", "temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithMS5611 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, o.SquallWindow, o.PressureHistoryFile, o.ForecastWind, o.WithLPS25H}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			wind, err := forecastWind()
+			if err != nil {
+				return nil, err
+			}
+			dev, err := ms5.NewMS5611(bus, conf.address(ms5.MS5611DefaultAddress))
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("ms5611", dev)
+			squall := NewSquallDetector(ctx, "ms5611", cli().SquallWindow, squallSampleInterval, cli().SquallThreshold, dev)
+			// The LPS25H makes the forecast when present, as it always
+			// has.
+			var forecaster func(p, delta float64)
+			if !cli().WithLPS25H {
+				forecaster = newForecaster("ms5611", wind)
+			}
+			return registerBarometer("ms5611", squall, forecaster), nil
+		},
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/ms5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "ms5837",
+		section: true,
+		fields:  []string{"pressure", "temperature", "depth"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithMS5837 || o.SeaTemperature == "ms5837" },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.MS5837Water, o.MS5837Surface}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			density, err := waterDensity(cli().MS5837Water)
+			if err != nil {
+				return nil, err
+			}
+			dev, err := ms5.NewMS5837(bus)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("ms5837", dev)
+			return registerMS5837(dev, density, cli().MS5837Surface), nil
+		},
+	})
+}
+
+// waterDensity returns the density in kg/m³ of "fresh" or "salt" water,
+// or as given.
+func waterDensity(s string) (float64, error) {
+	switch s {
+	case "fresh":
+		return ms5.FreshWater, nil
+	case "salt":
+		return ms5.SaltWater, nil
+	}
+	d, err := strconv.ParseFloat(s, 64)
+	if err != nil || d < 900 || d > 1100 {
+		return 0, fmt.Errorf("water density %q: must be fresh, salt or a density in kg/m³", s)
+	}
+	return d, nil
+}
+
+func registerMS5837(dev *ms5.MS5837, density, surface float64) func() {
+	press := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ms5837",
+		Name:      "pressure_mb",
+	})
+	temp := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ms5837",
+		Name:      "temperature_celsius",
+	})
+	depth := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "ms5837",
+		Name:      "depth_metres",
+		Help:      "Depth of water above the sensor.",
+	})
+
+	return func() {
+		if err := dev.Refresh(time.Second); err != nil {
+			log.Println("MS5837:", err)
+			health.failed("ms5837", err)
+			return
+		}
+
+		health.ok("ms5837")
+		conf := sensorConf("ms5837")
+		t := conf.correct("temperature", dev.Temperature())
+		press.Set(conf.correct("pressure", dev.Pressure()))
+		temp.Set(t)
+		depth.Set(conf.correct("depth", dev.Depth(surface, density)))
+		if cli().SeaTemperature == "ms5837" {
+			seaTemperature().Set(t)
+		}
+	}
+}
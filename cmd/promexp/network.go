@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Listen and destination addresses are host:port, where the host may be a
+// name, an IPv4 address or a bracketed IPv6 address, with a zone for link
+// local addresses ([fe80::1%wlan0]:10110). A port alone, or the host [::],
+// listens on all addresses of both families, which works the same on IPv4
+// only, dual stack and IPv6 only networks. Listeners and outputs take
+// several addresses where a single one is not enough, such as listening
+// on one address of each family.
+
+// checkHostPort returns an error for an address that is not host:port,
+// with a hint for the common mistake of an IPv6 address without brackets.
+func checkHostPort(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if strings.Count(addr, ":") > 1 && !strings.HasPrefix(addr, "[") {
+			return fmt.Errorf("address %s: IPv6 addresses must be in brackets, as in [::1]:10110", addr)
+		}
+		return fmt.Errorf("address %s: %w", addr, err)
+	}
+	return nil
+}
+
+// listenTCP listens on the address.
+func listenTCP(addr string) (net.Listener, error) {
+	if err := checkHostPort(addr); err != nil {
+		return nil, err
+	}
+	return net.Listen("tcp", addr)
+}
+
+// dialUDP returns a connection sending to the address.
+func dialUDP(addr string) (net.Conn, error) {
+	if err := checkHostPort(addr); err != nil {
+		return nil, err
+	}
+	return net.Dial("udp", addr)
+}
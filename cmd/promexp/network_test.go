@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCheckHostPort(t *testing.T) {
+	for _, addr := range []string{":9091", "[::]:9091", "[::1]:10110", "[fe80::1%wlan0]:10110", "192.168.1.10:2947", "boat.local:2947"} {
+		if err := checkHostPort(addr); err != nil {
+			t.Errorf("%s: unexpected error %v", addr, err)
+		}
+	}
+	if err := checkHostPort("fe80::1:10110"); err == nil || !strings.Contains(err.Error(), "brackets") {
+		t.Errorf("expected bracket hint, got %v", err)
+	}
+	if err := checkHostPort("localhost"); err == nil {
+		t.Error("expected error for missing port")
+	}
+}
+
+func TestListenDualStack(t *testing.T) {
+	l, err := listenTCP("[::1]:0")
+	if err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			conn.Write([]byte("$GPRMC\r\n"))
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 8)
+	if n, _ := conn.Read(buf); string(buf[:n]) != "$GPRMC\r\n" {
+		t.Errorf("unexpected data %q", buf[:n])
+	}
+}
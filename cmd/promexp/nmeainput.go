@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/nmea"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The NMEA input reads the other instruments on the boat, from serial
+// ports, a TCP multiplexer or UDP broadcasts, and exports their depth,
+// water temperature, wind, speed through the water, heading and position
+// next to the local sensors:
+//
+//   sensors_nmea_depth_metres
+//   sensors_nmea_water_temperature_celsius
+//   sensors_nmea_wind_angle_degrees{reference="apparent"|"true"}
+//   sensors_nmea_wind_speed_knots{reference="apparent"|"true"}
+//   sensors_nmea_speed_through_water_knots
+//   sensors_nmea_heading_degrees{reference="magnetic"|"true"}
+//   sensors_nmea_position_degrees{axis="latitude"|"longitude"}
+//   sensors_nmea_speed_over_ground_knots
+//   sensors_nmea_course_over_ground_degrees
+//
+// A serial port may be the same as the GPS input's, at the same baud rate.
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "nmea-input",
+		enabled: func(o options) bool { return len(o.NMEAInput) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.NMEAInput, o.NMEAInputBaudRate}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			inst := nmea.NewInstruments()
+			var srcs []*nmea.Source
+			for _, addr := range cli().NMEAInput {
+				src, err := nmea.Open(ctx, addr, cli().NMEAInputBaudRate, inst.Line)
+				if err != nil {
+					return nil, err
+				}
+				srcs = append(srcs, src)
+			}
+			return registerNMEAInput(inst, srcs), nil
+		},
+	})
+}
+
+func registerNMEAInput(inst *nmea.Instruments, srcs []*nmea.Source) func() {
+	instrument := func(name string) *gauge {
+		return newGauge(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: "nmea",
+			Name:      name,
+		})
+	}
+	instrumentVec := func(name, label string) *gaugeVec {
+		return newGaugeVec(prometheus.GaugeOpts{
+			Namespace: "sensors",
+			Subsystem: "nmea",
+			Name:      name,
+		}, []string{label})
+	}
+	depth := instrument("depth_metres")
+	water := instrument("water_temperature_celsius")
+	windAngle := instrumentVec("wind_angle_degrees", "reference")
+	windSpeed := instrumentVec("wind_speed_knots", "reference")
+	stw := instrument("speed_through_water_knots")
+	heading := instrumentVec("heading_degrees", "reference")
+	pos := instrumentVec("position_degrees", "axis")
+	sog := instrument("speed_over_ground_knots")
+	cog := instrument("course_over_ground_degrees")
+
+	sentences := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "nmea",
+		Name:      "input_sentences_total",
+		Help:      "Valid NMEA sentences received on the NMEA input, per talker.",
+	}, []string{"talker"})
+	malformed := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "nmea",
+		Name:      "input_malformed_sentences_total",
+		Help:      "NMEA sentences dropped as malformed on the NMEA input, per talker and reason.",
+	}, []string{"talker", "reason"})
+
+	links := newLinkMetrics()
+
+	// Values are only set when new data has been received, so that they
+	// expire if the instrument goes silent.
+	var last nmea.Data
+	updated := func(cur, prev nmea.Reading) bool {
+		return !cur.Time.IsZero() && cur.Time != prev.Time
+	}
+	reconnects := make([]uint64, len(srcs))
+	prev := make(map[string]nmea.TalkerStats)
+	return func() {
+		for i, src := range srcs {
+			r := src.Reconnects()
+			links.set("nmea-input", src.Name(), src.Connected(), r-reconnects[i])
+			reconnects[i] = r
+		}
+
+		for talker, st := range inst.Stats() {
+			p := prev[talker]
+			sentences.WithLabelValues(talker).Add(float64(st.Sentences - p.Sentences))
+			malformed.WithLabelValues(talker, "checksum").Add(float64(st.Checksum - p.Checksum))
+			malformed.WithLabelValues(talker, "length").Add(float64(st.Length - p.Length))
+			malformed.WithLabelValues(talker, "fields").Add(float64(st.Fields - p.Fields))
+			prev[talker] = st
+		}
+
+		d := inst.Data()
+		if updated(d.Depth, last.Depth) {
+			depth.Set(d.Depth.Value)
+		}
+		if updated(d.WaterTemperature, last.WaterTemperature) {
+			water.Set(d.WaterTemperature.Value)
+			if cli().SeaTemperature == "nmea" {
+				seaTemperature().Set(d.WaterTemperature.Value)
+			}
+		}
+		if updated(d.ApparentWindAngle, last.ApparentWindAngle) {
+			windAngle.WithLabelValues("apparent").Set(d.ApparentWindAngle.Value)
+			windSpeed.WithLabelValues("apparent").Set(d.ApparentWindSpeed.Value)
+		}
+		if updated(d.TrueWindAngle, last.TrueWindAngle) {
+			windAngle.WithLabelValues("true").Set(d.TrueWindAngle.Value)
+			windSpeed.WithLabelValues("true").Set(d.TrueWindSpeed.Value)
+		}
+		if updated(d.SpeedThroughWater, last.SpeedThroughWater) {
+			stw.Set(d.SpeedThroughWater.Value)
+		}
+		if updated(d.MagneticHeading, last.MagneticHeading) {
+			heading.WithLabelValues("magnetic").Set(d.MagneticHeading.Value)
+		}
+		if updated(d.TrueHeading, last.TrueHeading) {
+			heading.WithLabelValues("true").Set(d.TrueHeading.Value)
+		}
+		if updated(d.Latitude, last.Latitude) {
+			pos.WithLabelValues("latitude").Set(d.Latitude.Value)
+			pos.WithLabelValues("longitude").Set(d.Longitude.Value)
+		}
+		if updated(d.SpeedOverGround, last.SpeedOverGround) {
+			sog.Set(d.SpeedOverGround.Value)
+		}
+		if updated(d.CourseOverGround, last.CourseOverGround) {
+			cog.Set(d.CourseOverGround.Value)
+		}
+		last = d
+	}
+}
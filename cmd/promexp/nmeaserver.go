@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The NMEA server forwards sentences from the GPS input and the LSM9DS1
+// heading to TCP clients (such as a chart plotter or OpenCPN) and
+// optionally to UDP addresses, doing the job of a simple NMEA multiplexer. Sentences can be limited to
+// certain types and rate limited per type.
+
+func init() {
+	registerSensor(sensorDef{
+		// Not a sensor, but the outputs for the sentences of the GPS
+		// input and the attitude output of the LSM9DS1.
+		name:    "nmea",
+		enabled: func(o options) bool { return len(o.NMEAListen) > 0 || len(o.NMEAUDP) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.NMEAListen, o.NMEAUDP, o.NMEASentences, o.NMEARateLimit}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			filter := newNMEAFilter(cli().NMEASentences, cli().NMEARateLimit)
+			srv, err := startNMEAServer(ctx, cli().NMEAListen, cli().NMEAUDP, filter)
+			if err != nil {
+				return nil, err
+			}
+			setNMEAOutput(ctx, srv)
+			return registerNMEAServer(srv), nil
+		},
+	})
+}
+
+const nmeaClientBuffer = 64
+
+// A UDP destination is considered down for this long after a write error.
+// Refusals are reported by the kernel on every other write, so a single
+// successful write says little.
+const nmeaUDPErrorHold = time.Minute
+
+type nmeaFilter struct {
+	sentences map[string]bool // sentence types (e.g. "RMC") or addresses (e.g. "GPRMC"); empty for all
+	interval  time.Duration
+	last      map[string]time.Time
+}
+
+func newNMEAFilter(sentences []string, interval time.Duration) *nmeaFilter {
+	f := &nmeaFilter{
+		sentences: make(map[string]bool),
+		interval:  interval,
+		last:      make(map[string]time.Time),
+	}
+	for _, s := range sentences {
+		f.sentences[strings.ToUpper(s)] = true
+	}
+	return f
+}
+
+// pass returns true if the sentence should be forwarded.
+func (f *nmeaFilter) pass(line string, now time.Time) bool {
+	addr := line[1:]
+	if i := strings.IndexByte(addr, ','); i >= 0 {
+		addr = addr[:i]
+	}
+	if len(addr) < 3 {
+		return false
+	}
+	kind := addr[len(addr)-3:]
+	if len(f.sentences) > 0 && !f.sentences[kind] && !f.sentences[addr] {
+		return false
+	}
+	if f.interval > 0 {
+		if now.Sub(f.last[addr]) < f.interval {
+			return false
+		}
+		f.last[addr] = now
+	}
+	return true
+}
+
+type nmeaServer struct {
+	mut       sync.Mutex
+	filter    *nmeaFilter
+	clients   map[chan string]struct{}
+	udp       []net.Conn
+	udpAddrs  []string
+	udpFailed []time.Time // the last write error, per UDP destination
+}
+
+func startNMEAServer(ctx context.Context, listen, udpAddrs []string, filter *nmeaFilter) (*nmeaServer, error) {
+	s := &nmeaServer{filter: filter, clients: make(map[chan string]struct{})}
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, conn := range s.udp {
+			conn.Close()
+		}
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, addr := range udpAddrs {
+		conn, err := dialUDP(addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		s.udp = append(s.udp, conn)
+		s.udpAddrs = append(s.udpAddrs, addr)
+		s.udpFailed = append(s.udpFailed, time.Time{})
+	}
+
+	for _, addr := range listen {
+		l, err := listenTCP(addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	for _, l := range listeners {
+		go s.accept(ctx, l)
+	}
+	onDone(ctx, closeAll)
+	return s, nil
+}
+
+func (s *nmeaServer) accept(ctx context.Context, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("NMEA server:", err)
+			}
+			return
+		}
+		go s.serve(ctx, conn)
+	}
+}
+
+func (s *nmeaServer) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	lines := make(chan string, nmeaClientBuffer)
+	s.mut.Lock()
+	s.clients[lines] = struct{}{}
+	s.mut.Unlock()
+	defer func() {
+		s.mut.Lock()
+		delete(s.clients, lines)
+		s.mut.Unlock()
+	}()
+
+	for {
+		select {
+		case line := <-lines:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if _, err := conn.Write([]byte(line + "\r\n")); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// forward sends the sentence to all clients, if it passes the filter.
+// Clients that do not keep up miss sentences.
+func (s *nmeaServer) forward(line string) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if !s.filter.pass(line, time.Now()) {
+		return
+	}
+	for c := range s.clients {
+		select {
+		case c <- line:
+		default:
+		}
+	}
+	now := time.Now()
+	for i, conn := range s.udp {
+		// A refused or unreachable destination shows as an error
+		// on a later write.
+		if _, err := conn.Write([]byte(line + "\r\n")); err != nil {
+			if s.udpUp(i, now) {
+				log.Printf("NMEA UDP output %s: %v", s.udpAddrs[i], err)
+			}
+			s.udpFailed[i] = now
+		}
+	}
+}
+
+// udpUp returns whether the UDP destination has had no write errors for
+// a while. Must be called with the lock held.
+func (s *nmeaServer) udpUp(i int, now time.Time) bool {
+	return now.Sub(s.udpFailed[i]) >= nmeaUDPErrorHold
+}
+
+func registerNMEAServer(s *nmeaServer) func() {
+	clients := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "nmea",
+		Name:      "clients",
+		Help:      "TCP clients connected to the NMEA server.",
+	})
+	links := newLinkMetrics()
+
+	return func() {
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		clients.Set(float64(len(s.clients)))
+		now := time.Now()
+		for i, addr := range s.udpAddrs {
+			links.set("nmea-udp", addr, s.udpUp(i, now), 0)
+		}
+	}
+}
+
+// The running NMEA server, if any, shared by the sources of sentences.
+var (
+	nmeaOutputMut sync.Mutex
+	nmeaOutput    *nmeaServer
+)
+
+// setNMEAOutput makes the server the destination of nmeaForward until the
+// context is done.
+func setNMEAOutput(ctx context.Context, s *nmeaServer) {
+	nmeaOutputMut.Lock()
+	nmeaOutput = s
+	nmeaOutputMut.Unlock()
+	go func() {
+		<-ctx.Done()
+		nmeaOutputMut.Lock()
+		if nmeaOutput == s {
+			nmeaOutput = nil
+		}
+		nmeaOutputMut.Unlock()
+	}()
+}
+
+// nmeaForward sends the sentence to the NMEA server, if one is running.
+func nmeaForward(line string) {
+	nmeaOutputMut.Lock()
+	s := nmeaOutput
+	nmeaOutputMut.Unlock()
+	if s != nil {
+		s.forward(line)
+	}
+}
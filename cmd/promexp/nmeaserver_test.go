@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNMEAFilter(t *testing.T) {
+	now := time.Now()
+
+	f := newNMEAFilter([]string{"rmc", "GPGGA"}, 0)
+	for line, exp := range map[string]bool{
+		"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A": true,
+		"$GNRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*74": true,
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47":    true,
+		"$GNGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*59":    false,
+		"$IIMTW,15.2,C*1F": false,
+	} {
+		if f.pass(line, now) != exp {
+			t.Errorf("%s: expected %v", line, exp)
+		}
+	}
+
+	f = newNMEAFilter(nil, time.Second)
+	const rmc = "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A"
+	if !f.pass(rmc, now) || !f.pass("$IIMTW,15.2,C*1F", now) {
+		t.Error("expected first sentences to pass")
+	}
+	if f.pass(rmc, now.Add(500*time.Millisecond)) {
+		t.Error("expected rate limit")
+	}
+	if !f.pass(rmc, now.Add(time.Second)) {
+		t.Error("expected sentence to pass after the interval")
+	}
+}
+
+func TestNMEAUDPLinkState(t *testing.T) {
+	// A destination that is listening, and one that refuses.
+	up, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	down, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	downAddr := down.LocalAddr().String()
+	down.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := startNMEAServer(ctx, nil, []string{up.LocalAddr().String(), downAddr}, newNMEAFilter(nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		// The refusal is reported on the write after the first.
+		s.forward("$IIMTW,15.2,C*1F")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	buf := make([]byte, 64)
+	up.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := up.ReadFrom(buf); err != nil || string(buf[:n]) != "$IIMTW,15.2,C*1F\r\n" {
+		t.Errorf("unexpected datagram %q, %v", buf[:n], err)
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if now := time.Now(); !s.udpUp(0, now) || s.udpUp(1, now) {
+		t.Errorf("unexpected link errors %v", s.udpFailed)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Raised alarms are announced in the log and, with --notify-url, posted as
+// plain text to a push service such as ntfy. The text can be given per
+// alarm name, or per kind of alarm, as a Go template in the configuration
+// file; the default message is used otherwise:
+//
+//   boat-name: Amalia
+//   notifications:
+//     anchor: "{{.Boat}}: anchor dragging! {{length .Value}} from the anchor at {{.Time.Format \"15:04\"}}"
+//     gas: "{{.Boat}}: GAS in the {{.Alarm}}. Ventilate, no sparks."
+//     mob: "{{.Boat}}: MAN OVERBOARD {{if .HasPosition}}at {{position .Lat .Lon}}{{end}}"
+//     default: "{{.Boat}}: {{.Message}}"
+//
+// The template data is a notice. Values are in metres, degrees Celsius or
+// millibar; the length, temperature and pressure functions show them in
+// the selected units.
+
+// A notice is an alarm as announced.
+type notice struct {
+	Boat        string // --boat-name
+	Alarm       string // the name of the alarm, as listed at /alarms
+	Kind        string // gas, flame, heat, anchor, mob or alr
+	Message     string // the default text
+	Value       float64
+	Lat, Lon    float64
+	HasPosition bool
+	Time        time.Time
+}
+
+const notifyTimeout = 10 * time.Second
+
+var noticeFuncs = template.FuncMap{
+	"length": func(m float64) string {
+		if cli().LengthUnit == "feet" {
+			return fmt.Sprintf("%.0f ft", m/0.3048)
+		}
+		return fmt.Sprintf("%.0f m", m)
+	},
+	"temperature": func(c float64) string {
+		if cli().TemperatureUnit == "fahrenheit" {
+			return fmt.Sprintf("%.0f °F", c*9/5+32)
+		}
+		return fmt.Sprintf("%.0f °C", c)
+	},
+	"pressure": func(mb float64) string {
+		if cli().PressureUnit == "inhg" {
+			return fmt.Sprintf("%.2f inHg", mb*0.0295300)
+		}
+		return fmt.Sprintf("%.0f mb", mb)
+	},
+	"position": formatPosition,
+}
+
+// formatPosition returns the position in degrees and decimal minutes, as
+// on a chart plotter.
+func formatPosition(lat, lon float64) string {
+	dm := func(v float64, digits int, pos, neg string) string {
+		hemi := pos
+		if v < 0 {
+			hemi, v = neg, -v
+		}
+		deg := math.Floor(v)
+		return fmt.Sprintf("%0*.0f°%06.3f'%s", digits, deg, (v-deg)*60, hemi)
+	}
+	return dm(lat, 2, "N", "S") + " " + dm(lon, 3, "E", "W")
+}
+
+// raiseAlarm raises the latched alarm of the notice and announces it, if
+// it was not already raised.
+func raiseAlarm(n notice) {
+	if !alarms.raise(n.Alarm) {
+		return
+	}
+	o := cli()
+	n.Boat = o.BoatName
+	n.Time = time.Now()
+	text := renderNotice(sections().Notifications, n)
+	log.Printf("ALARM: %s", text)
+	if o.NotifyURL != "" {
+		go postNotice(o.NotifyURL, text)
+	}
+}
+
+// renderNotice returns the text of the notice from the template for its
+// alarm name, kind or "default", in that order, or the default message if
+// there is no template or it fails.
+func renderNotice(tpls map[string]string, n notice) string {
+	for _, key := range []string{n.Alarm, n.Kind, "default"} {
+		src, ok := tpls[key]
+		if !ok {
+			continue
+		}
+		tpl, err := parseNoticeTemplate(key, src)
+		if err == nil {
+			var buf bytes.Buffer
+			if err = tpl.Execute(&buf, n); err == nil {
+				return strings.TrimSpace(buf.String())
+			}
+		}
+		log.Printf("Notification template %s: %v", key, err)
+		break
+	}
+	return n.Message
+}
+
+func parseNoticeTemplate(name, src string) (*template.Template, error) {
+	return template.New(name).Funcs(noticeFuncs).Parse(src)
+}
+
+func postNotice(url, text string) {
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "text/plain; charset=utf-8", strings.NewReader(text))
+	if err != nil {
+		log.Println("Notification:", err)
+		return
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Println("Notification:", resp.Status)
+	}
+}
+
+func validateNotifications(tpls map[string]string) error {
+	for name, src := range tpls {
+		tpl, err := parseNoticeTemplate(name, src)
+		if err != nil {
+			return fmt.Errorf("notification %s: %w", name, err)
+		}
+		if err := tpl.Execute(ioutil.Discard, notice{}); err != nil {
+			return fmt.Errorf("notification %s: %w", name, err)
+		}
+	}
+	return nil
+}
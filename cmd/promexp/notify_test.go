@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderNotice(t *testing.T) {
+	withOptions(t, func(o *options) {
+		o.LengthUnit = "feet"
+	})
+
+	tpls := map[string]string{
+		"anchor":  "{{.Boat}}: anchor dragging, {{length .Value}} out at {{position .Lat .Lon}}",
+		"gas":     "{{.Boat}}: GAS in the {{.Alarm}}",
+		"default": "{{.Boat}}: {{.Message}}",
+	}
+	if err := validateNotifications(tpls); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		n   notice
+		exp string
+	}{
+		{notice{Boat: "Amalia", Alarm: "anchor", Kind: "anchor", Value: 40, Lat: 57.693333, Lon: -11.85, HasPosition: true},
+			"Amalia: anchor dragging, 131 ft out at 57°41.600'N 011°51.000'W"},
+		{notice{Boat: "Amalia", Alarm: "galley", Kind: "gas"}, "Amalia: GAS in the galley"},
+		{notice{Boat: "Amalia", Alarm: "alr-ii-012", Kind: "alr", Message: "II alarm 012: HIGH TEMP"}, "Amalia: II alarm 012: HIGH TEMP"},
+	}
+	for _, tc := range cases {
+		if res := renderNotice(tpls, tc.n); res != tc.exp {
+			t.Errorf("got %q, expected %q", res, tc.exp)
+		}
+	}
+
+	if res := renderNotice(nil, notice{Message: "flame detector engine triggered"}); res != "flame detector engine triggered" {
+		t.Errorf("expected the default message, got %q", res)
+	}
+
+	for _, src := range []string{"{{.Boat", "{{.Speed}}", "{{knots .Value}}"} {
+		if err := validateNotifications(map[string]string{"default": src}); err == nil {
+			t.Errorf("expected error for %q", src)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/omini"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "omini",
+		section: true,
+		fields:  []string{"a", "b", "c"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithOmini },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, o.CrankingChannel}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			dev := omini.New(bus, conf.address(omini.DefaultAddress))
+			meta.setDevices("omini", dev)
+			update := registerOmini(dev)
+			if cli().CrankingChannel == "" {
+				return update, nil
+			}
+			crank, err := newCrankMonitor(ctx, dev, cli().CrankingChannel)
+			if err != nil {
+				return nil, err
+			}
+			cranking := registerCranking(crank)
+			return func() {
+				update()
+				cranking()
+			}, nil
+		},
+	})
+}
+
+var ominiHighBitModes = map[string]omini.SpuriousBitMode{
+	"retry": omini.RetrySpuriousBit,
+	"mask":  omini.MaskSpuriousBit,
+	"keep":  omini.KeepSpuriousBit,
+}
+
+func registerOmini(dev *omini.Omini) func() {
+	vv := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "voltage",
+	}, []string{"channel"})
+
+	soc := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "state_of_charge_percent",
+		Help:      "State of charge estimated from the voltage, by the battery chemistry or curve configured for the channel.",
+	}, []string{"channel"})
+
+	reads := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "reads_total",
+	}, nil)
+	highBits := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "high_bit_reads_total",
+		Help:      "Reads with the spurious high bit set, per register.",
+	}, []string{"register"})
+	discarded := newCounterVec(prometheus.CounterOpts{
+		Namespace: "sensors",
+		Subsystem: "omini",
+		Name:      "discarded_total",
+		Help:      "Readings discarded as outliers by the median filter.",
+	}, []string{"channel"})
+
+	logLine := ""
+	var prev omini.Stats
+	charger := newChargerStages()
+
+	return func() {
+		conf := sensorConf("omini")
+		var gain, offset [3]float64
+		for i, ch := range []string{"a", "b", "c"} {
+			gain[i] = conf.gain(ch)
+			offset[i] = conf.Offsets[ch]
+		}
+		dev.SetCalibration(gain, offset)
+		dev.SetSpuriousBitMode(ominiHighBitModes[cli().OminiHighBit], cli().OminiDiagnostics)
+
+		defer func() {
+			stats := dev.Stats()
+			reads.WithLabelValues().Add(float64(stats.Reads - prev.Reads))
+			for i := range stats.HighBits {
+				highBits.WithLabelValues(strconv.Itoa(i + 1)).Add(float64(stats.HighBits[i] - prev.HighBits[i]))
+			}
+			for i, ch := range []string{"a", "b", "c"} {
+				discarded.WithLabelValues(ch).Add(float64(stats.Discarded[i] - prev.Discarded[i]))
+			}
+			prev = stats
+		}()
+
+		a, b, c, err := dev.Voltages()
+		if err != nil {
+			log.Println("Omini:", err)
+			health.failed("omini", err)
+			return
+		}
+		health.ok("omini")
+		var vals []string
+		for i, v := range []float64{a, b, c} {
+			if v > 1 {
+				ch := string(rune('a' + i))
+				pct := batteryCurve(ch).val(v)
+				soc.WithLabelValues(ch).Set(pct)
+				vals = append(vals, fmt.Sprintf("%s V (%.0f %%)", cli().DisplayPrecision.format(v), pct))
+			}
+		}
+		if len(vals) > 0 {
+			newLogLine := fmt.Sprintf("Omini: %s", strings.Join(vals, ", "))
+			if newLogLine != logLine {
+				logLine = newLogLine
+				log.Println(logLine)
+			}
+		}
+
+		vv.WithLabelValues("a").Set(a)
+		vv.WithLabelValues("b").Set(b)
+		vv.WithLabelValues("c").Set(c)
+
+		now := time.Now()
+		for ch, v := range map[string]float64{"a": a, "b": b, "c": c} {
+			if v > 1 {
+				charger.observe(ch, v, now)
+			}
+		}
+	}
+}
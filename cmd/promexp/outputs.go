@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/calmh/boatpi/gpio"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outputs, relays for pumps, fans and lights, are named in their own
+// section, on the Pi's GPIO or on an expander like the inputs (see
+// inputs.go):
+//
+//   outputs:
+//     bilge-pump:
+//       pin: 5
+//       active-low: true # most relay boards
+//     anchor-light:
+//       expander: mcp23017
+//       pin: 0
+//
+// They start off, also when the section changes, and are switched by a
+// POST to /api/v1/outputs with the name and the state, on or off; a GET
+// lists them. Switching requires authentication to be set up (see
+// auth.go), as it does something on board. The state is exported as
+// sensors_output_on{output="<name>"}.
+
+type outputConfig struct {
+	Expander  string `yaml:"expander"`
+	Address   int    `yaml:"address"`
+	Pin       int    `yaml:"pin"`
+	ActiveLow bool   `yaml:"active-low"`
+}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "outputs",
+		enabled: func(o options) bool { return len(sections().Outputs) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.GPIOChip, sections().Outputs}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initOutputs(ctx, bus, sections().Outputs)
+		},
+	})
+}
+
+func sortedOutputs(outs map[string]outputConfig) []string {
+	names := make([]string, 0, len(outs))
+	for name := range outs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateOutputs checks the outputs, and that their pins are not also
+// used for inputs.
+func validateOutputs(outs map[string]outputConfig, ins map[string]inputConfig) error {
+	pins := make(map[inputConfig]string)
+	for name, in := range ins {
+		pins[pinKey(in.Expander, in.Address, in.Pin)] = "input " + name
+	}
+	for _, name := range sortedOutputs(outs) {
+		out := outs[name]
+		if out.Expander == "" && out.Address != 0 {
+			return fmt.Errorf("output %s: address without an expander", name)
+		}
+		if out.Expander != "" {
+			if err := validateExpanderPin(out.Expander, out.Address, out.Pin); err != nil {
+				return fmt.Errorf("output %s: %w", name, err)
+			}
+		}
+		key := pinKey(out.Expander, out.Address, out.Pin)
+		if other, ok := pins[key]; ok {
+			return fmt.Errorf("output %s: pin %d already used by %s", name, out.Pin, other)
+		}
+		pins[key] = "output " + name
+	}
+	return nil
+}
+
+type output struct {
+	name  string
+	set   func(on bool) error
+	close func() error
+	on    bool
+}
+
+// outputSet holds the outputs currently set up, for the HTTP handler.
+type outputSet struct {
+	mut     sync.Mutex
+	outputs map[string]*output
+}
+
+var switchable = &outputSet{outputs: make(map[string]*output)}
+
+var errNoSuchOutput = errors.New("no such output")
+
+// replace sets the outputs, replacing any previous ones.
+func (s *outputSet) replace(outs []*output) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.outputs = make(map[string]*output, len(outs))
+	for _, out := range outs {
+		s.outputs[out.name] = out
+	}
+}
+
+// release switches off and closes the outputs, and forgets them unless
+// they have been replaced already.
+func (s *outputSet) release(outs []*output) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, out := range outs {
+		if err := out.set(false); err != nil {
+			log.Printf("Output %s: switch off: %v", out.name, err)
+		}
+		if out.close != nil {
+			out.close()
+		}
+		if s.outputs[out.name] == out {
+			delete(s.outputs, out.name)
+		}
+	}
+}
+
+func (s *outputSet) set(name string, on bool) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	out, ok := s.outputs[name]
+	if !ok {
+		return errNoSuchOutput
+	}
+	if err := out.set(on); err != nil {
+		return err
+	}
+	out.on = on
+	return nil
+}
+
+func (s *outputSet) list() map[string]bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	res := make(map[string]bool, len(s.outputs))
+	for name, out := range s.outputs {
+		res[name] = out.on
+	}
+	return res
+}
+
+func initOutputs(ctx context.Context, bus *i2c.Bus, outs map[string]outputConfig) (func(), error) {
+	var list []*output
+	var devs []sensor.Sensor
+	closeAll := func() {
+		for _, out := range list {
+			if out.close != nil {
+				out.close()
+			}
+		}
+	}
+	for _, name := range sortedOutputs(outs) {
+		conf := outs[name]
+		if conf.Expander == "" {
+			line, err := gpio.RequestOutput(cli().GPIOChip, conf.Pin, conf.ActiveLow)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("output %s: %w", name, err)
+			}
+			list = append(list, &output{name: name, set: line.SetValue, close: line.Close})
+			continue
+		}
+
+		exp, err := sharedExpander(bus, conf.Expander, conf.Address)
+		if err == nil {
+			err = exp.ConfigureOutput(conf.Pin, conf.ActiveLow)
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("output %s: %w", name, err)
+		}
+		devs = appendDevice(devs, exp)
+		pin, activeLow := conf.Pin, conf.ActiveLow
+		list = append(list, &output{name: name, set: func(on bool) error { return exp.SetLevel(pin, on != activeLow) }})
+	}
+
+	switchable.replace(list)
+	meta.setDevices("outputs", devs...)
+	onDone(ctx, func() { switchable.release(list) })
+	return registerOutputs(), nil
+}
+
+func registerOutputs() func() {
+	on := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "output",
+		Name:      "on",
+		Help:      "Output state, 1 when switched on.",
+	}, []string{"output"})
+
+	return func() {
+		for name, v := range switchable.list() {
+			if v {
+				on.WithLabelValues(name).Set(1)
+			} else {
+				on.WithLabelValues(name).Set(0)
+			}
+		}
+	}
+}
+
+// handleOutputs lists the outputs on GET and switches the output given by
+// the "name" parameter to the "state" parameter, on or off, on POST.
+func handleOutputs(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		user, ok := authenticatedUser(req)
+		if !ok {
+			http.Error(w, "switching outputs requires authentication to be set up", http.StatusForbidden)
+			return
+		}
+		var on bool
+		switch state := req.FormValue("state"); state {
+		case "on":
+			on = true
+		case "off":
+		default:
+			http.Error(w, fmt.Sprintf("invalid state %q (valid: on, off)", state), http.StatusBadRequest)
+			return
+		}
+		name := req.FormValue("name")
+		if err := switchable.set(name, on); err == errNoSuchOutput {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Output %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Output %s switched %s by %s", name, req.FormValue("state"), user)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(switchable.list())
+}
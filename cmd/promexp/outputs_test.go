@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandleOutputs(t *testing.T) {
+	var pump bool
+	out := &output{name: "pump", set: func(on bool) error { pump = on; return nil }}
+	switchable.replace([]*output{out})
+	defer switchable.release([]*output{out})
+
+	post := func(form url.Values, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/outputs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handleOutputs(rec, req)
+		return rec
+	}
+
+	// Open access is not enough.
+	if rec := post(url.Values{"name": {"pump"}, "state": {"on"}}, ""); rec.Code != http.StatusForbidden || pump {
+		t.Errorf("switched without authentication set up: %d", rec.Code)
+	}
+
+	defer setAuth(options{})
+	if err := setAuth(options{AuthTokens: []string{"skipper:s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := post(url.Values{"name": {"pump"}, "state": {"on"}}, "s3cret"); rec.Code != http.StatusOK || !pump {
+		t.Errorf("not switched on: %d %s", rec.Code, rec.Body)
+	} else if body := rec.Body.String(); !strings.Contains(body, `"pump":true`) {
+		t.Errorf("unexpected list %s", body)
+	}
+	if rec := post(url.Values{"name": {"pump"}, "state": {"toggle"}}, "s3cret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid state: %d", rec.Code)
+	}
+	if rec := post(url.Values{"name": {"fan"}, "state": {"on"}}, "s3cret"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown output: %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	handleOutputs(rec, httptest.NewRequest(http.MethodGet, "/api/v1/outputs", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `"pump":true`) {
+		t.Errorf("unexpected list %s", body)
+	}
+
+	switchable.release([]*output{out})
+	if pump {
+		t.Error("expected the output switched off on release")
+	}
+}
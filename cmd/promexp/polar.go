@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Performance polars: the boat speed for each true wind speed and angle,
+// accumulated from the sailing done. The wind and boat speed come from
+// expressions over the other metrics, typically virtual sensors computing
+// the true wind from an instrument feed. The boat speed is binned by true
+// wind speed and by the true wind angle off the bow, either side, and the
+// table is saved to disk so that it grows across restarts. It is
+// available as /api/v1/polar.
+
+const (
+	polarWindSpeedStep = 2.0  // knots
+	polarWindAngleStep = 10.0 // degrees
+	polarMinBoatSpeed  = 0.5  // knots; slower is drifting or moored
+	polarSaveInterval  = 10 * time.Minute
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "polar",
+		order:   2,
+		enabled: func(o options) bool { return o.PolarWindSpeed != "" && o.PolarWindAngle != "" },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.PolarWindSpeed, o.PolarWindAngle, o.PolarBoatSpeed, o.PolarFile}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initPolar(ctx, cli().PolarWindSpeed, cli().PolarWindAngle, cli().PolarBoatSpeed, cli().PolarFile)
+		},
+	})
+}
+
+type polarCell struct {
+	WindSpeed float64 `json:"windSpeed"` // lower edge of the bin, knots
+	WindAngle float64 `json:"windAngle"` // lower edge of the bin, degrees
+	Samples   int     `json:"samples"`
+	Mean      float64 `json:"mean"` // boat speed, knots
+	Max       float64 `json:"max"`
+}
+
+type polarKey struct {
+	speed, angle int // bin indexes
+}
+
+type polarTable struct {
+	mut   sync.Mutex
+	cells map[polarKey]*polarCell
+}
+
+// The table being accumulated, if any, for the API.
+var (
+	polarMut     sync.Mutex
+	currentPolar *polarTable
+)
+
+func newPolarTable() *polarTable {
+	return &polarTable{cells: make(map[polarKey]*polarCell)}
+}
+
+// observe adds the boat speed at the true wind speed and angle.
+func (p *polarTable) observe(tws, twa, bsp float64) {
+	if bsp < polarMinBoatSpeed || tws < 0 || math.IsNaN(tws+twa+bsp) {
+		return
+	}
+	twa = math.Abs(math.Remainder(twa, 360))
+	key := polarKey{int(tws / polarWindSpeedStep), int(twa / polarWindAngleStep)}
+	if key.angle >= int(180/polarWindAngleStep) {
+		key.angle = int(180/polarWindAngleStep) - 1
+	}
+
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	c, ok := p.cells[key]
+	if !ok {
+		c = &polarCell{WindSpeed: float64(key.speed) * polarWindSpeedStep, WindAngle: float64(key.angle) * polarWindAngleStep}
+		p.cells[key] = c
+	}
+	c.Samples++
+	c.Mean += (bsp - c.Mean) / float64(c.Samples)
+	c.Max = math.Max(c.Max, bsp)
+}
+
+// list returns the cells ordered by wind speed and angle.
+func (p *polarTable) list() []polarCell {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	res := make([]polarCell, 0, len(p.cells))
+	for _, c := range p.cells {
+		res = append(res, *c)
+	}
+	sort.Slice(res, func(a, b int) bool {
+		if res[a].WindSpeed != res[b].WindSpeed {
+			return res[a].WindSpeed < res[b].WindSpeed
+		}
+		return res[a].WindAngle < res[b].WindAngle
+	})
+	return res
+}
+
+func loadPolar(file string) *polarTable {
+	p := newPolarTable()
+	fd, err := os.Open(file)
+	if err != nil {
+		return p
+	}
+	defer fd.Close()
+
+	var cells []polarCell
+	if err := json.NewDecoder(fd).Decode(&cells); err != nil {
+		log.Println("Load polar:", err)
+		return p
+	}
+	for _, c := range cells {
+		c := c
+		p.cells[polarKey{int(c.WindSpeed / polarWindSpeedStep), int(c.WindAngle / polarWindAngleStep)}] = &c
+	}
+	return p
+}
+
+func (p *polarTable) save(file string) error {
+	tmp := file + ".tmp"
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fd).Encode(p.list()); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func initPolar(ctx context.Context, windSpeed, windAngle, boatSpeed, file string) (func(), error) {
+	var exprs [3]exprNode
+	for i, src := range []string{windSpeed, windAngle, boatSpeed} {
+		expr, _, err := parseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("polar expression %q: %w", src, err)
+		}
+		exprs[i] = expr
+	}
+
+	table := loadPolar(file)
+	polarMut.Lock()
+	currentPolar = table
+	polarMut.Unlock()
+	onDone(ctx, func() {
+		polarMut.Lock()
+		if currentPolar == table {
+			currentPolar = nil
+		}
+		polarMut.Unlock()
+		if err := table.save(file); err != nil {
+			log.Println("Save polar:", err)
+		}
+	})
+
+	saved := time.Now()
+	var lastErr error
+	return func() {
+		mfs, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			log.Println("Polar: gather metrics:", err)
+			return
+		}
+		lookup := gatheredLookup(mfs)
+		var vals [3]float64
+		for i, expr := range exprs {
+			if vals[i], err = expr(lookup); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			// The wind or speed may well be missing for a while;
+			// say so once.
+			if lastErr == nil || lastErr.Error() != err.Error() {
+				log.Println("Polar:", err)
+			}
+			lastErr = err
+			return
+		}
+		lastErr = nil
+		table.observe(vals[0], vals[1], vals[2])
+
+		if time.Since(saved) >= polarSaveInterval {
+			if err := table.save(file); err != nil {
+				log.Println("Save polar:", err)
+			}
+			saved = time.Now()
+		}
+	}, nil
+}
+
+func handlePolar(w http.ResponseWriter, req *http.Request) {
+	polarMut.Lock()
+	table := currentPolar
+	polarMut.Unlock()
+	if table == nil {
+		http.Error(w, "polars are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		WindSpeedStep float64     `json:"windSpeedStep"`
+		WindAngleStep float64     `json:"windAngleStep"`
+		Cells         []polarCell `json:"cells"`
+	}{polarWindSpeedStep, polarWindAngleStep, table.list()})
+}
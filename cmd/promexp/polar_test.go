@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPolarTable(t *testing.T) {
+	p := newPolarTable()
+	p.observe(11, 45, 6)
+	p.observe(11.5, -48, 7) // the other tack, same bin
+	p.observe(11, 45, 0.2)  // drifting, ignored
+	p.observe(3, 180, 2)    // dead downwind, in the last bin
+	p.observe(3, 200, 3)    // past it, off the other side
+
+	exp := []polarCell{
+		{WindSpeed: 2, WindAngle: 160, Samples: 1, Mean: 3, Max: 3},
+		{WindSpeed: 2, WindAngle: 170, Samples: 1, Mean: 2, Max: 2},
+		{WindSpeed: 10, WindAngle: 40, Samples: 2, Mean: 6.5, Max: 7},
+	}
+	if cells := p.list(); !reflect.DeepEqual(cells, exp) {
+		t.Errorf("unexpected cells %+v", cells)
+	}
+
+	dir, err := ioutil.TempDir("", "polar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "polar.json")
+	if err := p.save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded := loadPolar(file)
+	loaded.observe(10, 40, 8)
+	exp[2].Samples, exp[2].Mean, exp[2].Max = 3, 7, 8
+	if cells := loaded.list(); !reflect.DeepEqual(cells, exp) {
+		t.Errorf("unexpected cells after reload %+v", cells)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+)
+
+// pulseStall is how long without a pulse until the rate is taken to be
+// zero, as from an engine at 150 RPM with two pulses per revolution.
+const pulseStall = 2 * time.Second
+
+// pulseRate turns the pulse count of a gpio.Counter into a rate. The rate
+// is over the time between the last edges seen on consecutive updates,
+// not the time between the updates, so that it does not jitter with the
+// few pulses of each update at low rates.
+type pulseRate struct {
+	count uint64
+	last  time.Duration // of the last edge, by the kernel's clock
+	seen  time.Time     // when a pulse was last seen
+	rate  float64       // per second
+}
+
+// update takes the count and the time of the last edge, and returns the
+// number of pulses since the previous update and the rate per second.
+func (p *pulseRate) update(now time.Time, count uint64, last time.Duration) (n uint64, rate float64) {
+	n = count - p.count
+	switch {
+	case p.seen.IsZero():
+		// The first update, with no previous edge to go from.
+	case n > 0 && last > p.last:
+		p.rate = float64(n) / (last - p.last).Seconds()
+	case n == 0 && now.Sub(p.seen) >= pulseStall:
+		p.rate = 0
+	}
+	if n > 0 || p.seen.IsZero() {
+		p.seen = now
+	}
+	p.count, p.last = count, last
+	return n, p.rate
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPulseRate(t *testing.T) {
+	var p pulseRate
+	t0 := time.Now()
+	if n, rate := p.update(t0, 10, 5*time.Second); n != 10 || rate != 0 {
+		t.Errorf("first update %d pulses, rate %v; expected 10, no rate yet", n, rate)
+	}
+
+	// 20 Hz, with the updates out of step with the edges.
+	if n, rate := p.update(t0.Add(1100*time.Millisecond), 30, 6*time.Second); n != 20 || rate != 20 {
+		t.Errorf("%d pulses, rate %v; expected 20, 20", n, rate)
+	}
+
+	// No pulses for a moment keeps the rate; for longer it is zero.
+	if _, rate := p.update(t0.Add(2*time.Second), 30, 6*time.Second); rate != 20 {
+		t.Errorf("rate %v right after the last pulse", rate)
+	}
+	if _, rate := p.update(t0.Add(4*time.Second), 30, 6*time.Second); rate != 0 {
+		t.Errorf("rate %v after stalling", rate)
+	}
+}
+
+func TestFlowMeter(t *testing.T) {
+	m := &flowMeter{Litres: 100, pulsesPerLitre: 450}
+	t0 := time.Now()
+	m.observe(t0, 0, 0)
+
+	// 7.5 Hz at 450 pulses per litre is a litre a minute.
+	if rate := m.observe(t0.Add(time.Minute), 450, time.Minute); math.Abs(rate-60) > 1e-9 {
+		t.Errorf("rate %v l/h, expected 60", rate)
+	}
+	if math.Abs(m.Litres-101) > 1e-9 {
+		t.Errorf("volume %v l, expected 101 cumulative", m.Litres)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/calmh/boatpi/store"
+)
+
+// The query API returns the history of a series from the local store,
+// optionally aggregated into steps, for charts without a Prometheus
+// server:
+//
+//   /api/v1/query?series=sensors_lps25h_pressure_mb&start=...&end=...&step=5m&agg=max
+//
+// Times are RFC 3339 or Unix seconds; the range defaults to the last hour.
+// Without a step the raw samples are returned. The aggregation is avg, min,
+// max or angle (the mean of angles in degrees), avg by default.
+
+const (
+	queryDefaultRange = time.Hour
+	queryMaxPoints    = 11000
+)
+
+type queryResult struct {
+	Series string       `json:"series"`
+	Values [][2]float64 `json:"values"` // Unix seconds and value
+}
+
+func handleQuery(w http.ResponseWriter, req *http.Request) {
+	series := req.FormValue("series")
+	if series == "" {
+		http.Error(w, "missing series", http.StatusBadRequest)
+		return
+	}
+	end, err := parseQueryTime(req.FormValue("end"), time.Now())
+	if err != nil {
+		http.Error(w, "end: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, err := parseQueryTime(req.FormValue("start"), end.Add(-queryDefaultRange))
+	if err != nil {
+		http.Error(w, "start: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if end.Before(start) {
+		http.Error(w, "end before start", http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if s := req.FormValue("step"); s != "" {
+		if step, err = parseQueryStep(s); err != nil {
+			http.Error(w, "step: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if end.Sub(start)/step > queryMaxPoints {
+			http.Error(w, "step too small for the range", http.StatusBadRequest)
+			return
+		}
+	}
+	aggName := req.FormValue("agg")
+	if aggName == "" {
+		aggName = "avg"
+	}
+	agg, ok := store.Aggregations[aggName]
+	if !ok {
+		http.Error(w, "unknown aggregation "+aggName, http.StatusBadRequest)
+		return
+	}
+
+	localStoreMut.RLock()
+	defer localStoreMut.RUnlock()
+	if localStore == nil {
+		http.Error(w, "no local store", http.StatusServiceUnavailable)
+		return
+	}
+	samples, err := localStore.Query(series, start, end)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if step > 0 {
+		samples, _ = store.Downsample(samples, start, step, agg)
+	}
+
+	res := queryResult{Series: series, Values: make([][2]float64, len(samples))}
+	for i, s := range samples {
+		res.Values[i] = [2]float64{float64(s.Time.UnixNano()) / 1e9, s.Value}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// parseQueryTime parses an RFC 3339 time or Unix seconds, returning def
+// for the empty string.
+func parseQueryTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*1e9)), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseQueryStep parses a duration such as 5m, or a number of seconds.
+func parseQueryStep(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		secs, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, err
+		}
+		d = time.Duration(secs * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
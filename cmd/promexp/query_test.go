@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/store"
+)
+
+func TestHandleQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := store.OpenSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	t0 := time.Unix(1591012800, 0)
+	for i := 0; i < 6; i++ {
+		st.Append("p", t0.Add(time.Duration(i)*30*time.Second), float64(1000+i))
+	}
+	localStore = st
+	defer func() { localStore = nil }()
+
+	rec := httptest.NewRecorder()
+	handleQuery(rec, httptest.NewRequest("GET", "/api/v1/query?series=p&start=1591012800&end=2020-06-01T12:03:00Z&step=1m&agg=max", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	var res queryResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	exp := [][2]float64{{1591012800, 1001}, {1591012860, 1003}, {1591012920, 1005}}
+	if len(res.Values) != len(exp) {
+		t.Fatalf("unexpected values %v", res.Values)
+	}
+	for i := range exp {
+		if res.Values[i] != exp[i] {
+			t.Errorf("got %v, expected %v", res.Values[i], exp[i])
+		}
+	}
+
+	for _, q := range []string{"", "series=p&agg=median", "series=p&step=-1m", "series=p&start=1591012800&end=1591099200&step=1s"} {
+		rec := httptest.NewRecorder()
+		handleQuery(rec, httptest.NewRequest("GET", "/api/v1/query?"+q, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: unexpected status %d", q, rec.Code)
+		}
+	}
+}
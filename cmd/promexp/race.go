@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/gpio"
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensehat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Racing: a start countdown, and the start line given by its pin and
+// committee boat ends, marked from the GPS position when the boat is at
+// each. From the line and the GPS speed and course follow the distance to
+// the line and the time to burn: how much earlier than the start the boat
+// would reach the line at the current speed. Everything is controlled and
+// reported at /api/v1/race:
+//
+//   POST /api/v1/race?action=start&minutes=5   start the countdown
+//   POST /api/v1/race?action=sync              round it to the nearest minute
+//   POST /api/v1/race?action=stop
+//   POST /api/v1/race?action=pin               mark the pin end here
+//   POST /api/v1/race?action=boat              mark the committee boat end here
+//
+// The countdown is shown on the race page of the LED matrix and, with
+// --race-horn-pin, sounded on a buzzer on a GPIO output: long at each
+// minute and the start, short at 30, 20, 10 and the last five seconds.
+
+const (
+	raceDefaultMinutes = 5
+	raceEarthRadius    = 6371000 // metres
+	raceShortSignal    = 200 * time.Millisecond
+	raceLongSignal     = time.Second
+)
+
+func init() {
+	registerSensor(sensorDef{
+		// Uses the GPS metrics, and is shown by the display.
+		name:    "race",
+		order:   1,
+		enabled: func(o options) bool { return o.WithRace },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.GPIOChip, o.RaceHornPin}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			if cli().RaceHornPin >= 0 {
+				horn, err := gpio.RequestOutput(cli().GPIOChip, cli().RaceHornPin, false)
+				if err != nil {
+					return nil, fmt.Errorf("race horn: %w", err)
+				}
+				go race.sound(ctx, horn)
+			}
+			return registerRace(), nil
+		},
+	})
+}
+
+type racePoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type raceState struct {
+	mut   sync.Mutex
+	start time.Time // zero when no countdown is running
+	pin   *racePoint
+	boat  *racePoint
+
+	// Latest GPS data, for marking the ends
+	pos      *racePoint
+	sog, cog float64
+}
+
+var race = &raceState{}
+
+type raceReport struct {
+	Start          *time.Time `json:"start,omitempty"`
+	Countdown      *float64   `json:"countdown,omitempty"` // seconds, negative after the start
+	Pin            *racePoint `json:"pin,omitempty"`
+	Boat           *racePoint `json:"boat,omitempty"`
+	Position       *racePoint `json:"position,omitempty"`
+	DistanceToLine *float64   `json:"distanceToLine,omitempty"` // metres
+	TimeToLine     *float64   `json:"timeToLine,omitempty"`     // seconds at the current speed and course
+	TimeToBurn     *float64   `json:"timeToBurn,omitempty"`     // seconds
+}
+
+func (r *raceState) report(now time.Time) raceReport {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	rep := raceReport{Pin: r.pin, Boat: r.boat, Position: r.pos}
+	if !r.start.IsZero() {
+		start := r.start
+		cd := start.Sub(now).Seconds()
+		rep.Start, rep.Countdown = &start, &cd
+	}
+	if r.pin == nil || r.boat == nil || r.pos == nil {
+		return rep
+	}
+	dist, approach := lineApproach(*r.pin, *r.boat, *r.pos, r.sog, r.cog)
+	rep.DistanceToLine = &dist
+	if approach > 0 {
+		ttl := dist / approach
+		rep.TimeToLine = &ttl
+		if rep.Countdown != nil && *rep.Countdown > 0 {
+			burn := *rep.Countdown - ttl
+			rep.TimeToBurn = &burn
+		}
+	}
+	return rep
+}
+
+// lineApproach returns the distance in metres from the position to the
+// line through the pin and boat ends, and the speed in metres per second
+// at which the boat approaches it at the speed (knots) and course. The
+// distances are small enough to treat the earth as flat around the pin.
+func lineApproach(pin, boat, pos racePoint, sog, cog float64) (dist, approach float64) {
+	xy := func(p racePoint) (float64, float64) {
+		x := (p.Lon - pin.Lon) / 180 * math.Pi * math.Cos(pin.Lat/180*math.Pi) * raceEarthRadius
+		y := (p.Lat - pin.Lat) / 180 * math.Pi * raceEarthRadius
+		return x, y
+	}
+	bx, by := xy(boat)
+	px, py := xy(pos)
+	l := math.Hypot(bx, by)
+	if l == 0 {
+		return math.Hypot(px, py), 0
+	}
+	// The foot of the perpendicular from the position to the line.
+	t := (px*bx + py*by) / (l * l)
+	fx, fy := t*bx-px, t*by-py
+	dist = math.Hypot(fx, fy)
+	if dist == 0 {
+		return 0, 0
+	}
+	speed := sog * 1852 / 3600
+	vx, vy := speed*math.Sin(cog/180*math.Pi), speed*math.Cos(cog/180*math.Pi)
+	return dist, (vx*fx + vy*fy) / dist
+}
+
+// act performs one of the API actions.
+func (r *raceState) act(action string, minutes float64, now time.Time) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	switch action {
+	case "start":
+		r.start = now.Add(time.Duration(minutes * float64(time.Minute)))
+	case "sync":
+		if r.start.IsZero() {
+			return fmt.Errorf("no countdown running")
+		}
+		left := r.start.Sub(now).Round(time.Minute)
+		r.start = now.Add(left)
+	case "stop":
+		r.start = time.Time{}
+	case "pin", "boat":
+		if r.pos == nil {
+			return fmt.Errorf("no GPS position")
+		}
+		pos := *r.pos
+		if action == "pin" {
+			r.pin = &pos
+		} else {
+			r.boat = &pos
+		}
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	return nil
+}
+
+func (r *raceState) setGPS(pos racePoint, sog, cog float64) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.pos = &pos
+	r.sog, r.cog = sog, cog
+}
+
+// raceSignal returns the length of the horn signal due when the countdown
+// passes the given whole number of seconds left, or zero for none.
+func raceSignal(left int) time.Duration {
+	switch {
+	case left < 0:
+		return 0
+	case left%60 == 0:
+		return raceLongSignal
+	case left == 30, left == 20, left == 10, left <= 5:
+		return raceShortSignal
+	}
+	return 0
+}
+
+// sound sounds the countdown on the horn until the context is done.
+func (r *raceState) sound(ctx context.Context, horn *gpio.Line) {
+	defer horn.Close()
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	last := math.MinInt32
+	var off time.Time
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			horn.SetValue(false)
+			return
+		}
+		now := time.Now()
+		if !off.IsZero() && now.After(off) {
+			if err := horn.SetValue(false); err != nil {
+				log.Println("Race horn:", err)
+			}
+			off = time.Time{}
+		}
+
+		r.mut.Lock()
+		start := r.start
+		r.mut.Unlock()
+		if start.IsZero() {
+			last = math.MinInt32
+			continue
+		}
+		left := int(math.Ceil(start.Sub(now).Seconds()))
+		if left == last {
+			continue
+		}
+		last = left
+		if d := raceSignal(left); d > 0 {
+			if err := horn.SetValue(true); err != nil {
+				log.Println("Race horn:", err)
+			}
+			off = now.Add(d)
+		}
+	}
+}
+
+func registerRace() func() {
+	countdown := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "race",
+		Name:      "countdown_seconds",
+		Help:      "Time to the start, negative after it.",
+	})
+	distance := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "race",
+		Name:      "distance_to_line_metres",
+	})
+	burn := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "race",
+		Name:      "time_to_burn_seconds",
+		Help:      "Time to the start less the time to reach the line at the current speed and course.",
+	})
+
+	lat := seriesRef{name: "sensors_gps_position_degrees", labels: map[string]string{"axis": "latitude"}}
+	lon := seriesRef{name: "sensors_gps_position_degrees", labels: map[string]string{"axis": "longitude"}}
+	sog := seriesRef{name: "sensors_gps_speed_over_ground_knots"}
+	cog := seriesRef{name: "sensors_gps_course_over_ground_degrees"}
+
+	return func() {
+		if mfs, err := prometheus.DefaultGatherer.Gather(); err == nil {
+			lookup := gatheredLookup(mfs)
+			var vals [4]float64
+			for i, ref := range []seriesRef{lat, lon, sog, cog} {
+				if vals[i], err = lookup(ref); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				race.setGPS(racePoint{vals[0], vals[1]}, vals[2], vals[3])
+			}
+		}
+
+		// The values are only set while there is something to set
+		// them from, so that they expire otherwise.
+		r := race.report(time.Now())
+		if r.Countdown != nil {
+			countdown.Set(*r.Countdown)
+		}
+		if r.DistanceToLine != nil {
+			distance.Set(*r.DistanceToLine)
+		}
+		if r.TimeToBurn != nil {
+			burn.Set(*r.TimeToBurn)
+		}
+	}
+}
+
+func handleRace(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodPost {
+		minutes := float64(raceDefaultMinutes)
+		if s := req.FormValue("minutes"); s != "" {
+			var err error
+			if minutes, err = strconv.ParseFloat(s, 64); err != nil || minutes <= 0 {
+				http.Error(w, "minutes: must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		if err := race.act(req.FormValue("action"), minutes, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(race.report(time.Now()))
+}
+
+// raceFrame shows the countdown: a yellow row for each whole minute left
+// and the seconds of the current minute filling the bottom row in green.
+// The last ten seconds flash red, and after the start a green dot shows.
+func raceFrame(left float64, on bool) [64]sensehat.Color {
+	var frame [64]sensehat.Color
+	switch {
+	case left <= 0:
+		frame[3*8+3], frame[3*8+4], frame[4*8+3], frame[4*8+4] = colorGreen, colorGreen, colorGreen, colorGreen
+	case left <= 10:
+		if on {
+			for i := range frame {
+				frame[i] = colorRed
+			}
+		}
+	default:
+		mins := int(left) / 60
+		if mins > 7 {
+			mins = 7
+		}
+		for i := 0; i < mins*8; i++ {
+			frame[i] = colorYellow
+		}
+		secs := math.Mod(left, 60)
+		for col := 0; col < int(math.Ceil(secs/60*8)); col++ {
+			frame[7*8+col] = colorGreen
+		}
+	}
+	return frame
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLineApproach(t *testing.T) {
+	// A line running east from the pin, and the boat 100 m south of its
+	// middle.
+	pin := racePoint{Lat: 57.7, Lon: 11.85}
+	boat := racePoint{Lat: 57.7, Lon: 11.85 + 200/(111195*math.Cos(57.7/180*math.Pi))}
+	pos := racePoint{Lat: 57.7 - 100/111195.0, Lon: (pin.Lon + boat.Lon) / 2}
+
+	dist, approach := lineApproach(pin, boat, pos, 5, 0)
+	if math.Abs(dist-100) > 0.5 {
+		t.Errorf("distance %v, expected 100", dist)
+	}
+	if exp := 5 * 1852 / 3600.0; math.Abs(approach-exp) > 0.01 {
+		t.Errorf("approach %v heading north, expected %v", approach, exp)
+	}
+	if _, approach := lineApproach(pin, boat, pos, 5, 90); math.Abs(approach) > 0.01 {
+		t.Errorf("approach %v sailing along the line", approach)
+	}
+	if _, approach := lineApproach(pin, boat, pos, 5, 180); approach >= 0 {
+		t.Errorf("approach %v sailing away", approach)
+	}
+}
+
+func TestRaceCountdown(t *testing.T) {
+	r := &raceState{}
+	now := time.Now()
+	if err := r.act("pin", 0, now); err == nil {
+		t.Error("expected error marking without a position")
+	}
+	r.setGPS(racePoint{Lat: 57.7 - 100/111195.0, Lon: 11.85}, 5, 0)
+	r.act("start", 5, now)
+	r.act("sync", 0, now.Add(62*time.Second))
+	rep := r.report(now.Add(62 * time.Second))
+	if *rep.Countdown != 240 {
+		t.Errorf("countdown %v after sync, expected 240", *rep.Countdown)
+	}
+	if rep.DistanceToLine != nil {
+		t.Error("distance to line without a line")
+	}
+
+	r.pin = &racePoint{Lat: 57.7, Lon: 11.84}
+	r.boat = &racePoint{Lat: 57.7, Lon: 11.86}
+	rep = r.report(now.Add(62 * time.Second))
+	if rep.TimeToLine == nil || math.Abs(*rep.TimeToLine-38.9) > 0.5 {
+		t.Errorf("unexpected time to line %v", rep.TimeToLine)
+	}
+	if rep.TimeToBurn == nil || math.Abs(*rep.TimeToBurn-(240-*rep.TimeToLine)) > 1e-9 {
+		t.Errorf("unexpected time to burn %v", rep.TimeToBurn)
+	}
+}
+
+func TestRaceSignal(t *testing.T) {
+	for left, exp := range map[int]time.Duration{
+		300: raceLongSignal,
+		299: 0,
+		30:  raceShortSignal,
+		11:  0,
+		3:   raceShortSignal,
+		0:   raceLongSignal,
+		-1:  0,
+	} {
+		if d := raceSignal(left); d != exp {
+			t.Errorf("%d s left: signal %v, expected %v", left, d, exp)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The sensor metrics are recorded in the local store at the store interval,
+// so that there is a history even without a Prometheus server. Series are
+// named as in virtual sensor expressions, e.g.
+// sensors_omini_voltage{channel="a"}.
+
+func init() {
+	registerSensor(sensorDef{
+		// Records the others, so it comes last.
+		name:    "store",
+		order:   3,
+		enabled: func(o options) bool { return o.StorePath != "" },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.StorePath, o.StoreBackend}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initStore(ctx)
+		},
+	})
+}
+
+// The open store, if any, for the query API. Queries hold the read lock.
+var (
+	localStoreMut sync.RWMutex
+	localStore    store.Store
+)
+
+func initStore(ctx context.Context) (func(), error) {
+	st, err := store.Open(cli().StoreBackend, cli().StorePath)
+	if err != nil {
+		return nil, err
+	}
+	localStoreMut.Lock()
+	localStore = st
+	localStoreMut.Unlock()
+	onDone(ctx, func() {
+		localStoreMut.Lock()
+		if localStore == st {
+			localStore = nil
+		}
+		localStoreMut.Unlock()
+		if err := st.Close(); err != nil {
+			log.Println("Close store:", err)
+		}
+	})
+
+	var last time.Time
+	return func() {
+		now := time.Now()
+		if now.Sub(last) < cli().StoreInterval {
+			return
+		}
+		last = now
+
+		mfs, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			log.Println("Store: gather metrics:", err)
+			return
+		}
+		for _, mf := range mfs {
+			if !strings.HasPrefix(mf.GetName(), "sensors_") {
+				continue
+			}
+			for _, m := range mf.GetMetric() {
+				var v float64
+				switch {
+				case m.Gauge != nil:
+					v = m.Gauge.GetValue()
+				case m.Counter != nil:
+					v = m.Counter.GetValue()
+				default:
+					continue
+				}
+				ref := seriesRef{name: mf.GetName(), labels: make(map[string]string)}
+				for _, lp := range m.GetLabel() {
+					ref.labels[lp.GetName()] = lp.GetValue()
+				}
+				if err := st.Append(ref.String(), now, v); err != nil {
+					log.Println("Store:", err)
+					return
+				}
+			}
+		}
+	}, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/alecthomas/kong"
+	"github.com/calmh/boatpi/i2c"
+)
+
+// A runningSensor is an initialized sensor along with the settings it was
+// initialized with.
+type runningSensor struct {
+	def      sensorDef
+	settings []interface{}
+	update   func()
+	cancel   context.CancelFunc
+	cleanups *sync.WaitGroup
+}
+
+// stop cancels the sensor's context and waits for its cleanups, so that
+// its devices and files are released when stop returns.
+func (r *runningSensor) stop() {
+	r.cancel()
+	r.cleanups.Wait()
+}
+
+type runningSensors []*runningSensor
+
+// apply initializes enabled sensors that are not yet running or whose
+// settings have changed, and stops those that are no longer enabled. Sensors
+// that fail to initialize are skipped; the last such error is returned. A
+// sensor whose settings changed is stopped, and its cleanups have run, before
+// it is initialized again. The sensors are stopped when the context is done.
+func (rs *runningSensors) apply(ctx context.Context, bus *i2c.Bus) error {
+	o := cli()
+	var next runningSensors
+	var initErr error
+	for _, def := range sensorDefs {
+		cur := rs.get(def.name)
+		if !def.enabled(*o) && !detected[def.name] {
+			if cur != nil {
+				log.Printf("Stopping %s", def.name)
+				cur.stop()
+				health.stop(def.name)
+				meta.remove(def.name)
+			}
+			continue
+		}
+
+		conf := sensorConf(def.name)
+		settings := def.settings(*o, conf)
+		if cur != nil {
+			if reflect.DeepEqual(cur.settings, settings) {
+				next = append(next, cur)
+				continue
+			}
+			log.Printf("Settings for %s changed; reinitializing", def.name)
+			cur.stop()
+		}
+
+		sctx, cancel := context.WithCancel(ctx)
+		sctx, wg := withCleanups(sctx)
+		update, err := def.init(sctx, bus, conf)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			initErr = fmt.Errorf("init %s: %w", def.name, err)
+			log.Println(initErr)
+			health.stop(def.name)
+			meta.remove(def.name)
+			continue
+		}
+		if def.section {
+			// A hardware sensor, tracked for readiness and described
+			// in the metadata.
+			health.start(def.name)
+			meta.start(def.name)
+		}
+		next = append(next, &runningSensor{def: def, settings: settings, update: update, cancel: cancel, cleanups: wg})
+	}
+	*rs = next
+	return initErr
+}
+
+func (rs runningSensors) get(name string) *runningSensor {
+	for _, r := range rs {
+		if r.def.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// call runs the update function of each sensor that is due at the given
+// tick of the base update interval.
+func (rs runningSensors) call(tick int) {
+	intv := cli().UpdateInterval
+	health.tick()
+	for _, r := range rs {
+		every := int(sensorConf(r.def.name).interval(intv) / intv)
+		if every < 1 {
+			every = 1
+		}
+		if tick%every == 0 {
+			r.update()
+		}
+	}
+}
+
+// reload re-reads the configuration and command line and applies the
+// changes.
+func reload(ctx context.Context, bus *i2c.Bus, rs *runningSensors) {
+	log.Println("Reloading configuration")
+
+	opts, secs, err := parseOptions(os.Args[1:])
+	if err != nil {
+		log.Println("reload configuration:", err)
+		return
+	}
+	prev := cli()
+	if opts.Device != prev.Device || !reflect.DeepEqual(opts.PrometheusAddr, prev.PrometheusAddr) {
+		log.Println("Changes to the I2C device or listen address require a restart")
+		opts.Device = prev.Device
+		opts.PrometheusAddr = prev.PrometheusAddr
+	}
+	if opts.TemperatureUnit != prev.TemperatureUnit || opts.PressureUnit != prev.PressureUnit || opts.LengthUnit != prev.LengthUnit {
+		log.Println("Changes to the output units require a restart")
+		opts.TemperatureUnit = prev.TemperatureUnit
+		opts.PressureUnit = prev.PressureUnit
+		opts.LengthUnit = prev.LengthUnit
+	}
+	if opts.Hotplug && !prev.Hotplug {
+		log.Println("Enabling hotplug requires a restart")
+		opts.Hotplug = false
+	}
+
+	setConfig(opts, secs)
+	if err := setAuth(opts); err != nil {
+		log.Println("Authentication:", err, "(keeping the previous settings)")
+	}
+	i2c.SetDefaultRetries(opts.I2CRetries, opts.I2CBackoff)
+	detected = detectBoards(bus)
+	rs.apply(ctx, bus)
+}
+
+func parseOptions(args []string) (options, fileSections, error) {
+	var opts options
+	var secs fileSections
+	parser, err := kong.New(&opts, kong.Configuration(yamlConfig(&secs)), kong.Exit(func(int) {}))
+	if err != nil {
+		return options{}, fileSections{}, err
+	}
+	if _, err := parser.Parse(args); err != nil {
+		return options{}, fileSections{}, fmt.Errorf("parse: %w", err)
+	}
+	return opts, secs, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+func TestApplyWaitsForCleanups(t *testing.T) {
+	// A sensor holding a device that can only be opened once, and which
+	// takes a moment to let go of it.
+	var held int32
+	enabled, generation := true, 0
+	def := sensorDef{
+		name:    "test-held",
+		enabled: func(o options) bool { return enabled },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{generation}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			if !atomic.CompareAndSwapInt32(&held, 0, 1) {
+				return nil, errors.New("device busy")
+			}
+			onDone(ctx, func() {
+				time.Sleep(10 * time.Millisecond)
+				atomic.StoreInt32(&held, 0)
+			})
+			return func() {}, nil
+		},
+	}
+	prev := sensorDefs
+	sensorDefs = []sensorDef{def}
+	defer func() { sensorDefs = prev }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var running runningSensors
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changed settings reinitialize the sensor, which must find the
+	// device released.
+	generation++
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 {
+		t.Fatal("expected the sensor running after the reload")
+	}
+
+	enabled = false
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 0 || atomic.LoadInt32(&held) != 0 {
+		t.Error("expected the sensor stopped and the device released")
+	}
+}
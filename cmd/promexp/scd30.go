@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensirion"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "scd30",
+		section: true,
+		fields:  []string{"co2", "humidity", "temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithSCD30 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.SCD30Interval, o.SCD30SelfCal}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			scd30, err := sensirion.NewSCD30(bus, cli().SCD30Interval, cli().SCD30SelfCal)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("scd30", scd30)
+			return registerSCD30(scd30), nil
+		},
+	})
+}
+
+func registerSCD30(scd30 *sensirion.SCD30) func() {
+	co2 := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "scd30",
+		Name:      "co2_ppm",
+		Help:      "CO2 concentration.",
+	})
+	hum := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "scd30",
+		Name:      "humidity_percent",
+	})
+	temp := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "scd30",
+		Name:      "temperature_celsius",
+	})
+	dew := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "scd30",
+		Name:      "dewpoint_celsius",
+	})
+
+	return func() {
+		err := scd30.Refresh(time.Second)
+		if err == sensirion.ErrNoMeasurement {
+			// The first one is an interval away.
+			return
+		}
+		if err != nil {
+			log.Println("SCD30:", err)
+			health.failed("scd30", err)
+			return
+		}
+
+		health.ok("scd30")
+		conf := sensorConf("scd30")
+		h := conf.correct("humidity", scd30.Humidity())
+		t := conf.correct("temperature", scd30.Temperature())
+		co2.Set(conf.correct("co2", scd30.CO2()))
+		hum.Set(h)
+		temp.Set(t)
+		dew.Set(dewPoint(t, h))
+		moisture.observe("scd30", t, h)
+	}
+}
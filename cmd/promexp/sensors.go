@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+// Each sensor, and each output that works like one, registers a sensorDef
+// from its own file. The flags are still in the options struct, as the
+// command line parser needs them there.
+
+// A sensorDef describes how to set up a sensor from the options.
+type sensorDef struct {
+	name    string
+	enabled func(options) bool
+	// settings returns the options and configuration that require the
+	// sensor to be reinitialized when changed
+	settings func(options, sensorConfig) []interface{}
+	init     func(context.Context, *i2c.Bus, sensorConfig) (func(), error)
+
+	// section is true for sensors that take a section in the
+	// configuration file, with offsets for the given fields and, if gains
+	// is set, gains.
+	section bool
+	fields  []string
+	gains   bool
+
+	// Definitions are set up and updated in increasing order, so that
+	// those using the values of others come after them.
+	order int
+}
+
+var sensorDefs []sensorDef
+
+func registerSensor(def sensorDef) {
+	sensorDefs = append(sensorDefs, def)
+	sort.SliceStable(sensorDefs, func(a, b int) bool {
+		if sensorDefs[a].order != sensorDefs[b].order {
+			return sensorDefs[a].order < sensorDefs[b].order
+		}
+		return sensorDefs[a].name < sensorDefs[b].name
+	})
+}
+
+// Work to be done when a sensor's context is cancelled, such as closing
+// devices and writing the calibration, is tracked so that shutdown can wait
+// for it. It is also tracked per sensor, in the sensor's context, so that a
+// reload can wait for the previous instance of a sensor to let go of its
+// devices and files before initializing it again.
+var cleanups sync.WaitGroup
+
+type cleanupsKey struct{}
+
+// withCleanups returns a context in which the tracked cleanups are also
+// added to the returned WaitGroup.
+func withCleanups(ctx context.Context) (context.Context, *sync.WaitGroup) {
+	wg := new(sync.WaitGroup)
+	return context.WithValue(ctx, cleanupsKey{}, wg), wg
+}
+
+// track runs fn in a goroutine, as a tracked cleanup.
+func track(ctx context.Context, fn func()) {
+	wg, _ := ctx.Value(cleanupsKey{}).(*sync.WaitGroup)
+	cleanups.Add(1)
+	if wg != nil {
+		wg.Add(1)
+	}
+	go func() {
+		defer cleanups.Done()
+		if wg != nil {
+			defer wg.Done()
+		}
+		fn()
+	}()
+}
+
+// onDone calls fn when the context is done, as a tracked cleanup.
+func onDone(ctx context.Context, fn func()) {
+	track(ctx, func() {
+		<-ctx.Done()
+		fn()
+	})
+}
+
+func sensorDefByName(name string) (sensorDef, bool) {
+	for _, def := range sensorDefs {
+		if def.name == name {
+			return def, true
+		}
+	}
+	return sensorDef{}, false
+}
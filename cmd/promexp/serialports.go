@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/calmh/boatpi/serial"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The serial ports in use, whoever uses them, are exported with their
+// state and traffic. Lines dropped are lines received but not delivered to
+// a consumer that fell behind.
+//
+//   sensors_serial_connected{port="/dev/serial/by-id/usb-FTDI_FT232R-if00-port0",device="/dev/ttyUSB0"} 1
+//   sensors_serial_lines_total{port="/dev/serial/by-id/usb-FTDI_FT232R-if00-port0"} 81234
+
+var (
+	serialConnectedDesc = prometheus.NewDesc("sensors_serial_connected",
+		"Whether the serial port is open, and the device it resolved to.", []string{"port", "device"}, nil)
+	serialReconnectsDesc = prometheus.NewDesc("sensors_serial_reconnects_total",
+		"Times the serial port was opened again after being lost.", []string{"port"}, nil)
+	serialLinesDesc = prometheus.NewDesc("sensors_serial_lines_total",
+		"Lines received on the serial port.", []string{"port"}, nil)
+	serialDroppedDesc = prometheus.NewDesc("sensors_serial_dropped_lines_total",
+		"Lines received on the serial port but dropped for a consumer that fell behind.", []string{"port"}, nil)
+)
+
+type serialCollector struct{}
+
+func (serialCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serialConnectedDesc
+	ch <- serialReconnectsDesc
+	ch <- serialLinesDesc
+	ch <- serialDroppedDesc
+}
+
+func (serialCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, st := range serial.Ports() {
+		connected := 0.0
+		if st.Connected {
+			connected = 1
+		}
+		ch <- prometheus.MustNewConstMetric(serialConnectedDesc, prometheus.GaugeValue, connected, st.Path, st.Device)
+		ch <- prometheus.MustNewConstMetric(serialReconnectsDesc, prometheus.CounterValue, float64(st.Reconnects), st.Path)
+		ch <- prometheus.MustNewConstMetric(serialLinesDesc, prometheus.CounterValue, float64(st.Lines), st.Path)
+		ch <- prometheus.MustNewConstMetric(serialDroppedDesc, prometheus.CounterValue, float64(st.Dropped), st.Path)
+	}
+}
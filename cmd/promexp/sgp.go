@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensirion"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The SGP30 and SGP40 air quality sensors learn their baseline over hours.
+// It is saved in --sgp-state-file every hour and on shutdown, and
+// restored at start while it is still valid: a week for the SGP30
+// baseline, ten minutes for the SGP40 VOC index state. Both sensors are
+// humidity compensated when --sgp-temperature and --sgp-humidity are set.
+
+const sgpSaveInterval = time.Hour
+
+type sgpState struct {
+	SGP30      *sensirion.SGP30Baseline `json:"sgp30,omitempty"`
+	SGP30Saved time.Time                `json:"sgp30Saved,omitempty"`
+	SGP40      *sensirion.VOCState      `json:"sgp40,omitempty"`
+	SGP40Saved time.Time                `json:"sgp40Saved,omitempty"`
+}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "sgp30",
+		order:   1, // compensated by the values of others
+		section: true,
+		fields:  []string{"eco2", "tvoc"},
+		enabled: func(o options) bool { return o.WithSGP30 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.SGPStateFile, o.SGPTemperature, o.SGPHumidity}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			comp, err := sgpCompensation()
+			if err != nil {
+				return nil, err
+			}
+			file := cli().SGPStateFile
+			var baseline *sensirion.SGP30Baseline
+			if st := loadSGPState(file); st.SGP30 != nil && time.Since(st.SGP30Saved) < sensirion.SGP30BaselineAge {
+				baseline = st.SGP30
+			}
+			dev, err := sensirion.NewSGP30(ctx, bus, baseline)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("sgp30", dev)
+			save := func() {
+				if b, ok := dev.Baseline(); ok {
+					updateSGPState(file, func(st *sgpState) { st.SGP30, st.SGP30Saved = &b, time.Now() })
+				}
+			}
+			onDone(ctx, save)
+			return registerSGP30(dev, comp, save), nil
+		},
+	})
+	registerSensor(sensorDef{
+		name:    "sgp40",
+		order:   1,
+		section: true,
+		enabled: func(o options) bool { return o.WithSGP40 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{o.SGPStateFile, o.SGPTemperature, o.SGPHumidity}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			comp, err := sgpCompensation()
+			if err != nil {
+				return nil, err
+			}
+			file := cli().SGPStateFile
+			var state *sensirion.VOCState
+			if st := loadSGPState(file); st.SGP40 != nil && time.Since(st.SGP40Saved) < sensirion.SGP40StateAge {
+				state = st.SGP40
+			}
+			dev, err := sensirion.NewSGP40(ctx, bus, state)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("sgp40", dev)
+			save := func() {
+				if s, ok := dev.State(); ok {
+					updateSGPState(file, func(st *sgpState) { st.SGP40, st.SGP40Saved = &s, time.Now() })
+				}
+			}
+			onDone(ctx, save)
+			return registerSGP40(dev, comp, save), nil
+		},
+	})
+}
+
+// sgpCompensation returns the parsed temperature and humidity expressions,
+// or nil if there are none.
+func sgpCompensation() ([]exprNode, error) {
+	o := cli()
+	if o.SGPTemperature == "" && o.SGPHumidity == "" {
+		return nil, nil
+	}
+	if o.SGPTemperature == "" || o.SGPHumidity == "" {
+		return nil, fmt.Errorf("humidity compensation requires both --sgp-temperature and --sgp-humidity")
+	}
+	var exprs []exprNode
+	for _, src := range []string{o.SGPTemperature, o.SGPHumidity} {
+		expr, _, err := parseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("compensation expression %q: %w", src, err)
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+// sgpCompensate evaluates the compensation expressions and passes the
+// temperature and humidity to set, if they are available.
+func sgpCompensate(comp []exprNode, set func(temperature, humidity float64)) {
+	if comp == nil {
+		return
+	}
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return
+	}
+	lookup := gatheredLookup(mfs)
+	t, err := comp[0](lookup)
+	if err != nil {
+		return
+	}
+	h, err := comp[1](lookup)
+	if err != nil {
+		return
+	}
+	set(t, h)
+}
+
+func loadSGPState(file string) sgpState {
+	var st sgpState
+	fd, err := os.Open(file)
+	if err != nil {
+		return st
+	}
+	defer fd.Close()
+	if err := json.NewDecoder(fd).Decode(&st); err != nil {
+		log.Println("Load SGP state:", err)
+		return sgpState{}
+	}
+	return st
+}
+
+// sgpStateMut serializes the updates of the state file, which may happen
+// for both sensors at once on shutdown.
+var sgpStateMut sync.Mutex
+
+// updateSGPState saves the state file with the changes of fn, keeping the
+// state of the other sensor.
+func updateSGPState(file string, fn func(*sgpState)) {
+	sgpStateMut.Lock()
+	defer sgpStateMut.Unlock()
+	st := loadSGPState(file)
+	fn(&st)
+	tmp := file + ".tmp"
+	fd, err := os.Create(tmp)
+	if err == nil {
+		err = json.NewEncoder(fd).Encode(st)
+		if cerr := fd.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		log.Println("Save SGP state:", err)
+	}
+}
+
+func registerSGP30(dev *sensirion.SGP30, comp []exprNode, save func()) func() {
+	eco2 := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "sgp30",
+		Name:      "eco2_ppm",
+		Help:      "CO2 equivalent, estimated from the VOCs.",
+	})
+	tvoc := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "sgp30",
+		Name:      "tvoc_ppb",
+		Help:      "Total volatile organic compounds.",
+	})
+
+	saved := time.Now()
+	return func() {
+		sgpCompensate(comp, dev.SetCompensation)
+		if time.Since(saved) >= sgpSaveInterval {
+			save()
+			saved = time.Now()
+		}
+
+		err := dev.Refresh(0)
+		if err == sensirion.ErrNoMeasurement {
+			return
+		}
+		if err != nil {
+			log.Println("SGP30:", err)
+			health.failed("sgp30", err)
+			return
+		}
+		health.ok("sgp30")
+		conf := sensorConf("sgp30")
+		eco2.Set(conf.correct("eco2", dev.ECO2()))
+		tvoc.Set(conf.correct("tvoc", dev.TVOC()))
+	}
+}
+
+func registerSGP40(dev *sensirion.SGP40, comp []exprNode, save func()) func() {
+	index := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "sgp40",
+		Name:      "voc_index",
+		Help:      "VOC index, from 1 to 500; 100 is the average of the past day.",
+	})
+	raw := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "sgp40",
+		Name:      "raw_ticks",
+		Help:      "Raw VOC signal; lower with more VOCs.",
+	})
+
+	saved := time.Now()
+	return func() {
+		sgpCompensate(comp, dev.SetCompensation)
+		if time.Since(saved) >= sgpSaveInterval {
+			save()
+			saved = time.Now()
+		}
+
+		err := dev.Refresh(0)
+		if err == sensirion.ErrNoMeasurement {
+			return
+		}
+		if err != nil {
+			log.Println("SGP40:", err)
+			health.failed("sgp40", err)
+			return
+		}
+		health.ok("sgp40")
+		index.Set(dev.VOCIndex())
+		raw.Set(dev.Raw())
+	}
+}
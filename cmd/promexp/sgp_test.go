@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/sensirion"
+)
+
+func TestSGPState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "sgp.state")
+
+	if st := loadSGPState(file); st.SGP30 != nil || st.SGP40 != nil {
+		t.Errorf("expected empty state, got %+v", st)
+	}
+	updateSGPState(file, func(st *sgpState) {
+		st.SGP30, st.SGP30Saved = &sensirion.SGP30Baseline{ECO2: 0x8a21, TVOC: 0x8f3c}, time.Now()
+	})
+	updateSGPState(file, func(st *sgpState) {
+		st.SGP40, st.SGP40Saved = &sensirion.VOCState{Mean: 9876, Std: 42}, time.Now()
+	})
+
+	st := loadSGPState(file)
+	if st.SGP30 == nil || st.SGP30.TVOC != 0x8f3c || st.SGP40 == nil || st.SGP40.Std != 42 {
+		t.Errorf("unexpected state %+v", st)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"github.com/calmh/boatpi/gps"
+)
+
+// A waypoint is a position flag given as LAT/LON in decimal degrees.
+type waypoint [2]float64
+
+func (w *waypoint) Decode(ctx *kong.DecodeContext) error {
+	// Southern and western waypoints start with a minus, which the
+	// scanner would otherwise take for a short flag.
+	s, ok := ctx.Scan.Pop().Value.(string)
+	if !ok {
+		return fmt.Errorf("expected waypoint value")
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("waypoint %q: expected LAT/LON", s)
+	}
+	lat, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return fmt.Errorf("waypoint %q: invalid latitude", s)
+	}
+	lon, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return fmt.Errorf("waypoint %q: invalid longitude", s)
+	}
+	*w = waypoint{lat, lon}
+	return nil
+}
+
+func simulation(o options) gps.Simulation {
+	route := make([][2]float64, len(o.SimulateRoute))
+	for i, wp := range o.SimulateRoute {
+		route[i] = wp
+	}
+	return gps.Simulation{
+		Route:   route,
+		Speed:   o.SimulateSpeed,
+		Heading: o.SimulateHeading,
+		Depth:   o.SimulateDepth,
+	}
+}
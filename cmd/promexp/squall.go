@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// The leading edge of a squall is typically preceded by a sharp pressure
+// rise of a millibar or more over a few minutes (the "pressure jump"), a
+// while before the gust front itself arrives. We sample pressure at a high
+// rate and look for such a rise compared to the recent minimum. The
+// deviations of the samples from their mean over the last minute are kept
+// for the pressure histogram, which widens in gusty conditions.
+
+const (
+	squallHoldTime       = 15 * time.Minute
+	squallSampleInterval = 250 * time.Millisecond
+)
+
+type pressureSample struct {
+	when time.Time
+	val  float64
+}
+
+// A barometer is a pressure sensor, with the pressure in millibar.
+type barometer interface {
+	Refresh(age time.Duration) error
+	Pressure() float64
+	Temperature() float64
+}
+
+type SquallDetector struct {
+	barometer
+	name       string
+	intv       time.Duration
+	window     time.Duration
+	threshold  float64
+	mut        sync.Mutex
+	samples    []pressureSample
+	deviations []float64
+	warnUntil  time.Time
+}
+
+func NewSquallDetector(ctx context.Context, name string, window, intv time.Duration, threshold float64, baro barometer) *SquallDetector {
+	d := &SquallDetector{
+		barometer: baro,
+		name:      name,
+		intv:      intv,
+		window:    window,
+		threshold: threshold,
+		samples:   make([]pressureSample, 0, int(window/intv)+1),
+	}
+	go d.serve(ctx)
+	return d
+}
+
+func (d *SquallDetector) serve(ctx context.Context) {
+	t := time.NewTicker(d.intv)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := d.barometer.Refresh(d.intv / 2); err != nil {
+			log.Printf("refresh %s: %v", d.name, err)
+			continue
+		}
+		d.update(time.Now(), d.barometer.Pressure())
+	}
+}
+
+func (d *SquallDetector) update(now time.Time, val float64) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	cutoff := now.Add(-d.window)
+	i := 0
+	for i < len(d.samples) && d.samples[i].when.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		n := copy(d.samples, d.samples[i:])
+		d.samples = d.samples[:n]
+	}
+	d.samples = append(d.samples, pressureSample{now, val})
+	d.deviations = append(d.deviations, val-recentMean(d.samples, now))
+
+	if pressureJump(d.samples, now) >= d.threshold {
+		if now.After(d.warnUntil) {
+			log.Printf("Squall warning: pressure jump of %.1f mb", pressureJump(d.samples, now))
+		}
+		d.warnUntil = now.Add(squallHoldTime)
+	}
+}
+
+func (d *SquallDetector) SetThreshold(threshold float64) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.threshold = threshold
+}
+
+// PressureJump returns the pressure rise, in millibar, of the last minute
+// compared to the lowest pressure seen earlier in the window.
+func (d *SquallDetector) PressureJump() float64 {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return pressureJump(d.samples, time.Now())
+}
+
+// TakeDeviations returns the deviations, in millibar, of the samples since
+// the last call from the mean pressure of the minute up to each.
+func (d *SquallDetector) TakeDeviations() []float64 {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	devs := d.deviations
+	d.deviations = nil
+	return devs
+}
+
+// Warning returns true while a squall warning is in effect.
+func (d *SquallDetector) Warning() bool {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return time.Now().Before(d.warnUntil)
+}
+
+func pressureJump(samples []pressureSample, now time.Time) float64 {
+	recent := now.Add(-time.Minute)
+	min, sum, n := 0.0, 0.0, 0
+	for i, s := range samples {
+		if s.when.After(recent) {
+			sum += s.val
+			n++
+		} else if i == 0 || s.val < min {
+			min = s.val
+		}
+	}
+	if n == 0 || n == len(samples) {
+		return 0
+	}
+	return sum/float64(n) - min
+}
+
+func recentMean(samples []pressureSample, now time.Time) float64 {
+	recent := now.Add(-time.Minute)
+	sum, n := 0.0, 0
+	for i := len(samples) - 1; i >= 0 && samples[i].when.After(recent); i-- {
+		sum += samples[i].val
+		n++
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSquallDetector(t *testing.T) {
+	d := &SquallDetector{window: 10 * time.Minute, threshold: 1}
+	t0 := time.Now().Add(-20 * time.Minute)
+
+	// Ten minutes of steady, slightly noisy pressure.
+	for i := 0; i < 600; i++ {
+		d.update(t0.Add(time.Duration(i)*time.Second), 1012+float64(i%3)*0.05)
+	}
+	if d.warnUntil.After(t0) {
+		t.Fatal("unexpected warning on steady pressure")
+	}
+
+	// A 1.5 mb rise over three minutes.
+	for i := 0; i < 180; i++ {
+		d.update(t0.Add(time.Duration(600+i)*time.Second), 1012+1.5*float64(i)/180)
+	}
+	if !d.warnUntil.After(t0.Add(780 * time.Second)) {
+		t.Fatal("expected warning on pressure jump")
+	}
+	devs := d.TakeDeviations()
+	if len(devs) != 780 || devs[0] != 0 || math.Abs(devs[779]-0.25) > 0.01 {
+		t.Errorf("unexpected deviations: %d, first %v, last %v", len(devs), devs[0], devs[len(devs)-1])
+	}
+	if devs := d.TakeDeviations(); len(devs) != 0 {
+		t.Errorf("deviations %v taken twice", devs)
+	}
+	if len(d.samples) > 601 {
+		t.Error("samples not pruned to window:", len(d.samples))
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Under systemd with Type=notify, the exporter reports READY=1 once the
+// sensors are initialized, and around reloads and at shutdown. With
+// WatchdogSec set, the update loop pings the watchdog as it runs, so that
+// systemd restarts the exporter if the loop wedges, typically on a stuck
+// I2C bus. The protocol is a datagram per state change on the socket
+// given in NOTIFY_SOCKET; outside of systemd nothing is sent.
+
+type systemdNotifier struct {
+	addr     string
+	watchdog time.Duration
+
+	mut  sync.Mutex
+	last time.Time // last watchdog ping
+}
+
+var systemd = newSystemdNotifier()
+
+func newSystemdNotifier() *systemdNotifier {
+	n := &systemdNotifier{addr: os.Getenv("NOTIFY_SOCKET")}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// The watchdog is meant for another process.
+		return n
+	}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		n.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	return n
+}
+
+// notify sends the state, such as "READY=1", if running under systemd.
+func (n *systemdNotifier) notify(state string) {
+	if n.addr == "" {
+		return
+	}
+	conn, err := net.Dial("unixgram", n.addr)
+	if err != nil {
+		log.Println("systemd notify:", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Println("systemd notify:", err)
+	}
+}
+
+// ping tells the watchdog that the update loop is running, at most four
+// times per watchdog timeout.
+func (n *systemdNotifier) ping(now time.Time) {
+	if n.addr == "" || n.watchdog == 0 {
+		return
+	}
+	n.mut.Lock()
+	due := now.Sub(n.last) >= n.watchdog/4
+	if due {
+		n.last = now
+	}
+	n.mut.Unlock()
+	if due {
+		n.notify("WATCHDOG=1")
+	}
+}
+
+// checkInterval warns if the update loop runs too seldom to keep the
+// watchdog happy.
+func (n *systemdNotifier) checkInterval(intv time.Duration) {
+	if n.watchdog > 0 && intv >= n.watchdog/2 {
+		log.Printf("Warning: update interval %v is too long for the systemd watchdog timeout of %v", intv, n.watchdog)
+	}
+}
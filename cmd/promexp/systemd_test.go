@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSystemdNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr := filepath.Join(dir, "notify")
+	conn, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	recv := func() string {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 64)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+
+	n := &systemdNotifier{addr: addr, watchdog: 4 * time.Second}
+	n.notify("READY=1")
+	if s := recv(); s != "READY=1" {
+		t.Errorf("got %q, expected READY=1", s)
+	}
+
+	now := time.Now()
+	n.ping(now)
+	n.ping(now.Add(500 * time.Millisecond)) // too soon, not sent
+	n.ping(now.Add(time.Second))
+	for i := 0; i < 2; i++ {
+		if s := recv(); s != "WATCHDOG=1" {
+			t.Errorf("ping %d: got %q, expected WATCHDOG=1", i, s)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err := conn.ReadFrom(make([]byte, 64)); err == nil {
+		t.Error("unexpected extra ping")
+	}
+}
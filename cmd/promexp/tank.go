@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/maxbotix"
+	"github.com/calmh/boatpi/sensor"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Tank levels, in their own section. The level comes from one of:
+//
+//   - level: an expression for the height of the liquid in metres, such as
+//     the depth from an MS5837 on the bottom of the tank or a pressure
+//     sender on an ADC channel scaled to metres;
+//   - distance: an expression for the distance in metres from a sensor
+//     above the tank down to the surface, such as an ultrasonic sender on
+//     an ADC channel;
+//   - port: the serial port of a MaxBotix ultrasonic rangefinder looking
+//     down at the surface.
+//
+// A distance is taken from the sensor height above the bottom, which
+// defaults to the tank height. A tank of even section needs only its
+// height and capacity; anything else (a wedge under the berth, a tank
+// around the keel) a strapping table of level and litres pairs, measured
+// by filling it a known amount at a time. With only a height, as for a gas
+// bottle with a Mopeka sensor (see ble.go), there is no litres remaining:
+//
+//   tanks:
+//     water:
+//       level: sensors_ms5837_depth_metres
+//       height: 0.4
+//       capacity: 120
+//     diesel:
+//       port: /dev/serial/by-id/usb-FTDI_FT232R_USB_UART_A10K5JQ0-if00-port0
+//       sensor-height: 0.55
+//       strapping: [[0, 0], [0.1, 30], [0.2, 80], [0.3, 140], [0.45, 200]]
+//     propane:
+//       level: sensors_ble_level_metres{name="propane"}
+//       height: 0.3
+//
+// The expressions see lengths in metres also when they are exported in
+// feet, as is the level. Each is exported as
+// sensors_tank_fill_percent{tank="<name>"}, along with the level and the
+// litres remaining.
+
+type tankConfig struct {
+	Level        string       `yaml:"level"`    // expression, metres
+	Distance     string       `yaml:"distance"` // expression, metres
+	Port         string       `yaml:"port"`
+	SensorHeight float64      `yaml:"sensor-height"` // m above the bottom, for a distance
+	Height       float64      `yaml:"height"`        // m, when full
+	Capacity     float64      `yaml:"capacity"`      // litres, when full; optional with a height
+	Strapping    [][2]float64 `yaml:"strapping"`     // level and litres pairs
+}
+
+// litres returns the volume at the level in metres, and the capacity.
+func (c tankConfig) litres(level float64) (float64, float64) {
+	if len(c.Strapping) > 0 {
+		var n interpolation
+		for _, p := range c.Strapping {
+			n.x = append(n.x, p[0])
+			n.y = append(n.y, p[1])
+		}
+		capacity := c.Capacity
+		if capacity == 0 {
+			capacity = n.y[len(n.y)-1]
+		}
+		return n.val(level), capacity
+	}
+	return math.Max(0, math.Min(c.Capacity, level/c.Height*c.Capacity)), c.Capacity
+}
+
+// sensorHeight returns the height of the distance sensor above the bottom.
+func (c tankConfig) sensorHeight() float64 {
+	if c.SensorHeight > 0 {
+		return c.SensorHeight
+	}
+	return c.Height
+}
+
+func init() {
+	registerSensor(sensorDef{
+		// The level may well be a virtual sensor.
+		name:    "tanks",
+		order:   2,
+		enabled: func(o options) bool { return len(sections().Tanks) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{sections().Tanks}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return initTanks(ctx, sections().Tanks)
+		},
+	})
+}
+
+func sortedTanks(tks map[string]tankConfig) []string {
+	names := make([]string, 0, len(tks))
+	for name := range tks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateTanks(tks map[string]tankConfig) error {
+	for _, name := range sortedTanks(tks) {
+		tk := tks[name]
+		sources := 0
+		for _, s := range []string{tk.Level, tk.Distance, tk.Port} {
+			if s != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("tank %s: exactly one of level, distance and port is needed", name)
+		}
+		for _, src := range []string{tk.Level, tk.Distance} {
+			if src == "" {
+				continue
+			}
+			if _, _, err := parseExpr(src); err != nil {
+				return fmt.Errorf("tank %s: %w", name, err)
+			}
+		}
+		if tk.Level == "" && tk.sensorHeight() <= 0 {
+			return fmt.Errorf("tank %s: a distance needs the sensor height", name)
+		}
+		if len(tk.Strapping) == 1 {
+			return fmt.Errorf("tank %s: strapping table needs at least two points", name)
+		}
+		for i := 1; i < len(tk.Strapping); i++ {
+			if tk.Strapping[i][0] <= tk.Strapping[i-1][0] {
+				return fmt.Errorf("tank %s: strapping table levels must be increasing", name)
+			}
+			if tk.Strapping[i][1] < tk.Strapping[i-1][1] {
+				return fmt.Errorf("tank %s: strapping table litres must not decrease", name)
+			}
+		}
+		if len(tk.Strapping) == 0 && tk.Height <= 0 {
+			return fmt.Errorf("tank %s: height is needed without a strapping table", name)
+		}
+		if tk.Capacity < 0 {
+			return fmt.Errorf("tank %s: capacity must be positive", name)
+		}
+	}
+	return nil
+}
+
+type tankInput struct {
+	tankConfig
+	name  string
+	expr  exprNode
+	sonar *maxbotix.MaxSonar
+}
+
+// level returns the level in metres.
+func (in *tankInput) level(lookup lookupFunc) (float64, error) {
+	if in.sonar != nil {
+		if err := in.sonar.Refresh(time.Second); err != nil {
+			return 0, err
+		}
+		return in.sensorHeight() - in.sonar.Distance(), nil
+	}
+	v, err := in.expr(lookup)
+	if err != nil {
+		return 0, err
+	}
+	if in.Distance != "" {
+		return in.sensorHeight() - v, nil
+	}
+	return v, nil
+}
+
+func initTanks(ctx context.Context, tks map[string]tankConfig) (func(), error) {
+	var inputs []*tankInput
+	var sonars []sensor.Sensor
+	for _, name := range sortedTanks(tks) {
+		tk := tks[name]
+		in := &tankInput{tankConfig: tk, name: name}
+		switch {
+		case tk.Port != "":
+			sonar, err := maxbotix.NewMaxSonar(ctx, tk.Port)
+			if err != nil {
+				return nil, fmt.Errorf("tank %s: %w", name, err)
+			}
+			in.sonar = sonar
+			sonars = append(sonars, sonar)
+		case tk.Distance != "":
+			in.expr, _, _ = parseExpr(tk.Distance)
+		default:
+			in.expr, _, _ = parseExpr(tk.Level)
+		}
+		inputs = append(inputs, in)
+	}
+	meta.setDevices("tanks", sonars...)
+	return registerTanks(inputs), nil
+}
+
+func registerTanks(inputs []*tankInput) func() {
+	level := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "tank",
+		Name:      "level_metres",
+		Help:      "Height of the liquid above the bottom of the tank.",
+	}, []string{"tank"})
+	fill := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "tank",
+		Name:      "fill_percent",
+	}, []string{"tank"})
+	remaining := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "tank",
+		Name:      "remaining_litres",
+	}, []string{"tank"})
+
+	errs := make(map[string]error)
+	return func() {
+		mfs, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			log.Println("Tanks: gather metrics:", err)
+			return
+		}
+		lookup := gatheredLookup(mfs)
+		for _, in := range inputs {
+			lvl, err := in.level(lookup)
+			if err != nil {
+				if prev := errs[in.name]; prev == nil || prev.Error() != err.Error() {
+					log.Printf("Tank %s: %v", in.name, err)
+				}
+				errs[in.name] = err
+				continue
+			}
+			errs[in.name] = nil
+
+			level.WithLabelValues(in.name).Set(lvl)
+			if len(in.Strapping) == 0 && in.Capacity == 0 {
+				fill.WithLabelValues(in.name).Set(math.Max(0, math.Min(1, lvl/in.Height)) * 100)
+				continue
+			}
+			litres, capacity := in.litres(lvl)
+			remaining.WithLabelValues(in.name).Set(litres)
+			fill.WithLabelValues(in.name).Set(litres / capacity * 100)
+		}
+	}
+}
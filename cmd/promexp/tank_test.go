@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTankLitres(t *testing.T) {
+	even := tankConfig{Height: 0.4, Capacity: 120}
+	strapped := tankConfig{Strapping: [][2]float64{{0, 0}, {0.1, 30}, {0.2, 80}, {0.45, 200}}}
+	cases := []struct {
+		tank     tankConfig
+		level    float64
+		litres   float64
+		capacity float64
+	}{
+		{even, 0.1, 30, 120},
+		{even, 0.5, 120, 120},
+		{even, -0.01, 0, 120},
+		{strapped, 0.15, 55, 200},
+		{strapped, 0.5, 200, 200},
+	}
+	for _, tc := range cases {
+		litres, capacity := tc.tank.litres(tc.level)
+		if math.Abs(litres-tc.litres) > 1e-9 || capacity != tc.capacity {
+			t.Errorf("%+v at %v m: %v of %v litres, expected %v of %v", tc.tank, tc.level, litres, capacity, tc.litres, tc.capacity)
+		}
+	}
+}
+
+func TestTankDistance(t *testing.T) {
+	expr, _, err := parseExpr("sensors_ads1115_water_distance_metres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &tankInput{tankConfig: tankConfig{Distance: "sensors_ads1115_water_distance_metres", SensorHeight: 0.55}, expr: expr}
+	lookup := func(ref seriesRef) (float64, error) { return 0.25, nil }
+	if level, err := in.level(lookup); err != nil || math.Abs(level-0.3) > 1e-9 {
+		t.Errorf("level %v, %v; expected 0.3 below a sensor at 0.55 m", level, err)
+	}
+}
+
+func TestTankHeightOnly(t *testing.T) {
+	// A gas bottle, with a level and a height but no capacity.
+	tks := map[string]tankConfig{"propane": {Level: `sensors_ble_level_metres{name="propane"}`, Height: 0.3}}
+	if err := validateTanks(tks); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTankFeet(t *testing.T) {
+	// The level is computed in metres and converted once, on export.
+	withOptions(t, func(o *options) {
+		o.LengthUnit = "feet"
+		o.MetricsPrecision = -1
+	})
+
+	depth := newGauge(prometheus.GaugeOpts{Namespace: "sensors", Subsystem: "test_tank", Name: "depth_metres"})
+	depth.Set(0.2)
+	expr, _, err := parseExpr("sensors_test_tank_depth_metres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &tankInput{tankConfig: tankConfig{Level: "sensors_test_tank_depth_metres", Height: 0.4, Capacity: 120}, name: "feet", expr: expr}
+	registerTanks([]*tankInput{in})()
+
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := gatheredLookup(mfs)
+	tank := map[string]string{"tank": "feet"}
+	for name, want := range map[string]float64{
+		"sensors_tank_level_feet":       0.2 / 0.3048,
+		"sensors_tank_level_metres":     0.2,
+		"sensors_tank_fill_percent":     50,
+		"sensors_tank_remaining_litres": 60,
+	} {
+		if got, err := lookup(seriesRef{name: name, labels: tank}); err != nil || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: %v, %v; expected %v", name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+// The pressure tendency is the change in pressure over the last three
+// hours, as given in weather reports: steady within ±1 hPa, otherwise
+// rising or falling. The history is saved to disk now and then, so that a
+// restart does not mean three hours without a tendency.
+
+const (
+	tendencyPeriod         = 3 * time.Hour
+	tendencySampleInterval = time.Minute
+	tendencySlack          = 15 * time.Minute // allowed gap at the start of the period
+	tendencySaveInterval   = 10 * time.Minute
+	tendencySteadyLimit    = 1.0 // hPa per three hours
+)
+
+type pressureTendency struct {
+	file    string
+	samples []pressureSample
+	saved   time.Time
+}
+
+type savedPressure struct {
+	When     time.Time
+	Pressure float64
+}
+
+func loadPressureTendency(file string, now time.Time) *pressureTendency {
+	t := &pressureTendency{file: file, saved: now}
+	fd, err := os.Open(file)
+	if err != nil {
+		return t
+	}
+	defer fd.Close()
+
+	var saved []savedPressure
+	if err := json.NewDecoder(fd).Decode(&saved); err != nil {
+		return t
+	}
+	for _, s := range saved {
+		t.samples = append(t.samples, pressureSample{s.When, s.Pressure})
+	}
+	t.trim(now)
+	return t
+}
+
+func (t *pressureTendency) observe(now time.Time, p float64) {
+	if n := len(t.samples); n > 0 && now.Sub(t.samples[n-1].when) < tendencySampleInterval {
+		return
+	}
+	t.samples = append(t.samples, pressureSample{now, p})
+	t.trim(now)
+
+	if t.file != "" && now.Sub(t.saved) >= tendencySaveInterval {
+		if err := t.save(); err != nil {
+			log.Println("Save pressure history:", err)
+		}
+		t.saved = now
+	}
+}
+
+func (t *pressureTendency) trim(now time.Time) {
+	cutoff := now.Add(-tendencyPeriod - tendencySlack)
+	i := 0
+	for i < len(t.samples) && t.samples[i].when.Before(cutoff) {
+		i++
+	}
+	t.samples = t.samples[i:]
+}
+
+func (t *pressureTendency) save() error {
+	saved := make([]savedPressure, len(t.samples))
+	for i, s := range t.samples {
+		saved[i] = savedPressure{s.when, s.val}
+	}
+	tmp := t.file + ".tmp"
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(fd).Encode(saved); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.file)
+}
+
+// tendency returns the pressure change over the last three hours, if the
+// history reaches back that far.
+func (t *pressureTendency) tendency(now time.Time) (float64, bool) {
+	if len(t.samples) < 2 {
+		return 0, false
+	}
+	start := now.Add(-tendencyPeriod)
+	best := -1
+	for i, s := range t.samples {
+		if best < 0 || math.Abs(float64(s.when.Sub(start))) < math.Abs(float64(t.samples[best].when.Sub(start))) {
+			best = i
+		}
+	}
+	if math.Abs(float64(t.samples[best].when.Sub(start))) > float64(tendencySlack) {
+		return 0, false
+	}
+	return t.samples[len(t.samples)-1].val - t.samples[best].val, true
+}
+
+func pressureTendencyState(delta float64) string {
+	switch {
+	case delta >= tendencySteadyLimit:
+		return "rising"
+	case delta <= -tendencySteadyLimit:
+		return "falling"
+	default:
+		return "steady"
+	}
+}
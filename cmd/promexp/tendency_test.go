@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPressureTendency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tendency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pressure.history")
+
+	t0 := time.Now().Add(-4 * time.Hour)
+	tend := loadPressureTendency(file, t0)
+	now := t0
+	for ; now.Before(t0.Add(2 * time.Hour)); now = now.Add(10 * time.Second) {
+		// Falling 1 hPa per hour
+		tend.observe(now, 1010-now.Sub(t0).Hours())
+	}
+	if _, ok := tend.tendency(now); ok {
+		t.Error("expected no tendency with two hours of history")
+	}
+
+	// A restart, with the history saved within the last ten minutes.
+	tend = loadPressureTendency(file, now)
+	for ; now.Before(t0.Add(4 * time.Hour)); now = now.Add(10 * time.Second) {
+		tend.observe(now, 1010-now.Sub(t0).Hours())
+	}
+	delta, ok := tend.tendency(now)
+	if !ok {
+		t.Fatal("expected a tendency after restart")
+	}
+	if math.Abs(delta+3) > 0.1 {
+		t.Errorf("expected -3 hPa, got %v", delta)
+	}
+	if s := pressureTendencyState(delta); s != "falling" {
+		t.Errorf("expected falling, got %s", s)
+	}
+	if len(tend.samples) > int((tendencyPeriod+tendencySlack)/tendencySampleInterval)+1 {
+		t.Errorf("history not trimmed, %d samples", len(tend.samples))
+	}
+}
+
+func TestPressureHistoryFile(t *testing.T) {
+	cases := []struct{ file, name, exp string }{
+		{"pressure.history", "lps25h", "pressure.history"},
+		{"pressure.history", "ms5611", "pressure.ms5611.history"},
+		{"/var/lib/boatpi/pressure", "ms5611", "/var/lib/boatpi/pressure.ms5611"},
+	}
+	for _, tc := range cases {
+		if res := pressureHistoryFile(tc.file, tc.name); res != tc.exp {
+			t.Errorf("%s for %s: got %s, expected %s", tc.file, tc.name, res, tc.exp)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/tmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "tmp117",
+		section: true,
+		fields:  []string{"temperature"},
+		gains:   true,
+		enabled: func(o options) bool { return o.WithTMP117 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{c.Address, o.TMP117Averaging}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			tmp117, err := tmp.NewTMP117(bus, conf.address(tmp.TMP117DefaultAddress), cli().TMP117Averaging)
+			if err != nil {
+				return nil, err
+			}
+			meta.setDevices("tmp117", tmp117)
+			return registerTMP117(tmp117), nil
+		},
+	})
+}
+
+func registerTMP117(tmp117 *tmp.TMP117) func() {
+	temp := newGauge(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "tmp117",
+		Name:      "temperature_celsius",
+		Help:      "Reference temperature, accurate to 0.1 °C.",
+	})
+
+	return func() {
+		err := tmp117.Refresh(time.Second)
+		if err == tmp.ErrNoMeasurement {
+			// The first one is a second away.
+			return
+		}
+		if err != nil {
+			log.Println("TMP117:", err)
+			health.failed("tmp117", err)
+			return
+		}
+
+		health.ok("tmp117")
+		temp.Set(sensorConf("tmp117").correct("temperature", tmp117.Temperature()))
+	}
+}
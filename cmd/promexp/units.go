@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are produced in degrees Celsius, millibar and metres. Other units
+// can be selected per quantity, in which case the metric names get the
+// suffix of the selected unit instead (sensors_hts221_temperature_fahrenheit)
+// and the values are converted in the gauge wrappers on the way out.
+// Expressions, virtual sensors among them, see the values in the base
+// units, so virtual sensors are left alone.
+
+type outputUnit struct {
+	suffix  string
+	convert func(float64) float64
+	// delta converts a difference, for units with an offset
+	delta func(float64) float64
+	// decimals are the extra decimals worth showing in the unit
+	decimals int
+}
+
+var outputUnits = map[string]map[string]outputUnit{
+	"_celsius": {
+		"fahrenheit": {
+			suffix:  "_fahrenheit",
+			convert: func(c float64) float64 { return c*9/5 + 32 },
+			delta:   func(c float64) float64 { return c * 9 / 5 },
+		},
+	},
+	"_mb": {
+		"inhg": {
+			suffix:   "_inhg",
+			convert:  func(mb float64) float64 { return mb * 0.0295300 },
+			decimals: 2,
+		},
+	},
+	"_metres": {
+		"feet": {
+			suffix:  "_feet",
+			convert: func(m float64) float64 { return m / 0.3048 },
+		},
+	},
+}
+
+// Metrics that are differences rather than absolute values.
+var deltaMetrics = map[string]bool{
+	"sensors_moisture_dewpoint_margin_celsius": true,
+}
+
+// selectedUnit returns the unit selected in the options for metrics with
+// the suffix.
+func selectedUnit(o options, suffix string) string {
+	switch suffix {
+	case "_celsius":
+		return o.TemperatureUnit
+	case "_mb":
+		return o.PressureUnit
+	case "_metres":
+		return o.LengthUnit
+	}
+	return ""
+}
+
+// exportedUnit returns the unit of a reading as exported with the selected
+// units, and the precision in that unit.
+func exportedUnit(o options, unit string, precision int) (string, int) {
+	if u, ok := outputUnits["_"+unit][selectedUnit(o, "_"+unit)]; ok {
+		return strings.TrimPrefix(u.suffix, "_"), precision + u.decimals
+	}
+	return unit, precision
+}
+
+// convertUnit returns the options with the metric name changed to the
+// selected unit, and the conversion of the values, or nil if there is
+// none.
+func convertUnit(opts prometheus.GaugeOpts) (prometheus.GaugeOpts, func(float64) float64) {
+	if opts.Subsystem == "virtual" {
+		return opts, nil
+	}
+	name := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	for suffix, units := range outputUnits {
+		if !strings.HasSuffix(opts.Name, suffix) {
+			continue
+		}
+		unit, ok := units[selectedUnit(*cli(), suffix)]
+		if !ok {
+			return opts, nil
+		}
+		opts.Name = strings.TrimSuffix(opts.Name, suffix) + unit.suffix
+		if deltaMetrics[name] && unit.delta != nil {
+			return opts, unit.delta
+		}
+		return opts, unit.convert
+	}
+	return opts, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestConvertUnit(t *testing.T) {
+	withOptions(t, func(o *options) {
+		o.TemperatureUnit = "fahrenheit"
+		o.PressureUnit = "inhg"
+		o.LengthUnit = "metres"
+	})
+
+	cases := []struct {
+		subsystem, name, exp string
+		in, out              float64
+	}{
+		{"hts221", "temperature_celsius", "temperature_fahrenheit", 20, 68},
+		{"moisture", "dewpoint_margin_celsius", "dewpoint_margin_fahrenheit", 5, 9},
+		{"lps25h", "pressure_mb", "pressure_inhg", 1013.25, 29.921},
+		{"gps", "depth_metres", "depth_metres", 10, 10},
+		{"virtual", "cabin_celsius", "cabin_celsius", 20, 20},
+	}
+	for _, c := range cases {
+		opts, conv := convertUnit(prometheus.GaugeOpts{Namespace: "sensors", Subsystem: c.subsystem, Name: c.name})
+		if opts.Name != c.exp {
+			t.Errorf("%s: name %s, expected %s", c.name, opts.Name, c.exp)
+		}
+		v := c.in
+		if conv != nil {
+			v = conv(v)
+		}
+		if math.Abs(v-c.out) > 1e-3 {
+			t.Errorf("%s: %v converted to %v, expected %v", c.name, c.in, v, c.out)
+		}
+	}
+}
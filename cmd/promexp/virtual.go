@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func init() {
+	registerSensor(sensorDef{
+		// Virtual sensors come after the others so that they see the
+		// values from this round of updates.
+		name:    "virtual",
+		order:   1,
+		enabled: func(o options) bool { return len(sections().Virtual) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{sections().Virtual}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			return registerVirtual(sections().Virtual, prometheus.DefaultGatherer)
+		},
+	})
+}
+
+var virtualNameExp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+type virtualSensor struct {
+	name  string
+	expr  exprNode
+	gauge *gauge
+	err   error // last evaluation error, to avoid repeating it in the log
+}
+
+func validateVirtual(defs map[string]string) error {
+	for name, expr := range defs {
+		if !virtualNameExp.MatchString(name) {
+			return fmt.Errorf("virtual sensor %q: invalid name", name)
+		}
+		if _, _, err := parseExpr(expr); err != nil {
+			return fmt.Errorf("virtual sensor %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func registerVirtual(defs map[string]string, gatherer prometheus.Gatherer) (func(), error) {
+	var sensors []*virtualSensor
+	for name, src := range defs {
+		expr, _, err := parseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sensors = append(sensors, &virtualSensor{
+			name: name,
+			expr: expr,
+			gauge: newGauge(prometheus.GaugeOpts{
+				Namespace: "sensors",
+				Subsystem: "virtual",
+				Name:      name,
+				Help:      src,
+			}),
+		})
+	}
+	sort.Slice(sensors, func(a, b int) bool { return sensors[a].name < sensors[b].name })
+
+	return func() {
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			log.Println("Virtual sensors: gather metrics:", err)
+			return
+		}
+		lookup := gatheredLookup(mfs)
+		for _, s := range sensors {
+			v, err := s.expr(lookup)
+			if err != nil {
+				if s.err == nil || s.err.Error() != err.Error() {
+					log.Printf("Virtual sensor %s: %v", s.name, err)
+				}
+				s.err = err
+				continue
+			}
+			s.err = nil
+			s.gauge.Set(v)
+		}
+	}, nil
+}
+
+// gatheredLookup returns a lookupFunc that resolves series references
+// to the values of gauges as set, in the base units, and other metrics
+// against the gathered ones. A reference must match exactly one series.
+func gatheredLookup(mfs []*dto.MetricFamily) lookupFunc {
+	byName := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		byName[mf.GetName()] = mf
+	}
+
+	return func(ref seriesRef) (float64, error) {
+		if v, ok, err := rawValue(ref); ok {
+			return v, err
+		}
+		mf, ok := byName[ref.name]
+		if !ok {
+			return 0, fmt.Errorf("no such metric %s", ref.name)
+		}
+		var match *dto.Metric
+		for _, m := range mf.GetMetric() {
+			if !labelsMatch(m, ref.labels) {
+				continue
+			}
+			if match != nil {
+				return 0, fmt.Errorf("%v matches several series", ref)
+			}
+			match = m
+		}
+		if match == nil {
+			return 0, fmt.Errorf("no series matches %v", ref)
+		}
+		switch {
+		case match.Gauge != nil:
+			return match.Gauge.GetValue(), nil
+		case match.Counter != nil:
+			return match.Counter.GetValue(), nil
+		case match.Untyped != nil:
+			return match.Untyped.GetValue(), nil
+		default:
+			return 0, fmt.Errorf("%v is not a gauge or counter", ref)
+		}
+	}
+}
+
+func labelsMatch(m *dto.Metric, labels map[string]string) bool {
+	found := 0
+	for _, lp := range m.GetLabel() {
+		if v, ok := labels[lp.GetName()]; ok {
+			if v != lp.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(labels)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Webhooks let other systems, such as a shore side weather alert service
+// or a door sensor on another controller, post values and alarms. Each
+// webhook has a name and a secret in the configuration file:
+//
+//   webhooks:
+//     weather:
+//       secret: 3b9c0f2e71d4
+//
+// and is posted to at /webhook/<name>, with the secret as a bearer token
+// or in the "secret" query parameter, and a JSON body:
+//
+//   {"values": {"gust_knots": 38, "warning_level": 2}}
+//   {"value": 1}
+//   {"alarm": "Gale warning for Skagerrak"}
+//
+// The values are exported as sensors_webhook_value{webhook="weather",
+// field="gust_knots"} ("value" is field "value"), for use in virtual
+// sensors and expressions, and expire like other metrics when no longer
+// posted. An alarm raises the latched alarm webhook-<name>, and is
+// notified like the others.
+//
+// Webhooks check their own secrets rather than the HTTP authentication,
+// as the posting systems rarely can do more than a URL and a header. An
+// unknown name is refused like a wrong secret, so as not to tell which
+// webhooks exist.
+
+const (
+	// webhookMaxFields is the number of fields a webhook may export, to
+	// bound the number of series.
+	webhookMaxFields = 32
+	// webhookMaxPending is the number of posts kept until the next
+	// update; older ones are dropped.
+	webhookMaxPending = 64
+)
+
+type webhookConfig struct {
+	Secret string `yaml:"secret"`
+}
+
+type webhookPost struct {
+	Value  *float64           `json:"value"`
+	Values map[string]float64 `json:"values"`
+	Alarm  string             `json:"alarm"`
+}
+
+type webhookEvent struct {
+	name string
+	post webhookPost
+}
+
+var webhookState struct {
+	mut     sync.Mutex
+	secrets map[string]string
+	fields  map[string]map[string]bool // seen per webhook
+	pending []webhookEvent
+}
+
+func init() {
+	registerSensor(sensorDef{
+		name:    "webhooks",
+		enabled: func(o options) bool { return len(sections().Webhooks) > 0 },
+		settings: func(o options, c sensorConfig) []interface{} {
+			return []interface{}{sections().Webhooks}
+		},
+		init: func(ctx context.Context, bus *i2c.Bus, conf sensorConfig) (func(), error) {
+			setWebhooks(sections().Webhooks)
+			onDone(ctx, func() { setWebhooks(nil) })
+			return registerWebhooks(), nil
+		},
+	})
+}
+
+func validateWebhooks(hooks map[string]webhookConfig) error {
+	for name, hook := range hooks {
+		if !virtualNameExp.MatchString(name) {
+			return fmt.Errorf("webhook %q: invalid name", name)
+		}
+		if hook.Secret == "" {
+			return fmt.Errorf("webhook %s: missing secret", name)
+		}
+	}
+	return nil
+}
+
+// setWebhooks sets the webhooks accepted by the handler.
+func setWebhooks(hooks map[string]webhookConfig) {
+	webhookState.mut.Lock()
+	defer webhookState.mut.Unlock()
+	webhookState.secrets = make(map[string]string, len(hooks))
+	for name, hook := range hooks {
+		webhookState.secrets[name] = hook.Secret
+	}
+	webhookState.pending = nil
+}
+
+// queueWebhook checks the secret of the named webhook and queues the post for
+// the update loop. It returns the HTTP status.
+func queueWebhook(name, secret string, post webhookPost) (int, error) {
+	webhookState.mut.Lock()
+	defer webhookState.mut.Unlock()
+
+	want, ok := webhookState.secrets[name]
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(want)) != 1 || !ok {
+		return http.StatusUnauthorized, fmt.Errorf("wrong webhook or secret")
+	}
+
+	if post.Value != nil {
+		if post.Values == nil {
+			post.Values = make(map[string]float64)
+		}
+		post.Values["value"] = *post.Value
+	}
+	if webhookState.fields == nil {
+		webhookState.fields = make(map[string]map[string]bool)
+	}
+	seen := webhookState.fields[name]
+	if seen == nil {
+		seen = make(map[string]bool)
+		webhookState.fields[name] = seen
+	}
+	for field := range post.Values {
+		if !virtualNameExp.MatchString(field) {
+			return http.StatusBadRequest, fmt.Errorf("field %q: invalid name", field)
+		}
+		if !seen[field] && len(seen) >= webhookMaxFields {
+			return http.StatusBadRequest, fmt.Errorf("too many fields (at most %d)", webhookMaxFields)
+		}
+	}
+	for field := range post.Values {
+		seen[field] = true
+	}
+
+	if n := len(webhookState.pending); n >= webhookMaxPending {
+		webhookState.pending = append(webhookState.pending[:0], webhookState.pending[n-webhookMaxPending+1:]...)
+	}
+	webhookState.pending = append(webhookState.pending, webhookEvent{name, post})
+	return http.StatusNoContent, nil
+}
+
+func takeWebhookEvents() []webhookEvent {
+	webhookState.mut.Lock()
+	defer webhookState.mut.Unlock()
+	evs := webhookState.pending
+	webhookState.pending = nil
+	return evs
+}
+
+func handleWebhook(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/webhook/")
+	secret, ok := bearerToken(req)
+	if !ok {
+		secret = req.URL.Query().Get("secret")
+	}
+
+	var post webhookPost
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64<<10)).Decode(&post); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if code, err := queueWebhook(name, secret, post); err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func registerWebhooks() func() {
+	values := newGaugeVec(prometheus.GaugeOpts{
+		Namespace: "sensors",
+		Subsystem: "webhook",
+		Name:      "value",
+		Help:      "Values posted to the webhooks.",
+	}, []string{"webhook", "field"})
+
+	return func() {
+		for _, ev := range takeWebhookEvents() {
+			for field, v := range ev.post.Values {
+				values.WithLabelValues(ev.name, field).Set(v)
+			}
+			if ev.post.Alarm != "" {
+				raiseAlarm(notice{
+					Alarm:   "webhook-" + ev.name,
+					Kind:    "webhook",
+					Message: fmt.Sprintf("%s: %s", ev.name, ev.post.Alarm),
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	setWebhooks(map[string]webhookConfig{"weather": {Secret: "s3cret"}})
+	defer setWebhooks(nil)
+
+	cases := []struct {
+		path, authorization, body string
+		code                      int
+	}{
+		{"/webhook/weather", "Bearer s3cret", `{"values": {"gust_knots": 38}}`, http.StatusNoContent},
+		{"/webhook/weather?secret=s3cret", "", `{"value": 1, "alarm": "Gale warning"}`, http.StatusNoContent},
+		{"/webhook/weather", "Bearer wrong", `{"value": 1}`, http.StatusUnauthorized},
+		{"/webhook/weather", "", `{"value": 1}`, http.StatusUnauthorized},
+		{"/webhook/door", "Bearer s3cret", `{"value": 1}`, http.StatusUnauthorized},
+		{"/webhook/door", "", `{"value": 1}`, http.StatusUnauthorized},
+		{"/webhook/weather", "Bearer s3cret", `{"values": {"gust-knots": 1}}`, http.StatusBadRequest},
+		{"/webhook/weather", "Bearer s3cret", `not json`, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handleWebhook(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("%s with %q: got %d, expected %d", tc.path, tc.body, rec.Code, tc.code)
+		}
+	}
+
+	evs := takeWebhookEvents()
+	if len(evs) != 2 {
+		t.Fatalf("expected two events, got %d", len(evs))
+	}
+	if v := evs[0].post.Values["gust_knots"]; v != 38 {
+		t.Errorf("gust_knots: got %v, expected 38", v)
+	}
+	if v := evs[1].post.Values["value"]; v != 1 || evs[1].post.Alarm != "Gale warning" {
+		t.Errorf("unexpected second event %+v", evs[1].post)
+	}
+	if len(takeWebhookEvents()) != 0 {
+		t.Error("expected the events to be taken")
+	}
+
+	// Posts not taken in time are dropped, oldest first.
+	for i := 0; i < webhookMaxPending+10; i++ {
+		v := float64(i)
+		if code, err := queueWebhook("weather", "s3cret", webhookPost{Value: &v}); err != nil {
+			t.Fatal(code, err)
+		}
+	}
+	evs = takeWebhookEvents()
+	if len(evs) != webhookMaxPending || evs[0].post.Values["value"] != 10 {
+		t.Errorf("expected the last %d posts, got %d from %v", webhookMaxPending, len(evs), evs[0].post.Values)
+	}
+
+	if err := validateWebhooks(map[string]webhookConfig{"door": {}}); err == nil {
+		t.Error("expected error for webhook without secret")
+	}
+}
+
+func TestReloadWebhooks(t *testing.T) {
+	def, _ := sensorDefByName("webhooks")
+	prevDefs := sensorDefs
+	sensorDefs = []sensorDef{def}
+	defer func() { sensorDefs = prevDefs }()
+	prevConfig := current.Load()
+	defer current.Store(prevConfig)
+	defer setWebhooks(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var running runningSensors
+	setConfig(options{}, fileSections{Webhooks: map[string]webhookConfig{"a": {Secret: "one"}}})
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	setConfig(options{}, fileSections{Webhooks: map[string]webhookConfig{"a": {Secret: "two"}}})
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if code, err := queueWebhook("a", "two", webhookPost{}); err != nil {
+		t.Errorf("new secret refused after the reload: %d %v", code, err)
+	}
+	if code, _ := queueWebhook("a", "one", webhookPost{}); code != http.StatusUnauthorized {
+		t.Errorf("old secret accepted after the reload: %d", code)
+	}
+
+	setConfig(options{}, fileSections{})
+	if err := running.apply(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := queueWebhook("a", "two", webhookPost{}); code != http.StatusUnauthorized {
+		t.Errorf("secret accepted with the webhooks removed: %d", code)
+	}
+}
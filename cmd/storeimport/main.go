@@ -0,0 +1,62 @@
+// Command storeimport backfills the promexp local store from Prometheus
+// query results, InfluxDB line protocol exports or JSON lines logs. Stop
+// promexp while importing; the store has a single writer.
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"github.com/calmh/boatpi/store"
+)
+
+type options struct {
+	StorePath    string   `required:"" placeholder:"PATH" help:"Local store to import into, as given to promexp."`
+	StoreBackend string   `default:"segment" help:"Local store backend."`
+	Format       string   `required:"" enum:"prometheus,influx,jsonl" help:"Format of the files: prometheus (range query JSON), influx (line protocol) or jsonl."`
+	Prefix       string   `default:"sensors_" help:"Import only series with names starting with this."`
+	DropLabels   []string `default:"instance,job,host" placeholder:"LABEL,..." help:"Labels added by the collecting system, removed from the series."`
+	Files        []string `arg:"" optional:"" type:"existingfile" help:"Files to import; standard input if none."`
+}
+
+func main() {
+	var cli options
+	kong.Parse(&cli)
+
+	log.SetFlags(0)
+
+	st, err := store.Open(cli.StoreBackend, cli.StorePath)
+	if err != nil {
+		log.Fatalln("open store:", err)
+	}
+
+	total := 0
+	imp := func(name string, r io.Reader) {
+		n, err := store.Import(st, cli.Format, r, cli.Prefix, cli.DropLabels)
+		total += n
+		if err != nil {
+			st.Close()
+			log.Fatalf("import %s: %v", name, err)
+		}
+		log.Printf("%s: imported %d samples", name, n)
+	}
+
+	if len(cli.Files) == 0 {
+		imp("stdin", os.Stdin)
+	}
+	for _, file := range cli.Files {
+		fd, err := os.Open(file)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		imp(file, fd)
+		fd.Close()
+	}
+
+	if err := st.Close(); err != nil {
+		log.Fatalln("close store:", err)
+	}
+	log.Printf("Imported %d samples in total", total)
+}
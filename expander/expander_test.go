@@ -0,0 +1,117 @@
+package expander
+
+import (
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+// mcpDevice is a fake MCP23017, with inputs reading the pins set in
+// inputs and outputs reading their latch.
+type mcpDevice struct {
+	i2ctest.Registers
+	inputs uint16
+}
+
+func (d *mcpDevice) ReadBlockData(reg uint8, buf []byte) error {
+	for i := range buf {
+		r := reg + uint8(i)
+		if r == mcp23017GPIO || r == mcp23017GPIO+1 {
+			port := r - mcp23017GPIO
+			dir, latch, in := d.Registers[mcp23017IODIR+port], d.Registers[mcp23017OLAT+port], byte(d.inputs>>(8*port))
+			buf[i] = dir&in | ^dir&latch
+			continue
+		}
+		buf[i] = d.Registers[r]
+	}
+	return nil
+}
+
+func TestMCP23017(t *testing.T) {
+	dev := &mcpDevice{Registers: i2ctest.Registers{mcp23017IODIR: 0xff, mcp23017IODIR + 1: 0xff}}
+	s, err := NewMCP23017(i2c.NewBus(dev), MCP23017DefaultAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ConfigureInput(9, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfigureOutput(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if dev.Registers[mcp23017GPPU+1] != 0x02 || dev.Registers[mcp23017IODIR] != 0xfb || dev.Registers[mcp23017IODIR+1] != 0xff {
+		t.Errorf("pull-ups %08b, directions %08b %08b", dev.Registers[mcp23017GPPU+1], dev.Registers[mcp23017IODIR], dev.Registers[mcp23017IODIR+1])
+	}
+	if err := s.ConfigureInput(16, false); err == nil {
+		t.Error("expected error for pin 16")
+	}
+
+	dev.inputs = 1 << 9
+	if err := s.SetLevel(2, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	for pin, high := range map[int]bool{2: true, 9: true, 3: false, 8: false} {
+		if s.Level(pin) != high {
+			t.Errorf("pin %d high %v, expected %v", pin, s.Level(pin), high)
+		}
+	}
+	if r := s.Readings()[9]; r.Name != "pin_9" || r.Value != 1 {
+		t.Errorf("reading %+v", r)
+	}
+}
+
+// pcfDevice is a fake PCF8574 with a pin pulled low from outside.
+type pcfDevice struct {
+	i2c.Device
+	latch   byte
+	grounds byte
+}
+
+func (d *pcfDevice) SetAddress(addr int) error { return nil }
+
+func (d *pcfDevice) WriteBlockData(reg uint8, data []byte) error {
+	d.latch = reg
+	return nil
+}
+
+func (d *pcfDevice) Read(buf []byte) (int, error) {
+	buf[0] = d.latch &^ d.grounds
+	return 1, nil
+}
+
+func TestPCF8574(t *testing.T) {
+	dev := &pcfDevice{}
+	s, err := NewPCF8574(i2c.NewBus(dev), PCF8574DefaultAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.latch != 0xff {
+		t.Errorf("latch %08b at start, expected all inputs", dev.latch)
+	}
+
+	if err := s.ConfigureOutput(0, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ConfigureInput(5, true); err != nil {
+		t.Fatal(err)
+	}
+	dev.grounds = 1 << 5
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.Level(0) || s.Level(5) || !s.Level(1) {
+		t.Errorf("levels %08b", s.levels)
+	}
+
+	if err := s.SetLevel(0, true); err != nil {
+		t.Fatal(err)
+	}
+	if dev.latch != 0xff {
+		t.Errorf("latch %08b after setting pin 0 high", dev.latch)
+	}
+}
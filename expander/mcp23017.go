@@ -0,0 +1,164 @@
+// Package expander drives I2C GPIO expanders, for digital inputs and
+// outputs beyond the few free pins on the Pi: float switches, door
+// switches, shore power sense, relays.
+package expander
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Microchip MCP23017 16 bit I/O expander, with the pins of port A numbered
+// 0 to 7 and those of port B 8 to 15. All pins are inputs at power on. The
+// direction, pull-up and output registers are read, modified and written
+// for each change, so that several users of the chip each configure their
+// own pins.
+
+type MCP23017 struct {
+	bus     *i2c.Bus
+	address int
+
+	mut    sync.Mutex
+	cached time.Time
+	levels uint16
+}
+
+// MCP23017DefaultAddress is the address with the address pins to ground.
+// They select addresses 0x20 to 0x27.
+const MCP23017DefaultAddress = 0x20
+
+// Register addresses with IOCON.BANK clear, the power on default, where the
+// port B register follows that of port A.
+const (
+	mcp23017IODIR = 0x00 // 1: input
+	mcp23017GPPU  = 0x0c // 1: 100k pull-up
+	mcp23017GPIO  = 0x12
+	mcp23017OLAT  = 0x14
+
+	mcp23017Pins = 16
+)
+
+func NewMCP23017(bus *i2c.Bus, addr int) (*MCP23017, error) {
+	s := &MCP23017{bus: bus, address: addr}
+	// Check that it is there.
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		r.Block(mcp23017IODIR, 2)
+		return r.Error()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Pins returns the number of pins.
+func (s *MCP23017) Pins() int {
+	return mcp23017Pins
+}
+
+// ConfigureInput makes the pin an input, with or without the internal
+// pull-up.
+func (s *MCP23017) ConfigureInput(pin int, pullUp bool) error {
+	if err := s.setBit(mcp23017GPPU, pin, pullUp); err != nil {
+		return fmt.Errorf("pin %d: set pull-up: %w", pin, err)
+	}
+	if err := s.setBit(mcp23017IODIR, pin, true); err != nil {
+		return fmt.Errorf("pin %d: set direction: %w", pin, err)
+	}
+	return nil
+}
+
+// ConfigureOutput makes the pin an output, driven high or low.
+func (s *MCP23017) ConfigureOutput(pin int, high bool) error {
+	if err := s.setBit(mcp23017OLAT, pin, high); err != nil {
+		return fmt.Errorf("pin %d: set level: %w", pin, err)
+	}
+	if err := s.setBit(mcp23017IODIR, pin, false); err != nil {
+		return fmt.Errorf("pin %d: set direction: %w", pin, err)
+	}
+	return nil
+}
+
+// SetLevel drives the output pin high or low.
+func (s *MCP23017) SetLevel(pin int, high bool) error {
+	if err := s.setBit(mcp23017OLAT, pin, high); err != nil {
+		return fmt.Errorf("pin %d: set level: %w", pin, err)
+	}
+	return nil
+}
+
+// setBit sets or clears the pin's bit in the pair of port registers at
+// reg.
+func (s *MCP23017) setBit(reg uint8, pin int, set bool) error {
+	if pin < 0 || pin >= mcp23017Pins {
+		return fmt.Errorf("no pin %d (valid: 0 to %d)", pin, mcp23017Pins-1)
+	}
+	return s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(reg, 2)
+		if err := r.Error(); err != nil {
+			return err
+		}
+		v := uint16(data[0]) | uint16(data[1])<<8
+		if set {
+			v |= 1 << pin
+		} else {
+			v &^= 1 << pin
+		}
+		return r.WriteBlock(reg, []byte{byte(v), byte(v >> 8)})
+	})
+}
+
+// Refresh reads the levels of all pins, inputs and outputs alike.
+func (s *MCP23017) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(mcp23017GPIO, 2)
+		s.levels = uint16(data[0]) | uint16(data[1])<<8
+		return r.Error()
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// Level returns whether the pin was high at the last refresh.
+func (s *MCP23017) Level(pin int) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.levels&(1<<pin) != 0
+}
+
+func (s *MCP23017) Info() sensor.Info {
+	return sensor.Info{Chip: "MCP23017", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *MCP23017) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return levelReadings(uint(s.levels), mcp23017Pins)
+}
+
+// levelReadings returns the levels of the pins as readings "pin_0" and
+// onwards.
+func levelReadings(levels uint, pins int) []sensor.Reading {
+	res := make([]sensor.Reading, pins)
+	for i := range res {
+		res[i] = sensor.Reading{Name: fmt.Sprintf("pin_%d", i), Quantity: sensor.State, Value: float64(levels >> i & 1)}
+	}
+	return res
+}
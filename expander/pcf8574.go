@@ -0,0 +1,126 @@
+package expander
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// NXP (or TI) PCF8574 8 bit quasi-bidirectional I/O expander. It has no
+// registers: a byte written sets the pins, and a byte read returns their
+// levels. A pin set high is pulled up weakly and doubles as an input; one
+// set low sinks current. The chip cannot say what was written, so the
+// written byte is kept here, and all users of the chip must share the
+// same PCF8574 value.
+
+type PCF8574 struct {
+	bus     *i2c.Bus
+	address int
+
+	mut    sync.Mutex
+	latch  uint8
+	cached time.Time
+	levels uint8
+}
+
+// PCF8574DefaultAddress is the address of the PCF8574 with the address
+// pins to ground; they select addresses 0x20 to 0x27. The PCF8574A has
+// 0x38 to 0x3f.
+const PCF8574DefaultAddress = 0x20
+
+const pcf8574Pins = 8
+
+// NewPCF8574 returns the PCF8574 at the address, with all pins set high as
+// inputs, as at power on.
+func NewPCF8574(bus *i2c.Bus, addr int) (*PCF8574, error) {
+	s := &PCF8574{bus: bus, address: addr, latch: 0xff}
+	if err := s.write(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Pins returns the number of pins.
+func (s *PCF8574) Pins() int {
+	return pcf8574Pins
+}
+
+// ConfigureInput makes the pin an input by setting it high; the weak
+// pull-up is always there.
+func (s *PCF8574) ConfigureInput(pin int, pullUp bool) error {
+	return s.SetLevel(pin, true)
+}
+
+// ConfigureOutput sets the output pin high or low. High is only weakly
+// driven, so loads should be connected to sink current to a low pin.
+func (s *PCF8574) ConfigureOutput(pin int, high bool) error {
+	return s.SetLevel(pin, high)
+}
+
+// SetLevel sets the pin high or low.
+func (s *PCF8574) SetLevel(pin int, high bool) error {
+	if pin < 0 || pin >= pcf8574Pins {
+		return fmt.Errorf("no pin %d (valid: 0 to %d)", pin, pcf8574Pins-1)
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if high {
+		s.latch |= 1 << pin
+	} else {
+		s.latch &^= 1 << pin
+	}
+	if err := s.write(); err != nil {
+		return fmt.Errorf("pin %d: set level: %w", pin, err)
+	}
+	return nil
+}
+
+func (s *PCF8574) write() error {
+	return s.bus.Do(s.address, func(dev i2c.Device) error {
+		return i2c.NewReader(dev).WriteBlock(s.latch, nil)
+	})
+}
+
+// Refresh reads the levels of all pins.
+func (s *PCF8574) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		data, err := i2c.NewReader(dev).ReadBytes(1)
+		if err != nil {
+			return err
+		}
+		s.levels = data[0]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// Level returns whether the pin was high at the last refresh.
+func (s *PCF8574) Level(pin int) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.levels&(1<<pin) != 0
+}
+
+func (s *PCF8574) Info() sensor.Info {
+	return sensor.Info{Chip: "PCF8574", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *PCF8574) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return levelReadings(uint(s.levels), pcf8574Pins)
+}
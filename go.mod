@@ -3,7 +3,10 @@ module github.com/calmh/boatpi
 go 1.14
 
 require (
-	github.com/alecthomas/kong v0.2.16 // indirect
+	github.com/alecthomas/kong v0.2.16
+	github.com/mattn/go-sqlite3 v1.14.5
 	github.com/prometheus/client_golang v1.7.1
-	gobot.io/x/gobot v1.14.0
+	github.com/prometheus/client_model v0.2.0
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	gopkg.in/yaml.v2 v2.2.5
 )
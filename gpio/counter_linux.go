@@ -0,0 +1,101 @@
+package gpio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Pulse counting with line events, the kernel timestamping each edge as
+// it interrupts, using the v1 line event ABI.
+
+const ioctlGetLineEvent = 0xc030b404 // GPIO_GET_LINEEVENT_IOCTL
+
+type eventRequest struct {
+	lineOffset    uint32
+	handleFlags   uint32
+	eventFlags    uint32
+	consumerLabel [32]byte
+	fd            int32
+}
+
+// eventSize is the size of struct gpioevent_data: the timestamp in
+// nanoseconds, the event ID and padding.
+const eventSize = 16
+
+// A Counter counts the edges on an input line, in the background, from
+// when it is requested until it is closed.
+type Counter struct {
+	fd   *os.File
+	done chan struct{}
+
+	mut   sync.Mutex
+	count uint64
+	last  time.Duration // timestamp of the last edge
+}
+
+// RequestCounter requests the line at offset on the given chip as an
+// input and starts counting the edges.
+func RequestCounter(chip string, offset int, edges Edge) (*Counter, error) {
+	fd, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	req := eventRequest{lineOffset: uint32(offset), handleFlags: handleRequestInput, eventFlags: uint32(edges)}
+	copy(req.consumerLabel[:], "boatpi")
+	if err := ioctl(fd.Fd(), ioctlGetLineEvent, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("request line %d events: %w", offset, err)
+	}
+	// Non-blocking, for the runtime poller to wait for events, so that
+	// Close interrupts the wait.
+	if err := syscall.SetNonblock(int(req.fd), true); err != nil {
+		syscall.Close(int(req.fd))
+		return nil, fmt.Errorf("request line %d events: %w", offset, err)
+	}
+
+	c := &Counter{
+		fd:   os.NewFile(uintptr(req.fd), fmt.Sprintf("%s:%d", chip, offset)),
+		done: make(chan struct{}),
+	}
+	go c.serve()
+	return c, nil
+}
+
+func (c *Counter) serve() {
+	defer close(c.done)
+	buf := make([]byte, 64*eventSize)
+	for {
+		n, err := c.fd.Read(buf)
+		if err != nil {
+			return
+		}
+		c.mut.Lock()
+		for i := 0; i+eventSize <= n; i += eventSize {
+			c.count++
+			// Native byte order, which is little endian on the Pi.
+			c.last = time.Duration(binary.LittleEndian.Uint64(buf[i:]))
+		}
+		c.mut.Unlock()
+	}
+}
+
+// Pulses returns the number of edges counted and the time of the last
+// one. The time is by the kernel's clock, only good for the time between
+// edges.
+func (c *Counter) Pulses() (count uint64, last time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.count, c.last
+}
+
+func (c *Counter) Close() error {
+	err := c.fd.Close()
+	<-c.done
+	return err
+}
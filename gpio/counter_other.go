@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package gpio
+
+import "time"
+
+type Counter struct{}
+
+func RequestCounter(chip string, offset int, edges Edge) (*Counter, error) {
+	return nil, errUnsupported
+}
+
+func (c *Counter) Pulses() (count uint64, last time.Duration) {
+	return 0, 0
+}
+
+func (c *Counter) Close() error {
+	return nil
+}
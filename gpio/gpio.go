@@ -0,0 +1,12 @@
+// Package gpio reads and drives GPIO lines, and counts pulses on them,
+// through the Linux GPIO character device.
+package gpio
+
+// Edge selects the edges a Counter counts.
+type Edge int
+
+const (
+	RisingEdge Edge = 1 << iota
+	FallingEdge
+	BothEdges = RisingEdge | FallingEdge
+)
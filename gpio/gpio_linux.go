@@ -0,0 +1,107 @@
+package gpio
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Linux GPIO character device (/dev/gpiochipN) access, using the v1 line
+// handle ABI.
+
+const (
+	ioctlGetLineHandle = 0xc16cb403 // GPIO_GET_LINEHANDLE_IOCTL
+	ioctlGetLineValues = 0xc040b408 // GPIOHANDLE_GET_LINE_VALUES_IOCTL
+	ioctlSetLineValues = 0xc040b409 // GPIOHANDLE_SET_LINE_VALUES_IOCTL
+
+	handleRequestInput     = 1 << 0
+	handleRequestOutput    = 1 << 1
+	handleRequestActiveLow = 1 << 2
+)
+
+type handleRequest struct {
+	lineOffsets   [64]uint32
+	flags         uint32
+	defaultValues [64]uint8
+	consumerLabel [32]byte
+	lines         uint32
+	fd            int32
+}
+
+type handleData struct {
+	values [64]uint8
+}
+
+// A Line is a single requested GPIO line.
+type Line struct {
+	fd *os.File
+}
+
+// RequestInput requests the line at offset on the given chip as an input.
+// With activeLow set, a low level on the pin reads as true.
+func RequestInput(chip string, offset int, activeLow bool) (*Line, error) {
+	flags := uint32(handleRequestInput)
+	if activeLow {
+		flags |= handleRequestActiveLow
+	}
+	return requestLine(chip, offset, flags)
+}
+
+// RequestOutput requests the line at offset on the given chip as an
+// output, initially off. With activeLow set, on drives the pin low.
+func RequestOutput(chip string, offset int, activeLow bool) (*Line, error) {
+	flags := uint32(handleRequestOutput)
+	if activeLow {
+		flags |= handleRequestActiveLow
+	}
+	return requestLine(chip, offset, flags)
+}
+
+func requestLine(chip string, offset int, flags uint32) (*Line, error) {
+	fd, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	req := handleRequest{flags: flags, lines: 1}
+	req.lineOffsets[0] = uint32(offset)
+	copy(req.consumerLabel[:], "boatpi")
+	if err := ioctl(fd.Fd(), ioctlGetLineHandle, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("request line %d: %w", offset, err)
+	}
+
+	return &Line{fd: os.NewFile(uintptr(req.fd), fmt.Sprintf("%s:%d", chip, offset))}, nil
+}
+
+func (l *Line) Value() (bool, error) {
+	var data handleData
+	if err := ioctl(l.fd.Fd(), ioctlGetLineValues, unsafe.Pointer(&data)); err != nil {
+		return false, fmt.Errorf("get line value: %w", err)
+	}
+	return data.values[0] != 0, nil
+}
+
+// SetValue sets an output line on or off.
+func (l *Line) SetValue(on bool) error {
+	var data handleData
+	if on {
+		data.values[0] = 1
+	}
+	if err := ioctl(l.fd.Fd(), ioctlSetLineValues, unsafe.Pointer(&data)); err != nil {
+		return fmt.Errorf("set line value: %w", err)
+	}
+	return nil
+}
+
+func (l *Line) Close() error {
+	return l.fd.Close()
+}
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
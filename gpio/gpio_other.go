@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package gpio
+
+import "errors"
+
+var errUnsupported = errors.New("GPIO is only supported on Linux")
+
+type Line struct{}
+
+func RequestInput(chip string, offset int, activeLow bool) (*Line, error) {
+	return nil, errUnsupported
+}
+
+func RequestOutput(chip string, offset int, activeLow bool) (*Line, error) {
+	return nil, errUnsupported
+}
+
+func (l *Line) Value() (bool, error) {
+	return false, errUnsupported
+}
+
+func (l *Line) SetValue(on bool) error {
+	return errUnsupported
+}
+
+func (l *Line) Close() error {
+	return nil
+}
@@ -0,0 +1,119 @@
+package gps
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/calmh/boatpi/nmea"
+)
+
+// Other instruments on the NMEA bus report their alarms in ALR sentences,
+// and AIS or DSC man overboard devices in MOB sentences. The alarms are
+// kept per talker and identifier, for delivery together with our own.
+
+// Alarm is an alarm reported by another instrument.
+type Alarm struct {
+	Talker       string
+	ID           string // alarm number, or the MOB emitter ID
+	Text         string
+	MOB          bool
+	Active       bool
+	Acknowledged bool
+	Test         bool    // MOB device test; not an emergency
+	Lat, Lon     float64 // MOB position, valid with HasPosition
+	HasPosition  bool
+	Raised       time.Time // when the alarm became active
+	Updated      time.Time // when last reported
+}
+
+// Key returns a name identifying the alarm across reports.
+func (a Alarm) Key() string {
+	kind := "alr"
+	if a.MOB {
+		kind = "mob"
+	}
+	return strings.ToLower(kind + "-" + a.Talker + "-" + a.ID)
+}
+
+// handleAlarm updates the alarms from an ALR or MOB sentence, with the
+// lock held. It returns false if the sentence lacks required fields.
+func (g *GPS) handleAlarm(s nmea.Sentence) bool {
+	var a Alarm
+	switch s.Type {
+	case "ALR":
+		// $--ALR,hhmmss.ss,xxx,A,A,text: time, alarm number,
+		// condition (A = threshold exceeded), acknowledged (A) and
+		// description.
+		a = Alarm{
+			Talker:       s.Talker,
+			ID:           s.Field(1),
+			Active:       s.Field(2) == "A",
+			Acknowledged: s.Field(3) == "A",
+			Text:         s.Field(4),
+		}
+		if a.ID == "" || (s.Field(2) != "A" && s.Field(2) != "V") {
+			return false
+		}
+
+	case "MOB":
+		// $--MOB,hhhhh,a,hhmmss.ss,x,xxxxxx,hhmmss.ss,llll.ll,a,
+		// yyyyy.yy,a,...: emitter ID, status (A = activated, T =
+		// test, V = not active), time of activation, position source,
+		// date and time of position, and position.
+		a = Alarm{
+			Talker: s.Talker,
+			ID:     s.Field(0),
+			MOB:    true,
+			Active: s.Field(1) == "A" || s.Field(1) == "T",
+			Test:   s.Field(1) == "T",
+			Text:   "man overboard",
+		}
+		if a.ID == "" {
+			a.ID = "0"
+		}
+		switch s.Field(1) {
+		case "A", "T", "V":
+		default:
+			return false
+		}
+		lat, ok1 := s.Coordinate(6)
+		lon, ok2 := s.Coordinate(8)
+		if ok1 && ok2 {
+			a.Lat, a.Lon, a.HasPosition = lat, lon, true
+		}
+	}
+
+	now := time.Now()
+	if g.alarms == nil {
+		g.alarms = make(map[string]Alarm)
+	}
+	prev, ok := g.alarms[a.Key()]
+	if !a.Active {
+		delete(g.alarms, a.Key())
+		return true
+	}
+	a.Raised = now
+	if ok {
+		a.Raised = prev.Raised
+		if !a.HasPosition && prev.HasPosition {
+			a.Lat, a.Lon, a.HasPosition = prev.Lat, prev.Lon, true
+		}
+	}
+	a.Updated = now
+	g.alarms[a.Key()] = a
+	return true
+}
+
+// Alarms returns the currently active alarms reported by other
+// instruments, ordered by key.
+func (g *GPS) Alarms() []Alarm {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	res := make([]Alarm, 0, len(g.alarms))
+	for _, a := range g.alarms {
+		res = append(res, a)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key() < res[j].Key() })
+	return res
+}
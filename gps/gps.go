@@ -0,0 +1,332 @@
+package gps
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/nmea"
+	"github.com/calmh/boatpi/serial"
+)
+
+// GPS receiver speaking NMEA 0183, either directly on a serial port or via
+// gpsd.
+
+const reconnectDelay = 5 * time.Second
+
+type GPS struct {
+	name string
+	open func() (io.ReadCloser, error)
+	port *serial.Port // for serial receivers, instead of open
+
+	mut        sync.Mutex
+	received   time.Time
+	updated    time.Time
+	lat, lon   float64
+	sog, cog   float64
+	quality    int
+	satellites int
+
+	water        float64
+	waterUpdated time.Time
+
+	alarms map[string]Alarm
+
+	connected  bool
+	reconnects uint64
+
+	forward func(line string)
+	stats   map[string]TalkerStats
+}
+
+// TalkerStats are counters of sentences received from a talker since the
+// GPS was created.
+type TalkerStats = nmea.TalkerStats
+
+// NewSerial reads from a serial port, which may be shared with other
+// consumers and is reopened by the serial package when lost.
+func NewSerial(ctx context.Context, device string, baud int) (*GPS, error) {
+	port, err := serial.Open(device, baud)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", device, err)
+	}
+	g := &GPS{name: device, port: port, stats: make(map[string]TalkerStats)}
+	lines := port.Subscribe(ctx)
+	go func() {
+		defer port.Close()
+		for line := range lines {
+			g.line(line)
+		}
+	}()
+	return g, nil
+}
+
+func NewGPSD(ctx context.Context, addr string) (*GPS, error) {
+	return newGPS(ctx, addr, func() (io.ReadCloser, error) {
+		conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(conn, `?WATCH={"enable":true,"nmea":true}`+"\n"); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("enable watch: %w", err)
+		}
+		return conn, nil
+	})
+}
+
+func newGPS(ctx context.Context, name string, open func() (io.ReadCloser, error)) (*GPS, error) {
+	rc, err := open()
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", name, err)
+	}
+	g := &GPS{name: name, open: open, stats: make(map[string]TalkerStats), connected: true}
+	go g.serve(ctx, rc)
+	return g, nil
+}
+
+func (g *GPS) serve(ctx context.Context, rc io.ReadCloser) {
+	for {
+		// Closing the reader is the only way to interrupt a blocking read.
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				rc.Close()
+			case <-done:
+			}
+		}()
+		if err := g.read(rc); err != nil && ctx.Err() == nil {
+			log.Printf("read %s: %v", g.name, err)
+		}
+		close(done)
+		rc.Close()
+		g.setConnected(false)
+
+		for {
+			select {
+			case <-time.After(reconnectDelay):
+			case <-ctx.Done():
+				return
+			}
+			var err error
+			rc, err = g.open()
+			if err == nil {
+				g.mut.Lock()
+				g.connected = true
+				g.reconnects++
+				g.mut.Unlock()
+				break
+			}
+			log.Printf("open %s: %v", g.name, err)
+		}
+	}
+}
+
+func (g *GPS) read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		g.line(sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// line handles a received line, which may or may not be a sentence.
+func (g *GPS) line(line string) {
+	s, err := nmea.Parse(line)
+	if err == nmea.ErrNotSentence {
+		return
+	}
+	if err == nil && !g.handle(s) {
+		err = nmea.ErrFields
+	}
+	g.mut.Lock()
+	nmea.CountSentence(g.stats, s.Talker, err)
+	forward := g.forward
+	g.mut.Unlock()
+	if err == nil && forward != nil {
+		forward(strings.TrimSpace(line))
+	}
+}
+
+// handle updates the state from the sentence. It returns false if the
+// sentence lacks fields required for its type.
+func (g *GPS) handle(s nmea.Sentence) bool {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	g.received = time.Now()
+	switch s.Type {
+	case "GGA":
+		quality, ok := s.Int(5)
+		if !ok {
+			return false
+		}
+		g.quality = quality
+		if sats, ok := s.Int(6); ok {
+			g.satellites = sats
+		}
+		if quality == 0 {
+			return true
+		}
+		lat, ok1 := s.Coordinate(1)
+		lon, ok2 := s.Coordinate(3)
+		if !ok1 || !ok2 {
+			return false
+		}
+		g.lat, g.lon = lat, lon
+		g.updated = time.Now()
+
+	case "RMC":
+		if s.Field(1) != "A" {
+			return true
+		}
+		lat, ok1 := s.Coordinate(2)
+		lon, ok2 := s.Coordinate(4)
+		if !ok1 || !ok2 {
+			return false
+		}
+		g.lat, g.lon = lat, lon
+		g.updated = time.Now()
+		if sog, ok := s.Float(6); ok {
+			g.sog = sog
+		}
+		if cog, ok := s.Float(7); ok {
+			g.cog = cog
+		}
+
+	case "MTW":
+		// Water temperature, typically from a depth or log transducer
+		// on the same NMEA bus.
+		temp, ok := s.Float(0)
+		if !ok {
+			return false
+		}
+		if s.Field(1) == "C" {
+			g.water = temp
+			g.waterUpdated = time.Now()
+		}
+
+	case "ALR", "MOB":
+		return g.handleAlarm(s)
+	}
+	return true
+}
+
+func (g *GPS) setConnected(connected bool) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.connected = connected
+}
+
+// Name returns the device or gpsd address the GPS reads from.
+func (g *GPS) Name() string {
+	return g.name
+}
+
+// Connected returns whether the device or gpsd connection is open. A
+// connected receiver may still be silent; see Received.
+func (g *GPS) Connected() bool {
+	if g.port != nil {
+		return g.port.Status().Connected
+	}
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.connected
+}
+
+// Reconnects returns the number of times the device or gpsd connection
+// has been opened again after being lost.
+func (g *GPS) Reconnects() uint64 {
+	if g.port != nil {
+		return g.port.Status().Reconnects
+	}
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.reconnects
+}
+
+// Stats returns the sentence counters per talker.
+func (g *GPS) Stats() map[string]TalkerStats {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	stats := make(map[string]TalkerStats, len(g.stats))
+	for talker, st := range g.stats {
+		stats[talker] = st
+	}
+	return stats
+}
+
+// SetForwarder sets a function to be called with every sentence received,
+// from the goroutine reading the input. It must not block.
+func (g *GPS) SetForwarder(fn func(line string)) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.forward = fn
+}
+
+// Received returns the time the last sentence was received, valid or not.
+func (g *GPS) Received() time.Time {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.received
+}
+
+// Updated returns the time of the last valid position fix.
+func (g *GPS) Updated() time.Time {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.updated
+}
+
+func (g *GPS) Position() (lat, lon float64) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.lat, g.lon
+}
+
+// SpeedOverGround returns the speed over ground in knots.
+func (g *GPS) SpeedOverGround() float64 {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.sog
+}
+
+// CourseOverGround returns the true course over ground in degrees.
+func (g *GPS) CourseOverGround() float64 {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.cog
+}
+
+// FixQuality returns the GGA fix quality indicator (0 = no fix, 1 = GPS,
+// 2 = DGPS, ...).
+func (g *GPS) FixQuality() int {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.quality
+}
+
+func (g *GPS) Satellites() int {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.satellites
+}
+
+// WaterTemperature returns the last water temperature (MTW) seen on the
+// NMEA input, in degrees Celsius, and the time it was received. The time
+// is zero if no such sentence has been seen.
+func (g *GPS) WaterTemperature() (float64, time.Time) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return g.water, g.waterUpdated
+}
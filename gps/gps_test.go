@@ -0,0 +1,73 @@
+package gps
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestReadStats(t *testing.T) {
+	input := strings.Join([]string{
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47",
+		"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*00",
+		"$GPGGA,123519,,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,",
+		"$IIMTW,12.5,C",
+		"$I\x01MTW,12.5,C*00",
+		`{"class":"TPV"}`,
+	}, "\n")
+	var forwarded []string
+	g := &GPS{stats: make(map[string]TalkerStats)}
+	g.SetForwarder(func(line string) { forwarded = append(forwarded, line) })
+	g.read(strings.NewReader(input))
+
+	stats := g.Stats()
+	if st := stats["GP"]; st != (TalkerStats{Sentences: 1, Checksum: 1, Fields: 1}) {
+		t.Errorf("unexpected GP stats %+v", st)
+	}
+	if st := stats["II"]; st != (TalkerStats{Sentences: 1}) {
+		t.Errorf("unexpected II stats %+v", st)
+	}
+	if st := stats["unknown"]; st != (TalkerStats{Checksum: 1}) {
+		t.Errorf("unexpected unknown stats %+v", st)
+	}
+	if len(forwarded) != 2 {
+		t.Errorf("expected only valid sentences forwarded, got %q", forwarded)
+	}
+	if lat, _ := g.Position(); math.Abs(lat-48.1173) > 1e-6 {
+		t.Errorf("unexpected latitude %v", lat)
+	}
+}
+
+func TestAlarmSentences(t *testing.T) {
+	input := strings.Join([]string{
+		"$IIALR,101500.00,012,A,V,Shallow water",
+		"$AIMOB,12345,A,101510.00,1,150620,101510.00,5741.600,N,01155.400,E,90.0,1.5",
+		"$AIMOB,54321,T,101510.00,1,,,,,,,,,,",
+		"$IIALR,101500.00,013,X,V,Bad condition",
+	}, "\n")
+	g := &GPS{stats: make(map[string]TalkerStats)}
+	g.read(strings.NewReader(input))
+
+	list := g.Alarms()
+	if len(list) != 3 {
+		t.Fatalf("expected three alarms, got %+v", list)
+	}
+	if a := list[0]; a.Key() != "alr-ii-012" || !a.Active || a.Acknowledged || a.Text != "Shallow water" {
+		t.Errorf("unexpected ALR alarm %+v", a)
+	}
+	if a := list[1]; a.Key() != "mob-ai-12345" || !a.HasPosition || math.Abs(a.Lat-57.693333) > 1e-6 || math.Abs(a.Lon-11.923333) > 1e-6 {
+		t.Errorf("unexpected MOB alarm %+v", a)
+	}
+	if a := list[2]; !a.Test || a.HasPosition {
+		t.Errorf("unexpected MOB test %+v", a)
+	}
+	if st := g.Stats()["II"]; st != (TalkerStats{Sentences: 1, Fields: 1}) {
+		t.Errorf("unexpected II stats %+v", st)
+	}
+
+	// Clearing removes the alarm.
+	g.read(strings.NewReader("$IIALR,101600.00,012,V,A,Shallow water"))
+	if list := g.Alarms(); len(list) != 2 || list[0].MOB != true {
+		t.Errorf("expected ALR alarm cleared, got %+v", list)
+	}
+}
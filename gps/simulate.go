@@ -0,0 +1,126 @@
+package gps
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/nmea"
+)
+
+// A Simulation describes a boat for the simulated NMEA source, which lets
+// navigation features be tried out without a receiver.
+type Simulation struct {
+	Route    [][2]float64  // waypoints (latitude, longitude) sailed in a loop; the first is the start
+	Speed    float64       // knots
+	Heading  float64       // degrees true, used when there is only one waypoint
+	Depth    float64       // metres, sent as DPT
+	Interval time.Duration // between fixes, default one second
+}
+
+// NewSimulator returns a GPS reading sentences from a simulated boat.
+func NewSimulator(ctx context.Context, sim Simulation) (*GPS, error) {
+	if len(sim.Route) == 0 {
+		return nil, fmt.Errorf("simulator: no starting position")
+	}
+	if sim.Interval <= 0 {
+		sim.Interval = time.Second
+	}
+	s := &simulator{
+		Simulation: sim,
+		lat:        sim.Route[0][0],
+		lon:        sim.Route[0][1],
+		heading:    sim.Heading,
+		next:       1 % len(sim.Route),
+	}
+	return newGPS(ctx, "simulator", func() (io.ReadCloser, error) {
+		return s.open(ctx), nil
+	})
+}
+
+type simulator struct {
+	Simulation
+
+	mut      sync.Mutex
+	lat, lon float64
+	heading  float64
+	next     int // index of the waypoint being sailed to
+}
+
+// open returns a reader producing a burst of sentences every interval
+// until it is closed or the context is done.
+func (s *simulator) open(ctx context.Context) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		t := time.NewTicker(s.Interval)
+		defer t.Stop()
+		last := time.Now()
+		for {
+			select {
+			case now := <-t.C:
+				s.step(now.Sub(last))
+				last = now
+				if _, err := io.WriteString(pw, s.sentences(now.UTC())); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				pw.Close()
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// step moves the boat along for the given time. Distances are short enough
+// for a flat earth.
+func (s *simulator) step(d time.Duration) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	dist := s.Speed * d.Hours() // nautical miles
+	for len(s.Route) > 1 && dist > 0 {
+		wp := s.Route[s.next]
+		dy := (wp[0] - s.lat) * 60
+		dx := (wp[1] - s.lon) * 60 * math.Cos(s.lat/180*math.Pi)
+		s.heading = math.Mod(math.Atan2(dx, dy)/math.Pi*180+360, 360)
+		left := math.Hypot(dx, dy)
+		if left > dist {
+			break
+		}
+		s.lat, s.lon = wp[0], wp[1]
+		s.next = (s.next + 1) % len(s.Route)
+		dist -= left
+	}
+	rad := s.heading / 180 * math.Pi
+	s.lat += dist * math.Cos(rad) / 60
+	s.lon += dist * math.Sin(rad) / 60 / math.Cos(s.lat/180*math.Pi)
+}
+
+func (s *simulator) sentences(now time.Time) string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	tod := now.Format("150405.00")
+	lat := formatCoordinate(s.lat, 2, "N", "S")
+	lon := formatCoordinate(s.lon, 3, "E", "W")
+	sog := fmt.Sprintf("%.1f", s.Speed)
+	cog := fmt.Sprintf("%.1f", s.heading)
+	return nmea.FormatSentence("GPGGA", tod, lat, lon, "1", "08", "0.9", "0.0", "M", "0.0", "M", "", "") + "\r\n" +
+		nmea.FormatSentence("GPRMC", tod, "A", lat, lon, sog, cog, now.Format("020106"), "", "") + "\r\n" +
+		nmea.FormatSentence("SDDPT", fmt.Sprintf("%.1f", s.Depth), "0.0") + "\r\n"
+}
+
+// formatCoordinate formats signed decimal degrees as the (d)ddmm.mmmm and
+// hemisphere fields.
+func formatCoordinate(v float64, degDigits int, pos, neg string) string {
+	hemi := pos
+	if v < 0 {
+		v, hemi = -v, neg
+	}
+	deg := math.Floor(v)
+	return fmt.Sprintf("%0*.0f%07.4f,%s", degDigits, deg, (v-deg)*60, hemi)
+}
@@ -0,0 +1,53 @@
+package gps
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulatorSentences(t *testing.T) {
+	s := &simulator{
+		Simulation: Simulation{Speed: 6, Depth: 12.5},
+		lat:        57.5,
+		lon:        -11.25,
+		heading:    90,
+	}
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	g := &GPS{stats: make(map[string]TalkerStats)}
+	g.read(strings.NewReader(s.sentences(now)))
+	if st := g.Stats()["GP"]; st != (TalkerStats{Sentences: 2}) {
+		t.Errorf("unexpected GP stats %+v", st)
+	}
+	if st := g.Stats()["SD"]; st != (TalkerStats{Sentences: 1}) {
+		t.Errorf("unexpected SD stats %+v", st)
+	}
+	if lat, lon := g.Position(); math.Abs(lat-57.5) > 1e-6 || math.Abs(lon+11.25) > 1e-6 {
+		t.Errorf("unexpected position %v, %v", lat, lon)
+	}
+	if sog, cog := g.SpeedOverGround(), g.CourseOverGround(); sog != 6 || cog != 90 {
+		t.Errorf("unexpected speed %v and course %v", sog, cog)
+	}
+}
+
+func TestSimulatorStep(t *testing.T) {
+	// Due north at six knots is a tenth of a degree of latitude an hour.
+	s := &simulator{Simulation: Simulation{Speed: 6}, lat: 57}
+	s.step(time.Hour)
+	if math.Abs(s.lat-57.1) > 1e-9 || s.lon != 0 {
+		t.Errorf("unexpected position %v, %v", s.lat, s.lon)
+	}
+
+	// With a route, the boat turns at the waypoint and continues towards
+	// the next one, here back to the start.
+	s = &simulator{
+		Simulation: Simulation{Speed: 6, Route: [][2]float64{{0, 0}, {0.05, 0}}},
+		next:       1,
+	}
+	s.step(45 * time.Minute)
+	if math.Abs(s.lat-0.025) > 1e-9 || s.next != 0 || math.Abs(s.heading-180) > 1e-9 {
+		t.Errorf("unexpected position %v, waypoint %d, heading %v", s.lat, s.next, s.heading)
+	}
+}
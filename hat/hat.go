@@ -0,0 +1,191 @@
+// Package hat reads Raspberry Pi HAT identification EEPROMs.
+//
+// The EEPROM format is described in the raspberrypi/hats repository. The
+// firmware reads the EEPROM of an attached HAT at boot and publishes the
+// vendor information in the device tree, which is the easiest place to get
+// it from. Boards on the regular I2C bus can carry an EEPROM in the same
+// format, which is read directly.
+package hat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+// DeviceTreeDir is where the firmware publishes the HAT vendor information.
+var DeviceTreeDir = "/proc/device-tree/hat"
+
+type Info struct {
+	Vendor         string
+	Product        string
+	ProductID      uint16
+	ProductVersion uint16
+	UUID           string
+	Custom         [][]byte // custom data atoms, only read from the EEPROM
+}
+
+const (
+	signature  = 0x69502d52 // "R-Pi"
+	headerSize = 12
+	atomHeader = 8
+	crcSize    = 2
+
+	atomVendorInfo = 0x0001
+	atomCustomData = 0x0004
+)
+
+// FromDeviceTree returns the vendor information of the attached HAT as
+// published by the firmware.
+func FromDeviceTree(dir string) (Info, error) {
+	var info Info
+	for _, f := range []struct {
+		name string
+		str  *string
+		num  *uint16
+	}{
+		{name: "vendor", str: &info.Vendor},
+		{name: "product", str: &info.Product},
+		{name: "uuid", str: &info.UUID},
+		{name: "product_id", num: &info.ProductID},
+		{name: "product_ver", num: &info.ProductVersion},
+	} {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, f.name))
+		if err != nil {
+			return Info{}, err
+		}
+		val := strings.TrimRight(string(bs), "\x00\n")
+		if f.str != nil {
+			*f.str = val
+			continue
+		}
+		n, err := strconv.ParseUint(val, 0, 16)
+		if err != nil {
+			return Info{}, fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.num = uint16(n)
+	}
+	return info, nil
+}
+
+// ReadEEPROM reads and parses an EEPROM with 16 bit addressing, such as a
+// 24C32, at the given address on the bus.
+func ReadEEPROM(bus *i2c.Bus, addr int) (Info, error) {
+	var data []byte
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		hdr := r.Block16(0, headerSize)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read header: %w", err)
+		}
+		if binary.LittleEndian.Uint32(hdr) != signature {
+			return errors.New("no HAT EEPROM signature")
+		}
+		size := binary.LittleEndian.Uint32(hdr[8:])
+		if size < headerSize || size > 1<<16 {
+			return fmt.Errorf("implausible EEPROM data length %d", size)
+		}
+		data = r.Block16(0, int(size))
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Info{}, err
+	}
+	return Parse(data)
+}
+
+// Parse parses the contents of a HAT EEPROM.
+func Parse(data []byte) (Info, error) {
+	if len(data) < headerSize || binary.LittleEndian.Uint32(data) != signature {
+		return Info{}, errors.New("no HAT EEPROM signature")
+	}
+	natoms := int(binary.LittleEndian.Uint16(data[6:]))
+	size := int(binary.LittleEndian.Uint32(data[8:]))
+	if size > len(data) {
+		return Info{}, fmt.Errorf("short EEPROM data: %d < %d bytes", len(data), size)
+	}
+	data = data[:size]
+
+	var info Info
+	var vendor bool
+	offs := headerSize
+	for i := 0; i < natoms; i++ {
+		if offs+atomHeader > len(data) {
+			return Info{}, fmt.Errorf("atom %d: truncated header", i)
+		}
+		typ := binary.LittleEndian.Uint16(data[offs:])
+		dlen := int(binary.LittleEndian.Uint32(data[offs+4:]))
+		end := offs + atomHeader + dlen
+		if dlen < crcSize || end > len(data) {
+			return Info{}, fmt.Errorf("atom %d: bad length %d", i, dlen)
+		}
+		if crc16(data[offs:end-crcSize]) != binary.LittleEndian.Uint16(data[end-crcSize:]) {
+			return Info{}, fmt.Errorf("atom %d: CRC mismatch", i)
+		}
+		body := data[offs+atomHeader : end-crcSize]
+
+		switch typ {
+		case atomVendorInfo:
+			if err := parseVendorInfo(body, &info); err != nil {
+				return Info{}, fmt.Errorf("atom %d: %w", i, err)
+			}
+			vendor = true
+		case atomCustomData:
+			info.Custom = append(info.Custom, body)
+		}
+		offs = end
+	}
+	if !vendor {
+		return Info{}, errors.New("no vendor info atom")
+	}
+	return info, nil
+}
+
+func parseVendorInfo(data []byte, info *Info) error {
+	const fixed = 16 + 2 + 2 + 1 + 1
+	if len(data) < fixed {
+		return errors.New("short vendor info")
+	}
+	vslen, pslen := int(data[20]), int(data[21])
+	if len(data) < fixed+vslen+pslen {
+		return errors.New("short vendor info strings")
+	}
+
+	// The UUID is stored as four little endian 32 bit words, least
+	// significant first.
+	var uuid [16]byte
+	for i := 0; i < 4; i++ {
+		binary.BigEndian.PutUint32(uuid[12-4*i:], binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	info.UUID = fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+	info.ProductID = binary.LittleEndian.Uint16(data[16:])
+	info.ProductVersion = binary.LittleEndian.Uint16(data[18:])
+	info.Vendor = string(data[fixed : fixed+vslen])
+	info.Product = string(data[fixed+vslen : fixed+vslen+pslen])
+	return nil
+}
+
+// crc16 is the CRC-16/ARC used by the EEPROM format.
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,92 @@
+package hat
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	if crc := crc16([]byte("123456789")); crc != 0xbb3d {
+		t.Errorf("crc16 = %04x, expected bb3d", crc)
+	}
+}
+
+func atom(typ uint16, count uint16, body []byte) []byte {
+	bs := make([]byte, atomHeader, atomHeader+len(body)+crcSize)
+	binary.LittleEndian.PutUint16(bs, typ)
+	binary.LittleEndian.PutUint16(bs[2:], count)
+	binary.LittleEndian.PutUint32(bs[4:], uint32(len(body)+crcSize))
+	bs = append(bs, body...)
+	var crc [2]byte
+	binary.LittleEndian.PutUint16(crc[:], crc16(bs))
+	return append(bs, crc[:]...)
+}
+
+func TestParse(t *testing.T) {
+	vendor := make([]byte, 22)
+	for i := 0; i < 16; i++ {
+		vendor[i] = byte(i)
+	}
+	binary.LittleEndian.PutUint16(vendor[16:], 0x0001)
+	binary.LittleEndian.PutUint16(vendor[18:], 0x0002)
+	vendor[20], vendor[21] = 6, 5
+	vendor = append(vendor, "boatpi"...)
+	vendor = append(vendor, "omini"...)
+
+	atoms := append(atom(atomVendorInfo, 0, vendor), atom(atomCustomData, 1, []byte("omini,ds18b20"))...)
+	data := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(data, signature)
+	data[4] = 1
+	binary.LittleEndian.PutUint16(data[6:], 2)
+	binary.LittleEndian.PutUint32(data[8:], uint32(headerSize+len(atoms)))
+	data = append(data, atoms...)
+
+	info, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Vendor != "boatpi" || info.Product != "omini" || info.ProductID != 1 || info.ProductVersion != 2 {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if info.UUID != "0f0e0d0c-0b0a-0908-0706-050403020100" {
+		t.Errorf("unexpected UUID %s", info.UUID)
+	}
+	if len(info.Custom) != 1 || string(info.Custom[0]) != "omini,ds18b20" {
+		t.Errorf("unexpected custom data %q", info.Custom)
+	}
+
+	data[len(data)-1] ^= 0xff
+	if _, err := Parse(data); err == nil {
+		t.Error("expected CRC error")
+	}
+}
+
+func TestFromDeviceTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, val := range map[string]string{
+		"vendor":      "Raspberry Pi\x00",
+		"product":     "Sense HAT\x00",
+		"product_id":  "0x0001\x00",
+		"product_ver": "0x0001\x00",
+		"uuid":        "f6fd5e4a-0a8f-4c55-a0f5-2e4a2c8a2f1c\x00",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(val), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := FromDeviceTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Vendor != "Raspberry Pi" || info.Product != "Sense HAT" || info.ProductID != 1 {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
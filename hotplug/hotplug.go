@@ -0,0 +1,48 @@
+// Package hotplug reports devices appearing and disappearing, such as USB
+// serial adapters and CAN interfaces, as announced by the kernel.
+package hotplug
+
+import (
+	"bytes"
+	"strings"
+)
+
+// An Event is a kernel uevent.
+type Event struct {
+	Action    string // "add", "remove", "change", ...
+	Subsystem string // "tty", "net", "usb", ...
+	DevPath   string // in /sys, such as /devices/platform/.../ttyUSB0
+	DevName   string // in /dev, such as ttyUSB0; empty for network interfaces
+	Interface string // for network interfaces, such as can0
+	Env       map[string]string
+
+	// Lost is set, and the rest unset, when events were dropped because
+	// they came faster than they were read. Any device may have appeared
+	// or disappeared.
+	Lost bool
+}
+
+// parseUevent parses a kernel uevent message, "action@devpath" followed
+// by NUL separated KEY=value pairs.
+func parseUevent(msg []byte) (Event, bool) {
+	fields := bytes.Split(msg, []byte{0})
+	if len(fields) < 2 || !bytes.ContainsRune(fields[0], '@') {
+		return Event{}, false
+	}
+	ev := Event{Env: make(map[string]string)}
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(string(f), "=", 2)
+		if len(kv) == 2 {
+			ev.Env[kv[0]] = kv[1]
+		}
+	}
+	ev.Action = ev.Env["ACTION"]
+	ev.Subsystem = ev.Env["SUBSYSTEM"]
+	ev.DevPath = ev.Env["DEVPATH"]
+	ev.DevName = ev.Env["DEVNAME"]
+	ev.Interface = ev.Env["INTERFACE"]
+	if ev.Action == "" || ev.DevPath == "" {
+		return Event{}, false
+	}
+	return ev, true
+}
@@ -0,0 +1,37 @@
+package hotplug
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUevent(t *testing.T) {
+	msg := strings.Join([]string{
+		"add@/devices/platform/scb/fd500000.pcie/usb1/1-1/1-1.3/1-1.3:1.0/ttyUSB0/tty/ttyUSB0",
+		"ACTION=add",
+		"DEVPATH=/devices/platform/scb/fd500000.pcie/usb1/1-1/1-1.3/1-1.3:1.0/ttyUSB0/tty/ttyUSB0",
+		"SUBSYSTEM=tty",
+		"MAJOR=188",
+		"MINOR=0",
+		"DEVNAME=ttyUSB0",
+		"SEQNUM=2265",
+		"",
+	}, "\x00")
+	ev, ok := parseUevent([]byte(msg))
+	if !ok {
+		t.Fatal("expected event")
+	}
+	if ev.Action != "add" || ev.Subsystem != "tty" || ev.DevName != "ttyUSB0" || ev.Env["MAJOR"] != "188" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	msg = "add@/devices/platform/soc/usb/net/can0\x00ACTION=add\x00DEVPATH=/devices/platform/soc/usb/net/can0\x00SUBSYSTEM=net\x00INTERFACE=can0\x00IFINDEX=4\x00"
+	if ev, ok := parseUevent([]byte(msg)); !ok || ev.Subsystem != "net" || ev.Interface != "can0" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	// udev's own messages start with a binary header.
+	if _, ok := parseUevent([]byte("libudev\x00\xfe\xed\xca\xfe")); ok {
+		t.Error("expected udev message ignored")
+	}
+}
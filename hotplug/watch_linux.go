@@ -0,0 +1,71 @@
+package hotplug
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+)
+
+// Watch returns the uevents announced by the kernel until the context is
+// done or reading them fails. Device nodes and their /dev/serial/by-id links are created by udev
+// shortly after the event, so users should allow a moment before opening
+// them.
+func Watch(ctx context.Context) (<-chan Event, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	// Group 1 is the kernel's own events; group 2 is udev's, which
+	// requires udev to be running.
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+	// Non blocking, so that the read is handled by the runtime poller and
+	// interrupted by closing the file.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), "netlink")
+
+	events := make(chan Event)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		defer close(events)
+		buf := make([]byte, 16<<10)
+		for {
+			n, err := f.Read(buf)
+			var ev Event
+			switch {
+			case errors.Is(err, syscall.ENOBUFS):
+				// A burst of events, such as from plugging in a
+				// USB hub, overflowed the socket buffer.
+				ev = Event{Lost: true}
+			case err != nil:
+				if ctx.Err() == nil {
+					log.Println("hotplug: read netlink:", err)
+				}
+				return
+			default:
+				var ok bool
+				ev, ok = parseUevent(buf[:n])
+				if !ok {
+					continue
+				}
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
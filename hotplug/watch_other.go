@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package hotplug
+
+import (
+	"context"
+	"errors"
+)
+
+func Watch(ctx context.Context) (<-chan Event, error) {
+	return nil, errors.New("hotplug events are only supported on Linux")
+}
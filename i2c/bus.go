@@ -0,0 +1,30 @@
+package i2c
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A Bus serializes access to a Device shared by several drivers, so that
+// setting the slave address and the transaction that follows cannot be
+// interleaved with those of another goroutine.
+type Bus struct {
+	dev Device
+	mut sync.Mutex
+}
+
+func NewBus(dev Device) *Bus {
+	return &Bus{dev: dev}
+}
+
+// Do sets the slave address and calls fn with exclusive access to the
+// device. The device must not be retained after fn returns.
+func (b *Bus) Do(addr int, fn func(dev Device) error) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if err := b.dev.SetAddress(addr); err != nil {
+		return fmt.Errorf("set device address: %w", err)
+	}
+	return fn(b.dev)
+}
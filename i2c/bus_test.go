@@ -0,0 +1,48 @@
+package i2c
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+type addrCheckDevice struct {
+	Device
+	addr int
+}
+
+func (d *addrCheckDevice) SetAddress(addr int) error {
+	d.addr = addr
+	return nil
+}
+
+func (d *addrCheckDevice) ReadByteData(reg uint8) (uint8, error) {
+	return uint8(d.addr), nil
+}
+
+func TestBusSerializes(t *testing.T) {
+	bus := NewBus(new(addrCheckDevice))
+
+	var wg sync.WaitGroup
+	for addr := 1; addr < 8; addr++ {
+		wg.Add(1)
+		go func(addr int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				err := bus.Do(addr, func(dev Device) error {
+					for j := 0; j < 4; j++ {
+						if v, _ := dev.ReadByteData(0); int(v) != addr {
+							return errors.New("address changed during transaction")
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(addr)
+	}
+	wg.Wait()
+}
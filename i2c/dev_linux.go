@@ -0,0 +1,170 @@
+package i2c
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Linux I2C character device (/dev/i2c-N), supporting combined transactions
+// through the I2C_RDWR ioctl in addition to the usual SMBus operations.
+
+const (
+	ioctlI2CSlave = 0x0703
+	ioctlI2CRdwr  = 0x0707
+	ioctlI2CSMBus = 0x0720
+
+	i2cMsgRead = 0x0001
+
+	smbusRead     = 1
+	smbusWrite    = 0
+	smbusByteData = 2
+	smbusWordData = 3
+)
+
+type i2cMsg struct {
+	addr  uint16
+	flags uint16
+	len   uint16
+	buf   uintptr
+}
+
+type i2cRdwrData struct {
+	msgs  uintptr
+	nmsgs uint32
+}
+
+type smbusData struct {
+	readWrite uint8
+	command   uint8
+	size      uint32
+	data      uintptr
+}
+
+type LinuxDevice struct {
+	fd   *os.File
+	addr int
+}
+
+func Open(path string) (*LinuxDevice, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &LinuxDevice{fd: fd}, nil
+}
+
+func (d *LinuxDevice) Close() error {
+	return d.fd.Close()
+}
+
+func (d *LinuxDevice) SetAddress(address int) error {
+	if err := d.ioctl(ioctlI2CSlave, uintptr(address)); err != nil {
+		return err
+	}
+	d.addr = address
+	return nil
+}
+
+func (d *LinuxDevice) ReadByteData(reg uint8) (uint8, error) {
+	var data [34]byte // union i2c_smbus_data
+	err := d.smbus(smbusRead, reg, smbusByteData, &data)
+	return data[0], err
+}
+
+func (d *LinuxDevice) ReadWordData(reg uint8) (uint16, error) {
+	var data [34]byte
+	err := d.smbus(smbusRead, reg, smbusWordData, &data)
+	return uint16(data[0]) | uint16(data[1])<<8, err
+}
+
+func (d *LinuxDevice) WriteByteData(reg, val uint8) error {
+	var data [34]byte
+	data[0] = val
+	return d.smbus(smbusWrite, reg, smbusByteData, &data)
+}
+
+// ReadBlockData writes the register address and reads len(buf) bytes in
+// one combined transaction, i.e. with a repeated start condition between
+// the write and the read.
+func (d *LinuxDevice) ReadBlockData(reg uint8, buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	return d.transfer([]byte{reg}, buf)
+}
+
+// WriteBlockData writes the register address followed by data in one
+// transaction.
+func (d *LinuxDevice) WriteBlockData(reg uint8, data []byte) error {
+	return d.transfer(append([]byte{reg}, data...), nil)
+}
+
+// ReadRegister16 is like ReadBlockData for a 16 bit register address.
+func (d *LinuxDevice) ReadRegister16(reg uint16, buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	return d.transfer([]byte{byte(reg >> 8), byte(reg)}, buf)
+}
+
+// WriteRegister16 writes data starting at a 16 bit register address.
+func (d *LinuxDevice) WriteRegister16(reg uint16, data []byte) error {
+	return d.transfer(append([]byte{byte(reg >> 8), byte(reg)}, data...), nil)
+}
+
+// Read reads len(buf) bytes from the device, without writing a register
+// address first, as for devices that are sent a command and then read in
+// a separate transaction.
+func (d *LinuxDevice) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	msg := i2cMsg{addr: uint16(d.addr), flags: i2cMsgRead, len: uint16(len(buf)), buf: uintptr(unsafe.Pointer(&buf[0]))}
+	data := i2cRdwrData{msgs: uintptr(unsafe.Pointer(&msg)), nmsgs: 1}
+	err := d.ioctl(ioctlI2CRdwr, uintptr(unsafe.Pointer(&data)))
+	runtime.KeepAlive(buf)
+	runtime.KeepAlive(&msg)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+// transfer writes wr and then, if rd is not empty, reads into rd after a
+// repeated start condition.
+func (d *LinuxDevice) transfer(wr, rd []byte) error {
+	msgs := []i2cMsg{
+		{addr: uint16(d.addr), len: uint16(len(wr)), buf: uintptr(unsafe.Pointer(&wr[0]))},
+	}
+	if len(rd) > 0 {
+		msgs = append(msgs, i2cMsg{addr: uint16(d.addr), flags: i2cMsgRead, len: uint16(len(rd)), buf: uintptr(unsafe.Pointer(&rd[0]))})
+	}
+	data := i2cRdwrData{msgs: uintptr(unsafe.Pointer(&msgs[0])), nmsgs: uint32(len(msgs))}
+	err := d.ioctl(ioctlI2CRdwr, uintptr(unsafe.Pointer(&data)))
+	runtime.KeepAlive(wr)
+	runtime.KeepAlive(rd)
+	runtime.KeepAlive(msgs)
+	return err
+}
+
+func (d *LinuxDevice) smbus(readWrite, command uint8, size uint32, data *[34]byte) error {
+	args := smbusData{
+		readWrite: readWrite,
+		command:   command,
+		size:      size,
+		data:      uintptr(unsafe.Pointer(data)),
+	}
+	err := d.ioctl(ioctlI2CSMBus, uintptr(unsafe.Pointer(&args)))
+	runtime.KeepAlive(data)
+	return err
+}
+
+func (d *LinuxDevice) ioctl(req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.fd.Fd(), req, arg); errno != 0 {
+		return fmt.Errorf("ioctl 0x%04x: %w", req, errno)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package i2c
+
+import "errors"
+
+type LinuxDevice struct {
+	Device
+}
+
+func Open(path string) (*LinuxDevice, error) {
+	return nil, errors.New("I2C devices are only supported on Linux")
+}
+
+func (d *LinuxDevice) Close() error {
+	return nil
+}
@@ -0,0 +1,118 @@
+// Package i2ctest provides fake I2C devices for testing drivers. They
+// implement i2c.Device, i2c.BlockReader and i2c.BlockWriter; a test that
+// needs a device to act on writes, such as by clearing a reset bit or
+// filling a FIFO, embeds one and overrides the methods concerned.
+package i2ctest
+
+// Registers is a device with 8 bit registers. Block reads and writes
+// access consecutive registers, and registers not set read as zero.
+type Registers map[uint8]uint8
+
+func (d Registers) SetAddress(addr int) error { return nil }
+
+func (d Registers) ReadByteData(reg uint8) (uint8, error) {
+	return d[reg], nil
+}
+
+// ReadWordData reads two registers, low byte first, as SMBus does.
+func (d Registers) ReadWordData(reg uint8) (uint16, error) {
+	return uint16(d[reg]) | uint16(d[reg+1])<<8, nil
+}
+
+func (d Registers) WriteByteData(reg, val uint8) error {
+	d[reg] = val
+	return nil
+}
+
+func (d Registers) ReadBlockData(reg uint8, buf []byte) error {
+	for i := range buf {
+		buf[i] = d[reg+uint8(i)]
+	}
+	return nil
+}
+
+func (d Registers) WriteBlockData(reg uint8, data []byte) error {
+	for i, v := range data {
+		d[reg+uint8(i)] = v
+	}
+	return nil
+}
+
+// Set16 sets a 16 bit value in two registers, high byte first.
+func (d Registers) Set16(reg uint8, v uint16) {
+	d[reg], d[reg+1] = byte(v>>8), byte(v)
+}
+
+// Set16LE sets a 16 bit value in two registers, low byte first.
+func (d Registers) Set16LE(reg uint8, v uint16) {
+	d[reg], d[reg+1] = byte(v), byte(v>>8)
+}
+
+// Words is a device with 16 bit registers, such as current monitors and
+// reference thermometers, that transfers them high byte first. Block reads
+// and writes access consecutive registers.
+type Words map[uint8]uint16
+
+func (d Words) SetAddress(addr int) error { return nil }
+
+func (d Words) ReadByteData(reg uint8) (uint8, error) {
+	return byte(d[reg] >> 8), nil
+}
+
+func (d Words) ReadWordData(reg uint8) (uint16, error) {
+	return d[reg], nil
+}
+
+func (d Words) WriteByteData(reg, val uint8) error {
+	d[reg] = uint16(val) << 8
+	return nil
+}
+
+func (d Words) ReadBlockData(reg uint8, buf []byte) error {
+	for i := 0; i+1 < len(buf); i += 2 {
+		v := d[reg+uint8(i/2)]
+		buf[i], buf[i+1] = byte(v>>8), byte(v)
+	}
+	return nil
+}
+
+func (d Words) WriteBlockData(reg uint8, data []byte) error {
+	for i := 0; i+1 < len(data); i += 2 {
+		d[reg+uint8(i/2)] = uint16(data[i])<<8 | uint16(data[i+1])
+	}
+	return nil
+}
+
+// WordsLE is a device with 16 bit registers that transfers them low byte
+// first, as SMBus does.
+type WordsLE map[uint8]uint16
+
+func (d WordsLE) SetAddress(addr int) error { return nil }
+
+func (d WordsLE) ReadByteData(reg uint8) (uint8, error) {
+	return byte(d[reg]), nil
+}
+
+func (d WordsLE) ReadWordData(reg uint8) (uint16, error) {
+	return d[reg], nil
+}
+
+func (d WordsLE) WriteByteData(reg, val uint8) error {
+	d[reg] = uint16(val)
+	return nil
+}
+
+func (d WordsLE) ReadBlockData(reg uint8, buf []byte) error {
+	for i := 0; i+1 < len(buf); i += 2 {
+		v := d[reg+uint8(i/2)]
+		buf[i], buf[i+1] = byte(v), byte(v>>8)
+	}
+	return nil
+}
+
+func (d WordsLE) WriteBlockData(reg uint8, data []byte) error {
+	for i := 0; i+1 < len(data); i += 2 {
+		d[reg+uint8(i/2)] = uint16(data[i]) | uint16(data[i+1])<<8
+	}
+	return nil
+}
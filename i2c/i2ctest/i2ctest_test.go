@@ -0,0 +1,47 @@
+package i2ctest
+
+import (
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+var (
+	_ i2c.Device      = Registers(nil)
+	_ i2c.BlockReader = Registers(nil)
+	_ i2c.BlockWriter = Registers(nil)
+	_ i2c.Device      = Words(nil)
+	_ i2c.BlockReader = Words(nil)
+	_ i2c.BlockWriter = Words(nil)
+	_ i2c.Device      = WordsLE(nil)
+	_ i2c.BlockReader = WordsLE(nil)
+	_ i2c.BlockWriter = WordsLE(nil)
+)
+
+func TestRegisters(t *testing.T) {
+	dev := Registers{}
+	dev.Set16(0x10, 0x1234)
+	r := i2c.NewReader(dev)
+	if data := r.Block(0x10, 2); data[0] != 0x12 || data[1] != 0x34 {
+		t.Errorf("unexpected block %x", data)
+	}
+	if err := r.WriteBlock(0x20, []byte{1, 2}); err != nil || dev[0x20] != 1 || dev[0x21] != 2 {
+		t.Errorf("unexpected registers %v after writing: %v", dev, err)
+	}
+}
+
+func TestWords(t *testing.T) {
+	dev := Words{0x01: 0x1234}
+	r := i2c.NewReader(dev)
+	if data := r.Block(0x01, 2); data[0] != 0x12 || data[1] != 0x34 {
+		t.Errorf("unexpected block %x", data)
+	}
+	le := WordsLE{0x01: 0x1234}
+	r = i2c.NewReader(le)
+	if data := r.Block(0x01, 2); data[0] != 0x34 || data[1] != 0x12 {
+		t.Errorf("unexpected little endian block %x", data)
+	}
+	if err := r.WriteBlock(0x02, []byte{0x78, 0x56}); err != nil || le[0x02] != 0x5678 {
+		t.Errorf("unexpected registers %v after writing: %v", le, err)
+	}
+}
@@ -1,6 +1,13 @@
 package i2c
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // A Device is typically a *sysfs.I2cDevice (gobot.io/x/gobot/sysfs).
 type Device interface {
@@ -10,13 +17,96 @@ type Device interface {
 	WriteByteData(reg, val uint8) error
 }
 
+// A BlockReader can read a number of consecutive registers in one combined
+// transaction, such as a *LinuxDevice.
+type BlockReader interface {
+	ReadBlockData(reg uint8, buf []byte) error
+}
+
+// Transient NAKs are not unusual on long or noisy buses, so a failed
+// operation is retried after a short backoff that grows with each attempt.
+var (
+	defaultsMut    sync.Mutex
+	defaultRetries = 2
+	defaultBackoff = 5 * time.Millisecond
+)
+
+// SetDefaultRetries sets the retry settings for Readers created after the
+// call.
+func SetDefaultRetries(retries int, backoff time.Duration) {
+	defaultsMut.Lock()
+	defer defaultsMut.Unlock()
+	defaultRetries = retries
+	defaultBackoff = backoff
+}
+
+// retried counts retried operations by all Readers.
+var retried uint64
+
+// Retried returns the total number of retried operations since start.
+func Retried() uint64 {
+	return atomic.LoadUint64(&retried)
+}
+
+// A BlockWriter can write a number of consecutive registers in one
+// transaction, such as a *LinuxDevice. Devices with registers wider than a
+// byte need it.
+type BlockWriter interface {
+	WriteBlockData(reg uint8, data []byte) error
+}
+
+// A WideDevice addresses its registers with 16 bits, high byte first, as
+// larger EEPROMs and some ADCs do. *LinuxDevice is one.
+type WideDevice interface {
+	ReadRegister16(reg uint16, buf []byte) error
+	WriteRegister16(reg uint16, data []byte) error
+}
+
 type Reader struct {
-	dev   Device
-	error error
+	dev     Device
+	error   error
+	retries int
+	backoff time.Duration
+	retried int
 }
 
 func NewReader(dev Device) *Reader {
-	return &Reader{dev: dev}
+	defaultsMut.Lock()
+	defer defaultsMut.Unlock()
+	return &Reader{dev: dev, retries: defaultRetries, backoff: defaultBackoff}
+}
+
+// SetRetries sets the number of times a failed operation is retried, and
+// the backoff before the first retry.
+func (r *Reader) SetRetries(retries int, backoff time.Duration) {
+	r.retries = retries
+	r.backoff = backoff
+}
+
+// Retried returns the number of operations retried by this Reader.
+func (r *Reader) Retried() int {
+	return r.retried
+}
+
+func (r *Reader) retry(op func() error) error {
+	err := op()
+	for i := 0; err != nil && i < r.retries; i++ {
+		time.Sleep(r.backoff * time.Duration(i+1))
+		r.retried++
+		atomic.AddUint64(&retried, 1)
+		err = op()
+	}
+	return err
+}
+
+func (r *Reader) readByte(reg uint8) (uint8, error) {
+	var val uint8
+	err := r.retry(func() error {
+		var err error
+		val, err = r.dev.ReadByteData(reg)
+		return err
+	})
+	return val, err
 }
 
 func (r *Reader) Error() error {
@@ -31,7 +121,7 @@ func (r *Reader) Read(regs ...uint8) ([]byte, error) {
 	res := make([]byte, len(regs))
 
 	for i := len(regs) - 1; i >= 0; i-- {
-		val, err := r.dev.ReadByteData(regs[i])
+		val, err := r.readByte(regs[i])
 		if err != nil {
 			return nil, fmt.Errorf("read byte register: %w", err)
 		}
@@ -40,6 +130,168 @@ func (r *Reader) Read(regs ...uint8) ([]byte, error) {
 	return res, nil
 }
 
+// ReadBlock reads n bytes starting at the given register. Devices that do
+// not implement BlockReader but support raw reads and writes (such as
+// *sysfs.I2cDevice) get a register write followed by a separate read;
+// others fall back to reading the registers one by one. Many devices only
+// auto-increment the register address when told to, typically by setting
+// the high bit of the register address.
+func (r *Reader) ReadBlock(reg uint8, n int) ([]byte, error) {
+	buf := make([]byte, n)
+
+	switch dev := r.dev.(type) {
+	case BlockReader:
+		if err := r.retry(func() error { return dev.ReadBlockData(reg, buf) }); err != nil {
+			return nil, fmt.Errorf("read block: %w", err)
+		}
+
+	case io.ReadWriter:
+		err := r.retry(func() error {
+			if _, err := dev.Write([]byte{reg}); err != nil {
+				return fmt.Errorf("write register address: %w", err)
+			}
+			if _, err := io.ReadFull(dev, buf); err != nil {
+				return fmt.Errorf("read block: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		for i := range buf {
+			val, err := r.readByte(reg + uint8(i))
+			if err != nil {
+				return nil, fmt.Errorf("read byte register: %w", err)
+			}
+			buf[i] = val
+		}
+	}
+
+	return buf, nil
+}
+
+// WriteBlock writes data starting at the given register, in one
+// transaction. As with ReadBlock, devices that support raw writes get the
+// register address and data in one write.
+func (r *Reader) WriteBlock(reg uint8, data []byte) error {
+	switch dev := r.dev.(type) {
+	case BlockWriter:
+		if err := r.retry(func() error { return dev.WriteBlockData(reg, data) }); err != nil {
+			return fmt.Errorf("write block: %w", err)
+		}
+
+	case io.Writer:
+		buf := append([]byte{reg}, data...)
+		if err := r.retry(func() error { _, err := dev.Write(buf); return err }); err != nil {
+			return fmt.Errorf("write block: %w", err)
+		}
+
+	default:
+		return errors.New("device does not support block writes")
+	}
+
+	return nil
+}
+
+// ReadBlock16 reads n bytes starting at the given 16 bit register address.
+// As with ReadBlock, devices that support raw reads and writes get a
+// register write followed by a separate read.
+func (r *Reader) ReadBlock16(reg uint16, n int) ([]byte, error) {
+	buf := make([]byte, n)
+
+	switch dev := r.dev.(type) {
+	case WideDevice:
+		if err := r.retry(func() error { return dev.ReadRegister16(reg, buf) }); err != nil {
+			return nil, fmt.Errorf("read block: %w", err)
+		}
+
+	case io.ReadWriter:
+		err := r.retry(func() error {
+			if _, err := dev.Write([]byte{byte(reg >> 8), byte(reg)}); err != nil {
+				return fmt.Errorf("write register address: %w", err)
+			}
+			if _, err := io.ReadFull(dev, buf); err != nil {
+				return fmt.Errorf("read block: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errors.New("device does not support 16 bit register addresses")
+	}
+
+	return buf, nil
+}
+
+// WriteBlock16 writes data starting at the given 16 bit register address.
+func (r *Reader) WriteBlock16(reg uint16, data []byte) error {
+	switch dev := r.dev.(type) {
+	case WideDevice:
+		if err := r.retry(func() error { return dev.WriteRegister16(reg, data) }); err != nil {
+			return fmt.Errorf("write block: %w", err)
+		}
+
+	case io.Writer:
+		buf := append([]byte{byte(reg >> 8), byte(reg)}, data...)
+		if err := r.retry(func() error { _, err := dev.Write(buf); return err }); err != nil {
+			return fmt.Errorf("write block: %w", err)
+		}
+
+	default:
+		return errors.New("device does not support 16 bit register addresses")
+	}
+
+	return nil
+}
+
+// ReadBytes reads n bytes without first writing a register address, from
+// devices that take a command in one transaction and are read in another,
+// such as the Sensirion sensors.
+func (r *Reader) ReadBytes(n int) ([]byte, error) {
+	dev, ok := r.dev.(io.Reader)
+	if !ok {
+		return nil, errors.New("device does not support raw reads")
+	}
+	buf := make([]byte, n)
+	if err := r.retry(func() error { _, err := io.ReadFull(dev, buf); return err }); err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return buf, nil
+}
+
+// Block16 is like ReadBlock16 but records the error, to be returned by
+// Error(). A zeroed buffer is returned on error.
+func (r *Reader) Block16(reg uint16, n int) []byte {
+	if r.error != nil {
+		return make([]byte, n)
+	}
+	data, err := r.ReadBlock16(reg, n)
+	if err != nil {
+		r.error = err
+		return make([]byte, n)
+	}
+	return data
+}
+
+// Block is like ReadBlock but records the error, to be returned by
+// Error(). A zeroed buffer is returned on error.
+func (r *Reader) Block(reg uint8, n int) []byte {
+	if r.error != nil {
+		return make([]byte, n)
+	}
+	data, err := r.ReadBlock(reg, n)
+	if err != nil {
+		r.error = err
+		return make([]byte, n)
+	}
+	return data
+}
+
 func (r *Reader) Signed(regs ...uint8) int {
 	if r.error != nil {
 		return 0
@@ -56,7 +308,7 @@ func (r *Reader) Byte(reg uint8) int {
 	if r.error != nil {
 		return 0
 	}
-	val, err := r.dev.ReadByteData(reg)
+	val, err := r.readByte(reg)
 	if err != nil {
 		r.error = err
 		return 0
@@ -64,6 +316,16 @@ func (r *Reader) Byte(reg uint8) int {
 	return int(val)
 }
 
+// SignedLE returns the signed integer value of the little endian data, as
+// read from devices that store the low byte at the lower register address.
+func SignedLE(data []byte) int {
+	be := make([]byte, len(data))
+	for i, val := range data {
+		be[len(data)-1-i] = val
+	}
+	return signed(be)
+}
+
 func signed(data []byte) int {
 	res := int(int8(data[0]))
 	for _, val := range data[1:] {
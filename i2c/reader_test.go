@@ -1,6 +1,11 @@
 package i2c
 
-import "testing"
+import (
+	"errors"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
 
 func TestSigned(t *testing.T) {
 	cases := []struct {
@@ -18,3 +23,137 @@ func TestSigned(t *testing.T) {
 		}
 	}
 }
+
+func TestSignedLE(t *testing.T) {
+	cases := []struct {
+		in  []byte
+		out int
+	}{
+		{[]byte{0x34, 0x12}, 0x1234},
+		{[]byte{0x00, 0x80}, -0x8000},
+		{[]byte{0x01, 0x02, 0xff}, -0xfdff},
+	}
+
+	for _, tc := range cases {
+		if res := SignedLE(tc.in); res != tc.out {
+			t.Errorf("%d != expected %d for %v", res, tc.out, tc.in)
+		}
+	}
+}
+
+// byteDevice hides the block transfers of the device it wraps, leaving
+// only single register reads and writes.
+type byteDevice struct {
+	Device
+}
+
+func TestReadBlockFallback(t *testing.T) {
+	regs := make(i2ctest.Registers)
+	for i := 0; i < 256; i++ {
+		regs[uint8(i)] = byte(i)
+	}
+	r := NewReader(byteDevice{regs})
+	data := r.Block(0x28, 4)
+	if err := r.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0x28 || data[3] != 0x2b {
+		t.Errorf("unexpected data %x", data)
+	}
+}
+
+type flakyDevice struct {
+	Device
+	fails int
+}
+
+func (d *flakyDevice) ReadByteData(reg uint8) (uint8, error) {
+	if d.fails > 0 {
+		d.fails--
+		return 0, errors.New("nak")
+	}
+	return d.Device.ReadByteData(reg)
+}
+
+func TestReaderRetries(t *testing.T) {
+	dev := &flakyDevice{Device: i2ctest.Registers{0x10: 42}, fails: 2}
+	r := NewReader(dev)
+	r.SetRetries(2, 0)
+	if val := r.Byte(0x10); val != 42 {
+		t.Errorf("unexpected value %d", val)
+	}
+	if err := r.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Retried() != 2 {
+		t.Errorf("expected 2 retries, got %d", r.Retried())
+	}
+
+	dev.fails = 3
+	r.Reset()
+	r.Byte(0x10)
+	if r.Error() == nil {
+		t.Error("expected error after exhausting retries")
+	}
+}
+
+// rwDevice is a device with a 16 bit register pointer that is accessed
+// through plain reads and writes.
+type rwDevice struct {
+	Device
+	mem [1 << 16]byte
+	ptr uint16
+}
+
+func (d *rwDevice) Write(data []byte) (int, error) {
+	d.ptr = uint16(data[0])<<8 | uint16(data[1])
+	for i, b := range data[2:] {
+		d.mem[d.ptr+uint16(i)] = b
+	}
+	return len(data), nil
+}
+
+func (d *rwDevice) Read(buf []byte) (int, error) {
+	for i := range buf {
+		buf[i] = d.mem[d.ptr]
+		d.ptr++
+	}
+	return len(buf), nil
+}
+
+func TestReadWriteBlock16(t *testing.T) {
+	dev := new(rwDevice)
+	r := NewReader(dev)
+	if err := r.WriteBlock16(0x1234, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if dev.mem[0x1234] != 1 || dev.mem[0x1236] != 3 {
+		t.Errorf("unexpected memory contents %x", dev.mem[0x1234:0x1237])
+	}
+	data := r.Block16(0x1235, 2)
+	if err := r.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 2 || data[1] != 3 {
+		t.Errorf("unexpected data %x", data)
+	}
+
+	r = NewReader(i2ctest.Registers{})
+	if _, err := r.ReadBlock16(0, 1); err == nil {
+		t.Error("expected error for device without 16 bit support")
+	}
+}
+
+func TestWriteBlock(t *testing.T) {
+	dev := i2ctest.Words{}
+	if err := NewReader(dev).WriteBlock(5, []byte{0x12, 0x34}); err != nil {
+		t.Fatal(err)
+	}
+	if dev[5] != 0x1234 {
+		t.Errorf("unexpected register value %x", dev[5])
+	}
+
+	if err := NewReader(byteDevice{i2ctest.Registers{}}).WriteBlock(0, []byte{1}); err == nil {
+		t.Error("expected error for device without block writes")
+	}
+}
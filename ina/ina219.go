@@ -0,0 +1,148 @@
+// Package ina reads the Texas Instruments INA series of current and
+// voltage monitors.
+package ina
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// TI INA219 high-side current and bus voltage monitor. The current is
+// measured as the voltage over a shunt resistor, up to ±320 mV, so the
+// shunt decides the range: the 0.1 Ω of the common breakout boards gives
+// ±3.2 A, and an external 75 mV / 100 A shunt (0.00075 Ω) covers a solar
+// array or house load. The bus voltage is measured on the load side of the
+// shunt, up to 26 V.
+
+type INA219 struct {
+	bus     *i2c.Bus
+	address int
+	shunt   float64 // Ω
+
+	mut     sync.Mutex
+	cached  time.Time
+	voltage float64 // V
+	current float64 // A, positive from IN+ to IN-
+}
+
+// INA219DefaultAddress is the address with both address pins low. The
+// pins select addresses 0x40 to 0x4f.
+const INA219DefaultAddress = 0x40
+
+// DefaultShunt is the shunt resistance on most INA219 breakout boards.
+const DefaultShunt = 0.1
+
+const (
+	ina219ConfigReg = 0x00
+	ina219ShuntReg  = 0x01
+	ina219BusReg    = 0x02
+
+	// 32 V bus range, ±320 mV shunt range, both averaged over 128
+	// samples (68 ms), continuous.
+	ina219Config = 0x3fff
+
+	ina219ShuntLSB = 10e-6 // V
+	ina219BusLSB   = 4e-3  // V
+	ina219Overflow = 1     // math overflow flag in the bus voltage register
+)
+
+// NewINA219 configures the INA219 at the address, with the given shunt
+// resistance in ohms.
+func NewINA219(bus *i2c.Bus, addr int, shunt float64) (*INA219, error) {
+	if shunt <= 0 {
+		return nil, fmt.Errorf("invalid shunt resistance %v", shunt)
+	}
+	s := &INA219{bus: bus, address: addr, shunt: shunt}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		cfg := []byte{ina219Config >> 8, ina219Config & 0xff}
+		if err := i2c.NewReader(dev).WriteBlock(ina219ConfigReg, cfg); err != nil {
+			return fmt.Errorf("write configuration register: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *INA219) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		var err error
+		s.voltage, s.current, err = s.read(i2c.NewReader(dev))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+func (s *INA219) read(r *i2c.Reader) (voltage, current float64, err error) {
+	shunt := r.Block(ina219ShuntReg, 2)
+	bus := r.Block(ina219BusReg, 2)
+	if err := r.Error(); err != nil {
+		return 0, 0, fmt.Errorf("read data: %w", err)
+	}
+	if bus[1]&ina219Overflow != 0 {
+		return 0, 0, errors.New("current out of range for the shunt")
+	}
+	voltage = float64(int(bus[0])<<5|int(bus[1])>>3) * ina219BusLSB
+	current = float64(bigEndian(shunt)) * ina219ShuntLSB / s.shunt
+	return voltage, current, nil
+}
+
+// Voltage returns the bus voltage, in volts.
+func (s *INA219) Voltage() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.voltage
+}
+
+// Current returns the current through the shunt, in amperes, positive
+// from IN+ to IN-.
+func (s *INA219) Current() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.current
+}
+
+// Power returns the power delivered to the load, in watts.
+func (s *INA219) Power() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.voltage * s.current
+}
+
+func (s *INA219) Info() sensor.Info {
+	return sensor.Info{Chip: "INA219", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *INA219) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "voltage", Unit: "volts", Quantity: sensor.Voltage, Precision: 2, Value: s.voltage},
+		{Name: "current", Unit: "amperes", Quantity: sensor.Current, Precision: 3, Value: s.current},
+		{Name: "power", Unit: "watts", Quantity: sensor.Power, Precision: 1, Value: s.voltage * s.current},
+	}
+}
+
+// bigEndian returns the signed value of a 16 bit register, high byte
+// first.
+func bigEndian(data []byte) int {
+	return int(int16(uint16(data[0])<<8 | uint16(data[1])))
+}
@@ -0,0 +1,29 @@
+package ina
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+func TestINA219Read(t *testing.T) {
+	dev := i2ctest.Words{
+		ina219ShuntReg: 0xfc18,         // -1000 × 10 µV
+		ina219BusReg:   3100<<3 | 1<<1, // 12.4 V, conversion ready
+	}
+	s := &INA219{shunt: DefaultShunt}
+	v, c, err := s.read(i2c.NewReader(dev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(v-12.4) > 1e-9 || math.Abs(c+0.1) > 1e-9 {
+		t.Errorf("unexpected voltage %v and current %v", v, c)
+	}
+
+	dev[ina219BusReg] |= ina219Overflow
+	if _, _, err := s.read(i2c.NewReader(dev)); err == nil {
+		t.Error("expected error on overflow")
+	}
+}
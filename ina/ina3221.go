@@ -0,0 +1,131 @@
+package ina
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// TI INA3221 three channel current and bus voltage monitor. Each channel
+// works like an INA219 with a ±163.8 mV shunt range, so one chip covers,
+// say, the house bank, the starter and the solar panel. The bus voltages
+// are measured on the load side of the shunts, up to 26 V.
+
+type INA3221 struct {
+	bus     *i2c.Bus
+	address int
+	shunts  [3]float64 // Ω
+
+	mut     sync.Mutex
+	cached  time.Time
+	voltage [3]float64 // V
+	current [3]float64 // A, positive from IN+ to IN-
+}
+
+// INA3221DefaultAddress is the address with the address pin to ground. The
+// pin selects addresses 0x40 to 0x43.
+const INA3221DefaultAddress = 0x40
+
+const (
+	ina3221ConfigReg = 0x00
+	ina3221ShuntReg  = 0x01 // channel 1; channels 2 and 3 follow, each with its bus voltage
+	ina3221BusReg    = 0x02
+
+	// All channels, averaged over 64 samples of 1.1 ms, continuous.
+	ina3221Config = 0x7727
+
+	ina3221ShuntLSB = 40e-6 // V
+	ina3221BusLSB   = 8e-3  // V
+)
+
+// NewINA3221 configures the INA3221 at the address, with the shunt
+// resistances of channels 1 to 3 in ohms.
+func NewINA3221(bus *i2c.Bus, addr int, shunts [3]float64) (*INA3221, error) {
+	for i, shunt := range shunts {
+		if shunt <= 0 {
+			return nil, fmt.Errorf("channel %d: invalid shunt resistance %v", i+1, shunt)
+		}
+	}
+	s := &INA3221{bus: bus, address: addr, shunts: shunts}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		cfg := []byte{ina3221Config >> 8, ina3221Config & 0xff}
+		if err := i2c.NewReader(dev).WriteBlock(ina3221ConfigReg, cfg); err != nil {
+			return fmt.Errorf("write configuration register: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *INA3221) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		var err error
+		s.voltage, s.current, err = s.read(i2c.NewReader(dev))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+func (s *INA3221) read(r *i2c.Reader) (voltage, current [3]float64, err error) {
+	for i := range s.shunts {
+		shunt := r.Block(ina3221ShuntReg+uint8(2*i), 2)
+		bus := r.Block(ina3221BusReg+uint8(2*i), 2)
+		// The low three bits are unused.
+		voltage[i] = float64(bigEndian(bus)>>3) * ina3221BusLSB
+		current[i] = float64(bigEndian(shunt)>>3) * ina3221ShuntLSB / s.shunts[i]
+	}
+	if err := r.Error(); err != nil {
+		return voltage, current, fmt.Errorf("read data: %w", err)
+	}
+	return voltage, current, nil
+}
+
+// Voltages returns the bus voltages of channels 1 to 3, in volts.
+func (s *INA3221) Voltages() [3]float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.voltage
+}
+
+// Currents returns the currents through the shunts of channels 1 to 3, in
+// amperes, positive from IN+ to IN-.
+func (s *INA3221) Currents() [3]float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.current
+}
+
+func (s *INA3221) Info() sensor.Info {
+	return sensor.Info{Chip: "INA3221", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *INA3221) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var res []sensor.Reading
+	for i := range s.voltage {
+		ch := fmt.Sprint(i + 1)
+		res = append(res,
+			sensor.Reading{Name: "voltage_" + ch, Unit: "volts", Quantity: sensor.Voltage, Precision: 2, Value: s.voltage[i]},
+			sensor.Reading{Name: "current_" + ch, Unit: "amperes", Quantity: sensor.Current, Precision: 3, Value: s.current[i]},
+		)
+	}
+	return res
+}
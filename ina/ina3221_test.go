@@ -0,0 +1,29 @@
+package ina
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+func TestINA3221Read(t *testing.T) {
+	dev := i2ctest.Words{
+		0x01: 250 << 3,  // 10 mV
+		0x02: 1600 << 3, // 12.8 V
+		0x03: 0xfff8,    // -40 µV
+		0x04: 1550 << 3, // 12.4 V
+	}
+	s := &INA3221{shunts: [3]float64{0.1, 0.1, 0.1}}
+	v, c, err := s.read(i2c.NewReader(dev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(v[0]-12.8) > 1e-9 || math.Abs(v[1]-12.4) > 1e-9 || v[2] != 0 {
+		t.Errorf("unexpected voltages %v", v)
+	}
+	if math.Abs(c[0]-0.1) > 1e-9 || math.Abs(c[1]+0.0004) > 1e-9 || c[2] != 0 {
+		t.Errorf("unexpected currents %v", c)
+	}
+}
@@ -0,0 +1,392 @@
+package invensense
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensehat"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// InvenSense ICM-20948 3D accelerometer, 3D gyroscope and, on a die of its
+// own, an AKM AK09916 3D magnetometer. It is a nine axis replacement for
+// the LSM9DS1, with the same acceleration and compass interfaces and the
+// same calibration, plus the rate of turn.
+//
+// The registers are in four banks, selected by the bank select register
+// which is at the same address in all of them; the data are in bank 0 and
+// the configuration in bank 2. The magnetometer is reached with the
+// auxiliary I2C bus in bypass mode, where it appears on the main bus at an
+// address of its own. Its axes are turned to match the accelerometer's.
+//
+// There is no FIFO support; AccelerationSamples is the current sample.
+
+type ICM20948 struct {
+	bus     *i2c.Bus
+	address int
+	gyroLSB float64 // counts per °/s
+	mo      float64
+
+	mut        sync.Mutex
+	cal        *sensehat.Calibrator
+	cached     time.Time
+	ax, ay, az int16
+	gx, gy, gz int16
+	temp       int16
+	mx, my, mz int16
+}
+
+// ICM20948DefaultAddress is the address with AD0 high, as on most breakout
+// boards; with AD0 low it is 0x68.
+const ICM20948DefaultAddress = 0x69
+
+const (
+	icm20948BankSelReg = 0x7f // in every bank; the bank in bits 5:4
+
+	// Bank 0
+	icm20948WhoAmIReg     = 0x00
+	icm20948UserCtrlReg   = 0x03
+	icm20948PwrMgmt1Reg   = 0x06
+	icm20948IntPinCfgReg  = 0x0f
+	icm20948AccelXOutHReg = 0x2d // accelerometer, gyroscope and temperature, 14 bytes
+
+	// Bank 2
+	icm20948GyroSmplrtDivReg = 0x00
+	icm20948GyroConfig1Reg   = 0x01
+	icm20948AccelSmplrtDiv1  = 0x10 // the high four bits of the divider
+	icm20948AccelSmplrtDiv2  = 0x11 // the low eight
+	icm20948AccelConfigReg   = 0x14
+
+	icm20948WhoAmI       = 0xea
+	icm20948Reset        = 0b_1000_0000 // PWR_MGMT_1 DEVICE_RESET
+	icm20948ClockAuto    = 0b_0000_0001 // PWR_MGMT_1 CLKSEL, the gyroscope PLL when ready
+	icm20948Bypass       = 0b_0000_0010 // INT_PIN_CFG BYPASS_EN
+	icm20948FilterEnable = 0b_0000_0001 // GYRO_CONFIG_1 GYRO_FCHOICE, ACCEL_CONFIG ACCEL_FCHOICE
+	icm20948ConfigBank   = 2
+
+	icm20948ResetTime = 100 * time.Millisecond
+
+	icm20948TempSensitivity = 333.87 // counts per °C
+	icm20948TempRoomTemp    = 21     // °C at zero counts
+)
+
+// The AK09916 magnetometer, in bypass mode.
+const (
+	ak09916Address = 0x0c
+
+	ak09916WIA2Reg  = 0x01
+	ak09916ST1Reg   = 0x10 // status, data and status 2, 9 bytes
+	ak09916CNTL2Reg = 0x31
+	ak09916CNTL3Reg = 0x32
+
+	ak09916ID       = 0x09
+	ak09916Ready    = 0b_0000_0001 // ST1 DRDY
+	ak09916Overflow = 0b_0000_1000 // ST2 HOFL
+	ak09916Reset    = 0b_0000_0001 // CNTL3 SRST
+
+	// ak09916LSB is the sensitivity in counts per gauss, 0.15 µT per count.
+	ak09916LSB = 1000 / 1.5
+)
+
+// ICM20948Settings selects the sample rate (in Hz), the full scale ranges
+// (in g and °/s) and the magnetometer rate (in Hz). Zero values select the
+// defaults of 10 Hz, ±2 g, ±250 °/s and 10 Hz. The digital low pass
+// filters are set to about half the sample rate.
+type ICM20948Settings struct {
+	AccelRate  float64
+	AccelRange int
+	GyroRange  int
+	MagnRate   float64
+}
+
+// Register values, per the register map, keyed by rate or range. The
+// dividers are of 1125 Hz for the accelerometer and 1100 Hz for the
+// gyroscope, close enough to use the same for both.
+var (
+	icm20948Rates = map[float64]struct{ div, dlpf byte }{
+		10: {111, 6}, 50: {21, 5}, 100: {10, 4}, 225: {4, 3},
+	}
+	icm20948AccelRanges = map[int]byte{2: 0, 4: 1, 8: 2, 16: 3}
+	icm20948GyroRanges  = map[int]byte{250: 0, 500: 1, 1000: 2, 2000: 3}
+	ak09916Rates        = map[float64]byte{10: 0b0010, 20: 0b0100, 50: 0b0110, 100: 0b1000}
+
+	// Nominal sensitivity in counts per g and °/s, keyed by range.
+	icm20948AccelSensitivity = map[int]float64{2: 16384, 4: 8192, 8: 4096, 16: 2048}
+	icm20948GyroSensitivity  = map[int]float64{250: 131, 500: 65.5, 1000: 32.8, 2000: 16.4}
+)
+
+func (c *ICM20948Settings) defaults() {
+	if c.AccelRate == 0 {
+		c.AccelRate = 10
+	}
+	if c.AccelRange == 0 {
+		c.AccelRange = 2
+	}
+	if c.GyroRange == 0 {
+		c.GyroRange = 250
+	}
+	if c.MagnRate == 0 {
+		c.MagnRate = 10
+	}
+}
+
+// NewICM20948 resets and configures the ICM-20948 at the address and its
+// magnetometer, starting from the calibration. The magnetic offset is
+// added to the compass angles, in degrees.
+func NewICM20948(bus *i2c.Bus, addr int, magnOffs float64, cal sensehat.Calibration, settings ICM20948Settings) (*ICM20948, error) {
+	settings.defaults()
+	rate, ok := icm20948Rates[settings.AccelRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported accelerometer rate %v Hz", settings.AccelRate)
+	}
+	afs, ok := icm20948AccelRanges[settings.AccelRange]
+	if !ok {
+		return nil, fmt.Errorf("unsupported accelerometer range ±%d g", settings.AccelRange)
+	}
+	gfs, ok := icm20948GyroRanges[settings.GyroRange]
+	if !ok {
+		return nil, fmt.Errorf("unsupported gyroscope range ±%d °/s", settings.GyroRange)
+	}
+	mode, ok := ak09916Rates[settings.MagnRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported magnetometer rate %v Hz", settings.MagnRate)
+	}
+
+	s := &ICM20948{
+		bus:     bus,
+		address: addr,
+		gyroLSB: icm20948GyroSensitivity[settings.GyroRange],
+		mo:      magnOffs,
+		cal:     sensehat.NewCalibrator(cal, icm20948AccelSensitivity[settings.AccelRange], ak09916LSB),
+	}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		// The bank may be left over from before a restart.
+		if err := selectBank(dev, 0); err != nil {
+			return err
+		}
+		id, err := dev.ReadByteData(icm20948WhoAmIReg)
+		if err != nil {
+			return fmt.Errorf("read WHO_AM_I: %w", err)
+		}
+		if id != icm20948WhoAmI {
+			return fmt.Errorf("unknown WHO_AM_I 0x%02x", id)
+		}
+
+		if err := dev.WriteByteData(icm20948PwrMgmt1Reg, icm20948Reset); err != nil {
+			return fmt.Errorf("reset: %w", err)
+		}
+		time.Sleep(icm20948ResetTime)
+
+		// The reset selects bank 0.
+		for _, w := range []struct{ reg, val byte }{
+			{icm20948PwrMgmt1Reg, icm20948ClockAuto},
+			{icm20948UserCtrlReg, 0}, // the auxiliary I2C master off, for bypass
+			{icm20948IntPinCfgReg, icm20948Bypass},
+			{icm20948BankSelReg, icm20948ConfigBank << 4},
+			{icm20948GyroSmplrtDivReg, rate.div},
+			{icm20948GyroConfig1Reg, rate.dlpf<<3 | gfs<<1 | icm20948FilterEnable},
+			{icm20948AccelSmplrtDiv1, 0},
+			{icm20948AccelSmplrtDiv2, rate.div},
+			{icm20948AccelConfigReg, rate.dlpf<<3 | afs<<1 | icm20948FilterEnable},
+			{icm20948BankSelReg, 0},
+		} {
+			if err := dev.WriteByteData(w.reg, w.val); err != nil {
+				return fmt.Errorf("write register 0x%02x: %w", w.reg, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = bus.Do(ak09916Address, func(dev i2c.Device) error {
+		id, err := dev.ReadByteData(ak09916WIA2Reg)
+		if err != nil {
+			return fmt.Errorf("read magnetometer ID: %w", err)
+		}
+		if id != ak09916ID {
+			return fmt.Errorf("unknown magnetometer ID 0x%02x", id)
+		}
+		if err := dev.WriteByteData(ak09916CNTL3Reg, ak09916Reset); err != nil {
+			return fmt.Errorf("reset magnetometer: %w", err)
+		}
+		time.Sleep(icm20948ResetTime)
+		if err := dev.WriteByteData(ak09916CNTL2Reg, mode); err != nil {
+			return fmt.Errorf("write magnetometer mode: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// selectBank selects the register bank for the following accesses.
+func selectBank(dev i2c.Device, bank byte) error {
+	if err := dev.WriteByteData(icm20948BankSelReg, bank<<4); err != nil {
+		return fmt.Errorf("select bank %d: %w", bank, err)
+	}
+	return nil
+}
+
+func (s *ICM20948) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(icm20948AccelXOutHReg, 14)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
+		p := s.cal.Acceleration(sensor.Point{X: bigEndian(data[0:]), Y: bigEndian(data[2:]), Z: bigEndian(data[4:])})
+		s.ax, s.ay, s.az = p.X, p.Y, p.Z
+		s.gx, s.gy, s.gz = bigEndian(data[6:]), bigEndian(data[8:]), bigEndian(data[10:])
+		s.temp = bigEndian(data[12:])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var fresh bool
+	err = s.bus.Do(ak09916Address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		// Reading through ST2 releases the data registers for the
+		// next measurement.
+		data := r.Block(ak09916ST1Reg, 9)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read magnetometer: %w", err)
+		}
+		if data[0]&ak09916Ready == 0 || data[8]&ak09916Overflow != 0 {
+			return nil
+		}
+		// The magnetometer Y and Z axes point the other way from the
+		// accelerometer's.
+		s.mx = int16(i2c.SignedLE(data[1:3]))
+		s.my = -int16(i2c.SignedLE(data[3:5]))
+		s.mz = -int16(i2c.SignedLE(data[5:7]))
+		fresh = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if fresh {
+		s.cal.MagneticField(sensor.Point{X: s.mx, Y: s.my, Z: s.mz})
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+func (s *ICM20948) Calibration() sensehat.Calibration {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.cal.Calibration()
+}
+
+// CalibrationResets returns the magnetometer calibration resets since the
+// last call.
+func (s *ICM20948) CalibrationResets() []sensehat.CalibrationReset {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.cal.Resets()
+}
+
+// CalibrationQuality returns how far the magnetometer calibration can be
+// trusted.
+func (s *ICM20948) CalibrationQuality() sensehat.CalibrationQuality {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.cal.Quality()
+}
+
+func (s *ICM20948) Acceleration() (x, y, z int16) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.ax, s.ay, s.az
+}
+
+// AccelerationSamples returns the current accelerometer sample.
+func (s *ICM20948) AccelerationSamples() []sensor.Point {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Point{{X: s.ax, Y: s.ay, Z: s.az}}
+}
+
+// AccelerationRate returns the number of accelerometer samples per second
+// available from AccelerationSamples.
+func (s *ICM20948) AccelerationRate(refresh time.Duration) float64 {
+	return 1 / refresh.Seconds()
+}
+
+func (s *ICM20948) AccelerationAngles() (xy, xz, yz float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	xy = angle(float64(s.ay), float64(s.ax))
+	xz = angle(float64(s.az), float64(s.ax))
+	yz = angle(float64(s.az), float64(s.ay))
+	return xy, xz, yz
+}
+
+// Rotation returns the rate of turn around each axis, in °/s.
+func (s *ICM20948) Rotation() (x, y, z float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return float64(s.gx) / s.gyroLSB, float64(s.gy) / s.gyroLSB, float64(s.gz) / s.gyroLSB
+}
+
+func (s *ICM20948) MagneticField() (x, y, z int16) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.mx, s.my, s.mz
+}
+
+func (s *ICM20948) Compass() (xy, xz, yz float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.cal.Compass(sensor.Point{X: s.mx, Y: s.my, Z: s.mz}, s.mo)
+}
+
+// Temperature returns the die temperature in degrees Celsius, which runs
+// warmer than the surroundings.
+func (s *ICM20948) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature()
+}
+
+func (s *ICM20948) temperature() float64 {
+	return float64(s.temp)/icm20948TempSensitivity + icm20948TempRoomTemp
+}
+
+func (s *ICM20948) Info() sensor.Info {
+	return sensor.Info{Chip: "ICM20948", Bus: "i2c", Address: fmt.Sprintf("0x%02x, 0x%02x", s.address, ak09916Address)}
+}
+
+// Readings returns the calibrated acceleration, the raw magnetic field,
+// the rate of turn and the die temperature.
+func (s *ICM20948) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "acceleration_x", Quantity: sensor.Acceleration, Value: float64(s.ax)},
+		{Name: "acceleration_y", Quantity: sensor.Acceleration, Value: float64(s.ay)},
+		{Name: "acceleration_z", Quantity: sensor.Acceleration, Value: float64(s.az)},
+		{Name: "magnetic_field_x", Quantity: sensor.MagneticField, Value: float64(s.mx)},
+		{Name: "magnetic_field_y", Quantity: sensor.MagneticField, Value: float64(s.my)},
+		{Name: "magnetic_field_z", Quantity: sensor.MagneticField, Value: float64(s.mz)},
+		{Name: "rotation_x", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gx) / s.gyroLSB},
+		{Name: "rotation_y", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gy) / s.gyroLSB},
+		{Name: "rotation_z", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gz) / s.gyroLSB},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 0, Value: s.temperature()},
+	}
+}
@@ -0,0 +1,148 @@
+package invensense
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensehat"
+)
+
+// icmDevice is an ICM-20948 with banked registers at its address and the
+// AK09916 at the magnetometer address.
+type icmDevice struct {
+	i2c.Device
+	addr  int
+	banks [4]map[uint8]uint8
+	magn  map[uint8]uint8
+}
+
+func newICMDevice() *icmDevice {
+	d := &icmDevice{magn: map[uint8]uint8{ak09916WIA2Reg: ak09916ID}}
+	for i := range d.banks {
+		d.banks[i] = make(map[uint8]uint8)
+	}
+	d.banks[0][icm20948WhoAmIReg] = icm20948WhoAmI
+	return d
+}
+
+func (d *icmDevice) SetAddress(addr int) error {
+	d.addr = addr
+	return nil
+}
+
+func (d *icmDevice) regs() map[uint8]uint8 {
+	if d.addr == ak09916Address {
+		return d.magn
+	}
+	return d.banks[d.banks[0][icm20948BankSelReg]>>4]
+}
+
+func (d *icmDevice) ReadByteData(reg uint8) (uint8, error) {
+	return d.regs()[reg], nil
+}
+
+func (d *icmDevice) WriteByteData(reg, val uint8) error {
+	if d.addr != ak09916Address && reg == icm20948BankSelReg {
+		// The same register in every bank.
+		d.banks[0][reg] = val
+		return nil
+	}
+	d.regs()[reg] = val
+	return nil
+}
+
+func (d *icmDevice) ReadBlockData(reg uint8, buf []byte) error {
+	regs := d.regs()
+	for i := range buf {
+		buf[i] = regs[reg+uint8(i)]
+	}
+	return nil
+}
+
+func (d *icmDevice) set16(reg uint8, v int16) {
+	d.banks[0][reg], d.banks[0][reg+1] = byte(uint16(v)>>8), byte(v)
+}
+
+func (d *icmDevice) setField(x, y, z int16) {
+	d.magn[ak09916ST1Reg] = ak09916Ready
+	for i, v := range []int16{x, y, z} {
+		reg := ak09916ST1Reg + 1 + uint8(2*i)
+		d.magn[reg], d.magn[reg+1] = byte(v), byte(uint16(v)>>8)
+	}
+}
+
+func TestICM20948(t *testing.T) {
+	dev := newICMDevice()
+	if _, err := NewICM20948(i2c.NewBus(dev), ICM20948DefaultAddress, 0, sensehat.Calibration{}, ICM20948Settings{AccelRate: 119}); err == nil {
+		t.Error("expected error for unsupported rate")
+	}
+	cal := sensehat.Calibration{Min: sensehat.Point{X: -300, Y: -300, Z: -300}, Max: sensehat.Point{X: 300, Y: 300, Z: 300}}
+	s, err := NewICM20948(i2c.NewBus(dev), ICM20948DefaultAddress, 0, cal, ICM20948Settings{AccelRange: 4, GyroRange: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := dev.banks[2][icm20948AccelConfigReg]; v != 6<<3|1<<1|1 {
+		t.Errorf("accelerometer configuration 0x%02x in bank 2", v)
+	}
+	if v := dev.banks[2][icm20948AccelSmplrtDiv2]; v != 111 {
+		t.Errorf("sample rate divider %d, expected 111 for 10 Hz", v)
+	}
+	if _, ok := dev.banks[0][icm20948AccelConfigReg]; ok {
+		t.Error("configuration written to bank 0")
+	}
+	if v := dev.banks[0][icm20948BankSelReg]; v != 0 {
+		t.Errorf("left in bank %d", v>>4)
+	}
+	if v := dev.banks[0][icm20948IntPinCfgReg]; v != icm20948Bypass {
+		t.Errorf("no bypass to the magnetometer: 0x%02x", v)
+	}
+	if v := dev.magn[ak09916CNTL2Reg]; v != 0b0010 {
+		t.Errorf("magnetometer mode 0x%02x", v)
+	}
+
+	// Heeled 30°, turning at 6.5 °/s around z, at 25 °C, heading 90°.
+	dev.set16(icm20948AccelXOutHReg, 0)
+	dev.set16(icm20948AccelXOutHReg+2, 4096)
+	dev.set16(icm20948AccelXOutHReg+4, 7094)
+	dev.set16(icm20948AccelXOutHReg+10, 426)
+	dev.set16(icm20948AccelXOutHReg+12, 1335)
+	dev.setField(0, -300, 0)
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, yz := s.AccelerationAngles(); math.Abs(yz-60) > 0.1 {
+		t.Errorf("yz angle %v, expected 60", yz)
+	}
+	if _, _, z := s.Rotation(); math.Abs(z-6.5) > 0.01 {
+		t.Errorf("rotation %v, expected 6.5", z)
+	}
+	if temp := s.Temperature(); math.Abs(temp-25) > 0.01 {
+		t.Errorf("temperature %v, expected 25", temp)
+	}
+	if x, y, z := s.MagneticField(); x != 0 || y != 300 || z != 0 {
+		t.Errorf("magnetic field %d, %d, %d not in the accelerometer axes", x, y, z)
+	}
+	if xy, _, _ := s.Compass(); xy != 90 {
+		t.Errorf("heading %v, expected 90", xy)
+	}
+
+	// A magnet near the sensor throws the calibration away.
+	dev.setField(0, 3000, 0)
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if res := s.CalibrationResets(); len(res) != 1 {
+		t.Errorf("expected a calibration reset, got %v", res)
+	}
+
+	// Without new data the magnetometer is not read again.
+	dev.setField(0, 100, 0)
+	dev.magn[ak09916ST1Reg] = 0
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, y, _ := s.MagneticField(); y != -3000 {
+		t.Errorf("magnetic field y %d, expected the previous -3000", y)
+	}
+}
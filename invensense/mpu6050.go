@@ -0,0 +1,339 @@
+// Package invensense reads the InvenSense (now TDK) motion sensors.
+package invensense
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// InvenSense MPU-6050 3D accelerometer and 3D gyroscope. It has the same
+// acceleration interface as the LSM9DS1, raw counts at the nominal
+// sensitivity and angles between the axes, but no magnetometer. The
+// gyroscope gives the rate of turn.
+//
+// Clones that answer WHO_AM_I with something other than 0x68 are common
+// on cheap breakout boards; those known to behave the same are accepted.
+
+type MPU6050 struct {
+	bus     *i2c.Bus
+	address int
+	whoAmI  byte
+	fifo    bool
+	rate    float64 // Hz
+	gyroLSB float64 // counts per °/s
+
+	mut        sync.Mutex
+	cached     time.Time
+	ax, ay, az int16
+	gx, gy, gz int16
+	temp       int16
+	samples    []sensor.Point
+}
+
+// MPU6050DefaultAddress is the address with AD0 low; with AD0 high it is
+// 0x69.
+const MPU6050DefaultAddress = 0x68
+
+const (
+	mpu6050SmplrtDivReg   = 0x19
+	mpu6050ConfigReg      = 0x1a
+	mpu6050GyroConfigReg  = 0x1b
+	mpu6050AccelConfigReg = 0x1c
+	mpu6050FIFOEnReg      = 0x23
+	mpu6050IntEnableReg   = 0x38
+	mpu6050IntStatusReg   = 0x3a
+	mpu6050AccelXOutHReg  = 0x3b // accelerometer, temperature and gyroscope, 14 bytes
+	mpu6050UserCtrlReg    = 0x6a
+	mpu6050PwrMgmt1Reg    = 0x6b
+	mpu6050FIFOCountHReg  = 0x72
+	mpu6050FIFORWReg      = 0x74
+	mpu6050WhoAmIReg      = 0x75
+
+	mpu6050Reset       = 0b_1000_0000 // PWR_MGMT_1 DEVICE_RESET
+	mpu6050ClockPLL    = 0b_0000_0001 // PWR_MGMT_1 CLKSEL, the X gyroscope PLL
+	mpu6050FIFOEnable  = 0b_0100_0000 // USER_CTRL FIFO_EN
+	mpu6050FIFOReset   = 0b_0000_0100 // USER_CTRL FIFO_RESET
+	mpu6050AccelFIFO   = 0b_0000_1000 // FIFO_EN ACCEL_FIFO_EN
+	mpu6050FIFOOverrun = 0b_0001_0000 // INT_ENABLE FIFO_OFLOW_EN, INT_STATUS FIFO_OFLOW_INT
+	mpu6050FIFOSize    = 1024
+
+	mpu6050ResetTime = 100 * time.Millisecond
+
+	// mpu6050FIFOChunk is the most read from the FIFO in one go, a whole
+	// number of six byte accelerometer samples.
+	mpu6050FIFOChunk = 32 * 6
+)
+
+// WHO_AM_I values of the MPU-6050 and compatible clones.
+var mpu6050WhoAmIs = map[byte]bool{0x68: true, 0x70: true, 0x72: true, 0x98: true}
+
+// MPU6050Settings selects the sample rate (in Hz) and full scale ranges
+// (in g and °/s). Zero values select the defaults of 10 Hz, ±2 g and
+// ±250 °/s. The digital low pass filter is set to below half the sample
+// rate.
+//
+// With FIFO set, the accelerometer samples are buffered between refreshes,
+// up to 170 of them, all of which are read on each refresh and available
+// from AccelerationSamples.
+type MPU6050Settings struct {
+	AccelRate  float64
+	AccelRange int
+	GyroRange  int
+	FIFO       bool
+}
+
+// Register values, per the register map, keyed by rate or range.
+var (
+	mpu6050Rates = map[float64]struct{ div, dlpf byte }{
+		10: {99, 6}, 50: {19, 4}, 100: {9, 3}, 200: {4, 2}, 500: {1, 1}, 1000: {0, 1},
+	}
+	mpu6050AccelRanges = map[int]byte{2: 0, 4: 1, 8: 2, 16: 3}
+	mpu6050GyroRanges  = map[int]byte{250: 0, 500: 1, 1000: 2, 2000: 3}
+
+	// Nominal sensitivity in counts per °/s, keyed by range.
+	mpu6050GyroSensitivity = map[int]float64{250: 131, 500: 65.5, 1000: 32.8, 2000: 16.4}
+)
+
+func (c *MPU6050Settings) defaults() {
+	if c.AccelRate == 0 {
+		c.AccelRate = 10
+	}
+	if c.AccelRange == 0 {
+		c.AccelRange = 2
+	}
+	if c.GyroRange == 0 {
+		c.GyroRange = 250
+	}
+}
+
+// NewMPU6050 resets and configures the MPU-6050 at the address.
+func NewMPU6050(bus *i2c.Bus, addr int, settings MPU6050Settings) (*MPU6050, error) {
+	settings.defaults()
+	rate, ok := mpu6050Rates[settings.AccelRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported accelerometer rate %v Hz", settings.AccelRate)
+	}
+	afs, ok := mpu6050AccelRanges[settings.AccelRange]
+	if !ok {
+		return nil, fmt.Errorf("unsupported accelerometer range ±%d g", settings.AccelRange)
+	}
+	gfs, ok := mpu6050GyroRanges[settings.GyroRange]
+	if !ok {
+		return nil, fmt.Errorf("unsupported gyroscope range ±%d °/s", settings.GyroRange)
+	}
+
+	s := &MPU6050{
+		bus:     bus,
+		address: addr,
+		fifo:    settings.FIFO,
+		rate:    settings.AccelRate,
+		gyroLSB: mpu6050GyroSensitivity[settings.GyroRange],
+	}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		id, err := dev.ReadByteData(mpu6050WhoAmIReg)
+		if err != nil {
+			return fmt.Errorf("read WHO_AM_I: %w", err)
+		}
+		if !mpu6050WhoAmIs[id] {
+			return fmt.Errorf("unknown WHO_AM_I 0x%02x", id)
+		}
+		s.whoAmI = id
+
+		if err := dev.WriteByteData(mpu6050PwrMgmt1Reg, mpu6050Reset); err != nil {
+			return fmt.Errorf("reset: %w", err)
+		}
+		time.Sleep(mpu6050ResetTime)
+
+		var fifoEn, intEn, userCtrl byte
+		if settings.FIFO {
+			fifoEn, intEn, userCtrl = mpu6050AccelFIFO, mpu6050FIFOOverrun, mpu6050FIFOEnable
+		}
+		for _, w := range []struct{ reg, val byte }{
+			{mpu6050PwrMgmt1Reg, mpu6050ClockPLL},
+			{mpu6050SmplrtDivReg, rate.div},
+			{mpu6050ConfigReg, rate.dlpf},
+			{mpu6050AccelConfigReg, afs << 3},
+			{mpu6050GyroConfigReg, gfs << 3},
+			{mpu6050UserCtrlReg, mpu6050FIFOReset},
+			{mpu6050FIFOEnReg, fifoEn},
+			{mpu6050IntEnableReg, intEn},
+			{mpu6050UserCtrlReg, userCtrl},
+		} {
+			if err := dev.WriteByteData(w.reg, w.val); err != nil {
+				return fmt.Errorf("write register 0x%02x: %w", w.reg, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *MPU6050) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(mpu6050AccelXOutHReg, 14)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
+		s.ax, s.ay, s.az = bigEndian(data[0:]), bigEndian(data[2:]), bigEndian(data[4:])
+		s.temp = bigEndian(data[6:])
+		s.gx, s.gy, s.gz = bigEndian(data[8:]), bigEndian(data[10:]), bigEndian(data[12:])
+
+		if !s.fifo {
+			s.samples = []sensor.Point{{X: s.ax, Y: s.ay, Z: s.az}}
+			return nil
+		}
+		samples, err := s.readFIFO(r)
+		if err != nil {
+			return err
+		}
+		s.samples = samples
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// readFIFO reads the accelerometer samples in the FIFO. After an overrun
+// the samples are no longer aligned, so the FIFO is reset and the samples
+// are lost.
+func (s *MPU6050) readFIFO(r *i2c.Reader) ([]sensor.Point, error) {
+	status := r.Byte(mpu6050IntStatusReg)
+	count := r.Block(mpu6050FIFOCountHReg, 2)
+	if err := r.Error(); err != nil {
+		return nil, fmt.Errorf("read FIFO status: %w", err)
+	}
+	n := int(count[0])<<8 | int(count[1])
+	if status&mpu6050FIFOOverrun != 0 || n >= mpu6050FIFOSize {
+		if err := r.WriteBlock(mpu6050UserCtrlReg, []byte{mpu6050FIFOEnable | mpu6050FIFOReset}); err != nil {
+			return nil, fmt.Errorf("reset FIFO: %w", err)
+		}
+		return nil, nil
+	}
+
+	n -= n % 6
+	var samples []sensor.Point
+	for n > 0 {
+		chunk := n
+		if chunk > mpu6050FIFOChunk {
+			chunk = mpu6050FIFOChunk
+		}
+		data, err := r.ReadBlock(mpu6050FIFORWReg, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("read FIFO: %w", err)
+		}
+		for i := 0; i < len(data); i += 6 {
+			samples = append(samples, sensor.Point{
+				X: bigEndian(data[i:]),
+				Y: bigEndian(data[i+2:]),
+				Z: bigEndian(data[i+4:]),
+			})
+		}
+		n -= chunk
+	}
+	return samples, nil
+}
+
+func bigEndian(data []byte) int16 {
+	return int16(uint16(data[0])<<8 | uint16(data[1]))
+}
+
+func (s *MPU6050) Acceleration() (x, y, z int16) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.ax, s.ay, s.az
+}
+
+// AccelerationSamples returns the accelerometer samples read on the last
+// refresh, oldest first. Without FIFO this is the single current sample.
+func (s *MPU6050) AccelerationSamples() []sensor.Point {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]sensor.Point(nil), s.samples...)
+}
+
+// AccelerationRate returns the number of accelerometer samples per second
+// available from AccelerationSamples.
+func (s *MPU6050) AccelerationRate(refresh time.Duration) float64 {
+	if !s.fifo {
+		return 1 / refresh.Seconds()
+	}
+	return s.rate
+}
+
+func (s *MPU6050) AccelerationAngles() (xy, xz, yz float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	xy = angle(float64(s.ay), float64(s.ax))
+	xz = angle(float64(s.az), float64(s.ax))
+	yz = angle(float64(s.az), float64(s.ay))
+	return xy, xz, yz
+}
+
+// Rotation returns the rate of turn around each axis, in °/s.
+func (s *MPU6050) Rotation() (x, y, z float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return float64(s.gx) / s.gyroLSB, float64(s.gy) / s.gyroLSB, float64(s.gz) / s.gyroLSB
+}
+
+// Temperature returns the die temperature in degrees Celsius, which runs
+// warmer than the surroundings.
+func (s *MPU6050) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature()
+}
+
+func (s *MPU6050) temperature() float64 {
+	return float64(s.temp)/340 + 36.53
+}
+
+func (s *MPU6050) Info() sensor.Info {
+	return sensor.Info{Chip: "MPU6050", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address), ID: fmt.Sprintf("WHO_AM_I 0x%02x", s.whoAmI)}
+}
+
+// Readings returns the raw acceleration, the rate of turn and the die
+// temperature.
+func (s *MPU6050) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "acceleration_x", Quantity: sensor.Acceleration, Value: float64(s.ax)},
+		{Name: "acceleration_y", Quantity: sensor.Acceleration, Value: float64(s.ay)},
+		{Name: "acceleration_z", Quantity: sensor.Acceleration, Value: float64(s.az)},
+		{Name: "rotation_x", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gx) / s.gyroLSB},
+		{Name: "rotation_y", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gy) / s.gyroLSB},
+		{Name: "rotation_z", Unit: "degrees_per_second", Quantity: sensor.AngularVelocity, Precision: 1, Value: float64(s.gz) / s.gyroLSB},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 0, Value: s.temperature()},
+	}
+}
+
+func angle(y, x float64) float64 {
+	v := math.Atan2(y, x) / math.Pi * 180
+	for v > 180 {
+		v -= 360
+	}
+	for v < -180 {
+		v += 360
+	}
+	return v
+}
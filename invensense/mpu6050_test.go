@@ -0,0 +1,115 @@
+package invensense
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// mpuDevice is a register map except for the FIFO, which is read from the
+// queue.
+type mpuDevice struct {
+	i2ctest.Registers
+	fifo []byte
+}
+
+func (d *mpuDevice) ReadBlockData(reg uint8, buf []byte) error {
+	if reg == mpu6050FIFORWReg {
+		n := copy(buf, d.fifo)
+		d.fifo = d.fifo[n:]
+		return nil
+	}
+	return d.Registers.ReadBlockData(reg, buf)
+}
+
+func (d *mpuDevice) set16(reg uint8, v int16) {
+	d.Set16(reg, uint16(v))
+}
+
+func TestMPU6050(t *testing.T) {
+	dev := &mpuDevice{Registers: i2ctest.Registers{mpu6050WhoAmIReg: 0x68}}
+	if _, err := NewMPU6050(i2c.NewBus(dev), MPU6050DefaultAddress, MPU6050Settings{AccelRate: 119}); err == nil {
+		t.Error("expected error for unsupported rate")
+	}
+	s, err := NewMPU6050(i2c.NewBus(dev), MPU6050DefaultAddress, MPU6050Settings{AccelRange: 4, GyroRange: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := dev.Registers[mpu6050SmplrtDivReg]; v != 99 {
+		t.Errorf("sample rate divider %d, expected 99 for 10 Hz", v)
+	}
+	if v := dev.Registers[mpu6050AccelConfigReg]; v != 0x08 {
+		t.Errorf("accelerometer configuration 0x%02x", v)
+	}
+	if v := dev.Registers[mpu6050UserCtrlReg]; v != 0 {
+		t.Errorf("FIFO enabled without FIFO: 0x%02x", v)
+	}
+
+	// Heeled 30°, turning at 6.5 °/s around z, at 25 °C.
+	dev.set16(mpu6050AccelXOutHReg, 0)
+	dev.set16(mpu6050AccelXOutHReg+2, 4096)
+	dev.set16(mpu6050AccelXOutHReg+4, 7094)
+	dev.set16(mpu6050AccelXOutHReg+6, -3920)
+	dev.set16(mpu6050AccelXOutHReg+12, -426)
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, yz := s.AccelerationAngles(); math.Abs(yz-60) > 0.1 {
+		t.Errorf("yz angle %v, expected 60", yz)
+	}
+	if _, _, z := s.Rotation(); math.Abs(z+6.5) > 0.01 {
+		t.Errorf("rotation %v, expected -6.5", z)
+	}
+	if temp := s.Temperature(); math.Abs(temp-25) > 0.1 {
+		t.Errorf("temperature %v, expected 25", temp)
+	}
+	if samples := s.AccelerationSamples(); len(samples) != 1 || samples[0] != (sensor.Point{X: 0, Y: 4096, Z: 7094}) {
+		t.Errorf("unexpected samples %v", samples)
+	}
+}
+
+func TestMPU6050FIFO(t *testing.T) {
+	dev := &mpuDevice{Registers: i2ctest.Registers{mpu6050WhoAmIReg: 0x72}}
+	s, err := NewMPU6050(i2c.NewBus(dev), MPU6050DefaultAddress, MPU6050Settings{AccelRate: 100, FIFO: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := dev.Registers[mpu6050UserCtrlReg]; v != mpu6050FIFOEnable {
+		t.Errorf("FIFO not enabled: 0x%02x", v)
+	}
+	if rate := s.AccelerationRate(0); rate != 100 {
+		t.Errorf("rate %v, expected 100", rate)
+	}
+
+	// 40 samples and half of the next.
+	for i := 0; i < 40; i++ {
+		dev.fifo = append(dev.fifo, 0, byte(i), 0, 0, 0x40, 0)
+	}
+	dev.fifo = append(dev.fifo, 0, 0, 0)
+	dev.set16(mpu6050FIFOCountHReg, int16(len(dev.fifo)))
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	samples := s.AccelerationSamples()
+	if len(samples) != 40 {
+		t.Fatalf("%d samples, expected 40", len(samples))
+	}
+	if samples[39] != (sensor.Point{X: 39, Y: 0, Z: 16384}) {
+		t.Errorf("unexpected last sample %v", samples[39])
+	}
+
+	// An overrun resets the FIFO.
+	dev.Registers[mpu6050IntStatusReg] = mpu6050FIFOOverrun
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.AccelerationSamples()) != 0 {
+		t.Error("expected no samples after an overrun")
+	}
+	if v := dev.Registers[mpu6050UserCtrlReg]; v != mpu6050FIFOEnable|mpu6050FIFOReset {
+		t.Errorf("FIFO not reset: 0x%02x", v)
+	}
+}
@@ -0,0 +1,184 @@
+package light
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// ROHM BH1750 ambient light sensor. It has no registers, only commands of
+// one byte, and the measurement is read as two bytes.
+//
+// The range of a single setting does not cover both a dark anchorage and
+// the midday sun, so the sensor switches between two: a sensitive one for
+// up to about 7400 lx, with a resolution of a tenth of a lux, and one for
+// up to about 120000 lx in bright daylight. The sensor measures
+// continuously, and the first measurement after a switch is ready after
+// one measurement time; until then, the previous value is kept.
+//
+// With ADDR high the address is 0x5c, the same as the LPS25H on the Sense
+// HAT.
+
+type BH1750 struct {
+	bus     *i2c.Bus
+	address int
+
+	mut         sync.Mutex
+	cached      time.Time
+	rng         bh1750Range
+	settled     time.Time // when the current range has a measurement
+	illuminance float64   // lx
+}
+
+const (
+	BH1750DefaultAddress = 0x23
+	BH1750AltAddress     = 0x5c
+)
+
+const (
+	bh1750PowerOn = 0x01
+	bh1750Reset   = 0x07
+	bh1750MTHigh  = 0x40 // | the high 3 bits of the measurement time
+	bh1750MTLow   = 0x60 // | the low 5 bits
+
+	// bh1750MTDefault is the measurement time register value the
+	// datasheet sensitivity is given for, 1.2 counts per lx.
+	bh1750MTDefault = 69
+	// bh1750MaxTime is the longest measurement time in high resolution
+	// mode at the default measurement time register value.
+	bh1750MaxTime = 180 * time.Millisecond
+)
+
+type bh1750Range struct {
+	mode uint8 // continuous measurement command
+	mt   int   // measurement time register
+	half bool  // high resolution mode 2, half a count per step
+}
+
+var (
+	bh1750Dim    = bh1750Range{mode: 0x11, mt: 254, half: true}
+	bh1750Bright = bh1750Range{mode: 0x10, mt: 31}
+)
+
+const (
+	// bh1750Saturated is the count above which the dim range switches
+	// to the bright one.
+	bh1750Saturated = 65000
+	// bh1750DimBelow is the illuminance, in lx, below which the bright
+	// range switches to the dim one; well below where the dim one
+	// saturates, so as not to switch back and forth.
+	bh1750DimBelow = 5000
+)
+
+// lux returns the illuminance of the raw count.
+func (r bh1750Range) lux(count uint16) float64 {
+	lx := float64(count) / 1.2 * bh1750MTDefault / float64(r.mt)
+	if r.half {
+		lx /= 2
+	}
+	return lx
+}
+
+// measurementTime returns the longest time a measurement takes.
+func (r bh1750Range) measurementTime() time.Duration {
+	return bh1750MaxTime * time.Duration(r.mt) / bh1750MTDefault
+}
+
+// NewBH1750 powers on and resets the BH1750 at the address, and starts
+// measuring in the dim range.
+func NewBH1750(bus *i2c.Bus, addr int) (*BH1750, error) {
+	s := &BH1750{bus: bus, address: addr}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		for _, cmd := range []uint8{bh1750PowerOn, bh1750Reset} {
+			if err := command(r, cmd); err != nil {
+				return fmt.Errorf("power on: %w", err)
+			}
+		}
+		return s.setRange(r, bh1750Dim)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// command sends a command of one byte, without data.
+func command(r *i2c.Reader, cmd uint8) error {
+	return r.WriteBlock(cmd, nil)
+}
+
+func (s *BH1750) setRange(r *i2c.Reader, rng bh1750Range) error {
+	for _, cmd := range []uint8{
+		bh1750MTHigh | uint8(rng.mt>>5),
+		bh1750MTLow | uint8(rng.mt&0x1f),
+		rng.mode,
+	} {
+		if err := command(r, cmd); err != nil {
+			return fmt.Errorf("set measurement mode: %w", err)
+		}
+	}
+	s.rng = rng
+	s.settled = time.Now().Add(rng.measurementTime())
+	return nil
+}
+
+func (s *BH1750) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+	if time.Now().Before(s.settled) {
+		if s.cached.IsZero() {
+			return ErrNoMeasurement
+		}
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data, err := r.ReadBytes(2)
+		if err != nil {
+			return fmt.Errorf("read measurement: %w", err)
+		}
+		count := uint16(data[0])<<8 | uint16(data[1])
+		s.illuminance = s.rng.lux(count)
+
+		switch {
+		case s.rng == bh1750Dim && count >= bh1750Saturated:
+			return s.setRange(r, bh1750Bright)
+		case s.rng == bh1750Bright && s.illuminance < bh1750DimBelow:
+			return s.setRange(r, bh1750Dim)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// Illuminance returns the illuminance, in lx.
+func (s *BH1750) Illuminance() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.illuminance
+}
+
+func (s *BH1750) Info() sensor.Info {
+	return sensor.Info{Chip: "BH1750", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *BH1750) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "illuminance", Unit: "lux", Quantity: sensor.Illuminance, Precision: 1, Value: s.illuminance},
+	}
+}
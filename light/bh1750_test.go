@@ -0,0 +1,84 @@
+package light
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+// cmdDevice records the commands written and answers reads with the count.
+type cmdDevice struct {
+	i2c.Device
+	sent  []byte
+	count uint16
+}
+
+func (d *cmdDevice) SetAddress(addr int) error { return nil }
+
+func (d *cmdDevice) Write(data []byte) (int, error) {
+	d.sent = append(d.sent, data...)
+	return len(data), nil
+}
+
+func (d *cmdDevice) Read(buf []byte) (int, error) {
+	return copy(buf, []byte{byte(d.count >> 8), byte(d.count)}), nil
+}
+
+func TestBH1750(t *testing.T) {
+	dev := &cmdDevice{count: 1000}
+	s, err := NewBH1750(i2c.NewBus(dev), BH1750DefaultAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Power on, reset, measurement time 254 and high resolution mode 2.
+	if exp := []byte{0x01, 0x07, 0x47, 0x7e, 0x11}; string(dev.sent) != string(exp) {
+		t.Errorf("sent %x, expected %x", dev.sent, exp)
+	}
+	if err := s.Refresh(0); err != ErrNoMeasurement {
+		t.Errorf("expected no measurement yet, got %v", err)
+	}
+
+	s.settled = time.Time{}
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if lx := s.Illuminance(); math.Abs(lx-113.19) > 0.01 {
+		t.Errorf("got %.2f lx, expected 113.19", lx)
+	}
+
+	// Saturating switches to the bright range...
+	dev.sent, dev.count = nil, 65535
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []byte{0x40, 0x7f, 0x10}; string(dev.sent) != string(exp) {
+		t.Errorf("sent %x, expected %x", dev.sent, exp)
+	}
+	// ...where the value is kept until the first measurement.
+	lx := s.Illuminance()
+	dev.count = 40000
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.Illuminance() != lx {
+		t.Error("expected the value kept while switching")
+	}
+	s.settled = time.Time{}
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if lx := s.Illuminance(); math.Abs(lx-74193.5) > 0.1 {
+		t.Errorf("got %.1f lx, expected 74193.5", lx)
+	}
+
+	// Dusk switches back.
+	dev.sent, dev.count = nil, 100
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.rng != bh1750Dim {
+		t.Error("expected the dim range")
+	}
+}
@@ -0,0 +1,11 @@
+// Package light reads ambient light sensors. They cover the range from
+// moonlight to direct sun by switching their sensitivity as the light
+// changes, and keep the previous value while a measurement in the new
+// setting is under way.
+package light
+
+import "errors"
+
+// ErrNoMeasurement is returned by Refresh until the first measurement is
+// ready.
+var ErrNoMeasurement = errors.New("no measurement yet")
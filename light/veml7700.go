@@ -0,0 +1,187 @@
+package light
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Vishay VEML7700 ambient light sensor. Its registers are 16 bits, least
+// significant byte first.
+//
+// The sensitivity is set by the gain and the integration time, which are
+// stepped through as in the application note ("Designing the VEML7700
+// Into an Application"): more sensitive when the count is below 100, less
+// when it is above 10000. That covers from about 0.004 lx to direct sun.
+// At the two lowest gains the sensor is not linear above about 1000 lx,
+// which is corrected for with the polynomial of the application note up
+// to 10000 lx, and in proportion above.
+
+type VEML7700 struct {
+	bus     *i2c.Bus
+	address int
+
+	mut         sync.Mutex
+	cached      time.Time
+	step        int       // in veml7700Steps
+	settled     time.Time // when the current step has a measurement
+	illuminance float64   // lx
+}
+
+const VEML7700DefaultAddress = 0x10
+
+const (
+	veml7700ConfReg = 0x00
+	veml7700ALSReg  = 0x04
+	veml7700IDReg   = 0x07
+
+	veml7700ID = 0x81 // the low byte of the ID register
+
+	// veml7700MaxResolution is lx per count at gain 2 and 800 ms.
+	veml7700MaxResolution = 0.0042
+
+	veml7700CountLow  = 100
+	veml7700CountHigh = 10000
+
+	// veml7700CorrectionMax is the illuminance, in lx, up to which the
+	// polynomial holds; it grows without bound above it, so brighter light
+	// is corrected in proportion.
+	veml7700CorrectionMax = 10000
+)
+
+type veml7700Step struct {
+	gainBits uint16
+	gain     float64
+	itBits   uint16
+	it       time.Duration
+}
+
+// veml7700Steps are the settings from the least to the most sensitive.
+var veml7700Steps = []veml7700Step{
+	{0b10, 0.125, 0b1100, 25 * time.Millisecond},
+	{0b10, 0.125, 0b1000, 50 * time.Millisecond},
+	{0b10, 0.125, 0b0000, 100 * time.Millisecond},
+	{0b11, 0.25, 0b0000, 100 * time.Millisecond},
+	{0b00, 1, 0b0000, 100 * time.Millisecond},
+	{0b01, 2, 0b0000, 100 * time.Millisecond},
+	{0b01, 2, 0b0001, 200 * time.Millisecond},
+	{0b01, 2, 0b0010, 400 * time.Millisecond},
+	{0b01, 2, 0b0011, 800 * time.Millisecond},
+}
+
+// veml7700Start is the step measured first: gain 1/8 and 100 ms, as the
+// application note suggests.
+const veml7700Start = 2
+
+// conf returns the configuration register, powered on.
+func (s veml7700Step) conf() uint16 {
+	return s.gainBits<<11 | s.itBits<<6
+}
+
+// lux returns the illuminance of the raw count.
+func (s veml7700Step) lux(count uint16) float64 {
+	lx := float64(count) * veml7700MaxResolution * (2 / s.gain) * float64(800*time.Millisecond) / float64(s.it)
+	if s.gain < 1 {
+		if lx > veml7700CorrectionMax {
+			return lx * veml7700Correct(veml7700CorrectionMax) / veml7700CorrectionMax
+		}
+		return veml7700Correct(lx)
+	}
+	return lx
+}
+
+// veml7700Correct corrects the nonlinearity at the lowest gains.
+func veml7700Correct(lx float64) float64 {
+	return ((6.0135e-13*lx-9.3924e-9)*lx+8.1488e-5)*lx*lx + 1.0023*lx
+}
+
+// NewVEML7700 checks the VEML7700 at the address and starts measuring.
+func NewVEML7700(bus *i2c.Bus, addr int) (*VEML7700, error) {
+	s := &VEML7700{bus: bus, address: addr}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		id := r.Block(veml7700IDReg, 2)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read ID: %w", err)
+		}
+		if id[0] != veml7700ID {
+			return fmt.Errorf("unknown device ID 0x%02x", id[0])
+		}
+		return s.setStep(r, veml7700Start)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *VEML7700) setStep(r *i2c.Reader, step int) error {
+	conf := veml7700Steps[step].conf()
+	if err := r.WriteBlock(veml7700ConfReg, []byte{byte(conf), byte(conf >> 8)}); err != nil {
+		return fmt.Errorf("write configuration: %w", err)
+	}
+	s.step = step
+	// A measurement started before the change may be in progress.
+	s.settled = time.Now().Add(2 * veml7700Steps[step].it)
+	return nil
+}
+
+func (s *VEML7700) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+	if time.Now().Before(s.settled) {
+		if s.cached.IsZero() {
+			return ErrNoMeasurement
+		}
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(veml7700ALSReg, 2)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read measurement: %w", err)
+		}
+		count := uint16(data[0]) | uint16(data[1])<<8
+		s.illuminance = veml7700Steps[s.step].lux(count)
+
+		switch {
+		case count < veml7700CountLow && s.step < len(veml7700Steps)-1:
+			return s.setStep(r, s.step+1)
+		case count > veml7700CountHigh && s.step > 0:
+			return s.setStep(r, s.step-1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// Illuminance returns the illuminance, in lx.
+func (s *VEML7700) Illuminance() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.illuminance
+}
+
+func (s *VEML7700) Info() sensor.Info {
+	return sensor.Info{Chip: "VEML7700", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *VEML7700) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "illuminance", Unit: "lux", Quantity: sensor.Illuminance, Precision: 2, Value: s.illuminance},
+	}
+}
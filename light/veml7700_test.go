@@ -0,0 +1,69 @@
+package light
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+func TestVEML7700(t *testing.T) {
+	dev := i2ctest.WordsLE{veml7700IDReg: 0xc481, veml7700ALSReg: 50}
+	s, err := NewVEML7700(i2c.NewBus(dev), VEML7700DefaultAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Gain 1/8, 100 ms, powered on.
+	if conf := dev[veml7700ConfReg]; conf != 0x1000 {
+		t.Errorf("unexpected configuration 0x%04x", conf)
+	}
+	if err := s.Refresh(0); err != ErrNoMeasurement {
+		t.Errorf("expected no measurement yet, got %v", err)
+	}
+
+	// Dark; steps up in sensitivity until the count is large enough.
+	for i := 0; s.step < len(veml7700Steps)-1; i++ {
+		if i > len(veml7700Steps) {
+			t.Fatal("expected the most sensitive step")
+		}
+		s.settled = time.Time{}
+		if err := s.Refresh(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conf := dev[veml7700ConfReg]; conf != 0x08c0 {
+		t.Errorf("unexpected configuration 0x%04x", conf)
+	}
+	s.settled = time.Time{}
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if lx := s.Illuminance(); math.Abs(lx-0.21) > 0.001 {
+		t.Errorf("got %.3f lx, expected 0.21", lx)
+	}
+
+	// Direct sun, with the nonlinearity corrected.
+	s.step = 0
+	dev[veml7700ALSReg] = 40000
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if lx := s.Illuminance(); math.Abs(lx-127243) > 1 {
+		t.Errorf("got %.0f lx, expected 127243", lx)
+	}
+	if s.step != 0 {
+		t.Error("expected the least sensitive step")
+	}
+}
+
+func TestVEML7700Linear(t *testing.T) {
+	// The correction is small at low light.
+	for i, step := range veml7700Steps {
+		res := veml7700MaxResolution * (2 / step.gain) * float64(800*time.Millisecond) / float64(step.it)
+		if lx := step.lux(100); math.Abs(lx-100*res)/(100*res) > 0.02 {
+			t.Errorf("step %d: got %.3f lx, expected %.3f", i, lx, 100*res)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package maxbotix reads the MaxBotix ultrasonic rangefinders.
+package maxbotix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/sensor"
+	"github.com/calmh/boatpi/serial"
+)
+
+// MaxBotix HRXL-MaxSonar (MB7360 and relatives, including the weather
+// resistant WR models that suit a tank), reporting the range to the
+// nearest target continuously on its serial output as "R" and four digits
+// of millimetres, each ended by a CR, at 9600 baud. The output is RS232
+// levels inverted, or TTL on the TTL models; either way it wants a suitable
+// adapter. The range is reported as 300 mm for anything nearer and as the
+// maximum range (5000 or 9999 mm) when there is no target.
+
+type MaxSonar struct {
+	device string
+
+	mut     sync.Mutex
+	latest  float64 // metres
+	updated time.Time
+	cached  time.Time
+	dist    float64 // metres, as of the last refresh
+}
+
+// MaxSonarBaudRate is the fixed baud rate of the serial output.
+const MaxSonarBaudRate = 9600
+
+// maxSonarStale is how old the latest range may be; the sensor reports
+// several times a second.
+const maxSonarStale = 5 * time.Second
+
+// ErrNoRange is returned by Refresh when no range has been received
+// recently.
+var ErrNoRange = errors.New("no recent range")
+
+// NewMaxSonar reads the rangefinder on the serial port at the device path,
+// until the context is done.
+func NewMaxSonar(ctx context.Context, device string) (*MaxSonar, error) {
+	port, err := serial.Open(device, MaxSonarBaudRate)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", device, err)
+	}
+	s := &MaxSonar{device: device}
+	lines := port.Subscribe(ctx)
+	go func() {
+		defer port.Close()
+		for line := range lines {
+			s.line(time.Now(), line)
+		}
+	}()
+	return s, nil
+}
+
+func (s *MaxSonar) line(now time.Time, line string) {
+	mm, ok := parseRange(line)
+	if !ok {
+		return
+	}
+	s.mut.Lock()
+	s.latest = float64(mm) / 1000
+	s.updated = now
+	s.mut.Unlock()
+}
+
+// parseRange returns the range in millimetres from an "R1234" line.
+func parseRange(line string) (int, bool) {
+	if len(line) != 5 || line[0] != 'R' {
+		return 0, false
+	}
+	mm, err := strconv.Atoi(line[1:])
+	if err != nil || mm < 0 {
+		return 0, false
+	}
+	return mm, true
+}
+
+// Refresh takes the latest range received, which must be recent.
+func (s *MaxSonar) Refresh(maxAge time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if time.Since(s.cached) < maxAge {
+		return nil
+	}
+	if time.Since(s.updated) > maxSonarStale {
+		return ErrNoRange
+	}
+	s.dist = s.latest
+	s.cached = time.Now()
+	return nil
+}
+
+// Distance returns the range in metres as of the last refresh.
+func (s *MaxSonar) Distance() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.dist
+}
+
+func (s *MaxSonar) Info() sensor.Info {
+	return sensor.Info{Chip: "HRXL-MaxSonar", Bus: "serial", Address: s.device}
+}
+
+func (s *MaxSonar) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "distance", Unit: "metres", Quantity: sensor.Length, Precision: 3, Value: s.dist},
+	}
+}
@@ -0,0 +1,48 @@
+package maxbotix
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		line string
+		mm   int
+		ok   bool
+	}{
+		{"R0452", 452, true},
+		{"R5000", 5000, true},
+		{"R045", 0, false},
+		{"R04521", 0, false},
+		{"X0452", 0, false},
+		{"R04x2", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range cases {
+		if mm, ok := parseRange(tc.line); mm != tc.mm || ok != tc.ok {
+			t.Errorf("parseRange(%q) = %d, %v; expected %d, %v", tc.line, mm, ok, tc.mm, tc.ok)
+		}
+	}
+}
+
+func TestMaxSonarRefresh(t *testing.T) {
+	s := &MaxSonar{device: "/dev/test"}
+	if err := s.Refresh(0); err != ErrNoRange {
+		t.Errorf("expected no range before any line, got %v", err)
+	}
+
+	s.line(time.Now(), "R0452")
+	s.line(time.Now(), "garbage")
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Distance(); d != 0.452 {
+		t.Errorf("distance %v, expected 0.452", d)
+	}
+
+	s.line(time.Now().Add(-time.Minute), "R0300")
+	if err := s.Refresh(0); err != ErrNoRange {
+		t.Errorf("expected a stale range refused, got %v", err)
+	}
+}
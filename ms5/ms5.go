@@ -0,0 +1,116 @@
+// Package ms5 reads the TE Connectivity (formerly Measurement Specialties)
+// MS5xxx family of pressure sensors. They share a command set: after a
+// reset, the factory calibration is read from a PROM protected by a CRC,
+// and each reading is a pair of raw pressure and temperature conversions
+// that are compensated with the calibration.
+package ms5
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+const (
+	cmdReset   = 0x1e
+	cmdConvD1  = 0x48 // pressure, oversampling 4096
+	cmdConvD2  = 0x58 // temperature, oversampling 4096
+	cmdADCRead = 0x00
+	cmdPROM    = 0xa0 // + 2 × word
+
+	resetTime   = 3 * time.Millisecond
+	convertTime = 10 * time.Millisecond // 9.04 ms at oversampling 4096
+)
+
+// prom is the calibration PROM: C1 to C6 are the calibration
+// coefficients, and C0 or C7 holds the CRC depending on the part.
+type prom [8]uint16
+
+// readPROM resets the sensor and reads the given number of PROM words.
+func readPROM(r *i2c.Reader, words int) (prom, error) {
+	var p prom
+	if err := r.WriteBlock(cmdReset, nil); err != nil {
+		return p, fmt.Errorf("reset: %w", err)
+	}
+	time.Sleep(resetTime)
+	for i := 0; i < words; i++ {
+		data := r.Block(cmdPROM+uint8(2*i), 2)
+		if err := r.Error(); err != nil {
+			return p, fmt.Errorf("read PROM: %w", err)
+		}
+		p[i] = uint16(data[0])<<8 | uint16(data[1])
+	}
+	return p, nil
+}
+
+var errCRC = errors.New("PROM CRC mismatch")
+
+// checkCRC0 checks the CRC in the top four bits of C0, as on the MS5837
+// and the other newer parts.
+func checkCRC0(p prom) error {
+	crc := p[0] >> 12
+	p[0] &= 0x0fff
+	p[7] = 0
+	if crc4(p) != crc {
+		return errCRC
+	}
+	return nil
+}
+
+// checkCRC7 checks the CRC in the low four bits of C7, as on the MS5611.
+func checkCRC7(p prom) error {
+	crc := p[7] & 0xf
+	p[7] &= 0xff00
+	if crc4(p) != crc {
+		return errCRC
+	}
+	return nil
+}
+
+// crc4 is the PROM CRC from the datasheets, over the PROM with the CRC
+// bits cleared.
+func crc4(p prom) uint16 {
+	var rem uint16
+	for cnt := 0; cnt < 16; cnt++ {
+		if cnt%2 == 1 {
+			rem ^= p[cnt>>1] & 0xff
+		} else {
+			rem ^= p[cnt>>1] >> 8
+		}
+		for bit := 8; bit > 0; bit-- {
+			if rem&0x8000 != 0 {
+				rem = rem<<1 ^ 0x3000
+			} else {
+				rem <<= 1
+			}
+		}
+	}
+	return rem >> 12 & 0xf
+}
+
+// convert reads the raw pressure (D1) and temperature (D2).
+func convert(r *i2c.Reader) (d1, d2 int64, err error) {
+	for i, cmd := range []uint8{cmdConvD1, cmdConvD2} {
+		if err := r.WriteBlock(cmd, nil); err != nil {
+			return 0, 0, fmt.Errorf("start conversion: %w", err)
+		}
+		time.Sleep(convertTime)
+		data := r.Block(cmdADCRead, 3)
+		if err := r.Error(); err != nil {
+			return 0, 0, fmt.Errorf("read conversion: %w", err)
+		}
+		v := int64(data[0])<<16 | int64(data[1])<<8 | int64(data[2])
+		if v == 0 {
+			// The conversion was interrupted, or not started.
+			return 0, 0, errors.New("no conversion result")
+		}
+		if i == 0 {
+			d1 = v
+		} else {
+			d2 = v
+		}
+	}
+	return d1, d2, nil
+}
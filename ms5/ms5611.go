@@ -0,0 +1,119 @@
+package ms5
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// MS5611-01BA barometer, 10 to 1200 mbar in 0.012 mbar steps at the
+// highest oversampling; noticeably less noisy than the LPS25H, which helps
+// with the pressure tendency and squall detection.
+
+type MS5611 struct {
+	bus     *i2c.Bus
+	address int
+	prom    prom
+
+	mut         sync.Mutex
+	cached      time.Time
+	pressure    float64 // mbar
+	temperature float64 // °C
+}
+
+// MS5611DefaultAddress is the address with the CSB pin low, as on most
+// breakout boards; with CSB high it is 0x76.
+const MS5611DefaultAddress = 0x77
+
+func NewMS5611(bus *i2c.Bus, addr int) (*MS5611, error) {
+	s := &MS5611{bus: bus, address: addr}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		var err error
+		if s.prom, err = readPROM(i2c.NewReader(dev), 8); err != nil {
+			return err
+		}
+		return checkCRC7(s.prom)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *MS5611) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	var d1, d2 int64
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		var err error
+		d1, d2, err = convert(i2c.NewReader(dev))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.pressure, s.temperature = s.compensate(d1, d2)
+	s.cached = time.Now()
+	return nil
+}
+
+// compensate returns the pressure in mbar and the temperature in °C, with
+// the second order compensation of the MS5611-01BA datasheet.
+func (s *MS5611) compensate(d1, d2 int64) (pressure, temperature float64) {
+	c := s.prom
+	dT := d2 - int64(c[5])<<8
+	temp := 2000 + dT*int64(c[6])>>23
+	off := int64(c[2])<<16 + int64(c[4])*dT>>7
+	sens := int64(c[1])<<15 + int64(c[3])*dT>>8
+
+	if temp < 2000 {
+		t2 := dT * dT >> 31
+		off2 := 5 * (temp - 2000) * (temp - 2000) >> 1
+		sens2 := 5 * (temp - 2000) * (temp - 2000) >> 2
+		if temp < -1500 {
+			off2 += 7 * (temp + 1500) * (temp + 1500)
+			sens2 += 11 * (temp + 1500) * (temp + 1500) >> 1
+		}
+		temp -= t2
+		off -= off2
+		sens -= sens2
+	}
+
+	p := (d1*sens>>21 - off) >> 15 // 0.01 mbar
+	return float64(p) / 100, float64(temp) / 100
+}
+
+// Pressure returns the pressure, in mbar.
+func (s *MS5611) Pressure() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.pressure
+}
+
+// Temperature returns the temperature, in °C.
+func (s *MS5611) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature
+}
+
+func (s *MS5611) Info() sensor.Info {
+	return sensor.Info{Chip: "MS5611", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *MS5611) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "pressure", Unit: "mb", Quantity: sensor.Pressure, Precision: 2, Value: s.pressure},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: s.temperature},
+	}
+}
@@ -0,0 +1,138 @@
+package ms5
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// MS5837-30BA water pressure sensor, as in the Blue Robotics Bar30: up to
+// 30 bar, or close to 300 m of water, in 0.2 mbar steps. Mounted through
+// the hull or lowered in a tank, it gives the depth of water above it and
+// the water temperature.
+
+type MS5837 struct {
+	bus  *i2c.Bus
+	prom prom
+
+	mut         sync.Mutex
+	cached      time.Time
+	pressure    float64 // mbar
+	temperature float64 // °C
+}
+
+// MS5837Address is the fixed address of the MS5837.
+const MS5837Address = 0x76
+
+// Water densities, in kg/m³.
+const (
+	FreshWater = 997.0
+	SaltWater  = 1025.0
+)
+
+const gravity = 9.80665 // m/s²
+
+func NewMS5837(bus *i2c.Bus) (*MS5837, error) {
+	s := &MS5837{bus: bus}
+	err := bus.Do(MS5837Address, func(dev i2c.Device) error {
+		var err error
+		if s.prom, err = readPROM(i2c.NewReader(dev), 7); err != nil {
+			return err
+		}
+		return checkCRC0(s.prom)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *MS5837) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	var d1, d2 int64
+	err := s.bus.Do(MS5837Address, func(dev i2c.Device) error {
+		var err error
+		d1, d2, err = convert(i2c.NewReader(dev))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.pressure, s.temperature = s.compensate(d1, d2)
+	s.cached = time.Now()
+	return nil
+}
+
+// compensate returns the pressure in mbar and the temperature in °C, with
+// the second order compensation of the MS5837-30BA datasheet.
+func (s *MS5837) compensate(d1, d2 int64) (pressure, temperature float64) {
+	c := s.prom
+	dT := d2 - int64(c[5])<<8
+	temp := 2000 + dT*int64(c[6])>>23
+	off := int64(c[2])<<16 + int64(c[4])*dT>>7
+	sens := int64(c[1])<<15 + int64(c[3])*dT>>8
+
+	var ti, offi, sensi int64
+	if temp < 2000 {
+		ti = 3 * dT * dT >> 33
+		offi = 3 * (temp - 2000) * (temp - 2000) >> 1
+		sensi = 5 * (temp - 2000) * (temp - 2000) >> 3
+		if temp < -1500 {
+			offi += 7 * (temp + 1500) * (temp + 1500)
+			sensi += 4 * (temp + 1500) * (temp + 1500)
+		}
+	} else {
+		ti = 2 * dT * dT >> 37
+		offi = (temp - 2000) * (temp - 2000) >> 4
+	}
+	off -= offi
+	sens -= sensi
+	temp -= ti
+
+	p := (d1*sens>>21 - off) >> 13 // 0.1 mbar
+	return float64(p) / 10, float64(temp) / 100
+}
+
+// Pressure returns the absolute pressure, in mbar.
+func (s *MS5837) Pressure() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.pressure
+}
+
+// Temperature returns the water temperature, in °C.
+func (s *MS5837) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature
+}
+
+// Depth returns the depth of water above the sensor, in metres, given the
+// air pressure at the surface in mbar and the water density in kg/m³.
+func (s *MS5837) Depth(surface, density float64) float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return (s.pressure - surface) * 100 / (density * gravity)
+}
+
+func (s *MS5837) Info() sensor.Info {
+	return sensor.Info{Chip: "MS5837-30BA", Bus: "i2c", Address: fmt.Sprintf("0x%02x", MS5837Address)}
+}
+
+func (s *MS5837) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "pressure", Unit: "mb", Quantity: sensor.Pressure, Precision: 0, Value: s.pressure},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: s.temperature},
+	}
+}
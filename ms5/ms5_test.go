@@ -0,0 +1,106 @@
+package ms5
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+// cmdDevice answers the MS5xxx commands from a PROM and fixed conversion
+// results.
+type cmdDevice struct {
+	i2c.Device
+	prom   prom
+	d1, d2 uint32
+	conv   uint8 // the conversion last started
+}
+
+func (d *cmdDevice) SetAddress(addr int) error { return nil }
+
+func (d *cmdDevice) WriteBlockData(cmd uint8, data []byte) error {
+	d.conv = cmd
+	return nil
+}
+
+func (d *cmdDevice) ReadBlockData(cmd uint8, buf []byte) error {
+	switch {
+	case cmd >= cmdPROM && cmd < cmdPROM+16:
+		w := d.prom[(cmd-cmdPROM)/2]
+		buf[0], buf[1] = byte(w>>8), byte(w)
+	case cmd == cmdADCRead:
+		v := d.d1
+		if d.conv == cmdConvD2 {
+			v = d.d2
+		}
+		buf[0], buf[1], buf[2] = byte(v>>16), byte(v>>8), byte(v)
+		d.conv = 0
+	}
+	return nil
+}
+
+func testPROM() prom {
+	p := prom{0x0040, 34982, 36352, 20328, 22354, 26646, 26146}
+	p[0] |= crc4(p) << 12
+	return p
+}
+
+func TestMS5837(t *testing.T) {
+	// D2 equal to C5 × 256 is exactly 20 °C, where the second order
+	// compensation is zero.
+	dev := &cmdDevice{prom: testPROM(), d1: 4510461, d2: 26646 << 8}
+	s, err := NewMS5837(i2c.NewBus(dev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.Temperature() != 20 || math.Abs(s.Pressure()-1013.4) > 1e-9 {
+		t.Errorf("unexpected pressure %v and temperature %v", s.Pressure(), s.Temperature())
+	}
+
+	// Colder water reads lower, with the second order compensation.
+	dev.d2 = 26646<<8 - 50000
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if temp := s.Temperature(); temp > 19 || temp < 10 {
+		t.Errorf("unexpected temperature %v", temp)
+	}
+
+	s.pressure = 1013.25 + 10*SaltWater*gravity/100
+	if d := s.Depth(1013.25, SaltWater); math.Abs(d-10) > 1e-9 {
+		t.Errorf("unexpected depth %v", d)
+	}
+}
+
+func TestPROMCRC(t *testing.T) {
+	dev := &cmdDevice{prom: testPROM()}
+	dev.prom[3]++
+	if _, err := NewMS5837(i2c.NewBus(dev)); err == nil {
+		t.Error("expected CRC error")
+	}
+}
+
+func TestMS5611(t *testing.T) {
+	// The example from the datasheet, with the CRC filled in.
+	p := prom{0, 40127, 36924, 23317, 23282, 33464, 28312}
+	p[7] = crc4(p)
+	dev := &cmdDevice{prom: p, d1: 9085466, d2: 8569150}
+	s, err := NewMS5611(i2c.NewBus(dev), MS5611DefaultAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.Temperature() != 20.07 || s.Pressure() != 1000.09 {
+		t.Errorf("unexpected pressure %v and temperature %v", s.Pressure(), s.Temperature())
+	}
+
+	dev.prom[7] ^= 1
+	if _, err := NewMS5611(i2c.NewBus(dev), MS5611DefaultAddress); err == nil {
+		t.Error("expected CRC error")
+	}
+}
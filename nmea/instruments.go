@@ -0,0 +1,240 @@
+package nmea
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Instruments keeps the latest data from the instruments on an NMEA bus:
+// the depth sounder, log, wind instrument, compass and GPS. Lines may come
+// from any number of sources; the latest sentence of a kind wins.
+type Instruments struct {
+	mut      sync.Mutex
+	data     Data
+	received time.Time
+	stats    map[string]TalkerStats
+}
+
+// A Reading is a value and the time it was received. The time is zero if
+// no sentence carrying the value has been seen.
+type Reading struct {
+	Value float64
+	Time  time.Time
+}
+
+func (r *Reading) set(v float64, now time.Time) {
+	r.Value, r.Time = v, now
+}
+
+type Data struct {
+	Depth             Reading // metres below the transducer, adjusted by the offset (DPT)
+	WaterTemperature  Reading // °C (MTW)
+	ApparentWindAngle Reading // degrees off the bow, clockwise (MWV)
+	ApparentWindSpeed Reading // knots (MWV)
+	TrueWindAngle     Reading // degrees off the bow, clockwise (MWV)
+	TrueWindSpeed     Reading // knots (MWV)
+	SpeedThroughWater Reading // knots (VHW)
+	MagneticHeading   Reading // degrees (HDG, HDM, VHW)
+	TrueHeading       Reading // degrees (HDG with variation, HDT, VHW)
+	Latitude          Reading // degrees (GGA, RMC)
+	Longitude         Reading // degrees (GGA, RMC)
+	SpeedOverGround   Reading // knots (RMC)
+	CourseOverGround  Reading // degrees true (RMC)
+}
+
+func NewInstruments() *Instruments {
+	return &Instruments{stats: make(map[string]TalkerStats)}
+}
+
+// Line handles a received line, which may or may not be a sentence. It is
+// safe to call from several goroutines.
+func (in *Instruments) Line(line string) {
+	s, err := Parse(line)
+	if err == ErrNotSentence {
+		return
+	}
+	in.mut.Lock()
+	defer in.mut.Unlock()
+	now := time.Now()
+	in.received = now
+	if err == nil && !in.data.handle(s, now) {
+		err = ErrFields
+	}
+	CountSentence(in.stats, s.Talker, err)
+}
+
+// handle updates the data from the sentence. It returns false if the
+// sentence lacks fields required for its type. Sentence types without
+// instrument data are ignored.
+func (d *Data) handle(s Sentence, now time.Time) bool {
+	switch s.Type {
+	case "DPT":
+		// Depth below the transducer in metres, and the offset from
+		// the transducer: positive to the waterline, negative to the
+		// keel.
+		depth, ok := s.Float(0)
+		if !ok {
+			return false
+		}
+		if offset, ok := s.Float(1); ok {
+			depth += offset
+		}
+		d.Depth.set(depth, now)
+
+	case "MTW":
+		temp, ok := s.Float(0)
+		if !ok || s.Field(1) != "C" {
+			return false
+		}
+		d.WaterTemperature.set(temp, now)
+
+	case "MWV":
+		// Angle, reference (R = relative, T = true), speed, unit (K,
+		// M or N) and status.
+		if s.Field(4) != "A" {
+			return true
+		}
+		angle, ok1 := s.Float(0)
+		speed, ok2 := s.Float(2)
+		if !ok1 || !ok2 {
+			return false
+		}
+		switch s.Field(3) {
+		case "N":
+		case "K":
+			speed /= 1.852
+		case "M":
+			speed *= 3600 / 1852.0
+		default:
+			return false
+		}
+		switch s.Field(1) {
+		case "R":
+			d.ApparentWindAngle.set(angle, now)
+			d.ApparentWindSpeed.set(speed, now)
+		case "T":
+			d.TrueWindAngle.set(angle, now)
+			d.TrueWindSpeed.set(speed, now)
+		default:
+			return false
+		}
+
+	case "VHW":
+		// Heading true and magnetic, each followed by its unit, and
+		// speed through the water in knots and km/h.
+		if stw, ok := s.Float(4); ok {
+			d.SpeedThroughWater.set(stw, now)
+		} else if stw, ok := s.Float(6); ok {
+			d.SpeedThroughWater.set(stw/1.852, now)
+		} else {
+			return false
+		}
+		if hdg, ok := s.Float(0); ok {
+			d.TrueHeading.set(hdg, now)
+		}
+		if hdg, ok := s.Float(2); ok {
+			d.MagneticHeading.set(hdg, now)
+		}
+
+	case "HDG":
+		// Magnetic sensor heading, deviation and variation, each of
+		// the latter followed by E or W.
+		hdg, ok := s.Float(0)
+		if !ok {
+			return false
+		}
+		if dev, ok := s.Float(1); ok {
+			hdg += eastward(dev, s.Field(2))
+		}
+		d.MagneticHeading.set(normalize(hdg), now)
+		if vari, ok := s.Float(3); ok {
+			d.TrueHeading.set(normalize(hdg+eastward(vari, s.Field(4))), now)
+		}
+
+	case "HDM", "HDT":
+		hdg, ok := s.Float(0)
+		if !ok {
+			return false
+		}
+		if s.Type == "HDM" {
+			d.MagneticHeading.set(hdg, now)
+		} else {
+			d.TrueHeading.set(hdg, now)
+		}
+
+	case "GGA":
+		if q, ok := s.Int(5); !ok || q == 0 {
+			return ok
+		}
+		lat, ok1 := s.Coordinate(1)
+		lon, ok2 := s.Coordinate(3)
+		if !ok1 || !ok2 {
+			return false
+		}
+		d.Latitude.set(lat, now)
+		d.Longitude.set(lon, now)
+
+	case "RMC":
+		if s.Field(1) != "A" {
+			return true
+		}
+		lat, ok1 := s.Coordinate(2)
+		lon, ok2 := s.Coordinate(4)
+		if !ok1 || !ok2 {
+			return false
+		}
+		d.Latitude.set(lat, now)
+		d.Longitude.set(lon, now)
+		if sog, ok := s.Float(6); ok {
+			d.SpeedOverGround.set(sog, now)
+		}
+		if cog, ok := s.Float(7); ok {
+			d.CourseOverGround.set(cog, now)
+		}
+	}
+	return true
+}
+
+// eastward returns the deviation or variation as positive when east.
+func eastward(v float64, dir string) float64 {
+	if strings.EqualFold(dir, "W") {
+		return -v
+	}
+	return v
+}
+
+func normalize(deg float64) float64 {
+	for deg < 0 {
+		deg += 360
+	}
+	for deg >= 360 {
+		deg -= 360
+	}
+	return deg
+}
+
+// Data returns the latest instrument data.
+func (in *Instruments) Data() Data {
+	in.mut.Lock()
+	defer in.mut.Unlock()
+	return in.data
+}
+
+// Received returns the time the last sentence was received, valid or not.
+func (in *Instruments) Received() time.Time {
+	in.mut.Lock()
+	defer in.mut.Unlock()
+	return in.received
+}
+
+// Stats returns the sentence counters per talker.
+func (in *Instruments) Stats() map[string]TalkerStats {
+	in.mut.Lock()
+	defer in.mut.Unlock()
+	stats := make(map[string]TalkerStats, len(in.stats))
+	for talker, st := range in.stats {
+		stats[talker] = st
+	}
+	return stats
+}
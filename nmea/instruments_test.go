@@ -0,0 +1,121 @@
+package nmea
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInstruments(t *testing.T) {
+	in := NewInstruments()
+	for _, line := range []string{
+		"$SDDPT,12.3,0.5*62",
+		"$YXMTW,14.5,C",
+		"$WIMWV,045.0,R,10.0,M,A",
+		"$WIMWV,060.0,T,20.0,K,A",
+		"$WIMWV,090.0,T,99.0,N,V",
+		"$VWVHW,,T,,M,5.5,N,10.2,K",
+		"$HCHDG,98.3,1.0,W,3.5,E",
+		"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A",
+		"$IIMWV,010.0,X,5.0,N,A",
+		"$YXMTW,14.5,F",
+	} {
+		in.Line(line)
+	}
+	d := in.Data()
+
+	cases := []struct {
+		name string
+		r    Reading
+		want float64
+	}{
+		{"depth", d.Depth, 12.8},
+		{"water temperature", d.WaterTemperature, 14.5},
+		{"apparent wind angle", d.ApparentWindAngle, 45},
+		{"apparent wind speed", d.ApparentWindSpeed, 19.438},
+		{"true wind angle", d.TrueWindAngle, 60},
+		{"true wind speed", d.TrueWindSpeed, 10.799},
+		{"speed through water", d.SpeedThroughWater, 5.5},
+		{"magnetic heading", d.MagneticHeading, 97.3},
+		{"true heading", d.TrueHeading, 100.8},
+		{"latitude", d.Latitude, 48.1173},
+		{"speed over ground", d.SpeedOverGround, 22.4},
+		{"course over ground", d.CourseOverGround, 84.4},
+	}
+	for _, tc := range cases {
+		if tc.r.Time.IsZero() {
+			t.Errorf("%s: not set", tc.name)
+		} else if math.Abs(tc.r.Value-tc.want) > 1e-3 {
+			t.Errorf("%s: %v, expected %v", tc.name, tc.r.Value, tc.want)
+		}
+	}
+
+	stats := in.Stats()
+	if st := stats["II"]; st != (TalkerStats{Fields: 1}) {
+		t.Errorf("unexpected II stats %+v", st)
+	}
+	if st := stats["YX"]; st != (TalkerStats{Sentences: 1, Fields: 1}) {
+		t.Errorf("unexpected YX stats %+v", st)
+	}
+	if st := stats["WI"]; st != (TalkerStats{Sentences: 3}) {
+		t.Errorf("unexpected WI stats %+v", st)
+	}
+}
+
+func TestHeadingWraps(t *testing.T) {
+	in := NewInstruments()
+	in.Line("$HCHDG,358.0,,,4.0,E")
+	in.Line("$HCHDG,1.0,2.5,W,,")
+	d := in.Data()
+	if v := d.TrueHeading.Value; math.Abs(v-2) > 1e-6 {
+		t.Errorf("true heading %v, expected 2", v)
+	}
+	if v := d.MagneticHeading.Value; math.Abs(v-358.5) > 1e-6 {
+		t.Errorf("magnetic heading %v, expected 358.5", v)
+	}
+}
+
+func TestUDPSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Find a free port, as the source does not tell which it got.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.Close()
+
+	lines := make(chan string, 4)
+	src, err := Open(ctx, "udp://"+pc.LocalAddr().String(), 0, func(line string) { lines <- line })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !src.Connected() {
+		t.Error("expected listening source to be connected")
+	}
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("$SDDPT,12.3,0.5*62\r\n$YXMTW,14.5,C\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"$SDDPT,12.3,0.5*62", "$YXMTW,14.5,C"} {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Errorf("got %q, expected %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	if _, err := Open(ctx, "ws://example.com", 0, nil); err == nil {
+		t.Error("expected unsupported scheme to be rejected")
+	}
+}
@@ -0,0 +1,151 @@
+// Package nmea parses NMEA 0183 sentences, and reads instrument data such
+// as depth, wind and heading from serial ports and the network.
+package nmea
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotSentence = errors.New("not an NMEA sentence")
+	ErrTooLong     = errors.New("sentence too long")
+	ErrChecksum    = errors.New("checksum mismatch")
+	ErrFields      = errors.New("missing or invalid fields")
+)
+
+// maxSentenceLength is the longest sentence allowed by NMEA 0183, from the
+// leading $ up to but excluding the line ending.
+const maxSentenceLength = 82
+
+type Sentence struct {
+	Talker string   // e.g. "GP"
+	Type   string   // e.g. "RMC"
+	Fields []string // after the address
+}
+
+// Parse parses and validates a sentence. The checksum is optional, as some
+// older instruments omit it, but must match when present. On ErrTooLong
+// and ErrChecksum the returned sentence has the address as received, for
+// accounting.
+func Parse(line string) (Sentence, error) {
+	line = strings.TrimSpace(line)
+	if len(line) < 7 || (line[0] != '$' && line[0] != '!') {
+		return Sentence{}, ErrNotSentence
+	}
+	var err error
+	if len(line) > maxSentenceLength {
+		err = ErrTooLong
+	}
+	if i := strings.IndexByte(line, '*'); i > 0 {
+		if err == nil && !validChecksum(line[1:i], line[i+1:]) {
+			err = ErrChecksum
+		}
+		line = line[:i]
+	}
+	fields := strings.Split(line[1:], ",")
+	addr := fields[0]
+	if len(addr) < 5 {
+		return Sentence{}, ErrNotSentence
+	}
+	return Sentence{
+		Talker: addr[:len(addr)-3],
+		Type:   addr[len(addr)-3:],
+		Fields: fields[1:],
+	}, err
+}
+
+// FormatSentence returns a sentence with the given address (e.g. "GPRMC")
+// and fields, including the checksum but not the line ending.
+func FormatSentence(addr string, fields ...string) string {
+	data := addr + "," + strings.Join(fields, ",")
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum ^= data[i]
+	}
+	return fmt.Sprintf("$%s*%02X", data, sum)
+}
+
+// validChecksum returns true if sum is the two hex digit XOR of the
+// characters in data.
+func validChecksum(data, sum string) bool {
+	want, err := strconv.ParseUint(sum, 16, 8)
+	if err != nil || len(sum) != 2 {
+		return false
+	}
+	var got byte
+	for i := 0; i < len(data); i++ {
+		got ^= data[i]
+	}
+	return uint64(got) == want
+}
+
+// Field returns the field, or the empty string if there is no such field.
+func (s Sentence) Field(i int) string {
+	if i >= len(s.Fields) {
+		return ""
+	}
+	return s.Fields[i]
+}
+
+func (s Sentence) Float(i int) (float64, bool) {
+	v, err := strconv.ParseFloat(s.Field(i), 64)
+	return v, err == nil
+}
+
+func (s Sentence) Int(i int) (int, bool) {
+	v, err := strconv.Atoi(s.Field(i))
+	return v, err == nil
+}
+
+// Coordinate parses a (d)ddmm.mmmm value and its hemisphere indicator into
+// signed decimal degrees.
+func (s Sentence) Coordinate(i int) (float64, bool) {
+	v, ok := s.Float(i)
+	if !ok {
+		return 0, false
+	}
+	deg := float64(int(v / 100))
+	deg += (v - deg*100) / 60
+	switch s.Field(i + 1) {
+	case "N", "E":
+		return deg, true
+	case "S", "W":
+		return -deg, true
+	default:
+		return 0, false
+	}
+}
+
+// TalkerStats are counters of sentences received from a talker. Malformed
+// sentences are dropped.
+type TalkerStats struct {
+	Sentences uint64 // valid sentences
+	Checksum  uint64 // checksum mismatch
+	Length    uint64 // longer than NMEA 0183 allows
+	Fields    uint64 // required fields missing or unparseable
+}
+
+// CountSentence records a sentence from the talker in the stats, with the
+// error from parsing or handling it, if any. Talkers are expected to be
+// upper case letters; anything else is noise on the line and counted as
+// "unknown" to keep the set of talkers bounded.
+func CountSentence(stats map[string]TalkerStats, talker string, err error) {
+	if strings.TrimFunc(talker, func(r rune) bool { return r >= 'A' && r <= 'Z' }) != "" || len(talker) > 3 {
+		talker = "unknown"
+	}
+	st := stats[talker]
+	switch err {
+	case nil:
+		st.Sentences++
+	case ErrChecksum:
+		st.Checksum++
+	case ErrTooLong:
+		st.Length++
+	case ErrFields:
+		st.Fields++
+	}
+	stats[talker] = st
+}
@@ -0,0 +1,66 @@
+package nmea
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseSentence(t *testing.T) {
+	s, err := Parse("$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Talker != "GP" || s.Type != "GGA" {
+		t.Errorf("unexpected address %q %q", s.Talker, s.Type)
+	}
+	if lat, ok := s.Coordinate(1); !ok || math.Abs(lat-48.1173) > 1e-6 {
+		t.Errorf("unexpected latitude %v", lat)
+	}
+	if lon, ok := s.Coordinate(3); !ok || math.Abs(lon-11.516666) > 1e-6 {
+		t.Errorf("unexpected longitude %v", lon)
+	}
+	if sats, ok := s.Int(6); !ok || sats != 8 {
+		t.Errorf("unexpected satellite count %v", sats)
+	}
+
+	if _, err := Parse(`{"class":"VERSION"}`); err != ErrNotSentence {
+		t.Error("expected JSON line to be rejected")
+	}
+}
+
+func TestCoordinateHemisphere(t *testing.T) {
+	s, err := Parse("$GNRMC,001225,A,3355.5000,S,15112.0000,W,5.2,270.0,010120,,*0B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lat, _ := s.Coordinate(2); math.Abs(lat+33.925) > 1e-6 {
+		t.Errorf("unexpected latitude %v", lat)
+	}
+	if lon, _ := s.Coordinate(4); math.Abs(lon+151.2) > 1e-6 {
+		t.Errorf("unexpected longitude %v", lon)
+	}
+}
+
+func TestParseSentenceValidation(t *testing.T) {
+	cases := []struct {
+		line string
+		err  error
+	}{
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47", nil},
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,", nil},
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48", ErrChecksum},
+		{"$GPGGA,123519,4807.038,N,01131.900,E,1,08,0.9,545.4,M,46.9,M,,*47", ErrChecksum},
+		{"$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*4", ErrChecksum},
+		{"$GPTXT," + strings.Repeat("x", 80), ErrTooLong},
+	}
+	for _, tc := range cases {
+		s, err := Parse(tc.line)
+		if err != tc.err {
+			t.Errorf("%q: got error %v, expected %v", tc.line, err, tc.err)
+		}
+		if s.Talker != "GP" {
+			t.Errorf("%q: unexpected talker %q", tc.line, s.Talker)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package nmea
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/serial"
+)
+
+// A Source reads lines from a serial port, a TCP server such as a WiFi
+// multiplexer, or UDP datagrams, and passes them on to a handler. The
+// source is given as a device path, tcp://HOST:PORT or udp://[HOST]:PORT,
+// the latter to listen on.
+
+const reconnectDelay = 5 * time.Second
+
+type Source struct {
+	name string
+	port *serial.Port // for serial ports; the serial package reconnects
+
+	mut        sync.Mutex
+	connected  bool
+	reconnects uint64
+}
+
+// Open starts reading from the source, calling handle with each line
+// until the context is done. The baud rate applies to serial ports only.
+// TCP connections are made again when lost.
+func Open(ctx context.Context, source string, baud int, handle func(line string)) (*Source, error) {
+	switch {
+	case strings.HasPrefix(source, "tcp://"):
+		return openTCP(ctx, strings.TrimPrefix(source, "tcp://"), handle)
+	case strings.HasPrefix(source, "udp://"):
+		return openUDP(ctx, strings.TrimPrefix(source, "udp://"), handle)
+	case strings.Contains(source, "://"):
+		return nil, fmt.Errorf("%s: unsupported source (use a device path, tcp:// or udp://)", source)
+	}
+
+	port, err := serial.Open(source, baud)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", source, err)
+	}
+	lines := port.Subscribe(ctx)
+	go func() {
+		defer port.Close()
+		for line := range lines {
+			handle(line)
+		}
+	}()
+	return &Source{name: source, port: port}, nil
+}
+
+func openTCP(ctx context.Context, addr string, handle func(line string)) (*Source, error) {
+	dial := func() (net.Conn, error) {
+		return net.DialTimeout("tcp", addr, 10*time.Second)
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, fmt.Errorf("connect %s: %w", addr, err)
+	}
+	s := &Source{name: "tcp://" + addr, connected: true}
+	go func() {
+		for {
+			// Closing the connection is the only way to interrupt a
+			// blocking read.
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-done:
+				}
+			}()
+			if err := readLines(conn, handle); err != nil && ctx.Err() == nil {
+				log.Printf("read %s: %v", s.name, err)
+			}
+			close(done)
+			conn.Close()
+			s.setConnected(false)
+
+			for {
+				select {
+				case <-time.After(reconnectDelay):
+				case <-ctx.Done():
+					return
+				}
+				conn, err = dial()
+				if err == nil {
+					s.mut.Lock()
+					s.connected = true
+					s.reconnects++
+					s.mut.Unlock()
+					break
+				}
+				log.Printf("connect %s: %v", s.name, err)
+			}
+		}
+	}()
+	return s, nil
+}
+
+func openUDP(ctx context.Context, addr string, handle func(line string)) (*Source, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", addr, err)
+	}
+	s := &Source{name: "udp://" + addr, connected: true}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		// Each datagram holds one or more whole sentences.
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("read %s: %v", s.name, err)
+				}
+				s.setConnected(false)
+				return
+			}
+			readLines(strings.NewReader(string(buf[:n])), handle)
+		}
+	}()
+	return s, nil
+}
+
+// readLines calls handle with each line from the reader, until it fails.
+func readLines(r io.Reader, handle func(line string)) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		handle(sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+func (s *Source) setConnected(connected bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.connected = connected
+}
+
+// Name returns the source as given to Open.
+func (s *Source) Name() string {
+	return s.name
+}
+
+// Connected returns whether the port is open, the connection up or the
+// socket listening.
+func (s *Source) Connected() bool {
+	if s.port != nil {
+		return s.port.Status().Connected
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.connected
+}
+
+// Reconnects returns the number of times the port or connection has been
+// opened again after being lost.
+func (s *Source) Reconnects() uint64 {
+	if s.port != nil {
+		return s.port.Status().Reconnects
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.reconnects
+}
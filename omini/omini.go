@@ -1,51 +1,109 @@
 package omini
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
 )
 
 const medianFilterSize = 51
 
 type Omini struct {
-	dev        i2c.Device
+	bus        *i2c.Bus
+	address    int
 	mut        sync.Mutex
 	a, b, c    float64
 	pa, pb, pc floatset
+	gain, offs [3]float64
+	mode       SpuriousBitMode
+	diag       bool
+	stats      Stats
+	cached     time.Time
 }
 
+// The Omini sometimes returns register values with the high bit set, which
+// never happens for a real reading. Maybe it indicates the value changed
+// while reading? The SpuriousBitMode selects how to handle it.
+type SpuriousBitMode int
+
+const (
+	RetrySpuriousBit SpuriousBitMode = iota // read again, the default
+	MaskSpuriousBit                         // clear the bit and use the value
+	KeepSpuriousBit                         // use the value as read
+)
+
+// maxSpuriousRetries bounds the number of reads in RetrySpuriousBit mode.
+const maxSpuriousRetries = 10
+
+// Stats are counters since the Omini was created, to characterize the
+// spurious bit behavior.
+type Stats struct {
+	Reads     uint64    // reads of all registers
+	HighBits  [6]uint64 // reads with the high bit set, per register
+	Discarded [3]uint64 // outliers discarded by the median filter, per channel
+}
+
+// DefaultAddress is the factory default I2C address of the Omini.
+const DefaultAddress = 0x29
+
 const (
-	ominiAddress       = 0x29
 	ominiChannelARegHi = 1
 	ominiChannelBRegHi = 3
 	ominiChannelCRegHi = 5
 )
 
-func New(dev i2c.Device) *Omini {
+func New(bus *i2c.Bus, addr int) *Omini {
 	return &Omini{
-		dev: dev,
-		pa:  make(floatset, 0, medianFilterSize),
-		pb:  make(floatset, 0, medianFilterSize),
-		pc:  make(floatset, 0, medianFilterSize),
+		bus:     bus,
+		address: addr,
+		pa:      make(floatset, 0, medianFilterSize),
+		pb:      make(floatset, 0, medianFilterSize),
+		pc:      make(floatset, 0, medianFilterSize),
+		gain:    [3]float64{1, 1, 1},
 	}
 }
 
+// SetCalibration sets the gain and offset for channels a, b and c. Raw
+// readings are multiplied by the gain and then have the offset added,
+// before filtering.
+func (s *Omini) SetCalibration(gain, offset [3]float64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.gain = gain
+	s.offs = offset
+}
+
+// SetSpuriousBitMode sets how readings with the high bit set are handled.
+// With diagnostics enabled, such readings are also logged.
+func (s *Omini) SetSpuriousBitMode(mode SpuriousBitMode, diagnostics bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.mode = mode
+	s.diag = diagnostics
+}
+
+func (s *Omini) Stats() Stats {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.stats
+}
+
 func (s *Omini) Voltages() (a, b, c float64, err error) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 
-	if err := s.dev.SetAddress(ominiAddress); err != nil {
-		return 0, 0, 0, fmt.Errorf("set device address: %w", err)
+	a, b, c, err = s.calibratedVoltages()
+	if err != nil {
+		return 0, 0, 0, err
 	}
 
-	r := i2c.NewReader(s.dev)
-
-	a, b, c = s.voltages(r)
 	s.pa = s.pa.append(a)
 	s.pb = s.pb.append(b)
 	s.pc = s.pc.append(c)
@@ -54,41 +112,123 @@ func (s *Omini) Voltages() (a, b, c float64, err error) {
 		s.a = a
 	} else {
 		log.Printf("Discarding a=%v (median %v)", a, s.pa.median())
+		s.stats.Discarded[0]++
 	}
 	if !s.pb.filled() || math.Abs(b-s.pb.median()) < 0.5 {
 		s.b = b
 	} else {
 		log.Printf("Discarding b=%v (median %v)", b, s.pb.median())
+		s.stats.Discarded[1]++
 	}
 	if !s.pc.filled() || math.Abs(c-s.pc.median()) < 0.5 {
 		s.c = c
 	} else {
 		log.Printf("Discarding c=%v (median %v)", c, s.pc.median())
+		s.stats.Discarded[2]++
 	}
 
-	return s.a, s.b, s.c, r.Error()
+	s.cached = time.Now()
+	return s.a, s.b, s.c, nil
 }
 
-func (s *Omini) voltages(r *i2c.Reader) (a, b, c float64) {
-	for {
+// Refresh reads new voltages through the median filter, like Voltages,
+// unless they were read within the given age.
+func (s *Omini) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	cached := s.cached
+	s.mut.Unlock()
+	if time.Since(cached) < age {
+		return nil
+	}
+	_, _, _, err := s.Voltages()
+	return err
+}
+
+func (s *Omini) Info() sensor.Info {
+	return sensor.Info{Chip: "Omini", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+func (s *Omini) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "voltage_a", Unit: "volts", Quantity: sensor.Voltage, Precision: 2, Value: s.a},
+		{Name: "voltage_b", Unit: "volts", Quantity: sensor.Voltage, Precision: 2, Value: s.b},
+		{Name: "voltage_c", Unit: "volts", Quantity: sensor.Voltage, Precision: 2, Value: s.c},
+	}
+}
+
+// RawVoltages returns calibrated voltages without the median filter, for
+// following fast changes. It does not affect the filtered values.
+func (s *Omini) RawVoltages() (a, b, c float64, err error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.calibratedVoltages()
+}
+
+func (s *Omini) calibratedVoltages() (a, b, c float64, err error) {
+	err = s.bus.Do(s.address, func(dev i2c.Device) error {
+		var err error
+		a, b, c, err = s.voltages(i2c.NewReader(dev))
+		return err
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	a = a*s.gain[0] + s.offs[0]
+	b = b*s.gain[1] + s.offs[1]
+	c = c*s.gain[2] + s.offs[2]
+	return a, b, c, nil
+}
+
+func (s *Omini) voltages(r *i2c.Reader) (a, b, c float64, err error) {
+	for i := 1; ; i++ {
 		bs, err := r.Read(
 			ominiChannelARegHi, ominiChannelARegHi+1,
 			ominiChannelBRegHi, ominiChannelBRegHi+1,
 			ominiChannelCRegHi, ominiChannelCRegHi+1,
 		)
-		if err == nil {
-			// We sometimes seem to get the high bit set spuriously. Maybe
-			// it indicates the value changed while reading?
-			if (bs[0]&128 | bs[1]&128 | bs[2]&128 | bs[3]&128 | bs[4]&128 | bs[5]&128) != 0 {
-				continue
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		s.stats.Reads++
+
+		if s.spuriousBits(bs) {
+			if s.diag {
+				log.Printf("Omini: high bit set in registers % x", bs)
 			}
+			switch s.mode {
+			case RetrySpuriousBit:
+				if i < maxSpuriousRetries {
+					continue
+				}
+				return 0, 0, 0, errors.New("high bit set in too many consecutive reads")
+			case MaskSpuriousBit:
+				for j := range bs {
+					bs[j] &^= 128
+				}
+			}
+		}
+
+		a = float64(bs[0]) + float64(bs[1])/100
+		b = float64(bs[2]) + float64(bs[3])/100
+		c = float64(bs[4]) + float64(bs[5])/100
+		return a, b, c, nil
+	}
+}
 
-			a = float64(bs[0]) + float64(bs[1])/100
-			b = float64(bs[2]) + float64(bs[3])/100
-			c = float64(bs[4]) + float64(bs[5])/100
+// spuriousBits counts and returns whether any of the values have the high
+// bit set.
+func (s *Omini) spuriousBits(bs []byte) bool {
+	found := false
+	for i, v := range bs {
+		if v&128 != 0 {
+			s.stats.HighBits[i]++
+			found = true
 		}
-		return
 	}
+	return found
 }
 
 type floatset []float64
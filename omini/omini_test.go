@@ -0,0 +1,57 @@
+package omini
+
+import (
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+// spuriousDevice sets the high bit on register 2 for the first n reads of
+// it.
+type spuriousDevice struct {
+	i2ctest.Registers
+	spurious int
+}
+
+func (d *spuriousDevice) ReadByteData(reg uint8) (uint8, error) {
+	if reg == 2 && d.spurious > 0 {
+		d.spurious--
+		return d.Registers[reg] | 128, nil
+	}
+	return d.Registers[reg], nil
+}
+
+func TestSpuriousBitModes(t *testing.T) {
+	dev := &spuriousDevice{Registers: i2ctest.Registers{1: 12, 2: 34, 3: 13, 4: 5}}
+
+	s := New(nil, DefaultAddress)
+	dev.spurious = 2
+	a, b, _, err := s.voltages(i2c.NewReader(dev))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != 12.34 || b != 13.05 {
+		t.Errorf("unexpected voltages %v %v", a, b)
+	}
+	if s.stats.Reads != 3 || s.stats.HighBits[1] != 2 {
+		t.Errorf("unexpected stats %+v", s.stats)
+	}
+
+	dev.spurious = maxSpuriousRetries
+	if _, _, _, err := s.voltages(i2c.NewReader(dev)); err == nil {
+		t.Error("expected error after too many retries")
+	}
+
+	s.SetSpuriousBitMode(MaskSpuriousBit, false)
+	dev.spurious = 1
+	if a, _, _, _ := s.voltages(i2c.NewReader(dev)); a != 12.34 {
+		t.Errorf("unexpected masked voltage %v", a)
+	}
+
+	s.SetSpuriousBitMode(KeepSpuriousBit, false)
+	dev.spurious = 1
+	if a, _, _, _ := s.voltages(i2c.NewReader(dev)); a < 13.6 || a > 13.63 {
+		t.Errorf("unexpected kept voltage %v", a)
+	}
+}
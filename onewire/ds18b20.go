@@ -0,0 +1,119 @@
+package onewire
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Maxim DS18B20 1-Wire digital thermometer, as exposed by the w1_therm
+// kernel driver.
+
+var DevicesDir = "/sys/bus/w1/devices"
+
+const ds18b20Family = "28"
+
+// ds18b20PowerOn is the power-on reset value of the temperature register,
+// indicating that no conversion has taken place.
+const ds18b20PowerOn = 85000
+
+var errCRC = errors.New("CRC check failed")
+
+type DS18B20 struct {
+	id          string
+	mut         sync.Mutex
+	cached      time.Time
+	temperature float64
+}
+
+// DS18B20s returns the IDs of the connected DS18B20 probes.
+func DS18B20s() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(DevicesDir, ds18b20Family+"-*"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(paths))
+	for i, path := range paths {
+		ids[i] = filepath.Base(path)
+	}
+	return ids, nil
+}
+
+func NewDS18B20(id string) *DS18B20 {
+	return &DS18B20{id: id}
+}
+
+func (s *DS18B20) ID() string {
+	return s.id
+}
+
+func (s *DS18B20) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	bs, err := ioutil.ReadFile(filepath.Join(DevicesDir, s.id, "w1_slave"))
+	if err != nil {
+		return fmt.Errorf("read data: %w", err)
+	}
+	temp, err := parseW1Slave(string(bs))
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.id, err)
+	}
+
+	s.temperature = temp
+	s.cached = time.Now()
+	return nil
+}
+
+func (s *DS18B20) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature
+}
+
+func (s *DS18B20) Info() sensor.Info {
+	return sensor.Info{Chip: "DS18B20", Bus: "1-wire", ID: s.id}
+}
+
+func (s *DS18B20) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: s.temperature}}
+}
+
+// parseW1Slave parses the two line w1_slave output:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+func parseW1Slave(data string) (float64, error) {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("unexpected data %q", data)
+	}
+	if !strings.HasSuffix(lines[0], "YES") {
+		return 0, errCRC
+	}
+	i := strings.Index(lines[1], "t=")
+	if i < 0 {
+		return 0, fmt.Errorf("unexpected data %q", data)
+	}
+	milli, err := strconv.Atoi(lines[1][i+2:])
+	if err != nil {
+		return 0, fmt.Errorf("parse temperature: %w", err)
+	}
+	if milli == ds18b20PowerOn {
+		return 0, errors.New("no conversion (power-on value)")
+	}
+	return float64(milli) / 1000, nil
+}
@@ -0,0 +1,32 @@
+package onewire
+
+import "testing"
+
+func TestParseW1Slave(t *testing.T) {
+	cases := []struct {
+		in   string
+		out  float64
+		fail bool
+	}{
+		{"72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n", 23.125, false},
+		{"f6 ff 4b 46 7f ff 0a 10 d9 : crc=d9 YES\nf6 ff 4b 46 7f ff 0a 10 d9 t=-625\n", -0.625, false},
+		{"72 01 4b 46 7f ff 0e 10 57 : crc=00 NO\n72 01 4b 46 7f ff 0e 10 57 t=23125\n", 0, true},
+		{"50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tc := range cases {
+		res, err := parseW1Slave(tc.in)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("expected error for %q", tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error %v for %q", err, tc.in)
+		} else if res != tc.out {
+			t.Errorf("%v != expected %v for %q", res, tc.out, tc.in)
+		}
+	}
+}
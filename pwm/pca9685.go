@@ -0,0 +1,169 @@
+// Package pwm drives PWM controllers, for dimming lights and driving
+// analog gauges.
+package pwm
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// NXP PCA9685 16 channel, 12 bit PWM controller, on its internal 25 MHz
+// oscillator. All channels share the one frequency, from 24 to 1526 Hz;
+// a few hundred Hz suits LED dimming through a MOSFET, and moving coil
+// gauges average anything above that. The outputs are totem pole, the
+// power on default.
+
+type PCA9685 struct {
+	bus     *i2c.Bus
+	address int
+	freq    float64 // Hz, actual
+
+	mut   sync.Mutex
+	duty  [pca9685Channels]float64
+	known [pca9685Channels]bool
+}
+
+// PCA9685DefaultAddress is the address with the address pins to ground.
+// They select addresses 0x40 to 0x7f, less the all call and software reset
+// addresses.
+const PCA9685DefaultAddress = 0x40
+
+const (
+	pca9685Mode1    = 0x00
+	pca9685LED0     = 0x06 // ON_L, ON_H, OFF_L, OFF_H per channel
+	pca9685PreScale = 0xfe
+
+	pca9685Restart = 1 << 7 // MODE1
+	pca9685AI      = 1 << 5 // MODE1 register auto increment
+	pca9685Sleep   = 1 << 4 // MODE1
+
+	pca9685Full = 1 << 4 // ON_H or OFF_H: fully on or off
+
+	pca9685Oscillator = 25e6 // Hz
+	pca9685Steps      = 4096
+	pca9685Channels   = 16
+)
+
+// PCA9685Frequencies are the lowest and highest supported frequencies.
+var PCA9685Frequencies = [2]float64{
+	pca9685Oscillator / pca9685Steps / 256,
+	pca9685Oscillator / pca9685Steps / 4,
+}
+
+// NewPCA9685 returns the PCA9685 at the address, set to the frequency in
+// Hz. The outputs keep their state, so that lights stay as they were when
+// the program restarts; the duty cycles are unknown until set.
+func NewPCA9685(bus *i2c.Bus, addr int, freq float64) (*PCA9685, error) {
+	if freq < PCA9685Frequencies[0] || freq > PCA9685Frequencies[1] {
+		return nil, fmt.Errorf("frequency %v Hz out of range (%.0f to %.0f Hz)", freq, PCA9685Frequencies[0], PCA9685Frequencies[1])
+	}
+	prescale := math.Round(pca9685Oscillator/(pca9685Steps*freq)) - 1
+	s := &PCA9685{bus: bus, address: addr, freq: pca9685Oscillator / pca9685Steps / (prescale + 1)}
+
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		mode := r.Byte(pca9685Mode1)
+		if err := r.Error(); err != nil {
+			return err
+		}
+		if r.Byte(pca9685PreScale) == int(prescale) && mode&pca9685Sleep == 0 {
+			return r.WriteBlock(pca9685Mode1, []byte{byte(mode&^pca9685Restart | pca9685AI)})
+		}
+		// The prescaler can only be set while asleep; the oscillator
+		// then needs 500 µs to start again.
+		if err := r.WriteBlock(pca9685Mode1, []byte{byte(mode&^pca9685Restart | pca9685Sleep)}); err != nil {
+			return err
+		}
+		if err := r.WriteBlock(pca9685PreScale, []byte{byte(prescale)}); err != nil {
+			return err
+		}
+		if err := r.WriteBlock(pca9685Mode1, []byte{byte(mode&^(pca9685Restart|pca9685Sleep) | pca9685AI)}); err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+		mode = r.Byte(pca9685Mode1)
+		if err := r.Error(); err != nil {
+			return err
+		}
+		if mode&pca9685Restart != 0 {
+			// Resume the channels as they were before sleeping.
+			return r.WriteBlock(pca9685Mode1, []byte{byte(mode | pca9685Restart)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Frequency returns the PWM frequency, as near the one asked for as the
+// prescaler allows.
+func (s *PCA9685) Frequency() float64 {
+	return s.freq
+}
+
+// SetDuty sets the duty cycle of the channel, from 0 (off) to 1 (fully
+// on).
+func (s *PCA9685) SetDuty(ch int, duty float64) error {
+	if ch < 0 || ch >= pca9685Channels {
+		return fmt.Errorf("no channel %d (valid: 0 to %d)", ch, pca9685Channels-1)
+	}
+	duty = math.Max(0, math.Min(1, duty))
+	var on, off uint16
+	switch steps := math.Round(duty * pca9685Steps); {
+	case steps == 0:
+		off = pca9685Full << 8
+	case steps == pca9685Steps:
+		on = pca9685Full << 8
+	default:
+		off = uint16(steps)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		return i2c.NewReader(dev).WriteBlock(pca9685LED0+4*uint8(ch), []byte{byte(on), byte(on >> 8), byte(off), byte(off >> 8)})
+	})
+	if err != nil {
+		return fmt.Errorf("channel %d: %w", ch, err)
+	}
+	s.duty[ch], s.known[ch] = duty, true
+	return nil
+}
+
+// Duty returns the duty cycle last set on the channel, and whether one
+// has been set.
+func (s *PCA9685) Duty(ch int) (float64, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.duty[ch], s.known[ch]
+}
+
+// Refresh does nothing, as there is nothing to read; it makes the
+// PCA9685 a sensor.Sensor, to be described with the others.
+func (s *PCA9685) Refresh(age time.Duration) error {
+	return nil
+}
+
+func (s *PCA9685) Info() sensor.Info {
+	return sensor.Info{Chip: "PCA9685", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address)}
+}
+
+// Readings returns the duty cycles set, in percent.
+func (s *PCA9685) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	var res []sensor.Reading
+	for ch, known := range s.known {
+		if known {
+			res = append(res, sensor.Reading{Name: fmt.Sprintf("duty_%d", ch), Unit: "percent", Quantity: sensor.Raw, Precision: 1, Value: s.duty[ch] * 100})
+		}
+	}
+	return res
+}
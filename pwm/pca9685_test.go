@@ -0,0 +1,103 @@
+package pwm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+// pcaDevice is a fake PCA9685 that sets RESTART when put to sleep with
+// channels running, as the chip does.
+type pcaDevice struct {
+	i2ctest.Registers
+	sleeps int
+}
+
+func (d *pcaDevice) WriteBlockData(reg uint8, data []byte) error {
+	if reg == pca9685Mode1 {
+		v := data[0]
+		if v&pca9685Sleep != 0 && d.Registers[reg]&pca9685Sleep == 0 {
+			d.sleeps++
+			v |= pca9685Restart
+		} else if v&pca9685Restart != 0 {
+			v &^= pca9685Restart // writing one clears it
+		} else {
+			v |= d.Registers[reg] & pca9685Restart
+		}
+		d.Registers[reg] = v
+		return nil
+	}
+	return d.Registers.WriteBlockData(reg, data)
+}
+
+func TestPCA9685Frequency(t *testing.T) {
+	dev := &pcaDevice{Registers: i2ctest.Registers{}}
+	dev.Registers[pca9685Mode1] = pca9685Sleep | pca9685AI // power on
+	dev.Registers[pca9685PreScale] = 0x1e
+	s, err := NewPCA9685(i2c.NewBus(dev), PCA9685DefaultAddress, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Registers[pca9685PreScale] != 30 {
+		t.Errorf("prescale %d, expected 30", dev.Registers[pca9685PreScale])
+	}
+	if f := s.Frequency(); math.Abs(f-196.9) > 0.1 {
+		t.Errorf("frequency %v", f)
+	}
+	if mode := dev.Registers[pca9685Mode1]; mode&pca9685Sleep != 0 || mode&pca9685AI == 0 {
+		t.Errorf("mode %08b, expected awake with auto increment", mode)
+	}
+
+	// Same frequency again, as on a restart, does not sleep.
+	sleeps := dev.sleeps
+	if _, err := NewPCA9685(i2c.NewBus(dev), PCA9685DefaultAddress, 200); err != nil {
+		t.Fatal(err)
+	}
+	if dev.sleeps != sleeps {
+		t.Error("slept to set the same frequency")
+	}
+
+	if _, err := NewPCA9685(i2c.NewBus(dev), PCA9685DefaultAddress, 2000); err == nil {
+		t.Error("expected error for 2 kHz")
+	}
+}
+
+func TestPCA9685Duty(t *testing.T) {
+	dev := &pcaDevice{Registers: i2ctest.Registers{}}
+	s, err := NewPCA9685(i2c.NewBus(dev), PCA9685DefaultAddress, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		ch   int
+		duty float64
+		regs [4]byte
+	}{
+		{0, 0, [4]byte{0, 0, 0, 0x10}},
+		{1, 1, [4]byte{0, 0x10, 0, 0}},
+		{15, 0.25, [4]byte{0, 0, 0, 0x04}},
+		{2, 1.5, [4]byte{0, 0x10, 0, 0}},
+	}
+	for _, tc := range cases {
+		if err := s.SetDuty(tc.ch, tc.duty); err != nil {
+			t.Fatal(err)
+		}
+		reg := pca9685LED0 + 4*tc.ch
+		var regs [4]byte
+		dev.ReadBlockData(uint8(reg), regs[:])
+		if regs != tc.regs {
+			t.Errorf("channel %d at %v: registers % x, expected % x", tc.ch, tc.duty, regs, tc.regs)
+		}
+	}
+	if d, ok := s.Duty(15); !ok || d != 0.25 {
+		t.Errorf("duty %v, %v", d, ok)
+	}
+	if _, ok := s.Duty(3); ok {
+		t.Error("expected unknown duty for a channel never set")
+	}
+	if err := s.SetDuty(16, 0.5); err == nil {
+		t.Error("expected error for channel 16")
+	}
+}
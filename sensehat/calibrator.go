@@ -0,0 +1,104 @@
+package sensehat
+
+import (
+	"time"
+)
+
+// A Calibrator keeps the accelerometer and magnetometer calibration of an
+// IMU up to date as the readings come in: the six position accelerometer
+// calibration, the extremes of the magnetic field, the resets of obviously
+// bad calibrations and the calibration quality. It is what the LSM9DS1
+// uses, and other IMUs with a magnetometer use it to calibrate the same
+// way. The magnetometer axes must be those of the accelerometer.
+//
+// A Calibrator is not safe for concurrent use; the driver guards it with
+// its own lock.
+type Calibrator struct {
+	cal      Calibration
+	accelLSB float64 // accelerometer counts per g
+	magnLSB  float64 // magnetometer counts per gauss
+	prev     Point   // last raw accelerometer sample
+	accel    Point   // last calibrated accelerometer sample
+	quality  calibrationTracker
+	reads    int // magnetometer readings since the calibration was reset
+	resets   []CalibrationReset
+}
+
+// NewCalibrator returns a Calibrator starting from the calibration, for an
+// accelerometer and magnetometer with the given sensitivities in counts
+// per g and counts per gauss.
+func NewCalibrator(cal Calibration, accelLSB, magnLSB float64) *Calibrator {
+	return &Calibrator{cal: cal, accelLSB: accelLSB, magnLSB: magnLSB}
+}
+
+// Acceleration observes the raw accelerometer sample and returns it
+// calibrated, in counts at the nominal sensitivity.
+func (c *Calibrator) Acceleration(p Point) Point {
+	c.cal.Accel.observe(c.prev, p, c.accelLSB)
+	c.prev = p
+	c.accel = c.cal.Accel.apply(p, c.accelLSB)
+	return c.accel
+}
+
+// MagneticField observes the raw magnetometer reading, with the last
+// accelerometer sample telling which plane is horizontal.
+func (c *Calibrator) MagneticField(m Point) {
+	c.cal.observeField(m)
+	c.reads++
+	if reason := checkCalibration(c.cal, c.reads, c.magnLSB); reason != "" {
+		c.resets = append(c.resets, CalibrationReset{Time: time.Now(), Reason: reason, Calibration: c.cal})
+		c.cal.Min, c.cal.Max = Point{}, Point{}
+		c.quality = calibrationTracker{}
+		c.reads = 0
+		c.cal.observeField(m)
+	}
+	c.quality.observe(c.cal, m, c.accel)
+}
+
+func (c *Calibrator) Calibration() Calibration {
+	return c.cal
+}
+
+// Resets returns the magnetometer calibration resets since the last call.
+func (c *Calibrator) Resets() []CalibrationReset {
+	res := c.resets
+	c.resets = nil
+	return res
+}
+
+// Quality returns how far the magnetometer calibration can be trusted.
+func (c *Calibrator) Quality() CalibrationQuality {
+	return c.quality.quality()
+}
+
+// Compass returns the angles of the magnetometer reading, corrected for
+// the calibration offsets, in the three planes, plus the offset in degrees.
+func (c *Calibrator) Compass(m Point, offset float64) (xy, xz, yz float64) {
+	x := float64(m.X - (c.cal.Max.X+c.cal.Min.X)/2)
+	y := float64(m.Y - (c.cal.Max.Y+c.cal.Min.Y)/2)
+	z := float64(m.Z - (c.cal.Max.Z+c.cal.Min.Z)/2)
+	return compass(y, x, offset), compass(z, x, offset), compass(z, y, offset)
+}
+
+// observeField widens the extremes of the magnetic field to include the
+// reading.
+func (cal *Calibration) observeField(m Point) {
+	if cal.Max.X == 0 || m.X > cal.Max.X {
+		cal.Max.X = m.X
+	}
+	if cal.Min.X == 0 || m.X < cal.Min.X {
+		cal.Min.X = m.X
+	}
+	if cal.Max.Y == 0 || m.Y > cal.Max.Y {
+		cal.Max.Y = m.Y
+	}
+	if cal.Min.Y == 0 || m.Y < cal.Min.Y {
+		cal.Min.Y = m.Y
+	}
+	if cal.Max.Z == 0 || m.Z > cal.Max.Z {
+		cal.Max.Z = m.Z
+	}
+	if cal.Min.Z == 0 || m.Z < cal.Min.Z {
+		cal.Min.Z = m.Z
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
 )
 
 // ST HTS221 Humidity & Temperature Sensor
@@ -21,7 +22,8 @@ type HTS221 struct {
 	t1Out   float64
 	tSlope  float64
 	hSlope  float64
-	device  i2c.Device
+	bus     *i2c.Bus
+	whoAmI  string
 
 	mut         sync.Mutex
 	cached      time.Time
@@ -52,45 +54,52 @@ const (
 	t1OutRegH         = 0x3f
 )
 
-func NewHTS221(dev i2c.Device) (*HTS221, error) {
-	// Initialize sensor
-
-	if err := dev.SetAddress(hts221Address); err != nil {
+func NewHTS221(bus *i2c.Bus) (*HTS221, error) {
+	s := &HTS221{bus: bus}
+	if err := bus.Do(hts221Address, s.init); err != nil {
 		return nil, err
 	}
+	return s, nil
+}
+
+func (s *HTS221) init(dev i2c.Device) error {
+	// Initialize sensor
+
+	s.whoAmI = whoAmI(dev)
 	if err := dev.WriteByteData(hts221CtrlReg1, hts221InitData); err != nil {
-		return nil, err
+		return err
 	}
 
-	s := &HTS221{device: dev}
-
 	// Read calibration data
 
 	r := i2c.NewReader(dev)
+	cal := r.Block(h0rHx2Reg|autoIncrement, t1OutRegH-h0rHx2Reg+1)
+	if err := r.Error(); err != nil {
+		return fmt.Errorf("read calibration data: %w", err)
+	}
+	reg := func(reg uint8) []byte {
+		return cal[reg-h0rHx2Reg:]
+	}
 
-	s.h0rH = float64(r.Byte(h0rHx2Reg)) / 2
-	s.h1rH = float64(r.Byte(h1rHx2Reg)) / 2
-	s.t0degC = float64(r.Byte(t0degCx8Reg))
-	s.t1degC = float64(r.Byte(t1degCx8Reg))
-	t1t0msb := r.Byte(t1t0msbReg)
+	s.h0rH = float64(reg(h0rHx2Reg)[0]) / 2
+	s.h1rH = float64(reg(h1rHx2Reg)[0]) / 2
+	s.t0degC = float64(reg(t0degCx8Reg)[0])
+	s.t1degC = float64(reg(t1degCx8Reg)[0])
+	t1t0msb := int(reg(t1t0msbReg)[0])
 	s.t0degC += float64((t1t0msb & 0x3) << 8)
 	s.t1degC += float64((t1t0msb & 0xc) << 6)
 	s.t0degC /= 8
 	s.t1degC /= 8
 
-	s.h0t0Out = float64(r.Signed(h0t0OutRegH, h0t0OutRegL))
-	s.h1t0Out = float64(r.Signed(h1t0OutRegH, h1t0OutRegL))
-	s.t0Out = float64(r.Signed(t0OutRegH, t0OutRegL))
-	s.t1Out = float64(r.Signed(t1OutRegH, t1OutRegL))
-
-	if err := r.Error(); err != nil {
-		return nil, fmt.Errorf("read calibration data: %w", err)
-	}
+	s.h0t0Out = float64(i2c.SignedLE(reg(h0t0OutRegL)[:2]))
+	s.h1t0Out = float64(i2c.SignedLE(reg(h1t0OutRegL)[:2]))
+	s.t0Out = float64(i2c.SignedLE(reg(t0OutRegL)[:2]))
+	s.t1Out = float64(i2c.SignedLE(reg(t1OutRegL)[:2]))
 
 	s.tSlope = (s.t1degC - s.t0degC) / (s.t1Out - s.t0Out)
 	s.hSlope = (s.h1rH - s.h0rH) / (s.h1t0Out - s.h0t0Out)
 
-	return s, nil
+	return nil
 }
 
 func (s *HTS221) Refresh(age time.Duration) error {
@@ -101,17 +110,19 @@ func (s *HTS221) Refresh(age time.Duration) error {
 		return nil
 	}
 
-	if err := s.device.SetAddress(hts221Address); err != nil {
-		return fmt.Errorf("set device address: %w", err)
-	}
-
-	r := i2c.NewReader(s.device)
+	err := s.bus.Do(hts221Address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(hts221HumOutLReg|autoIncrement, 4)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
 
-	s.humidity = (float64(r.Signed(hts221HumOutHReg, hts221HumOutLReg))-s.h0t0Out)*s.hSlope + s.h0rH
-	s.temperature = (float64(r.Signed(hts221TempOutHReg, hts221TempOutLReg))-s.t0Out)*s.tSlope + s.t0degC
-
-	if err := r.Error(); err != nil {
-		return fmt.Errorf("read data: %w", err)
+		s.humidity = (float64(i2c.SignedLE(data[0:2]))-s.h0t0Out)*s.hSlope + s.h0rH
+		s.temperature = (float64(i2c.SignedLE(data[2:4]))-s.t0Out)*s.tSlope + s.t0degC
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	s.cached = time.Now()
@@ -129,3 +140,16 @@ func (s *HTS221) Humidity() float64 {
 	defer s.mut.Unlock()
 	return s.humidity
 }
+
+func (s *HTS221) Info() sensor.Info {
+	return sensor.Info{Chip: "HTS221", Bus: "i2c", Address: fmt.Sprintf("0x%02x", hts221Address), ID: s.whoAmI}
+}
+
+func (s *HTS221) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "humidity", Unit: "percent", Quantity: sensor.Humidity, Precision: 0, Value: s.humidity},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: s.temperature},
+	}
+}
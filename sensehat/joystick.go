@@ -0,0 +1,68 @@
+package sensehat
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The five way joystick, an input event device.
+
+type Key int
+
+const (
+	KeyUp    Key = 103
+	KeyDown  Key = 108
+	KeyLeft  Key = 105
+	KeyRight Key = 106
+	KeyEnter Key = 28
+)
+
+const (
+	evKey      = 1
+	keyPressed = 1
+)
+
+// struct input_event starts with a struct timeval, whose size depends on
+// the platform.
+var inputEventSize = int(unsafe.Sizeof(syscall.Timeval{})) + 8
+
+type Joystick struct {
+	fd *os.File
+}
+
+func OpenJoystick() (*Joystick, error) {
+	path, err := findDevice("/sys/class/input/event*", "device/name", "Raspberry Pi Sense HAT Joystick", "/dev/input")
+	if err != nil {
+		return nil, err
+	}
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Joystick{fd: fd}, nil
+}
+
+// Read blocks until a key is pressed and returns it. It returns an error
+// when the joystick is closed.
+func (j *Joystick) Read() (Key, error) {
+	buf := make([]byte, inputEventSize)
+	for {
+		if _, err := io.ReadFull(j.fd, buf); err != nil {
+			return 0, err
+		}
+		ev := buf[inputEventSize-8:]
+		typ := binary.LittleEndian.Uint16(ev)
+		code := binary.LittleEndian.Uint16(ev[2:])
+		val := int32(binary.LittleEndian.Uint32(ev[4:]))
+		if typ == evKey && val == keyPressed {
+			return Key(code), nil
+		}
+	}
+}
+
+func (j *Joystick) Close() error {
+	return j.fd.Close()
+}
@@ -0,0 +1,48 @@
+package sensehat
+
+import (
+	"encoding/binary"
+	"os"
+)
+
+// The 8x8 RGB LED matrix, driven through the framebuffer device provided
+// by the rpisense-fb kernel driver. Pixels are 16 bit RGB565.
+
+type Color struct {
+	R, G, B uint8
+}
+
+type LEDMatrix struct {
+	fd *os.File
+}
+
+func OpenLEDMatrix() (*LEDMatrix, error) {
+	path, err := findDevice("/sys/class/graphics/fb*", "name", "RPi-Sense FB", "/dev")
+	if err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &LEDMatrix{fd: fd}, nil
+}
+
+// Set sets all pixels, row by row from the top left.
+func (m *LEDMatrix) Set(pixels [64]Color) error {
+	_, err := m.fd.WriteAt(rgb565(pixels), 0)
+	return err
+}
+
+func (m *LEDMatrix) Close() error {
+	return m.fd.Close()
+}
+
+func rgb565(pixels [64]Color) []byte {
+	buf := make([]byte, 2*len(pixels))
+	for i, c := range pixels {
+		v := uint16(c.R>>3)<<11 | uint16(c.G>>2)<<5 | uint16(c.B>>3)
+		binary.LittleEndian.PutUint16(buf[2*i:], v)
+	}
+	return buf
+}
@@ -6,22 +6,28 @@ import (
 	"time"
 
 	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
 )
 
 // ST LPS25H Pressure & Temperature Sensor
 
 type LPS25H struct {
-	device      i2c.Device
+	bus         *i2c.Bus
+	address     int
+	whoAmI      string
 	mut         sync.Mutex
 	cached      time.Time
 	temperature float64
 	pressure    float64
 }
 
+// LPS25HAddress is the address of the LPS25H on the Sense HAT. Breakout
+// boards may use the alternate address 0x5d.
+const LPS25HAddress = 0x5c
+
 const (
-	lps25hAddress      = 0x5c
 	lps25hCtrlReg1     = 0x20
-	lps25hInitData     = 0x94 // PD=1, ODR0=1, BDU=1
+	lps25hInitData     = 0xb4 // PD=1, ODR=12.5 Hz, BDU=1
 	lps25HressOutXLReg = 0x28
 	lps25hPressOutLReg = 0x29
 	lps25hPressOutHReg = 0x2a
@@ -29,17 +35,22 @@ const (
 	lps25hTempOutHReg  = 0x2c
 )
 
-func NewLPS25H(dev i2c.Device) (*LPS25H, error) {
+func NewLPS25H(bus *i2c.Bus, addr int) (*LPS25H, error) {
 	// Initialize sensor
 
-	if err := dev.SetAddress(lps25hAddress); err != nil {
-		return nil, fmt.Errorf("set device address: %w", err)
-	}
-	if err := dev.WriteByteData(lps25hCtrlReg1, lps25hInitData); err != nil {
-		return nil, fmt.Errorf("write control register: %w", err)
+	s := &LPS25H{bus: bus, address: addr}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		s.whoAmI = whoAmI(dev)
+		if err := dev.WriteByteData(lps25hCtrlReg1, lps25hInitData); err != nil {
+			return fmt.Errorf("write control register: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &LPS25H{device: dev}, nil
+	return s, nil
 }
 
 func (s *LPS25H) Refresh(age time.Duration) error {
@@ -50,18 +61,20 @@ func (s *LPS25H) Refresh(age time.Duration) error {
 		return nil
 	}
 
-	if err := s.device.SetAddress(lps25hAddress); err != nil {
-		return fmt.Errorf("set device address: %w", err)
-	}
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(lps25HressOutXLReg|autoIncrement, 5)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
 
-	r := i2c.NewReader(s.device)
-
-	// Numeric constants from data sheet
-	s.pressure = float64(r.Signed(lps25hPressOutHReg, lps25hPressOutLReg, lps25HressOutXLReg)) / 4096
-	s.temperature = float64(r.Signed(lps25hTempOutHReg, lps25hTempOutLReg))/480 + 42.5
-
-	if err := r.Error(); err != nil {
-		return fmt.Errorf("read data: %w", err)
+		// Numeric constants from data sheet
+		s.pressure = float64(i2c.SignedLE(data[0:3])) / 4096
+		s.temperature = float64(i2c.SignedLE(data[3:5]))/480 + 42.5
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	s.cached = time.Now()
 	return nil
@@ -78,3 +91,16 @@ func (s *LPS25H) Pressure() float64 {
 	defer s.mut.Unlock()
 	return s.pressure
 }
+
+func (s *LPS25H) Info() sensor.Info {
+	return sensor.Info{Chip: "LPS25H", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address), ID: s.whoAmI}
+}
+
+func (s *LPS25H) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "pressure", Unit: "mb", Quantity: sensor.Pressure, Precision: 1, Value: s.pressure},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: s.temperature},
+	}
+}
@@ -8,69 +8,232 @@ import (
 	"time"
 
 	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
 )
 
 // ST LSM9DS1 iNEMO inertial module, 3D magnetometer, 3D accelerometer, 3D
 // gyroscope
 
 type LSM9DS1 struct {
-	device     i2c.Device
+	bus        *i2c.Bus
+	accelAddr  int
+	magnAddr   int
+	whoAmI     string
 	mut        sync.Mutex
-	cal        Calibration
+	cal        *Calibrator
 	mo         float64
 	cached     time.Time
+	fifo       bool
+	rate       float64
 	ax, ay, az int16
+	temp       int16
+	samples    []Point
 	mx, my, mz int16
 }
 
-type Point struct {
-	X, Y, Z int16
-}
+// Point is the raw reading of the three axes; see sensor.Point.
+type Point = sensor.Point
 
+// Calibration holds the extremes of the magnetic field seen so far and the
+// accelerometer calibration.
 type Calibration struct {
+	Min   Point
+	Max   Point
+	Accel AccelCalibration
+}
+
+// AccelCalibration holds the averaged accelerometer readings with each axis
+// pointing straight down (Min) and straight up (Max), found while the
+// sensor was still. The midpoint between them is the offset of the axis and
+// the distance between them is two g, giving the scale. This is the usual
+// six position calibration, done as the positions happen to come by; until
+// both positions of an axis have been seen its raw counts are used as they
+// are.
+type AccelCalibration struct {
 	Min Point
 	Max Point
 }
 
+// Addresses of the LSM9DS1 accelerometer/gyroscope and magnetometer on the
+// Sense HAT. Breakout boards commonly use 0x6b and 0x1e instead.
+const (
+	LSM9DS1AccelAddress = 0x6a
+	LSM9DS1MagnAddress  = 0x1c
+)
+
 const (
-	lsm9ds1AccelAddress    = 0x6a
+	lsm9ds1AccelOutTempL   = 0x15
 	lsm9ds1AccelCtrlReg6XL = 0x20
-	lsm9ds1AccelInitData   = 0b_001_00_000
+	lsm9ds1AccelCtrlReg9   = 0x23
 	lsm9ds1AccelXOutXLReg  = 0x28
 	lsm9ds1AccelYOutXLReg  = 0x2a
 	lsm9ds1AccelZOutXLReg  = 0x2c
+	lsm9ds1FIFOCtrlReg     = 0x2e
+	lsm9ds1FIFOSrcReg      = 0x2f
+
+	lsm9ds1FIFOEnable     = 0b_0000_0010 // CTRL_REG9 FIFO_EN
+	lsm9ds1FIFOContinuous = 0b_110_00000 // FIFO_CTRL FMODE, newest samples kept
+	lsm9ds1FIFOSamples    = 0b_0011_1111 // FIFO_SRC FSS
+
+	lsm9ds1MagnCtrlReg1M = 0x20
+	lsm9ds1MagnCtrlReg2M = 0x21
+	lsm9ds1MagnCtrlReg3M = 0x22
+	lsm9ds1MagnXOutLReg  = 0x28
+	lsm9ds1MagnYOutLReg  = 0x2a
+	lsm9ds1MagnZOutLReg  = 0x2c
 
-	lsm9ds1MagnAddress  = 0x1c
-	lsm9ds1MagnXOutLReg = 0x28
-	lsm9ds1MagnYOutLReg = 0x2a
-	lsm9ds1MagnZOutLReg = 0x2c
+	lsm9ds1MagnReset     = 0b_0000_1100 // CTRL_REG2_M REBOOT and SOFT_RST
+	lsm9ds1MagnResetTime = 10 * time.Millisecond
 )
 
-var magnInitData = [][2]byte{
-	{0x20, 0b_1001_0000}, // CTRL_REG1_M
-	{0x21, 0b_0000_1100}, // CTRL_REG2_M
-	{0x22, 0b_0000_0000}, // CTRL_REG3_M
+// LSM9DS1Settings selects output data rates (in Hz) and full scale ranges
+// (in g and gauss). Zero values select the defaults of 10 Hz, ±2 g, 10 Hz
+// and ±4 gauss. Raw readings scale with the range, so a magnetometer
+// calibration is only valid for the range it was made with.
+//
+// With FIFO set, the accelerometer buffers up to 32 samples between
+// refreshes, all of which are read on each refresh and available from
+// AccelerationSamples.
+type LSM9DS1Settings struct {
+	AccelRate  float64
+	AccelRange int
+	MagnRate   float64
+	MagnRange  int
+	FIFO       bool
 }
 
-func NewLSM9DS1(dev i2c.Device, magnOffs float64, cal Calibration) (*LSM9DS1, error) {
-	// Initialize sensors
+// Register bit patterns, per the data sheet, keyed by rate or range.
+var (
+	lsm9ds1AccelRates  = map[float64]byte{10: 0b001, 50: 0b010, 119: 0b011, 238: 0b100, 476: 0b101, 952: 0b110}
+	lsm9ds1AccelRanges = map[int]byte{2: 0b00, 4: 0b10, 8: 0b11, 16: 0b01}
+	lsm9ds1MagnRates   = map[float64]byte{0.625: 0b000, 1.25: 0b001, 2.5: 0b010, 5: 0b011, 10: 0b100, 20: 0b101, 40: 0b110, 80: 0b111}
+	lsm9ds1MagnRanges  = map[int]byte{4: 0b00, 8: 0b01, 12: 0b10, 16: 0b11}
+
+	// Nominal sensitivity in mg and mgauss per count, keyed by range.
+	lsm9ds1AccelSensitivity = map[int]float64{2: 0.061, 4: 0.122, 8: 0.244, 16: 0.732}
+	lsm9ds1MagnSensitivity  = map[int]float64{4: 0.14, 8: 0.29, 12: 0.43, 16: 0.58}
+)
+
+// registers returns the values for CTRL_REG6_XL, CTRL_REG1_M and
+// CTRL_REG2_M.
+func (c LSM9DS1Settings) registers() (ctrl6XL, ctrl1M, ctrl2M byte, err error) {
+	if c.AccelRate == 0 {
+		c.AccelRate = 10
+	}
+	if c.AccelRange == 0 {
+		c.AccelRange = 2
+	}
+	if c.MagnRate == 0 {
+		c.MagnRate = 10
+	}
+	if c.MagnRange == 0 {
+		c.MagnRange = 4
+	}
 
-	if err := dev.SetAddress(lsm9ds1AccelAddress); err != nil {
-		return nil, fmt.Errorf("set device address: %w", err)
+	odrXL, ok := lsm9ds1AccelRates[c.AccelRate]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unsupported accelerometer rate %v Hz", c.AccelRate)
 	}
-	if err := dev.WriteByteData(lsm9ds1AccelCtrlReg6XL, lsm9ds1AccelInitData); err != nil {
-		return nil, fmt.Errorf("write control register 6_XL: %w", err)
+	fsXL, ok := lsm9ds1AccelRanges[c.AccelRange]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unsupported accelerometer range ±%d g", c.AccelRange)
+	}
+	doM, ok := lsm9ds1MagnRates[c.MagnRate]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unsupported magnetometer rate %v Hz", c.MagnRate)
+	}
+	fsM, ok := lsm9ds1MagnRanges[c.MagnRange]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unsupported magnetometer range ±%d gauss", c.MagnRange)
+	}
+
+	ctrl6XL = odrXL<<5 | fsXL<<3
+	ctrl1M = 0b_1000_0000 | doM<<2 // temperature compensated, low power
+	ctrl2M = fsM << 5
+	return ctrl6XL, ctrl1M, ctrl2M, nil
+}
+
+func NewLSM9DS1(bus *i2c.Bus, accelAddr, magnAddr int, magnOffs float64, cal Calibration, settings LSM9DS1Settings) (*LSM9DS1, error) {
+	ctrl6XL, ctrl1M, ctrl2M, err := settings.registers()
+	if err != nil {
+		return nil, err
+	}
+
+	var ctrl9, fifoCtrl byte
+	if settings.FIFO {
+		ctrl9, fifoCtrl = lsm9ds1FIFOEnable, lsm9ds1FIFOContinuous
+	}
+
+	// Initialize sensors
+
+	var accelID, magnID string
+	err = bus.Do(accelAddr, func(dev i2c.Device) error {
+		accelID = whoAmI(dev)
+		if err := dev.WriteByteData(lsm9ds1AccelCtrlReg6XL, ctrl6XL); err != nil {
+			return fmt.Errorf("write control register 6_XL: %w", err)
+		}
+		// The FIFO mode must be set with the FIFO enabled; going
+		// through bypass mode also clears any old contents.
+		if err := dev.WriteByteData(lsm9ds1AccelCtrlReg9, ctrl9|lsm9ds1FIFOEnable); err != nil {
+			return fmt.Errorf("write control register 9: %w", err)
+		}
+		if err := dev.WriteByteData(lsm9ds1FIFOCtrlReg, 0); err != nil {
+			return fmt.Errorf("write FIFO control register: %w", err)
+		}
+		if err := dev.WriteByteData(lsm9ds1FIFOCtrlReg, fifoCtrl); err != nil {
+			return fmt.Errorf("write FIFO control register: %w", err)
+		}
+		if err := dev.WriteByteData(lsm9ds1AccelCtrlReg9, ctrl9); err != nil {
+			return fmt.Errorf("write control register 9: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err := dev.SetAddress(lsm9ds1MagnAddress); err != nil {
-		return nil, fmt.Errorf("set device address: %w", err)
+	// The reset restores the default configuration, so it goes first
+	// and the settings after it.
+	magnInitData := [][2]byte{
+		{lsm9ds1MagnCtrlReg1M, ctrl1M},
+		{lsm9ds1MagnCtrlReg2M, ctrl2M},
+		{lsm9ds1MagnCtrlReg3M, 0b_0000_0000}, // continuous conversion
 	}
-	for _, line := range magnInitData {
-		if err := dev.WriteByteData(line[0], line[1]); err != nil {
-			log.Printf("write control register 0x%02x->0x%02x: %v", line[1], line[0], err)
+	err = bus.Do(magnAddr, func(dev i2c.Device) error {
+		magnID = whoAmI(dev)
+		if err := dev.WriteByteData(lsm9ds1MagnCtrlReg2M, lsm9ds1MagnReset); err != nil {
+			log.Printf("reset magnetometer: %v", err)
+		}
+		time.Sleep(lsm9ds1MagnResetTime)
+		for _, line := range magnInitData {
+			if err := dev.WriteByteData(line[0], line[1]); err != nil {
+				log.Printf("write control register 0x%02x->0x%02x: %v", line[1], line[0], err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &LSM9DS1{device: dev, cal: cal, mo: magnOffs}, nil
+	rate := settings.AccelRate
+	if rate == 0 {
+		rate = 10
+	}
+	rng := settings.AccelRange
+	if rng == 0 {
+		rng = 2
+	}
+	lsb := 1000 / lsm9ds1AccelSensitivity[rng]
+	magnRng := settings.MagnRange
+	if magnRng == 0 {
+		magnRng = 4
+	}
+	magnLSB := 1000 / lsm9ds1MagnSensitivity[magnRng]
+	var id string
+	if accelID != "" || magnID != "" {
+		id = fmt.Sprintf("%s, %s", accelID, magnID)
+	}
+	return &LSM9DS1{bus: bus, accelAddr: accelAddr, magnAddr: magnAddr, whoAmI: id, cal: NewCalibrator(cal, lsb, magnLSB), mo: magnOffs, fifo: settings.FIFO, rate: rate}, nil
 }
 
 func (s *LSM9DS1) Refresh(age time.Duration) error {
@@ -81,31 +244,59 @@ func (s *LSM9DS1) Refresh(age time.Duration) error {
 		return nil
 	}
 
-	r := i2c.NewReader(s.device)
-
-	if err := s.device.SetAddress(lsm9ds1AccelAddress); err != nil {
-		return fmt.Errorf("set device address: %w", err)
-	}
-
-	s.ax = int16(r.Signed(lsm9ds1AccelXOutXLReg+1, lsm9ds1AccelXOutXLReg))
-	s.ay = int16(r.Signed(lsm9ds1AccelYOutXLReg+1, lsm9ds1AccelYOutXLReg))
-	s.az = int16(r.Signed(lsm9ds1AccelZOutXLReg+1, lsm9ds1AccelZOutXLReg))
-	if err := r.Error(); err != nil {
-		return fmt.Errorf("read data: %w", err)
-	}
-
-	if err := s.device.SetAddress(lsm9ds1MagnAddress); err != nil {
-		return fmt.Errorf("set device address: %w", err)
+	err := s.bus.Do(s.accelAddr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		n := 1
+		if s.fifo {
+			n = r.Byte(lsm9ds1FIFOSrcReg) & lsm9ds1FIFOSamples
+		}
+		// Each read of the output registers pops one sample off the
+		// FIFO, when enabled.
+		raw := make([]Point, 0, n)
+		for i := 0; i < n; i++ {
+			data := r.Block(lsm9ds1AccelXOutXLReg, 6)
+			raw = append(raw, Point{
+				X: int16(i2c.SignedLE(data[0:2])),
+				Y: int16(i2c.SignedLE(data[2:4])),
+				Z: int16(i2c.SignedLE(data[4:6])),
+			})
+		}
+		temp := r.Block(lsm9ds1AccelOutTempL, 2)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
+		samples := make([]Point, len(raw))
+		for i, p := range raw {
+			samples[i] = s.cal.Acceleration(p)
+		}
+		s.temp = int16(i2c.SignedLE(temp))
+		s.samples = samples
+		if n > 0 {
+			last := samples[n-1]
+			s.ax, s.ay, s.az = last.X, last.Y, last.Z
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	s.mx = int16(r.Signed(lsm9ds1MagnXOutLReg+1, lsm9ds1MagnXOutLReg))
-	s.my = int16(r.Signed(lsm9ds1MagnYOutLReg+1, lsm9ds1MagnYOutLReg))
-	s.mz = int16(r.Signed(lsm9ds1MagnZOutLReg+1, lsm9ds1MagnZOutLReg))
-	if err := r.Error(); err != nil {
-		return fmt.Errorf("read data: %w", err)
+	err = s.bus.Do(s.magnAddr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(lsm9ds1MagnXOutLReg|autoIncrement, 6)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read data: %w", err)
+		}
+		s.mx = int16(i2c.SignedLE(data[0:2]))
+		s.my = int16(i2c.SignedLE(data[2:4]))
+		s.mz = int16(i2c.SignedLE(data[4:6]))
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	s.updateCalibration(s.mx, s.my, s.mz)
+	s.cal.MagneticField(Point{X: s.mx, Y: s.my, Z: s.mz})
 	s.cached = time.Now()
 	return nil
 }
@@ -113,7 +304,23 @@ func (s *LSM9DS1) Refresh(age time.Duration) error {
 func (s *LSM9DS1) Calibration() Calibration {
 	s.mut.Lock()
 	defer s.mut.Unlock()
-	return s.cal
+	return s.cal.Calibration()
+}
+
+// CalibrationResets returns the magnetometer calibration resets since the
+// last call.
+func (s *LSM9DS1) CalibrationResets() []CalibrationReset {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.cal.Resets()
+}
+
+// CalibrationQuality returns how far the magnetometer calibration can be
+// trusted.
+func (s *LSM9DS1) CalibrationQuality() CalibrationQuality {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.cal.Quality()
 }
 
 func (s *LSM9DS1) Acceleration() (x, y, z int16) {
@@ -122,6 +329,23 @@ func (s *LSM9DS1) Acceleration() (x, y, z int16) {
 	return s.ax, s.ay, s.az
 }
 
+// AccelerationSamples returns the accelerometer samples read on the last
+// refresh, oldest first. Without FIFO this is the single current sample.
+func (s *LSM9DS1) AccelerationSamples() []Point {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]Point(nil), s.samples...)
+}
+
+// AccelerationRate returns the number of accelerometer samples per second
+// available from AccelerationSamples.
+func (s *LSM9DS1) AccelerationRate(refresh time.Duration) float64 {
+	if !s.fifo {
+		return 1 / refresh.Seconds()
+	}
+	return s.rate
+}
+
 func (s *LSM9DS1) AccelerationAngles() (xy, xz, yz float64) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
@@ -131,6 +355,18 @@ func (s *LSM9DS1) AccelerationAngles() (xy, xz, yz float64) {
 	return xy, xz, yz
 }
 
+// Temperature returns the die temperature in degrees Celsius. It is meant
+// for compensating the gyroscope and runs warmer than the surroundings.
+func (s *LSM9DS1) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature()
+}
+
+func (s *LSM9DS1) temperature() float64 {
+	return 25 + float64(s.temp)/16
+}
+
 func (s *LSM9DS1) MagneticField() (x, y, z int16) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
@@ -140,31 +376,222 @@ func (s *LSM9DS1) MagneticField() (x, y, z int16) {
 func (s *LSM9DS1) Compass() (xy, xz, yz float64) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
-	x := float64(s.mx - (s.cal.Max.X+s.cal.Min.X)/2)
-	y := float64(s.my - (s.cal.Max.Y+s.cal.Min.Y)/2)
-	z := float64(s.mz - (s.cal.Max.Z+s.cal.Min.Z)/2)
-	return compass(y, x, s.mo), compass(z, x, s.mo), compass(z, y, s.mo)
+	return s.cal.Compass(Point{X: s.mx, Y: s.my, Z: s.mz}, s.mo)
+}
+
+func (s *LSM9DS1) Info() sensor.Info {
+	return sensor.Info{Chip: "LSM9DS1", Bus: "i2c", Address: fmt.Sprintf("0x%02x, 0x%02x", s.accelAddr, s.magnAddr), ID: s.whoAmI}
+}
+
+// Readings returns the calibrated acceleration, the raw magnetic field and
+// the die temperature.
+func (s *LSM9DS1) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "acceleration_x", Quantity: sensor.Acceleration, Value: float64(s.ax)},
+		{Name: "acceleration_y", Quantity: sensor.Acceleration, Value: float64(s.ay)},
+		{Name: "acceleration_z", Quantity: sensor.Acceleration, Value: float64(s.az)},
+		{Name: "magnetic_field_x", Quantity: sensor.MagneticField, Value: float64(s.mx)},
+		{Name: "magnetic_field_y", Quantity: sensor.MagneticField, Value: float64(s.my)},
+		{Name: "magnetic_field_z", Quantity: sensor.MagneticField, Value: float64(s.mz)},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 0, Value: s.temperature()},
+	}
+}
+
+// A CalibrationReset records a magnetometer calibration that was thrown
+// away as obviously bad, and why.
+type CalibrationReset struct {
+	Time        time.Time
+	Reason      string
+	Calibration Calibration
+}
+
+// Limits for a sane magnetometer calibration. The earth's field is at most
+// about 0.65 gauss, so a larger half range on any axis means a magnet or
+// other strong field has been near the sensor and the extremes are
+// skewed for good. An axis that hasn't moved at all in a good number of
+// readings, not even by noise, means the readings are frozen.
+const (
+	calibrationMaxField    = 1.0 // gauss
+	calibrationMinReads    = 100
+	calibrationResetFrozen = "frozen"
+	calibrationResetField  = "field"
+)
+
+// checkCalibration returns the reason the calibration, after the number of
+// readings, is bad, or the empty string if it is not.
+func checkCalibration(cal Calibration, reads int, lsbPerGauss float64) string {
+	mins := [3]int16{cal.Min.X, cal.Min.Y, cal.Min.Z}
+	maxs := [3]int16{cal.Max.X, cal.Max.Y, cal.Max.Z}
+	for i := range mins {
+		half := (float64(maxs[i]) - float64(mins[i])) / 2
+		if half > calibrationMaxField*lsbPerGauss {
+			return calibrationResetField
+		}
+		if reads >= calibrationMinReads && half == 0 {
+			return calibrationResetFrozen
+		}
+	}
+	return ""
+}
+
+// CalibrationQuality describes the magnetometer calibration. Coverage is
+// the fraction of the compass sectors the heading has been in since start,
+// as a calibration from a boat that has only swung half way around is
+// lopsided. Residual is the RMS relative deviation of the recent readings
+// from the ellipsoid given by the calibration; it is small when the
+// calibration matches the field and grows after a magnet has been near or
+// the sensor has moved. Score combines them into a figure between 0 and 1,
+// coverage times one minus the residual.
+type CalibrationQuality struct {
+	Coverage float64
+	Residual float64
+	Score    float64
 }
 
-func (s *LSM9DS1) updateCalibration(x, y, z int16) {
-	if s.cal.Max.X == 0 || x > s.cal.Max.X {
-		s.cal.Max.X = x
+const (
+	calibrationSectors = 36
+	// Weight of a new reading in the moving mean square residual.
+	calibrationResidualAlpha = 0.01
+)
+
+type calibrationTracker struct {
+	sectors [calibrationSectors]bool
+	resid2  float64
+	seen    bool
+}
+
+// observe adds the magnetometer reading m, with the acceleration a telling
+// which plane is horizontal.
+func (t *calibrationTracker) observe(cal Calibration, m, a Point) {
+	c := [3]float64{
+		(float64(cal.Max.X) + float64(cal.Min.X)) / 2,
+		(float64(cal.Max.Y) + float64(cal.Min.Y)) / 2,
+		(float64(cal.Max.Z) + float64(cal.Min.Z)) / 2,
+	}
+	r := [3]float64{
+		(float64(cal.Max.X) - float64(cal.Min.X)) / 2,
+		(float64(cal.Max.Y) - float64(cal.Min.Y)) / 2,
+		(float64(cal.Max.Z) - float64(cal.Min.Z)) / 2,
+	}
+	v := [3]float64{float64(m.X), float64(m.Y), float64(m.Z)}
+
+	var sum float64
+	for i := range v {
+		if r[i] <= 0 {
+			t.resid2 = 1
+			t.seen = true
+			return
+		}
+		d := (v[i] - c[i]) / r[i]
+		sum += d * d
+	}
+	res := math.Sqrt(sum) - 1
+	if !t.seen {
+		t.resid2 = res * res
+		t.seen = true
+	} else {
+		t.resid2 += calibrationResidualAlpha * (res*res - t.resid2)
 	}
-	if s.cal.Min.X == 0 || x < s.cal.Min.X {
-		s.cal.Min.X = x
+
+	// The heading in the horizontal plane, the one across the axis
+	// gravity is along.
+	ax, ay, az := math.Abs(float64(a.X)), math.Abs(float64(a.Y)), math.Abs(float64(a.Z))
+	var h float64
+	switch {
+	case ax >= ay && ax >= az:
+		h = math.Atan2(v[2]-c[2], v[1]-c[1])
+	case ay >= ax && ay >= az:
+		h = math.Atan2(v[2]-c[2], v[0]-c[0])
+	default:
+		h = math.Atan2(v[1]-c[1], v[0]-c[0])
 	}
-	if s.cal.Max.Y == 0 || y > s.cal.Max.Y {
-		s.cal.Max.Y = y
+	sector := int((h + math.Pi) / (2 * math.Pi) * calibrationSectors)
+	if sector >= calibrationSectors {
+		sector = calibrationSectors - 1
 	}
-	if s.cal.Min.Y == 0 || y < s.cal.Min.Y {
-		s.cal.Min.Y = y
+	t.sectors[sector] = true
+}
+
+func (t *calibrationTracker) quality() CalibrationQuality {
+	var q CalibrationQuality
+	for _, seen := range t.sectors {
+		if seen {
+			q.Coverage++
+		}
 	}
-	if s.cal.Max.Z == 0 || z > s.cal.Max.Z {
-		s.cal.Max.Z = z
+	q.Coverage /= calibrationSectors
+	if !t.seen {
+		q.Residual = 1
+	} else {
+		q.Residual = math.Sqrt(t.resid2)
 	}
-	if s.cal.Min.Z == 0 || z < s.cal.Min.Z {
-		s.cal.Min.Z = z
+	q.Score = q.Coverage * math.Max(0, 1-q.Residual)
+	return q
+}
+
+// Limits, in g, for a sample to count towards the accelerometer
+// calibration: the change from the previous sample on every axis, the
+// deviation of the vertical axis from one g and the other axes from zero.
+const (
+	accelCalStill      = 0.02
+	accelCalVertical   = 0.15
+	accelCalHorizontal = 0.15
+)
+
+// observe updates the calibration with the raw sample cur, if it differs
+// little enough from the previous one for the sensor to be still and one
+// axis is close enough to vertical. The reading for that position is
+// averaged over the samples that qualify.
+func (c *AccelCalibration) observe(prev, cur Point, lsb float64) {
+	p, q := [3]int16{prev.X, prev.Y, prev.Z}, [3]int16{cur.X, cur.Y, cur.Z}
+	vert := -1
+	for i := range q {
+		if math.Abs(float64(q[i])-float64(p[i])) > accelCalStill*lsb {
+			return
+		}
+		switch {
+		case math.Abs(math.Abs(float64(q[i]))-lsb) < accelCalVertical*lsb:
+			vert = i
+		case math.Abs(float64(q[i])) > accelCalHorizontal*lsb:
+			return
+		}
+	}
+	if vert < 0 {
+		return
+	}
+
+	min, max := [3]*int16{&c.Min.X, &c.Min.Y, &c.Min.Z}, [3]*int16{&c.Max.X, &c.Max.Y, &c.Max.Z}
+	pos := max[vert]
+	if q[vert] < 0 {
+		pos = min[vert]
+	}
+	if *pos == 0 {
+		*pos = q[vert]
+		return
+	}
+	*pos += (q[vert] - *pos) / 16
+}
+
+// apply returns the raw sample corrected for offset and scale, in counts at
+// the nominal sensitivity.
+func (c AccelCalibration) apply(p Point, lsb float64) Point {
+	return Point{
+		X: accelCalAxis(p.X, c.Min.X, c.Max.X, lsb),
+		Y: accelCalAxis(p.Y, c.Min.Y, c.Max.Y, lsb),
+		Z: accelCalAxis(p.Z, c.Min.Z, c.Max.Z, lsb),
+	}
+}
+
+func accelCalAxis(v, min, max int16, lsb float64) int16 {
+	if min >= 0 || max <= 0 {
+		return v
 	}
+	offs := (float64(max) + float64(min)) / 2
+	half := (float64(max) - float64(min)) / 2
+	res := math.Round((float64(v) - offs) * lsb / half)
+	return int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, res)))
 }
 
 func compass(y, x, o float64) float64 {
@@ -0,0 +1,122 @@
+package sensehat
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLSM9DS1SettingsRegisters(t *testing.T) {
+	// The defaults are the rates and ranges the driver has always used.
+	ctrl6XL, ctrl1M, ctrl2M, err := LSM9DS1Settings{}.registers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctrl6XL != 0x20 || ctrl1M != 0x90 || ctrl2M != 0x00 {
+		t.Errorf("unexpected default registers %02x %02x %02x", ctrl6XL, ctrl1M, ctrl2M)
+	}
+
+	ctrl6XL, ctrl1M, ctrl2M, err = LSM9DS1Settings{AccelRate: 119, AccelRange: 8, MagnRate: 80, MagnRange: 16}.registers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctrl6XL != 0b_011_11_000 || ctrl1M != 0b_1001_1100 || ctrl2M != 0b_0110_0000 {
+		t.Errorf("unexpected registers %02x %02x %02x", ctrl6XL, ctrl1M, ctrl2M)
+	}
+
+	if _, _, _, err := (LSM9DS1Settings{AccelRange: 3}).registers(); err == nil {
+		t.Error("expected error for unsupported range")
+	}
+}
+
+func TestAccelCalibration(t *testing.T) {
+	const lsb = 1000 / 0.061
+
+	// A sensor reading 200 counts high and 3% low on every axis, in all
+	// six positions.
+	raw := func(v float64) int16 { return int16(v*0.97*lsb + 200) }
+	positions := []Point{
+		{X: raw(1), Y: raw(0), Z: raw(0)},
+		{X: raw(-1), Y: raw(0), Z: raw(0)},
+		{X: raw(0), Y: raw(1), Z: raw(0)},
+		{X: raw(0), Y: raw(-1), Z: raw(0)},
+		{X: raw(0), Y: raw(0), Z: raw(1)},
+	}
+
+	var cal AccelCalibration
+	for _, p := range positions {
+		for i := 0; i < 10; i++ {
+			cal.observe(p, p, lsb)
+		}
+	}
+
+	// Z has only been seen pointing up, so is left as it is.
+	p := cal.apply(Point{X: raw(0.5), Y: raw(-0.5), Z: raw(0.5)}, lsb)
+	if d := float64(p.X) - 0.5*lsb; d < -2 || d > 2 {
+		t.Errorf("calibrated X %d, expected %v", p.X, 0.5*lsb)
+	}
+	if d := float64(p.Y) + 0.5*lsb; d < -2 || d > 2 {
+		t.Errorf("calibrated Y %d, expected %v", p.Y, -0.5*lsb)
+	}
+	if p.Z != raw(0.5) {
+		t.Errorf("uncalibrated Z %d, expected %d", p.Z, raw(0.5))
+	}
+
+	// Samples while moving are ignored.
+	before := cal
+	cal.observe(positions[4], Point{X: raw(0.1), Y: raw(0), Z: raw(-1)}, lsb)
+	if cal != before {
+		t.Error("calibration changed while moving")
+	}
+}
+
+func TestCalibrationQuality(t *testing.T) {
+	cal := Calibration{Min: Point{X: -100, Y: -200, Z: -300}, Max: Point{X: 300, Y: 200, Z: 100}}
+	down := Point{Z: 16000}
+
+	var tr calibrationTracker
+	if q := tr.quality(); q.Score != 0 || q.Residual != 1 {
+		t.Errorf("unexpected quality %+v before any readings", q)
+	}
+
+	// Half a turn, on the calibration ellipsoid.
+	for deg := 0; deg < 180; deg += 5 {
+		rad := float64(deg) / 180 * math.Pi
+		m := Point{X: int16(100 + 200*math.Cos(rad)), Y: int16(200 * math.Sin(rad)), Z: -100}
+		tr.observe(cal, m, down)
+	}
+	q := tr.quality()
+	if q.Coverage < 0.45 || q.Coverage > 0.55 {
+		t.Errorf("coverage %v after half a turn", q.Coverage)
+	}
+	if q.Residual > 0.3 {
+		t.Errorf("residual %v for readings near the ellipsoid", q.Residual)
+	}
+
+	// A collapsed axis can't be trusted at all.
+	tr.observe(Calibration{Min: Point{X: 10, Y: -200, Z: -300}, Max: Point{X: 10, Y: 200, Z: 100}}, Point{X: 10, Y: 0, Z: 0}, down)
+	if q := tr.quality(); q.Score != 0 {
+		t.Errorf("score %v with a collapsed axis", q.Score)
+	}
+}
+
+func TestCheckCalibration(t *testing.T) {
+	const lsb = 1000 / 0.14
+
+	good := Calibration{Min: Point{X: -3000, Y: -2500, Z: -1000}, Max: Point{X: 2000, Y: 2600, Z: 1200}}
+	if r := checkCalibration(good, 1000, lsb); r != "" {
+		t.Errorf("good calibration rejected: %s", r)
+	}
+
+	frozen := Calibration{Min: Point{X: -3000, Y: 500, Z: -1000}, Max: Point{X: 2000, Y: 500, Z: 1200}}
+	if r := checkCalibration(frozen, 10, lsb); r != "" {
+		t.Errorf("calibration rejected after few readings: %s", r)
+	}
+	if r := checkCalibration(frozen, 1000, lsb); r != calibrationResetFrozen {
+		t.Errorf("frozen calibration gave %q", r)
+	}
+
+	magnet := Calibration{Min: Point{X: -3000, Y: -2500, Z: -1000}, Max: Point{X: 20000, Y: 2600, Z: 1200}}
+	if r := checkCalibration(magnet, 10, lsb); r != calibrationResetField {
+		t.Errorf("calibration with a magnet near gave %q", r)
+	}
+}
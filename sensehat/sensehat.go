@@ -0,0 +1,48 @@
+package sensehat
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+// The ST sensors auto-increment the register address in multi-byte reads
+// when the high bit of the register address is set. (The LSM9DS1
+// accelerometer/gyroscope instead does so by default, controlled by
+// IF_ADD_INC in CTRL_REG8.)
+const autoIncrement = 0x80
+
+// whoAmIReg is the identification register of the ST sensors. The value is
+// kept for the sensor metadata rather than checked, as compatible chips on
+// breakout boards may differ.
+const whoAmIReg = 0x0f
+
+func whoAmI(dev i2c.Device) string {
+	id, err := i2c.NewReader(dev).Read(whoAmIReg)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("WHO_AM_I 0x%02x", id[0])
+}
+
+// findDevice returns the device node for the sysfs class entry matching
+// the glob whose name file has the given contents.
+func findDevice(glob, nameFile, name, devDir string) (string, error) {
+	dirs, err := filepath.Glob(glob)
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range dirs {
+		bs, err := ioutil.ReadFile(filepath.Join(dir, nameFile))
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(bs)) == name {
+			return filepath.Join(devDir, filepath.Base(dir)), nil
+		}
+	}
+	return "", fmt.Errorf("%s not found", name)
+}
@@ -0,0 +1,170 @@
+package sensirion
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Sensirion SCD30 NDIR CO2 sensor, with temperature and humidity. It
+// measures continuously at the set interval and the latest measurement is
+// read when it is ready.
+//
+// The automatic self calibration assumes that the sensor sees fresh air,
+// about 400 ppm, for at least an hour a day; it is best left off in a
+// boat that is closed up for weeks. The setting is kept by the sensor, so
+// it is set at every start.
+//
+// The SCD30 stretches the clock for up to 150 ms, which the Raspberry Pi
+// I2C controller handles badly; run the bus at 50 kHz or slower, or use
+// the i2c-gpio overlay.
+
+type SCD30 struct {
+	bus     *i2c.Bus
+	address int
+	version string
+
+	mut         sync.Mutex
+	cached      time.Time
+	measured    bool
+	co2         float64 // ppm
+	temperature float64 // °C
+	humidity    float64 // %RH
+}
+
+const SCD30DefaultAddress = 0x61
+
+const (
+	scd30StartCmd       = 0x0010
+	scd30IntervalCmd    = 0x4600
+	scd30ReadyCmd       = 0x0202
+	scd30MeasurementCmd = 0x0300
+	scd30SelfCalCmd     = 0x5306
+	scd30VersionCmd     = 0xd100
+
+	// scd30ReadDelay is the least time between a command and reading
+	// the answer.
+	scd30ReadDelay = 3 * time.Millisecond
+
+	SCD30MinInterval = 2 * time.Second
+	SCD30MaxInterval = 1800 * time.Second
+)
+
+// ErrNoMeasurement is returned by Refresh until the first measurement is
+// ready.
+var ErrNoMeasurement = errors.New("no measurement yet")
+
+// NewSCD30 starts continuous measurement at the interval, with the
+// automatic self calibration on or off.
+func NewSCD30(bus *i2c.Bus, interval time.Duration, selfCalibration bool) (*SCD30, error) {
+	if interval < SCD30MinInterval || interval > SCD30MaxInterval {
+		return nil, fmt.Errorf("invalid measurement interval %v (valid: %v to %v)", interval, SCD30MinInterval, SCD30MaxInterval)
+	}
+	asc := uint16(0)
+	if selfCalibration {
+		asc = 1
+	}
+
+	s := &SCD30{bus: bus, address: SCD30DefaultAddress}
+	err := bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		v, err := query(r, scd30VersionCmd, scd30ReadDelay, 1)
+		if err != nil {
+			return fmt.Errorf("read firmware version: %w", err)
+		}
+		s.version = fmt.Sprintf("%d.%d", v[0]>>8, v[0]&0xff)
+
+		if err := command(r, scd30IntervalCmd, uint16(interval/time.Second)); err != nil {
+			return fmt.Errorf("set interval: %w", err)
+		}
+		if err := command(r, scd30SelfCalCmd, asc); err != nil {
+			return fmt.Errorf("set self calibration: %w", err)
+		}
+		// No ambient pressure compensation.
+		if err := command(r, scd30StartCmd, 0); err != nil {
+			return fmt.Errorf("start measurement: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Refresh reads the latest measurement, if there is a new one.
+func (s *SCD30) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		ready, err := query(r, scd30ReadyCmd, scd30ReadDelay, 1)
+		if err != nil {
+			return fmt.Errorf("read data ready: %w", err)
+		}
+		if ready[0] != 1 {
+			if !s.measured {
+				return ErrNoMeasurement
+			}
+			return nil
+		}
+		w, err := query(r, scd30MeasurementCmd, scd30ReadDelay, 6)
+		if err != nil {
+			return fmt.Errorf("read measurement: %w", err)
+		}
+		f := func(i int) float64 { return float64(math.Float32frombits(uint32(w[i])<<16 | uint32(w[i+1]))) }
+		s.co2, s.temperature, s.humidity = f(0), f(2), f(4)
+		s.measured = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// CO2 returns the CO2 concentration, in ppm.
+func (s *SCD30) CO2() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.co2
+}
+
+// Temperature returns the temperature, in °C.
+func (s *SCD30) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature
+}
+
+// Humidity returns the relative humidity, in percent.
+func (s *SCD30) Humidity() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.humidity
+}
+
+func (s *SCD30) Info() sensor.Info {
+	return sensor.Info{Chip: "SCD30", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address), ID: "firmware " + s.version}
+}
+
+func (s *SCD30) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "co2", Unit: "ppm", Quantity: sensor.Concentration, Precision: 0, Value: s.co2},
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 1, Value: s.temperature},
+		{Name: "humidity", Unit: "percent", Quantity: sensor.Humidity, Precision: 0, Value: s.humidity},
+	}
+}
@@ -0,0 +1,85 @@
+// Package sensirion reads the Sensirion gas and environment sensors.
+package sensirion
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+// The Sensirion sensors take 16 bit commands, optionally followed by
+// arguments, and answer in a separate read. Arguments and answers are 16
+// bit words, each followed by a CRC-8 of the word.
+
+const (
+	crcPolynomial = 0x31
+	crcInit       = 0xff
+)
+
+// crc8 returns the Sensirion CRC-8 of the data.
+func crc8(data []byte) byte {
+	crc := byte(crcInit)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ crcPolynomial
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// encodeWords returns the words with their CRCs, as sent.
+func encodeWords(words ...uint16) []byte {
+	buf := make([]byte, 0, 3*len(words))
+	for _, w := range words {
+		b := []byte{byte(w >> 8), byte(w)}
+		buf = append(buf, b[0], b[1], crc8(b))
+	}
+	return buf
+}
+
+// decodeWords returns the words of the frame, checking their CRCs.
+func decodeWords(frame []byte) ([]uint16, error) {
+	if len(frame)%3 != 0 {
+		return nil, fmt.Errorf("frame length %d is not a whole number of words", len(frame))
+	}
+	words := make([]uint16, len(frame)/3)
+	for i := range words {
+		b := frame[3*i : 3*i+2]
+		if crc := crc8(b); crc != frame[3*i+2] {
+			return nil, fmt.Errorf("CRC mismatch in word %d: 0x%02x != expected 0x%02x", i, frame[3*i+2], crc)
+		}
+		words[i] = uint16(b[0])<<8 | uint16(b[1])
+	}
+	return words, nil
+}
+
+// command sends the command with its arguments.
+func command(r *i2c.Reader, cmd uint16, args ...uint16) error {
+	if err := r.WriteBlock16(cmd, encodeWords(args...)); err != nil {
+		return fmt.Errorf("command 0x%04x: %w", cmd, err)
+	}
+	return nil
+}
+
+// query sends the command and, after the delay, reads n words of answer.
+func query(r *i2c.Reader, cmd uint16, delay time.Duration, n int) ([]uint16, error) {
+	if err := command(r, cmd); err != nil {
+		return nil, err
+	}
+	time.Sleep(delay)
+	frame, err := r.ReadBytes(3 * n)
+	if err != nil {
+		return nil, fmt.Errorf("command 0x%04x: %w", cmd, err)
+	}
+	words, err := decodeWords(frame)
+	if err != nil {
+		return nil, fmt.Errorf("command 0x%04x: %w", cmd, err)
+	}
+	return words, nil
+}
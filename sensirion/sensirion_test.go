@@ -0,0 +1,197 @@
+package sensirion
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+)
+
+func TestCRC8(t *testing.T) {
+	// The example from the datasheets.
+	if crc := crc8([]byte{0xbe, 0xef}); crc != 0x92 {
+		t.Errorf("got 0x%02x, expected 0x92", crc)
+	}
+	if _, err := decodeWords([]byte{0xbe, 0xef, 0x92, 0x00, 0x00, 0x81}); err != nil {
+		t.Error(err)
+	}
+	if _, err := decodeWords([]byte{0xbe, 0xef, 0x93}); err == nil {
+		t.Error("expected CRC error")
+	}
+}
+
+// cmdDevice answers each command from a table of words.
+type cmdDevice struct {
+	i2c.Device
+	answers map[uint16][]uint16
+	sent    map[uint16][]byte // arguments of the commands sent
+	last    uint16
+}
+
+func (d *cmdDevice) SetAddress(addr int) error { return nil }
+
+func (d *cmdDevice) ReadRegister16(reg uint16, buf []byte) error {
+	panic("no repeated start")
+}
+
+func (d *cmdDevice) WriteRegister16(reg uint16, data []byte) error {
+	if d.sent == nil {
+		d.sent = make(map[uint16][]byte)
+	}
+	d.sent[reg] = append([]byte(nil), data...)
+	d.last = reg
+	return nil
+}
+
+func (d *cmdDevice) Read(buf []byte) (int, error) {
+	return copy(buf, encodeWords(d.answers[d.last]...)), nil
+}
+
+func TestSCD30(t *testing.T) {
+	bits := func(f float32) []uint16 {
+		b := math.Float32bits(f)
+		return []uint16{uint16(b >> 16), uint16(b)}
+	}
+	var meas []uint16
+	meas = append(meas, bits(850.5)...)
+	meas = append(meas, bits(21.25)...)
+	meas = append(meas, bits(55)...)
+	dev := &cmdDevice{answers: map[uint16][]uint16{
+		scd30VersionCmd: {0x0342},
+		scd30ReadyCmd:   {0},
+	}}
+
+	s, err := NewSCD30(i2c.NewBus(dev), 5*time.Second, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := s.Info().ID; id != "firmware 3.66" {
+		t.Errorf("unexpected ID %q", id)
+	}
+	if a := dev.sent[scd30IntervalCmd]; len(a) != 3 || a[1] != 5 {
+		t.Errorf("unexpected interval argument %x", a)
+	}
+	if a := dev.sent[scd30SelfCalCmd]; len(a) != 3 || a[1] != 1 {
+		t.Errorf("unexpected self calibration argument %x", a)
+	}
+	if _, ok := dev.sent[scd30StartCmd]; !ok {
+		t.Error("expected measurement started")
+	}
+
+	if err := s.Refresh(0); err != ErrNoMeasurement {
+		t.Errorf("expected ErrNoMeasurement before the first measurement, got %v", err)
+	}
+	dev.answers[scd30ReadyCmd] = []uint16{1}
+	dev.answers[scd30MeasurementCmd] = meas
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.CO2() != 850.5 || s.Temperature() != 21.25 || s.Humidity() != 55 {
+		t.Errorf("unexpected readings %v", s.Readings())
+	}
+
+	if _, err := NewSCD30(i2c.NewBus(dev), time.Second, false); err == nil {
+		t.Error("expected error for too short an interval")
+	}
+}
+
+func TestSGP30(t *testing.T) {
+	dev := &cmdDevice{answers: map[uint16][]uint16{
+		sgp30SerialCmd:      {0x0000, 0x0123, 0x4567},
+		sgp30MeasureCmd:     {450, 25},
+		sgp30GetBaselineCmd: {0x8a21, 0x8f3c},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // measured by hand below
+
+	s, err := NewSGP30(ctx, i2c.NewBus(dev), &SGP30Baseline{ECO2: 0x8a00, TVOC: 0x8f00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := dev.sent[sgp30SetBaselineCmd]; len(a) != 6 || a[0] != 0x8f || a[3] != 0x8a {
+		t.Errorf("unexpected baseline arguments %x", a)
+	}
+
+	s.measure()
+	if err := s.Refresh(0); err != ErrNoMeasurement {
+		t.Errorf("expected ErrNoMeasurement while warming up, got %v", err)
+	}
+
+	s.started = time.Now().Add(-2 * time.Hour)
+	s.SetCompensation(20, 50) // 8.6 g/m³
+	s.measure()
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if s.ECO2() != 450 || s.TVOC() != 25 {
+		t.Errorf("unexpected readings %v", s.Readings())
+	}
+	if a := dev.sent[sgp30HumidityCmd]; len(a) != 3 || a[0] != 8 {
+		t.Errorf("unexpected humidity argument %x", a)
+	}
+	if b, ok := s.Baseline(); !ok || b.ECO2 != 0x8a21 || b.TVOC != 0x8f3c {
+		t.Errorf("unexpected baseline %v %v", b, ok)
+	}
+}
+
+func TestVOCIndex(t *testing.T) {
+	a := newVOCAlgorithm()
+	var index int
+	for i := 0; i < 3600; i++ {
+		index = a.process(30000)
+	}
+	if index != 100 {
+		t.Errorf("expected index 100 for a steady signal, got %d", index)
+	}
+
+	// VOCs lower the raw signal.
+	for i := 0; i < 60; i++ {
+		index = a.process(29000)
+	}
+	if index <= 150 {
+		t.Errorf("expected a higher index with VOCs, got %d", index)
+	}
+	if a.learned() {
+		t.Error("expected not learned after an hour")
+	}
+
+	b := newVOCAlgorithm()
+	b.setState(a.state())
+	if !b.learned() || b.state() != a.state() {
+		t.Errorf("unexpected restored state %v", b.state())
+	}
+}
+
+func TestSGP40(t *testing.T) {
+	dev := &cmdDevice{answers: map[uint16][]uint16{
+		sgp40SerialCmd:  {0x0000, 0x0123, 0x4567},
+		sgp40MeasureCmd: {30000},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s, err := NewSGP40(ctx, i2c.NewBus(dev), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetCompensation(25, 50)
+	s.measure()
+	if a := dev.sent[sgp40MeasureCmd]; len(a) != 6 || a[0] != 0x80 || a[3] != 0x66 {
+		t.Errorf("unexpected compensation arguments %x", a)
+	}
+	if err := s.Refresh(0); err != ErrNoMeasurement {
+		t.Errorf("expected ErrNoMeasurement during the blackout, got %v", err)
+	}
+	for i := 0; i < 60; i++ {
+		s.measure()
+	}
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	// Rising towards 100, through the low pass filter.
+	if s.Raw() != 30000 || s.VOCIndex() < 1 || s.VOCIndex() > 100 {
+		t.Errorf("unexpected readings %v", s.Readings())
+	}
+}
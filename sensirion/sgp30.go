@@ -0,0 +1,235 @@
+package sensirion
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Sensirion SGP30 air quality sensor, giving the total VOC (TVOC) and the
+// CO2 equivalent estimated from it (eCO2). It must be measured once a
+// second for its baseline compensation, so it is measured in the
+// background rather than when refreshed.
+//
+// The baseline takes twelve hours to learn, and can then be saved and
+// restored after a restart within a week. It is only valid, and offered
+// for saving, after the twelve hours or an hour after being restored.
+
+type SGP30 struct {
+	bus     *i2c.Bus
+	address int
+	serial  string
+	started time.Time
+	valid   time.Duration // time until the baseline is valid
+
+	mut         sync.Mutex
+	err         error
+	measured    bool
+	eco2        float64 // ppm
+	tvoc        float64 // ppb
+	baseline    SGP30Baseline
+	hasBaseline bool
+	baselineAt  time.Time
+	humidity    uint16 // absolute, g/m³ in 8.8 fixed point, 0 when not compensating
+	setHumidity bool
+}
+
+// SGP30Baseline is the learned baseline of the SGP30.
+type SGP30Baseline struct {
+	ECO2 uint16 `json:"eco2"`
+	TVOC uint16 `json:"tvoc"`
+}
+
+const SGP30Address = 0x58
+
+const (
+	sgp30SerialCmd      = 0x3682
+	sgp30InitCmd        = 0x2003
+	sgp30MeasureCmd     = 0x2008
+	sgp30GetBaselineCmd = 0x2015
+	sgp30SetBaselineCmd = 0x201e
+	sgp30HumidityCmd    = 0x2061
+
+	sgp30SerialDelay  = time.Millisecond
+	sgp30CommandDelay = 10 * time.Millisecond
+	sgp30MeasureDelay = 12 * time.Millisecond
+
+	// The measurements are fixed at 400 ppm and 0 ppb while the sensor
+	// warms up.
+	sgp30WarmUp = 15 * time.Second
+
+	// SGP30BaselineAge is how long a saved baseline may be restored.
+	SGP30BaselineAge = 7 * 24 * time.Hour
+
+	sgp30BaselineLearning = 12 * time.Hour
+	sgp30BaselineRestored = time.Hour
+	sgp30BaselineInterval = time.Minute
+)
+
+// NewSGP30 starts the air quality measurements, from the saved baseline
+// if not nil. The measurements stop when the context is cancelled.
+func NewSGP30(ctx context.Context, bus *i2c.Bus, baseline *SGP30Baseline) (*SGP30, error) {
+	s := &SGP30{bus: bus, address: SGP30Address, valid: sgp30BaselineLearning}
+	err := bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		w, err := query(r, sgp30SerialCmd, sgp30SerialDelay, 3)
+		if err != nil {
+			return fmt.Errorf("read serial number: %w", err)
+		}
+		s.serial = fmt.Sprintf("%04x%04x%04x", w[0], w[1], w[2])
+
+		if err := command(r, sgp30InitCmd); err != nil {
+			return fmt.Errorf("init: %w", err)
+		}
+		time.Sleep(sgp30CommandDelay)
+		if baseline != nil {
+			// In the reverse order of reading it.
+			if err := command(r, sgp30SetBaselineCmd, baseline.TVOC, baseline.ECO2); err != nil {
+				return fmt.Errorf("set baseline: %w", err)
+			}
+			time.Sleep(sgp30CommandDelay)
+			s.valid = sgp30BaselineRestored
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.started = time.Now()
+	go s.run(ctx)
+	return s, nil
+}
+
+func (s *SGP30) run(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.measure()
+		}
+	}
+}
+
+func (s *SGP30) measure() {
+	s.mut.Lock()
+	humidity, setHumidity := s.humidity, s.setHumidity
+	s.setHumidity = false
+	getBaseline := time.Since(s.started) >= s.valid && time.Since(s.baselineAt) >= sgp30BaselineInterval
+	s.mut.Unlock()
+
+	var m, b []uint16
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		if setHumidity {
+			if err := command(r, sgp30HumidityCmd, humidity); err != nil {
+				return fmt.Errorf("set humidity: %w", err)
+			}
+			time.Sleep(sgp30CommandDelay)
+		}
+		var err error
+		m, err = query(r, sgp30MeasureCmd, sgp30MeasureDelay, 2)
+		if err != nil {
+			return fmt.Errorf("measure: %w", err)
+		}
+		if getBaseline {
+			b, err = query(r, sgp30GetBaselineCmd, sgp30CommandDelay, 2)
+			if err != nil {
+				return fmt.Errorf("get baseline: %w", err)
+			}
+		}
+		return nil
+	})
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.err = err
+	if err != nil {
+		if setHumidity {
+			s.setHumidity = true
+		}
+		return
+	}
+	if time.Since(s.started) >= sgp30WarmUp {
+		s.eco2, s.tvoc = float64(m[0]), float64(m[1])
+		s.measured = true
+	}
+	if b != nil {
+		s.baseline = SGP30Baseline{ECO2: b[0], TVOC: b[1]}
+		s.hasBaseline = true
+		s.baselineAt = time.Now()
+	}
+}
+
+// SetCompensation sets the temperature (°C) and relative humidity (%) of
+// the air, for humidity compensation.
+func (s *SGP30) SetCompensation(temperature, humidity float64) {
+	abs := absoluteHumidity(temperature, humidity)
+	fixed := uint16(math.Round(math.Min(math.Max(abs, 1.0/256), 255) * 256))
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if fixed != s.humidity {
+		s.humidity, s.setHumidity = fixed, true
+	}
+}
+
+// absoluteHumidity returns the absolute humidity in g/m³.
+func absoluteHumidity(temperature, humidity float64) float64 {
+	return 216.7 * (humidity / 100 * 6.112 * math.Exp(17.62*temperature/(243.12+temperature)) / (273.15 + temperature))
+}
+
+// Refresh returns the error of the latest background measurement, or
+// ErrNoMeasurement while the sensor warms up.
+func (s *SGP30) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if !s.measured {
+		return ErrNoMeasurement
+	}
+	return nil
+}
+
+// ECO2 returns the CO2 equivalent, in ppm.
+func (s *SGP30) ECO2() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.eco2
+}
+
+// TVOC returns the total VOC, in ppb.
+func (s *SGP30) TVOC() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.tvoc
+}
+
+// Baseline returns the current baseline, and whether it is valid for
+// saving.
+func (s *SGP30) Baseline() (SGP30Baseline, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.baseline, s.hasBaseline
+}
+
+func (s *SGP30) Info() sensor.Info {
+	return sensor.Info{Chip: "SGP30", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address), ID: s.serial}
+}
+
+func (s *SGP30) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "eco2", Unit: "ppm", Quantity: sensor.Concentration, Precision: 0, Value: s.eco2},
+		{Name: "tvoc", Unit: "ppb", Quantity: sensor.Concentration, Precision: 0, Value: s.tvoc},
+	}
+}
@@ -0,0 +1,175 @@
+package sensirion
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// Sensirion SGP40 VOC sensor. The raw signal is turned into a VOC index by
+// the gas index algorithm (see voc.go), which needs a sample every second,
+// so it is measured in the background rather than when refreshed.
+
+type SGP40 struct {
+	bus     *i2c.Bus
+	address int
+	serial  string
+
+	mut      sync.Mutex
+	err      error
+	measured bool
+	raw      int
+	index    int
+	voc      *vocAlgorithm
+	comp     [2]uint16 // humidity and temperature ticks
+}
+
+const SGP40Address = 0x59
+
+const (
+	sgp40SerialCmd  = 0x3682
+	sgp40MeasureCmd = 0x260f
+
+	sgp40SerialDelay  = time.Millisecond
+	sgp40MeasureDelay = 30 * time.Millisecond
+
+	// SGP40StateAge is how long a saved VOC algorithm state may be
+	// restored.
+	SGP40StateAge = 10 * time.Minute
+)
+
+// sgp40NoCompensation are the humidity and temperature ticks that turn off
+// the compensation: 50 %RH and 25 °C.
+var sgp40NoCompensation = [2]uint16{0x8000, 0x6666}
+
+// NewSGP40 starts the VOC measurements, from the saved algorithm state if
+// not nil. The measurements stop when the context is cancelled.
+func NewSGP40(ctx context.Context, bus *i2c.Bus, state *VOCState) (*SGP40, error) {
+	s := &SGP40{bus: bus, address: SGP40Address, voc: newVOCAlgorithm(), comp: sgp40NoCompensation}
+	if state != nil {
+		s.voc.setState(*state)
+	}
+	err := bus.Do(s.address, func(dev i2c.Device) error {
+		w, err := query(i2c.NewReader(dev), sgp40SerialCmd, sgp40SerialDelay, 3)
+		if err != nil {
+			return fmt.Errorf("read serial number: %w", err)
+		}
+		s.serial = fmt.Sprintf("%04x%04x%04x", w[0], w[1], w[2])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	go s.run(ctx)
+	return s, nil
+}
+
+func (s *SGP40) run(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.measure()
+		}
+	}
+}
+
+func (s *SGP40) measure() {
+	s.mut.Lock()
+	comp := s.comp
+	s.mut.Unlock()
+
+	var raw []uint16
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		if err := command(r, sgp40MeasureCmd, comp[0], comp[1]); err != nil {
+			return fmt.Errorf("measure: %w", err)
+		}
+		time.Sleep(sgp40MeasureDelay)
+		frame, err := r.ReadBytes(3)
+		if err != nil {
+			return fmt.Errorf("measure: %w", err)
+		}
+		raw, err = decodeWords(frame)
+		return err
+	})
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.err = err
+	if err != nil {
+		return
+	}
+	s.raw = int(raw[0])
+	s.index = s.voc.process(s.raw)
+	if s.voc.uptime > vocInitialBlackout {
+		s.measured = true
+	}
+}
+
+// SetCompensation sets the temperature (°C) and relative humidity (%) of
+// the air, for humidity compensation.
+func (s *SGP40) SetCompensation(temperature, humidity float64) {
+	rh := math.Min(math.Max(humidity, 0), 100)
+	t := math.Min(math.Max(temperature, -45), 130)
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.comp = [2]uint16{uint16(math.Round(rh * 65535 / 100)), uint16(math.Round((t + 45) * 65535 / 175))}
+}
+
+// Refresh returns the error of the latest background measurement, or
+// ErrNoMeasurement until the VOC index is available.
+func (s *SGP40) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	if !s.measured {
+		return ErrNoMeasurement
+	}
+	return nil
+}
+
+// VOCIndex returns the VOC index, from 1 to 500 with 100 as the average.
+func (s *SGP40) VOCIndex() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return float64(s.index)
+}
+
+// Raw returns the raw signal, in ticks.
+func (s *SGP40) Raw() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return float64(s.raw)
+}
+
+// State returns the state of the VOC index algorithm, and whether it has
+// learned enough to be worth saving.
+func (s *SGP40) State() (VOCState, bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.voc.state(), s.voc.learned()
+}
+
+func (s *SGP40) Info() sensor.Info {
+	return sensor.Info{Chip: "SGP40", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address), ID: s.serial}
+}
+
+func (s *SGP40) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "voc_index", Unit: "index", Quantity: sensor.AirQuality, Precision: 0, Value: float64(s.index)},
+		{Name: "raw", Unit: "ticks", Quantity: sensor.Raw, Precision: 0, Value: float64(s.raw)},
+	}
+}
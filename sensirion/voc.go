@@ -0,0 +1,244 @@
+package sensirion
+
+import "math"
+
+// The Sensirion gas index algorithm for VOC, turning the raw SGP40 signal
+// into a VOC index from 1 to 500, where 100 is the average of the past
+// day: a port of the floating point version of the reference
+// implementation, for a sampling interval of one second.
+//
+// The algorithm tracks the mean and spread of the raw signal over about
+// twelve hours. This state can be saved and restored across restarts that
+// are short enough (ten minutes) for it to still be valid; otherwise it
+// takes the algorithm an hour or so to settle.
+
+const (
+	vocSamplingInterval = 1.0 // s
+
+	vocInitialBlackout        = 45.0 // s
+	vocIndexGain              = 230.0
+	vocSrawStdInitial         = 50.0
+	vocSrawStdBonus           = 220.0
+	vocTauMeanHours           = 12.0
+	vocTauVarianceHours       = 12.0
+	vocTauInitialMean         = 20.0
+	vocInitDurationMean       = 3600 * 0.75
+	vocInitTransitionMean     = 0.01
+	vocTauInitialVariance     = 2500.0
+	vocInitDurationVariance   = 3600 * 1.45
+	vocInitTransitionVariance = 0.01
+	vocGatingThreshold        = 340.0
+	vocGatingThresholdInitial = 510.0
+	vocGatingThresholdTrans   = 0.09
+	vocGatingMaxDuration      = 60 * 3.0 // minutes
+	vocGatingMaxRatio         = 0.3
+	vocSigmoidL               = 500.0
+	vocSigmoidK               = -0.0065
+	vocSigmoidX0              = 213.0
+	vocIndexOffset            = 100.0
+	vocLPTauFast              = 20.0
+	vocLPTauSlow              = 500.0
+	vocLPAlpha                = -0.2
+	vocSrawMinimum            = 20000
+	vocPersistenceUptimeGamma = 3 * 3600.0
+	vocGammaScaling           = 64.0
+	vocAdditionalGammaScaling = 64.0
+	vocFix16Max               = 32767.0
+)
+
+// VOCState is the saved state of the VOC index algorithm.
+type VOCState struct {
+	Mean float64 `json:"mean"`
+	Std  float64 `json:"std"`
+}
+
+type vocAlgorithm struct {
+	uptime float64
+	sraw   float64
+	index  float64
+
+	// mean and variance estimator
+	initialized               bool
+	mean, std, srawOffset     float64
+	gammaMean, gammaVariance  float64 // the tau based constants
+	gammaInitialMean          float64
+	gammaInitialVariance      float64
+	curGammaMean, curGammaVar float64
+	uptimeGamma, uptimeGating float64
+	gatingDuration            float64 // minutes
+
+	// MOX model
+	moxStd, moxMean float64
+
+	// adaptive lowpass
+	lpInitialized bool
+	lpA1, lpA2    float64
+	lpX1, lpX2    float64
+	lpX3          float64
+}
+
+func newVOCAlgorithm() *vocAlgorithm {
+	a := &vocAlgorithm{
+		std:                  vocSrawStdInitial,
+		gammaMean:            vocAdditionalGammaScaling * vocGammaScaling * (vocSamplingInterval / 3600) / (vocTauMeanHours + vocSamplingInterval/3600),
+		gammaVariance:        vocGammaScaling * (vocSamplingInterval / 3600) / (vocTauVarianceHours + vocSamplingInterval/3600),
+		gammaInitialMean:     vocAdditionalGammaScaling * vocGammaScaling * vocSamplingInterval / (vocTauInitialMean + vocSamplingInterval),
+		gammaInitialVariance: vocGammaScaling * vocSamplingInterval / (vocTauInitialVariance + vocSamplingInterval),
+		lpA1:                 vocSamplingInterval / (vocLPTauFast + vocSamplingInterval),
+		lpA2:                 vocSamplingInterval / (vocLPTauSlow + vocSamplingInterval),
+	}
+	a.moxStd, a.moxMean = a.std, a.estimatedMean()
+	return a
+}
+
+func (a *vocAlgorithm) estimatedMean() float64 {
+	return a.mean + a.srawOffset
+}
+
+func (a *vocAlgorithm) state() VOCState {
+	return VOCState{Mean: a.estimatedMean(), Std: a.std}
+}
+
+// learned returns whether the state is past the initial learning, as a
+// restored state is assumed to be.
+func (a *vocAlgorithm) learned() bool {
+	return a.uptimeGamma >= vocPersistenceUptimeGamma
+}
+
+// setState restores a saved state, skipping the initial learning.
+func (a *vocAlgorithm) setState(s VOCState) {
+	a.mean, a.srawOffset, a.std = s.Mean, 0, s.Std
+	a.uptimeGamma = vocPersistenceUptimeGamma
+	a.initialized = true
+	a.moxStd, a.moxMean = a.std, a.estimatedMean()
+	a.sraw = s.Mean
+}
+
+// process takes a raw signal sample and returns the VOC index, which is
+// zero during the initial blackout.
+func (a *vocAlgorithm) process(sraw int) int {
+	if a.uptime <= vocInitialBlackout {
+		a.uptime += vocSamplingInterval
+		return int(a.index + 0.5)
+	}
+
+	if sraw > 0 && sraw < 65000 {
+		if sraw < vocSrawMinimum+1 {
+			sraw = vocSrawMinimum + 1
+		} else if sraw > vocSrawMinimum+32767 {
+			sraw = vocSrawMinimum + 32767
+		}
+		a.sraw = float64(sraw - vocSrawMinimum)
+	}
+	a.index = a.scaledSigmoid(a.moxModel(a.sraw))
+	a.index = a.lowpass(a.index)
+	if a.index < 0.5 {
+		a.index = 0.5
+	}
+	if a.sraw > 0 {
+		a.estimate(a.sraw)
+		a.moxStd, a.moxMean = a.std, a.estimatedMean()
+	}
+	return int(a.index + 0.5)
+}
+
+func sigmoid(x0, k, sample float64) float64 {
+	x := k * (sample - x0)
+	switch {
+	case x < -50:
+		return 1
+	case x > 50:
+		return 0
+	default:
+		return 1 / (1 + math.Exp(x))
+	}
+}
+
+func (a *vocAlgorithm) calculateGamma() {
+	uptimeLimit := vocFix16Max - vocSamplingInterval
+	if a.uptimeGamma < uptimeLimit {
+		a.uptimeGamma += vocSamplingInterval
+	}
+	if a.uptimeGating < uptimeLimit {
+		a.uptimeGating += vocSamplingInterval
+	}
+
+	sigmoidGammaMean := sigmoid(vocInitDurationMean, vocInitTransitionMean, a.uptimeGamma)
+	gammaMean := a.gammaMean + (a.gammaInitialMean-a.gammaMean)*sigmoidGammaMean
+	gatingThreshold := vocGatingThreshold + (vocGatingThresholdInitial-vocGatingThreshold)*sigmoid(vocInitDurationMean, vocInitTransitionMean, a.uptimeGating)
+	sigmoidGatingMean := sigmoid(gatingThreshold, vocGatingThresholdTrans, a.index)
+	a.curGammaMean = sigmoidGatingMean * gammaMean
+
+	sigmoidGammaVariance := sigmoid(vocInitDurationVariance, vocInitTransitionVariance, a.uptimeGamma)
+	gammaVariance := a.gammaVariance + (a.gammaInitialVariance-a.gammaVariance)*(sigmoidGammaVariance-sigmoidGammaMean)
+	gatingThreshold = vocGatingThreshold + (vocGatingThresholdInitial-vocGatingThreshold)*sigmoid(vocInitDurationVariance, vocInitTransitionVariance, a.uptimeGating)
+	sigmoidGatingVariance := sigmoid(gatingThreshold, vocGatingThresholdTrans, a.index)
+	a.curGammaVar = sigmoidGatingVariance * gammaVariance
+
+	a.gatingDuration += vocSamplingInterval / 60 * ((1-sigmoidGatingMean)*(1+vocGatingMaxRatio) - vocGatingMaxRatio)
+	if a.gatingDuration < 0 {
+		a.gatingDuration = 0
+	}
+	if a.gatingDuration > vocGatingMaxDuration {
+		a.uptimeGating = 0
+	}
+}
+
+// estimate updates the mean and variance estimate with the sample.
+func (a *vocAlgorithm) estimate(sraw float64) {
+	if !a.initialized {
+		a.initialized = true
+		a.srawOffset = sraw
+		a.mean = 0
+		return
+	}
+
+	if a.mean >= 100 || a.mean <= -100 {
+		a.srawOffset += a.mean
+		a.mean = 0
+	}
+	sraw -= a.srawOffset
+	a.calculateGamma()
+	delta := (sraw - a.mean) / vocGammaScaling
+	c := a.std + math.Abs(delta)
+	scaling := 1.0
+	if c > 1440 {
+		scaling = (c / 1440) * (c / 1440)
+	}
+	a.std = math.Sqrt(scaling*(vocGammaScaling-a.curGammaVar)) *
+		math.Sqrt(a.std*(a.std/(vocGammaScaling*scaling))+a.curGammaVar*delta/scaling*delta)
+	a.mean += a.curGammaMean * delta / vocAdditionalGammaScaling
+}
+
+func (a *vocAlgorithm) moxModel(sraw float64) float64 {
+	return (sraw - a.moxMean) / -(a.moxStd + vocSrawStdBonus) * vocIndexGain
+}
+
+func (a *vocAlgorithm) scaledSigmoid(sample float64) float64 {
+	x := vocSigmoidK * (sample - vocSigmoidX0)
+	switch {
+	case x < -50:
+		return vocSigmoidL
+	case x > 50:
+		return 0
+	case sample >= 0:
+		shift := (vocSigmoidL - 5*vocIndexOffset) / 4
+		return (vocSigmoidL+shift)/(1+math.Exp(x)) - shift
+	default:
+		return vocSigmoidL / (1 + math.Exp(x))
+	}
+}
+
+func (a *vocAlgorithm) lowpass(sample float64) float64 {
+	if !a.lpInitialized {
+		a.lpX1, a.lpX2, a.lpX3 = sample, sample, sample
+		a.lpInitialized = true
+	}
+	a.lpX1 = (1-a.lpA1)*a.lpX1 + a.lpA1*sample
+	a.lpX2 = (1-a.lpA2)*a.lpX2 + a.lpA2*sample
+	f1 := math.Exp(vocLPAlpha * math.Abs(a.lpX1-a.lpX2))
+	tau := (vocLPTauSlow-vocLPTauFast)*f1 + vocLPTauFast
+	a3 := vocSamplingInterval / (vocSamplingInterval + tau)
+	a.lpX3 = (1-a3)*a.lpX3 + a3*sample
+	return a.lpX3
+}
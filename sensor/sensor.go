@@ -0,0 +1,69 @@
+// Package sensor defines the interface shared by the sensor drivers, so
+// that sensors can be handled generically.
+package sensor
+
+import "time"
+
+type Sensor interface {
+	// Refresh reads the sensor, unless it was read within maxAge.
+	Refresh(maxAge time.Duration) error
+	// Readings returns the values from the latest refresh.
+	Readings() []Reading
+}
+
+// A Reading is one value from a sensor. The name and unit are lower case
+// with underscores, suitable for metric names, e.g. "temperature" and
+// "celsius". Raw values without a unit have an empty unit.
+//
+// The quantity is what the value is a measure of, one of the Quantity
+// constants, so that clients need not guess it from the name or the unit:
+// a percentage may be a humidity or a state of charge. The precision is
+// the number of decimals worth showing, from the resolution and accuracy
+// of the sensor.
+type Reading struct {
+	Name      string
+	Unit      string
+	Quantity  string
+	Precision int
+	Value     float64
+}
+
+// Quantities of readings.
+const (
+	Temperature     = "temperature"
+	Humidity        = "humidity" // relative
+	Pressure        = "pressure"
+	Voltage         = "voltage"
+	Current         = "current"
+	Power           = "power"
+	Concentration   = "concentration" // of a gas, such as CO2
+	AirQuality      = "air_quality"   // an index
+	Acceleration    = "acceleration"
+	MagneticField   = "magnetic_field"
+	AngularVelocity = "angular_velocity" // rate of turn
+	Angle           = "angle"            // such as a heading or heel
+	Illuminance     = "illuminance"
+	Length          = "length" // a distance, depth or level
+	State           = "state"  // on or off, as 1 or 0
+	Raw             = "raw"    // an uncalibrated signal
+)
+
+// A Point is a raw three axis reading, such as an acceleration or a
+// magnetic field, in counts.
+type Point struct {
+	X, Y, Z int16
+}
+
+// A Describer is a sensor that can tell what hardware it is.
+type Describer interface {
+	Info() Info
+}
+
+// Info describes the hardware behind a sensor, for clients that show where
+// the data comes from.
+type Info struct {
+	Chip    string // e.g. "LPS25H"
+	Bus     string // "i2c", "1-wire"
+	Address string // on the bus, e.g. "0x5c"
+	ID      string // identification register, serial number or firmware version, if any
+}
@@ -0,0 +1,77 @@
+package sensor_test
+
+import (
+	"github.com/calmh/boatpi/adc"
+	"github.com/calmh/boatpi/ams"
+	"github.com/calmh/boatpi/bmp"
+	"github.com/calmh/boatpi/bno"
+	"github.com/calmh/boatpi/expander"
+	"github.com/calmh/boatpi/ina"
+	"github.com/calmh/boatpi/invensense"
+	"github.com/calmh/boatpi/light"
+	"github.com/calmh/boatpi/maxbotix"
+	"github.com/calmh/boatpi/ms5"
+	"github.com/calmh/boatpi/omini"
+	"github.com/calmh/boatpi/onewire"
+	"github.com/calmh/boatpi/pwm"
+	"github.com/calmh/boatpi/sensehat"
+	"github.com/calmh/boatpi/sensirion"
+	"github.com/calmh/boatpi/sensor"
+	"github.com/calmh/boatpi/tmp"
+)
+
+var (
+	_ sensor.Sensor = (*sensehat.HTS221)(nil)
+	_ sensor.Sensor = (*sensehat.LPS25H)(nil)
+	_ sensor.Sensor = (*sensehat.LSM9DS1)(nil)
+	_ sensor.Sensor = (*omini.Omini)(nil)
+	_ sensor.Sensor = (*onewire.DS18B20)(nil)
+	_ sensor.Sensor = (*ina.INA219)(nil)
+	_ sensor.Sensor = (*ina.INA3221)(nil)
+	_ sensor.Sensor = (*adc.ADS1115)(nil)
+	_ sensor.Sensor = (*adc.MCP3008)(nil)
+	_ sensor.Sensor = (*ms5.MS5611)(nil)
+	_ sensor.Sensor = (*bmp.BMP388)(nil)
+	_ sensor.Sensor = (*ms5.MS5837)(nil)
+	_ sensor.Sensor = (*sensirion.SCD30)(nil)
+	_ sensor.Sensor = (*sensirion.SGP30)(nil)
+	_ sensor.Sensor = (*sensirion.SGP40)(nil)
+	_ sensor.Sensor = (*light.BH1750)(nil)
+	_ sensor.Sensor = (*light.VEML7700)(nil)
+	_ sensor.Sensor = (*tmp.TMP117)(nil)
+	_ sensor.Sensor = (*invensense.MPU6050)(nil)
+	_ sensor.Sensor = (*invensense.ICM20948)(nil)
+	_ sensor.Sensor = (*bno.BNO055)(nil)
+	_ sensor.Sensor = (*ams.AS5600)(nil)
+	_ sensor.Sensor = (*maxbotix.MaxSonar)(nil)
+	_ sensor.Sensor = (*expander.MCP23017)(nil)
+	_ sensor.Sensor = (*expander.PCF8574)(nil)
+	_ sensor.Sensor = (*pwm.PCA9685)(nil)
+
+	_ sensor.Describer = (*sensehat.HTS221)(nil)
+	_ sensor.Describer = (*sensehat.LPS25H)(nil)
+	_ sensor.Describer = (*sensehat.LSM9DS1)(nil)
+	_ sensor.Describer = (*omini.Omini)(nil)
+	_ sensor.Describer = (*onewire.DS18B20)(nil)
+	_ sensor.Describer = (*ina.INA219)(nil)
+	_ sensor.Describer = (*ina.INA3221)(nil)
+	_ sensor.Describer = (*adc.ADS1115)(nil)
+	_ sensor.Describer = (*adc.MCP3008)(nil)
+	_ sensor.Describer = (*ms5.MS5611)(nil)
+	_ sensor.Describer = (*bmp.BMP388)(nil)
+	_ sensor.Describer = (*ms5.MS5837)(nil)
+	_ sensor.Describer = (*sensirion.SCD30)(nil)
+	_ sensor.Describer = (*sensirion.SGP30)(nil)
+	_ sensor.Describer = (*sensirion.SGP40)(nil)
+	_ sensor.Describer = (*light.BH1750)(nil)
+	_ sensor.Describer = (*light.VEML7700)(nil)
+	_ sensor.Describer = (*tmp.TMP117)(nil)
+	_ sensor.Describer = (*invensense.MPU6050)(nil)
+	_ sensor.Describer = (*invensense.ICM20948)(nil)
+	_ sensor.Describer = (*bno.BNO055)(nil)
+	_ sensor.Describer = (*ams.AS5600)(nil)
+	_ sensor.Describer = (*maxbotix.MaxSonar)(nil)
+	_ sensor.Describer = (*expander.MCP23017)(nil)
+	_ sensor.Describer = (*expander.PCF8574)(nil)
+	_ sensor.Describer = (*pwm.PCA9685)(nil)
+)
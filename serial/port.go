@@ -0,0 +1,279 @@
+// Package serial manages serial ports shared by several consumers, such
+// as an NMEA receiver on a USB adapter read by both the GPS input and a
+// logger. Ports are kept open and reopened when lost, so that unplugging
+// and replugging an adapter only interrupts the data. Stable names such
+// as /dev/serial/by-id/usb-FTDI_..., which follow the adapter rather
+// than the order of plugging, are the best way to name a port; they are
+// resolved anew on every open.
+package serial
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReconnectDelay is the time between attempts to reopen a lost port.
+const ReconnectDelay = 5 * time.Second
+
+// subscriberBuffer is the number of lines buffered per subscriber; a
+// subscriber that falls further behind misses lines.
+const subscriberBuffer = 64
+
+// ErrNotConnected is returned when writing to a port that is not open.
+var ErrNotConnected = errors.New("not connected")
+
+// Status describes a port.
+type Status struct {
+	Path       string // as given, such as a /dev/serial/by-id name
+	Device     string // the device it resolved to when last opened
+	Baud       int
+	Connected  bool
+	Reconnects uint64 // times reopened after being lost
+	Lines      uint64 // received
+	Dropped    uint64 // lines not delivered to a subscriber that fell behind
+	Error      error  // the last error, if any
+}
+
+// A Port is a shared serial port that delivers what it receives as lines,
+// as most boat protocols (NMEA 0183, VE.Direct, modem AT commands) are
+// line oriented.
+type Port struct {
+	open   func() (io.ReadWriteCloser, error)
+	cancel context.CancelFunc
+
+	mut    sync.Mutex
+	refs   int
+	rwc    io.ReadWriteCloser // nil when not connected
+	subs   map[chan string]struct{}
+	status Status
+}
+
+// The open ports, by path.
+var (
+	portsMut sync.Mutex
+	ports    = make(map[string]*Port)
+)
+
+// Open returns the port at the path, opening it at the baud rate (8N1) if
+// it is not already open. Each Open must be matched by a Close. An error
+// is returned if the port cannot be opened initially, or is already open
+// at another baud rate.
+func Open(path string, baud int) (*Port, error) {
+	return openPort(path, baud, func() (io.ReadWriteCloser, error) {
+		return openTTY(path, baud)
+	})
+}
+
+func openPort(path string, baud int, open func() (io.ReadWriteCloser, error)) (*Port, error) {
+	portsMut.Lock()
+	defer portsMut.Unlock()
+
+	if p, ok := ports[path]; ok {
+		p.mut.Lock()
+		defer p.mut.Unlock()
+		if p.status.Baud != baud {
+			return nil, fmt.Errorf("%s: already open at %d baud", path, p.status.Baud)
+		}
+		p.refs++
+		return p, nil
+	}
+
+	rwc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Port{
+		open:   open,
+		cancel: cancel,
+		refs:   1,
+		rwc:    rwc,
+		subs:   make(map[chan string]struct{}),
+		status: Status{Path: path, Device: resolve(path), Baud: baud, Connected: true},
+	}
+	ports[path] = p
+	go p.serve(ctx, rwc)
+	return p, nil
+}
+
+// resolve returns the device the path currently points to.
+func resolve(path string) string {
+	if dev, err := filepath.EvalSymlinks(path); err == nil {
+		return dev
+	}
+	return path
+}
+
+// Close releases the port; it is closed when the last user releases it.
+func (p *Port) Close() {
+	portsMut.Lock()
+	defer portsMut.Unlock()
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.refs--
+	if p.refs > 0 {
+		return
+	}
+	delete(ports, p.status.Path)
+	p.cancel()
+	if p.rwc != nil {
+		p.rwc.Close()
+	}
+	for c := range p.subs {
+		close(c)
+	}
+	p.subs = nil
+}
+
+// Subscribe returns a channel receiving the lines read from the port,
+// without line endings, until the context is done or the port is closed.
+func (p *Port) Subscribe(ctx context.Context) <-chan string {
+	c := make(chan string, subscriberBuffer)
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.subs == nil {
+		close(c)
+		return c
+	}
+	p.subs[c] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		p.mut.Lock()
+		defer p.mut.Unlock()
+		if _, ok := p.subs[c]; ok {
+			delete(p.subs, c)
+			close(c)
+		}
+	}()
+	return c
+}
+
+// Write writes to the port, if it is open.
+func (p *Port) Write(data []byte) (int, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.rwc == nil {
+		return 0, ErrNotConnected
+	}
+	return p.rwc.Write(data)
+}
+
+func (p *Port) Status() Status {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	return p.status
+}
+
+// Ports returns the status of the open ports, ordered by path.
+func Ports() []Status {
+	portsMut.Lock()
+	list := make([]*Port, 0, len(ports))
+	for _, p := range ports {
+		list = append(list, p)
+	}
+	portsMut.Unlock()
+
+	res := make([]Status, len(list))
+	for i, p := range list {
+		res[i] = p.Status()
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Path < res[b].Path })
+	return res
+}
+
+func (p *Port) serve(ctx context.Context, rwc io.ReadWriteCloser) {
+	for {
+		err := p.read(rwc)
+		p.mut.Lock()
+		if p.rwc != nil {
+			p.rwc.Close()
+			p.rwc = nil
+		}
+		p.status.Connected = false
+		if ctx.Err() == nil {
+			p.status.Error = err
+		}
+		p.mut.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Serial port %s: %v", p.status.Path, err)
+
+		for {
+			select {
+			case <-time.After(ReconnectDelay):
+			case <-ctx.Done():
+				return
+			}
+			var err error
+			rwc, err = p.open()
+			p.mut.Lock()
+			if err == nil && ctx.Err() != nil {
+				// Closed while opening.
+				rwc.Close()
+				p.mut.Unlock()
+				return
+			}
+			if err == nil {
+				p.rwc = rwc
+				p.status.Device = resolve(p.status.Path)
+				p.status.Connected = true
+				p.status.Reconnects++
+				p.status.Error = nil
+				p.mut.Unlock()
+				log.Printf("Serial port %s: reconnected (%s)", p.status.Path, p.status.Device)
+				break
+			}
+			p.status.Error = err
+			p.mut.Unlock()
+		}
+	}
+}
+
+func (p *Port) read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Split(scanLines)
+	for sc.Scan() {
+		line := sc.Text()
+		p.mut.Lock()
+		p.status.Lines++
+		for c := range p.subs {
+			select {
+			case c <- line:
+			default:
+				p.status.Dropped++
+			}
+		}
+		p.mut.Unlock()
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// scanLines is a bufio.SplitFunc for lines ending in CR, LF or both, as
+// some devices (MaxBotix rangefinders, for one) end them in CR only.
+// Empty lines are skipped.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start := 0
+	for start < len(data) && (data[start] == '\r' || data[start] == '\n') {
+		start++
+	}
+	if i := bytes.IndexAny(data[start:], "\r\n"); i >= 0 {
+		return start + i + 1, data[start : start+i], nil
+	}
+	if atEOF && start < len(data) {
+		return len(data), data[start:], nil
+	}
+	return start, nil, nil
+}
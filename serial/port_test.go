@@ -0,0 +1,125 @@
+package serial
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipePort is a fake serial device; data written to remote is read from
+// the port.
+type pipePort struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+func newPipePort() (io.ReadWriteCloser, *io.PipeWriter) {
+	r, w := io.Pipe()
+	return pipePort{Reader: r, Writer: ioutil.Discard, Closer: r}, w
+}
+
+func TestSharedPort(t *testing.T) {
+	rwc, remote := newPipePort()
+	opens := 0
+	open := func() (io.ReadWriteCloser, error) {
+		opens++
+		return rwc, nil
+	}
+
+	p1, err := openPort("/dev/test0", 4800, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := openPort("/dev/test0", 4800, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1 != p2 || opens != 1 {
+		t.Fatalf("expected the port shared, got %d opens", opens)
+	}
+	if _, err := openPort("/dev/test0", 38400, open); err == nil {
+		t.Error("expected error for a different baud rate")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c1 := p1.Subscribe(ctx)
+	c2 := p2.Subscribe(ctx)
+	go io.WriteString(remote, "$GPGLL,5741.6000,N,01158.0000,E,120000,A*2C\r\n")
+	for _, c := range []<-chan string{c1, c2} {
+		select {
+		case line := <-c:
+			if line != "$GPGLL,5741.6000,N,01158.0000,E,120000,A*2C" {
+				t.Errorf("unexpected line %q", line)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	p1.Close()
+	if len(Ports()) != 1 {
+		t.Error("expected the port open while still in use")
+	}
+	p2.Close()
+	if len(Ports()) != 0 {
+		t.Error("expected the port closed")
+	}
+	if _, ok := <-c1; ok {
+		t.Error("expected the subscription closed with the port")
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	rwc, remote := newPipePort()
+	p, err := openPort("/dev/test1", 4800, func() (io.ReadWriteCloser, error) { return rwc, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.Subscribe(ctx) // never read
+	for i := 0; i < subscriberBuffer+10; i++ {
+		io.WriteString(remote, "line\n")
+	}
+	// The write returns once the port has read the line, but it may not yet
+	// have been counted.
+	deadline := time.Now().Add(time.Second)
+	for p.Status().Dropped != 10 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if st := p.Status(); st.Lines != subscriberBuffer+10 || st.Dropped != 10 {
+		t.Errorf("expected %d lines and 10 dropped, got %d and %d", subscriberBuffer+10, st.Lines, st.Dropped)
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	_, err := openPort("/dev/test2", 4800, func() (io.ReadWriteCloser, error) { return nil, errors.New("no such device") })
+	if err == nil {
+		t.Error("expected error")
+	}
+	if len(Ports()) != 0 {
+		t.Error("expected no port registered")
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	sc := bufio.NewScanner(strings.NewReader("R0452\rR0451\r$GPGLL\r\n\nlast"))
+	sc.Split(scanLines)
+	var lines []string
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	expected := []string{"R0452", "R0451", "$GPGLL", "last"}
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Errorf("lines %q, expected %q", lines, expected)
+	}
+}
@@ -0,0 +1,44 @@
+package serial
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var baudRates = map[int]uint32{
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+}
+
+// openTTY opens the given tty in raw 8N1 mode at the given baud rate.
+func openTTY(device string, baud int) (*os.File, error) {
+	rate, ok := baudRates[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %d", baud)
+	}
+
+	fd, err := os.OpenFile(device, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	t := syscall.Termios{
+		Iflag:  syscall.IGNPAR,
+		Cflag:  syscall.CS8 | syscall.CREAD | syscall.CLOCAL | rate,
+		Ispeed: rate,
+		Ospeed: rate,
+	}
+	t.Cc[syscall.VMIN] = 1
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		fd.Close()
+		return nil, fmt.Errorf("set terminal attributes: %w", errno)
+	}
+
+	return fd, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package serial
+
+import (
+	"os"
+)
+
+// openTTY opens the given device as is; the line settings must be
+// configured outside of this program.
+func openTTY(device string, baud int) (*os.File, error) {
+	return os.OpenFile(device, os.O_RDWR, 0)
+}
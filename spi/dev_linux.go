@@ -0,0 +1,101 @@
+package spi
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Linux spidev character device (/dev/spidevB.C).
+
+const (
+	ioctlSPIWrMode        = 0x40016b01
+	ioctlSPIWrBitsPerWord = 0x40016b03
+	ioctlSPIWrMaxSpeedHz  = 0x40046b04
+	ioctlSPIMessage1      = 0x40206b00 // SPI_IOC_MESSAGE(1)
+)
+
+// spiIocTransfer is struct spi_ioc_transfer.
+type spiIocTransfer struct {
+	txBuf       uint64
+	rxBuf       uint64
+	len         uint32
+	speedHz     uint32
+	delayUsecs  uint16
+	bitsPerWord uint8
+	csChange    uint8
+	txNbits     uint8
+	rxNbits     uint8
+	wordDelay   uint8
+	pad         uint8
+}
+
+type LinuxDevice struct {
+	mut   sync.Mutex
+	fd    *os.File
+	speed uint32
+}
+
+// Open opens the spidev device with the clock mode and the clock speed in
+// Hz, with eight bit words.
+func Open(path string, mode uint8, speedHz uint32) (*LinuxDevice, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := &LinuxDevice{fd: fd, speed: speedHz}
+	bits := uint8(8)
+	for _, set := range []struct {
+		req uintptr
+		arg unsafe.Pointer
+	}{
+		{ioctlSPIWrMode, unsafe.Pointer(&mode)},
+		{ioctlSPIWrBitsPerWord, unsafe.Pointer(&bits)},
+		{ioctlSPIWrMaxSpeedHz, unsafe.Pointer(&speedHz)},
+	} {
+		if err := d.ioctl(set.req, uintptr(set.arg)); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+	runtime.KeepAlive(&mode)
+	runtime.KeepAlive(&bits)
+	runtime.KeepAlive(&speedHz)
+	return d, nil
+}
+
+func (d *LinuxDevice) Close() error {
+	return d.fd.Close()
+}
+
+func (d *LinuxDevice) Transfer(tx, rx []byte) error {
+	if len(tx) != len(rx) {
+		return fmt.Errorf("transfer: %d bytes to send but %d to receive", len(tx), len(rx))
+	}
+	if len(tx) == 0 {
+		return nil
+	}
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	tr := spiIocTransfer{
+		txBuf:       uint64(uintptr(unsafe.Pointer(&tx[0]))),
+		rxBuf:       uint64(uintptr(unsafe.Pointer(&rx[0]))),
+		len:         uint32(len(tx)),
+		speedHz:     d.speed,
+		bitsPerWord: 8,
+	}
+	err := d.ioctl(ioctlSPIMessage1, uintptr(unsafe.Pointer(&tr)))
+	runtime.KeepAlive(tx)
+	runtime.KeepAlive(rx)
+	return err
+}
+
+func (d *LinuxDevice) ioctl(req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.fd.Fd(), req, arg); errno != 0 {
+		return fmt.Errorf("ioctl 0x%08x: %w", req, errno)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package spi
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestTransferSize(t *testing.T) {
+	// Must match struct spi_ioc_transfer, as encoded in the ioctl number.
+	if s := unsafe.Sizeof(spiIocTransfer{}); s != ioctlSPIMessage1>>16&0x3fff {
+		t.Errorf("transfer struct is %d bytes", s)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package spi
+
+import "errors"
+
+type LinuxDevice struct {
+	Device
+}
+
+func Open(path string, mode uint8, speedHz uint32) (*LinuxDevice, error) {
+	return nil, errors.New("SPI devices are only supported on Linux")
+}
+
+func (d *LinuxDevice) Close() error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package spi
+
+import "sync"
+
+// A Mock is a Device for tests. It records the transfers and answers each
+// with the result of Reply, if set, or zeros.
+type Mock struct {
+	Reply func(tx []byte) []byte
+
+	mut       sync.Mutex
+	transfers [][]byte
+}
+
+func (m *Mock) Transfer(tx, rx []byte) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	m.transfers = append(m.transfers, append([]byte(nil), tx...))
+	for i := range rx {
+		rx[i] = 0
+	}
+	if m.Reply != nil {
+		copy(rx, m.Reply(tx))
+	}
+	return nil
+}
+
+// Transfers returns the bytes sent in each transfer so far.
+func (m *Mock) Transfers() [][]byte {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return append([][]byte(nil), m.transfers...)
+}
@@ -0,0 +1,29 @@
+// Package spi talks to devices on an SPI bus, typically through the Linux
+// spidev driver (/dev/spidevB.C, one device per chip select).
+package spi
+
+import "fmt"
+
+// A Device does full duplex transfers: the bytes in tx are clocked out
+// while as many are clocked into rx, with the chip selected for the whole
+// transfer. A *LinuxDevice is one.
+type Device interface {
+	Transfer(tx, rx []byte) error
+}
+
+// Transfer sends tx and returns the bytes received meanwhile.
+func Transfer(dev Device, tx []byte) ([]byte, error) {
+	rx := make([]byte, len(tx))
+	if err := dev.Transfer(tx, rx); err != nil {
+		return nil, fmt.Errorf("spi transfer: %w", err)
+	}
+	return rx, nil
+}
+
+// Clock modes: the clock polarity and phase.
+const (
+	Mode0 = 0 // clock idle low, sample on the rising edge
+	Mode1 = 1
+	Mode2 = 2
+	Mode3 = 3 // clock idle high, sample on the rising edge
+)
@@ -0,0 +1,20 @@
+package spi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMockTransfer(t *testing.T) {
+	m := &Mock{Reply: func(tx []byte) []byte { return []byte{0xff, tx[0]} }}
+	rx, err := Transfer(m, []byte{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rx, []byte{0xff, 1}) {
+		t.Errorf("unexpected reply %x", rx)
+	}
+	if tr := m.Transfers(); len(tr) != 1 || !bytes.Equal(tr[0], []byte{1, 2}) {
+		t.Errorf("unexpected transfers %x", tr)
+	}
+}
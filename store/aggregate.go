@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// An Aggregation combines the samples in a step into one value.
+type Aggregation func([]float64) float64
+
+var Aggregations = map[string]Aggregation{
+	"avg": func(vs []float64) float64 {
+		sum := 0.0
+		for _, v := range vs {
+			sum += v
+		}
+		return sum / float64(len(vs))
+	},
+	"min": func(vs []float64) float64 {
+		min := math.Inf(1)
+		for _, v := range vs {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	"max": func(vs []float64) float64 {
+		max := math.Inf(-1)
+		for _, v := range vs {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	// The mean of angles in degrees, which behaves across the 360/0
+	// wrap. The result is positive if all the angles are.
+	"angle": func(vs []float64) float64 {
+		var sin, cos float64
+		pos := true
+		for _, v := range vs {
+			rad := v / 180 * math.Pi
+			sin += math.Sin(rad)
+			cos += math.Cos(rad)
+			pos = pos && v >= 0
+		}
+		res := math.Atan2(sin, cos) / math.Pi * 180
+		if pos && res < 0 {
+			res += 360
+		}
+		return res
+	},
+}
+
+// Downsample aggregates the samples, which must be in time order, into
+// steps starting at from. Each result has the time of the start of its
+// step. Steps without samples are left out.
+func Downsample(samples []Sample, from time.Time, step time.Duration, agg Aggregation) ([]Sample, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive")
+	}
+	var res []Sample
+	var vals []float64
+	cur := -1
+	for _, s := range samples {
+		if s.Time.Before(from) {
+			continue
+		}
+		i := int(s.Time.Sub(from) / step)
+		if i != cur && len(vals) > 0 {
+			res = append(res, Sample{from.Add(time.Duration(cur) * step), agg(vals)})
+			vals = vals[:0]
+		}
+		cur = i
+		vals = append(vals, s.Value)
+	}
+	if len(vals) > 0 {
+		res = append(res, Sample{from.Add(time.Duration(cur) * step), agg(vals)})
+	}
+	return res, nil
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDownsample(t *testing.T) {
+	t0 := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	var samples []Sample
+	for i, v := range []float64{1, 3, 2, 8, 4, 6} {
+		samples = append(samples, Sample{t0.Add(time.Duration(i) * 20 * time.Second), v})
+	}
+	// A gap of a whole step, then one more sample.
+	samples = append(samples, Sample{t0.Add(3 * time.Minute), 5})
+
+	for agg, exp := range map[string][]float64{
+		"avg": {2, 6, 5},
+		"min": {1, 4, 5},
+		"max": {3, 8, 5},
+	} {
+		res, err := Downsample(samples, t0, time.Minute, Aggregations[agg])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != len(exp) {
+			t.Fatalf("%s: unexpected result %v", agg, res)
+		}
+		for i, v := range exp {
+			if res[i].Value != v {
+				t.Errorf("%s: step %d: got %v, expected %v", agg, i, res[i].Value, v)
+			}
+		}
+		if !res[2].Time.Equal(t0.Add(3 * time.Minute)) {
+			t.Errorf("%s: unexpected time of last step %v", agg, res[2].Time)
+		}
+	}
+
+	if _, err := Downsample(samples, t0, 0, Aggregations["avg"]); err == nil {
+		t.Error("expected error for zero step")
+	}
+}
+
+func TestAngleAggregation(t *testing.T) {
+	angle := Aggregations["angle"]
+	if v := angle([]float64{350, 10}); v > 1e-9 && v < 360-1e-9 {
+		t.Errorf("mean of 350 and 10 is %v, expected north", v)
+	}
+	if v := angle([]float64{80, 100}); v < 90-1e-9 || v > 90+1e-9 {
+		t.Errorf("mean of 80 and 100 is %v, expected 90", v)
+	}
+	if v := angle([]float64{-20, -10}); v < -15-1e-9 || v > -15+1e-9 {
+		t.Errorf("mean of -20 and -10 is %v, expected -15", v)
+	}
+}
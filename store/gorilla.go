@@ -0,0 +1,227 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Series are compressed as in Facebook's Gorilla paper: timestamps as the
+// difference between consecutive deltas, and values XORed with the
+// previous one, both encoded with as few bits as possible. Sensor values
+// change slowly and are sampled at a fixed interval, so most samples take
+// a couple of bits for the time and a dozen or so for the value.
+// Timestamps are kept to the millisecond.
+
+var errCorrupt = errors.New("corrupt compressed series")
+
+type bitWriter struct {
+	buf  []byte
+	free uint8 // unused bits in the last byte
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.free
+	}
+}
+
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(v&(1<<uint(i)) != 0)
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos int // in bits
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf)*8 {
+		return false, errCorrupt
+	}
+	bit := r.buf[r.pos/8]&(0x80>>uint(r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+// The delta-of-delta buckets: a prefix of ones terminated by a zero (or
+// not, for the last bucket) and a two's complement value of the given
+// width. A zero delta-of-delta is a single zero bit.
+var dodBuckets = []struct {
+	prefix, prefixLen int
+	width             int
+}{
+	{0x2, 2, 7},
+	{0x6, 3, 9},
+	{0xe, 4, 12},
+	{0xf, 4, 64},
+}
+
+// compressSeries encodes the samples, which must be in time order.
+func compressSeries(samples []Sample) []byte {
+	var w bitWriter
+	if len(samples) == 0 {
+		return nil
+	}
+	t := samples[0].Time.UnixNano() / int64(time.Millisecond)
+	v := math.Float64bits(samples[0].Value)
+	w.writeBits(uint64(t), 64)
+	w.writeBits(v, 64)
+
+	var delta int64
+	leading, trailing := -1, 0
+	for _, s := range samples[1:] {
+		nt := s.Time.UnixNano() / int64(time.Millisecond)
+		nd := nt - t
+		dod := nd - delta
+		t, delta = nt, nd
+		if dod == 0 {
+			w.writeBit(false)
+		} else {
+			for _, b := range dodBuckets {
+				if b.width == 64 || (dod >= -(1<<uint(b.width-1)) && dod < 1<<uint(b.width-1)) {
+					w.writeBits(uint64(b.prefix), b.prefixLen)
+					w.writeBits(uint64(dod), b.width)
+					break
+				}
+			}
+		}
+
+		nv := math.Float64bits(s.Value)
+		xor := nv ^ v
+		v = nv
+		if xor == 0 {
+			w.writeBit(false)
+			continue
+		}
+		w.writeBit(true)
+		l, tr := bits.LeadingZeros64(xor), bits.TrailingZeros64(xor)
+		if l > 31 {
+			l = 31 // five bits
+		}
+		if leading >= 0 && l >= leading && tr >= trailing {
+			// Fits in the previous window.
+			w.writeBit(false)
+			w.writeBits(xor>>uint(trailing), 64-leading-trailing)
+			continue
+		}
+		leading, trailing = l, tr
+		w.writeBit(true)
+		w.writeBits(uint64(leading), 5)
+		// The length is 1 to 64; 64 is written as 0.
+		w.writeBits(uint64(64-leading-trailing)&0x3f, 6)
+		w.writeBits(xor>>uint(trailing), 64-leading-trailing)
+	}
+	return w.buf
+}
+
+// decompressSeries decodes n samples and calls fn for each.
+func decompressSeries(buf []byte, n int, fn func(t int64, v float64)) error {
+	if n == 0 {
+		return nil
+	}
+	r := bitReader{buf: buf}
+	ut, err := r.readBits(64)
+	if err != nil {
+		return err
+	}
+	v, err := r.readBits(64)
+	if err != nil {
+		return err
+	}
+	t := int64(ut)
+	fn(t*int64(time.Millisecond), math.Float64frombits(v))
+
+	var delta int64
+	leading, trailing := 0, 0
+	for i := 1; i < n; i++ {
+		// Up to four ones select the bucket; a zero first means no
+		// change in delta.
+		ones := 0
+		for ones < len(dodBuckets) {
+			bit, err := r.readBit()
+			if err != nil {
+				return err
+			}
+			if !bit {
+				break
+			}
+			ones++
+		}
+		var dod int64
+		if ones > 0 {
+			width := dodBuckets[ones-1].width
+			raw, err := r.readBits(width)
+			if err != nil {
+				return err
+			}
+			dod = signExtend(raw, width)
+		}
+		delta += dod
+		t += delta
+
+		bit, err := r.readBit()
+		if err != nil {
+			return err
+		}
+		if bit {
+			bit, err := r.readBit()
+			if err != nil {
+				return err
+			}
+			if bit {
+				l, err := r.readBits(5)
+				if err != nil {
+					return err
+				}
+				length, err := r.readBits(6)
+				if err != nil {
+					return err
+				}
+				if length == 0 {
+					length = 64
+				}
+				leading = int(l)
+				trailing = 64 - leading - int(length)
+				if trailing < 0 {
+					return errCorrupt
+				}
+			}
+			xor, err := r.readBits(64 - leading - trailing)
+			if err != nil {
+				return err
+			}
+			v ^= xor << uint(trailing)
+		}
+		fn(t*int64(time.Millisecond), math.Float64frombits(v))
+	}
+	return nil
+}
+
+func signExtend(v uint64, width int) int64 {
+	shift := uint(64 - width)
+	return int64(v<<shift) >> shift
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestCompressSeries(t *testing.T) {
+	t0 := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	rng := rand.New(rand.NewSource(42))
+	var samples []Sample
+	ts, v := t0, 1013.25
+	for i := 0; i < 1440; i++ {
+		// Jittery minute intervals, the odd long gap, and slowly
+		// varying values with repeats.
+		ts = ts.Add(time.Minute + time.Duration(rng.Intn(200)-100)*time.Millisecond)
+		if i%500 == 499 {
+			ts = ts.Add(3 * time.Hour)
+		}
+		if rng.Intn(3) == 0 {
+			v += rng.Float64() - 0.5
+		}
+		samples = append(samples, Sample{ts, v})
+	}
+	samples = append(samples, Sample{ts.Add(time.Second), math.Inf(-1)}, Sample{ts.Add(2 * time.Second), 0})
+
+	buf := compressSeries(samples)
+	if len(buf) > len(samples)*16/2 {
+		t.Errorf("poor compression: %d bytes for %d samples", len(buf), len(samples))
+	}
+
+	var res []Sample
+	err := decompressSeries(buf, len(samples), func(t int64, v float64) {
+		res = append(res, Sample{time.Unix(0, t), v})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(samples) {
+		t.Fatalf("got %d samples, expected %d", len(res), len(samples))
+	}
+	for i := range samples {
+		if !res[i].Time.Equal(samples[i].Time.Truncate(time.Millisecond)) || res[i].Value != samples[i].Value {
+			t.Fatalf("sample %d: got %v, expected %v", i, res[i], samples[i])
+		}
+	}
+
+	if err := decompressSeries(buf[:len(buf)/2], len(samples), func(int64, float64) {}); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
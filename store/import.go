@@ -0,0 +1,345 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History from elsewhere can be imported into a store, so that past
+// seasons aren't lost when switching to it. The supported formats are:
+//
+// prometheus: the JSON response of a Prometheus range query
+// (/api/v1/query_range, or /api/v1/query with a range selector).
+//
+// influx: InfluxDB line protocol, as written by influx_inspect export,
+// with nanosecond timestamps. Each field is a series named
+// measurement_field, or just measurement for fields named value, gauge or
+// counter (as written by Telegraf for Prometheus metrics).
+//
+// jsonl: one JSON object per line with the series, either as "series"
+// (e.g. sensors_omini_voltage{channel="a"}) or as "name" and "labels", a
+// "time" (RFC 3339 or Unix seconds) and a "value".
+
+// ImportFormats are the names of the supported import formats.
+var ImportFormats = []string{"prometheus", "influx", "jsonl"}
+
+// SeriesName returns the name of a series as stored, e.g.
+// sensors_omini_voltage{channel="a"}, with the labels sorted.
+func SeriesName(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	var parts []string
+	for k, v := range labels {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(parts)
+	return name + "{" + strings.Join(parts, ",") + "}"
+}
+
+// Import reads history in the format and appends it to the store. Series
+// whose names don't start with prefix, if set, are skipped, as are the
+// labels to drop, typically those added by the collecting system such as
+// instance and job. Samples are appended in time order, across series, so
+// the segment store moves through the days once. It's up to the caller not
+// to import the same range twice. Returns the number of samples imported.
+func Import(st Store, format string, r io.Reader, prefix string, dropLabels []string) (int, error) {
+	imp := &importer{
+		prefix: prefix,
+		drop:   make(map[string]bool),
+	}
+	for _, l := range dropLabels {
+		imp.drop[l] = true
+	}
+
+	var err error
+	switch format {
+	case "prometheus":
+		err = imp.prometheus(r)
+	case "influx":
+		err = imp.lines(r, imp.influxLine)
+	case "jsonl":
+		err = imp.lines(r, imp.jsonLine)
+	default:
+		err = fmt.Errorf("unknown import format %q (available: %s)", format, strings.Join(ImportFormats, ", "))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	sort.SliceStable(imp.samples, func(a, b int) bool { return imp.samples[a].Time.Before(imp.samples[b].Time) })
+	for i, s := range imp.samples {
+		if err := st.Append(s.series, s.Time, s.Value); err != nil {
+			return i, fmt.Errorf("%s: %w", s.series, err)
+		}
+	}
+	return len(imp.samples), nil
+}
+
+type importer struct {
+	samples []importSample
+	prefix  string
+	drop    map[string]bool
+}
+
+type importSample struct {
+	series string
+	Sample
+}
+
+func (imp *importer) add(name string, labels map[string]string, t time.Time, v float64) {
+	if !strings.HasPrefix(name, imp.prefix) {
+		return
+	}
+	for l := range labels {
+		if imp.drop[l] {
+			delete(labels, l)
+		}
+	}
+	imp.samples = append(imp.samples, importSample{SeriesName(name, labels), Sample{t, v}})
+}
+
+func (imp *importer) prometheus(r io.Reader) error {
+	var resp struct {
+		Status string
+		Error  string
+		Data   struct {
+			ResultType string
+			Result     []struct {
+				Metric map[string]string
+				Values [][2]interface{}
+			}
+		}
+	}
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Status != "success" {
+		return fmt.Errorf("query status %q: %s", resp.Status, resp.Error)
+	}
+	if resp.Data.ResultType != "matrix" {
+		return fmt.Errorf("result type %q, expected a matrix from a range query", resp.Data.ResultType)
+	}
+	for _, res := range resp.Data.Result {
+		name := res.Metric["__name__"]
+		delete(res.Metric, "__name__")
+		for _, tv := range res.Values {
+			ts, ok := tv[0].(float64)
+			vs, ok2 := tv[1].(string)
+			if !ok || !ok2 {
+				return fmt.Errorf("%s: malformed value %v", name, tv)
+			}
+			v, err := strconv.ParseFloat(vs, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			labels := make(map[string]string, len(res.Metric))
+			for k, l := range res.Metric {
+				labels[k] = l
+			}
+			imp.add(name, labels, unixSeconds(ts), v)
+		}
+	}
+	return nil
+}
+
+// lines calls fn for each non-empty line that isn't a comment.
+func (imp *importer) lines(r io.Reader, fn func(string) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return sc.Err()
+}
+
+func (imp *importer) jsonLine(line string) error {
+	var rec struct {
+		Series string
+		Name   string
+		Labels map[string]string
+		Time   json.RawMessage
+		Value  *float64
+	}
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		return err
+	}
+	if rec.Value == nil {
+		return errors.New("missing value")
+	}
+
+	var t time.Time
+	var secs float64
+	if err := json.Unmarshal(rec.Time, &secs); err == nil {
+		t = unixSeconds(secs)
+	} else if err := json.Unmarshal(rec.Time, &t); err != nil {
+		return fmt.Errorf("time: %s", rec.Time)
+	}
+
+	name, labels := rec.Name, rec.Labels
+	if rec.Series != "" {
+		var err error
+		if name, labels, err = parseSeriesName(rec.Series); err != nil {
+			return err
+		}
+	}
+	if name == "" {
+		return errors.New("missing series")
+	}
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	imp.add(name, labels, t, *rec.Value)
+	return nil
+}
+
+// influxLine parses a line of the line protocol:
+// measurement[,tag=value...] field=value[,field=value...] timestamp
+func (imp *importer) influxLine(line string) error {
+	parts := splitUnescaped(line, ' ')
+	if len(parts) != 3 {
+		return errors.New("expected measurement, fields and timestamp")
+	}
+	ns, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("timestamp: %w", err)
+	}
+	t := time.Unix(0, ns)
+
+	keys := splitUnescaped(parts[0], ',')
+	measurement := unescapeInflux(keys[0])
+	tags := make(map[string]string)
+	for _, kv := range keys[1:] {
+		kv := splitUnescaped(kv, '=')
+		if len(kv) != 2 {
+			return fmt.Errorf("malformed tag %q", kv)
+		}
+		tags[unescapeInflux(kv[0])] = unescapeInflux(kv[1])
+	}
+
+	for _, kv := range splitUnescaped(parts[1], ',') {
+		kv := splitUnescaped(kv, '=')
+		if len(kv) != 2 {
+			return fmt.Errorf("malformed field %q", kv)
+		}
+		field, val := unescapeInflux(kv[0]), kv[1]
+		var v float64
+		switch {
+		case val == "t" || val == "T" || val == "true" || val == "True" || val == "TRUE":
+			v = 1
+		case val == "f" || val == "F" || val == "false" || val == "False" || val == "FALSE":
+			v = 0
+		case strings.HasPrefix(val, `"`):
+			// Strings aren't samples.
+			continue
+		default:
+			var err error
+			v, err = strconv.ParseFloat(strings.TrimRight(val, "iu"), 64)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", field, err)
+			}
+		}
+		name := measurement
+		switch field {
+		case "value", "gauge", "counter":
+		default:
+			name += "_" + field
+		}
+		labels := make(map[string]string, len(tags))
+		for k, l := range tags {
+			labels[k] = l
+		}
+		imp.add(name, labels, t, v)
+	}
+	return nil
+}
+
+// splitUnescaped splits s at each sep that isn't escaped with a backslash
+// or inside double quotes.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseSeriesName parses a series name as returned by SeriesName.
+func parseSeriesName(s string) (string, map[string]string, error) {
+	labels := make(map[string]string)
+	i := strings.IndexByte(s, '{')
+	if i < 0 {
+		return s, labels, nil
+	}
+	name, rest := s[:i], s[i+1:]
+	for {
+		rest = strings.TrimLeft(rest, ", ")
+		if strings.HasPrefix(rest, "}") {
+			return name, labels, nil
+		}
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return "", nil, fmt.Errorf("malformed series %q", s)
+		}
+		key := strings.TrimSpace(rest[:eq])
+		rest = strings.TrimSpace(rest[eq+1:])
+		end := 1
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if !strings.HasPrefix(rest, `"`) || end >= len(rest) {
+			return "", nil, fmt.Errorf("malformed series %q", s)
+		}
+		val, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return "", nil, fmt.Errorf("malformed series %q", s)
+		}
+		labels[key] = val
+		rest = rest[end+1:]
+	}
+}
+
+func unixSeconds(secs float64) time.Time {
+	s, frac := math.Modf(secs)
+	return time.Unix(int64(s), int64(math.Round(frac*1e9)))
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImport(t *testing.T) {
+	cases := []struct {
+		format string
+		data   string
+	}{
+		{"prometheus", `{"status":"success","data":{"resultType":"matrix","result":[
+			{"metric":{"__name__":"sensors_omini_voltage","channel":"a","instance":"boat:9091","job":"boatpi"},
+			 "values":[[1590969660,"12.6"],[1590969600.5,"12.5"]]},
+			{"metric":{"__name__":"go_goroutines"},"values":[[1590969600,"12"]]}]}}`},
+		{"influx", `# DML
+sensors_omini_voltage,channel=a,host=boat gauge=12.6 1590969660000000000
+sensors_omini_voltage,channel=a,host=boat gauge=12.5 1590969600500000000
+go_goroutines gauge=12i 1590969600000000000`},
+		{"jsonl", `{"series":"sensors_omini_voltage{channel=\"a\"}","time":1590969660,"value":12.6}
+{"name":"sensors_omini_voltage","labels":{"channel":"a"},"time":"2020-06-01T00:00:00.5Z","value":12.5}
+{"series":"go_goroutines","time":1590969600,"value":12}`},
+	}
+
+	t0 := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range cases {
+		t.Run(tc.format, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "store")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			st, err := OpenSegments(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+
+			n, err := Import(st, tc.format, strings.NewReader(tc.data), "sensors_", []string{"instance", "job", "host"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 2 {
+				t.Errorf("imported %d samples, expected 2", n)
+			}
+
+			series, err := st.Series()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(series, []string{`sensors_omini_voltage{channel="a"}`}) {
+				t.Errorf("unexpected series %q", series)
+			}
+			res, err := st.Query(series[0], t0, t0.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp := []Sample{{t0.Add(500 * time.Millisecond), 12.5}, {t0.Add(time.Minute), 12.6}}
+			if len(res) != 2 || !res[0].Time.Equal(exp[0].Time) || res[0].Value != exp[0].Value ||
+				!res[1].Time.Equal(exp[1].Time) || res[1].Value != exp[1].Value {
+				t.Errorf("unexpected samples %v", res)
+			}
+		})
+	}
+}
+
+func TestParseSeriesName(t *testing.T) {
+	name, labels, err := parseSeriesName(`a_b{x="1",y="q\"z"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "a_b" || !reflect.DeepEqual(labels, map[string]string{"x": "1", "y": `q"z`}) {
+		t.Errorf("got %s %v", name, labels)
+	}
+	if SeriesName(name, labels) != `a_b{x="1",y="q\"z"}` {
+		t.Errorf("no round trip: %s", SeriesName(name, labels))
+	}
+}
@@ -0,0 +1,397 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The segment store appends samples to one file per UTC day and never
+// rewrites anything, which suits SD cards. Writes are buffered and flushed
+// at most once per flush interval. Queries scan the segments of the days in
+// the range.
+//
+// A record is the series name length (uint16) and name, the time in
+// nanoseconds (int64) and the value (float64 bits), big endian.
+//
+// Once a day is over its segment is compacted: each series is compressed
+// (see gorilla.go) and written to a new file, which replaces the raw
+// segment. This takes months of data from gigabytes to a few hundred
+// megabytes. A compacted file is the magic "BPC1" followed by, for each
+// series, the name length (uint16) and name, the number of samples and the
+// length of the compressed data (both uint32) and the data.
+
+const (
+	segmentSuffix        = ".seg"
+	compactedSuffix      = ".cseg"
+	compactedMagic       = "BPC1"
+	segmentDateFormat    = "20060102"
+	segmentFlushInterval = time.Minute
+	segmentMaxName       = math.MaxUint16
+)
+
+type SegmentStore struct {
+	dir string
+
+	mut     sync.Mutex
+	day     string // of the open segment
+	fd      *os.File
+	buf     *bufio.Writer
+	flushed time.Time
+}
+
+// OpenSegments opens or creates a segment store in the directory.
+func OpenSegments(dir string) (*SegmentStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &SegmentStore{dir: dir, flushed: time.Now()}
+	s.compactBefore(time.Now().UTC().Format(segmentDateFormat))
+	return s, nil
+}
+
+func (s *SegmentStore) Append(series string, t time.Time, v float64) error {
+	if len(series) > segmentMaxName {
+		return errors.New("series name too long")
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	day := t.UTC().Format(segmentDateFormat)
+	if day != s.day {
+		if err := s.openSegment(day); err != nil {
+			return err
+		}
+	}
+
+	var hdr [2]byte
+	binary.BigEndian.PutUint16(hdr[:], uint16(len(series)))
+	var val [16]byte
+	binary.BigEndian.PutUint64(val[:], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(val[8:], math.Float64bits(v))
+	s.buf.Write(hdr[:])
+	s.buf.WriteString(series)
+	s.buf.Write(val[:])
+
+	if time.Since(s.flushed) >= segmentFlushInterval {
+		return s.flush()
+	}
+	return nil
+}
+
+// openSegment closes the current segment, if any, and opens the one for
+// the day for appending. A partial record at the end, from a crash or
+// power loss, is cut off.
+func (s *SegmentStore) openSegment(day string) error {
+	prev := s.day
+	if err := s.closeSegment(); err != nil {
+		return err
+	}
+	if prev != "" && prev < day {
+		s.compactBefore(day)
+	}
+	path := filepath.Join(s.dir, day+segmentSuffix)
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := readSegment(fd, func(string, int64, float64) {})
+	if err != nil {
+		fd.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := fd.Truncate(n); err != nil {
+		fd.Close()
+		return err
+	}
+	if _, err := fd.Seek(n, io.SeekStart); err != nil {
+		fd.Close()
+		return err
+	}
+	s.day = day
+	s.fd = fd
+	s.buf = bufio.NewWriter(fd)
+	return nil
+}
+
+func (s *SegmentStore) closeSegment() error {
+	if s.fd == nil {
+		return nil
+	}
+	err := s.flush()
+	if cerr := s.fd.Close(); err == nil {
+		err = cerr
+	}
+	s.fd, s.buf, s.day = nil, nil, ""
+	return err
+}
+
+func (s *SegmentStore) flush() error {
+	s.flushed = time.Now()
+	if s.buf == nil {
+		return nil
+	}
+	return s.buf.Flush()
+}
+
+func (s *SegmentStore) Query(series string, from, to time.Time) ([]Sample, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	days, err := s.days()
+	if err != nil {
+		return nil, err
+	}
+	first := from.UTC().Format(segmentDateFormat)
+	last := to.UTC().Format(segmentDateFormat)
+	fromNs, toNs := from.UnixNano(), to.UnixNano()
+	var res []Sample
+	for _, day := range days {
+		if day < first || day > last {
+			continue
+		}
+		err := s.readDay(day, func(name string, t int64, v float64) {
+			if name == series && t >= fromNs && t <= toNs {
+				res = append(res, Sample{time.Unix(0, t), v})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (s *SegmentStore) Series() ([]string, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	days, err := s.days()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, day := range days {
+		err := s.readDay(day, func(name string, _ int64, _ float64) {
+			seen[name] = true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *SegmentStore) Close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.closeSegment()
+}
+
+// days returns the days for which there are segments, in order.
+func (s *SegmentStore) days() ([]string, error) {
+	seen := make(map[string]bool)
+	var days []string
+	for _, suffix := range []string{segmentSuffix, compactedSuffix} {
+		files, err := filepath.Glob(filepath.Join(s.dir, "*"+suffix))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			day := filepath.Base(file)
+			day = day[:len(day)-len(suffix)]
+			if _, err := time.Parse(segmentDateFormat, day); err == nil && !seen[day] {
+				seen[day] = true
+				days = append(days, day)
+			}
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// readDay reads the compacted and raw segments for the day, whichever
+// exist. There can be both if samples were added for a day that was
+// already compacted.
+func (s *SegmentStore) readDay(day string, fn func(name string, t int64, v float64)) error {
+	fd, err := os.Open(filepath.Join(s.dir, day+compactedSuffix))
+	if err == nil {
+		err = readCompacted(fd, fn)
+		fd.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", day, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	fd, err = os.Open(filepath.Join(s.dir, day+segmentSuffix))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fd.Close()
+	_, err = readSegment(fd, fn)
+	return err
+}
+
+// compactBefore compacts the raw segments of the days before the given
+// one. Failures are logged; the raw segment is then kept as it is.
+func (s *SegmentStore) compactBefore(day string) {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*"+segmentSuffix))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		d := filepath.Base(file)
+		d = d[:len(d)-len(segmentSuffix)]
+		if d >= day || d == s.day {
+			continue
+		}
+		if err := s.compact(d); err != nil {
+			log.Printf("Compact store segment %s: %v", d, err)
+		}
+	}
+}
+
+// compact writes the samples of the day, raw and already compacted, to a
+// new compacted file and removes the raw segment.
+func (s *SegmentStore) compact(day string) error {
+	series := make(map[string][]Sample)
+	err := s.readDay(day, func(name string, t int64, v float64) {
+		series[name] = append(series[name], Sample{time.Unix(0, t), v})
+	})
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(series))
+	for name, samples := range series {
+		names = append(names, name)
+		sort.SliceStable(samples, func(a, b int) bool { return samples[a].Time.Before(samples[b].Time) })
+	}
+	sort.Strings(names)
+
+	path := filepath.Join(s.dir, day+compactedSuffix)
+	tmp := path + ".tmp"
+	fd, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fd)
+	w.WriteString(compactedMagic)
+	for _, name := range names {
+		data := compressSeries(series[name])
+		var hdr [10]byte
+		binary.BigEndian.PutUint16(hdr[:], uint16(len(name)))
+		binary.BigEndian.PutUint32(hdr[2:], uint32(len(series[name])))
+		binary.BigEndian.PutUint32(hdr[6:], uint32(len(data)))
+		w.Write(hdr[:2])
+		w.WriteString(name)
+		w.Write(hdr[2:])
+		w.Write(data)
+	}
+	if err := w.Flush(); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(s.dir, day+segmentSuffix))
+}
+
+func readCompacted(r io.Reader, fn func(name string, t int64, v float64)) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(compactedMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != compactedMagic {
+		return errCorrupt
+	}
+	var hdr [8]byte
+	for {
+		var nameLen [2]byte
+		if _, err := io.ReadFull(br, nameLen[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errCorrupt
+		}
+		name := make([]byte, binary.BigEndian.Uint16(nameLen[:]))
+		if _, err := io.ReadFull(br, name); err != nil {
+			return errCorrupt
+		}
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return errCorrupt
+		}
+		data := make([]byte, binary.BigEndian.Uint32(hdr[4:]))
+		if _, err := io.ReadFull(br, data); err != nil {
+			return errCorrupt
+		}
+		n := int(binary.BigEndian.Uint32(hdr[:4]))
+		err := decompressSeries(data, n, func(t int64, v float64) {
+			fn(string(name), t, v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readSegment calls fn for each complete record and returns the length of
+// the complete records.
+func readSegment(r io.Reader, fn func(name string, t int64, v float64)) (int64, error) {
+	br := bufio.NewReader(r)
+	var n int64
+	var hdr [2]byte
+	var val [16]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return n, ignoreEOF(err)
+		}
+		name := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		if _, err := io.ReadFull(br, name); err != nil {
+			return n, ignoreEOF(err)
+		}
+		if _, err := io.ReadFull(br, val[:]); err != nil {
+			return n, ignoreEOF(err)
+		}
+		fn(string(name), int64(binary.BigEndian.Uint64(val[:])), math.Float64frombits(binary.BigEndian.Uint64(val[8:])))
+		n += int64(len(hdr) + len(name) + len(val))
+	}
+}
+
+func ignoreEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+//go:build sqlite
+// +build sqlite
+
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The SQLite backend needs cgo, so it is only included when building with
+// the sqlite tag. It allows ad hoc SQL queries against the history, at the
+// cost of more write amplification than the segment store.
+
+func init() {
+	backends["sqlite"] = func(path string) (Store, error) { return OpenSQLite(path) }
+}
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens or creates an SQLite database at path.
+func OpenSQLite(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS samples (series TEXT NOT NULL, time INTEGER NOT NULL, value REAL NOT NULL)`,
+		`CREATE INDEX IF NOT EXISTS samples_series_time ON samples (series, time)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Append(series string, t time.Time, v float64) error {
+	_, err := s.db.Exec(`INSERT INTO samples (series, time, value) VALUES (?, ?, ?)`, series, t.UnixNano(), v)
+	return err
+}
+
+func (s *SQLiteStore) Query(series string, from, to time.Time) ([]Sample, error) {
+	rows, err := s.db.Query(`SELECT time, value FROM samples WHERE series = ? AND time >= ? AND time <= ? ORDER BY time`, series, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Sample
+	for rows.Next() {
+		var t int64
+		var v float64
+		if err := rows.Scan(&t, &v); err != nil {
+			return nil, err
+		}
+		res = append(res, Sample{time.Unix(0, t), v})
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLiteStore) Series() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT series FROM samples ORDER BY series`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		res = append(res, name)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,51 @@
+// Package store keeps a local history of metric samples, for when there
+// is no Prometheus server around to do it. There are several backends,
+// trading query power against how kind they are to an SD card.
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+type Store interface {
+	// Append adds a sample to the series. Samples for a series must be
+	// appended in time order.
+	Append(series string, t time.Time, v float64) error
+	// Query returns the samples of the series in the time range,
+	// inclusive, in time order.
+	Query(series string, from, to time.Time) ([]Sample, error)
+	// Series returns the names of all stored series, sorted.
+	Series() ([]string, error)
+	Close() error
+}
+
+var backends = map[string]func(path string) (Store, error){
+	"segment": func(path string) (Store, error) { return OpenSegments(path) },
+}
+
+// Backends returns the names of the available backends.
+func Backends() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open opens the store at path with the named backend.
+func Open(backend, path string) (Store, error) {
+	open, ok := backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown store backend %q (available: %s)", backend, strings.Join(Backends(), ", "))
+	}
+	return open(path)
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackends(t *testing.T) {
+	for _, backend := range Backends() {
+		t.Run(backend, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "store")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			s, err := Open(backend, filepath.Join(dir, "store"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			// Across a day boundary, for the segment store.
+			t0 := time.Date(2020, 6, 1, 23, 59, 0, 0, time.UTC)
+			for i := 0; i < 4; i++ {
+				ts := t0.Add(time.Duration(i) * 30 * time.Second)
+				if err := s.Append("a", ts, float64(i)); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Append(`b{x="y"}`, ts, float64(-i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res, err := s.Query("a", t0.Add(30*time.Second), t0.Add(time.Minute+30*time.Second))
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp := []Sample{
+				{t0.Add(30 * time.Second), 1},
+				{t0.Add(60 * time.Second), 2},
+				{t0.Add(90 * time.Second), 3},
+			}
+			if len(res) != len(exp) {
+				t.Fatalf("unexpected result %v", res)
+			}
+			for i := range exp {
+				if !res[i].Time.Equal(exp[i].Time) || res[i].Value != exp[i].Value {
+					t.Errorf("sample %d: got %v, expected %v", i, res[i], exp[i])
+				}
+			}
+
+			series, err := s.Series()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(series, []string{"a", `b{x="y"}`}) {
+				t.Errorf("unexpected series %q", series)
+			}
+		})
+	}
+}
+
+func TestSegmentPartialRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t0 := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	s, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Append("a", t0, 1)
+	s.Close()
+
+	// Simulate a write cut short by a power loss.
+	path := filepath.Join(dir, "20200601.seg")
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Write([]byte{0, 1, 'a', 0, 0})
+	fd.Close()
+
+	s, err = OpenSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Append("a", t0.Add(time.Second), 2)
+	res, err := s.Query("a", t0, t0.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Value != 1 || res[1].Value != 2 {
+		t.Errorf("unexpected result %v", res)
+	}
+}
+
+func TestSegmentCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t0 := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	s, err := OpenSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1440; i++ {
+		s.Append("a", t0.Add(time.Duration(i)*time.Minute), float64(i/60))
+	}
+	raw, err := os.Stat(filepath.Join(dir, "20200601.seg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rolling over to the next day compacts the previous one.
+	s.Append("a", t0.Add(24*time.Hour), 24)
+	defer s.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, "20200601.seg")); !os.IsNotExist(err) {
+		t.Error("raw segment should be gone, got", err)
+	}
+	comp, err := os.Stat(filepath.Join(dir, "20200601.cseg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comp.Size() > raw.Size()/20 {
+		t.Errorf("compacted to %d bytes from %d", comp.Size(), raw.Size())
+	}
+
+	res, err := s.Query("a", t0, t0.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1441 {
+		t.Fatalf("got %d samples", len(res))
+	}
+	for i, r := range res {
+		if !r.Time.Equal(t0.Add(time.Duration(i)*time.Minute)) || r.Value != float64(i/60) {
+			t.Fatalf("sample %d: got %v", i, r)
+		}
+	}
+}
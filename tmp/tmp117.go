@@ -0,0 +1,140 @@
+// Package tmp reads the Texas Instruments TMP series of digital
+// temperature sensors.
+package tmp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/sensor"
+)
+
+// TI TMP117 temperature sensor, accurate to ±0.1 °C from -20 to 50 °C
+// without calibration, which makes it a good reference for the
+// temperatures of the other sensors. It converts continuously once a
+// second, averaging a number of conversions to reduce the noise; the
+// sensor rests between conversions, which keeps the self heating down.
+//
+// The configuration is not written to the EEPROM, so the power on
+// defaults are left as they were.
+
+type TMP117 struct {
+	bus      *i2c.Bus
+	address  int
+	revision int
+
+	mut         sync.Mutex
+	cached      time.Time
+	temperature float64 // °C
+}
+
+// TMP117DefaultAddress is the address with ADD0 to ground; to V+, SDA
+// and SCL it is 0x49, 0x4a and 0x4b.
+const TMP117DefaultAddress = 0x48
+
+const (
+	tmp117TempReg   = 0x00
+	tmp117ConfigReg = 0x01
+	tmp117IDReg     = 0x0f
+
+	tmp117ID       = 0x117 // the low 12 bits of the ID register
+	tmp117LSB      = 0.0078125
+	tmp117NoResult = -0x8000 // the temperature register until the first conversion
+
+	tmp117Conv1s = 0b100 << 7 // continuous conversion, once a second
+)
+
+// Averagings are the valid numbers of conversions averaged per
+// measurement.
+var Averagings = []int{1, 8, 32, 64}
+
+// ErrNoMeasurement is returned by Refresh until the first measurement is
+// ready.
+var ErrNoMeasurement = errors.New("no measurement yet")
+
+// NewTMP117 checks and configures the TMP117 at the address, averaging the
+// number of conversions.
+func NewTMP117(bus *i2c.Bus, addr, averaging int) (*TMP117, error) {
+	avg := -1
+	for i, a := range Averagings {
+		if a == averaging {
+			avg = i
+		}
+	}
+	if avg < 0 {
+		return nil, fmt.Errorf("invalid averaging %d (valid: %v)", averaging, Averagings)
+	}
+
+	s := &TMP117{bus: bus, address: addr}
+	err := bus.Do(addr, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		id := r.Block(tmp117IDReg, 2)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read device ID: %w", err)
+		}
+		if v := int(id[0])<<8 | int(id[1]); v&0xfff != tmp117ID {
+			return fmt.Errorf("unknown device ID 0x%04x", v)
+		}
+		s.revision = int(id[0] >> 4)
+
+		cfg := tmp117Conv1s | avg<<5
+		if err := r.WriteBlock(tmp117ConfigReg, []byte{byte(cfg >> 8), byte(cfg)}); err != nil {
+			return fmt.Errorf("write configuration register: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *TMP117) Refresh(age time.Duration) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if time.Since(s.cached) < age {
+		return nil
+	}
+
+	err := s.bus.Do(s.address, func(dev i2c.Device) error {
+		r := i2c.NewReader(dev)
+		data := r.Block(tmp117TempReg, 2)
+		if err := r.Error(); err != nil {
+			return fmt.Errorf("read temperature: %w", err)
+		}
+		raw := int(int16(uint16(data[0])<<8 | uint16(data[1])))
+		if raw == tmp117NoResult {
+			return ErrNoMeasurement
+		}
+		s.temperature = float64(raw) * tmp117LSB
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cached = time.Now()
+	return nil
+}
+
+// Temperature returns the temperature, in °C.
+func (s *TMP117) Temperature() float64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.temperature
+}
+
+func (s *TMP117) Info() sensor.Info {
+	return sensor.Info{Chip: "TMP117", Bus: "i2c", Address: fmt.Sprintf("0x%02x", s.address), ID: fmt.Sprintf("revision %d", s.revision)}
+}
+
+func (s *TMP117) Readings() []sensor.Reading {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return []sensor.Reading{
+		{Name: "temperature", Unit: "celsius", Quantity: sensor.Temperature, Precision: 2, Value: s.temperature},
+	}
+}
@@ -0,0 +1,51 @@
+package tmp
+
+import (
+	"testing"
+
+	"github.com/calmh/boatpi/i2c"
+	"github.com/calmh/boatpi/i2c/i2ctest"
+)
+
+func TestTMP117(t *testing.T) {
+	dev := i2ctest.Words{
+		tmp117IDReg:   0x1117,
+		tmp117TempReg: 0x8000,
+	}
+	if _, err := NewTMP117(i2c.NewBus(dev), TMP117DefaultAddress, 16); err == nil {
+		t.Error("expected error for invalid averaging")
+	}
+	s, err := NewTMP117(i2c.NewBus(dev), TMP117DefaultAddress, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := dev[tmp117ConfigReg]; cfg != 0x0240 {
+		t.Errorf("unexpected configuration 0x%04x", cfg)
+	}
+	if id := s.Info().ID; id != "revision 1" {
+		t.Errorf("unexpected ID %q", id)
+	}
+
+	if err := s.Refresh(0); err != ErrNoMeasurement {
+		t.Errorf("expected no measurement yet, got %v", err)
+	}
+	dev[tmp117TempReg] = 0x0a80 // 21 °C
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if temp := s.Temperature(); temp != 21 {
+		t.Errorf("got %v °C, expected 21", temp)
+	}
+	dev[tmp117TempReg] = 0xff80 // -1 °C
+	if err := s.Refresh(0); err != nil {
+		t.Fatal(err)
+	}
+	if temp := s.Temperature(); temp != -1 {
+		t.Errorf("got %v °C, expected -1", temp)
+	}
+
+	dev[tmp117IDReg] = 0x0190
+	if _, err := NewTMP117(i2c.NewBus(dev), TMP117DefaultAddress, 8); err == nil {
+		t.Error("expected error for another device")
+	}
+}